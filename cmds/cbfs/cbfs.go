@@ -6,17 +6,63 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/linuxboot/fiano/pkg/cbfs"
 	flag "github.com/spf13/pflag"
 )
 
-var debug = flag.BoolP("debug", "d", false, "enable debug prints")
+var (
+	debug  = flag.BoolP("debug", "d", false, "enable debug prints")
+	output = flag.StringP("output", "o", "", "write the modified image to this file instead of the firmware file")
+	region = flag.StringP("region", "r", cbfs.DefaultRegion, "FMAP area of the CBFS to use")
+	comp   = flag.StringP("compression", "c", "none", "compression of the added files: none, lzma or lz4")
+	align  = flag.Uint32P("alignment", "a", 0, "alignment of the data of the added file")
+	base   = flag.StringP("base", "b", "", "offset in the CBFS of the data of the added file, an x86 address of the flash or, if negative, an offset from the end of the CBFS")
+	asJSON = flag.Bool("json", false, "print the files of every region in JSON with the regions command")
+	raw    = flag.Bool("raw", false, "extract the files as stored, without decompressing them")
+)
+
+// baseOffset returns the offset in the CBFS given by the base flag.
+func baseOffset(i *cbfs.Image, b string) (uint32, error) {
+	if strings.HasPrefix(b, "-") {
+		n, err := strconv.ParseUint(b[1:], 0, 32)
+		if err != nil {
+			return 0, err
+		}
+		if n > uint64(i.Area.Size) {
+			return 0, fmt.Errorf("base %s is before the start of the CBFS", b)
+		}
+		return i.Area.Size - uint32(n), nil
+	}
+	n, err := strconv.ParseUint(b, 0, 32)
+	if err != nil {
+		return 0, err
+	}
+	if n < uint64(i.Area.Size) {
+		return uint32(n), nil
+	}
+	return i.TopAlignedOffset(uint32(n))
+}
+
+// save writes the modified image to the output file.
+func save(i *cbfs.Image, n string) {
+	if *output != "" {
+		n = *output
+	}
+	if err := i.Update(); err != nil {
+		log.Fatal(err)
+	}
+	if err := i.WriteFile(n, 0666); err != nil {
+		log.Fatal(err)
+	}
+}
 
 func main() {
 	flag.Parse()
@@ -27,10 +73,33 @@ func main() {
 
 	a := flag.Args()
 	if len(a) < 2 {
-		log.Fatal("Usage: cbfs <firmware-file> <json,list,extract <directory-name>>")
+		log.Fatal("Usage: cbfs [-r region] [-o output-file] [-c compression] [-a alignment] [-b base] [--raw] [--json] <firmware-file> <regions,json,list,print,check,verify,stages,extract <directory-name>,add <name> <type> <file>,remove <name>,replace <name> <file>,segments <payload-name> [<directory-name>],truncate,expand,resize <size>>")
+	}
+
+	if a[1] == "regions" {
+		f, err := os.Open(a[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		images, err := cbfs.NewRegionImages(f)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *asJSON {
+			j, err := json.MarshalIndent(images, "", "  ")
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("%s\n", j)
+			return
+		}
+		for _, i := range images {
+			fmt.Printf("%-16s %#-10x %#x\n", i.Area.Name.String(), i.Area.Offset, i.Area.Size)
+		}
+		return
 	}
 
-	i, err := cbfs.Open(a[0])
+	i, err := cbfs.OpenRegion(a[0], *region)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -38,6 +107,52 @@ func main() {
 	switch a[1] {
 	case "list":
 		fmt.Printf("%s", i.String())
+	case "print":
+		fmt.Printf("%s", i.CbfstoolString())
+	case "check":
+		if err := i.CheckMasterHeader(); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("OK")
+	case "verify":
+		for _, s := range i.Segs {
+			f := s.GetFile()
+			if f.Deleted() {
+				continue
+			}
+			h, err := f.HashAttribute()
+			if errors.Is(err, cbfs.ErrNoHash) {
+				continue
+			}
+			check := "OK"
+			if err == nil {
+				err = f.VerifyHash()
+			}
+			if err != nil {
+				check = err.Error()
+			}
+			alg := "?"
+			if h != nil {
+				alg = h.HashType.String()
+			}
+			fmt.Printf("%-32s %-8s %s\n", f.Name, alg, check)
+		}
+		if err := i.VerifyHashes(); err != nil {
+			log.Fatal(err)
+		}
+	case "stages":
+		fmt.Printf("%-32s %-18s %-18s %-10s %s\n", "Name", "Load", "Entry", "MemSize", "Check")
+		for _, s := range i.Segs {
+			st, ok := s.(cbfs.Stage)
+			if !ok {
+				continue
+			}
+			info, check := st.StageInfo(), "OK"
+			if err := st.CheckStage(); err != nil {
+				check = err.Error()
+			}
+			fmt.Printf("%-32s %#-18x %#-18x %#-10x %s\n", st.GetFile().Name, info.LoadAddress, info.Entry, info.MemSize, check)
+		}
 	case "json":
 		j, err := json.MarshalIndent(i, "  ", "  ")
 		if err != nil {
@@ -56,7 +171,8 @@ func main() {
 		base := i.Area.Offset
 		log.Printf("FMAP base at %x", base)
 		for s := range i.Segs {
-			f := i.Segs[s].GetFile()
+			seg := i.Segs[s]
+			f := seg.GetFile()
 			n := f.Name
 			c := f.Compression()
 			o := f.RecordStart
@@ -65,9 +181,13 @@ func main() {
 			} else {
 				log.Printf("Extracting %v from 0x%x, compression: %v", n, o, c)
 				fpath := filepath.Join(dir, strings.Replace(n, "/", "_", -1))
-				d, err := f.Decompress()
-				if err != nil {
-					log.Fatal(err)
+				// The raw bytes can be added back as they are, with the
+				// same compression.
+				d := f.FData
+				if !*raw {
+					if d, err = seg.Decompress(); err != nil {
+						log.Fatal(err)
+					}
 				}
 				err = os.WriteFile(fpath, d, 0644)
 				if err != nil {
@@ -75,6 +195,123 @@ func main() {
 				}
 			}
 		}
+	case "add":
+		if len(a) != 5 {
+			log.Fatal("Usage: add <name> <type> <file>")
+		}
+		t, err := cbfs.ParseFileType(a[3])
+		if err != nil {
+			log.Fatal(err)
+		}
+		c, err := cbfs.ParseCompression(*comp)
+		if err != nil {
+			log.Fatal(err)
+		}
+		d, err := os.ReadFile(a[4])
+		if err != nil {
+			log.Fatal(err)
+		}
+		r, err := cbfs.NewCompressedRecord(a[2], t, c, d)
+		if err != nil {
+			log.Fatal(err)
+		}
+		switch {
+		case *base != "":
+			off, err := baseOffset(i, *base)
+			if err != nil {
+				log.Fatal(err)
+			}
+			err = i.AddAt(r, off)
+		case *align != 0:
+			err = i.AddAligned(r, *align)
+		default:
+			err = i.Add(r)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		save(i, a[0])
+	case "remove":
+		if len(a) != 3 {
+			log.Fatal("Usage: remove <name>")
+		}
+		if err := i.Remove(a[2]); err != nil {
+			log.Fatal(err)
+		}
+		save(i, a[0])
+	case "replace":
+		if len(a) != 4 {
+			log.Fatal("Usage: replace <name> <file>")
+		}
+		d, err := os.ReadFile(a[3])
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := i.Replace(a[2], d); err != nil {
+			log.Fatal(err)
+		}
+		save(i, a[0])
+	case "truncate":
+		size, err := i.Truncate()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%#x\n", size)
+		save(i, a[0])
+	case "expand":
+		if err := i.Expand(); err != nil {
+			log.Fatal(err)
+		}
+		save(i, a[0])
+	case "resize":
+		if len(a) != 3 {
+			log.Fatal("Usage: resize <size>")
+		}
+		size, err := strconv.ParseUint(a[2], 0, 32)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := i.Resize(uint32(size)); err != nil {
+			log.Fatal(err)
+		}
+		save(i, a[0])
+	case "segments":
+		if len(a) != 3 && len(a) != 4 {
+			log.Fatal("Usage: segments <payload-name> [<directory-name>]")
+		}
+		s, err := i.Lookup(a[2])
+		if err != nil {
+			log.Fatal(err)
+		}
+		p, ok := s.(*cbfs.PayloadRecord)
+		if !ok {
+			log.Fatalf("%s is a %v, not a payload", a[2], s.GetFile().Type)
+		}
+		fmt.Printf("%-4s %-8s %-10s %-18s %-10s %-10s %s\n", "#", "Type", "Offset", "Load", "Size", "MemSize", "Comp")
+		for n, seg := range p.Segs {
+			fmt.Printf("%-4d %-8s %#-10x %#-18x %#-10x %#-10x %s\n", n, seg.Type, seg.Offset, seg.LoadAddress, seg.Size, seg.MemSize, seg.Compression)
+		}
+		if len(a) == 3 {
+			break
+		}
+		dir := filepath.Join(".", a[3])
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			log.Fatal(err)
+		}
+		for n, seg := range p.Segs {
+			if seg.Type == cbfs.SegBSS || seg.Type == cbfs.SegEntry {
+				continue
+			}
+			d, err := p.Segment(n)
+			if err != nil {
+				log.Fatal(err)
+			}
+			fpath := filepath.Join(dir, fmt.Sprintf("%d.%s", n, seg.Type))
+			log.Printf("Extracting segment #%d to %s", n, fpath)
+			if err := os.WriteFile(fpath, d, 0644); err != nil {
+				log.Fatal(err)
+			}
+		}
 	default:
 		log.Fatal("?")
 	}
@@ -5,7 +5,6 @@
 package main
 
 import (
-	"crypto/sha1"
 	"errors"
 	"flag"
 	"fmt"
@@ -25,7 +24,7 @@ var (
 	filetype   = flag.String("type", "DRIVER", "UEFI filetype")
 	version    = flag.String("version", "1.0", "File version")
 	guidString = flag.String("guid", "", "File GUID")
-	depex      = flag.String("depex", "", "Space or comma separated protocol guid dependencies or TRUE")
+	depex      = flag.String("depex", "", "Dependency expression of GUIDs, TRUE, FALSE, AND, OR, NOT and parentheses; listed GUIDs are ANDed")
 
 	printf = func(string, ...interface{}) {}
 )
@@ -34,41 +33,125 @@ const (
 	usageString = "Usage: create-ffs [flags] file.efi"
 )
 
-func createDepExes(deps string) ([]uefi.DepExOp, error) {
-	var err error
-	ops := []uefi.DepExOp{}
+// depExParser parses a dependency expression into the postfix operations of
+// a DEPEX section. The grammar is:
+//
+//	expr   = term { "OR" term }
+//	term   = factor { ["AND"] factor }
+//	factor = "NOT" factor | "(" expr ")" | "TRUE" | "FALSE" | GUID
+//
+// so GUIDs which are simply listed must all be installed.
+type depExParser struct {
+	tokens []string
+	ops    []uefi.DepExOp
+}
 
-	if strings.ToUpper(deps) == "TRUE" {
-		// Create just "TRUE" and "END" for now, but this feels unnecessary to me.
-		ops = append(ops, uefi.DepExOp{OpCode: "TRUE"})
-		ops = append(ops, uefi.DepExOp{OpCode: "END"})
-		return ops, nil
+// peek returns the next token in upper case, or "" at the end.
+func (p *depExParser) peek() string {
+	if len(p.tokens) == 0 {
+		return ""
 	}
+	return strings.ToUpper(p.tokens[0])
+}
 
-	// we expect a space or comma separated list of GUIDs, split by both.
-	guidSpace := strings.Split(deps, " ")
-	guids := []string{}
-	for _, g := range guidSpace {
-		guids = append(guids, strings.Split(g, ",")...)
+func (p *depExParser) expr() error {
+	if err := p.term(); err != nil {
+		return err
 	}
+	for p.peek() == "OR" {
+		p.tokens = p.tokens[1:]
+		if err := p.term(); err != nil {
+			return err
+		}
+		p.ops = append(p.ops, uefi.DepExOp{OpCode: "OR"})
+	}
+	return nil
+}
 
-	for _, guidStr := range guids {
-		var g *guid.GUID
+func (p *depExParser) term() error {
+	if err := p.factor(); err != nil {
+		return err
+	}
+	for {
+		switch p.peek() {
+		case "", "OR", ")":
+			return nil
+		case "AND":
+			p.tokens = p.tokens[1:]
+		}
+		if err := p.factor(); err != nil {
+			return err
+		}
+		p.ops = append(p.ops, uefi.DepExOp{OpCode: "AND"})
+	}
+}
 
-		if g, err = guid.Parse(guidStr); err != nil {
-			return nil, err
+func (p *depExParser) factor() error {
+	tok := p.peek()
+	if tok == "" {
+		return errors.New("unexpected end of dependency expression")
+	}
+	raw := p.tokens[0]
+	p.tokens = p.tokens[1:]
+	switch tok {
+	case "NOT":
+		if err := p.factor(); err != nil {
+			return err
+		}
+		p.ops = append(p.ops, uefi.DepExOp{OpCode: "NOT"})
+	case "(":
+		if err := p.expr(); err != nil {
+			return err
+		}
+		if p.peek() != ")" {
+			return errors.New("missing ) in dependency expression")
+		}
+		p.tokens = p.tokens[1:]
+	case "TRUE", "FALSE":
+		p.ops = append(p.ops, uefi.DepExOp{OpCode: uefi.DepExOpCode(tok)})
+	case "AND", "OR", ")":
+		return fmt.Errorf("unexpected %v in dependency expression", tok)
+	default:
+		g, err := guid.Parse(raw)
+		if err != nil {
+			return err
 		}
 		printf("depex guid requested: %v", *g)
-		ops = append(ops, uefi.DepExOp{OpCode: "PUSH", GUID: g})
+		p.ops = append(p.ops, uefi.DepExOp{OpCode: "PUSH", GUID: g})
 	}
+	return nil
+}
 
-	// Append an "AND" for n-1 pushes.
-	for i := 1; i < len(guids); i++ {
-		ops = append(ops, uefi.DepExOp{OpCode: "AND"})
+// createDepExes returns the operations of the dependency expression deps.
+// Tokens are separated by spaces or commas, parentheses need not be.
+func createDepExes(deps string) ([]uefi.DepExOp, error) {
+	deps = strings.NewReplacer("(", " ( ", ")", " ) ", ",", " ").Replace(deps)
+	p := &depExParser{tokens: strings.Fields(deps)}
+	if err := p.expr(); err != nil {
+		return nil, err
+	}
+	if len(p.tokens) != 0 {
+		return nil, fmt.Errorf("unexpected %v in dependency expression", p.tokens[0])
 	}
+	return append(p.ops, uefi.DepExOp{OpCode: "END"}), nil
+}
 
-	ops = append(ops, uefi.DepExOp{OpCode: "END"})
-	return ops, nil
+// depExSectionTypes returns the types of the dependency sections of the file
+// type. Combined files have one for each phase, holding the same expression.
+func depExSectionTypes(fType uefi.FVFileType) []uefi.SectionType {
+	switch fType {
+	case uefi.FVFileTypePEIM:
+		return []uefi.SectionType{uefi.SectionTypePEIDepEx}
+	case uefi.FVFileTypeDriver:
+		return []uefi.SectionType{uefi.SectionTypeDXEDepEx}
+	case uefi.FVFileTypeCombinedPEIMDriver:
+		return []uefi.SectionType{uefi.SectionTypePEIDepEx, uefi.SectionTypeDXEDepEx}
+	case uefi.FVFileTypeSMM, uefi.FVFileTypeSMMStandalone:
+		return []uefi.SectionType{uefi.SectionMMDepEx}
+	case uefi.FVFileTypeCombinedSMMDXE:
+		return []uefi.SectionType{uefi.SectionTypeDXEDepEx, uefi.SectionMMDepEx}
+	}
+	return nil
 }
 
 func parseFlags() (fType uefi.FVFileType, fGUID *guid.GUID, depOps []uefi.DepExOp, err error) {
@@ -96,10 +179,9 @@ func parseFlags() (fType uefi.FVFileType, fGUID *guid.GUID, depOps []uefi.DepExO
 			return
 		}
 	} else if *name != "" {
-		// We sha1 the name to get a reproducible GUID.
-		fGUID = &guid.GUID{}
-		sum := sha1.Sum([]byte(*name))
-		copy(fGUID[:], sum[:guid.Size])
+		// The name-based GUID of the name is reproducible.
+		g := guid.NewV5(guid.NamespaceFiano, []byte(*name))
+		fGUID = &g
 	} else {
 		err = errors.New("no GUID or name provided, please provide at least one")
 		return
@@ -111,6 +193,10 @@ func parseFlags() (fType uefi.FVFileType, fGUID *guid.GUID, depOps []uefi.DepExO
 	}
 
 	if *depex != "" {
+		if depExSectionTypes(fType) == nil {
+			err = fmt.Errorf("file type %v takes no dependency expression", fType)
+			return
+		}
 		depOps, err = createDepExes(*depex)
 		if err != nil {
 			err = fmt.Errorf("can't parse depex guids, got %v", err)
@@ -154,7 +240,8 @@ func main() {
 	secType := uefi.SectionTypeRaw
 	switch fType {
 	case uefi.FVFileTypeApplication, uefi.FVFileTypeCombinedSMMDXE,
-		uefi.FVFileTypeCombinedPEIMDriver, uefi.FVFileTypeDriver:
+		uefi.FVFileTypeCombinedPEIMDriver, uefi.FVFileTypeDriver,
+		uefi.FVFileTypePEIM, uefi.FVFileTypeSMM, uefi.FVFileTypeSMMStandalone:
 		secType = uefi.SectionTypePE32
 	}
 
@@ -164,6 +251,16 @@ func main() {
 	file.Header.State = 0xF8
 	file.Header.Attributes = 0x40
 
+	// The dependency sections come first, as in files built by EDK2.
+	if *depex != "" {
+		for _, t := range depExSectionTypes(fType) {
+			s := &uefi.Section{}
+			s.SetType(t)
+			s.DepEx = depOps
+			file.Sections = append(file.Sections, s)
+		}
+	}
+
 	mainSection := &uefi.Section{}
 	mainSection.SetType(secType)
 	mainSection.SetBuf(secData)
@@ -187,13 +284,6 @@ func main() {
 		file.Sections = append(file.Sections, s)
 	}
 
-	if *depex != "" {
-		s := &uefi.Section{}
-		s.SetType(uefi.SectionTypeDXEDepEx)
-		s.DepEx = depOps
-		file.Sections = append(file.Sections, s)
-	}
-
 	save := &visitors.Save{DirPath: *outfile}
 
 	err = file.Apply(save)
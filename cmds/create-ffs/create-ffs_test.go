@@ -6,6 +6,7 @@ package main
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/linuxboot/fiano/pkg/guid"
@@ -78,6 +79,26 @@ func TestCreateDepExes(t *testing.T) {
 				{OpCode: "PUSH", GUID: guid.MustParse("01234567-89AB-CDEF-0123-456789ABCDEF")},
 				{OpCode: "AND"},
 				{OpCode: "END"}}, ""},
+		{"orNot", "(01234567-89AB-CDEF-0123-456789ABCDEF or not 11111111-2222-3333-4444-555555555555),TRUE",
+			[]uefi.DepExOp{
+				{OpCode: "PUSH", GUID: guid.MustParse("01234567-89AB-CDEF-0123-456789ABCDEF")},
+				{OpCode: "PUSH", GUID: guid.MustParse("11111111-2222-3333-4444-555555555555")},
+				{OpCode: "NOT"},
+				{OpCode: "OR"},
+				{OpCode: "TRUE"},
+				{OpCode: "AND"},
+				{OpCode: "END"}}, ""},
+		{"precedence", "FALSE OR TRUE AND 01234567-89AB-CDEF-0123-456789ABCDEF",
+			[]uefi.DepExOp{
+				{OpCode: "FALSE"},
+				{OpCode: "TRUE"},
+				{OpCode: "PUSH", GUID: guid.MustParse("01234567-89AB-CDEF-0123-456789ABCDEF")},
+				{OpCode: "AND"},
+				{OpCode: "OR"},
+				{OpCode: "END"}}, ""},
+		{"unbalanced", "(TRUE", nil, "missing ) in dependency expression"},
+		{"trailing", "TRUE)", nil, "unexpected ) in dependency expression"},
+		{"dangling", "TRUE AND", nil, "unexpected end of dependency expression"},
 		{"badGUID", "ABC", nil, "guid string not correct, need string of the format \n01234567-89AB-CDEF-0123-456789ABCDEF" +
 			"\n, got \nABC"},
 	}
@@ -93,3 +114,19 @@ func TestCreateDepExes(t *testing.T) {
 		})
 	}
 }
+
+func TestDepExSectionTypes(t *testing.T) {
+	for _, test := range []struct {
+		fType uefi.FVFileType
+		want  []uefi.SectionType
+	}{
+		{uefi.FVFileTypePEIM, []uefi.SectionType{uefi.SectionTypePEIDepEx}},
+		{uefi.FVFileTypeDriver, []uefi.SectionType{uefi.SectionTypeDXEDepEx}},
+		{uefi.FVFileTypeCombinedPEIMDriver, []uefi.SectionType{uefi.SectionTypePEIDepEx, uefi.SectionTypeDXEDepEx}},
+		{uefi.FVFileTypeApplication, nil},
+	} {
+		if got := depExSectionTypes(test.fType); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("depExSectionTypes(%v) = %v, want %v", test.fType, got, test.want)
+		}
+	}
+}
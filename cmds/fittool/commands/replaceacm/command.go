@@ -0,0 +1,136 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package replaceacm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/linuxboot/fiano/cmds/fittool/commands"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/fit"
+)
+
+var _ commands.Command = (*Command)(nil)
+
+type Command struct {
+	UEFIPath     string  `description:"path to UEFI image" required:"true" short:"f" long:"uefi"`
+	ACMPath      string  `description:"path to the new startup ACM" required:"true" short:"a" long:"acm"`
+	EntryNumber  *uint   `description:"FIT entry number of the startup ACM, the first one by default" short:"n" long:"entry-number"`
+	ReservedSize *uint64 `description:"size of the space reserved for the ACM, the size of the current ACM by default" long:"reserved-size"`
+	ZeroPad      bool    `description:"fill the reserved space after the new ACM with zeros" long:"zero-pad"`
+}
+
+// ShortDescription explains what this command does in one line
+func (cmd *Command) ShortDescription() string {
+	return "replace the startup ACM in place"
+}
+
+// LongDescription explains what this verb does (without limitation in amount of lines)
+func (cmd *Command) LongDescription() string {
+	return "The new ACM is written at the address of the current one, so it must fit in the reserved space. " +
+		"The headers of the FIT entry of type 0x02 are recalculated."
+}
+
+// Execute is the main function here. It is responsible to
+// start the execution of the command.
+//
+// `args` are the arguments left unused by verb itself and options.
+func (cmd *Command) Execute(args []string) error {
+	if len(args) != 0 {
+		return commands.ErrArgs{Err: fmt.Errorf("there are extra arguments")}
+	}
+
+	acm, err := os.ReadFile(cmd.ACMPath)
+	if err != nil {
+		return fmt.Errorf("unable to read the ACM file '%s': %w", cmd.ACMPath, err)
+	}
+	if _, err := fit.ParseSACMData(bytes.NewReader(acm)); err != nil {
+		return fmt.Errorf("invalid ACM '%s': %w", cmd.ACMPath, err)
+	}
+	acmSize, err := fit.EntrySACMParseSize(acm)
+	if err != nil {
+		return fmt.Errorf("unable to get the size of the ACM '%s': %w", cmd.ACMPath, err)
+	}
+	if uint64(acmSize) > uint64(len(acm)) {
+		return fmt.Errorf("the ACM '%s' is truncated: its header says %d bytes, the file has %d", cmd.ACMPath, acmSize, len(acm))
+	}
+
+	file, err := os.OpenFile(cmd.UEFIPath, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("unable to open the firmware image file '%s': %w", cmd.UEFIPath, err)
+	}
+	defer file.Close()
+
+	table, err := fit.GetTableFrom(file)
+	if err != nil {
+		return fmt.Errorf("unable to get FIT from the firmware image: %w", err)
+	}
+
+	var entryHeaders *fit.EntryHeaders
+	if cmd.EntryNumber != nil {
+		if len(table) <= int(*cmd.EntryNumber) {
+			return fmt.Errorf("there are only %d entries in the FIT (no entry # %d)", len(table), *cmd.EntryNumber)
+		}
+		entryHeaders = &table[*cmd.EntryNumber]
+		if entryHeaders.Type() != fit.EntryTypeStartupACModuleEntry {
+			return fmt.Errorf("entry # %d is of type %v, not a startup ACM", *cmd.EntryNumber, entryHeaders.Type())
+		}
+	} else {
+		for idx := range table {
+			if table[idx].Type() == fit.EntryTypeStartupACModuleEntry {
+				entryHeaders = &table[idx]
+				break
+			}
+		}
+		if entryHeaders == nil {
+			return fmt.Errorf("there is no startup ACM entry in the FIT")
+		}
+	}
+
+	fileSize, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("unable to determine the file size: %w", err)
+	}
+	offset := entryHeaders.Address.Offset(uint64(fileSize))
+
+	reservedSize := uint64(0)
+	if cmd.ReservedSize != nil {
+		reservedSize = *cmd.ReservedSize
+	} else {
+		size, err := fit.EntrySACMParseSizeFrom(file, offset)
+		if err != nil {
+			return fmt.Errorf("unable to get the size of the current ACM: %w", err)
+		}
+		reservedSize = uint64(size)
+	}
+	if offset+reservedSize > uint64(fileSize) {
+		return fmt.Errorf("the reserved space [%#x, %#x) is out of the image of %#x bytes", offset, offset+reservedSize, fileSize)
+	}
+	if uint64(len(acm)) > reservedSize {
+		return fmt.Errorf("the new ACM of %#x bytes does not fit in the reserved space of %#x bytes", len(acm), reservedSize)
+	}
+
+	data := acm
+	if cmd.ZeroPad {
+		data = make([]byte, reservedSize)
+		copy(data, acm)
+	}
+	if _, err := file.WriteAt(data, int64(offset)); err != nil {
+		return fmt.Errorf("unable to write the ACM into the firmware: %w", err)
+	}
+
+	// See 4.4.7 of the FIT specification: the size of a startup ACM entry
+	// is zero, the size is in the ACM header.
+	entryHeaders.Size.SetUint32(0)
+	if entryHeaders.IsChecksumValid() {
+		entryHeaders.Checksum = entryHeaders.CalculateChecksum()
+	}
+	if _, err := table.WriteToFirmwareImage(file); err != nil {
+		return fmt.Errorf("unable to write FIT into a firmware: %w", err)
+	}
+	return nil
+}
@@ -13,6 +13,7 @@
 //     fittool add_raw_headers -f UEFI_FILE [options]
 //     fittool set_raw_headers -f UEFI_FILE -n ENTRY_ID [options]
 //     fittool remove_headers -f UEFI_FILE -n ENTRY_ID [options]
+//     fittool replace_acm -f UEFI_FILE -a ACM_FILE [options]
 //     fittool show -f UEFI_FILE [options]
 //
 // An example:
@@ -20,6 +21,7 @@
 //     fittool add_raw_headers -f firmware.fd --type 2 --address $((16#100000)) --size $((16#20000))
 //     fittool set_raw_headers -f firmware.fd -n 1 --type $((16#7F))
 //     fittool remove_headers -f firmware.fd -n 1
+//     fittool replace_acm -f firmware.fd -a startup_acm.bin --zero-pad
 //     fittool show -f firmware.fd --format=json --include-data | jq -r '.[] | select(.Headers.Type == 2) | .DataParsed.EntrySACMDataInterface.TXTSVN'
 //
 // Description:
@@ -27,6 +29,7 @@
 //     add_raw_headers: Add raw headers to FIT
 //     set_raw_headers: Overwrite the row # ENTRY_ID with specified RAW headers
 //     remove_headers:  Remove headers from row entry # ENTRY_ID
+//     replace_acm:     Replace the startup ACM in place
 //     show:            Print FIT
 //
 // For more advanced key manifest and boot policy manifest management see also Converged Security Suite:
@@ -45,6 +48,7 @@ import (
 	"github.com/linuxboot/fiano/cmds/fittool/commands/addrawheaders"
 	_init "github.com/linuxboot/fiano/cmds/fittool/commands/init"
 	"github.com/linuxboot/fiano/cmds/fittool/commands/removeheaders"
+	"github.com/linuxboot/fiano/cmds/fittool/commands/replaceacm"
 	"github.com/linuxboot/fiano/cmds/fittool/commands/setrawheaders"
 	"github.com/linuxboot/fiano/cmds/fittool/commands/show"
 )
@@ -56,6 +60,7 @@ var (
 		"add_raw_headers": &addrawheaders.Command{},
 		"set_raw_headers": &setrawheaders.Command{},
 		"remove_headers":  &removeheaders.Command{},
+		"replace_acm":     &replaceacm.Command{},
 	}
 )
 
@@ -6,24 +6,48 @@
 //
 // Synopsis:
 //
-//	fmap checksum [md5|sha1|sha256] FILE
+//	fmap candidates FILE
+//	fmap [--areas NAME,...|--with-flags FLAGS] checksum [md5|sha1|sha256] FILE
+//	fmap create LAYOUT FILE
 //	fmap extract [index|name] FILE
+//	fmap flags [index|name] [+|-]FLAGS FILE
+//	fmap [--areas NAME,...|--with-flags FLAGS] flashrom-layout FILE
+//	fmap fmd FILE
 //	fmap jget JSONFILE FILE
 //	fmap jput JSONFILE FILE
-//	fmap summary FILE
+//	fmap replace [index|name] DATAFILE FILE
+//	fmap [--json] summary FILE
+//	fmap tree FILE
 //	fmap usage FILE
 //	fmap verify FILE
 //
 // Description:
 //
-//	checksum: Print a checksum using the given hash function.
+//	candidates: Print every fmap signature with the score of its fmap.
+//	checksum: Print a checksum using the given hash function of the static
+//	          areas, of the areas named with --areas, in order, or of the areas
+//	          with all the flags given with --with-flags, e.g. READ_ONLY.
+//	create:   Create an erased FILE holding a new fmap described by LAYOUT.
+//	          See fmap.ParseLayout for the format of LAYOUT.
 //	extract:  Print the i-th area or area name from the flash.
+//	flags:    Set the flags of the i-th area or area name to FLAGS, e.g.
+//	          STATIC|READ_ONLY, or with a + or - prefix set or clear them.
+//	flashrom-layout: Print a layout file for "flashrom -l" of all the areas,
+//	          of the areas named with --areas or of the areas with all the
+//	          flags given with --with-flags.
+//	fmd:      Print the fmap as a coreboot flashmap descriptor (.fmd).
 //	jget:     Write json representation of the fmap to JSONFILE.
 //	jput:     Replace current fmap with json representation in JSONFILE.
-//	summary:  Print a human readable summary.
+//	replace:  Replace the i-th area or area name with DATAFILE, padded with
+//	          0xff. DATAFILE must not be larger than the area.
+//	summary:  Print a human readable summary, or the json representation of
+//	          the fmap with --json.
+//	tree:     Print the areas as a tree of the areas containing each other.
 //	usage:    Print human readable usage stats.
 //	verify:   Return 1 if the flash map is invalid.
 //
+//	In a flash holding several fmaps, pick one with --offset OFFSET.
+//
 //	This implementation is based off of https://github.com/dhendrix/flashmap.
 package main
 
@@ -34,11 +58,13 @@ import (
 	"crypto/sha256"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"hash"
 	"io"
 	"os"
 	"strconv"
+	"strings"
 	"text/template"
 
 	"github.com/linuxboot/fiano/pkg/fmap"
@@ -50,18 +76,33 @@ var cmds = map[string]struct {
 	openFile, parseFMap bool
 	f                   func(a cmdArgs) error
 }{
-	"checksum": {1, true, true, checksum},
-	"extract":  {1, true, true, extract},
-	"jget":     {1, true, true, jsonGet},
-	"jput":     {1, false, false, jsonPut},
-	"summary":  {0, true, true, summary},
-	"usage":    {0, true, false, usage},
-	"jusage":   {0, true, false, jusage},
-	"verify":   {0, true, true, verify},
+	"candidates":      {0, true, false, candidates},
+	"checksum":        {1, true, true, checksum},
+	"create":          {1, false, false, create},
+	"extract":         {1, true, true, extract},
+	"flags":           {2, true, true, flags},
+	"flashrom-layout": {0, true, true, flashromLayout},
+	"fmd":             {0, true, true, fmd},
+	"jget":            {1, true, true, jsonGet},
+	"jput":            {1, false, false, jsonPut},
+	"replace":         {2, true, true, replace},
+	"summary":         {0, true, true, summary},
+	"tree":            {0, true, true, tree},
+	"usage":           {0, true, false, usage},
+	"jusage":          {0, true, false, jusage},
+	"verify":          {0, true, true, verify},
 }
 
+var (
+	jsonOutput = flag.Bool("json", false, "print the summary in json")
+	areaNames  = flag.String("areas", "", "comma separated names of the areas to checksum or lay out")
+	withFlags  = flag.String("with-flags", "", "checksum or lay out the areas with all these flags, e.g. READ_ONLY")
+	offset     = flag.String("offset", "", "offset of the fmap to use in a flash holding several")
+)
+
 type cmdArgs struct {
 	args []string
+	file string
 	f    *fmap.FMap     // optional
 	m    *fmap.Metadata // optional
 	r    *os.File
@@ -78,6 +119,56 @@ type jsonSchema struct {
 	Metadata *fmap.Metadata
 }
 
+// Print every fmap signature with the score of its fmap.
+func candidates(a cmdArgs) error {
+	c, err := fmap.FindAll(a.r)
+	if err != nil {
+		return err
+	}
+	if len(c) == 0 {
+		return errors.New("cannot find FMAP signature")
+	}
+	for _, cand := range c {
+		if cand.Err != nil {
+			fmt.Printf("%#08x: score 0: %v\n", cand.Start, cand.Err)
+			continue
+		}
+		fmt.Printf("%#08x: score %d: %q, size %#x, %d areas\n", cand.Start, cand.Score, cand.FMap.Name.String(), cand.FMap.Size, cand.FMap.NAreas)
+	}
+	return nil
+}
+
+// checksumAreas returns the indexes of the areas selected for the checksum.
+func checksumAreas(f *fmap.FMap) ([]int, error) {
+	return selectAreas(f, f.AreasWithFlags(fmap.FmapAreaStatic))
+}
+
+// selectAreas returns the indexes of the areas selected with --areas or
+// --with-flags, or def when neither is given.
+func selectAreas(f *fmap.FMap, def []int) ([]int, error) {
+	switch {
+	case *areaNames != "" && *withFlags != "":
+		return nil, errors.New("select the areas either by name or by flags")
+	case *areaNames != "":
+		var indexes []int
+		for _, n := range strings.Split(*areaNames, ",") {
+			i := f.IndexOfArea(n)
+			if i == -1 {
+				return nil, fmt.Errorf("area %q not found", n)
+			}
+			indexes = append(indexes, i)
+		}
+		return indexes, nil
+	case *withFlags != "":
+		fl, err := fmap.ParseFlags(*withFlags)
+		if err != nil {
+			return nil, err
+		}
+		return f.AreasWithFlags(fl), nil
+	}
+	return def, nil
+}
+
 // Print a checksum using the given hash function.
 func checksum(a cmdArgs) error {
 	if _, ok := hashFuncs[a.args[0]]; !ok {
@@ -88,7 +179,11 @@ func checksum(a cmdArgs) error {
 		return errors.New(msg)
 	}
 
-	checksum, err := a.f.Checksum(a.r, hashFuncs[a.args[0]]())
+	indexes, err := checksumAreas(a.f)
+	if err != nil {
+		return err
+	}
+	checksum, err := a.f.ChecksumAreas(a.r, hashFuncs[a.args[0]](), indexes)
 	if err != nil {
 		return err
 	}
@@ -96,15 +191,42 @@ func checksum(a cmdArgs) error {
 	return nil
 }
 
-// Print the i-th area of the flash.
-func extract(a cmdArgs) error {
-	i, err := strconv.Atoi(a.args[0])
+// Create an erased flash file holding a new fmap described by LAYOUT.
+func create(a cmdArgs) error {
+	l, err := os.Open(a.args[0])
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	f, err := fmap.ParseLayout(l)
+	if err != nil {
+		return fmt.Errorf("%s: %w", a.args[0], err)
+	}
+	flash, _, err := f.NewFlash()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(a.file, flash, 0666)
+}
+
+// areaIndex returns the index of the area given by its index or name.
+func areaIndex(f *fmap.FMap, s string) (int, error) {
+	i, err := strconv.Atoi(s)
 	if err != nil {
-		i = a.f.IndexOfArea(a.args[0])
+		i = f.IndexOfArea(s)
 		if i == -1 {
-			return fmt.Errorf("area %q not found", a.args[0])
+			return 0, fmt.Errorf("area %q not found", s)
 		}
 	}
+	return i, nil
+}
+
+// Print the i-th area of the flash.
+func extract(a cmdArgs) error {
+	i, err := areaIndex(a.f, a.args[0])
+	if err != nil {
+		return err
+	}
 	area, err := a.f.ReadArea(a.r, i)
 	if err != nil {
 		return err
@@ -113,6 +235,76 @@ func extract(a cmdArgs) error {
 	return err
 }
 
+// Replace the i-th area of the flash with DATAFILE.
+func replace(a cmdArgs) error {
+	i, err := areaIndex(a.f, a.args[0])
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(a.args[1])
+	if err != nil {
+		return err
+	}
+	fi, err := a.r.Stat()
+	if err != nil {
+		return err
+	}
+	if i >= 0 && i < len(a.f.Areas) {
+		if end := uint64(a.f.Areas[i].Offset) + uint64(a.f.Areas[i].Size); end > uint64(fi.Size()) {
+			return fmt.Errorf("area %q ends at %#x, after the end of the file at %#x", a.f.Areas[i].Name.String(), end, fi.Size())
+		}
+	}
+	w, err := os.OpenFile(a.r.Name(), os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if err := a.f.ReplaceArea(w, i, data); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// Set or clear the flags of the i-th area of the flash.
+func flags(a cmdArgs) error {
+	i, err := areaIndex(a.f, a.args[0])
+	if err != nil {
+		return err
+	}
+	edit := a.args[1]
+	if edit == "" {
+		return errors.New("no flags given")
+	}
+	op := edit[:1]
+	if op == "+" || op == "-" {
+		edit = edit[1:]
+	}
+	fl, err := fmap.ParseFlags(edit)
+	if err != nil {
+		return err
+	}
+	set, clear := fl, ^fl
+	switch op {
+	case "+":
+		clear = 0
+	case "-":
+		set, clear = 0, fl
+	}
+	if err := a.f.SetAreaFlags(i, set, clear); err != nil {
+		return err
+	}
+	w, err := os.OpenFile(a.file, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if err := fmap.Write(w, a.f, a.m); err != nil {
+		return err
+	}
+	fmt.Printf("%s: %s\n", a.f.Areas[i].Name.String(), fmap.FlagNames(a.f.Areas[i].Flags))
+	return w.Close()
+}
+
 // Write json representation of the fmap to JSONFILE.
 func jsonGet(a cmdArgs) error {
 	data, err := json.MarshalIndent(jsonSchema{a.f, a.m}, "", "\t")
@@ -125,7 +317,7 @@ func jsonGet(a cmdArgs) error {
 
 // Replace current fmap with json representation in JSONFILE.
 func jsonPut(a cmdArgs) error {
-	r, err := os.OpenFile(os.Args[len(os.Args)-1], os.O_WRONLY, 0666)
+	r, err := os.OpenFile(a.file, os.O_WRONLY, 0666)
 	if err != nil {
 		return err
 	}
@@ -144,6 +336,14 @@ func jsonPut(a cmdArgs) error {
 
 // Print a human readable summary.
 func summary(a cmdArgs) error {
+	if *jsonOutput {
+		data, err := json.MarshalIndent(jsonSchema{a.f, a.m}, "", "\t")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", data)
+		return nil
+	}
 	const desc = `Fmap found at {{printf "%#x" .Metadata.Start}}:
 	Signature:  {{printf "%s" .Signature}}
 	VerMajor:   {{.VerMajor}}
@@ -171,6 +371,25 @@ func summary(a cmdArgs) error {
 	return t.Execute(os.Stdout, combined)
 }
 
+// Print the fmap as a coreboot flashmap descriptor.
+func fmd(a cmdArgs) error {
+	return a.f.WriteFMD(os.Stdout)
+}
+
+// Print a flashrom layout file of the selected areas.
+func flashromLayout(a cmdArgs) error {
+	indexes, err := selectAreas(a.f, nil)
+	if err != nil {
+		return err
+	}
+	return a.f.WriteFlashromLayout(os.Stdout, indexes)
+}
+
+// Print the areas as a tree.
+func tree(a cmdArgs) error {
+	return a.f.WriteTree(os.Stdout)
+}
+
 // Print human readable usage stats.
 func usage(a cmdArgs) error {
 	blockSize := 4 * 1024
@@ -316,7 +535,7 @@ func verify(a cmdArgs) error {
 }
 
 func printUsage() {
-	fmt.Printf("Usage: %s CMD [ARGS...] FILE\n", os.Args[0])
+	fmt.Printf("Usage: %s [--json] [--offset OFFSET] [--areas NAME,...|--with-flags FLAGS] CMD [ARGS...] FILE\n", os.Args[0])
 	fmt.Printf("CMD can be one of:\n")
 	for k := range cmds {
 		fmt.Printf("\t%s\n", k)
@@ -325,29 +544,33 @@ func printUsage() {
 }
 
 func main() {
+	flag.Parse()
+	args := flag.Args()
+
 	// Validate args.
-	if len(os.Args) <= 2 {
+	if len(args) <= 1 {
 		printUsage()
 	}
-	cmd, ok := cmds[os.Args[1]]
+	cmd, ok := cmds[args[0]]
 	if !ok {
-		log.Errorf("Invalid command %#v\n", os.Args[1])
+		log.Errorf("Invalid command %#v\n", args[0])
 		printUsage()
 	}
-	if len(os.Args) != cmd.nArgs+3 {
-		log.Errorf("Expected %d arguments, got %d\n", cmd.nArgs+3, len(os.Args))
+	if len(args) != cmd.nArgs+2 {
+		log.Errorf("Expected %d arguments, got %d\n", cmd.nArgs+2, len(args))
 		printUsage()
 	}
 
 	// Args passed to the command.
 	a := cmdArgs{
-		args: os.Args[2 : len(os.Args)-1],
+		args: args[1 : len(args)-1],
+		file: args[len(args)-1],
 	}
 
 	// Open file, but only for specific commands.
 	if cmd.openFile {
 		// Open file.
-		r, err := os.Open(os.Args[len(os.Args)-1])
+		r, err := os.Open(a.file)
 		if err != nil {
 			log.Fatalf("%v", err)
 		}
@@ -358,7 +581,20 @@ func main() {
 	// Parse fmap, but only for specific commands.
 	if cmd.parseFMap {
 		// Parse fmap.
-		f, m, err := fmap.Read(a.r)
+		var (
+			f   *fmap.FMap
+			m   *fmap.Metadata
+			err error
+		)
+		if *offset != "" {
+			var start uint64
+			if start, err = strconv.ParseUint(*offset, 0, 64); err != nil {
+				log.Fatalf("offset: %v", err)
+			}
+			f, m, err = fmap.ReadAt(a.r, start)
+		} else {
+			f, m, err = fmap.Read(a.r)
+		}
 		if err != nil {
 			log.Fatalf("%v", err)
 		}
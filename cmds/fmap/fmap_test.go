@@ -6,10 +6,13 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/linuxboot/fiano/pkg/fmap"
 	"github.com/u-root/u-root/pkg/testutil"
 )
 
@@ -125,6 +128,239 @@ func TestJson(t *testing.T) {
 	}
 }
 
+func TestCreate(t *testing.T) {
+	tmpDir := t.TempDir()
+	layout := filepath.Join(tmpDir, "layout")
+	flash := filepath.Join(tmpDir, "flash")
+	if err := os.WriteFile(layout, []byte("FLASH test 0 0x1000\nFMAP 0x800 0x100 STATIC\nDATA 0 0x800\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := testutil.Command(t, "create", layout, flash).CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	out, err := testutil.Command(t, "summary", flash).CombinedOutput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `Fmap found at 0x800:
+	Signature:  __FMAP__
+	VerMajor:   1
+	VerMinor:   1
+	Base:       0x0
+	Size:       0x1000
+	Name:       test
+	NAreas:     2
+	Areas[0]:
+		Offset:  0x800
+		Size:    0x100
+		Name:    FMAP
+		Flags:   0x1 (STATIC)
+	Areas[1]:
+		Offset:  0x0
+		Size:    0x800
+		Name:    DATA
+		Flags:   0x0 (0x0)
+`
+	if got := string(bytes.ReplaceAll(out, []byte{0}, []byte{})); got != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	tmpDir := t.TempDir()
+	layout := filepath.Join(tmpDir, "layout")
+	flash := filepath.Join(tmpDir, "flash")
+	data := filepath.Join(tmpDir, "data")
+	if err := os.WriteFile(layout, []byte("FLASH test 0 0x1000\nFMAP 0x800 0x100\nDATA 0 0x800\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(data, []byte("new data"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := testutil.Command(t, "create", layout, flash).CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	if out, err := testutil.Command(t, "replace", "DATA", data, flash).CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	out, err := testutil.Command(t, "extract", "DATA", flash).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append([]byte("new data"), bytes.Repeat([]byte{0xff}, 0x800-8)...)
+	if !bytes.Equal(out, want) {
+		t.Errorf("got %q..., want %q...", out[:16], want[:16])
+	}
+
+	if err := os.WriteFile(data, make([]byte, 0x101), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := testutil.Command(t, "replace", "FMAP", data, flash).Run(); err == nil {
+		t.Errorf("replacing with data too large: got nil, want error")
+	}
+}
+
+func TestJSONSummary(t *testing.T) {
+	out, err := testutil.Command(t, "--json", "summary", testFlash).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var j struct {
+		FMap     fmap.FMap
+		Metadata fmap.Metadata
+	}
+	if err := json.Unmarshal(out, &j); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	if j.Metadata.Start != 0x5f74 || j.FMap.NAreas != 2 || j.FMap.Areas[1].Name.String() != "Area Number 2xxxxxxxxxxxxxxxxxxx" {
+		t.Errorf("got %+v", j)
+	}
+}
+
+func TestFlags(t *testing.T) {
+	tmpDir := t.TempDir()
+	layout := filepath.Join(tmpDir, "layout")
+	flash := filepath.Join(tmpDir, "flash")
+	if err := os.WriteFile(layout, []byte("FLASH test 0 0x1000\nFMAP 0x800 0x100 STATIC\nDATA 0 0x800\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := testutil.Command(t, "create", layout, flash).CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	for _, tt := range []struct {
+		area, edit, want string
+	}{
+		{"DATA", "READ_ONLY|COMPRESSED", "DATA: COMPRESSED|READ_ONLY\n"},
+		{"DATA", "+STATIC", "DATA: STATIC|COMPRESSED|READ_ONLY\n"},
+		{"1", "-COMPRESSED|READ_ONLY", "DATA: STATIC\n"},
+		{"FMAP", "-STATIC", "FMAP: 0x0\n"},
+	} {
+		out, err := testutil.Command(t, "flags", tt.area, tt.edit, flash).Output()
+		if err != nil {
+			t.Fatalf("flags %s %s: %v", tt.area, tt.edit, err)
+		}
+		if string(out) != tt.want {
+			t.Errorf("flags %s %s: got %q, want %q", tt.area, tt.edit, out, tt.want)
+		}
+	}
+	out, err := testutil.Command(t, "tree", flash).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "DATA") || !strings.Contains(string(out), "STATIC") {
+		t.Errorf("the flags were not saved:\n%s", out)
+	}
+}
+
+func TestChecksumAreas(t *testing.T) {
+	tmpDir := t.TempDir()
+	layout := filepath.Join(tmpDir, "layout")
+	flash := filepath.Join(tmpDir, "flash")
+	if err := os.WriteFile(layout, []byte("FLASH test 0 0x1000\nRO 0 0x800 READ_ONLY\nFMAP 0x800 0x100 READ_ONLY|STATIC\nRW 0x900 0x700\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := testutil.Command(t, "create", layout, flash).CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	sum := func(args ...string) string {
+		t.Helper()
+		out, err := testutil.Command(t, append(args, flash)...).Output()
+		if err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		return string(out)
+	}
+	static := sum("checksum", "sha256")
+	if got := sum("--areas", "FMAP", "checksum", "sha256"); got != static {
+		t.Errorf("checksum of FMAP: got %s, want the checksum of the static areas %s", got, static)
+	}
+	ro := sum("--with-flags", "READ_ONLY", "checksum", "sha256")
+	if got := sum("--areas", "RO,FMAP", "checksum", "sha256"); got != ro {
+		t.Errorf("checksum of RO,FMAP: got %s, want the checksum of the read-only areas %s", got, ro)
+	}
+	if ro == static {
+		t.Errorf("the checksums of the static and read-only areas are both %s", ro)
+	}
+	if err := testutil.Command(t, "--areas", "NOPE", "checksum", "sha256", flash).Run(); err == nil {
+		t.Errorf("missing area: got nil, want error")
+	}
+}
+
+func TestFlashromLayout(t *testing.T) {
+	tmpDir := t.TempDir()
+	layout := filepath.Join(tmpDir, "layout")
+	flash := filepath.Join(tmpDir, "flash")
+	if err := os.WriteFile(layout, []byte("FLASH test 0 0x1000\nRO 0 0x800 READ_ONLY\nFMAP 0x800 0x100 READ_ONLY|STATIC\nRW 0x900 0x700\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := testutil.Command(t, "create", layout, flash).CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{nil, "00000000:000007ff RO\n00000800:000008ff FMAP\n00000900:00000fff RW\n"},
+		{[]string{"--areas", "RW"}, "00000900:00000fff RW\n"},
+		{[]string{"--with-flags", "READ_ONLY"}, "00000000:000007ff RO\n00000800:000008ff FMAP\n"},
+	} {
+		out, err := testutil.Command(t, append(tt.args, "flashrom-layout", flash)...).Output()
+		if err != nil {
+			t.Fatalf("%v: %v", tt.args, err)
+		}
+		if string(out) != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.args, out, tt.want)
+		}
+	}
+}
+
+func TestCandidates(t *testing.T) {
+	out, err := testutil.Command(t, "candidates", testFlash).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "0x00005f74: score 1: \"Fake flash\", size 0x44332211, 2 areas\n"; string(out) != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+	out, err = testutil.Command(t, "--offset", "0x5f74", "summary", testFlash).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(out), "Fmap found at 0x5f74:") {
+		t.Errorf("got %q, want the summary of the fmap at 0x5f74", out)
+	}
+	if err := testutil.Command(t, "--offset", "0x100", "summary", testFlash).Run(); err == nil {
+		t.Errorf("no fmap at 0x100: got nil, want error")
+	}
+}
+
+func TestFMD(t *testing.T) {
+	tmpDir := t.TempDir()
+	layout := filepath.Join(tmpDir, "layout")
+	flash := filepath.Join(tmpDir, "flash")
+	if err := os.WriteFile(layout, []byte("FLASH test 0 0x1000\nRO 0 0x800 READ_ONLY\nFMAP 0x0 0x100 READ_ONLY|STATIC\nRW 0x800 0x800\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := testutil.Command(t, "create", layout, flash).CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	out, err := testutil.Command(t, "fmd", flash).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "test@0x0 0x1000 {\n\tRO(READ_ONLY)@0x0 0x800 {\n\t\tFMAP(STATIC|READ_ONLY)@0x0 0x100\n\t}\n\tRW@0x800 0x800\n}\n"
+	if string(out) != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", out, want)
+	}
+	f, err := fmap.ParseFMD(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Areas) != 3 || f.Areas[1].Name.String() != "FMAP" || f.Areas[1].Flags != fmap.FmapAreaStatic|fmap.FmapAreaReadOnly {
+		t.Errorf("got areas %+v", f.Areas)
+	}
+}
+
 func TestMain(m *testing.M) {
 	testutil.Run(m, main)
 }
@@ -12,6 +12,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/linuxboot/fiano/pkg/fsp"
 	"github.com/linuxboot/fiano/pkg/log"
@@ -19,18 +20,64 @@ import (
 )
 
 var (
-	flagJSON = flag.Bool("j", false, "Output as JSON")
+	flagJSON   = flag.Bool("j", false, "Output as JSON")
+	flagSchema = flag.String("u", "", "BSF or YAML (.yaml, .yml) schema of the UPD regions to print")
 )
 
-// extractFSPHeader decapsulates an FSP header as described by the FSP specification.
-// The FSP files from intel contain various components (e.g. FSP-M, FSP-T, FSP-S),
-// each contained in a firmware volume.
-// Each FSP component has an FSP_INFO_HEADER in the first FFS file in the first firmware
-// volume.
+// component is a component of the FSP binary with its UPD region, if a
+// schema describes it.
+type component struct {
+	Component *fsp.Component
+	UPD       *fsp.UPD `json:",omitempty"`
+}
+
+// readSchema reads a BSF or YAML UPD schema.
+func readSchema(path string) (fsp.UPDSchema, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		return fsp.ParseUPDYAML(f)
+	}
+	return fsp.ParseBSF(f)
+}
+
+// readComponents returns the components of the FSP binary b. The FSP files
+// from intel contain various components (e.g. FSP-M, FSP-T, FSP-S), each
+// made of firmware volumes, with an FSP_INFO_HEADER in the first FFS file
+// of the first one. The binaries which are not a sequence of components are
+// read as before, from the first header only, with a warning.
 // See https://www.intel.com/content/dam/www/public/us/en/documents/technical-specifications/fsp-architecture-spec-v2.pdf chapter 4.
+func readComponents(b []byte, schema fsp.UPDSchema) ([]component, error) {
+	comps, err := fsp.ParseComponents(b)
+	if err != nil {
+		c, ferr := extractFirstComponent(b)
+		if ferr != nil {
+			return nil, err
+		}
+		log.Warnf("%v, only reading the first FSP header", err)
+		comps = []*fsp.Component{c}
+	}
+	var r []component
+	for _, c := range comps {
+		rc := component{Component: c}
+		if schema != nil {
+			if rc.UPD, err = c.UPD(b, schema); err != nil && !errors.Is(err, fsp.ErrUnknownUPD) {
+				return nil, err
+			}
+		}
+		r = append(r, rc)
+	}
+	return r, nil
+}
 
-// TODO extract the remaining firmware volumes too
-func extractFirstFSPHeader(b []byte) (*fsp.CommonInfoHeader, error) {
+// extractFirstComponent returns the component of the FSP info header in the
+// first section of the first file of the firmware volume at the start of b,
+// whatever follows.
+func extractFirstComponent(b []byte) (*fsp.Component, error) {
 	fv, err := uefi.NewFirmwareVolume(b, 0, false)
 	if err != nil {
 		return nil, fmt.Errorf("cannot parse Firmware Volume: %v", err)
@@ -48,7 +95,21 @@ func extractFirstFSPHeader(b []byte) (*fsp.CommonInfoHeader, error) {
 	if err != nil {
 		return nil, fmt.Errorf("cannot parse FSP Info Header: %v", err)
 	}
-	return hdr, nil
+	_, off, _ := uefi.Locate(fv, file)
+	return &fsp.Component{HeaderOffset: *off + file.DataOffset + 4, Header: hdr, FVs: []uint64{0}}, nil
+}
+
+// marshalComponents returns the JSON of the components: an array, or the
+// info header of a single component, as fspinfo printed it before reading
+// every component, with its UPD settings.
+func marshalComponents(comps []component) ([]byte, error) {
+	if len(comps) == 1 {
+		return json.MarshalIndent(struct {
+			*fsp.CommonInfoHeader
+			UPD *fsp.UPD `json:",omitempty"`
+		}{comps[0].Component.Header, comps[0].UPD}, "", "    ")
+	}
+	return json.MarshalIndent(comps, "", "    ")
 }
 
 func main() {
@@ -60,18 +121,33 @@ func main() {
 	if err != nil {
 		log.Fatalf("cannot read input file: %v", err)
 	}
-	hdr, err := extractFirstFSPHeader(data)
+	var schema fsp.UPDSchema
+	if *flagSchema != "" {
+		if schema, err = readSchema(*flagSchema); err != nil {
+			log.Fatalf("cannot read UPD schema: %v", err)
+		}
+	}
+	comps, err := readComponents(data, schema)
 	if err != nil {
 		log.Fatalf("%v", err)
 	}
 
-	j, err := json.MarshalIndent(hdr, "", "    ")
-	if err != nil {
-		log.Fatalf("cannot marshal JSON: %v", err)
-	}
 	if *flagJSON {
+		j, err := marshalComponents(comps)
+		if err != nil {
+			log.Fatalf("cannot marshal JSON: %v", err)
+		}
 		fmt.Println(string(j))
-	} else {
-		fmt.Print(hdr.Summary())
+		return
+	}
+	for i, c := range comps {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%v at %#x, info header at %#x\n", c.Component.Type(), c.Component.Offset, c.Component.HeaderOffset)
+		fmt.Print(c.Component.Header.Summary())
+		if c.UPD != nil {
+			fmt.Print(c.UPD.Summary())
+		}
 	}
 }
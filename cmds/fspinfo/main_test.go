@@ -6,6 +6,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"os"
 	"testing"
 
@@ -82,3 +83,101 @@ func TestNewInfoHeaderRealFile(t *testing.T) {
 		t.Errorf("Invalid FSP silicon init entry offset %#x; want %#x", hdr.FSPSiliconInitEntryOffset, 0x58a)
 	}
 }
+
+func TestReadComponentsJSON(t *testing.T) {
+	buf, err := os.ReadFile(FSPTestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := readSchema("../../pkg/fsp/testdata/upd.bsf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	comps, err := readComponents(buf, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := json.Marshal(comps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []struct {
+		Component struct {
+			Type         string
+			Offset       uint64
+			HeaderOffset uint64
+			Header       struct{ ImageBase uint32 }
+		}
+		UPD *struct {
+			Signature string
+			Offset    uint64
+			Fields    []struct {
+				Name, Value string
+				Offset      uint32
+			}
+		}
+	}
+	if err := json.Unmarshal(j, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("got %d components, want 3:\n%s", len(got), j)
+	}
+	for i, w := range []struct {
+		typ    string
+		offset uint64
+		base   uint32
+	}{{"FSP-S", 0, 0x200000}, {"FSP-M", 0x2a000, 0xfef71000}, {"FSP-T", 0x83000, 0xffffe000}} {
+		c := got[i].Component
+		if c.Type != w.typ || c.Offset != w.offset || c.HeaderOffset != w.offset+148 || c.Header.ImageBase != w.base {
+			t.Errorf("component %d: got %+v, want %s at %#x based at %#x", i, c, w.typ, w.offset, w.base)
+		}
+	}
+	// The schema only describes the UPD of FSP-S.
+	u := got[0].UPD
+	if u == nil || u.Signature != "APLUPD_S" || u.Offset != 0x124 || len(u.Fields) != 15 {
+		t.Fatalf("got UPD %+v, want APLUPD_S at 0x124 with 15 fields", u)
+	}
+	if f := u.Fields[14]; f.Name != "PkgCStateLimit" || f.Offset != 0x2c || f.Value != "0x02" {
+		t.Errorf("got %+v, want PkgCStateLimit at 0x2c holding 0x02", f)
+	}
+	if got[1].UPD != nil || got[2].UPD != nil {
+		t.Errorf("got UPDs for FSP-M or FSP-T, want none")
+	}
+}
+
+func TestReadComponentsSingle(t *testing.T) {
+	buf, err := os.ReadFile(FSPTestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// FSP-S alone, then followed by data in no component, which is only
+	// read from its first header.
+	fspS := buf[:0x2a000]
+	for name, b := range map[string][]byte{
+		"single component": fspS,
+		"trailing data":    append(append([]byte{}, fspS...), bytes.Repeat([]byte{0xff}, 0x1000)...),
+	} {
+		comps, err := readComponents(b, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(comps) != 1 || comps[0].Component.HeaderOffset != 148 || comps[0].Component.Type() != fsp.TypeS {
+			t.Fatalf("%s: got %+v, want FSP-S with its header at 148", name, comps)
+		}
+		j, err := marshalComponents(comps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var hdr fsp.CommonInfoHeader
+		if err := json.Unmarshal(j, &hdr); err != nil {
+			t.Fatalf("%s: got %s, want the info header: %v", name, j, err)
+		}
+		if hdr.ImageSize != 0x2a000 || hdr.ImageBase != 0x200000 {
+			t.Errorf("%s: got %s, want the info header of FSP-S", name, j)
+		}
+	}
+	if _, err := readComponents(bytes.Repeat([]byte{0xff}, 0x1000), nil); err == nil {
+		t.Errorf("no firmware volume: got nil, want error")
+	}
+}
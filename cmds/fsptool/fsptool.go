@@ -0,0 +1,282 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Fsptool modifies Intel FSP binaries.
+//
+// Synopsis:
+//
+//	fsptool merge PART... FILE
+//	fsptool rebase t|m|s|o BASE FILE
+//	fsptool sethdr t|m|s|o NAME=VALUE,... FILE
+//	fsptool setupd SCHEMA t|m|s|o NAME=VALUE,... FILE
+//	fsptool split DIR FILE
+//	fsptool upd SCHEMA t|m|s|o FILE
+//
+// Description:
+//
+//	merge:  Write to FILE the concatenation of the FSP binaries PART...,
+//	        e.g. the FSP-T, FSP-M and FSP-S parts written by split.
+//	rebase: Move the FSP-T, FSP-M, FSP-S or FSP-O component of FILE to the
+//	        address BASE, patching its info header, its patch table and the
+//	        relocations of its PE and TE images, as SplitFspBin.py does.
+//	        FILE is modified in place.
+//	sethdr: Set fields of the info header of a component, e.g.
+//	        CfgRegionOffset=0x124,ImageAttribute=1, and update the checksum
+//	        of its file. FILE is modified in place. The fields which may be
+//	        set are listed by fsp.HeaderFields.
+//	setupd: Set UPD settings of a component, a number or bytes in braces,
+//	        e.g. PkgCStateLimit=2,DisableCores={0x01, 0x00}. FILE is modified
+//	        in place.
+//	split:  Write each component of FILE to DIR, named after FILE with the
+//	        suffix of its type, e.g. Fsp_M.fd for the FSP-M of Fsp.fd, and
+//	        print its info header.
+//	upd:    Print the UPD settings of a component, the configuration region
+//	        described by SCHEMA, a BSF file or a YAML file with a .yaml or
+//	        .yml extension, and their defaults if they differ.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/fsp"
+	"github.com/linuxboot/fiano/pkg/log"
+)
+
+// Commands with nArgs -1 take one or more arguments. Those with parse set get
+// the components of FILE.
+var cmds = map[string]struct {
+	nArgs int
+	parse bool
+	f     func(a cmdArgs) error
+}{
+	"merge":  {-1, false, merge},
+	"rebase": {2, true, rebase},
+	"sethdr": {2, true, setHeader},
+	"setupd": {3, true, setUPD},
+	"split":  {1, true, split},
+	"upd":    {2, true, upd},
+}
+
+type cmdArgs struct {
+	args  []string
+	file  string
+	data  []byte
+	comps []*fsp.Component
+}
+
+// component returns the component named by s, e.g. m for FSP-M.
+func (a cmdArgs) component(s string) (*fsp.Component, error) {
+	t, err := fsp.ParseType(s)
+	if err != nil {
+		return nil, err
+	}
+	c := fsp.ComponentOfType(a.comps, t)
+	if c == nil {
+		return nil, fmt.Errorf("%s has no %v component", a.file, t)
+	}
+	return c, nil
+}
+
+// Move a component to a new base address.
+func rebase(a cmdArgs) error {
+	c, err := a.component(a.args[0])
+	if err != nil {
+		return err
+	}
+	base, err := strconv.ParseUint(a.args[1], 0, 32)
+	if err != nil {
+		return fmt.Errorf("base: %w", err)
+	}
+	old := c.Header.ImageBase
+	if err := c.Rebase(a.data, uint32(base)); err != nil {
+		return err
+	}
+	if err := os.WriteFile(a.file, a.data, 0o666); err != nil {
+		return err
+	}
+	fmt.Printf("%v rebased from %#08x to %#08x\n", c.Type(), old, base)
+	return nil
+}
+
+// Set fields of the info header of a component.
+func setHeader(a cmdArgs) error {
+	c, err := a.component(a.args[0])
+	if err != nil {
+		return err
+	}
+	for _, f := range strings.Split(a.args[1], ",") {
+		name, val, ok := strings.Cut(f, "=")
+		if !ok {
+			return fmt.Errorf("want NAME=VALUE, got %q", f)
+		}
+		v, err := strconv.ParseUint(val, 0, 32)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if err := c.SetHeaderField(a.data, name, v); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(a.file, a.data, 0o666); err != nil {
+		return err
+	}
+	fmt.Print(c.Header.Summary())
+	return nil
+}
+
+// readUPD returns the UPD of the component named by a.args[1] described by
+// the schema in a.args[0].
+func (a cmdArgs) readUPD() (*fsp.UPD, error) {
+	c, err := a.component(a.args[1])
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(a.args[0])
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var schema fsp.UPDSchema
+	switch filepath.Ext(a.args[0]) {
+	case ".yaml", ".yml":
+		schema, err = fsp.ParseUPDYAML(f)
+	default:
+		schema, err = fsp.ParseBSF(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", a.args[0], err)
+	}
+	return c.UPD(a.data, schema)
+}
+
+// Print the UPD settings of a component.
+func upd(a cmdArgs) error {
+	u, err := a.readUPD()
+	if err != nil {
+		return err
+	}
+	fmt.Print(u.Summary())
+	return nil
+}
+
+// Set UPD settings of a component.
+func setUPD(a cmdArgs) error {
+	u, err := a.readUPD()
+	if err != nil {
+		return err
+	}
+	// Values in braces hold commas too.
+	settings := a.args[2]
+	for settings != "" {
+		name, rest, ok := strings.Cut(settings, "=")
+		if !ok {
+			return fmt.Errorf("want NAME=VALUE, got %q", settings)
+		}
+		end := strings.Index(rest, ",")
+		if strings.HasPrefix(rest, "{") {
+			end = strings.Index(rest, "}") + 1
+			if end == 0 {
+				return fmt.Errorf("%s: unterminated value %q", name, rest)
+			}
+		}
+		if end == -1 {
+			end = len(rest)
+		}
+		if err := u.SetString(name, rest[:end]); err != nil {
+			return err
+		}
+		settings = strings.TrimPrefix(rest[end:], ",")
+	}
+	return os.WriteFile(a.file, a.data, 0o666)
+}
+
+// Write the components of the binary to a directory.
+func split(a cmdArgs) error {
+	comps, parts, err := fsp.Split(a.data)
+	if err != nil {
+		return err
+	}
+	ext := filepath.Ext(a.file)
+	name := strings.TrimSuffix(filepath.Base(a.file), ext)
+	for i, c := range comps {
+		suffix := strings.TrimPrefix(c.Type().String(), "FSP-")
+		path := filepath.Join(a.args[0], name+"_"+suffix+ext)
+		if err := os.WriteFile(path, parts[i], 0o666); err != nil {
+			return err
+		}
+		fmt.Printf("%v at %#x: %s\n%s\n", c.Type(), a.comps[i].Offset, path, c.Header.Summary())
+	}
+	return nil
+}
+
+// Concatenate binaries.
+func merge(a cmdArgs) error {
+	var parts [][]byte
+	for _, p := range a.args {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		parts = append(parts, b)
+	}
+	b, err := fsp.Merge(parts...)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(a.file, b, 0o666)
+}
+
+func printUsage() {
+	fmt.Printf("Usage: %s CMD [ARGS...] FILE\n", os.Args[0])
+	fmt.Printf("CMD can be one of:\n")
+	for k := range cmds {
+		fmt.Printf("\t%s\n", k)
+	}
+	os.Exit(2)
+}
+
+func main() {
+	flag.Parse()
+	args := flag.Args()
+
+	if len(args) <= 1 {
+		printUsage()
+	}
+	cmd, ok := cmds[args[0]]
+	if !ok {
+		log.Errorf("Invalid command %#v\n", args[0])
+		printUsage()
+	}
+	if cmd.nArgs == -1 {
+		if len(args) < 3 {
+			log.Errorf("Expected at least 3 arguments, got %d\n", len(args))
+			printUsage()
+		}
+	} else if len(args) != cmd.nArgs+2 {
+		log.Errorf("Expected %d arguments, got %d\n", cmd.nArgs+2, len(args))
+		printUsage()
+	}
+
+	a := cmdArgs{
+		args: args[1 : len(args)-1],
+		file: args[len(args)-1],
+	}
+	if cmd.parse {
+		var err error
+		if a.data, err = os.ReadFile(a.file); err != nil {
+			log.Fatalf("%v", err)
+		}
+		if a.comps, err = fsp.ParseComponents(a.data); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	if err := cmd.f(a); err != nil {
+		log.Fatalf("%v", err)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/fsp"
+	"github.com/u-root/u-root/pkg/testutil"
+)
+
+// From https://github.com/IntelFsp/FSP/blob/master/ApolloLakeFspBinPkg/FspBin/Fsp.fd
+// under the FSP license. See README.md under cmds/fspinfo/test_blobs.
+const testFSP = "../fspinfo/test_blobs/ApolloLakeFspBinPkg/Fsp.fd"
+
+// copyTestFSP returns the path of a copy of the test binary.
+func copyTestFSP(t *testing.T) string {
+	t.Helper()
+	b, err := os.ReadFile(testFSP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := filepath.Join(t.TempDir(), "Fsp.fd")
+	if err := os.WriteFile(f, b, 0o666); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestRebase(t *testing.T) {
+	f := copyTestFSP(t)
+	out, err := testutil.Command(t, "rebase", "m", "0xfef00000", f).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	if want := "FSP-M rebased from 0xfef71000 to 0xfef00000\n"; string(out) != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+	b, err := os.ReadFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comps, err := fsp.ParseComponents(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := fsp.ComponentOfType(comps, fsp.TypeM); c.Header.ImageBase != 0xfef00000 {
+		t.Errorf("got FSP-M based at %#x, want 0xfef00000", c.Header.ImageBase)
+	}
+
+	for _, args := range [][]string{{"x", "0"}, {"s", "0x1000000000"}, {"t", "0xfffff000"}} {
+		if err := testutil.Command(t, append([]string{"rebase"}, append(args, f)...)...).Run(); err == nil {
+			t.Errorf("rebase %v: got nil, want error", args)
+		}
+	}
+}
+
+func TestSplitMerge(t *testing.T) {
+	f := copyTestFSP(t)
+	dir := t.TempDir()
+	out, err := testutil.Command(t, "split", dir, f).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	var parts []string
+	for _, s := range []string{"S", "M", "T"} {
+		p := filepath.Join(dir, "Fsp_"+s+".fd")
+		if !strings.Contains(string(out), "FSP-"+s+" at ") || !strings.Contains(string(out), p) {
+			t.Errorf("got %q, want FSP-%s written to %s", out, s, p)
+		}
+		parts = append(parts, p)
+	}
+	if !strings.Contains(string(out), "Image Base                       : 0xfef71000") {
+		t.Errorf("got %q, want the info headers", out)
+	}
+
+	merged := filepath.Join(dir, "merged.fd")
+	if out, err := testutil.Command(t, append(append([]string{"merge"}, parts...), merged)...).CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	want, err := os.ReadFile(testFSP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("merging the parts did not restore the binary")
+	}
+	if err := testutil.Command(t, "merge", parts[0], parts[0], merged).Run(); err == nil {
+		t.Errorf("merging FSP-S twice: got nil, want error")
+	}
+}
+
+func TestUPD(t *testing.T) {
+	f := copyTestFSP(t)
+	const schema = "../../pkg/fsp/testdata/upd.bsf"
+	if out, err := testutil.Command(t, "setupd", schema, "s", "PkgCStateLimit=5,Revision={0x02},C1e=1", f).CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	out, err := testutil.Command(t, "upd", schema, "s", f).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	for _, want := range []string{
+		"APLUPD_S at 0x124\n",
+		"0x0025    1 ProcTraceMemSize                         0xff\n",
+		"0x002a    1 C1e                                      0x01 (default 0x00)\n",
+		"0x002c    1 PkgCStateLimit                           0x05 (default 0x02)\n",
+		"0x0008    1 Revision                                 0x02 (default 0x01)\n",
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("got\n%s\nwant a line %q", out, want)
+		}
+	}
+	for _, args := range [][]string{{"upd", schema, "m"}, {"setupd", schema, "s", "Eist"}, {"setupd", schema, "s", "Eist=0x100"}} {
+		if err := testutil.Command(t, append(args, f)...).Run(); err == nil {
+			t.Errorf("%v: got nil, want error", args)
+		}
+	}
+}
+
+func TestSetHeader(t *testing.T) {
+	f := copyTestFSP(t)
+	out, err := testutil.Command(t, "sethdr", "s", "ImageBase=0x300000,ImageAttribute=0", f).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	for _, want := range []string{
+		"Image Base                       : 0x00300000 3145728\n",
+		"Image Attribute                  : 0x0000 GraphicsDisplayNotSupported",
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("got\n%s\nwant %q", out, want)
+		}
+	}
+	b, err := os.ReadFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comps, err := fsp.ParseComponents(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := comps[0].Header; h.ImageBase != 0x300000 || h.ImageAttribute != 0 {
+		t.Errorf("got %+v, want the new values", h)
+	}
+	for _, arg := range []string{"ImageSize=0", "ImageBase", "CfgRegionSize=0x100000"} {
+		if err := testutil.Command(t, "sethdr", "s", arg, f).Run(); err == nil {
+			t.Errorf("%s: got nil, want error", arg)
+		}
+	}
+}
+
+func TestMain(m *testing.M) {
+	testutil.Run(m, main)
+}
@@ -0,0 +1,79 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// fwdiff compares two firmware images across the UEFI tree, the FIT, the
+// BootGuard manifests, the AMD PSP and BIOS directories and the CBFS.
+//
+// Synopsis:
+//
+//	fwdiff [-j] [-html FILE] OLD NEW
+//
+// Description:
+//
+//	Print, for each domain, whether it is the same in both images, differs,
+//	or is found in neither, and the added, removed and changed items: the
+//	regions, firmware volumes and files, the FIT entries, the manifests, the
+//	directory entries, the FMAP areas and the CBFS files. With -html, also
+//	write the report to FILE as a page with a collapsible section per
+//	domain. Exit with 1 if the images differ.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	bytes2 "github.com/linuxboot/fiano/pkg/bytes"
+	"github.com/linuxboot/fiano/pkg/fwdiff"
+	"github.com/linuxboot/fiano/pkg/log"
+)
+
+var (
+	flagJSON = flag.Bool("j", false, "Output as JSON")
+	flagHTML = flag.String("html", "", "Write an HTML report to this file")
+)
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 2 {
+		log.Fatalf("usage: fwdiff [-j] [-html FILE] OLD NEW")
+	}
+	// Map the images rather than reading them, as each domain only looks
+	// at parts of them.
+	oldImage, err := bytes2.Mmap(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("cannot read file: %v", err)
+	}
+	newImage, err := bytes2.Mmap(flag.Arg(1))
+	if err != nil {
+		log.Fatalf("cannot read file: %v", err)
+	}
+
+	r := fwdiff.Compare(flag.Arg(0), oldImage.Bytes(), flag.Arg(1), newImage.Bytes())
+	oldImage.Close()
+	newImage.Close()
+	if *flagHTML != "" {
+		var b bytes.Buffer
+		if err := r.WriteHTML(&b); err != nil {
+			log.Fatalf("%v", err)
+		}
+		if err := os.WriteFile(*flagHTML, b.Bytes(), 0o666); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	if *flagJSON {
+		j, err := json.MarshalIndent(r, "", "    ")
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		fmt.Println(string(j))
+	} else if err := r.WriteText(os.Stdout); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if r.Different() {
+		os.Exit(1)
+	}
+}
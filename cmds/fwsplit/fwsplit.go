@@ -0,0 +1,140 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Fwsplit splits Intel flash images into their descriptor regions and joins
+// them back.
+//
+// Synopsis:
+//
+//	fwsplit split DIR FILE
+//	fwsplit join DIR FILE
+//
+// Description:
+//
+//	split: Write the flash descriptor and each region it declares of FILE to
+//	       DIR, named after the region, e.g. descriptor.bin, me.bin, gbe.bin,
+//	       ec.bin and bios.bin. The bytes which no region holds are written
+//	       to gap_OFFSET.bin files, so that no byte of FILE is lost. The
+//	       parts are listed in DIR/layout.txt, in the format of the layout
+//	       files of "flashrom -l".
+//	join:  Write to FILE the image made of the parts in DIR listed by
+//	       DIR/layout.txt, typically after some of them were replaced. The
+//	       offset and size of each part are checked against the regions
+//	       declared by DIR/descriptor.bin.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/log"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// layoutFile lists the parts of the image in the directory.
+const layoutFile = "layout.txt"
+
+var cmds = map[string]func(dir, file string) error{
+	"split": split,
+	"join":  join,
+}
+
+// partName returns the name of a part in the layout, that of its file
+// without the .bin extension.
+func partName(p uefi.FlashRegionPart) string {
+	if p.Name == uefi.FlashPartGap {
+		return fmt.Sprintf("%s_%08x", p.Name, p.Offset)
+	}
+	return p.Name
+}
+
+// Write each part of the image to dir.
+func split(dir, file string) error {
+	image, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	parts, err := uefi.SplitRegions(image)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return err
+	}
+	var layout strings.Builder
+	for _, p := range parts {
+		name := partName(p)
+		if err := os.WriteFile(filepath.Join(dir, name+".bin"), p.Data, 0o666); err != nil {
+			return err
+		}
+		fmt.Fprintf(&layout, "%08x:%08x %s\n", p.Offset, p.Offset+p.Size-1, name)
+	}
+	fmt.Print(layout.String())
+	return os.WriteFile(filepath.Join(dir, layoutFile), []byte(layout.String()), 0o666)
+}
+
+// Join the parts of dir into an image.
+func join(dir, file string) error {
+	f, err := os.Open(filepath.Join(dir, layoutFile))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var parts []uefi.FlashRegionPart
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		if strings.TrimSpace(s.Text()) == "" {
+			continue
+		}
+		var start, end uint64
+		var name string
+		if _, err := fmt.Sscanf(s.Text(), "%x:%x %s", &start, &end, &name); err != nil || end < start {
+			return fmt.Errorf("%s:%d: want START:END NAME, got %q", layoutFile, line, s.Text())
+		}
+		p := uefi.FlashRegionPart{Name: name, Offset: start, Size: end - start + 1}
+		if strings.HasPrefix(name, uefi.FlashPartGap+"_") {
+			p.Name = uefi.FlashPartGap
+		}
+		if p.Data, err = os.ReadFile(filepath.Join(dir, name+".bin")); err != nil {
+			return err
+		}
+		if uint64(len(p.Data)) != p.Size {
+			return fmt.Errorf("%s.bin: %s declares %#x bytes, got %#x", name, layoutFile, p.Size, len(p.Data))
+		}
+		parts = append(parts, p)
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	image, err := uefi.JoinRegions(parts...)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, image, 0o666)
+}
+
+func printUsage() {
+	fmt.Printf("Usage: %s split|join DIR FILE\n", os.Args[0])
+	os.Exit(2)
+}
+
+func main() {
+	flag.Parse()
+	args := flag.Args()
+	if len(args) != 3 {
+		printUsage()
+	}
+	cmd, ok := cmds[args[0]]
+	if !ok {
+		log.Errorf("Invalid command %#v\n", args[0])
+		printUsage()
+	}
+	if err := cmd(args[1], args[2]); err != nil {
+		log.Fatalf("%v", err)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/u-root/u-root/pkg/testutil"
+)
+
+// writeTestImage writes an image of 1MiB whose descriptor declares an ME
+// region at 0x1000 and a BIOS region filling the second half.
+func writeTestImage(t *testing.T) (string, []byte) {
+	t.Helper()
+	image := bytes.Repeat([]byte{0xff}, 1<<20)
+	ifd := image[:uefi.FlashDescriptorLength]
+	for i := range ifd {
+		ifd[i] = 0
+	}
+	copy(ifd[16:], uefi.FlashSignature)
+	// FLMAP0: the regions at 0x40.
+	copy(ifd[20:], []byte{0x03, 0x00, 0x04, 0x00})
+	// FLMAP1: the masters at 0x60.
+	ifd[24] = 0x06
+	binary.LittleEndian.PutUint16(ifd[0x44:], 0x80)
+	binary.LittleEndian.PutUint16(ifd[0x46:], 0xff)
+	binary.LittleEndian.PutUint16(ifd[0x48:], 0x1)
+	binary.LittleEndian.PutUint16(ifd[0x4a:], 0x1f)
+	copy(image[0x1000:], "ME")
+	copy(image[0x7000:], "gap")
+	copy(image[0x80000:], "BIOS")
+	f := filepath.Join(t.TempDir(), "image.rom")
+	if err := os.WriteFile(f, image, 0o666); err != nil {
+		t.Fatal(err)
+	}
+	return f, image
+}
+
+func TestSplitJoin(t *testing.T) {
+	f, image := writeTestImage(t)
+	dir := filepath.Join(t.TempDir(), "regions")
+	out, err := testutil.Command(t, "split", dir, f).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	want := "00000000:00000fff descriptor\n00001000:0001ffff me\n00020000:0007ffff gap_00020000\n00080000:000fffff bios\n"
+	if string(out) != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+	me, err := os.ReadFile(filepath.Join(dir, "me.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(me, image[0x1000:0x20000]) {
+		t.Error("me.bin is not the ME region")
+	}
+
+	// Replace the BIOS region and join.
+	bios := bytes.Repeat([]byte{0x42}, 0x80000)
+	if err := os.WriteFile(filepath.Join(dir, "bios.bin"), bios, 0o666); err != nil {
+		t.Fatal(err)
+	}
+	joined := filepath.Join(t.TempDir(), "joined.rom")
+	if out, err := testutil.Command(t, "join", dir, joined).CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	got, err := os.ReadFile(joined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, append(append([]byte{}, image[:0x80000]...), bios...)) {
+		t.Error("the joined image is not the image with the new BIOS region")
+	}
+
+	// A BIOS region of the wrong size.
+	if err := os.WriteFile(filepath.Join(dir, "bios.bin"), bios[:0x1000], 0o666); err != nil {
+		t.Fatal(err)
+	}
+	if err := testutil.Command(t, "join", dir, joined).Run(); err == nil {
+		t.Error("join with a short BIOS region: got nil, want error")
+	}
+}
+
+func TestMain(m *testing.M) {
+	testutil.Run(m, main)
+}
@@ -6,18 +6,24 @@
 //
 // Synopsis:
 //
-//	glzma -o OUTPUT_FILE (-d|-e) [-f86] INPUT_FILE
+//	glzma [-o OUTPUT_FILE] (-d|-e) [-f86] [-dict SIZE] [INPUT_FILE]
 //
 // Options:
 //
 //	-d: decode
 //	-e: encode
 //	-f86: Use the x86 branch/call/jump filter. See `man xz` for more information.
-//	-o OUTPUT_FILE: output file
+//	-dict SIZE: dictionary size for encoding, in bytes, e.g. 0x400000. The
+//	            internal lzma implementation is then used.
+//	-o OUTPUT_FILE: output file, the standard output if unset or "-"
+//
+// The input is read from the standard input if INPUT_FILE is unset or "-",
+// so glzma can be used as a filter.
 package main
 
 import (
 	"flag"
+	"io"
 	"os"
 
 	"github.com/linuxboot/fiano/pkg/compression"
@@ -25,10 +31,11 @@ import (
 )
 
 var (
-	d   = flag.Bool("d", false, "decode")
-	e   = flag.Bool("e", false, "encode")
-	f86 = flag.Bool("f86", false, "use x86 extension")
-	o   = flag.String("o", "", "output file")
+	d    = flag.Bool("d", false, "decode")
+	e    = flag.Bool("e", false, "encode")
+	f86  = flag.Bool("f86", false, "use x86 extension")
+	dict = flag.Int("dict", 0, "dictionary size for encoding")
+	o    = flag.String("o", "", "output file")
 )
 
 func main() {
@@ -37,17 +44,19 @@ func main() {
 	if *d == *e {
 		log.Fatalf("either decode (-d) or encode (-e) must be set")
 	}
-	if *o == "" {
-		log.Fatalf("output file must be set")
-	}
-	if flag.NArg() != 1 {
-		log.Fatalf("expected one input file")
+	if flag.NArg() > 1 {
+		log.Fatalf("expected at most one input file")
 	}
 
 	var compressor compression.Compressor
-	if *f86 {
+	switch {
+	case *dict != 0 && *f86:
+		compressor = compression.NewLZMAX86(&compression.LZMA{DictCap: *dict})
+	case *dict != 0:
+		compressor = &compression.LZMA{DictCap: *dict}
+	case *f86:
 		compressor = compression.CompressorFromGUID(&compression.LZMAX86GUID)
-	} else {
+	default:
 		compressor = compression.CompressorFromGUID(&compression.LZMAGUID)
 	}
 
@@ -58,7 +67,13 @@ func main() {
 		op = compressor.Encode
 	}
 
-	in, err := os.ReadFile(flag.Args()[0])
+	var in []byte
+	var err error
+	if flag.NArg() == 0 || flag.Arg(0) == "-" {
+		in, err = io.ReadAll(os.Stdin)
+	} else {
+		in, err = os.ReadFile(flag.Arg(0))
+	}
 	if err != nil {
 		log.Fatalf("%v", err)
 	}
@@ -66,7 +81,12 @@ func main() {
 	if err != nil {
 		log.Fatalf("%v", err)
 	}
-	if err := os.WriteFile(*o, out, 0666); err != nil {
+	if *o == "" || *o == "-" {
+		_, err = os.Stdout.Write(out)
+	} else {
+		err = os.WriteFile(*o, out, 0666)
+	}
+	if err != nil {
 		log.Fatalf("%v", err)
 	}
 }
@@ -0,0 +1,56 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/testutil"
+)
+
+func TestFilter(t *testing.T) {
+	want := bytes.Repeat([]byte("\xe8\x00\x10\x00\x00FIANO ROCKS!"), 1000)
+	for _, tt := range []struct {
+		args []string
+		dict uint32
+	}{
+		{nil, 0},
+		{[]string{"-f86"}, 0},
+		{[]string{"-dict", "0x10000"}, 0x10000},
+		{[]string{"-f86", "-dict", "0x10000"}, 0x10000},
+	} {
+		args := tt.args
+		enc := testutil.Command(t, append([]string{"-e"}, args...)...)
+		enc.Stdin = bytes.NewReader(want)
+		encoded, err := enc.Output()
+		if err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		// The LZMA header holds the dictionary size after the properties.
+		if got := binary.LittleEndian.Uint32(encoded[1:]); tt.dict != 0 && got != tt.dict {
+			t.Errorf("%v: got dictionary size %#x, want %#x", args, got, tt.dict)
+		}
+
+		in := filepath.Join(t.TempDir(), "in.lzma")
+		if err := os.WriteFile(in, encoded, 0o666); err != nil {
+			t.Fatal(err)
+		}
+		got, err := testutil.Command(t, append(append([]string{"-d", "-o", "-"}, args...), in)...).Output()
+		if err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%v: decoding did not restore the input", args)
+		}
+	}
+}
+
+func TestMain(m *testing.M) {
+	testutil.Run(m, main)
+}
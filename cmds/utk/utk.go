@@ -7,6 +7,10 @@
 // Synopsis:
 //
 //	utk BIOS OPERATIONS...
+//	utk -i BIOS
+//	utk -f SCRIPT BIOS
+//	utk diff OLD NEW
+//	utk -batch DIR [-j JOBS] [-reports DIR] OPERATIONS...
 //
 // Examples:
 //
@@ -22,6 +26,9 @@
 //	# Extract everything into a directory:
 //	utk winterfell.rom extract winterfell/
 //
+//	# Extract everything laid out as a UEFIExtract dump:
+//	utk winterfell.rom extract-uefitool winterfell.rom.dump/
+//
 //	# Re-assemble the directory into an image:
 //	utk winterfell/ save winterfell2.rom
 //
@@ -32,6 +39,113 @@
 //	  replace_pe32 Shell linux.efi \
 //	  save winterfell2.rom
 //
+//	# Record the modifications to a script and replay them on another image:
+//	utk winterfell.rom record fixes.json remove Shell replace_pe32 DxeCore dxe.efi
+//	utk tioga.rom replay fixes.json save tioga2.rom
+//
+//	# Parse the image once and run operations interactively:
+//	utk -i winterfell.rom
+//	utk> find Shell
+//	utk> remove Shell
+//	utk> save winterfell2.rom
+//
+//	# Run the operations from a script file, one or more per line. Lines
+//	# starting with "#" are comments, NAME=VALUE sets a variable which is
+//	# referenced as $NAME or ${NAME}. $IMAGE is the image file name:
+//	utk -f fixes.utk winterfell.rom
+//
+//	# Open the flash image of an Insyde update file, either an iFlash
+//	# container such as isflash.bin or an iFdPacker archive:
+//	utk BIOSUpdate.exe extract bios/
+//
+//	# Read the flash chip with flashrom, modify it and write it back:
+//	utk flashrom:internal remove Shell save flashrom:internal
+//
+//	# Write only the flash descriptor regions which the operations modified:
+//	utk old.rom remove Shell save new.rom flashrom-layout-changed old.rom > layout
+//	flashrom -p internal -l layout $(awk '{print "-i", $2}' layout) -w new.rom
+//
+//	# Download the image over HTTPS or ssh, checking the checksum given by
+//	# the URL fragment, or without any checksum with -allow-unverified:
+//	utk https://ci.example.com/winterfell.rom#sha256=HEX table
+//	utk -allow-unverified ssh://builder@ci.example.com/srv/roms/winterfell.rom table
+//
+//	# Parse an image held by two flash chips, modify it and write each chip
+//	# back, or save the rejoined image:
+//	utk chip0.rom+chip1.rom remove Shell save-chips chip0-new.rom chip1-new.rom
+//	utk chip0.rom+chip1.rom save joined.rom
+//
+//	# Read the image from stdin and write the modified image to stdout:
+//	cat winterfell.rom | utk - remove Shell save - > winterfell2.rom
+//
+//	# Compare the regions, volumes and files of two images:
+//	utk diff winterfell.rom winterfell2.rom
+//
+//	# Run the checksum, FIT, BootGuard/CBnT and AMD PSB checks which apply
+//	# to the image and summarize pass or fail per subsystem:
+//	utk winterfell.rom verify
+//
+//	# Also check that every compressed section is compressed again into the
+//	# same bytes, so that the image can be reassembled bit-exact:
+//	utk -verify-compression winterfell.rom verify
+//
+//	# List the ranges of the image which neither the BootGuard IBB nor the
+//	# AMD PSB RTM signature protects:
+//	utk winterfell.rom coverage
+//
+//	# Estimate the dispatch order of the DXE drivers once Shell is removed,
+//	# and list the drivers whose DEPEX would no longer be satisfied:
+//	utk winterfell.rom remove Shell dispatch-order
+//
+//	# Run the same operations on every image of a directory, four at a
+//	# time, writing the output of each to reports/IMAGE.report:
+//	utk -batch dumps/ -j 4 -format json verify
+//
+//	# Report the regions, volumes and files which the operations would
+//	# change, with their sizes and offsets, without saving the image:
+//	utk -dry-run winterfell.rom remove Shell save winterfell2.rom
+//
+//	# Load the commands of an out of tree plugin, see visitors.LoadPlugin
+//	# for the protocol:
+//	utk -plugin /opt/acme/utk-sign winterfell.rom acme_sign key.pem save signed.rom
+//
+//	# Show the progress of parsing and assembling a large image:
+//	utk -progress big.rom remove Shell save big2.rom
+//
+//	# Compress with the internal compressors and fixed parameters rather
+//	# than the system xz and brotli, so that the image is reproducible:
+//	utk -deterministic winterfell/ save winterfell2.rom
+//
+//	# Name the GUIDs of the files after their UI sections in one image, and
+//	# find the files of another image, whose UI sections were stripped, by
+//	# those names:
+//	utk winterfell.rom learn-names winterfell.guids
+//	utk -guids winterfell.guids tioga.rom find Shell
+//
+//	# Show the source modules, INF paths and build-time addresses of the
+//	# files of an in-house build, from its EDK2 build report and FV maps:
+//	utk -build-report Build/BuildReport.txt -build-report Build/FV/DXEFV.Fv.map OVMF.rom table
+//	utk -build-report Build/BuildReport.txt OVMF.rom find MdeModulePkg/Universal/PCD/Dxe/Pcd.inf
+//
+//	# List the regions, modules, microcode updates, FSP components and EC
+//	# firmware with their versions and hashes as an SPDX or CycloneDX SBOM:
+//	utk winterfell.rom sbom spdx > winterfell.spdx.json
+//
+//	# Print the versions of the EC firmware of the EC region and of the
+//	# firmware bundled in the BIOS region:
+//	utk winterfell.rom ec
+//
+//	# Provision attestation verifiers with the BootGuard and AMD PSB reference
+//	# values of a golden image and the digest of its BIOS region, as a CoRIM:
+//	utk winterfell.rom corim-regions winterfell.corim winterfell bios
+//
+//	# Browse the image in a web browser at http://localhost:8080/, see
+//	# visitors.Serve for the JSON API:
+//	utk winterfell.rom serve localhost:8080
+//
+//	# Print the output of any operation as JSON or YAML:
+//	utk --format=yaml winterfell.rom table
+//
 // Operations:
 //
 //	`json`: Dump the entire parsed image (excluding binary data) as JSON to
@@ -40,7 +154,15 @@
 //	         consumption and the format may change without notice.
 //	`find (GUID|NAME)`: Dump the JSON of one or more files. The file is
 //	                    found by a regex match to its GUID or name in the UI
-//	                    section.
+//	                    section, or by the known name of its GUID, which
+//	                    may be partial, for files without a UI section.
+//	`hexdump (GUID|NAME|PATH)`: Print the content of a node as "hexdump -C"
+//	                            does, with the flash offsets on the left.
+//	                            The node is a file matched as in `find`, or
+//	                            the node at a tree path as printed in the
+//	                            errors, such as "FV GUID/File GUID".
+//	`hexdump-range (GUID|NAME|PATH) START LENGTH`: Print LENGTH bytes of
+//	                            the node from START, as `hexdump`.
 //	`remove (GUID|NAME)`: Remove the first file which matches the given GUID
 //	                      or NAME. The same matching rules and exit status
 //	                      are used as `find`.
@@ -51,44 +173,158 @@
 //	`save FILE`: Save the current state of the image to the give file.
 //	             Remember that operations are applied left-to-right, so only
 //	             the operations to the left are included in the new image.
+//	             A FILE of the form flashrom:PROGRAMMER writes the image to
+//	             the flash chip with flashrom. The same form can be used in
+//	             place of the BIOS file name to read the flash chip. A FILE
+//	             of "-" writes the image to stdout, and a BIOS file name of
+//	             "-" reads the image from stdin.
 //	`extract DIR`: Extract the BIOS to the given directory. Remember that
 //	               operations are applied left-to-right, so only the
 //	               operations to the left are included in the new image.
+//	`extract-tar ARCHIVE`: Extract the BIOS to the given tar archive, laid
+//	                       out as the directory of extract. The archive is
+//	                       compressed with gzip if it ends with .gz or .tgz,
+//	                       and "-" writes it to stdout.
+//	`extract-uefitool DIR`: Extract every node to the given directory laid
+//	                        out as UEFIExtract does, with header.bin,
+//	                        body.bin and info.txt in a directory per node.
+//	                        The image cannot be reassembled from it.
+//
+// Exit status:
+//
+//	0: success
+//	1: unclassified error
+//	2: invalid flags or operations
+//	3: the image cannot be parsed
+//	4: the image failed validation
+//	5: an operation failed
+//	6: a file or the flash chip cannot be read or written
+//
+// With -error-json, the error is printed to stderr as JSON, with its kind,
+// exit code and the node of the image it is about, when known.
 package main
 
 import (
 	"flag"
 	"fmt"
+	"os"
 	"strconv"
+	"strings"
 
+	"github.com/linuxboot/fiano/pkg/buildreport"
+	"github.com/linuxboot/fiano/pkg/knownguids"
 	"github.com/linuxboot/fiano/pkg/log"
+	"github.com/linuxboot/fiano/pkg/remote"
 	"github.com/linuxboot/fiano/pkg/uefi"
 	"github.com/linuxboot/fiano/pkg/utk"
 	"github.com/linuxboot/fiano/pkg/visitors"
 )
 
+// stringList is a flag which may be repeated.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
 type config struct {
 	ErasePolarity *byte
+	Interactive   bool
+	Script        string
+	ErrorJSON     bool
+	Batch         string
+	Jobs          int
+	Reports       string
+	Progress      bool
+
+	// Flags are passed on to the utk processes run in batch mode.
+	Flags []string
 }
 
 func parseArguments() (config, []string, error) {
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), "Usage: utk [flags] <file name> [0 or more operations]\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "       utk -i [flags] <file name>\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "       utk -f <script> [flags] <file name>\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "       utk -batch <directory> [flags] [0 or more operations]\n")
 		flag.PrintDefaults()
 		fmt.Fprintf(flag.CommandLine.Output(), "\nOperations:\n%s", visitors.ListCLI())
 	}
 	erasePolarityFlag := flag.String("erase-polarity", "", "set erase polarity; possible values: '', '0x00', '0xFF'")
+	interactiveFlag := flag.Bool("i", false, "parse the image once and read operations from stdin")
+	scriptFlag := flag.String("f", "", "parse the image once and run the operations from the given script file")
+	errorJSONFlag := flag.Bool("error-json", false, "print the error to stderr as JSON, with its kind and exit code")
+	formatFlag := flag.String("format", visitors.FormatText, "output format of the operations; possible values: 'text', 'json', 'yaml'")
+	batchFlag := flag.String("batch", "", "run the operations on every image of the given directory")
+	jobsFlag := flag.Int("j", 1, "number of images processed in parallel in batch mode")
+	dryRunFlag := flag.Bool("dry-run", false, "run the operations without writing the image or any other file, and report the regions, volumes and files they change")
+	verifyCompressionFlag := flag.Bool("verify-compression", false, "make verify decompress and compress again every compressed section, and fail if the stored data is not reproduced")
+	var plugins stringList
+	flag.Var(&plugins, "plugin", "load the commands of the given plugin executable; may be repeated")
+	progressFlag := flag.Bool("progress", false, "draw the progress of parsing, decompression, validation and assembly on stderr")
+	reportsFlag := flag.String("reports", "reports", "directory receiving the output of each image in batch mode")
+	allowUnverifiedFlag := flag.Bool("allow-unverified", false, "download the image URLs which give no #sha256= checksum")
+	guidsFlag := flag.String("guids", "", "name the GUIDs listed in the given file, as written by learn-names")
+	var buildReports stringList
+	flag.Var(&buildReports, "build-report", "show the source modules of the files listed in the given EDK2 build report or FV map; may be repeated")
 	flag.Parse()
-	if len(flag.Args()) == 0 || flag.Args()[0] == "help" {
+	// Plugins register their commands, which the usage lists.
+	for _, p := range plugins {
+		if err := visitors.LoadPlugin(p); err != nil {
+			return config{}, nil, err
+		}
+	}
+	if (len(flag.Args()) == 0 && *batchFlag == "") || (len(flag.Args()) != 0 && flag.Args()[0] == "help") {
 		flag.Usage()
 	}
 
-	var cfg config
+	cfg := config{
+		Interactive: *interactiveFlag,
+		Script:      *scriptFlag,
+		ErrorJSON:   *errorJSONFlag,
+		Batch:       *batchFlag,
+		Jobs:        *jobsFlag,
+		Reports:     *reportsFlag,
+		Progress:    *progressFlag,
+		Flags:       []string{"-format", *formatFlag, "-erase-polarity", *erasePolarityFlag, fmt.Sprintf("-dry-run=%t", *dryRunFlag), fmt.Sprintf("-verify-compression=%t", *verifyCompressionFlag), fmt.Sprintf("-allow-unverified=%t", *allowUnverifiedFlag)},
+	}
+	for _, p := range plugins {
+		cfg.Flags = append(cfg.Flags, "-plugin", p)
+	}
+	if *guidsFlag != "" {
+		cfg.Flags = append(cfg.Flags, "-guids", *guidsFlag)
+		if err := learnGUIDs(*guidsFlag); err != nil {
+			return cfg, nil, err
+		}
+	}
+	for _, p := range buildReports {
+		cfg.Flags = append(cfg.Flags, "-build-report", p)
+		if err := learnBuildReport(p); err != nil {
+			return cfg, nil, err
+		}
+	}
+	visitors.DryRun = *dryRunFlag
+	visitors.VerifyCompressionRoundTrip = *verifyCompressionFlag
+	remote.AllowUnverified = *allowUnverifiedFlag
+	if err := visitors.SetOutputFormat(*formatFlag); err != nil {
+		return cfg, nil, err
+	}
+	if cfg.Interactive && cfg.Script != "" {
+		return cfg, nil, fmt.Errorf("-i and -f cannot be used together")
+	}
+	if cfg.Batch != "" && (cfg.Interactive || cfg.Script != "") {
+		return cfg, nil, fmt.Errorf("-batch cannot be used with -i or -f")
+	}
 
 	if *erasePolarityFlag != "" {
 		erasePolarity, err := strconv.ParseUint(*erasePolarityFlag, 0, 8)
 		if err != nil {
-			return config{}, nil, fmt.Errorf("unable to parse erase polarity '%s': %w", *erasePolarityFlag, err)
+			return cfg, nil, fmt.Errorf("unable to parse erase polarity '%s': %w", *erasePolarityFlag, err)
 		}
 		cfg.ErasePolarity = &[]uint8{uint8(erasePolarity)}[0]
 	}
@@ -96,19 +332,116 @@ func parseArguments() (config, []string, error) {
 	return cfg, flag.Args(), nil
 }
 
-func main() {
-	cfg, args, err := parseArguments()
+// learnGUIDs adds the names of the GUIDs in the mapping file to the known
+// GUIDs.
+func learnGUIDs(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	names, err := knownguids.ReadNames(f)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("%s: %w", path, err)
 	}
+	knownguids.Learn(names)
+	return nil
+}
 
+// learnBuildReport adds the modules of the build report or FV map to
+// visitors.BuildReport, and their names to the known GUIDs.
+func learnBuildReport(path string) error {
+	r, err := buildreport.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if visitors.BuildReport == nil {
+		visitors.BuildReport = buildreport.Report{}
+	}
+	visitors.BuildReport.Merge(r)
+	knownguids.Learn(r.Names())
+	return nil
+}
+
+func run(cfg config, args []string) error {
 	if cfg.ErasePolarity != nil {
 		if err := uefi.SetErasePolarity(*cfg.ErasePolarity); err != nil {
-			panic(fmt.Errorf("unable to set erase polarity 0x%X: %w", *cfg.ErasePolarity, err))
+			return &utk.Error{Kind: utk.KindUsage, Err: fmt.Errorf("unable to set erase polarity 0x%X: %w", *cfg.ErasePolarity, err)}
+		}
+	}
+
+	if cfg.Batch != "" {
+		return runBatch(cfg, args)
+	}
+
+	if cfg.Progress {
+		bar := utk.NewProgressBar(os.Stderr)
+		uefi.SetProgress(bar.Report)
+		defer bar.Finish()
+	}
+
+	if cfg.Interactive {
+		if len(args) != 1 {
+			return &utk.Error{Kind: utk.KindUsage, Err: fmt.Errorf("interactive mode takes exactly one file name, got %d arguments", len(args))}
+		}
+		if args[0] == visitors.StdioPath {
+			return &utk.Error{Kind: utk.KindUsage, Err: fmt.Errorf("interactive mode reads the operations from stdin, the image cannot be read from stdin")}
+		}
+		return utk.Interactive(args[0], os.Stdin, os.Stdout)
+	}
+
+	if cfg.Script != "" {
+		if len(args) != 1 {
+			return &utk.Error{Kind: utk.KindUsage, Err: fmt.Errorf("script mode takes exactly one file name, got %d arguments", len(args))}
 		}
+		return utk.RunScriptFile(args[0], cfg.Script)
 	}
 
-	if err := utk.Run(args...); err != nil {
-		log.Fatalf("%v", err)
+	return utk.Run(args...)
+}
+
+func runBatch(cfg config, args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return &utk.Error{Kind: utk.KindIO, Err: err}
+	}
+	b := &utk.Batch{
+		Command:    append([]string{exe}, cfg.Flags...),
+		Dir:        cfg.Batch,
+		ReportDir:  cfg.Reports,
+		Jobs:       cfg.Jobs,
+		Operations: args,
+	}
+	results, err := b.Run()
+	for _, r := range results {
+		status := "ok"
+		if r.ExitCode != utk.ExitOK {
+			status = fmt.Sprintf("exit code %d", r.ExitCode)
+		}
+		fmt.Printf("%s\t%s\t%s\n", r.Image, status, r.Report)
+	}
+	return err
+}
+
+func main() {
+	cfg, args, err := parseArguments()
+	if err != nil {
+		err = &utk.Error{Kind: utk.KindUsage, Err: err}
+	} else {
+		err = run(cfg, args)
+	}
+	if err == nil {
+		return
+	}
+
+	if cfg.ErrorJSON {
+		b, jsonErr := utk.ErrorJSON(err)
+		if jsonErr != nil {
+			log.Fatalf("%v", err)
+		}
+		fmt.Fprintln(os.Stderr, string(b))
+	} else {
+		log.Errorf("%v", err)
 	}
+	os.Exit(utk.ExitCode(err))
 }
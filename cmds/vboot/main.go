@@ -0,0 +1,186 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// vboot inspects the verified boot structures of ChromeOS-style images.
+//
+// Synopsis:
+//
+//	vboot [-j] gbb FILE
+//	vboot setgbb NAME=VALUE,... FILE
+//	vboot [-j] verify FILE
+//
+// Description:
+//
+//	gbb:    Print the hardware ID, flags and keys of the GBB.
+//	setgbb: Set the flags, hardware ID, root key or recovery key of the
+//	        GBB, e.g. flags=FORCE_DEV_SWITCH_ON|0x8,hwid=EVE TEST,
+//	        rootkey=root_key.vbpubk, and print the GBB. Keys are read from
+//	        packed key files. The HWID digest is updated. FILE is modified
+//	        in place.
+//	verify: Verify the RW firmware slots with the keys of the GBB and print
+//	        which slots verify and their versions. Exit with 1 if none does.
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/fmap"
+	"github.com/linuxboot/fiano/pkg/log"
+	"github.com/linuxboot/fiano/pkg/vboot"
+)
+
+var (
+	flagJSON = flag.Bool("j", false, "Output as JSON")
+)
+
+func printJSON(v interface{}) {
+	j, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	fmt.Println(string(j))
+}
+
+func keySummary(k *vboot.PackedKey) string {
+	return fmt.Sprintf("%v, version %d, sha1sum %x", k.Algorithm, k.KeyVersion, sha1.Sum(k.Data))
+}
+
+// readGBB returns the GBB area of the image and the GBB parsed from it.
+func readGBB(image []byte) ([]byte, *vboot.GBB) {
+	f, _, err := fmap.Read(bytes.NewReader(image))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	i := f.IndexOfArea("GBB")
+	if i < 0 {
+		log.Fatalf("FMAP area %q not found", "GBB")
+	}
+	a := f.Areas[i]
+	if uint64(a.Offset)+uint64(a.Size) > uint64(len(image)) {
+		log.Fatalf("GBB area [%#x, %#x) is outside of the image", a.Offset, uint64(a.Offset)+uint64(a.Size))
+	}
+	b := image[a.Offset : a.Offset+a.Size]
+	g, err := vboot.ParseGBB(b)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	return b, g
+}
+
+func printGBB(g *vboot.GBB) {
+	if *flagJSON {
+		printJSON(g)
+		return
+	}
+	fmt.Printf("Version      : %d.%d\n", g.MajorVersion, g.MinorVersion)
+	fmt.Printf("HWID         : %s\n", g.HWID)
+	fmt.Printf("Flags        : %#x %v\n", uint32(g.Flags), g.Flags)
+	fmt.Printf("Root key     : %s\n", keySummary(g.RootKey))
+	fmt.Printf("Recovery key : %s\n", keySummary(g.RecoveryKey))
+}
+
+func gbb(image []byte) {
+	_, g := readGBB(image)
+	printGBB(g)
+}
+
+func setGBB(image []byte, settings, file string) {
+	b, g := readGBB(image)
+	for _, s := range strings.Split(settings, ",") {
+		name, val, ok := strings.Cut(s, "=")
+		if !ok {
+			log.Fatalf("want NAME=VALUE, got %q", s)
+		}
+		var err error
+		switch strings.ToLower(name) {
+		case "flags":
+			var flags vboot.GBBFlags
+			if flags, err = vboot.ParseGBBFlags(val); err == nil {
+				err = g.SetFlags(b, flags)
+			}
+		case "hwid":
+			err = g.SetHWID(b, val)
+		case "rootkey":
+			var key []byte
+			if key, err = os.ReadFile(val); err == nil {
+				err = g.SetRootKey(b, key)
+			}
+		case "recoverykey":
+			var key []byte
+			if key, err = os.ReadFile(val); err == nil {
+				err = g.SetRecoveryKey(b, key)
+			}
+		default:
+			err = errors.New("unknown GBB setting, want flags, hwid, rootkey or recoverykey")
+		}
+		if err != nil {
+			log.Fatalf("%s: %v", name, err)
+		}
+	}
+	if err := os.WriteFile(file, image, 0o666); err != nil {
+		log.Fatalf("%v", err)
+	}
+	printGBB(g)
+}
+
+func verify(image []byte) {
+	results, err := vboot.VerifyImage(image)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	verified := false
+	for _, r := range results {
+		verified = verified || r.Verified
+	}
+	if *flagJSON {
+		printJSON(results)
+	} else {
+		for _, r := range results {
+			status := "FAIL"
+			if r.Verified {
+				status = "OK (" + r.Key + " key)"
+			}
+			fmt.Printf("Slot %s: %s, data key version %d, firmware version %d\n", r.Slot, status, r.DataKeyVersion, r.FirmwareVersion)
+			if r.Error != "" {
+				fmt.Printf("    %s\n", r.Error)
+			}
+		}
+	}
+	if !verified {
+		os.Exit(1)
+	}
+}
+
+func main() {
+	flag.Parse()
+	nArgs := 2
+	if flag.Arg(0) == "setgbb" {
+		nArgs = 3
+	}
+	if flag.NArg() != nArgs {
+		log.Fatalf("usage: vboot [-j] gbb|verify FILE or vboot setgbb NAME=VALUE,... FILE")
+	}
+	file := flag.Arg(nArgs - 1)
+	image, err := os.ReadFile(file)
+	if err != nil {
+		log.Fatalf("cannot read file: %v", err)
+	}
+	switch flag.Arg(0) {
+	case "gbb":
+		gbb(image)
+	case "setgbb":
+		setGBB(image, flag.Arg(1), file)
+	case "verify":
+		verify(image)
+	default:
+		log.Fatalf("unknown command %q", flag.Arg(0))
+	}
+}
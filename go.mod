@@ -3,6 +3,7 @@ module github.com/linuxboot/fiano
 go 1.21
 
 require (
+	github.com/andybalholm/brotli v1.0.5
 	github.com/dustin/go-humanize v1.0.0
 	github.com/fatih/camelcase v1.0.0
 	github.com/hashicorp/go-multierror v1.1.1
@@ -18,6 +19,7 @@ require (
 	github.com/xaionaro-go/bytesextra v0.0.0-20220103144954-846e454ddea9
 	github.com/xaionaro-go/gosrc v0.0.0-20201124181305-3fdf8476a735
 	golang.org/x/text v0.6.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -31,5 +33,4 @@ require (
 	github.com/xaionaro-go/unsafetools v0.0.0-20210722164218-75ba48cf7b3c // indirect
 	golang.org/x/sys v0.4.0 // indirect
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
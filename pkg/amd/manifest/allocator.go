@@ -0,0 +1,197 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package manifest
+
+import (
+	"errors"
+	"fmt"
+
+	bytes2 "github.com/linuxboot/fiano/pkg/bytes"
+)
+
+// EntryAlignment is the default alignment of the payloads of new PSP and BIOS
+// directory entries, the alignment amdfwtool uses for blobs.
+const EntryAlignment = 0x100
+
+// ErasedByte is the value of the bytes of erased flash, which the allocator
+// considers free when no structure owns them.
+const ErasedByte = 0xff
+
+// ErrNoSpace is returned when no free range of the image can hold a payload.
+var ErrNoSpace = errors.New("no free space left in the image")
+
+// Placement is a range of the image given to the payload of an entry.
+type Placement struct {
+	// Name describes the payload, such as "PSP entry 0x5a".
+	Name string
+	// Range is the range of the payload.
+	Range bytes2.Range
+	// Padding is the number of bytes reserved after the payload up to the
+	// next aligned offset, filled with ErasedByte.
+	Padding uint64
+}
+
+// OwnershipMap returns the ranges of the image owned by the PSP firmware: the
+// embedded firmware structure, the directories and the payloads of their
+// entries. Entries holding a value rather than a location, and entries
+// pointing out of the image, own nothing.
+func (p *PSPFirmware) OwnershipMap(imageSize uint64) bytes2.RangeSet {
+	ranges := []bytes2.Range{
+		p.EmbeddedFirmwareRange,
+		p.PSPDirectoryLevel1Range,
+		p.PSPDirectoryLevel2Range,
+		p.BIOSDirectoryLevel1Range,
+		p.BIOSDirectoryLevel2Range,
+	}
+	for _, table := range []*PSPDirectoryTable{p.PSPDirectoryLevel1, p.PSPDirectoryLevel2} {
+		if table == nil {
+			continue
+		}
+		for _, entry := range table.Entries {
+			ranges = append(ranges, bytes2.Range{Offset: entry.LocationOrValue, Length: uint64(entry.Size)})
+		}
+	}
+	for _, table := range []*BIOSDirectoryTable{p.BIOSDirectoryLevel1, p.BIOSDirectoryLevel2} {
+		if table == nil {
+			continue
+		}
+		for _, entry := range table.Entries {
+			ranges = append(ranges, bytes2.Range{Offset: entry.SourceAddress, Length: uint64(entry.Size)})
+		}
+	}
+	var owned []bytes2.Range
+	for _, r := range ranges {
+		if r.Length != 0 && r.End() > r.Offset && r.End() <= imageSize {
+			owned = append(owned, r)
+		}
+	}
+	return bytes2.NewRangeSet(owned...)
+}
+
+// Allocator finds free ranges of the image for the payloads of new PSP and
+// BIOS directory entries. A range is free when neither the PSP firmware nor
+// an earlier placement owns it and it is erased.
+type Allocator struct {
+	// Alignment of the payloads placed by PlacePSPEntry and PlaceBIOSEntry,
+	// EntryAlignment by default.
+	Alignment uint64
+	// Placements records the ranges given out, in order.
+	Placements []Placement
+
+	image []byte
+	owned bytes2.RangeSet
+}
+
+// NewAllocator returns an allocator of the free space of the image of the
+// firmware.
+func NewAllocator(amdFw *AMDFirmware) *Allocator {
+	image := amdFw.Firmware().ImageBytes()
+	return &Allocator{
+		Alignment: EntryAlignment,
+		image:     image,
+		owned:     amdFw.PSPFirmware().OwnershipMap(uint64(len(image))),
+	}
+}
+
+// Owned returns the ranges of the image owned by the PSP firmware or given
+// out by the allocator.
+func (a *Allocator) Owned() bytes2.RangeSet {
+	return a.owned
+}
+
+// Free returns the ranges of the image which can be allocated: not owned and
+// erased.
+func (a *Allocator) Free() bytes2.RangeSet {
+	var free []bytes2.Range
+	for _, gap := range a.owned.Gaps(bytes2.Range{Length: uint64(len(a.image))}).Ranges() {
+		start := gap.Offset
+		for off := gap.Offset; off <= gap.End(); off++ {
+			if off < gap.End() && a.image[off] == ErasedByte {
+				continue
+			}
+			if off > start {
+				free = append(free, bytes2.Range{Offset: start, Length: off - start})
+			}
+			start = off + 1
+		}
+	}
+	return bytes2.NewRangeSet(free...)
+}
+
+// Reserve marks r as owned, such as a range another tool will write.
+func (a *Allocator) Reserve(r bytes2.Range) error {
+	if r.End() < r.Offset || r.End() > uint64(len(a.image)) {
+		return fmt.Errorf("range %s is out of the image of size %#x", r, len(a.image))
+	}
+	if !a.owned.Intersect(bytes2.NewRangeSet(r)).IsEmpty() {
+		return fmt.Errorf("range %s is already owned", r)
+	}
+	a.owned = a.owned.Union(bytes2.NewRangeSet(r))
+	return nil
+}
+
+// Allocate gives out the first free range of size bytes aligned to
+// alignment, followed by the padding up to the next aligned offset when it
+// is free, and records the placement under name.
+func (a *Allocator) Allocate(name string, size, alignment uint64) (Placement, error) {
+	if size == 0 {
+		return Placement{}, fmt.Errorf("cannot allocate an empty range for %s", name)
+	}
+	if alignment == 0 || alignment&(alignment-1) != 0 {
+		return Placement{}, fmt.Errorf("alignment %#x is not a power of 2", alignment)
+	}
+	for _, free := range a.Free().Ranges() {
+		offset := (free.Offset + alignment - 1) &^ (alignment - 1)
+		if offset+size < offset || offset+size > free.End() {
+			continue
+		}
+		p := Placement{Name: name, Range: bytes2.Range{Offset: offset, Length: size}}
+		if end := (offset + size + alignment - 1) &^ (alignment - 1); end <= free.End() {
+			p.Padding = end - offset - size
+		}
+		a.owned = a.owned.Union(bytes2.NewRangeSet(bytes2.Range{Offset: offset, Length: size + p.Padding}))
+		a.Placements = append(a.Placements, p)
+		return p, nil
+	}
+	return Placement{}, fmt.Errorf("%s: %#x bytes aligned to %#x: %w", name, size, alignment, ErrNoSpace)
+}
+
+// Place allocates a range for data, then writes data and its padding to the
+// image.
+func (a *Allocator) Place(name string, data []byte, alignment uint64) (Placement, error) {
+	p, err := a.Allocate(name, uint64(len(data)), alignment)
+	if err != nil {
+		return Placement{}, err
+	}
+	copy(a.image[p.Range.Offset:], data)
+	for i := p.Range.End(); i < p.Range.End()+p.Padding; i++ {
+		a.image[i] = ErasedByte
+	}
+	return p, nil
+}
+
+// PlacePSPEntry places the payload of entry in the image and sets its
+// location and size.
+func (a *Allocator) PlacePSPEntry(entry *PSPDirectoryTableEntry, data []byte) error {
+	p, err := a.Place(fmt.Sprintf("PSP entry %#x", uint8(entry.Type)), data, a.Alignment)
+	if err != nil {
+		return err
+	}
+	entry.LocationOrValue = p.Range.Offset
+	entry.Size = uint32(p.Range.Length)
+	return nil
+}
+
+// PlaceBIOSEntry places the payload of entry in the image and sets its source
+// address and size.
+func (a *Allocator) PlaceBIOSEntry(entry *BIOSDirectoryTableEntry, data []byte) error {
+	p, err := a.Place(fmt.Sprintf("BIOS entry %#x", uint8(entry.Type)), data, a.Alignment)
+	if err != nil {
+		return err
+	}
+	entry.SourceAddress = p.Range.Offset
+	entry.Size = uint32(p.Range.Length)
+	return nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package manifest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	bytes2 "github.com/linuxboot/fiano/pkg/bytes"
+)
+
+// allocatorTestImage returns an erased image of 1MiB with an embedded
+// firmware structure pointing to a PSP directory at 0x1000, whose only entry
+// has a payload of 0x300 bytes at 0x2000.
+func allocatorTestImage(t *testing.T) FirmwareImage {
+	image := FirmwareImage(bytes.Repeat([]byte{ErasedByte}, 1<<20))
+	efs := EmbeddedFirmwareStructure{Signature: EmbeddedFirmwareStructureSignature, PSPDirectoryTablePointer: 0x1000}
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, efs); err != nil {
+		t.Fatal(err)
+	}
+	copy(image[image.PhysAddrToOffset(0xfffa0000):], buf.Bytes())
+
+	buf.Reset()
+	header := PSPDirectoryTableHeader{PSPCookie: PSPDirectoryTableCookie, TotalEntries: 1}
+	entry := []byte{
+		byte(PSPBootloaderFirmwareEntry), 0, 0, 0,
+		0x00, 0x03, 0x00, 0x00,
+		0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	if err := binary.Write(&buf, binary.LittleEndian, header); err != nil {
+		t.Fatal(err)
+	}
+	copy(image[0x1000:], append(buf.Bytes(), entry...))
+	copy(image[0x2000:], bytes.Repeat([]byte{0x42}, 0x300))
+	return image
+}
+
+func TestAllocator(t *testing.T) {
+	image := allocatorTestImage(t)
+	amdFw, err := NewAMDFirmware(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewAllocator(amdFw)
+
+	owned := a.Owned().Ranges()
+	want := bytes2.Ranges{{Offset: 0x1000, Length: 0x20}, {Offset: 0x2000, Length: 0x300}, {Offset: 0xa0000, Length: 0x4a}}
+	if owned.String() != want.String() {
+		t.Errorf("owned: got %s, want %s", owned, want)
+	}
+
+	// The payload does not fit before the directory at 0x1000 nor between
+	// the directory and the payload at 0x2000, so it goes after the payload.
+	entry := PSPDirectoryTableEntry{Type: AMDPublicKeyEntry}
+	if err := a.PlacePSPEntry(&entry, bytes.Repeat([]byte{1}, 0x1010)); err != nil {
+		t.Fatal(err)
+	}
+	if entry.LocationOrValue != 0x2300 || entry.Size != 0x1010 {
+		t.Errorf("PSP entry: got location %#x size %#x", entry.LocationOrValue, entry.Size)
+	}
+	if !bytes.Equal(image[0x2300:0x3310], bytes.Repeat([]byte{1}, 0x1010)) {
+		t.Error("PSP entry payload is not written")
+	}
+
+	// The first free range is at the start of the image.
+	biosEntry := BIOSDirectoryTableEntry{Type: APCBDataEntry}
+	if err := a.PlaceBIOSEntry(&biosEntry, bytes.Repeat([]byte{2}, 0x100)); err != nil {
+		t.Fatal(err)
+	}
+	if biosEntry.SourceAddress != 0 || biosEntry.Size != 0x100 {
+		t.Errorf("BIOS entry: got source %#x size %#x", biosEntry.SourceAddress, biosEntry.Size)
+	}
+
+	// Data which is not erased is not free.
+	image[0x100] = 0
+	p, err := a.Allocate("blob", 0x10, 0x10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Range.Offset != 0x110 {
+		t.Errorf("blob: got %s", p.Range)
+	}
+	p, err = a.Allocate("aligned", 0x800, 0x1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Range.Offset != 0x4000 || p.Padding != 0x800 {
+		t.Errorf("aligned: got %s padding %#x", p.Range, p.Padding)
+	}
+	if len(a.Placements) != 4 || a.Placements[0].Name != "PSP entry 0x0" || a.Placements[0].Padding != 0xf0 || a.Placements[1].Padding != 0 {
+		t.Errorf("placements: got %+v", a.Placements)
+	}
+
+	if err := a.Reserve(bytes2.Range{Offset: 0x3300, Length: 0x10}); err == nil {
+		t.Error("reserving an owned range succeeded")
+	}
+	if err := a.Reserve(bytes2.Range{Offset: 0x3400, Length: 0x10}); err != nil {
+		t.Error(err)
+	}
+	if _, err := a.Allocate("huge", 1<<20, 0x100); !errors.Is(err, ErrNoSpace) {
+		t.Errorf("huge: got %v, want %v", err, ErrNoSpace)
+	}
+	if _, err := a.Allocate("misaligned", 0x10, 3); err == nil {
+		t.Error("allocating with an alignment of 3 succeeded")
+	}
+}
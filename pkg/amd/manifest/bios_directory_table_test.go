@@ -139,3 +139,10 @@ func TestBrokenTotalEntriesBiosDirectoryParsing(t *testing.T) {
 		t.Errorf("expected error when parsing incorrect psp directory table contents")
 	}
 }
+
+func FuzzParseBIOSDirectoryTable(f *testing.F) {
+	f.Add(biosDirectoryTableDataChunk)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, _, _ = ParseBIOSDirectoryTable(data)
+	})
+}
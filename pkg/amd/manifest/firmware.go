@@ -8,6 +8,7 @@ import (
 	"fmt"
 
 	bytes2 "github.com/linuxboot/fiano/pkg/bytes"
+	"github.com/linuxboot/fiano/pkg/log"
 )
 
 // Firmware is an abstraction of a firmware image, obtained for example via flashrom
@@ -75,6 +76,8 @@ func parsePSPFirmware(firmware Firmware) (*PSPFirmware, error) {
 		if err == nil {
 			pspDirectoryLevel1Range.Offset = uint64(efs.PSPDirectoryTablePointer)
 			pspDirectoryLevel1Range.Length = length
+		} else {
+			log.With(log.F("offset", fmt.Sprintf("%#x", efs.PSPDirectoryTablePointer))).Debugf("no PSP directory at the EFS pointer, searching the image: %v", err)
 		}
 	}
 	if pspDirectoryLevel1 == nil {
@@ -94,6 +97,8 @@ func parsePSPFirmware(firmware Firmware) (*PSPFirmware, error) {
 					result.PSPDirectoryLevel2 = pspDirectoryLevel2
 					result.PSPDirectoryLevel2Range.Offset = entry.LocationOrValue
 					result.PSPDirectoryLevel2Range.Length = length
+				} else {
+					log.With(log.F("offset", fmt.Sprintf("%#x", entry.LocationOrValue))).Debugf("skipping the level 2 PSP directory: %v", err)
 				}
 			}
 			break
@@ -116,6 +121,7 @@ func parsePSPFirmware(firmware Firmware) (*PSPFirmware, error) {
 		var length uint64
 		biosDirectoryLevel1, length, err = ParseBIOSDirectoryTable(image[offset:])
 		if err != nil {
+			log.With(log.F("offset", fmt.Sprintf("%#x", offset))).Debugf("no BIOS directory at the EFS pointer: %v", err)
 			continue
 		}
 		biosDirectoryLevel1Range.Offset = uint64(offset)
@@ -141,6 +147,8 @@ func parsePSPFirmware(firmware Firmware) (*PSPFirmware, error) {
 					result.BIOSDirectoryLevel2 = biosDirectoryLevel2
 					result.BIOSDirectoryLevel2Range.Offset = entry.SourceAddress
 					result.BIOSDirectoryLevel2Range.Length = length
+				} else {
+					log.With(log.F("offset", fmt.Sprintf("%#x", entry.SourceAddress))).Debugf("skipping the level 2 BIOS directory: %v", err)
 				}
 			}
 			break
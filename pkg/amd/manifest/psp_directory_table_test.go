@@ -114,3 +114,10 @@ func TestBrokenTotalEntriesPspDirectoryParsing(t *testing.T) {
 		t.Errorf("expected error when parsing incorrect psp directory table contents")
 	}
 }
+
+func FuzzParsePSPDirectoryTable(f *testing.F) {
+	f.Add(pspDirectoryTableDataChunk)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, _, _ = ParsePSPDirectoryTable(data)
+	})
+}
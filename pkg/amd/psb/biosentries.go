@@ -227,6 +227,38 @@ func ValidateRTM(amdFw *amd_manifest.AMDFirmware, biosLevel uint) (*SignatureVal
 	return &SignatureValidationResult{signedElement: "RTM Volume concatenated with BIOS Directory", signingKey: oemKey, err: err}, nil
 }
 
+// RTMRanges returns the ranges of the image which are covered by the RTM
+// volume signature of the BIOS directory of level biosLevel: the RTM volume
+// and the BIOS directory, as well as the level 1 BIOS directory for level 2,
+// see ValidateRTM.
+func RTMRanges(amdFw *amd_manifest.AMDFirmware, biosLevel uint) (bytes2.Ranges, error) {
+	pspFw := amdFw.PSPFirmware()
+
+	var ranges bytes2.Ranges
+	switch biosLevel {
+	case 1:
+		ranges = bytes2.Ranges{pspFw.BIOSDirectoryLevel1Range}
+	case 2:
+		ranges = bytes2.Ranges{pspFw.BIOSDirectoryLevel1Range, pspFw.BIOSDirectoryLevel2Range}
+	default:
+		return nil, fmt.Errorf("cannot get RTM ranges, invalid BIOS Directory Level requested: %d", biosLevel)
+	}
+
+	rtmVolume, err := GetBIOSEntry(pspFw, biosLevel, BIOSRTMVolumeEntry, 0)
+	if err != nil {
+		return nil, fmt.Errorf("could not get BIOS entry corresponding to RTM volume (%x): %w", BIOSRTMVolumeEntry, err)
+	}
+	ranges = append(ranges, bytes2.Range{Offset: rtmVolume.SourceAddress, Length: uint64(rtmVolume.Size)})
+
+	firmwareBytes := amdFw.Firmware().ImageBytes()
+	for _, r := range ranges {
+		if err := checkBoundaries(r.Offset, r.End(), firmwareBytes); err != nil {
+			return nil, newErrInvalidFormat(err)
+		}
+	}
+	return ranges, nil
+}
+
 // GetPSBSignBIOSKey returns and OEM Key that is used to sign BIOS during PSB enabled
 func GetPSBSignBIOSKey(amdFw *amd_manifest.AMDFirmware, biosLevel uint) (*Key, error) {
 	keySet, err := GetKeys(amdFw, biosLevel)
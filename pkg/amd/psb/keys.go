@@ -203,6 +203,9 @@ func readExponent(buff *bytes.Buffer, key *Key) error {
 	if key.data.ExponentSize%8 != 0 {
 		return newErrInvalidFormat(fmt.Errorf("exponent size is not divisible by 8"))
 	}
+	if uint64(key.data.ExponentSize/8) > uint64(buff.Len()) {
+		return newErrInvalidFormat(fmt.Errorf("exponent size %d exceeds the remaining %d bytes", key.data.ExponentSize/8, buff.Len()))
+	}
 	exponent := make([]byte, key.data.ExponentSize/8)
 	if err := binary.Read(buff, binary.LittleEndian, &exponent); err != nil {
 		return newErrInvalidFormat(fmt.Errorf("could not parse exponent: %w", err))
@@ -217,6 +220,9 @@ func readModulus(buff *bytes.Buffer, key *Key) error {
 	if key.data.ModulusSize%8 != 0 {
 		return newErrInvalidFormat(fmt.Errorf("modulus size is not divisible by 8"))
 	}
+	if uint64(key.data.ModulusSize/8) > uint64(buff.Len()) {
+		return newErrInvalidFormat(fmt.Errorf("modulus size %d exceeds the remaining %d bytes", key.data.ModulusSize/8, buff.Len()))
+	}
 
 	modulus := make([]byte, key.data.ModulusSize/8)
 	if err := binary.Read(buff, binary.LittleEndian, &modulus); err != nil {
@@ -299,6 +305,9 @@ func NewTokenKey(buff *bytes.Buffer, keySet KeySet) (*Key, error) {
 	}
 
 	// validate the signature of the new token key
+	if uint64(signatureSize) > uint64(buff.Len()) {
+		return nil, newErrInvalidFormat(fmt.Errorf("signature size %d exceeds the remaining %d bytes", signatureSize, buff.Len()))
+	}
 	signature := make([]byte, signatureSize)
 	if err := binary.Read(buff, binary.LittleEndian, &signature); err != nil {
 		return nil, newErrInvalidFormat(fmt.Errorf("could not parse signature from key token: %w", err))
@@ -7,6 +7,7 @@ package psb
 import (
 	"bytes"
 	"crypto/sha256"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -144,6 +145,43 @@ func (suite *KeySuite) TestKeyDBParsing() {
 
 }
 
+func (suite *KeySuite) TestKeySetConcurrent() {
+	keySet := NewKeySet()
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := &Key{data: KeyData{KeyID: KeyID{byte(i)}}}
+			assert.NoError(suite.T(), keySet.AddKey(key, OEMKey))
+			assert.Equal(suite.T(), key, keySet.GetKey(KeyID{byte(i)}))
+			_, err := keySet.KeysetFromType(OEMKey)
+			assert.NoError(suite.T(), err)
+			_ = keySet.AllKeyIDs()
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(suite.T(), 16, len(keySet.AllKeyIDs()))
+
+	var zero KeySet
+	assert.Nil(suite.T(), zero.GetKey(KeyID(rootKeyID)))
+	assert.Error(suite.T(), zero.AddKey(&Key{}, OEMKey))
+}
+
 func TestKeySuite(t *testing.T) {
 	suite.Run(t, new(KeySuite))
 }
+
+func FuzzNewRootKey(f *testing.F) {
+	f.Add(amdRootKey)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, _ = NewRootKey(bytes.NewBuffer(data))
+	})
+}
+
+func FuzzParseKeyDatabase(f *testing.F) {
+	f.Add(keyDB)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_ = parseKeyDatabase(data, NewKeySet())
+	})
+}
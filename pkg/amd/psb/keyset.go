@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"sync"
 
 	amd_manifest "github.com/linuxboot/fiano/pkg/amd/manifest"
 )
@@ -28,16 +29,31 @@ const (
 	ABLKey KeyType = "ALBKey"
 )
 
-// KeySet is a container for all keys known to the system
+// KeySet is a container for all keys known to the system. It is safe for
+// concurrent use, and its copies share the same keys.
 type KeySet struct {
+	// mu guards db and keyType. It is a pointer, so that the copies of the
+	// KeySet share it along with the maps.
+	mu *sync.RWMutex
 	// db holds a mapping between keyID and key
 	db map[KeyID]*Key
 	// keyType holds a mapping betweek KeyType and KeyID
 	keyType map[KeyType][]KeyID
 }
 
+// rlock read-locks the key set and returns the function unlocking it. The
+// zero KeySet holds no key and has no lock.
+func (kdb KeySet) rlock() func() {
+	if kdb.mu == nil {
+		return func() {}
+	}
+	kdb.mu.RLock()
+	return kdb.mu.RUnlock
+}
+
 // String returns a string representation of the key in the set
 func (kdb *KeySet) String() string {
+	defer kdb.rlock()()
 	var s strings.Builder
 	fmt.Fprintf(&s, "Number of keys in key set: %d\n\n", len(kdb.db))
 
@@ -49,6 +65,11 @@ func (kdb *KeySet) String() string {
 
 // AddKey adds a key to the key set
 func (kdb KeySet) AddKey(k *Key, keyType KeyType) error {
+	if kdb.mu == nil {
+		return fmt.Errorf("cannot add key id %s to set, the key set was not built by NewKeySet", k.data.KeyID.Hex())
+	}
+	kdb.mu.Lock()
+	defer kdb.mu.Unlock()
 	if _, ok := kdb.db[k.data.KeyID]; ok {
 		return fmt.Errorf("cannot add key id %s to set, key with same id already exists", k.data.KeyID.Hex())
 	}
@@ -62,6 +83,7 @@ func (kdb KeySet) AddKey(k *Key, keyType KeyType) error {
 // NewKeySet builds an empty key set object
 func NewKeySet() KeySet {
 	keySet := KeySet{}
+	keySet.mu = new(sync.RWMutex)
 	keySet.db = make(map[KeyID]*Key)
 	keySet.keyType = make(map[KeyType][]KeyID)
 	return keySet
@@ -69,6 +91,7 @@ func NewKeySet() KeySet {
 
 // GetKey returns a key if known to the KeySet. If the key is not known, null is returned
 func (kdb KeySet) GetKey(id KeyID) *Key {
+	defer kdb.rlock()()
 	if kdb.db == nil {
 		return nil
 	}
@@ -77,6 +100,7 @@ func (kdb KeySet) GetKey(id KeyID) *Key {
 
 // AllKeyIDs returns a list of all KeyIDs stored in the KeySet
 func (kdb KeySet) AllKeyIDs() KeyIDs {
+	defer kdb.rlock()()
 	keyIDs := make(KeyIDs, 0, len(kdb.db))
 	for keyID := range kdb.db {
 		keyIDs = append(keyIDs, keyID)
@@ -86,12 +110,14 @@ func (kdb KeySet) AllKeyIDs() KeyIDs {
 
 // KeysetFromType returns a KeySet containing all KeyIDs of a specific type
 func (kdb KeySet) KeysetFromType(keyType KeyType) (KeySet, error) {
+	defer kdb.rlock()()
 	if _, ok := kdb.keyType[keyType]; !ok {
 		return NewKeySet(), newErrNotFound(nil)
 	}
 	keySet := NewKeySet()
 	for _, keyID := range kdb.keyType[keyType] {
-		key := kdb.GetKey(keyID)
+		// The key set is locked already, so it is read directly.
+		key := kdb.db[keyID]
 		if key == nil {
 			return NewKeySet(), newErrInvalidFormat(fmt.Errorf("KeySet in inconsistent state, no key is present with keyID %s", keyID.Hex()))
 		}
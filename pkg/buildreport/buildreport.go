@@ -0,0 +1,256 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package buildreport reads the module information of the EDK2 build: the
+// build report written by "build -y" and the FV map files written by GenFv
+// next to each firmware volume, so that the files of an in-house image can
+// be traced back to their source modules.
+package buildreport
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+)
+
+// Module is a module of the build, identified by the GUID of its file.
+type Module struct {
+	GUID guid.GUID
+	Name string `json:",omitempty"`
+	// INFPath is the path of the module INF file in the workspace.
+	INFPath    string `json:",omitempty"`
+	DriverType string `json:",omitempty"`
+	// BaseAddress and EntryPoint are the build-time addresses of the image
+	// from the FV map. They are only set for modules listed in an FV map.
+	BaseAddress *uint64 `json:",omitempty"`
+	EntryPoint  *uint64 `json:",omitempty"`
+	// Image is the path of the built executable.
+	Image string `json:",omitempty"`
+}
+
+// Report maps the GUIDs of the files to their modules.
+type Report map[guid.GUID]*Module
+
+// module returns the module of g, adding it if needed.
+func (r Report) module(g guid.GUID) *Module {
+	m, ok := r[g]
+	if !ok {
+		m = &Module{GUID: g}
+		r[g] = m
+	}
+	return m
+}
+
+// Merge adds the modules of o to r. The fields set in o replace those of r.
+func (r Report) Merge(o Report) {
+	for g, om := range o {
+		m := r.module(g)
+		if om.Name != "" {
+			m.Name = om.Name
+		}
+		if om.INFPath != "" {
+			m.INFPath = om.INFPath
+		}
+		if om.DriverType != "" {
+			m.DriverType = om.DriverType
+		}
+		if om.BaseAddress != nil {
+			m.BaseAddress = om.BaseAddress
+		}
+		if om.EntryPoint != nil {
+			m.EntryPoint = om.EntryPoint
+		}
+		if om.Image != "" {
+			m.Image = om.Image
+		}
+	}
+}
+
+// Names returns the module names of the GUIDs, for knownguids.Learn.
+func (r Report) Names() map[guid.GUID]string {
+	names := map[guid.GUID]string{}
+	for g, m := range r {
+		if m.Name != "" {
+			names[g] = m.Name
+		}
+	}
+	return names
+}
+
+// Parse reads a build report or an FV map. Both may be concatenated, the
+// lines of either format are recognized on their own.
+//
+// In the build report, the "Module Summary" of each module lists:
+//
+//	Module Name:          PcdDxe
+//	Module INF Path:      MdeModulePkg/Universal/PCD/Dxe/Pcd.inf
+//	File GUID:            80CF7257-87AB-47F9-A3FE-D50B76D89541
+//	Driver Type:          0x7 (DRIVER)
+//
+// In the FV map, each module is listed as:
+//
+//	PcdDxe (Fixed Flash Address, BaseAddress=0x00fffc8000, EntryPoint=0x00fffc8290, Type=PE)
+//	(GUID=80CF7257-87AB-47F9-A3FE-D50B76D89541 .textbaseaddress=0x00fffc8240 .databaseaddress=0x00fffcd3e0)
+//	(IMAGE=/build/OvmfX64/DEBUG_GCC5/X64/MdeModulePkg/Universal/PCD/Dxe/Pcd/DEBUG/PcdDxe.efi)
+func Parse(r io.Reader) (Report, error) {
+	report := Report{}
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	var (
+		line int
+		// summary is the module of the current module summary. Its
+		// fields are merged into the report when its File GUID is read.
+		summary = &Module{}
+		// mapped is the module of the previous FV map entry, which the
+		// GUID line names, and last the module of the GUID line, which the
+		// IMAGE line completes.
+		mapped *Module
+		last   *Module
+	)
+	for s.Scan() {
+		line++
+		text := strings.TrimSpace(s.Text())
+		if key, value, ok := summaryField(text); ok {
+			switch key {
+			case "Module Name":
+				summary = &Module{Name: value}
+			case "Module INF Path":
+				summary.INFPath = strings.ReplaceAll(value, "\\", "/")
+			case "Driver Type":
+				summary.DriverType = driverType(value)
+			case "File GUID":
+				g, err := guid.Parse(value)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", line, err)
+				}
+				report.Merge(Report{*g: summary})
+				summary = report[*g]
+			}
+			continue
+		}
+		switch {
+		case strings.HasPrefix(text, "(GUID="):
+			fields := strings.Fields(strings.Trim(text, "()"))
+			g, err := guid.Parse(strings.TrimPrefix(fields[0], "GUID="))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			last = nil
+			if mapped != nil {
+				mapped.GUID = *g
+				report.Merge(Report{*g: mapped})
+				last = report[*g]
+			}
+			mapped = nil
+		case strings.HasPrefix(text, "(IMAGE="):
+			if last != nil {
+				last.Image = strings.TrimSuffix(strings.TrimPrefix(text, "(IMAGE="), ")")
+			}
+			last = nil
+		default:
+			m, err := mapEntry(text)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			mapped, last = m, nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// summaryField splits a "Key: value" line of a module summary.
+func summaryField(text string) (key, value string, ok bool) {
+	i := strings.Index(text, ":")
+	if i < 0 {
+		return "", "", false
+	}
+	key = text[:i]
+	switch key {
+	case "Module Name", "Module INF Path", "File GUID", "Driver Type":
+		return key, strings.TrimSpace(text[i+1:]), true
+	}
+	return "", "", false
+}
+
+// driverType returns the name of a driver type such as "0x7 (DRIVER)".
+func driverType(value string) string {
+	if i, j := strings.Index(value, "("), strings.LastIndex(value, ")"); i >= 0 && j > i {
+		return value[i+1 : j]
+	}
+	return value
+}
+
+// mapEntry parses the first line of an FV map entry, returning nil for other
+// lines.
+func mapEntry(text string) (*Module, error) {
+	i := strings.Index(text, " (")
+	if i <= 0 || !strings.HasSuffix(text, ")") || !strings.Contains(text, "BaseAddress=") {
+		return nil, nil
+	}
+	m := &Module{Name: text[:i]}
+	for _, field := range strings.Split(text[i+2:len(text)-1], ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			continue
+		}
+		var addr **uint64
+		switch key {
+		case "BaseAddress":
+			addr = &m.BaseAddress
+		case "EntryPoint":
+			addr = &m.EntryPoint
+		default:
+			continue
+		}
+		n, err := strconv.ParseUint(value, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s of %s: %w", key, m.Name, err)
+		}
+		*addr = &n
+	}
+	return m, nil
+}
+
+// ReadFile parses the build report or FV map at path.
+func ReadFile(path string) (Report, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return r, nil
+}
+
+// LookupINF returns the GUIDs of the modules whose INF path ends with path,
+// ignoring case, sorted.
+func (r Report) LookupINF(path string) []guid.GUID {
+	path = strings.ToLower(strings.ReplaceAll(path, "\\", "/"))
+	if path == "" {
+		return nil
+	}
+	var gs []guid.GUID
+	for g, m := range r {
+		inf := strings.ToLower(m.INFPath)
+		if inf == path || strings.HasSuffix(inf, "/"+path) {
+			gs = append(gs, g)
+		}
+	}
+	sort.Slice(gs, func(i, j int) bool {
+		return gs[i].String() < gs[j].String()
+	})
+	return gs
+}
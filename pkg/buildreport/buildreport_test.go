@@ -0,0 +1,92 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package buildreport
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+)
+
+const testReport = `Platform Summary
+Platform Name:        Ovmf
+Platform DSC Path:    OvmfPkg/OvmfPkgX64.dsc
+>======================================================================================================================<
+Module Summary
+Module Name:          PcdDxe
+Module INF Path:      MdeModulePkg\Universal\PCD\Dxe\Pcd.inf
+File GUID:            80CF7257-87AB-47F9-A3FE-D50B76D89541
+Size:                 0x6840 (26.06K)
+Module Arch:          X64
+Driver Type:          0x7 (DRIVER)
+>======================================================================================================================<
+Module Summary
+Module Name:          DxeCore
+Module INF Path:      MdeModulePkg/Core/Dxe/DxeMain.inf
+File GUID:            D6A2CB7F-6A18-4E2F-B43B-9920A733700A
+Driver Type:          0x5 (DXE_CORE)
+`
+
+const testFVMap = `EFI_BASE_ADDRESS = 0x000000
+EFI_FV_TOTAL_SIZE = 0xd00000
+
+PcdDxe (BaseAddress=0x0000001000, EntryPoint=0x0000001290, Type=PE)
+(GUID=80CF7257-87AB-47F9-A3FE-D50B76D89541 .textbaseaddress=0x0000001240 .databaseaddress=0x00000063e0)
+(IMAGE=/build/X64/MdeModulePkg/Universal/PCD/Dxe/Pcd/DEBUG/PcdDxe.efi)
+
+SecMain (Fixed Flash Address, BaseAddress=0x00fffcc094, EntryPoint=0x00fffcc2c4, Type=PE)
+(GUID=DF1CCEF6-F301-4A63-9661-FC6030DCC880 .textbaseaddress=0x00fffcc2f4 .databaseaddress=0x00fffcfdd4)
+`
+
+func TestParse(t *testing.T) {
+	r, err := Parse(strings.NewReader(testReport + testFVMap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r) != 3 {
+		t.Fatalf("got %d modules, want 3: %v", len(r), r)
+	}
+	base, entry := uint64(0x1000), uint64(0x1290)
+	want := &Module{
+		GUID:        *guid.MustParse("80CF7257-87AB-47F9-A3FE-D50B76D89541"),
+		Name:        "PcdDxe",
+		INFPath:     "MdeModulePkg/Universal/PCD/Dxe/Pcd.inf",
+		DriverType:  "DRIVER",
+		BaseAddress: &base,
+		EntryPoint:  &entry,
+		Image:       "/build/X64/MdeModulePkg/Universal/PCD/Dxe/Pcd/DEBUG/PcdDxe.efi",
+	}
+	if got := r[want.GUID]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	sec := r[*guid.MustParse("DF1CCEF6-F301-4A63-9661-FC6030DCC880")]
+	if sec == nil || sec.Name != "SecMain" || sec.BaseAddress == nil || *sec.BaseAddress != 0xfffcc094 || sec.Image != "" {
+		t.Errorf("SecMain: got %+v", sec)
+	}
+	if got := r.Names()[want.GUID]; got != "PcdDxe" {
+		t.Errorf("name: got %q", got)
+	}
+
+	if got := r.LookupINF("dxe/pcd.inf"); len(got) != 1 || got[0] != want.GUID {
+		t.Errorf("LookupINF: got %v", got)
+	}
+	if got := r.LookupINF("cd.inf"); len(got) != 0 {
+		t.Errorf("LookupINF of a partial file name: got %v", got)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, s := range []string{
+		"File GUID: not-a-guid\n",
+		"PcdDxe (BaseAddress=0xzz, Type=PE)\n",
+		"PcdDxe (BaseAddress=0x1000, Type=PE)\n(GUID=xyz)\n",
+	} {
+		if _, err := Parse(strings.NewReader(s)); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
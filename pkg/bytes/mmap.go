@@ -0,0 +1,73 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bytes
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// MmapReader reads a file mapped into memory rather than copied to the
+// heap, so that many large images can be analyzed at once. The mapping is
+// private: writes to the mapped memory, e.g. by a parser patching the
+// image in place, are not written back to the file.
+//
+// The memory returned by Bytes and ReadAll, and whatever is parsed from it,
+// must not be used after Close. Where mapping is not supported, the file is
+// read into the heap instead.
+type MmapReader struct {
+	*bytes.Reader
+	data   []byte
+	mapped bool
+}
+
+// Mmap maps the file at path into memory.
+func Mmap(path string) (*MmapReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	data, mapped, err := mmap(f, fi.Size())
+	if err != nil {
+		return nil, &os.PathError{Op: "mmap", Path: path, Err: err}
+	}
+	return &MmapReader{Reader: bytes.NewReader(data), data: data, mapped: mapped}, nil
+}
+
+// Bytes returns the whole mapped file.
+func (r *MmapReader) Bytes() []byte {
+	return r.data
+}
+
+// Close unmaps the file.
+func (r *MmapReader) Close() error {
+	data, mapped := r.data, r.mapped
+	r.data, r.mapped = nil, false
+	r.Reader.Reset(nil)
+	if !mapped {
+		return nil
+	}
+	return munmap(data)
+}
+
+// ReadAll is io.ReadAll, except that the unread bytes of an MmapReader are
+// returned as is rather than copied.
+func ReadAll(r io.Reader) ([]byte, error) {
+	m, ok := r.(*MmapReader)
+	if !ok {
+		return io.ReadAll(r)
+	}
+	off := m.Size() - int64(m.Len())
+	if _, err := m.Seek(0, io.SeekEnd); err != nil {
+		return nil, err
+	}
+	return m.data[off:], nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bytes
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMmap(t *testing.T) {
+	want := bytes.Repeat([]byte("fiano"), 1000)
+	path := filepath.Join(t.TempDir(), "image")
+	if err := os.WriteFile(path, want, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Mmap(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if !bytes.Equal(r.Bytes(), want) {
+		t.Fatalf("mapped bytes differ from the file")
+	}
+	if _, err := r.Seek(5, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want[5:]) {
+		t.Errorf("ReadAll: got %d bytes, want %d", len(got), len(want)-5)
+	}
+	if &got[0] != &r.Bytes()[5] {
+		t.Errorf("ReadAll copied the mapped bytes")
+	}
+	if r.Len() != 0 {
+		t.Errorf("ReadAll left %d bytes unread", r.Len())
+	}
+
+	// The mapping is private.
+	got[0] = 'F'
+	if b, err := os.ReadFile(path); err != nil || !bytes.Equal(b, want) {
+		t.Errorf("writing to the mapped bytes changed the file")
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if r.Bytes() != nil || r.Len() != 0 {
+		t.Errorf("the reader still holds data after Close")
+	}
+}
+
+func TestMmapEmpty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := Mmap(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ReadAll(r); err != nil || len(b) != 0 {
+		t.Errorf("ReadAll: got %q, %v, want no bytes", b, err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMmapMissing(t *testing.T) {
+	if _, err := Mmap(filepath.Join(t.TempDir(), "missing")); !os.IsNotExist(err) {
+		t.Errorf("got %v, want a not exist error", err)
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+package bytes
+
+import (
+	"os"
+	"syscall"
+)
+
+func mmap(f *os.File, size int64) ([]byte, bool, error) {
+	if size == 0 {
+		// Empty files cannot be mapped.
+		return []byte{}, false, nil
+	}
+	if int64(int(size)) != size {
+		return nil, false, syscall.EFBIG
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+func munmap(data []byte) error {
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,106 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bytes
+
+// RangeSet is a set of offsets, such as the bytes of an image covered by a
+// signature. It is kept as sorted ranges which neither overlap nor touch, so
+// two sets with the same offsets have the same ranges.
+//
+// The zero value is the empty set.
+type RangeSet struct {
+	ranges Ranges
+}
+
+// NewRangeSet returns the set of the offsets in ranges, which may overlap
+// and be in any order.
+func NewRangeSet(ranges ...Range) RangeSet {
+	var s Ranges
+	for _, r := range ranges {
+		if r.Length != 0 {
+			s = append(s, r)
+		}
+	}
+	s.SortAndMerge()
+	return RangeSet{ranges: s}
+}
+
+// Ranges returns the ranges of the set, sorted by offset.
+func (s RangeSet) Ranges() Ranges {
+	return append(Ranges(nil), s.ranges...)
+}
+
+func (s RangeSet) String() string {
+	return s.ranges.String()
+}
+
+// IsEmpty returns true if the set has no offsets.
+func (s RangeSet) IsEmpty() bool {
+	return len(s.ranges) == 0
+}
+
+// Len returns the number of offsets in the set.
+func (s RangeSet) Len() uint64 {
+	var n uint64
+	for _, r := range s.ranges {
+		n += r.Length
+	}
+	return n
+}
+
+// Contains returns true if the offset is in the set.
+func (s RangeSet) Contains(offset uint64) bool {
+	return s.ranges.IsIn(offset)
+}
+
+// Union returns the offsets which are in s or in o.
+func (s RangeSet) Union(o RangeSet) RangeSet {
+	return NewRangeSet(append(s.Ranges(), o.ranges...)...)
+}
+
+// Intersect returns the offsets which are both in s and in o.
+func (s RangeSet) Intersect(o RangeSet) RangeSet {
+	var result Ranges
+	i, j := 0, 0
+	for i < len(s.ranges) && j < len(o.ranges) {
+		a, b := s.ranges[i], o.ranges[j]
+		start := maxUint64(a.Offset, b.Offset)
+		end := a.End()
+		if b.End() < end {
+			end = b.End()
+		}
+		if start < end {
+			result = append(result, Range{Offset: start, Length: end - start})
+		}
+		// Keep the range which goes further, it may intersect the next
+		// one of the other set.
+		if a.End() < b.End() {
+			i++
+		} else {
+			j++
+		}
+	}
+	return RangeSet{ranges: result}
+}
+
+// Subtract returns the offsets of s which are not in o.
+func (s RangeSet) Subtract(o RangeSet) RangeSet {
+	var result Ranges
+	// Exclude sorts its arguments in place, so it gets a copy.
+	exclude := o.Ranges()
+	for _, r := range s.ranges {
+		for _, part := range r.Exclude(exclude...) {
+			if part.Length != 0 {
+				result = append(result, part)
+			}
+		}
+	}
+	return RangeSet{ranges: result}
+}
+
+// Gaps returns the offsets of within which are not in the set, such as the
+// bytes of an image which no signature covers.
+func (s RangeSet) Gaps(within Range) RangeSet {
+	return NewRangeSet(within).Subtract(s)
+}
@@ -0,0 +1,75 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bytes
+
+import (
+	"testing"
+)
+
+func TestRangeSet(t *testing.T) {
+	// [0x10, 0x30) and [0x40, 0x50), given out of order, overlapping,
+	// touching and with an empty range.
+	a := NewRangeSet(
+		Range{Offset: 0x40, Length: 0x10},
+		Range{Offset: 0x10, Length: 0x10},
+		Range{Offset: 0x18, Length: 0x8},
+		Range{Offset: 0x20, Length: 0x10},
+		Range{Offset: 0x60, Length: 0},
+	)
+	// [0x28, 0x48) and [0x70, 0x80).
+	b := NewRangeSet(
+		Range{Offset: 0x28, Length: 0x20},
+		Range{Offset: 0x70, Length: 0x10},
+	)
+
+	assertEqualRanges(t, Ranges{{Offset: 0x10, Length: 0x20}, {Offset: 0x40, Length: 0x10}}, a.Ranges())
+	if a.Len() != 0x30 {
+		t.Errorf("Len: expected 0x30, got %#x", a.Len())
+	}
+	if !a.Contains(0x2f) || a.Contains(0x30) || a.Contains(0x60) {
+		t.Errorf("Contains: unexpected result for %v", a)
+	}
+
+	t.Run("union", func(t *testing.T) {
+		assertEqualRanges(t, Ranges{
+			{Offset: 0x10, Length: 0x40},
+			{Offset: 0x70, Length: 0x10},
+		}, a.Union(b).Ranges())
+	})
+	t.Run("intersect", func(t *testing.T) {
+		expected := Ranges{
+			{Offset: 0x28, Length: 0x8},
+			{Offset: 0x40, Length: 0x8},
+		}
+		assertEqualRanges(t, expected, a.Intersect(b).Ranges())
+		assertEqualRanges(t, expected, b.Intersect(a).Ranges())
+	})
+	t.Run("subtract", func(t *testing.T) {
+		assertEqualRanges(t, Ranges{
+			{Offset: 0x10, Length: 0x18},
+			{Offset: 0x48, Length: 0x8},
+		}, a.Subtract(b).Ranges())
+		assertEqualRanges(t, Ranges{
+			{Offset: 0x30, Length: 0x10},
+			{Offset: 0x70, Length: 0x10},
+		}, b.Subtract(a).Ranges())
+		if !a.Subtract(a).IsEmpty() {
+			t.Errorf("a - a is not empty: %v", a.Subtract(a))
+		}
+	})
+	t.Run("gaps", func(t *testing.T) {
+		assertEqualRanges(t, Ranges{
+			{Offset: 0, Length: 0x10},
+			{Offset: 0x30, Length: 0x10},
+			{Offset: 0x50, Length: 0x30},
+		}, a.Gaps(Range{Length: 0x80}).Ranges())
+		assertEqualRanges(t, Ranges{
+			{Offset: 0x30, Length: 0x10},
+		}, a.Gaps(Range{Offset: 0x20, Length: 0x28}).Ranges())
+		assertEqualRanges(t, Ranges{
+			{Length: 0x80},
+		}, RangeSet{}.Gaps(Range{Length: 0x80}).Ranges())
+	})
+}
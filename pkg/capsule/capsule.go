@@ -0,0 +1,314 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package capsule reads and writes the UEFI firmware management protocol
+// (FMP) capsules, which carry firmware updates. A capsule holds embedded
+// drivers and payload items, each item an update image for the device
+// whose FMP image type it names. The update images are authenticated by an
+// EFI_FIRMWARE_IMAGE_AUTHENTICATION, a PKCS#7 signature of the payload
+// which Sign recomputes, as EDK2's GenerateCapsule makes it. See the UEFI
+// specification, section 23.
+package capsule
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+)
+
+// FMPCapsuleGUID is the GUID of the capsules carrying FMP payloads,
+// EFI_FIRMWARE_MANAGEMENT_CAPSULE_ID_GUID.
+var FMPCapsuleGUID = *guid.MustParse("6DCBD5ED-E82D-4C44-BDA1-7194199AD92A")
+
+// CertTypePKCS7GUID is the certificate type of PKCS#7 signatures,
+// EFI_CERT_TYPE_PKCS7_GUID.
+var CertTypePKCS7GUID = *guid.MustParse("4AAFD29D-68DF-49EE-8AA9-347D375665A7")
+
+// Flags of the capsule header.
+const (
+	FlagPersistAcrossReset  = 0x00010000
+	FlagPopulateSystemTable = 0x00020000
+	FlagInitiateReset       = 0x00040000
+)
+
+// Values of the WIN_CERTIFICATE header of the authentication.
+const (
+	winCertRevision    = 0x0200
+	winCertTypeEFIGUID = 0x0EF1
+)
+
+// Lengths of the fixed parts of a capsule.
+const (
+	HeaderLen    = 28
+	FMPHeaderLen = 8
+	// AuthLen is the length of an EFI_FIRMWARE_IMAGE_AUTHENTICATION
+	// without its certificate data: the monotonic count and the
+	// WIN_CERTIFICATE_UEFI_GUID header.
+	AuthLen = 8 + 24
+)
+
+// imageHeaderLens are the lengths of the image headers by version.
+var imageHeaderLens = map[uint32]int{1: 32, 2: 40, 3: 48}
+
+// ErrNotCapsule is returned when a buffer is not an FMP capsule.
+var ErrNotCapsule = errors.New("not an FMP capsule")
+
+// Header is the EFI_CAPSULE_HEADER starting a capsule.
+type Header struct {
+	GUID             guid.GUID
+	HeaderSize       uint32
+	Flags            uint32
+	CapsuleImageSize uint32
+}
+
+// FMPHeader is the EFI_FIRMWARE_MANAGEMENT_CAPSULE_HEADER following the
+// capsule header. It is followed by the offsets of the drivers and items,
+// from the start of the FMP header.
+type FMPHeader struct {
+	Version             uint32
+	EmbeddedDriverCount uint16
+	PayloadItemCount    uint16
+}
+
+// ImageHeader is the EFI_FIRMWARE_MANAGEMENT_CAPSULE_IMAGE_HEADER of an
+// item. The fields after UpdateVendorCodeSize are only stored from
+// version 2 and 3 on.
+type ImageHeader struct {
+	Version                uint32
+	UpdateImageTypeID      guid.GUID
+	UpdateImageIndex       uint8
+	Reserved               [3]uint8
+	UpdateImageSize        uint32
+	UpdateVendorCodeSize   uint32
+	UpdateHardwareInstance uint64
+	ImageCapsuleSupport    uint64
+}
+
+// Authentication is the EFI_FIRMWARE_IMAGE_AUTHENTICATION starting the
+// update image of an authenticated item.
+type Authentication struct {
+	// MonotonicCount is signed with the payload, against rollbacks.
+	MonotonicCount uint64
+	CertType       guid.GUID
+	// CertData is the signature, a DER PKCS#7 SignedData for
+	// CertTypePKCS7GUID.
+	CertData []byte
+}
+
+// Item is a payload item of a capsule.
+type Item struct {
+	Header ImageHeader
+	// Auth is nil when the update image is not authenticated.
+	Auth *Authentication
+	// Payload is the update image after the authentication.
+	Payload    []byte
+	VendorCode []byte
+}
+
+// Capsule is an FMP capsule.
+type Capsule struct {
+	Header Header
+	// HeaderPadding are the bytes between the capsule header and the FMP
+	// header, up to HeaderSize.
+	HeaderPadding []byte
+	FMPHeader     FMPHeader
+	Drivers       [][]byte
+	Items         []*Item
+}
+
+// String implements fmt.Stringer.
+func (i *Item) String() string {
+	s := fmt.Sprintf("image %v index %d: %#x bytes", i.Header.UpdateImageTypeID, i.Header.UpdateImageIndex, len(i.Payload))
+	if i.Auth != nil {
+		s += fmt.Sprintf(", signed with monotonic count %d", i.Auth.MonotonicCount)
+	}
+	return s
+}
+
+// Parse parses the FMP capsule in buf. ErrNotCapsule is returned if buf is
+// not an FMP capsule.
+func Parse(buf []byte) (*Capsule, error) {
+	var c Capsule
+	if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, &c.Header); err != nil || c.Header.GUID != FMPCapsuleGUID {
+		return nil, ErrNotCapsule
+	}
+	h := c.Header
+	if h.HeaderSize < HeaderLen || h.CapsuleImageSize < h.HeaderSize || uint64(h.CapsuleImageSize) > uint64(len(buf)) {
+		return nil, fmt.Errorf("capsule of %#x bytes with a header of %#x bytes in %#x bytes", h.CapsuleImageSize, h.HeaderSize, len(buf))
+	}
+	buf = buf[:h.CapsuleImageSize]
+	c.HeaderPadding = append([]byte{}, buf[HeaderLen:h.HeaderSize]...)
+
+	fmp := buf[h.HeaderSize:]
+	if err := binary.Read(bytes.NewReader(fmp), binary.LittleEndian, &c.FMPHeader); err != nil {
+		return nil, fmt.Errorf("FMP header: %w", err)
+	}
+	if c.FMPHeader.Version != 1 {
+		return nil, fmt.Errorf("FMP header version %d, want 1", c.FMPHeader.Version)
+	}
+	n := int(c.FMPHeader.EmbeddedDriverCount) + int(c.FMPHeader.PayloadItemCount)
+	if FMPHeaderLen+8*n > len(fmp) {
+		return nil, fmt.Errorf("FMP header: %d offsets past the capsule", n)
+	}
+	offsets := make([]uint64, n+1)
+	for x := 0; x < n; x++ {
+		offsets[x] = binary.LittleEndian.Uint64(fmp[FMPHeaderLen+8*x:])
+	}
+	offsets[n] = uint64(len(fmp))
+	for x := 0; x < n; x++ {
+		start, end := offsets[x], offsets[x+1]
+		if start < uint64(FMPHeaderLen+8*n) || start > end || end > uint64(len(fmp)) {
+			return nil, fmt.Errorf("FMP header: element %d at %#x, next at %#x, in %#x bytes", x, start, end, len(fmp))
+		}
+		if x < int(c.FMPHeader.EmbeddedDriverCount) {
+			c.Drivers = append(c.Drivers, append([]byte{}, fmp[start:end]...))
+			continue
+		}
+		item, err := parseItem(fmp[start:end])
+		if err != nil {
+			return nil, fmt.Errorf("item %d: %w", len(c.Items), err)
+		}
+		c.Items = append(c.Items, item)
+	}
+	return &c, nil
+}
+
+// parseItem parses a payload item, which spans buf.
+func parseItem(buf []byte) (*Item, error) {
+	var i Item
+	if len(buf) < 4 {
+		return nil, fmt.Errorf("image header of %d bytes", len(buf))
+	}
+	i.Header.Version = binary.LittleEndian.Uint32(buf)
+	hdrLen, ok := imageHeaderLens[i.Header.Version]
+	if !ok {
+		return nil, fmt.Errorf("unknown image header version %d", i.Header.Version)
+	}
+	if len(buf) < hdrLen {
+		return nil, fmt.Errorf("image header of %d bytes, want %d", len(buf), hdrLen)
+	}
+	// The fields of the older versions are left zero.
+	hdr := make([]byte, imageHeaderLens[3])
+	copy(hdr, buf[:hdrLen])
+	if err := binary.Read(bytes.NewReader(hdr), binary.LittleEndian, &i.Header); err != nil {
+		return nil, err
+	}
+	imageSize, vendorSize := uint64(i.Header.UpdateImageSize), uint64(i.Header.UpdateVendorCodeSize)
+	if uint64(hdrLen)+imageSize+vendorSize > uint64(len(buf)) {
+		return nil, fmt.Errorf("update image of %#x bytes and vendor code of %#x bytes in %#x bytes", imageSize, vendorSize, len(buf)-hdrLen)
+	}
+	image := buf[hdrLen:][:imageSize]
+	i.VendorCode = append([]byte{}, buf[uint64(hdrLen)+imageSize:][:vendorSize]...)
+	i.Auth, image = parseAuth(image)
+	i.Payload = append([]byte{}, image...)
+	return &i, nil
+}
+
+// parseAuth returns the authentication starting the update image, if any,
+// and the payload after it.
+func parseAuth(image []byte) (*Authentication, []byte) {
+	if len(image) < AuthLen {
+		return nil, image
+	}
+	length := uint64(binary.LittleEndian.Uint32(image[8:]))
+	revision := binary.LittleEndian.Uint16(image[12:])
+	certType := binary.LittleEndian.Uint16(image[14:])
+	if revision != winCertRevision || certType != winCertTypeEFIGUID || length < AuthLen-8 || 8+length > uint64(len(image)) {
+		return nil, image
+	}
+	a := &Authentication{MonotonicCount: binary.LittleEndian.Uint64(image)}
+	copy(a.CertType[:], image[16:32])
+	a.CertData = append([]byte{}, image[AuthLen:8+length]...)
+	return a, image[8+length:]
+}
+
+// Bytes returns the authentication as stored before the payload.
+func (a *Authentication) Bytes() []byte {
+	b := binary.LittleEndian.AppendUint64(nil, a.MonotonicCount)
+	b = binary.LittleEndian.AppendUint32(b, uint32(AuthLen-8+len(a.CertData)))
+	b = binary.LittleEndian.AppendUint16(b, winCertRevision)
+	b = binary.LittleEndian.AppendUint16(b, winCertTypeEFIGUID)
+	b = append(b, a.CertType[:]...)
+	return append(b, a.CertData...)
+}
+
+// Bytes returns the item, its image header updated with the sizes of the
+// update image and vendor code.
+func (i *Item) Bytes() ([]byte, error) {
+	hdrLen, ok := imageHeaderLens[i.Header.Version]
+	if !ok {
+		return nil, fmt.Errorf("unknown image header version %d", i.Header.Version)
+	}
+	var image []byte
+	if i.Auth != nil {
+		image = i.Auth.Bytes()
+	}
+	image = append(image, i.Payload...)
+	hdr := i.Header
+	hdr.UpdateImageSize = uint32(len(image))
+	hdr.UpdateVendorCodeSize = uint32(len(i.VendorCode))
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.LittleEndian, hdr); err != nil {
+		return nil, err
+	}
+	out := append(b.Bytes()[:hdrLen], image...)
+	return append(out, i.VendorCode...), nil
+}
+
+// Bytes returns the capsule, its headers updated with the offsets and
+// sizes of the drivers and items.
+func (c *Capsule) Bytes() ([]byte, error) {
+	fmpHdr := c.FMPHeader
+	fmpHdr.EmbeddedDriverCount = uint16(len(c.Drivers))
+	fmpHdr.PayloadItemCount = uint16(len(c.Items))
+	elements := append([][]byte{}, c.Drivers...)
+	for x, i := range c.Items {
+		b, err := i.Bytes()
+		if err != nil {
+			return nil, fmt.Errorf("item %d: %w", x, err)
+		}
+		elements = append(elements, b)
+	}
+
+	var fmp bytes.Buffer
+	if err := binary.Write(&fmp, binary.LittleEndian, fmpHdr); err != nil {
+		return nil, err
+	}
+	offset := uint64(FMPHeaderLen + 8*len(elements))
+	for _, e := range elements {
+		fmp.Write(binary.LittleEndian.AppendUint64(nil, offset))
+		offset += uint64(len(e))
+	}
+	for _, e := range elements {
+		fmp.Write(e)
+	}
+
+	hdr := c.Header
+	hdr.HeaderSize = uint32(HeaderLen + len(c.HeaderPadding))
+	hdr.CapsuleImageSize = hdr.HeaderSize + uint32(fmp.Len())
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.LittleEndian, hdr); err != nil {
+		return nil, err
+	}
+	b.Write(c.HeaderPadding)
+	b.Write(fmp.Bytes())
+	return b.Bytes(), nil
+}
+
+// Payload returns the payload of the first item of the FMP capsule in buf,
+// which is the firmware image of system firmware updates. ErrNotCapsule is
+// returned if buf is not an FMP capsule.
+func Payload(buf []byte) ([]byte, error) {
+	c, err := Parse(buf)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Items) == 0 {
+		return nil, errors.New("the capsule has no payload item")
+	}
+	return c.Items[0].Payload, nil
+}
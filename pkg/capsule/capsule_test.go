@@ -0,0 +1,208 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package capsule
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+)
+
+var testImageTypeID = *guid.MustParse("B122A263-3661-4F68-9929-78F8B0D62180")
+
+// testCapsule returns a capsule of a driver and an item carrying payload,
+// authenticated with a dummy signature when signed is set.
+func testCapsule(payload []byte, signed bool) *Capsule {
+	item := &Item{
+		Header:     ImageHeader{Version: 3, UpdateImageTypeID: testImageTypeID, UpdateImageIndex: 1, UpdateHardwareInstance: 2},
+		Payload:    payload,
+		VendorCode: []byte("vendor"),
+	}
+	if signed {
+		item.Auth = &Authentication{MonotonicCount: 7, CertType: CertTypePKCS7GUID, CertData: []byte("signature")}
+	}
+	return &Capsule{
+		Header:        Header{GUID: FMPCapsuleGUID, Flags: FlagPersistAcrossReset | FlagInitiateReset},
+		HeaderPadding: make([]byte, 4),
+		FMPHeader:     FMPHeader{Version: 1},
+		Drivers:       [][]byte{[]byte("driver")},
+		Items:         []*Item{item},
+	}
+}
+
+// testSigner returns a key of the given kind and its self-signed
+// certificate.
+func testSigner(t *testing.T, kind string) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	var key crypto.Signer
+	var err error
+	if kind == "RSA" {
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	} else {
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(0x1234),
+		Subject:      pkix.Name{CommonName: "fiano test capsule signer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// verifyPKCS7 checks that sig is a detached PKCS#7 signature of content by
+// cert, as made by signPKCS7.
+func verifyPKCS7(t *testing.T, sig, content []byte, cert *x509.Certificate) {
+	t.Helper()
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(sig, &ci); err != nil || !ci.ContentType.Equal(oidSignedData) {
+		t.Fatalf("got content info %v, %v, want signed data", ci.ContentType, err)
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		t.Fatal(err)
+	}
+	if len(sd.SignerInfos) != 1 || len(sd.ContentInfo.Content.Bytes) != 0 || !bytes.Equal(sd.Certificates.Bytes, cert.Raw) {
+		t.Fatalf("got %d signers, content %x and certificates %x, want a detached signature by the certificate", len(sd.SignerInfos), sd.ContentInfo.Content.Bytes, sd.Certificates.Bytes)
+	}
+	si := sd.SignerInfos[0]
+	if si.IssuerAndSerialNumber.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		t.Errorf("got serial number %v, want %v", si.IssuerAndSerialNumber.SerialNumber, cert.SerialNumber)
+	}
+
+	var attrs []attribute
+	set := append([]byte{0x31}, si.AuthenticatedAttributes.FullBytes[1:]...)
+	if _, err := asn1.UnmarshalWithParams(set, &attrs, "set"); err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(content)
+	var found bool
+	for _, a := range attrs {
+		if a.Type.Equal(oidMessageDigest) {
+			var got []byte
+			if _, err := asn1.Unmarshal(a.Values.Bytes, &got); err != nil {
+				t.Fatal(err)
+			}
+			found = bytes.Equal(got, digest[:])
+		}
+	}
+	if !found {
+		t.Errorf("the message digest does not match the content")
+	}
+	algo := x509.SHA256WithRSA
+	if _, ok := cert.PublicKey.(*ecdsa.PublicKey); ok {
+		algo = x509.ECDSAWithSHA256
+	}
+	if err := cert.CheckSignature(algo, set, si.EncryptedDigest); err != nil {
+		t.Errorf("the signature of the attributes does not verify: %v", err)
+	}
+}
+
+func TestParseBytes(t *testing.T) {
+	for _, signed := range []bool{false, true} {
+		c := testCapsule([]byte("payload"), signed)
+		b, err := c.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := Parse(append(b, "trailing"...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Header.HeaderSize != 32 || got.Header.CapsuleImageSize != uint32(len(b)) || got.Header.Flags != c.Header.Flags {
+			t.Errorf("got header %+v", got.Header)
+		}
+		if len(got.Drivers) != 1 || string(got.Drivers[0]) != "driver" || len(got.Items) != 1 {
+			t.Fatalf("got %d drivers and %d items", len(got.Drivers), len(got.Items))
+		}
+		item := got.Items[0]
+		if string(item.Payload) != "payload" || string(item.VendorCode) != "vendor" || item.Header.UpdateHardwareInstance != 2 {
+			t.Errorf("got item %v, header %+v", item, item.Header)
+		}
+		if (item.Auth != nil) != signed || signed && (item.Auth.MonotonicCount != 7 || string(item.Auth.CertData) != "signature") {
+			t.Errorf("got authentication %+v, want it %t", item.Auth, signed)
+		}
+		if again, err := got.Bytes(); err != nil || !bytes.Equal(again, b) {
+			t.Errorf("the capsule is not written back as it was read: %v", err)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	if _, err := Parse([]byte("not a capsule")); !errors.Is(err, ErrNotCapsule) {
+		t.Errorf("got %v, want %v", err, ErrNotCapsule)
+	}
+	b, err := testCapsule([]byte("payload"), true).Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Parse(b[:len(b)-1]); err == nil || errors.Is(err, ErrNotCapsule) {
+		t.Errorf("truncated capsule: got %v", err)
+	}
+	// The offset of the item points past the capsule.
+	bad := append([]byte{}, b...)
+	bad[32+8+8] = 0xff
+	if _, err := Parse(bad); err == nil {
+		t.Errorf("item past the capsule: got no error")
+	}
+}
+
+func TestSign(t *testing.T) {
+	for _, kind := range []string{"RSA", "ECDSA"} {
+		t.Run(kind, func(t *testing.T) {
+			cert, key := testSigner(t, kind)
+			c := testCapsule([]byte("payload"), true)
+			c.Items[0].Payload = []byte("modified payload")
+			if err := c.Items[0].Sign(cert, key); err != nil {
+				t.Fatal(err)
+			}
+			b, err := c.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Parse(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			item := got.Items[0]
+			if item.Auth == nil || item.Auth.MonotonicCount != 7 || item.Auth.CertType != CertTypePKCS7GUID {
+				t.Fatalf("got authentication %+v", item.Auth)
+			}
+			verifyPKCS7(t, item.Auth.CertData, signedContent([]byte("modified payload"), 7), cert)
+		})
+	}
+
+	// An item without authentication gets one.
+	cert, key := testSigner(t, "ECDSA")
+	item := testCapsule([]byte("payload"), false).Items[0]
+	if err := item.Sign(cert, key); err != nil {
+		t.Fatal(err)
+	}
+	verifyPKCS7(t, item.Auth.CertData, signedContent([]byte("payload"), 0), cert)
+}
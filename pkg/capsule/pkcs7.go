@@ -0,0 +1,182 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package capsule
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+// Object identifiers of the PKCS#7 signatures, see RFC 2315.
+var (
+	oidData             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSHA256           = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSAEncryption    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSAWithSHA256  = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	sha256AlgorithmID   = pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+	rsaAlgorithmID      = pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+	ecdsaSHA256AlgoID   = pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}
+	contextSpecificTag0 = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true}
+)
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	// Content is the explicitly tagged [0] content, if any.
+	Content asn1.RawValue `asn1:"optional"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	// ContentInfo has no content: the signature is detached.
+	ContentInfo  contentInfo
+	Certificates asn1.RawValue `asn1:"optional,tag:0"`
+	SignerInfos  []signerInfo  `asn1:"set"`
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     issuerAndSerialNumber
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+}
+
+type attribute struct {
+	Type asn1.ObjectIdentifier
+	// Values is the DER of the SET OF the attribute values.
+	Values asn1.RawValue
+}
+
+// signedContent returns what the signature of an item covers: its payload
+// followed by the monotonic count, as EDK2's FmpAuthenticationLib checks.
+func signedContent(payload []byte, monotonicCount uint64) []byte {
+	content := append([]byte{}, payload...)
+	return binary.LittleEndian.AppendUint64(content, monotonicCount)
+}
+
+// Sign replaces the authentication of the item with a PKCS#7 signature of
+// its payload, made by key for cert. The monotonic count of the previous
+// authentication is kept, 0 is signed if the item had none. The signature
+// is a detached SignedData with the content type and message digest as
+// authenticated attributes, as openssl smime -sign makes it for EDK2's
+// GenerateCapsule. RSA and ECDSA keys are supported, with SHA-256.
+func (i *Item) Sign(cert *x509.Certificate, key crypto.Signer) error {
+	var count uint64
+	if i.Auth != nil {
+		count = i.Auth.MonotonicCount
+	}
+	sig, err := signPKCS7(signedContent(i.Payload, count), cert, key)
+	if err != nil {
+		return err
+	}
+	i.Auth = &Authentication{MonotonicCount: count, CertType: CertTypePKCS7GUID, CertData: sig}
+	return nil
+}
+
+// signPKCS7 returns the DER ContentInfo of a detached PKCS#7 SignedData of
+// content.
+func signPKCS7(content []byte, cert *x509.Certificate, key crypto.Signer) ([]byte, error) {
+	var encryption pkix.AlgorithmIdentifier
+	switch key.Public().(type) {
+	case *rsa.PublicKey:
+		encryption = rsaAlgorithmID
+	case *ecdsa.PublicKey:
+		encryption = ecdsaSHA256AlgoID
+	default:
+		return nil, fmt.Errorf("unsupported %T signing key, expected an RSA or ECDSA key", key.Public())
+	}
+
+	digest := sha256.Sum256(content)
+	attrs, err := authenticatedAttributes(digest[:])
+	if err != nil {
+		return nil, err
+	}
+	// The signature covers the DER of the attributes as a SET OF.
+	set, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: attrs})
+	if err != nil {
+		return nil, err
+	}
+	setDigest := sha256.Sum256(set)
+	encrypted, err := key.Sign(rand.Reader, setDigest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	tag0 := func(b []byte) asn1.RawValue {
+		v := contextSpecificTag0
+		v.Bytes = b
+		return v
+	}
+	sd, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256AlgorithmID},
+		ContentInfo:      contentInfo{ContentType: oidData},
+		Certificates:     tag0(cert.Raw),
+		SignerInfos: []signerInfo{{
+			Version: 1,
+			IssuerAndSerialNumber: issuerAndSerialNumber{
+				Issuer:       asn1.RawValue{FullBytes: cert.RawIssuer},
+				SerialNumber: cert.SerialNumber,
+			},
+			DigestAlgorithm:           sha256AlgorithmID,
+			AuthenticatedAttributes:   tag0(attrs),
+			DigestEncryptionAlgorithm: encryption,
+			EncryptedDigest:           encrypted,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{ContentType: oidSignedData, Content: tag0(sd)})
+}
+
+// authenticatedAttributes returns the DER of the content type and message
+// digest attributes, in the order of their encodings as DER sets require.
+func authenticatedAttributes(digest []byte) ([]byte, error) {
+	var encoded [][]byte
+	for _, a := range []struct {
+		oid   asn1.ObjectIdentifier
+		value interface{}
+	}{
+		{oidContentType, oidData},
+		{oidMessageDigest, digest},
+	} {
+		v, err := asn1.Marshal(a.value)
+		if err != nil {
+			return nil, err
+		}
+		b, err := asn1.Marshal(attribute{
+			Type:   a.oid,
+			Values: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: v},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, b)
+	}
+	sort.Slice(encoded, func(i, j int) bool { return bytes.Compare(encoded[i], encoded[j]) < 0 })
+	return bytes.Join(encoded, nil), nil
+}
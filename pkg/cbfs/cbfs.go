@@ -7,10 +7,16 @@ package cbfs
 import "os"
 
 func Open(n string) (*Image, error) {
+	return OpenRegion(n, DefaultRegion)
+}
+
+// OpenRegion opens the CBFS of the FMAP area named region.
+func OpenRegion(n, region string) (*Image, error) {
 	f, err := os.Open(n)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 
-	return NewImage(f)
+	return NewRegionImage(f, region)
 }
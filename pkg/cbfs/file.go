@@ -11,20 +11,69 @@ import (
 	"errors"
 	"fmt"
 	"io"
-
-	"github.com/linuxboot/fiano/pkg/compression"
 )
 
 var ErrCBFSHeaderMagicNotFound = errors.New("CBFS header magic doesn't match")
 
 func (f *File) MarshalJSON() ([]byte, error) {
-	return json.Marshal(mFile{
+	m, err := f.mFile()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(m)
+}
+
+// mFile returns the fields of the file for marshalling.
+func (f *File) mFile() (mFile, error) {
+	attrs, err := f.Attributes()
+	if err != nil {
+		return mFile{}, err
+	}
+	m := mFile{
 		Name:        f.Name,
 		Start:       f.RecordStart,
 		Size:        f.FileHeader.Size,
 		Type:        f.FileHeader.Type.String(),
 		Compression: f.Compression().String(),
-	})
+		DataOffset:  f.RecordStart + f.SubHeaderOffset,
+	}
+	for _, a := range attrs {
+		m.Attributes = append(m.Attributes, newMAttr(a))
+	}
+	return m, nil
+}
+
+// newMAttr decodes the attribute a for marshalling.
+func newMAttr(a []byte) mAttr {
+	m := mAttr{Tag: Tag(Endian.Uint32(a)).String(), Size: uint32(len(a))}
+	switch r := bytes.NewReader(a); Tag(Endian.Uint32(a)) {
+	case Compressed:
+		var c FileAttrCompression
+		if Read(r, &c) == nil {
+			m.Compression, m.DecompressedSize = c.Compression.String(), c.DecompressedSize
+		}
+	case Hash:
+		if len(a) >= hashHeaderLen {
+			m.HashAlgorithm = HashAlgorithm(Endian.Uint32(a[8:])).String()
+			m.Hash = fmt.Sprintf("%x", a[hashHeaderLen:])
+		}
+	case PSCB:
+		var p FileAttrPos
+		if Read(r, &p) == nil {
+			m.Position = p.Pos
+		}
+	case ALCB:
+		var al FileAttrAlign
+		if Read(r, &al) == nil {
+			m.Alignment = al.Align
+		}
+	case SHCB:
+		var h FileAttrStageHeader
+		if Read(r, &h) == nil {
+			m.LoadAddress, m.Entry, m.MemSize = h.LoadAddress, h.LoadAddress+uint64(h.EntryOffset), h.MemSize
+		}
+	}
+	return m
 }
 
 // NewFile reads in the CBFS file at current offset
@@ -47,6 +96,20 @@ func NewFile(r io.ReadSeeker) (*File, error) {
 	}
 	Debug("Found CBFS file at %#02x is %v type %v", f.RecordStart, f, f.Type)
 
+	// Check the offsets and the size before allocating the name, the
+	// attributes and the data.
+	hdrSize := uint32(binary.Size(FileHeader{}))
+	if f.SubHeaderOffset < hdrSize || (f.AttrOffset != 0 && (f.AttrOffset < hdrSize || f.AttrOffset > f.SubHeaderOffset)) {
+		return nil, fmt.Errorf("file at %#x: invalid attributes offset %#x or data offset %#x", f.RecordStart, f.AttrOffset, f.SubHeaderOffset)
+	}
+	left, err := remaining(r)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(f.SubHeaderOffset-hdrSize)+uint64(f.Size) > uint64(left) {
+		return nil, fmt.Errorf("file at %#x: %#x bytes of data at %#x are out of the %#x bytes left", f.RecordStart, f.Size, f.SubHeaderOffset, uint64(left)+uint64(hdrSize))
+	}
+
 	var nameSize uint32
 	if f.AttrOffset == 0 {
 		nameSize = f.SubHeaderOffset - uint32(binary.Size(FileHeader{}))
@@ -143,7 +206,7 @@ func (f *File) FindAttribute(t Tag) ([]byte, error) {
 			return nil, fmt.Errorf("end tag found")
 		}
 		// Validate input
-		if generic.Size < uint32(binary.Size(generic)) || generic.Size == 0xffffffff {
+		if generic.Size < uint32(binary.Size(generic)) || int64(generic.Size) > buf.Size() {
 			return nil, fmt.Errorf("tag is malformed, aborting")
 		}
 		Debug("FindAttribute: Found attribute with tag %x", generic.Tag)
@@ -163,35 +226,61 @@ func (f *File) FindAttribute(t Tag) ([]byte, error) {
 	}
 }
 
+// Attributes returns the attributes of the file, each as []byte of the size
+// given by its tag, up to the end tag.
+func (f *File) Attributes() ([][]byte, error) {
+	var attrs [][]byte
+	for b := f.Attr; len(b) >= binary.Size(FileAttr{}); {
+		tag, size := Endian.Uint32(b), Endian.Uint32(b[4:])
+		if tag == uint32(Unused) || tag == uint32(Unused2) {
+			break
+		}
+		if size < uint32(binary.Size(FileAttr{})) || size > uint32(len(b)) {
+			return nil, fmt.Errorf("%q: attribute %v of %#x bytes is malformed", f.Name, Tag(tag), size)
+		}
+		attrs = append(attrs, b[:size])
+		b = b[size:]
+	}
+	return attrs, nil
+}
+
+// compressionAttr returns the compression attribute, if any.
+func (f *File) compressionAttr() (*FileAttrCompression, error) {
+	cattr, err := f.FindAttribute(Compressed)
+	if err != nil {
+		return nil, err
+	}
+	comp := &FileAttrCompression{}
+	if err := binary.Read(bytes.NewBuffer(cattr), Endian, comp); err != nil {
+		return nil, err
+	}
+	return comp, nil
+}
+
 // Compression returns the algorithm used to compress FData.
 // If no compression attribute is found or on error it returns 'None'
 func (f *File) Compression() Compression {
-	cattr, err := f.FindAttribute(Compressed)
+	comp, err := f.compressionAttr()
 	if err != nil {
 		Debug("Compression: No compression tag found: %v", err)
 		return None
 	}
-
-	comp := FileAttrCompression{}
-	if err := binary.Read(bytes.NewBuffer(cattr), Endian, &comp); err != nil {
-		Debug("Compression: failed to read compression tag: %v", err)
-		return None
-	}
 	return comp.Compression
 }
 
 // Decompress returns the decompressed FData
 // If FData is not compressed it returns FData
 func (f *File) Decompress() ([]byte, error) {
-	c := f.Compression()
-	if c == None {
+	comp, err := f.compressionAttr()
+	if err != nil {
 		return f.FData, nil
-	} else if c == LZMA {
-		compressor := compression.LZMA{}
-		return compressor.Decode(f.FData)
-	} else if c == LZ4 {
-		compressor := compression.LZ4{}
-		return compressor.Decode(f.FData)
-	}
-	return nil, fmt.Errorf("unknown compression")
+	}
+	d, err := decompress(comp.Compression, f.FData)
+	if err != nil {
+		return nil, fmt.Errorf("%q: %w", f.Name, err)
+	}
+	if comp.Compression != None && comp.DecompressedSize != 0 && uint32(len(d)) != comp.DecompressedSize {
+		return nil, fmt.Errorf("%q: decompressed to %#x bytes, attribute says %#x", f.Name, len(d), comp.DecompressedSize)
+	}
+	return d, nil
 }
@@ -8,8 +8,11 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
 	"unicode"
+
+	"github.com/linuxboot/fiano/pkg/compression"
 )
 
 var Debug = func(format string, v ...interface{}) {}
@@ -50,18 +53,57 @@ func WriteLE(r io.Writer, f interface{}) error {
 	return nil
 }
 
+// String returns the name of c, which for the types registered with
+// compression.RegisterCBFS is the name of their compressor.
 func (c Compression) String() string {
-	switch c {
-	case None:
+	if c == None {
 		return "none"
-	case LZMA:
-		return "lzma"
-	case LZ4:
-		return "lz4"
+	}
+	if compressor := compression.CompressorFromCBFS(uint32(c)); compressor != nil {
+		return strings.ToLower(compressor.Name())
 	}
 	return "unknown"
 }
 
+// ParseCompression returns the compression named s, as printed by String.
+func ParseCompression(s string) (Compression, error) {
+	cs := []Compression{None}
+	for _, t := range compression.CBFSTypes() {
+		cs = append(cs, Compression(t))
+	}
+	for _, c := range cs {
+		if strings.EqualFold(c.String(), s) {
+			return c, nil
+		}
+	}
+	return None, fmt.Errorf("unknown compression %q", s)
+}
+
+// Compressor returns the pkg/compression backend of c, as registered with
+// compression.RegisterCBFS, or nil for None.
+func (c Compression) Compressor() (compression.Compressor, error) {
+	if c == None {
+		return nil, nil
+	}
+	if compressor := compression.CompressorFromCBFS(uint32(c)); compressor != nil {
+		return compressor, nil
+	}
+	return nil, fmt.Errorf("unknown compression %#x", uint32(c))
+}
+
+// decompress decodes b compressed with c.
+func decompress(c Compression, b []byte) ([]byte, error) {
+	compressor, err := c.Compressor()
+	if err != nil || compressor == nil {
+		return b, err
+	}
+	d, err := compressor.Decode(b)
+	if err != nil {
+		return nil, fmt.Errorf("%v decompression: %w", c, err)
+	}
+	return d, nil
+}
+
 func (f FileType) String() string {
 	switch f {
 	case TypeDeleted2:
@@ -114,6 +156,44 @@ func (f FileType) String() string {
 	return fmt.Sprintf("%#x", uint32(f))
 }
 
+var fileTypes = []FileType{
+	TypeDeleted2, TypeDeleted, TypeBootBlock, TypeMaster, TypeLegacyStage,
+	TypeStage, TypeSELF, TypeFIT, TypeOptionRom, TypeBootSplash, TypeRaw,
+	TypeVSA, TypeMBI, TypeMicroCode, TypeFSP, TypeMRC, TypeMMA, TypeEFI,
+	TypeStruct, TypeCMOS, TypeSPD, TypeMRCCache, TypeCMOSLayout,
+}
+
+// ParseFileType returns the file type named s, as printed by String and
+// ignoring case, or numbered s.
+func ParseFileType(s string) (FileType, error) {
+	for _, t := range fileTypes {
+		if strings.EqualFold(t.String(), s) {
+			return t, nil
+		}
+	}
+	n, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("unknown file type %q", s)
+	}
+	return FileType(n), nil
+}
+
+func (t Tag) String() string {
+	switch t {
+	case Compressed:
+		return "compression"
+	case Hash:
+		return "hash"
+	case PSCB:
+		return "position"
+	case ALCB:
+		return "alignment"
+	case SHCB:
+		return "stage header"
+	}
+	return fmt.Sprintf("%#x", uint32(t))
+}
+
 func recString(n string, off uint32, typ string, sz uint32, compress string) string {
 	return fmt.Sprintf("%-32s 0x%-8x %-24s 0x%-8x %-4s", n, off, typ, sz, compress)
 }
@@ -130,6 +210,23 @@ func cleanString(n string) string {
 	}, n)
 }
 
+// remaining returns the number of bytes from the current offset of s to its
+// end.
+func remaining(s io.Seeker) (int64, error) {
+	cur, err := s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	end, err := s.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := s.Seek(cur, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return end - cur, nil
+}
+
 func ffbyte(s uint32) []byte {
 	b := make([]byte, s)
 	for i := range b {
@@ -0,0 +1,151 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cbfs
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+// HashAlgorithm is the vb2_hash_algorithm of a hash attribute. In the vb2_hash
+// layout of current coreboot the algorithm is a byte preceded by three
+// reserved zero bytes, which reads as the same big endian uint32.
+type HashAlgorithm uint32
+
+const (
+	HashNone HashAlgorithm = iota
+	HashSHA1
+	HashSHA256
+	HashSHA512
+	HashSHA224
+	HashSHA384
+)
+
+// hashHeaderLen is the size of the hash attribute before the digest.
+const hashHeaderLen = 12
+
+// ErrNoHash is returned for files without a hash attribute.
+var ErrNoHash = errors.New("no hash attribute")
+
+func (a HashAlgorithm) String() string {
+	switch a {
+	case HashNone:
+		return "none"
+	case HashSHA1:
+		return "sha1"
+	case HashSHA256:
+		return "sha256"
+	case HashSHA512:
+		return "sha512"
+	case HashSHA224:
+		return "sha224"
+	case HashSHA384:
+		return "sha384"
+	}
+	return fmt.Sprintf("%#x", uint32(a))
+}
+
+// New returns a hash.Hash computing a.
+func (a HashAlgorithm) New() (hash.Hash, error) {
+	switch a {
+	case HashSHA1:
+		return sha1.New(), nil
+	case HashSHA256:
+		return sha256.New(), nil
+	case HashSHA512:
+		return sha512.New(), nil
+	case HashSHA224:
+		return sha256.New224(), nil
+	case HashSHA384:
+		return sha512.New384(), nil
+	}
+	return nil, fmt.Errorf("unsupported hash algorithm %v", a)
+}
+
+// NewHashAttribute returns a hash attribute holding the digest of data, to
+// be passed to NewRecord.
+func NewHashAttribute(a HashAlgorithm, data []byte) ([]byte, error) {
+	h, err := a.New()
+	if err != nil {
+		return nil, err
+	}
+	h.Write(data)
+	d := h.Sum(nil)
+	var b bytes.Buffer
+	if err := Write(&b, FileAttr{Tag: uint32(Hash), Size: uint32(hashHeaderLen + len(d))}); err != nil {
+		return nil, err
+	}
+	if err := Write(&b, a); err != nil {
+		return nil, err
+	}
+	b.Write(d)
+	return b.Bytes(), nil
+}
+
+// HashAttribute returns the hash attribute of the file. The error wraps
+// ErrNoHash if there is none.
+func (f *File) HashAttribute() (*FileAttrHash, error) {
+	a, err := f.FindAttribute(Hash)
+	if err != nil {
+		return nil, fmt.Errorf("%q: %w: %v", f.Name, ErrNoHash, err)
+	}
+	if len(a) < hashHeaderLen {
+		return nil, fmt.Errorf("%q: hash attribute of %d bytes is too short", f.Name, len(a))
+	}
+	h := &FileAttrHash{
+		Tag:      Tag(Endian.Uint32(a)),
+		Size:     Endian.Uint32(a[4:]),
+		HashType: HashAlgorithm(Endian.Uint32(a[8:])),
+	}
+	alg, err := h.HashType.New()
+	if err != nil {
+		return nil, fmt.Errorf("%q: %w", f.Name, err)
+	}
+	if len(a)-hashHeaderLen < alg.Size() {
+		return nil, fmt.Errorf("%q: %v hash attribute holds %d bytes, want %d", f.Name, h.HashType, len(a)-hashHeaderLen, alg.Size())
+	}
+	h.Data = a[hashHeaderLen : hashHeaderLen+alg.Size()]
+	return h, nil
+}
+
+// VerifyHash recomputes the digest of the data of the file, as stored, and
+// compares it to the one of its hash attribute, as vboot CBFS verification
+// does.
+func (f *File) VerifyHash() error {
+	h, err := f.HashAttribute()
+	if err != nil {
+		return err
+	}
+	alg, err := h.HashType.New()
+	if err != nil {
+		return err
+	}
+	alg.Write(f.FData)
+	if got := alg.Sum(nil); !bytes.Equal(got, h.Data) {
+		return fmt.Errorf("%q: %v mismatch: got %x, want %x", f.Name, h.HashType, got, h.Data)
+	}
+	return nil
+}
+
+// VerifyHashes verifies every file of the image which has a hash attribute
+// and returns the mismatches joined in one error.
+func (i *Image) VerifyHashes() error {
+	var errs []error
+	for _, s := range i.Segs {
+		f := s.GetFile()
+		if f.Deleted() {
+			continue
+		}
+		if err := f.VerifyHash(); err != nil && !errors.Is(err, ErrNoHash) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,63 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cbfs
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestVerifyHashes(t *testing.T) {
+	i := openTestImage(t)
+	if err := i.VerifyHashes(); err != nil {
+		t.Fatalf("image without hashes: %v", err)
+	}
+	f := findFile(i, "config")
+	if _, err := f.HashAttribute(); !errors.Is(err, ErrNoHash) {
+		t.Errorf("got %v, want %v", err, ErrNoHash)
+	}
+
+	data := []byte(strings.Repeat("hashed\n", 100))
+	for _, a := range []HashAlgorithm{HashSHA1, HashSHA256, HashSHA512, HashSHA224, HashSHA384} {
+		attr, err := NewHashAttribute(a, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewRecord("hashed/"+a.String(), TypeRaw, attr, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := i.Add(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n := reparse(t, i)
+	if err := n.VerifyHashes(); err != nil {
+		t.Fatal(err)
+	}
+	h, err := findFile(n, "hashed/sha256").HashAttribute()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.HashType != HashSHA256 || len(h.Data) != 32 {
+		t.Errorf("got %v hash of %d bytes, want sha256 of 32 bytes", h.HashType, len(h.Data))
+	}
+
+	f = findFile(n, "hashed/sha384")
+	n.Data[n.Area.Offset+f.RecordStart+f.SubHeaderOffset] ^= 0xff
+	n, err = NewImage(bytes.NewReader(n.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = n.VerifyHashes()
+	if err == nil || !strings.Contains(err.Error(), "sha384 mismatch") {
+		t.Fatalf("got %v, want a sha384 mismatch", err)
+	}
+	if strings.Contains(err.Error(), "sha256") {
+		t.Errorf("got %v, want only sha384 to mismatch", err)
+	}
+}
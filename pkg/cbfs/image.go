@@ -11,6 +11,7 @@ import (
 	"io"
 	"os"
 
+	bytes2 "github.com/linuxboot/fiano/pkg/bytes"
 	"github.com/linuxboot/fiano/pkg/fmap"
 )
 
@@ -31,33 +32,79 @@ func RegisterFileReader(f *SegReader) error {
 	return nil
 }
 
+// DefaultRegion is the FMAP area holding the CBFS booted first.
+const DefaultRegion = "COREBOOT"
+
+// readFMAP reads the image and its FMAP.
+func readFMAP(rs io.ReadSeeker) ([]byte, *fmap.FMap, *fmap.Metadata, error) {
+	// Suck the image in, unless it is mapped.
+	b, err := bytes2.ReadAll(rs)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to read: %w", err)
+	}
+	f, m, err := fmap.Read(bytes.NewReader(b))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	Debug("Fmap %v", f)
+	return b, f, m, nil
+}
+
+// NewImage parses the CBFS of the COREBOOT area.
 func NewImage(rs io.ReadSeeker) (*Image, error) {
-	// Suck the image in. Todo: write a thing that implements
-	// ReadSeeker on a []byte.
-	b, err := io.ReadAll(rs)
+	return NewRegionImage(rs, DefaultRegion)
+}
+
+// NewRegionImage parses the CBFS of the FMAP area named region.
+func NewRegionImage(rs io.ReadSeeker, region string) (*Image, error) {
+	b, f, m, err := readFMAP(rs)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read: %w", err)
+		return nil, err
 	}
-	in := bytes.NewReader(b)
-	f, m, err := fmap.Read(in)
+	x := f.IndexOfArea(region)
+	if x < 0 {
+		return nil, fmt.Errorf("no CBFS in fmap: no area %q", region)
+	}
+	return parseImage(b, f, m, &f.Areas[x])
+}
+
+// NewRegionImages parses all the CBFS of the image, found in the FMAP areas
+// which start with a CBFS file, such as COREBOOT, FW_MAIN_A and FW_MAIN_B.
+// The images share Data: update any of them and write any one to save all
+// the changes.
+func NewRegionImages(rs io.ReadSeeker) ([]*Image, error) {
+	b, f, m, err := readFMAP(rs)
 	if err != nil {
 		return nil, err
 	}
-	Debug("Fmap %v", f)
-	var i = &Image{FMAP: f, FMAPMetadata: m, Data: b}
-	for _, a := range f.Areas {
+	var images []*Image
+	for x := range f.Areas {
+		a := &f.Areas[x]
 		Debug("Check %v", a.Name.String())
-		if a.Name.String() == "COREBOOT" {
-			i.Area = &a
-			break
+		if uint64(a.Offset)+uint64(len(FileMagic)) > uint64(len(b)) || string(b[a.Offset:a.Offset+uint32(len(FileMagic))]) != FileMagic {
+			continue
 		}
+		i, err := parseImage(b, f, m, a)
+		if err != nil {
+			return nil, fmt.Errorf("area %s: %w", a.Name.String(), err)
+		}
+		images = append(images, i)
 	}
-	if i.Area == nil {
+	if len(images) == 0 {
 		return nil, fmt.Errorf("no CBFS in fmap")
 	}
+	return images, nil
+}
+
+// parseImage parses the CBFS of area a of the image b.
+func parseImage(b []byte, f *fmap.FMap, m *fmap.Metadata, a *fmap.Area) (*Image, error) {
+	var i = &Image{FMAP: f, FMAPMetadata: m, Area: a, Data: b}
+	in := bytes.NewReader(b)
 	r := io.NewSectionReader(in, int64(i.Area.Offset), int64(i.Area.Size))
 
-	for off := int64(0); off < int64(i.Area.Size); {
+	// The end of a truncated CBFS is erased, with no room for a file header
+	// in its last bytes.
+	for off := int64(0); off+FileSize <= int64(i.Area.Size); {
 		var f *File
 
 		if _, err := r.Seek(off, io.SeekStart); err != nil {
@@ -78,19 +125,10 @@ func NewImage(rs io.ReadSeeker) (*Image, error) {
 		Debug("It is %v type %v", f, f.Type)
 		Debug("Starting at %#02x + %#02x", i.Area.Offset, f.RecordStart)
 
-		sr, ok := SegReaders[f.Type]
-		if !ok {
-			Debug("No match found for type %v, %v", f.Type, ok)
-			sr = &SegReader{Type: f.Type, Name: "Unknown", New: NewUnknownRecord}
-		}
-		s, err := sr.New(f)
+		s, err := newRecord(f)
 		if err != nil {
 			return nil, err
 		}
-		Debug("Segment: %v", s)
-		if err := s.Read(bytes.NewReader(f.FData)); err != nil {
-			return nil, fmt.Errorf("reading %#x byte subheader, type %v: %w", len(f.FData), f.Type, err)
-		}
 		Debug("Segment was readable")
 		i.Segs = append(i.Segs, s)
 		off, err = r.Seek(0, io.SeekCurrent)
@@ -112,42 +150,56 @@ func (i *Image) WriteFile(name string, perm os.FileMode) error {
 
 // Update creates a new []byte for the cbfs. It is complicated a lot
 // by the fact that endianness is not consistent in cbfs images.
+// The space between the files is filled with 0xff. The ROM size and
+// offset of the master header, and the pointer to it which ends x86 images,
+// are regenerated. Images holding a metadata cache, see MCacheName, are
+// rejected.
 func (i *Image) Update() error {
-	//FIXME: Support additional regions
-	for _, s := range i.Segs {
-		var b bytes.Buffer
-		if err := Write(&b, s.GetFile().FileHeader); err != nil {
-			return err
-		}
-		if _, err := b.Write(s.GetFile().Attr); err != nil {
-			return fmt.Errorf("writing attr to cbfs record for %v: %w", s, err)
-		}
-		if err := s.Write(&b); err != nil {
+	if uint64(i.Area.Offset)+uint64(i.Area.Size) > uint64(len(i.Data)) {
+		return fmt.Errorf("area %s [%#x, %#x) is outside of the image", i.Area.Name.String(), i.Area.Offset, uint64(i.Area.Offset)+uint64(i.Area.Size))
+	}
+	if err := i.updateMasterHeader(); err != nil {
+		return err
+	}
+	area := i.Data[i.Area.Offset : i.Area.Offset+i.Area.Size]
+	for x, s := range i.Segs {
+		b, err := recordBytes(s)
+		if err != nil {
 			return err
 		}
-		// This error should not happen but we need to check just in case.
-		end := uint32(len(b.Bytes())) + s.GetFile().RecordStart
+		start := s.GetFile().RecordStart
+		end := start + uint32(len(b))
 		if end > i.Area.Size {
-			return fmt.Errorf("region [%#x, %#x] outside of CBFS [%#x, %#x]", s.GetFile().RecordStart, end, s.GetFile().RecordStart, i.Area.Size)
+			return fmt.Errorf("region [%#x, %#x] outside of CBFS [%#x, %#x]", start, end, 0, i.Area.Size)
 		}
 
-		Debug("Copy %s %d bytes to i.Data[%d]", s.GetFile().Type.String(), len(b.Bytes()), i.Area.Offset+s.GetFile().RecordStart)
-		copy(i.Data[i.Area.Offset+s.GetFile().RecordStart:], b.Bytes())
+		Debug("Copy %s %d bytes to i.Data[%d]", s.GetFile().Type.String(), len(b), i.Area.Offset+start)
+		copy(area[start:], b)
+		if next := i.recordEnd(x); next > end && next <= i.Area.Size {
+			copy(area[end:next], ffbyte(next-end))
+		}
+	}
+	// Erase the rest of the area of a truncated CBFS.
+	if size := i.Size(); size < i.Area.Size {
+		copy(area[size:], ffbyte(i.Area.Size-size))
 	}
+	i.updateMasterPointer()
 	return nil
 }
 
 type mImage struct {
+	Region   string
 	Offset   uint32
+	Size     uint32
 	Segments []ReadWriter
 }
 
 func (i *Image) MarshalJSON() ([]byte, error) {
-	return json.Marshal(mImage{Segments: i.Segs, Offset: i.Area.Offset})
+	return json.Marshal(mImage{Region: i.Area.Name.String(), Offset: i.Area.Offset, Size: i.Area.Size, Segments: i.Segs})
 }
 
 func (i *Image) String() string {
-	var s = "FMAP REGIOName: COREBOOT\n"
+	var s = "FMAP REGIOName: " + i.Area.Name.String() + "\n"
 
 	s += fmt.Sprintf("%-32s %-8s   %-24s %-8s   %-4s\n", "Name", "Offset", "Type", "Size", "Comp")
 	for _, seg := range i.Segs {
@@ -160,46 +212,3 @@ func (h *FileHeader) Deleted() bool {
 	t := h.Type
 	return t == TypeDeleted || t == TypeDeleted2
 }
-
-func (i *Image) Remove(n string) error {
-	found := -1
-	for x, s := range i.Segs {
-		if s.GetFile().Name == n {
-			found = x
-		}
-	}
-	if found == -1 {
-		return os.ErrExist
-	}
-	// You can not remove the master header
-	// Just remake the cbfs if you're doing that kind of surgery.
-	if found == 0 {
-		return os.ErrPermission
-	}
-	// Bootblock on x86 is at the end of CBFS and shall stay untouched.
-	if found == len(i.Segs)-1 && i.Segs[found].GetFile().Type == TypeBootBlock {
-		return os.ErrPermission
-	}
-	start, end := found, found+1
-	if i.Segs[start-1].GetFile().Deleted() {
-		start = start - 1
-	}
-	if i.Segs[end].GetFile().Deleted() {
-		end = end + 1
-	}
-	Debug("Remove: empty range [%d:%d]", start, end)
-	base := i.Segs[start].GetFile().RecordStart
-	top := i.Segs[end].GetFile().RecordStart
-	Debug("Remove: base %#x top %#x", base, top)
-	// 0x28: header size + 16-byte-aligned-size name
-	s := top - base - 0x28
-	i.Segs[found].GetFile().SubHeaderOffset = 0x28
-	i.Segs[found].GetFile().Size = s
-	del, _ := NewEmptyRecord(i.Segs[found].GetFile())
-	Debug("Offset is 0x28, Size is %#x", s)
-	Debug("Remove: Replace %d..%d with %s", start, end, del.String())
-	// At most, there will be an Empty record before us since
-	// things come pre-merged
-	i.Segs = append(append(i.Segs[:start], del), i.Segs[end:]...)
-	return nil
-}
@@ -6,12 +6,18 @@ package cbfs
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"reflect"
 	"strings"
 	"testing"
+
+	bytes2 "github.com/linuxboot/fiano/pkg/bytes"
+	"github.com/linuxboot/fiano/pkg/fmap"
 )
 
 func TestReadFile(t *testing.T) {
@@ -27,6 +33,30 @@ func TestReadFile(t *testing.T) {
 	t.Logf("%s", i)
 }
 
+func TestReadMappedFile(t *testing.T) {
+	Debug = func(format string, v ...interface{}) {}
+	b, err := os.ReadFile("testdata/coreboot.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := NewImage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := bytes2.Mmap("testdata/coreboot.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	i, err := NewImage(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.String() != want.String() {
+		t.Errorf("got %s, want %s", i, want)
+	}
+}
+
 func TestCompression(t *testing.T) {
 	Debug = t.Logf
 	f, err := os.Open("testdata/coreboot.rom")
@@ -73,6 +103,46 @@ func TestCompression(t *testing.T) {
 	t.Logf("%s", i)
 }
 
+func TestLegacyStageDecompress(t *testing.T) {
+	data := []byte(strings.Repeat("FIANO ROCKS!\n", 1024))
+	for _, c := range []Compression{None, LZMA, LZ4} {
+		t.Run(c.String(), func(t *testing.T) {
+			stored := data
+			if c != None {
+				compressor, err := c.Compressor()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if stored, err = compressor.Encode(data); err != nil {
+					t.Fatal(err)
+				}
+			}
+			h := StageHeader{Compression: c, Entry: 0x1000, LoadAddress: 0x1000, Size: uint32(len(stored)), MemSize: uint32(len(data))}
+			var b bytes.Buffer
+			if err := WriteLE(&b, h); err != nil {
+				t.Fatal(err)
+			}
+			b.Write(stored)
+			r, err := NewRecord("stage", TypeLegacyStage, nil, b.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := r.Decompress()
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, err := NewRecord("stage", TypeLegacyStage, nil, d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := s.(*LegacyStageRecord)
+			if got.StageHeader.Compression != None || !bytes.Equal(got.Data, data) {
+				t.Errorf("got a stage compressed with %v holding %q..., want an uncompressed stage holding %q...", got.StageHeader.Compression, got.Data[:12], data[:12])
+			}
+		})
+	}
+}
+
 func TestBogusArchives(t *testing.T) {
 	var tests = []struct {
 		n    string
@@ -239,3 +309,236 @@ func TestRemovePayload(t *testing.T) {
 	*/
 
 }
+
+func TestCbfstoolString(t *testing.T) {
+	i, err := Open("testdata/coreboot.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `FMAP REGION: COREBOOT
+Name                           Offset     Type             Size Comp
+cbfs master header             0x0        cbfs header        32 none
+fallback/romstage              0x80       legacy stage    15812 none
+fallback/ramstage              0x3ec0     legacy stage    52417 none
+config                         0x10bc0    raw               355 none
+revision                       0x10d80    raw               576 none
+cmos_layout.bin                0x11000    cmos_layout       548 none
+fallback/dsdt.aml              0x11280    raw              6952 none
+fallback/payload               0x12e00    simple elf         28 none
+(empty)                        0x12e80    null               36 none
+compression_test1              0x12ec0    raw                90 LZ4 (13312 decompressed)
+compression_test2              0x12f80    raw                74 LZMA (13312 decompressed)
+(empty)                        0x13040    null           182756 none
+bootblock                      0x3fa40    bootblock         880 none
+`
+	if got := i.CbfstoolString(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+// twoRegionImage returns the test image grown with a FW_MAIN_A CBFS holding
+// a single file.
+func twoRegionImage(t *testing.T) []byte {
+	t.Helper()
+	rom, err := os.ReadFile("testdata/coreboot.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, _, err := fmap.Read(bytes.NewReader(rom))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := append(rom, ffbyte(0x1000)...)
+	a := fmap.Area{Offset: uint32(len(rom)), Size: 0x1000}
+	copy(a.Name.Value[:], "FW_MAIN_A")
+	f.Areas = append(f.Areas, a)
+	f.NAreas++
+	f.Size += 0x1000
+	var hdr bytes.Buffer
+	if err := binary.Write(&hdr, binary.LittleEndian, f.Header); err != nil {
+		t.Fatal(err)
+	}
+	if err := binary.Write(&hdr, binary.LittleEndian, f.Areas); err != nil {
+		t.Fatal(err)
+	}
+	copy(b, hdr.Bytes())
+
+	r, err := NewRecord("vboot", TypeRaw, nil, []byte("region A"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb, err := recordBytes(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(b[a.Offset:], rb)
+	used := alignUp(uint32(len(rb)), Alignment)
+	del, err := newEmptyRecord(used, a.Size-used)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := recordBytes(del)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(b[a.Offset+used:], db)
+	return b
+}
+
+func TestRegionImages(t *testing.T) {
+	b := twoRegionImage(t)
+	images, err := NewRegionImages(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, i := range images {
+		names = append(names, i.Area.Name.String())
+	}
+	if want := []string{"COREBOOT", "FW_MAIN_A"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("got regions %q, want %q", names, want)
+	}
+
+	a, err := NewRegionImage(bytes.NewReader(b), "FW_MAIN_A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Segs) != 2 || a.Segs[0].GetFile().Name != "vboot" {
+		t.Errorf("got FW_MAIN_A\n%v\nwant a vboot file and empty space", a)
+	}
+
+	// Changes to any region are saved with the others.
+	if err := images[1].Remove("vboot"); err != nil {
+		t.Fatal(err)
+	}
+	if err := images[1].Update(); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewRegionImage(bytes.NewReader(images[0].Data), "FW_MAIN_A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Segs) != 1 || !c.Segs[0].GetFile().Deleted() {
+		t.Errorf("got FW_MAIN_A\n%v\nwant empty space", c)
+	}
+
+	if _, err := NewRegionImage(bytes.NewReader(b), "FW_MAIN_B"); err == nil {
+		t.Errorf("parsing a missing region: got nil, want error")
+	}
+}
+
+func TestJSON(t *testing.T) {
+	b := twoRegionImage(t)
+	images, err := NewRegionImages(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte(strings.Repeat("json", 100))
+	hash, err := NewHashAttribute(HashSHA256, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRecord("hashed", TypeRaw, hash, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := images[0].Add(r); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := json.Marshal(images)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []struct {
+		Region   string
+		Offset   uint32
+		Size     uint32
+		Segments []struct {
+			Name        string
+			Type        string
+			Compression string
+			Attributes  []map[string]interface{}
+		}
+	}
+	if err := json.Unmarshal(j, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Region != "COREBOOT" || got[1].Region != "FW_MAIN_A" {
+		t.Fatalf("got %s, want the COREBOOT and FW_MAIN_A regions", j)
+	}
+	if got[1].Offset != images[1].Area.Offset || got[1].Size != images[1].Area.Size {
+		t.Errorf("got FW_MAIN_A at %#x size %#x, want %#x size %#x", got[1].Offset, got[1].Size, images[1].Area.Offset, images[1].Area.Size)
+	}
+	files := map[string]int{}
+	for x, s := range got[0].Segments {
+		files[s.Name] = x
+	}
+	if s := got[0].Segments[files["fallback/ramstage"]]; s.Type != "LegacyStage" || s.Compression != "lzma" {
+		t.Errorf("got ramstage %+v, want an lzma LegacyStage", s)
+	}
+	if s := got[0].Segments[files["compression_test1"]]; len(s.Attributes) != 1 || s.Attributes[0]["Tag"] != "compression" || s.Attributes[0]["Compression"] != "lz4" {
+		t.Errorf("got compression_test1 attributes %v, want an lz4 compression attribute", s.Attributes)
+	}
+	want := map[string]interface{}{
+		"Tag":           "hash",
+		"Size":          float64(12 + 32),
+		"HashAlgorithm": "sha256",
+		"Hash":          fmt.Sprintf("%x", sha256.Sum256(data)),
+	}
+	if s := got[0].Segments[files["hashed"]]; len(s.Attributes) != 1 || !reflect.DeepEqual(s.Attributes[0], want) {
+		t.Errorf("got hashed attributes %v, want %v", s.Attributes, want)
+	}
+}
+
+// smallImage returns an FMAP and a COREBOOT CBFS holding a single file,
+// small enough for the fuzzer to mutate efficiently.
+func smallImage(tb testing.TB) []byte {
+	tb.Helper()
+	b := ffbyte(0x800)
+	areas := []fmap.Area{{Offset: 0, Size: 0x100}, {Offset: 0x100, Size: 0x700}}
+	copy(areas[0].Name.Value[:], "FMAP")
+	copy(areas[1].Name.Value[:], "COREBOOT")
+	h := fmap.Header{VerMajor: 1, VerMinor: 1, Size: uint32(len(b)), NAreas: uint16(len(areas))}
+	copy(h.Signature[:], fmap.Signature)
+	copy(h.Name.Value[:], "FLASH")
+	var hdr bytes.Buffer
+	if err := binary.Write(&hdr, binary.LittleEndian, h); err != nil {
+		tb.Fatal(err)
+	}
+	if err := binary.Write(&hdr, binary.LittleEndian, areas); err != nil {
+		tb.Fatal(err)
+	}
+	copy(b, hdr.Bytes())
+
+	a := areas[1]
+	r, err := NewRecord("config", TypeRaw, nil, []byte("CONFIG_FUZZ=y\n"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	rb, err := recordBytes(r)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	copy(b[a.Offset:], rb)
+	used := alignUp(uint32(len(rb)), Alignment)
+	del, err := newEmptyRecord(used, a.Size-used)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	db, err := recordBytes(del)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	copy(b[a.Offset+used:], db)
+	return b
+}
+
+func FuzzNewImage(f *testing.F) {
+	Debug = func(format string, v ...interface{}) {}
+	f.Add(Master)
+	f.Add(smallImage(f))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		_, _ = NewImage(bytes.NewReader(b))
+	})
+}
@@ -5,7 +5,9 @@
 package cbfs
 
 import (
-	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 )
@@ -23,12 +25,6 @@ func NewMaster(f *File) (ReadWriter, error) {
 }
 
 func (r *MasterRecord) Read(in io.ReadSeeker) error {
-	dump := &bytes.Buffer{}
-	n, err := io.Copy(dump, in)
-	if err != nil {
-		return err
-	}
-	Debug("MasterRecord (%d bytes)\n   %+v\n   %x", n, r, dump)
 	Debug("MasterRecord Header %v at %v", r.MasterHeader, r.Offset)
 	if err := Read(in, &r.MasterHeader); err != nil {
 		Debug("MasterRecord read from %v: %v", r.Offset, err)
@@ -51,3 +47,114 @@ func (r *MasterRecord) Write(w io.Writer) error {
 func (r *MasterRecord) GetFile() *File {
 	return &r.File
 }
+
+// masterPointerLen is the size of the pointer to the master header which
+// ends the image.
+const masterPointerLen = 4
+
+// master returns the master header record, or nil.
+func (i *Image) master() *MasterRecord {
+	for _, s := range i.Segs {
+		if m, ok := s.(*MasterRecord); ok {
+			return m
+		}
+	}
+	return nil
+}
+
+// hasMasterPointer tells whether the image ends with a pointer to the master
+// header of this CBFS, as x86 bootblocks find it.
+func (i *Image) hasMasterPointer() bool {
+	return i.master() != nil && uint64(i.Area.Offset)+uint64(i.Area.Size) == uint64(len(i.Data)) && len(i.Data) >= masterPointerLen
+}
+
+// MasterHeaderPointer returns the offset in Data of the master header, read
+// from the pointer in the last 4 bytes of the image. The pointer is relative
+// to the end of the image, or the address of the header in an x86 image
+// mapped below 4GiB, which amounts to the same.
+func (i *Image) MasterHeaderPointer() (uint32, error) {
+	if len(i.Data) < masterPointerLen {
+		return 0, errors.New("image too small for a master header pointer")
+	}
+	p := binary.LittleEndian.Uint32(i.Data[len(i.Data)-masterPointerLen:])
+	off := uint32(len(i.Data)) + p
+	if uint64(off)+MasterHeaderLen > uint64(len(i.Data)) {
+		return 0, fmt.Errorf("master header pointer %#x is outside of the image", p)
+	}
+	return off, nil
+}
+
+// masterOffset returns the offset in Data of the header of m.
+func (i *Image) masterOffset(m *MasterRecord) uint32 {
+	return i.Area.Offset + m.RecordStart + m.SubHeaderOffset
+}
+
+// CheckMasterHeader checks the fields of the master header and the pointer
+// to it, which bootblocks rely on to find the CBFS. Images without a master
+// header pass.
+func (i *Image) CheckMasterHeader() error {
+	m := i.master()
+	if m == nil {
+		return nil
+	}
+	var errs []error
+	if m.Magic != HeaderMagic {
+		errs = append(errs, fmt.Errorf("master header magic is %#x, want %#x", m.Magic, HeaderMagic))
+	}
+	if m.Version != HeaderV1 && m.Version != HeaderV2 {
+		errs = append(errs, fmt.Errorf("master header version is %#x, want %#x or %#x", m.Version, HeaderV1, HeaderV2))
+	}
+	if m.RomSize != uint32(len(i.Data)) {
+		errs = append(errs, fmt.Errorf("master header ROM size is %#x, the image is %#x bytes", m.RomSize, len(i.Data)))
+	}
+	if m.Offset != i.Area.Offset {
+		errs = append(errs, fmt.Errorf("master header offset is %#x, the CBFS starts at %#x", m.Offset, i.Area.Offset))
+	}
+	if m.Align == 0 || m.Align&(m.Align-1) != 0 {
+		errs = append(errs, fmt.Errorf("master header alignment %#x is not a power of 2", m.Align))
+	} else {
+		for _, s := range i.Segs {
+			// The bootblock is placed to end the image instead.
+			if f := s.GetFile(); f.RecordStart%m.Align != 0 && f.Type != TypeBootBlock {
+				errs = append(errs, fmt.Errorf("%q at %#x is not aligned to %#x", f.Name, f.RecordStart, m.Align))
+			}
+		}
+	}
+	if i.hasMasterPointer() {
+		if off, err := i.MasterHeaderPointer(); err != nil {
+			errs = append(errs, err)
+		} else if off != i.masterOffset(m) {
+			errs = append(errs, fmt.Errorf("master header pointer points to %#x, the header is at %#x", off, i.masterOffset(m)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// MCacheName is the name of the file holding a metadata cache, a copy of
+// the headers of the files of the CBFS.
+const MCacheName = "mcache"
+
+// updateMasterHeader regenerates the fields of the master header which
+// depend on the layout of the image, the ROM size and the offset of the
+// CBFS. Images holding a metadata cache are rejected, as it would describe
+// the files before the update.
+func (i *Image) updateMasterHeader() error {
+	for _, s := range i.Segs {
+		if f := s.GetFile(); !f.Deleted() && f.Name == MCacheName {
+			return fmt.Errorf("%q at %#x: updating the metadata cache is not supported", f.Name, f.RecordStart)
+		}
+	}
+	if m := i.master(); m != nil {
+		m.RomSize, m.Offset = uint32(len(i.Data)), i.Area.Offset
+	}
+	return nil
+}
+
+// updateMasterPointer points the end of the image to the master header.
+func (i *Image) updateMasterPointer() {
+	if !i.hasMasterPointer() {
+		return
+	}
+	p := i.masterOffset(i.master()) - uint32(len(i.Data))
+	binary.LittleEndian.PutUint32(i.Data[len(i.Data)-masterPointerLen:], p)
+}
@@ -5,6 +5,7 @@
 package cbfs
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -49,6 +50,7 @@ func (r *PayloadRecord) Read(in io.ReadSeeker) error {
 	// This _may_ happen. E.g. with the test payload here. Silently ignore.
 	if bodySize == 0 {
 		Debug("Payload empty, nothing to read")
+		r.FData = nil
 		return nil
 	}
 	r.FData = make([]byte, bodySize)
@@ -62,23 +64,16 @@ func (r *PayloadRecord) Read(in io.ReadSeeker) error {
 
 // struct for PayloadRecord marshalling
 type mPayloadRecord struct {
-	Name        string
-	Start       uint32
-	Size        uint32
-	Type        string
-	Segments    []PayloadHeader
-	Compression string
+	mFile
+	Segments []PayloadHeader
 }
 
 func (r *PayloadRecord) MarshalJSON() ([]byte, error) {
-	return json.Marshal(mPayloadRecord{
-		Name:        r.Name,
-		Start:       r.RecordStart,
-		Size:        r.FileHeader.Size,
-		Type:        r.FileHeader.Type.String(),
-		Segments:    r.Segs,
-		Compression: r.File.Compression().String(),
-	})
+	m, err := r.File.mFile()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(mPayloadRecord{mFile: m, Segments: r.Segs})
 }
 
 func (r *PayloadRecord) String() string {
@@ -86,6 +81,7 @@ func (r *PayloadRecord) String() string {
 	for i, seg := range r.Segs {
 		s += "\n"
 		s += recString(fmt.Sprintf(" Seg #%d", i), seg.Offset, seg.Type.String(), seg.Size, seg.Compression.String())
+		s += fmt.Sprintf(" load %#x mem %#x", seg.LoadAddress, seg.MemSize)
 	}
 	return s
 }
@@ -128,6 +124,42 @@ func (r *PayloadRecord) Write(w io.Writer) error {
 	return Write(w, r.FData)
 }
 
+// Entry returns the entry point of the payload, the load address of its
+// entry segment.
+func (r *PayloadRecord) Entry() (uint64, error) {
+	for _, s := range r.Segs {
+		if s.Type == SegEntry {
+			return s.LoadAddress, nil
+		}
+	}
+	return 0, fmt.Errorf("%q: no entry segment", r.File.Name)
+}
+
+// Segment returns the decompressed data of segment n. The offsets of the
+// segments count from the start of the segment table, while FData holds
+// what follows the table. BSS and entry segments have no data.
+func (r *PayloadRecord) Segment(n int) ([]byte, error) {
+	if n < 0 || n >= len(r.Segs) {
+		return nil, fmt.Errorf("%q: no segment #%d, there are %d", r.File.Name, n, len(r.Segs))
+	}
+	s := r.Segs[n]
+	if s.Type == SegBSS || s.Type == SegEntry {
+		return nil, nil
+	}
+	table := uint32(len(r.Segs) * binary.Size(PayloadHeader{}))
+	if s.Offset < table || uint64(s.Offset-table)+uint64(s.Size) > uint64(len(r.FData)) {
+		return nil, fmt.Errorf("%q: segment #%d [%#x, %#x) is outside of the payload", r.File.Name, n, s.Offset, uint64(s.Offset)+uint64(s.Size))
+	}
+	d, err := decompress(s.Compression, r.FData[s.Offset-table:s.Offset-table+s.Size])
+	if err != nil {
+		return nil, fmt.Errorf("%q: segment #%d: %w", r.File.Name, n, err)
+	}
+	if uint32(len(d)) > s.MemSize {
+		return nil, fmt.Errorf("%q: segment #%d is %#x bytes, more than its memory size %#x", r.File.Name, n, len(d), s.MemSize)
+	}
+	return d, nil
+}
+
 func (r *PayloadRecord) GetFile() *File {
 	return &r.File
 }
@@ -0,0 +1,76 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cbfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestPayloadSegments(t *testing.T) {
+	code := []byte(strings.Repeat("code", 0x100))
+	data := []byte("data")
+	lzma, err := LZMA.Compressor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	packed, err := lzma.Encode(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table := uint32(4 * binary.Size(PayloadHeader{}))
+	segs := []PayloadHeader{
+		{Type: SegCode, Compression: LZMA, Offset: table, LoadAddress: 0x100000, Size: uint32(len(packed)), MemSize: uint32(len(code))},
+		{Type: SegData, Compression: None, Offset: table + uint32(len(packed)), LoadAddress: 0x200000, Size: uint32(len(data)), MemSize: 0x10},
+		{Type: SegBSS, LoadAddress: 0x300000, MemSize: 0x1000},
+		{Type: SegEntry, LoadAddress: 0x100010},
+	}
+	var b bytes.Buffer
+	if err := Write(&b, segs); err != nil {
+		t.Fatal(err)
+	}
+	b.Write(packed)
+	b.Write(data)
+
+	s, err := NewRecord("payload", TypeSELF, nil, b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := s.(*PayloadRecord)
+	if len(p.Segs) != len(segs) {
+		t.Fatalf("got %d segments, want %d", len(p.Segs), len(segs))
+	}
+	entry, err := p.Entry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry != 0x100010 {
+		t.Errorf("got entry %#x, want %#x", entry, 0x100010)
+	}
+	for n, want := range [][]byte{code, data, nil, nil} {
+		got, err := p.Segment(n)
+		if err != nil {
+			t.Fatalf("segment #%d: %v", n, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("segment #%d: got %#x bytes, want %#x", n, len(got), len(want))
+		}
+	}
+	if _, err := p.Segment(len(segs)); err == nil {
+		t.Errorf("segment #%d: got nil, want error", len(segs))
+	}
+
+	// The record is written back unchanged.
+	got, err := recordBytes(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(got, b.Bytes()) {
+		t.Errorf("the written payload differs from the original")
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cbfs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// cbfstoolTypeNames are the names cbfstool gives to the file types.
+var cbfstoolTypeNames = map[FileType]string{
+	TypeBootBlock:   "bootblock",
+	TypeMaster:      "cbfs header",
+	TypeLegacyStage: "legacy stage",
+	TypeStage:       "stage",
+	TypeSELF:        "simple elf",
+	TypeFIT:         "fit",
+	TypeOptionRom:   "optionrom",
+	TypeBootSplash:  "bootsplash",
+	TypeRaw:         "raw",
+	TypeVSA:         "vsa",
+	TypeMBI:         "mbi",
+	TypeMicroCode:   "microcode",
+	TypeFSP:         "fsp",
+	TypeMRC:         "mrc",
+	TypeMMA:         "mma",
+	TypeEFI:         "efi",
+	TypeStruct:      "struct",
+	TypeDeleted:     "deleted",
+	TypeDeleted2:    "null",
+	TypeCMOS:        "cmos_default",
+	TypeSPD:         "spd",
+	TypeMRCCache:    "mrc_cache",
+	TypeCMOSLayout:  "cmos_layout",
+}
+
+// cbfstoolCompressionNames are the names cbfstool gives to the compressions.
+var cbfstoolCompressionNames = map[Compression]string{
+	None: "none",
+	LZMA: "LZMA",
+	LZ4:  "LZ4",
+}
+
+// CbfstoolString returns the listing of the image as "cbfstool print" prints
+// it, with the same columns and order.
+func (i *Image) CbfstoolString() string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "FMAP REGION: %s\n", i.Area.Name.String())
+	fmt.Fprintf(&s, "%-30s %-10s %-12s %8s %-4s\n", "Name", "Offset", "Type", "Size", "Comp")
+	for _, seg := range i.Segs {
+		f := seg.GetFile()
+		name := f.Name
+		if name == "" {
+			name = "(empty)"
+		}
+		typ, ok := cbfstoolTypeNames[f.Type]
+		if !ok {
+			typ = "(unknown)"
+		}
+		comp := "none"
+		if c, err := f.compressionAttr(); err == nil {
+			if comp, ok = cbfstoolCompressionNames[c.Compression]; !ok {
+				comp = "(unknown)"
+			}
+			if c.Compression != None {
+				comp = fmt.Sprintf("%s (%d decompressed)", comp, c.DecompressedSize)
+			}
+		}
+		fmt.Fprintf(&s, "%-30s 0x%-8x %-12s %8d %-4s\n", name, f.RecordStart, typ, f.FileHeader.Size, comp)
+	}
+	return s.String()
+}
@@ -5,6 +5,8 @@
 package cbfs
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
@@ -30,6 +32,13 @@ func (r *LegacyStageRecord) Read(in io.ReadSeeker) error {
 		return err
 	}
 	Debug("Got StageHeader %s, data is %d bytes", r.String(), r.StageHeader.Size)
+	left, err := remaining(in)
+	if err != nil {
+		return err
+	}
+	if uint64(r.StageHeader.Size) > uint64(left) {
+		return fmt.Errorf("stage of %#x bytes is larger than the %#x bytes of its file", r.StageHeader.Size, left)
+	}
 	r.Data = make([]byte, r.StageHeader.Size)
 	n, err := in.Read(r.Data)
 	if err != nil {
@@ -49,7 +58,35 @@ func (h *StageHeader) String() string {
 }
 
 func (r *LegacyStageRecord) String() string {
-	return recString(r.File.Name, r.RecordStart, r.Type.String(), r.Size, r.File.Compression().String())
+	return recString(r.File.Name, r.RecordStart, r.Type.String(), r.Size, r.StageHeader.Compression.String())
+}
+
+// MarshalJSON gives the compression of the stage header, which legacy
+// stages use instead of the compression attribute.
+func (r *LegacyStageRecord) MarshalJSON() ([]byte, error) {
+	m, err := r.File.mFile()
+	if err != nil {
+		return nil, err
+	}
+	m.Compression = r.StageHeader.Compression.String()
+	return json.Marshal(m)
+}
+
+// Decompress returns the stage with its data decompressed, so it can be
+// added again as an uncompressed stage.
+func (r *LegacyStageRecord) Decompress() ([]byte, error) {
+	d, err := decompress(r.StageHeader.Compression, r.Data)
+	if err != nil {
+		return nil, fmt.Errorf("%q: %w", r.File.Name, err)
+	}
+	h := r.StageHeader
+	h.Compression, h.Size = None, uint32(len(d))
+	var b bytes.Buffer
+	if err := WriteLE(&b, h); err != nil {
+		return nil, err
+	}
+	b.Write(d)
+	return b.Bytes(), nil
 }
 
 func (r *LegacyStageRecord) Write(w io.Writer) error {
@@ -70,7 +107,16 @@ func NewStageRecord(f *File) (ReadWriter, error) {
 }
 
 func (r *StageRecord) Read(in io.ReadSeeker) error {
-	return nil
+	var err error
+	if r.Data, err = io.ReadAll(in); err != nil {
+		return err
+	}
+	a, err := r.FindAttribute(SHCB)
+	if err != nil {
+		Debug("Stage %q has no stage header: %v", r.File.Name, err)
+		return nil
+	}
+	return Read(bytes.NewReader(a), &r.FileAttrStageHeader)
 }
 
 func (h *FileAttrStageHeader) String() string {
@@ -92,3 +138,71 @@ func (r *StageRecord) Write(w io.Writer) error {
 func (r *StageRecord) GetFile() *File {
 	return &r.File
 }
+
+// StageInfo tells where a stage is loaded and entered.
+type StageInfo struct {
+	LoadAddress uint64
+	Entry       uint64
+	MemSize     uint32
+}
+
+// Check sanity-checks that the entry point is in the memory of the stage
+// and that its dataSize bytes, once decompressed, fit. Relocatable modules,
+// such as the ramstage, are loaded at 0 and their entry point is not
+// checked.
+func (s StageInfo) Check(dataSize uint64) error {
+	if dataSize > uint64(s.MemSize) {
+		return fmt.Errorf("%#x bytes of data do not fit in %#x bytes of memory", dataSize, s.MemSize)
+	}
+	if s.LoadAddress != 0 && (s.Entry < s.LoadAddress || s.Entry >= s.LoadAddress+uint64(s.MemSize)) {
+		return fmt.Errorf("entry point %#x is outside of the memory [%#x, %#x)", s.Entry, s.LoadAddress, s.LoadAddress+uint64(s.MemSize))
+	}
+	return nil
+}
+
+// StageInfo returns the load address, entry point and memory size of the
+// stage header.
+func (r *LegacyStageRecord) StageInfo() StageInfo {
+	return StageInfo{LoadAddress: r.LoadAddress, Entry: r.Entry, MemSize: r.MemSize}
+}
+
+// CheckStage sanity-checks the stage, decompressing its data.
+func (r *LegacyStageRecord) CheckStage() error {
+	d, err := decompress(r.StageHeader.Compression, r.Data)
+	if err != nil {
+		return fmt.Errorf("%q: %w", r.File.Name, err)
+	}
+	if err := r.StageInfo().Check(uint64(len(d))); err != nil {
+		return fmt.Errorf("%q: %w", r.File.Name, err)
+	}
+	return nil
+}
+
+// StageInfo returns the load address, entry point and memory size of the
+// stage header attribute.
+func (r *StageRecord) StageInfo() StageInfo {
+	h := r.FileAttrStageHeader
+	return StageInfo{LoadAddress: h.LoadAddress, Entry: h.LoadAddress + uint64(h.EntryOffset), MemSize: h.MemSize}
+}
+
+// CheckStage sanity-checks the stage, decompressing its data.
+func (r *StageRecord) CheckStage() error {
+	if r.FileAttrStageHeader.Tag != SHCB {
+		return fmt.Errorf("%q: no stage header attribute", r.File.Name)
+	}
+	d, err := r.File.Decompress()
+	if err != nil {
+		return err
+	}
+	if err := r.StageInfo().Check(uint64(len(d))); err != nil {
+		return fmt.Errorf("%q: %w", r.File.Name, err)
+	}
+	return nil
+}
+
+// Stage is implemented by the legacy and the current stage records.
+type Stage interface {
+	ReadWriter
+	StageInfo() StageInfo
+	CheckStage() error
+}
@@ -0,0 +1,83 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cbfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestLegacyStageInfo(t *testing.T) {
+	i := openTestImage(t)
+	r, err := i.Lookup("fallback/romstage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, ok := r.(Stage)
+	if !ok {
+		t.Fatalf("%v is not a stage", r)
+	}
+	want := StageInfo{LoadAddress: 0xfffc0300, Entry: 0xfffc0320, MemSize: 0x3da8}
+	if got := s.StageInfo(); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	for _, n := range []string{"fallback/romstage", "fallback/ramstage"} {
+		r, err := i.Lookup(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.(Stage).CheckStage(); err != nil {
+			t.Errorf("%q: %v", n, err)
+		}
+	}
+}
+
+func newTestStage(t *testing.T, h FileAttrStageHeader, data []byte) Stage {
+	t.Helper()
+	h.Tag, h.Size = SHCB, uint32(binary.Size(h))
+	var b bytes.Buffer
+	if err := Write(&b, h); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRecord("stage", TypeStage, b.Bytes(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r.(Stage)
+}
+
+func TestStageInfo(t *testing.T) {
+	data := make([]byte, 0x100)
+	for _, tt := range []struct {
+		name string
+		h    FileAttrStageHeader
+		ok   bool
+	}{
+		{name: "good", h: FileAttrStageHeader{LoadAddress: 0x100000, EntryOffset: 0x10, MemSize: 0x200}, ok: true},
+		{name: "relocatable", h: FileAttrStageHeader{EntryOffset: 0x4000000, MemSize: 0x200}, ok: true},
+		{name: "entry outside", h: FileAttrStageHeader{LoadAddress: 0x100000, EntryOffset: 0x200, MemSize: 0x200}},
+		{name: "too small", h: FileAttrStageHeader{LoadAddress: 0x100000, MemSize: 0x80}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStage(t, tt.h, data)
+			want := StageInfo{LoadAddress: tt.h.LoadAddress, Entry: tt.h.LoadAddress + uint64(tt.h.EntryOffset), MemSize: tt.h.MemSize}
+			if got := s.StageInfo(); got != want {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+			if err := s.CheckStage(); (err == nil) != tt.ok {
+				t.Errorf("got %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+
+	r, err := NewRecord("stage", TypeStage, nil, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.(Stage).CheckStage(); err == nil {
+		t.Errorf("stage without header: got nil, want error")
+	}
+}
@@ -109,6 +109,23 @@ type mFile struct {
 	Size        uint32
 	Type        string
 	Compression string
+	DataOffset  uint32
+	Attributes  []mAttr `json:",omitempty"`
+}
+
+// mAttr is an attribute with the fields of its type.
+type mAttr struct {
+	Tag              string
+	Size             uint32
+	Compression      string `json:",omitempty"`
+	DecompressedSize uint32 `json:",omitempty"`
+	HashAlgorithm    string `json:",omitempty"`
+	Hash             string `json:",omitempty"`
+	Position         uint32 `json:",omitempty"`
+	Alignment        uint32 `json:",omitempty"`
+	LoadAddress      uint64 `json:",omitempty"`
+	Entry            uint64 `json:",omitempty"`
+	MemSize          uint32 `json:",omitempty"`
 }
 
 // The common fields of extended cbfs file attributes.
@@ -141,7 +158,7 @@ type FileAttrCompression struct {
 type FileAttrHash struct {
 	Tag      Tag
 	Size     uint32 // includes everything including data.
-	HashType uint32
+	HashType HashAlgorithm
 	Data     []byte
 }
 
@@ -318,6 +335,9 @@ type ReadWriter interface {
 	String() string
 	Read(r io.ReadSeeker) error
 	Write(f io.Writer) error
+	// Decompress returns the data, decompressed according to the
+	// compression attribute or to the subheaders of the record.
+	Decompress() ([]byte, error)
 }
 
 type Image struct {
@@ -0,0 +1,472 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cbfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// emptyHeaderLen is the size of the header of an empty file: the file header
+// and an empty name padded to 16 bytes.
+const emptyHeaderLen = FileSize + 16
+
+func alignUp(v, a uint32) uint32 {
+	return (v + a - 1) &^ (a - 1)
+}
+
+// newRecord returns the record of the registered type of f, reading its
+// subheaders from FData.
+func newRecord(f *File) (ReadWriter, error) {
+	sr, ok := SegReaders[f.Type]
+	if !ok {
+		Debug("No match found for type %v, %v", f.Type, ok)
+		sr = &SegReader{Type: f.Type, Name: "Unknown", New: NewUnknownRecord}
+	}
+	s, err := sr.New(f)
+	if err != nil {
+		return nil, err
+	}
+	Debug("Segment: %v", s)
+	if err := s.Read(bytes.NewReader(f.FData)); err != nil {
+		return nil, fmt.Errorf("reading %#x byte subheader, type %v: %w", len(f.FData), f.Type, err)
+	}
+	return s, nil
+}
+
+// NewRecord creates a file to be added to an image. The name is padded to 16
+// bytes and the attributes, if any, follow it as cbfstool lays them out.
+func NewRecord(name string, t FileType, attr, data []byte) (ReadWriter, error) {
+	if name == "" {
+		return nil, fmt.Errorf("a CBFS file needs a name")
+	}
+	nameLen := alignUp(uint32(len(name)+1), 16)
+	f := &File{
+		FileHeader: FileHeader{
+			Size:            uint32(len(data)),
+			Type:            t,
+			SubHeaderOffset: FileSize + nameLen + uint32(len(attr)),
+		},
+		Name:  name,
+		Attr:  attr,
+		FData: data,
+	}
+	copy(f.Magic[:], FileMagic)
+	if len(attr) != 0 {
+		f.AttrOffset = FileSize + nameLen
+	}
+	return newRecord(f)
+}
+
+// NewCompressedRecord creates a file holding data compressed with c, and a
+// compression attribute giving its decompressed size, as cbfstool add -c
+// does. The data is stored as is for None.
+func NewCompressedRecord(name string, t FileType, c Compression, data []byte) (ReadWriter, error) {
+	compressor, err := c.Compressor()
+	if err != nil {
+		return nil, err
+	}
+	if compressor == nil {
+		return NewRecord(name, t, nil, data)
+	}
+	d, err := compressor.Encode(data)
+	if err != nil {
+		return nil, fmt.Errorf("%v compression of %q: %w", c, name, err)
+	}
+	attr := FileAttrCompression{
+		Tag:              Compressed,
+		Size:             uint32(binary.Size(FileAttrCompression{})),
+		Compression:      c,
+		DecompressedSize: uint32(len(data)),
+	}
+	var b bytes.Buffer
+	if err := Write(&b, attr); err != nil {
+		return nil, err
+	}
+	return NewRecord(name, t, b.Bytes(), d)
+}
+
+// newEmptyRecord returns an empty file spanning size bytes at start.
+func newEmptyRecord(start, size uint32) (ReadWriter, error) {
+	f := &File{
+		FileHeader: FileHeader{
+			Size:            size - emptyHeaderLen,
+			Type:            TypeDeleted2,
+			SubHeaderOffset: emptyHeaderLen,
+		},
+		RecordStart: start,
+	}
+	copy(f.Magic[:], FileMagic)
+	return NewEmptyRecord(f)
+}
+
+// recordBytes serializes the file header, name, attributes and data of s.
+func recordBytes(s ReadWriter) ([]byte, error) {
+	f := s.GetFile()
+	nameEnd := f.SubHeaderOffset
+	if f.AttrOffset != 0 {
+		nameEnd = f.AttrOffset
+	}
+	if nameEnd < FileSize || nameEnd > f.SubHeaderOffset || uint32(len(f.Name)) > nameEnd-FileSize {
+		return nil, fmt.Errorf("%q: bad offsets: attributes at %#x, data at %#x", f.Name, f.AttrOffset, f.SubHeaderOffset)
+	}
+
+	var b bytes.Buffer
+	if err := Write(&b, f.FileHeader); err != nil {
+		return nil, err
+	}
+	// The name is padded with zeros to 16 bytes, any space left before the
+	// attributes or data with 0xff.
+	name := ffbyte(nameEnd - FileSize)
+	copy(name, make([]byte, alignUp(uint32(len(f.Name)+1), 16)))
+	copy(name, f.Name)
+	b.Write(name)
+	if f.AttrOffset != 0 {
+		attr := make([]byte, f.SubHeaderOffset-f.AttrOffset)
+		copy(attr, f.Attr)
+		b.Write(attr)
+	}
+	if err := s.Write(&b); err != nil {
+		return nil, fmt.Errorf("writing cbfs record for %v: %w", s, err)
+	}
+	if want := f.SubHeaderOffset + f.Size; uint32(b.Len()) != want {
+		return nil, fmt.Errorf("%q: record is %#x bytes, header says %#x", f.Name, b.Len(), want)
+	}
+	return b.Bytes(), nil
+}
+
+// Alignment returns the alignment of the files of the image, from the master
+// header if there is one.
+func (i *Image) Alignment() uint32 {
+	if m := i.master(); m != nil && m.Align != 0 {
+		return m.Align
+	}
+	return Alignment
+}
+
+// find returns the index of the file named n.
+func (i *Image) find(n string) (int, error) {
+	for x, s := range i.Segs {
+		if f := s.GetFile(); !f.Deleted() && f.Name == n {
+			return x, nil
+		}
+	}
+	return -1, fmt.Errorf("%q: %w", n, os.ErrNotExist)
+}
+
+// Lookup returns the file named n.
+func (i *Image) Lookup(n string) (ReadWriter, error) {
+	x, err := i.find(n)
+	if err != nil {
+		return nil, err
+	}
+	return i.Segs[x], nil
+}
+
+// recordEnd returns the end of the space owned by file x: the start of the
+// next file, or the end of the CBFS for the last one.
+func (i *Image) recordEnd(x int) uint32 {
+	if x+1 < len(i.Segs) {
+		return i.Segs[x+1].GetFile().RecordStart
+	}
+	f := i.Segs[x].GetFile()
+	return alignUp(f.RecordStart+f.SubHeaderOffset+f.Size, i.Alignment())
+}
+
+// Size returns the size of the CBFS, the end of its last file. It is the size
+// of the FMAP area unless the CBFS was truncated.
+func (i *Image) Size() uint32 {
+	if len(i.Segs) == 0 {
+		return 0
+	}
+	return i.recordEnd(len(i.Segs) - 1)
+}
+
+// splice replaces the files [start, end) with segs.
+func (i *Image) splice(start, end int, segs ...ReadWriter) {
+	i.Segs = append(i.Segs[:start], append(segs, i.Segs[end:]...)...)
+}
+
+// checkNew checks that there is no file named like f yet.
+func (i *Image) checkNew(f *File) error {
+	if _, err := i.find(f.Name); err == nil {
+		return fmt.Errorf("%q: %w", f.Name, os.ErrExist)
+	}
+	return nil
+}
+
+// Add places r in the first empty space large enough for it. The rest of the
+// empty space remains an empty file. Call Update to write the change to
+// Data.
+func (i *Image) Add(r ReadWriter) error {
+	f := r.GetFile()
+	if err := i.checkNew(f); err != nil {
+		return err
+	}
+	need := alignUp(f.SubHeaderOffset+f.Size, i.Alignment())
+	for x, s := range i.Segs {
+		e := s.GetFile()
+		if !e.Deleted() {
+			continue
+		}
+		start, end := e.RecordStart, i.recordEnd(x)
+		if end-start != need && end-start < need+emptyHeaderLen {
+			continue
+		}
+		f.RecordStart = start
+		segs := []ReadWriter{r}
+		if end-start != need {
+			del, err := newEmptyRecord(start+need, end-start-need)
+			if err != nil {
+				return err
+			}
+			segs = append(segs, del)
+		}
+		Debug("Add: %q at %#x, %#x bytes", f.Name, start, need)
+		i.splice(x, x+1, segs...)
+		return nil
+	}
+	return fmt.Errorf("no empty space of %#x bytes for %q", need, f.Name)
+}
+
+// Remove turns the file named n into empty space, merged with the empty
+// files around it. The master header and the x86 bootblock can not be
+// removed. Call Update to write the change to Data.
+func (i *Image) Remove(n string) error {
+	found, err := i.find(n)
+	if err != nil {
+		return err
+	}
+	// You can not remove the master header
+	// Just remake the cbfs if you're doing that kind of surgery.
+	if i.Segs[found].GetFile().Type == TypeMaster {
+		return os.ErrPermission
+	}
+	// Bootblock on x86 is at the end of CBFS and shall stay untouched.
+	if found == len(i.Segs)-1 && i.Segs[found].GetFile().Type == TypeBootBlock {
+		return os.ErrPermission
+	}
+	start, end := found, found+1
+	if start > 0 && i.Segs[start-1].GetFile().Deleted() {
+		start--
+	}
+	if end < len(i.Segs) && i.Segs[end].GetFile().Deleted() {
+		end++
+	}
+	base, top := i.Segs[start].GetFile().RecordStart, i.recordEnd(end-1)
+	Debug("Remove: empty range [%d:%d], base %#x top %#x", start, end, base, top)
+	del, err := newEmptyRecord(base, top-base)
+	if err != nil {
+		return err
+	}
+	i.splice(start, end, del)
+	return nil
+}
+
+// Replace replaces the data of the file named n. The file keeps its name,
+// type and compression but loses its other attributes. It is removed and
+// added again, so it may move. Call Update to write the change to Data.
+func (i *Image) Replace(n string, data []byte) error {
+	x, err := i.find(n)
+	if err != nil {
+		return err
+	}
+	f := i.Segs[x].GetFile()
+	r, err := NewCompressedRecord(n, f.Type, f.Compression(), data)
+	if err != nil {
+		return err
+	}
+	segs := append([]ReadWriter{}, i.Segs...)
+	if err := i.Remove(n); err != nil {
+		return err
+	}
+	if err := i.Add(r); err != nil {
+		i.Segs = segs
+		return err
+	}
+	return nil
+}
+
+// placeAt places r in the empty file x so that its data starts at off, if it
+// fits. The header of r, hdr bytes long at least, grows so that the file
+// starts aligned, as cbfstool does. The space left around the file remains
+// empty files.
+func (i *Image) placeAt(x int, r ReadWriter, hdr, off uint32) (bool, error) {
+	f := r.GetFile()
+	start, end := i.Segs[x].GetFile().RecordStart, i.recordEnd(x)
+	a := i.Alignment()
+	if off < start+hdr {
+		return false, nil
+	}
+	rs := (off - hdr) &^ (a - 1)
+	for rs > start && rs-start < emptyHeaderLen {
+		if rs < a {
+			return false, nil
+		}
+		rs -= a
+	}
+	recEnd := alignUp(off+f.Size, a)
+	if rs < start || recEnd > end || (recEnd != end && end-recEnd < emptyHeaderLen) {
+		return false, nil
+	}
+	var segs []ReadWriter
+	if rs > start {
+		del, err := newEmptyRecord(start, rs-start)
+		if err != nil {
+			return false, err
+		}
+		segs = append(segs, del)
+	}
+	f.RecordStart, f.SubHeaderOffset = rs, off-rs
+	segs = append(segs, r)
+	if recEnd != end {
+		del, err := newEmptyRecord(recEnd, end-recEnd)
+		if err != nil {
+			return false, err
+		}
+		segs = append(segs, del)
+	}
+	Debug("placeAt: %q at %#x, data at %#x", f.Name, rs, off)
+	i.splice(x, x+1, segs...)
+	return true, nil
+}
+
+// AddAt places r so that its data starts at offset off of the CBFS, as
+// cbfstool add -b does for execute-in-place stages and the x86 bootblock.
+// See TopAlignedOffset for x86 addresses. Call Update to write the change to
+// Data.
+func (i *Image) AddAt(r ReadWriter, off uint32) error {
+	f := r.GetFile()
+	if err := i.checkNew(f); err != nil {
+		return err
+	}
+	for x, s := range i.Segs {
+		e := s.GetFile()
+		if !e.Deleted() || off < e.RecordStart || off >= i.recordEnd(x) {
+			continue
+		}
+		ok, err := i.placeAt(x, r, f.SubHeaderOffset, off)
+		if err != nil || ok {
+			return err
+		}
+		break
+	}
+	return fmt.Errorf("no empty space for %q with its data at %#x", f.Name, off)
+}
+
+// AddAligned places r in the first empty space where its data can start at a
+// multiple of align, as cbfstool add -a does. Call Update to write the change
+// to Data.
+func (i *Image) AddAligned(r ReadWriter, align uint32) error {
+	f := r.GetFile()
+	if align == 0 || align&(align-1) != 0 {
+		return fmt.Errorf("alignment %#x is not a power of 2", align)
+	}
+	if err := i.checkNew(f); err != nil {
+		return err
+	}
+	hdr := f.SubHeaderOffset
+	for x, s := range i.Segs {
+		e := s.GetFile()
+		if !e.Deleted() {
+			continue
+		}
+		end := i.recordEnd(x)
+		for off := alignUp(e.RecordStart+hdr, align); off+f.Size <= end; off += align {
+			ok, err := i.placeAt(x, r, hdr, off)
+			if err != nil || ok {
+				return err
+			}
+		}
+	}
+	return fmt.Errorf("no empty space for %q with its data aligned to %#x", f.Name, align)
+}
+
+// TopAlignedOffset returns the offset in the CBFS of addr, an x86 address
+// of the flash, whose end is mapped at 4GiB.
+func (i *Image) TopAlignedOffset(addr uint32) (uint32, error) {
+	base := uint64(1<<32) - uint64(len(i.Data))
+	if uint64(addr) < base {
+		return 0, fmt.Errorf("address %#x is below the flash mapped at %#x", addr, base)
+	}
+	off := uint64(addr) - base
+	if off < uint64(i.Area.Offset) || off >= uint64(i.Area.Offset)+uint64(i.Area.Size) {
+		return 0, fmt.Errorf("address %#x is outside of %v", addr, i.Area.Name.String())
+	}
+	return uint32(off) - i.Area.Offset, nil
+}
+
+// usedSize returns the end of the last file which is not empty.
+func (i *Image) usedSize() uint32 {
+	for x := len(i.Segs) - 1; x >= 0; x-- {
+		if !i.Segs[x].GetFile().Deleted() {
+			return i.recordEnd(x)
+		}
+	}
+	return 0
+}
+
+// Resize grows or shrinks the CBFS to size bytes of its FMAP area, by
+// replacing the empty files which end it with one empty file, or none. The files, the
+// master header among them, stay in place. The x86 bootblock ends the area,
+// so a CBFS ending with it can not be resized. Call Update to write the
+// change to Data: the part of the area after the CBFS is erased.
+func (i *Image) Resize(size uint32) error {
+	if size > i.Area.Size {
+		return fmt.Errorf("size %#x is larger than area %s (%#x bytes)", size, i.Area.Name.String(), i.Area.Size)
+	}
+	if size != i.Area.Size && size%i.Alignment() != 0 {
+		return fmt.Errorf("size %#x is not aligned to %#x", size, i.Alignment())
+	}
+	x := len(i.Segs)
+	if x > 0 && i.Segs[x-1].GetFile().Type == TypeBootBlock {
+		if size != i.Area.Size {
+			return fmt.Errorf("the bootblock ends the CBFS: %w", os.ErrPermission)
+		}
+		return nil
+	}
+	used := i.usedSize()
+	if size < used {
+		return fmt.Errorf("size %#x is smaller than the files, which end at %#x", size, used)
+	}
+	for x > 0 && i.Segs[x-1].GetFile().Deleted() {
+		x--
+	}
+	var segs []ReadWriter
+	if size > used {
+		if size-used < emptyHeaderLen {
+			return fmt.Errorf("%#x bytes after the files are too few for an empty file", size-used)
+		}
+		del, err := newEmptyRecord(used, size-used)
+		if err != nil {
+			return err
+		}
+		segs = append(segs, del)
+	}
+	Debug("Resize: %#x -> %#x bytes, files end at %#x", i.Size(), size, used)
+	i.splice(x, len(i.Segs), segs...)
+	return nil
+}
+
+// Truncate shrinks the CBFS to the end of its last file and returns its new
+// size, as cbfstool truncate does. See Resize.
+func (i *Image) Truncate() (uint32, error) {
+	size := i.usedSize()
+	if x := len(i.Segs); x > 0 && i.Segs[x-1].GetFile().Type == TypeBootBlock {
+		size = i.Area.Size
+	}
+	if err := i.Resize(size); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// Expand grows the CBFS to its whole FMAP area, as cbfstool expand does. See
+// Resize.
+func (i *Image) Expand() error {
+	return i.Resize(i.Area.Size)
+}
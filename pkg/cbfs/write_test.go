@@ -0,0 +1,519 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cbfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/compression"
+)
+
+func openTestImage(t *testing.T) *Image {
+	t.Helper()
+	i, err := Open("testdata/coreboot.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
+
+// reparse updates the image and parses the result.
+func reparse(t *testing.T, i *Image) *Image {
+	t.Helper()
+	if err := i.Update(); err != nil {
+		t.Fatal(err)
+	}
+	n, err := NewImage(bytes.NewReader(i.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func findFile(i *Image, n string) *File {
+	for _, s := range i.Segs {
+		if f := s.GetFile(); !f.Deleted() && f.Name == n {
+			return f
+		}
+	}
+	return nil
+}
+
+// checkLayout checks that the files are aligned and cover the CBFS without
+// overlapping.
+func checkLayout(t *testing.T, i *Image) {
+	t.Helper()
+	for x, s := range i.Segs {
+		f := s.GetFile()
+		if f.RecordStart%i.Alignment() != 0 && f.Type != TypeBootBlock {
+			t.Errorf("%q at %#x is not aligned", f.Name, f.RecordStart)
+		}
+		if x+1 < len(i.Segs) && f.RecordStart+f.SubHeaderOffset+f.Size > i.Segs[x+1].GetFile().RecordStart {
+			t.Errorf("%q at %#x overlaps the next file", f.Name, f.RecordStart)
+		}
+	}
+}
+
+func TestUpdateUnchanged(t *testing.T) {
+	i := openTestImage(t)
+	old := append([]byte{}, i.Data...)
+	if err := i.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(old, i.Data) {
+		t.Errorf("updating an unchanged image changed it")
+	}
+}
+
+func TestAdd(t *testing.T) {
+	i := openTestImage(t)
+	data := []byte(strings.Repeat("fiano", 100))
+	r, err := NewRecord("added", TypeRaw, nil, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Add(r); err != nil {
+		t.Fatal(err)
+	}
+	n := reparse(t, i)
+	checkLayout(t, n)
+	f := findFile(n, "added")
+	if f == nil {
+		t.Fatalf("added file not found in %v", n)
+	}
+	if f.Type != TypeRaw || !bytes.Equal(f.FData, data) {
+		t.Errorf("got %v %q, want %v %q", f.Type, f.FData, TypeRaw, data)
+	}
+	if len(n.Segs) != len(i.Segs) {
+		t.Errorf("got %d files, want %d", len(n.Segs), len(i.Segs))
+	}
+
+	if err := i.Add(r); !errors.Is(err, os.ErrExist) {
+		t.Errorf("adding twice: got %v, want %v", err, os.ErrExist)
+	}
+	big, err := NewRecord("big", TypeRaw, nil, make([]byte, len(i.Data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Add(big); err == nil {
+		t.Errorf("adding a file larger than the image: got nil, want error")
+	}
+}
+
+func TestRemove(t *testing.T) {
+	i := openTestImage(t)
+	before := len(i.Segs)
+	for _, name := range []string{"fallback/payload", "compression_test1", "compression_test2"} {
+		if err := i.Remove(name); err != nil {
+			t.Fatalf("removing %q: %v", name, err)
+		}
+	}
+	n := reparse(t, i)
+	checkLayout(t, n)
+	for _, name := range []string{"fallback/payload", "compression_test1", "compression_test2"} {
+		if findFile(n, name) != nil {
+			t.Errorf("%q is still there", name)
+		}
+	}
+	// The files and the empty files around them merge into one empty file.
+	if want := before - 4; len(n.Segs) != want {
+		t.Errorf("got %d files, want %d:\n%v", len(n.Segs), want, n)
+	}
+	if f := findFile(n, "config"); f == nil || !strings.Contains(string(f.FData), "CONFIG") {
+		t.Errorf("other files are damaged: config is %v", f)
+	}
+
+	for _, name := range []string{"cbfs master header", "bootblock"} {
+		if err := i.Remove(name); !errors.Is(err, os.ErrPermission) {
+			t.Errorf("removing %q: got %v, want %v", name, err, os.ErrPermission)
+		}
+	}
+	if err := i.Remove("nonexistent"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("removing a nonexistent file: got %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	i := openTestImage(t)
+	for _, data := range [][]byte{[]byte("small"), bytes.Repeat([]byte("large"), 0x1000)} {
+		if err := i.Replace("revision", data); err != nil {
+			t.Fatal(err)
+		}
+		n := reparse(t, i)
+		checkLayout(t, n)
+		f := findFile(n, "revision")
+		if f == nil || f.Type != TypeRaw || !bytes.Equal(f.FData, data) {
+			t.Errorf("got %v, want a raw file holding %#x bytes", f, len(data))
+		}
+	}
+	if err := i.Replace("revision", make([]byte, len(i.Data))); err == nil {
+		t.Errorf("replacing with data larger than the image: got nil, want error")
+	}
+	if findFile(i, "revision") == nil {
+		t.Errorf("a failed replacement removed the file")
+	}
+}
+
+func TestAddCompressed(t *testing.T) {
+	data := []byte(strings.Repeat("FIANO ROCKS!\n", 1024))
+	for _, c := range []Compression{None, LZMA, LZ4} {
+		t.Run(c.String(), func(t *testing.T) {
+			i := openTestImage(t)
+			r, err := NewCompressedRecord("compressed", TypeRaw, c, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := i.Add(r); err != nil {
+				t.Fatal(err)
+			}
+			f := findFile(reparse(t, i), "compressed")
+			if f == nil {
+				t.Fatal("added file not found")
+			}
+			if f.Compression() != c {
+				t.Errorf("got compression %v, want %v", f.Compression(), c)
+			}
+			if c != None {
+				comp, err := f.compressionAttr()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if comp.DecompressedSize != uint32(len(data)) {
+					t.Errorf("got decompressed size %#x, want %#x", comp.DecompressedSize, len(data))
+				}
+				if len(f.FData) >= len(data) {
+					t.Errorf("got %#x bytes stored, want less than %#x", len(f.FData), len(data))
+				}
+			}
+			d, err := f.Decompress()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(d, data) {
+				t.Errorf("got %q..., want %q...", d[:12], data[:12])
+			}
+		})
+	}
+}
+
+func TestAddRegisteredCompression(t *testing.T) {
+	const zstd Compression = 3
+	compression.RegisterCBFS(uint32(zstd), func() compression.Compressor { return &compression.Zstd{} })
+	c, err := ParseCompression("zstd")
+	if err != nil || c != zstd {
+		t.Fatalf("got %v, %v, want %v, nil", c, err, zstd)
+	}
+
+	data := []byte(strings.Repeat("FIANO ROCKS!\n", 1024))
+	i := openTestImage(t)
+	r, err := NewCompressedRecord("compressed", TypeRaw, c, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Add(r); err != nil {
+		t.Fatal(err)
+	}
+	f := findFile(reparse(t, i), "compressed")
+	if f == nil || f.Compression() != zstd {
+		t.Fatalf("got %v, want a file compressed with zstd", f)
+	}
+	if d, err := f.Decompress(); err != nil || !bytes.Equal(d, data) {
+		t.Errorf("got %v decompressing, want the data", err)
+	}
+}
+
+func TestReplaceCompressed(t *testing.T) {
+	i := openTestImage(t)
+	data := []byte(strings.Repeat("replaced\n", 100))
+	if err := i.Replace("compression_test2", data); err != nil {
+		t.Fatal(err)
+	}
+	f := findFile(reparse(t, i), "compression_test2")
+	if f == nil || f.Compression() != LZMA {
+		t.Fatalf("got %v, want an LZMA compressed file", f)
+	}
+	d, err := f.Decompress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(d, data) {
+		t.Errorf("got %q..., want %q...", d[:12], data[:12])
+	}
+}
+
+func TestMasterHeader(t *testing.T) {
+	i := openTestImage(t)
+	off, err := i.MasterHeaderPointer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if off != 0x238 {
+		t.Errorf("got master header at %#x, want %#x", off, 0x238)
+	}
+	if err := i.CheckMasterHeader(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Update fixes the pointer.
+	i.Data[len(i.Data)-1] ^= 0xff
+	if err := i.CheckMasterHeader(); err == nil {
+		t.Errorf("bad pointer: got nil, want error")
+	}
+	n := reparse(t, i)
+	if err := n.CheckMasterHeader(); err != nil {
+		t.Errorf("after update: %v", err)
+	}
+
+	// Update regenerates the ROM size and offset.
+	m := n.master()
+	m.RomSize, m.Offset = 0x1000, 0x10
+	if err := n.CheckMasterHeader(); err == nil {
+		t.Errorf("bad ROM size and offset: got nil, want error")
+	}
+	n = reparse(t, n)
+	if err := n.CheckMasterHeader(); err != nil {
+		t.Errorf("after update: %v", err)
+	}
+
+	m = n.master()
+	m.Align, m.RomSize = 48, 0x1000
+	err = n.CheckMasterHeader()
+	for _, want := range []string{"alignment 0x30", "ROM size is 0x1000"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("got %v, want an error about %q", err, want)
+		}
+	}
+}
+
+func TestAddAligned(t *testing.T) {
+	i := openTestImage(t)
+	data := []byte(strings.Repeat("aligned", 50))
+	for _, align := range []uint32{0x40, 0x1000, 0x10000} {
+		r, err := NewRecord(fmt.Sprintf("aligned%#x", align), TypeRaw, nil, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := i.AddAligned(r, align); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n := reparse(t, i)
+	checkLayout(t, n)
+	for _, align := range []uint32{0x40, 0x1000, 0x10000} {
+		f := findFile(n, fmt.Sprintf("aligned%#x", align))
+		if f == nil {
+			t.Fatalf("file aligned to %#x not found in %v", align, n)
+		}
+		if off := f.RecordStart + f.SubHeaderOffset; off%align != 0 {
+			t.Errorf("got data at %#x, want it aligned to %#x", off, align)
+		}
+		if !bytes.Equal(f.FData, data) {
+			t.Errorf("got %q, want %q", f.FData, data)
+		}
+	}
+	r, err := NewRecord("bad", TypeRaw, nil, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.AddAligned(r, 0x30); err == nil {
+		t.Errorf("alignment 0x30: got nil, want error")
+	}
+}
+
+func TestAddAt(t *testing.T) {
+	i := openTestImage(t)
+	data := []byte(strings.Repeat("xip", 100))
+	top, err := i.TopAlignedOffset(0xfffe0320)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if top != 0x20120 {
+		t.Errorf("got offset %#x for 0xfffe0320, want 0x20120", top)
+	}
+	for _, off := range []uint32{0x13040 + 0x28, 0x14000, top} {
+		r, err := NewRecord(fmt.Sprintf("xip%#x", off), TypeRaw, nil, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := i.AddAt(r, off); err != nil {
+			t.Fatalf("adding at %#x: %v", off, err)
+		}
+	}
+	n := reparse(t, i)
+	checkLayout(t, n)
+	for _, off := range []uint32{0x13040 + 0x28, 0x14000, top} {
+		f := findFile(n, fmt.Sprintf("xip%#x", off))
+		if f == nil || f.RecordStart+f.SubHeaderOffset != off || !bytes.Equal(f.FData, data) {
+			t.Errorf("got %v, want its data at %#x", f, off)
+		}
+		if d := n.Data[n.Area.Offset+off:]; !bytes.Equal(d[:len(data)], data) {
+			t.Errorf("got %q... at %#x, want %q...", d[:8], off, data[:8])
+		}
+	}
+
+	for _, off := range []uint32{0x100, 0x13110, n.Area.Size} {
+		r, err := NewRecord("bad", TypeRaw, nil, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := i.AddAt(r, off); err == nil {
+			t.Errorf("adding at %#x: got nil, want error", off)
+		}
+	}
+	for _, addr := range []uint32{0x1000, 0xfffc0000} {
+		if _, err := i.TopAlignedOffset(addr); err == nil {
+			t.Errorf("address %#x: got nil, want error", addr)
+		}
+	}
+}
+
+// withoutBootBlock replaces the bootblock of the test image, and the empty
+// file before it, with an empty file ending the CBFS, as in non-x86 images.
+func withoutBootBlock(t *testing.T, i *Image) {
+	t.Helper()
+	x := len(i.Segs) - 2
+	start := i.Segs[x].GetFile().RecordStart
+	del, err := newEmptyRecord(start, i.Area.Size-start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i.splice(x, len(i.Segs), del)
+}
+
+func TestResize(t *testing.T) {
+	i := openTestImage(t)
+	// The x86 bootblock ends the area.
+	if size, err := i.Truncate(); err != nil || size != i.Area.Size {
+		t.Errorf("truncating an x86 CBFS: got %#x, %v, want %#x", size, err, i.Area.Size)
+	}
+	if err := i.Resize(0x20000); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("resizing an x86 CBFS: got %v, want %v", err, os.ErrPermission)
+	}
+
+	withoutBootBlock(t, i)
+	// Split the empty file ending the CBFS: Truncate drops both parts.
+	last := i.Segs[len(i.Segs)-1].GetFile()
+	first, err := newEmptyRecord(last.RecordStart, 0x1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := newEmptyRecord(last.RecordStart+0x1000, i.Area.Size-last.RecordStart-0x1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i.splice(len(i.Segs)-1, len(i.Segs), first, second)
+	size, err := i.Truncate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 0x13040 {
+		t.Errorf("got size %#x, want %#x", size, 0x13040)
+	}
+	n := reparse(t, i)
+	checkLayout(t, n)
+	if n.Size() != size || findFile(n, "compression_test2") == nil || n.Segs[len(n.Segs)-1].GetFile().Deleted() {
+		t.Errorf("got a CBFS of %#x bytes:\n%v", n.Size(), n)
+	}
+	// The master header pointer ends the image.
+	if tail := n.Data[n.Area.Offset+size : len(n.Data)-masterPointerLen]; !bytes.Equal(tail, ffbyte(uint32(len(tail)))) {
+		t.Errorf("the area after the CBFS is not erased")
+	}
+	if err := n.CheckMasterHeader(); err != nil {
+		t.Error(err)
+	}
+	r, err := NewRecord("added", TypeRaw, nil, make([]byte, 0x1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Add(r); err == nil {
+		t.Errorf("adding to a truncated CBFS: got nil, want error")
+	}
+
+	for _, bad := range []uint32{size - 0x40, size + 0x10, n.Area.Size + 0x40} {
+		if err := n.Resize(bad); err == nil {
+			t.Errorf("resizing to %#x: got nil, want error", bad)
+		}
+	}
+	if err := n.Resize(0x20000); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Add(r); err != nil {
+		t.Fatal(err)
+	}
+	n = reparse(t, n)
+	checkLayout(t, n)
+	if n.Size() != 0x20000 || findFile(n, "added") == nil {
+		t.Errorf("got a CBFS of %#x bytes:\n%v", n.Size(), n)
+	}
+	if err := n.Expand(); err != nil {
+		t.Fatal(err)
+	}
+	if n = reparse(t, n); n.Size() != n.Area.Size {
+		t.Errorf("got a CBFS of %#x bytes, want %#x", n.Size(), n.Area.Size)
+	}
+}
+
+// TestEditResized adds and removes files in a CBFS whose last empty file
+// ends short of the FMAP area: the empty space stops at the end of the CBFS.
+func TestEditResized(t *testing.T) {
+	i := openTestImage(t)
+	withoutBootBlock(t, i)
+	if err := i.Resize(0x20000); err != nil {
+		t.Fatal(err)
+	}
+	free := 0x20000 - i.usedSize()
+
+	// The empty space up to the end of the area is not usable.
+	big, err := NewRecord("big", TypeRaw, nil, make([]byte, free))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Add(big); err == nil {
+		t.Errorf("adding %#x bytes past the end of the CBFS: got nil, want error", free)
+	}
+
+	r, err := NewRecord("added", TypeRaw, nil, make([]byte, free/2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Add(r); err != nil {
+		t.Fatal(err)
+	}
+	n := reparse(t, i)
+	checkLayout(t, n)
+	if n.Size() != 0x20000 || findFile(n, "added") == nil {
+		t.Errorf("got a CBFS of %#x bytes:\n%v", n.Size(), n)
+	}
+
+	// The removed file merges with the empty file after it, which keeps
+	// its end.
+	if err := n.Remove("added"); err != nil {
+		t.Fatal(err)
+	}
+	n = reparse(t, n)
+	checkLayout(t, n)
+	if n.Size() != 0x20000 || findFile(n, "added") != nil || !n.Segs[len(n.Segs)-1].GetFile().Deleted() {
+		t.Errorf("got a CBFS of %#x bytes:\n%v", n.Size(), n)
+	}
+}
+
+func TestUpdateMCache(t *testing.T) {
+	i := openTestImage(t)
+	r, err := NewRecord(MCacheName, TypeRaw, nil, []byte("cached headers"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Add(r); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Update(); err == nil || !strings.Contains(err.Error(), "metadata cache") {
+		t.Errorf("image with an mcache file: got %v, want an error about the metadata cache", err)
+	}
+}
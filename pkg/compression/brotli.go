@@ -0,0 +1,69 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compression
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	brotliQuality    = 9
+	brotliWindowBits = 22
+	brotliHeaderSize = 0x10
+	// This seems to be the buffer size needed by the UEFI decompressor
+	// 0x03000000 should suffice. The EDK2 base tools generates this header
+	// using Brotli internals. This needs to be tuned somehow
+	brotliScratchBufferSize = 0x03000000
+)
+
+// brotliHeader returns the header of EDK2 Brotli sections, the size of the
+// decoded data and of the scratch buffer of the decompressor.
+func brotliHeader(decodedSize int) []byte {
+	header := make([]byte, brotliHeaderSize)
+	binary.LittleEndian.PutUint64(header, uint64(decodedSize))
+	binary.LittleEndian.PutUint64(header[8:], brotliScratchBufferSize)
+	return header
+}
+
+// BROTLI implements Compressor and uses a Go-based implementation.
+type BROTLI struct{}
+
+// Name returns the type of compression employed.
+func (c *BROTLI) Name() string {
+	return "BROTLI"
+}
+
+// Decode decodes a byte slice of BROTLI data.
+func (c *BROTLI) Decode(encodedData []byte) ([]byte, error) {
+	if len(encodedData) < brotliHeaderSize {
+		return nil, fmt.Errorf("BROTLI.Decode: %d bytes is too short for the header", len(encodedData))
+	}
+	size := binary.LittleEndian.Uint64(encodedData)
+	decodedData, err := readAllLimited(c.Name(), brotli.NewReader(bytes.NewReader(encodedData[brotliHeaderSize:])), int64(size))
+	if err != nil {
+		return nil, err
+	}
+	if size != uint64(len(decodedData)) {
+		return nil, fmt.Errorf("BROTLI.Decode: decoded %d bytes, header says %d", len(decodedData), size)
+	}
+	return decodedData, nil
+}
+
+// Encode encodes a byte slice with BROTLI.
+func (c *BROTLI) Encode(decodedData []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(brotliHeader(len(decodedData)))
+	w := brotli.NewWriterOptions(buf, brotli.WriterOptions{Quality: brotliQuality, LGWin: brotliWindowBits})
+	if _, err := w.Write(decodedData); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -9,14 +9,89 @@
 package compression
 
 import (
+	"bytes"
+	"errors"
 	"flag"
-	"os/exec"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
 
 	"github.com/linuxboot/fiano/pkg/guid"
 )
 
-var brotliPath = flag.String("brotliPath", "brotli", "Path to system brotli command used for brotli encoding.")
-var xzPath = flag.String("xzPath", "xz", "Path to system xz command used for lzma encoding. If unset, an internal lzma implementation is used.")
+// Deterministic makes CompressorFromGUID and CompressorFromCBFS return the
+// internal implementations, whose parameters are fixed, rather than calling
+// the system xz or brotli commands, whose output depends on their version.
+// Compressing the same data then yields the same bytes on every host, as
+// reproducible builds need. It is set by the -deterministic flag.
+var Deterministic bool
+
+func init() {
+	flag.BoolVar(&Deterministic, "deterministic", false, "Use the internal compressors with fixed parameters, so that assembling the same tree always yields the same image.")
+}
+
+// MaxDecodedSize bounds the size of the data decoded by the internal
+// decompressors, so that a small crafted stream cannot exhaust the memory.
+// Whole firmware images are far smaller.
+var MaxDecodedSize uint64 = 512 << 20
+
+// ErrTooLarge is returned by the decoders when the decoded data would be
+// larger than MaxDecodedSize.
+var ErrTooLarge = errors.New("decoded data is larger than MaxDecodedSize")
+
+// decodePool holds the buffers the data of unknown size is decoded into.
+var decodePool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// readAllLimited reads r up to MaxDecodedSize bytes. size is the decoded size
+// given by the encoded data, or -1 if it does not give it. A known size is
+// allocated upfront. Otherwise, the data is decoded into a pooled buffer and
+// copied out at its exact size, so that neither the growth of the buffer nor
+// its spare capacity is left to the garbage collector. The name of the
+// compression prefixes the errors.
+func readAllLimited(name string, r io.Reader, size int64) ([]byte, error) {
+	r = io.LimitReader(r, int64(MaxDecodedSize)+1)
+	var b []byte
+	if size >= 0 && uint64(size) <= MaxDecodedSize {
+		// One more byte to read the end without growing the buffer.
+		var err error
+		if b, err = readAll(r, make([]byte, 0, size+1)); err != nil {
+			return nil, err
+		}
+	} else {
+		buf := decodePool.Get().(*bytes.Buffer)
+		defer decodePool.Put(buf)
+		buf.Reset()
+		if _, err := buf.ReadFrom(r); err != nil {
+			return nil, err
+		}
+		if uint64(buf.Len()) > MaxDecodedSize {
+			return nil, fmt.Errorf("%s.Decode: %w", name, ErrTooLarge)
+		}
+		b = append([]byte{}, buf.Bytes()...)
+	}
+	if uint64(len(b)) > MaxDecodedSize {
+		return nil, fmt.Errorf("%s.Decode: %w", name, ErrTooLarge)
+	}
+	return b, nil
+}
+
+// readAll is io.ReadAll appending to b.
+func readAll(r io.Reader, b []byte) ([]byte, error) {
+	for {
+		if len(b) == cap(b) {
+			b = append(b, 0)[:len(b)]
+		}
+		n, err := r.Read(b[len(b):cap(b)])
+		b = b[:len(b)+n]
+		if err == io.EOF {
+			return b, nil
+		}
+		if err != nil {
+			return b, err
+		}
+	}
+}
 
 // Compressor defines a single compression scheme (such as LZMA).
 type Compressor interface {
@@ -34,30 +109,110 @@ var (
 	LZMAGUID    = *guid.MustParse("EE4E5898-3914-4259-9D6E-DC7BD79403CF")
 	LZMAX86GUID = *guid.MustParse("D42AE6BD-1352-4BFB-909A-CA72A6EAE889")
 	ZLIBGUID    = *guid.MustParse("CE3233F5-2CD6-4D87-9152-4A238BB6D1C4")
+	TIANOGUID   = *guid.MustParse("A31280AD-481E-41B6-95E8-127F4C984779")
+)
+
+// Compression types of coreboot CBFS files and payload segments. Type 0 is
+// no compression.
+const (
+	CBFSLZMA uint32 = 1
+	CBFSLZ4  uint32 = 2
 )
 
+// brotliCompressor returns the system brotli command for brotli encoding or,
+// if it is not found, an internal brotli implementation.
+func brotliCompressor() Compressor {
+	if !Deterministic {
+		if c := systemBROTLI(); c != nil {
+			return c
+		}
+	}
+	return &BROTLI{}
+}
+
+// lzmaCompressor returns the system xz command for lzma encoding or, if it is
+// not found, an internal lzma implementation.
+func lzmaCompressor() Compressor {
+	if !Deterministic {
+		if c := systemLZMA(); c != nil {
+			return c
+		}
+	}
+	return &LZMA{}
+}
+
+// registryMu guards guidCompressors and cbfsCompressors, which RegisterGUID
+// and RegisterCBFS modify while images may be parsed concurrently.
+var registryMu sync.RWMutex
+
+// guidCompressors are the compressors of GUIDed sections, by GUID.
+var guidCompressors = map[guid.GUID]func() Compressor{
+	BROTLIGUID: brotliCompressor,
+	LZMAGUID:   lzmaCompressor,
+	// Alternatively, the -f86 argument could be passed into xz. It does
+	// not make much difference because the x86 filter is not the
+	// bottleneck.
+	LZMAX86GUID: func() Compressor { return &LZMAX86{lzmaCompressor()} },
+	ZLIBGUID:    func() Compressor { return &ZLIB{} },
+	TIANOGUID:   func() Compressor { return &Tiano{} },
+}
+
+// RegisterGUID makes CompressorFromGUID return a compressor made by f for
+// the GUIDed sections with GUID g, e.g. for the vendor specific GUIDs of
+// LZ4 sections.
+func RegisterGUID(g guid.GUID, f func() Compressor) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	guidCompressors[g] = f
+}
+
 // CompressorFromGUID returns a Compressor for the corresponding GUIDed Section.
 func CompressorFromGUID(guid *guid.GUID) Compressor {
-	// Default to system xz command for lzma encoding; if not found, use an
-	// internal lzma implementation.
-	var lzma Compressor
-	if _, err := exec.LookPath(*xzPath); err == nil {
-		lzma = &SystemLZMA{*xzPath}
-	} else {
-		lzma = &LZMA{}
-	}
-	switch *guid {
-	case BROTLIGUID:
-		return &SystemBROTLI{*brotliPath}
-	case LZMAGUID:
-		return lzma
-	case LZMAX86GUID:
-		// Alternatively, the -f86 argument could be passed
-		// into xz. It does not make much difference because
-		// the x86 filter is not the bottleneck.
-		return &LZMAX86{lzma}
-	case ZLIBGUID:
-		return &ZLIB{}
+	registryMu.RLock()
+	f, ok := guidCompressors[*guid]
+	registryMu.RUnlock()
+	if ok {
+		return f()
 	}
 	return nil
 }
+
+// cbfsCompressors are the compressors of CBFS files and payload segments, by
+// compression type.
+var cbfsCompressors = map[uint32]func() Compressor{
+	CBFSLZMA: func() Compressor { return &LZMA{} },
+	CBFSLZ4:  func() Compressor { return &LZ4{} },
+}
+
+// RegisterCBFS makes CompressorFromCBFS return a compressor made by f for
+// the CBFS compression type t, e.g. for types coreboot added after this
+// package.
+func RegisterCBFS(t uint32, f func() Compressor) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	cbfsCompressors[t] = f
+}
+
+// CompressorFromCBFS returns a Compressor for the CBFS compression type t,
+// or nil if there is none.
+func CompressorFromCBFS(t uint32) Compressor {
+	registryMu.RLock()
+	f, ok := cbfsCompressors[t]
+	registryMu.RUnlock()
+	if ok {
+		return f()
+	}
+	return nil
+}
+
+// CBFSTypes returns the CBFS compression types with a Compressor, sorted.
+func CBFSTypes() []uint32 {
+	var types []uint32
+	registryMu.RLock()
+	for t := range cbfsCompressors {
+		types = append(types, t)
+	}
+	registryMu.RUnlock()
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
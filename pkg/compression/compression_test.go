@@ -5,8 +5,14 @@
 package compression
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"os"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/linuxboot/fiano/pkg/guid"
@@ -30,6 +36,12 @@ var tests = []struct {
 		decodedFilename: "testdata/random.bin",
 		compressor:      &LZ4{},
 	},
+	{
+		name:            "random data legacy LZ4",
+		encodedFilename: "testdata/random.bin.lz4legacy",
+		decodedFilename: "testdata/random.bin",
+		compressor:      &LZ4{},
+	},
 	{
 		name:            "random data SystemLZMA",
 		encodedFilename: "testdata/random.bin.lzma",
@@ -48,12 +60,36 @@ var tests = []struct {
 		decodedFilename: "testdata/random.bin",
 		compressor:      &LZMAX86{&SystemLZMA{"xz"}},
 	},
+	{
+		name:            "random data BROTLI",
+		encodedFilename: "testdata/random.bin.brotli",
+		decodedFilename: "testdata/random.bin",
+		compressor:      &BROTLI{},
+	},
+	{
+		name:            "random data Tiano",
+		encodedFilename: "testdata/random.bin.tiano",
+		decodedFilename: "testdata/random.bin",
+		compressor:      &Tiano{},
+	},
+	{
+		name:            "random data EFI 1.1",
+		encodedFilename: "testdata/random.bin.efi",
+		decodedFilename: "testdata/random.bin",
+		compressor:      &Tiano{EFI: true},
+	},
 	{
 		name:            "random data ZLIB",
 		encodedFilename: "testdata/random.bin.zlib",
 		decodedFilename: "testdata/random.bin",
 		compressor:      &ZLIB{},
 	},
+	{
+		name:            "random data Zstd",
+		encodedFilename: "testdata/random.bin.zst",
+		decodedFilename: "testdata/random.bin",
+		compressor:      &Zstd{},
+	},
 }
 
 func TestEncodeDecode(t *testing.T) {
@@ -178,3 +214,318 @@ func TestCompressorFromGUID(t *testing.T) {
 
 	}
 }
+
+func TestBROTLIHeader(t *testing.T) {
+	encoded, err := (&BROTLI{}).Encode([]byte("FIANO ROCKS!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{12, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 3, 0, 0, 0, 0}; len(encoded) < 16 || !reflect.DeepEqual(encoded[:16], want) {
+		t.Errorf("got header % x, want % x", encoded, want)
+	}
+	encoded[0]++
+	if _, err := (&BROTLI{}).Decode(encoded); err == nil {
+		t.Errorf("decoding with a wrong size: got nil, want error")
+	}
+	if _, err := (&BROTLI{}).Decode(encoded[:8]); err == nil {
+		t.Errorf("decoding a truncated header: got nil, want error")
+	}
+	if c := CompressorFromGUID(&BROTLIGUID); c == nil || c.Name() != "BROTLI" {
+		t.Errorf("got %v, want a BROTLI compressor", c)
+	}
+}
+
+func TestTiano(t *testing.T) {
+	for _, c := range []*Tiano{{}, {EFI: true}} {
+		for _, want := range [][]byte{
+			{},
+			[]byte("F"),
+			[]byte("FIANO ROCKS! FIANO ROCKS! FIANO ROCKS!"),
+			bytes.Repeat([]byte{0xff}, 0x30000),
+		} {
+			encoded, err := c.Encode(want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := c.Decode(encoded)
+			if err != nil {
+				t.Fatalf("%s: decoding %d bytes: %v", c.Name(), len(want), err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s: got %q, want %q", c.Name(), got, want)
+			}
+			if len(encoded) > 8 {
+				if _, err := c.Decode(encoded[:len(encoded)-5]); err == nil {
+					t.Errorf("%s: decoding truncated data: got nil, want error", c.Name())
+				}
+			}
+		}
+	}
+	if c := CompressorFromGUID(&TIANOGUID); c == nil || c.Name() != "TIANO" {
+		t.Errorf("got %v, want a TIANO compressor", c)
+	}
+}
+
+// tianoStream returns EFI 1.1 or Tiano compressed data of want, whose
+// bits are given as a string of 0s and 1s, where spaces are ignored.
+func tianoStream(bits string, want string) []byte {
+	var data []byte
+	bits = strings.ReplaceAll(bits, " ", "")
+	for i := 0; i < len(bits); i += 8 {
+		var b byte
+		for j := 0; j < 8; j++ {
+			b <<= 1
+			if i+j < len(bits) && bits[i+j] == '1' {
+				b |= 1
+			}
+		}
+		data = append(data, b)
+	}
+	header := make([]byte, 8)
+	binary.LittleEndian.PutUint32(header, uint32(len(data)))
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(want)))
+	return append(header, data...)
+}
+
+func TestTianoDecodeStream(t *testing.T) {
+	// The streams are assembled by hand from the format of the UEFI
+	// specification rather than by Encode, so that the decoder is not only
+	// checked against the encoder. They only differ by the size of the
+	// fields of the position table: 4 bits for EFI 1.1, 5 for Tiano.
+	for _, tt := range []struct {
+		name string
+		bits func(pNum func(n int) string) string
+		want string
+	}{
+		{
+			name: "single position",
+			bits: func(pNum func(n int) string) string {
+				return "0000000000000011" + // 3 symbols
+					// Code lengths of the code lengths: 0, 0, 2, no zeros, 2, 1.
+					"00101 000 000 010 00 010 001" +
+					// 262 code lengths: 65 zeros, 'A' 1, 'B' 2,
+					// 194 zeros, length 8 2.
+					"100000110 10 000101101 11 0 10 010101110 0" +
+					// A single position, 1.
+					pNum(0) + pNum(1) +
+					// 'A', 'B', 8 bytes 2 back.
+					"0 10 11"
+			},
+			want: "ABABABABAB",
+		},
+		{
+			name: "positions",
+			bits: func(pNum func(n int) string) string {
+				return "0000000000001000" + // 8 symbols
+					// Code lengths of the code lengths: 2, 0, 2, 1 zero, 2, 2.
+					"00110 010 000 010 01 010 010" +
+					// 259 code lengths: 65 zeros, 'A' 2, 'B' to 'E' 3,
+					// 186 zeros, length 3 3, 0, length 5 3.
+					"100000011 01 000101101 10 11 11 11 11 01 010100110 11 00 11" +
+					// Position code lengths: 1, 0, 0, 1.
+					pNum(4) + "001 000 000 001" +
+					// 'A' to 'E', 5 bytes 5 back, 3 bytes 1 back.
+					"00 010 011 100 101 111 1 00 110 0"
+			},
+			want: "ABCDEABCDEEEE",
+		},
+	} {
+		for _, c := range []*Tiano{{EFI: true}, {}} {
+			_, pBit := c.windowBits()
+			pNum := func(n int) string {
+				return fmt.Sprintf("%0*b", pBit, n)
+			}
+			got, err := c.Decode(tianoStream(tt.bits(pNum), tt.want))
+			if err != nil {
+				t.Fatalf("%s %s: %v", c.Name(), tt.name, err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("%s %s: got %q, want %q", c.Name(), tt.name, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestLZ4Frame(t *testing.T) {
+	encoded, err := (&LZ4{}).Encode([]byte("FIANO ROCKS! FIANO ROCKS! FIANO ROCKS!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A frame with a content checksum ends with the 0 end mark and the
+	// checksum.
+	if len(encoded) < 8 || !reflect.DeepEqual(encoded[len(encoded)-8:len(encoded)-4], []byte{0, 0, 0, 0}) {
+		t.Errorf("got frame % x, want it to end with an end mark and a checksum", encoded)
+	}
+}
+
+func TestRegisterGUID(t *testing.T) {
+	g := *guid.MustParse("0B1A2C3D-4E5F-6071-8293-A4B5C6D7E8F9")
+	if c := CompressorFromGUID(&g); c != nil {
+		t.Fatalf("got %v for an unknown GUID, want nil", c.Name())
+	}
+	RegisterGUID(g, func() Compressor { return &LZ4{} })
+	defer func() {
+		registryMu.Lock()
+		delete(guidCompressors, g)
+		registryMu.Unlock()
+	}()
+	if c := CompressorFromGUID(&g); c == nil || c.Name() != "LZ4" {
+		t.Errorf("got %v, want the LZ4 compressor", c)
+	}
+	RegisterGUID(g, func() Compressor { return &Zstd{} })
+	if c := CompressorFromGUID(&g); c == nil || c.Name() != "ZSTD" {
+		t.Errorf("got %v, want the Zstd compressor", c)
+	}
+}
+
+func TestRegisterCBFS(t *testing.T) {
+	if c := CompressorFromCBFS(CBFSLZ4); c == nil || c.Name() != "LZ4" {
+		t.Errorf("got %v for CBFS LZ4, want the LZ4 compressor", c)
+	}
+	const cbfsZstd = 3
+	if c := CompressorFromCBFS(cbfsZstd); c != nil {
+		t.Fatalf("got %v for an unknown type, want nil", c.Name())
+	}
+	RegisterCBFS(cbfsZstd, func() Compressor { return &Zstd{} })
+	defer func() {
+		registryMu.Lock()
+		delete(cbfsCompressors, cbfsZstd)
+		registryMu.Unlock()
+	}()
+	if c := CompressorFromCBFS(cbfsZstd); c == nil || c.Name() != "ZSTD" {
+		t.Errorf("got %v, want the Zstd compressor", c)
+	}
+	if got, want := CBFSTypes(), []uint32{CBFSLZMA, CBFSLZ4, cbfsZstd}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got types %v, want %v", got, want)
+	}
+}
+
+func TestRegisterConcurrent(t *testing.T) {
+	var gs []guid.GUID
+	for i := 0; i < 8; i++ {
+		g := *guid.MustParse("0B1A2C3D-4E5F-6071-8293-A4B5C6D7E8F9")
+		g[0] = byte(i)
+		gs = append(gs, g)
+	}
+	t.Cleanup(func() {
+		registryMu.Lock()
+		defer registryMu.Unlock()
+		for _, g := range gs {
+			delete(guidCompressors, g)
+		}
+	})
+
+	var wg sync.WaitGroup
+	for _, g := range gs {
+		wg.Add(1)
+		go func(g guid.GUID) {
+			defer wg.Done()
+			RegisterGUID(g, func() Compressor { return &LZ4{} })
+			if c := CompressorFromGUID(&g); c == nil {
+				t.Errorf("%v not registered", g)
+			}
+			CompressorFromGUID(&LZMAGUID)
+			CBFSTypes()
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestDeterministic(t *testing.T) {
+	defer func(d bool) { Deterministic = d }(Deterministic)
+	Deterministic = true
+	for _, tt := range []struct {
+		guid *guid.GUID
+		want Compressor
+	}{
+		{&LZMAGUID, &LZMA{}},
+		{&LZMAX86GUID, &LZMAX86{&LZMA{}}},
+		{&BROTLIGUID, &BROTLI{}},
+	} {
+		if c := CompressorFromGUID(tt.guid); !reflect.DeepEqual(c, tt.want) {
+			t.Errorf("got %#v for %v, want %#v", c, tt.guid, tt.want)
+		}
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	// Firmware compresses about 3:1, unlike random data.
+	data, err := os.ReadFile("testdata/random.bin")
+	if err != nil {
+		b.Fatal(err)
+	}
+	data = bytes.Repeat(data[:len(data)/4], 3)
+	for _, c := range []Compressor{&LZMA{}, &SystemLZMA{"xz"}, &LZMAX86{&LZMA{}}, &LZ4{}, &Zstd{}, &BROTLI{}, &ZLIB{}} {
+		b.Run(fmt.Sprintf("%T", c), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				if _, err := c.Encode(data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	data, err := os.ReadFile("testdata/random.bin")
+	if err != nil {
+		b.Fatal(err)
+	}
+	data = bytes.Repeat(data[:len(data)/4], 3)
+	for _, c := range []Compressor{&LZMA{}, &LZMAX86{&LZMA{}}, &LZ4{}, &Zstd{}, &BROTLI{}, &ZLIB{}, &Tiano{}} {
+		b.Run(fmt.Sprintf("%T", c), func(b *testing.B) {
+			encoded, err := c.Encode(data)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := c.Decode(encoded); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeTooLarge(t *testing.T) {
+	defer func(max uint64) { MaxDecodedSize = max }(MaxDecodedSize)
+	for _, tt := range tests {
+		if strings.HasPrefix(tt.name, "random data System") {
+			continue
+		}
+		t.Run(tt.name, func(t *testing.T) {
+			want, err := os.ReadFile(tt.decodedFilename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			encoded, err := os.ReadFile(tt.encodedFilename)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			MaxDecodedSize = uint64(len(want)) - 1
+			if _, err := tt.compressor.Decode(encoded); !errors.Is(err, ErrTooLarge) {
+				t.Errorf("got %v, want %v", err, ErrTooLarge)
+			}
+		})
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	compressors := []Compressor{&LZMA{}, &LZMAX86{&LZMA{}}, &LZ4{}, &BROTLI{}, &Tiano{}, &Tiano{EFI: true}, &ZLIB{}, &Zstd{}}
+	data := bytes.Repeat([]byte("fiano fuzz "), 32)
+	for i, c := range compressors {
+		encoded, err := c.Encode(data)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(uint8(i), encoded)
+	}
+	f.Fuzz(func(t *testing.T, i uint8, encoded []byte) {
+		_, _ = compressors[int(i)%len(compressors)].Decode(encoded)
+	})
+}
@@ -6,12 +6,18 @@ package compression
 
 import (
 	"bytes"
-	"io"
+	"encoding/binary"
 
 	"github.com/pierrec/lz4"
 )
 
-// LZ4 implements Compressor and uses a Go-based implementation.
+// lz4LegacyMagic starts the legacy LZ4 frames of the lz4 -l command, as used
+// for Linux kernels and initramfs.
+const lz4LegacyMagic = 0x184c2102
+
+// LZ4 implements Compressor and uses a Go-based implementation. It encodes
+// LZ4 frames, as coreboot CBFS files and payload segments hold, and decodes
+// them as well as legacy frames.
 type LZ4 struct{}
 
 // Name returns the type of compression employed.
@@ -21,19 +27,23 @@ func (c *LZ4) Name() string {
 
 // Decode decodes a byte slice of LZ4 data.
 func (c *LZ4) Decode(encodedData []byte) ([]byte, error) {
-	return io.ReadAll(lz4.NewReader(bytes.NewBuffer(encodedData)))
+	if len(encodedData) >= 4 && binary.LittleEndian.Uint32(encodedData) == lz4LegacyMagic {
+		return readAllLimited(c.Name(), lz4.NewReaderLegacy(bytes.NewReader(encodedData)), -1)
+	}
+	return readAllLimited(c.Name(), lz4.NewReader(bytes.NewReader(encodedData)), -1)
 }
 
 // Encode encodes a byte slice with LZ4.
 func (c *LZ4) Encode(decodedData []byte) ([]byte, error) {
-
 	buf := bytes.Buffer{}
 	w := lz4.NewWriter(&buf)
-	_, err := w.Write(decodedData)
-	if err != nil {
+	if _, err := w.Write(decodedData); err != nil {
+		return nil, err
+	}
+	// Close writes the end mark and the checksum of the frame, without
+	// which the frame is truncated for other decoders.
+	if err := w.Close(); err != nil {
 		return nil, err
 	}
-	w.Flush()
-
 	return buf.Bytes(), nil
 }
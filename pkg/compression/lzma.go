@@ -6,6 +6,8 @@ package compression
 
 import (
 	"bytes"
+	"encoding/binary"
+	"fmt"
 	"io"
 
 	"github.com/ulikunitz/xz/lzma"
@@ -16,7 +18,11 @@ var lzmaDictCapExps = []uint{18, 20, 21, 22, 22, 23, 23, 24, 25, 26}
 var compressionLevel = 7
 
 // LZMA implements Compressor and uses a Go-based implementation.
-type LZMA struct{}
+type LZMA struct {
+	// DictCap is the size of the dictionary for encoding. If zero, the
+	// dictionary size of compression level 7 is used.
+	DictCap int
+}
 
 // Name returns the type of compression employed.
 func (c *LZMA) Name() string {
@@ -25,11 +31,36 @@ func (c *LZMA) Name() string {
 
 // Decode decodes a byte slice of LZMA data.
 func (c *LZMA) Decode(encodedData []byte) ([]byte, error) {
-	r, err := lzma.NewReader(bytes.NewBuffer(encodedData))
+	// The decoder allocates the dictionary size of the header upfront.
+	if len(encodedData) >= 5 {
+		if dictCap := binary.LittleEndian.Uint32(encodedData[1:]); uint64(dictCap) > MaxDecodedSize {
+			return nil, fmt.Errorf("LZMA.Decode: dictionary of %#x bytes: %w", dictCap, ErrTooLarge)
+		}
+	}
+	size := int64(-1)
+	var in io.Reader = bytes.NewReader(encodedData)
+	if len(encodedData) >= lzma.HeaderLen {
+		// An unknown size is all ones.
+		size = int64(binary.LittleEndian.Uint64(encodedData[5:]))
+		in = io.MultiReader(bytes.NewReader(shrinkLZMADict(encodedData[:lzma.HeaderLen], size)), bytes.NewReader(encodedData[lzma.HeaderLen:]))
+	}
+	r, err := lzma.NewReader(in)
 	if err != nil {
 		return nil, err
 	}
-	return io.ReadAll(r)
+	return readAllLimited(c.Name(), r, size)
+}
+
+// shrinkLZMADict returns a copy of the LZMA header whose dictionary is no
+// larger than the decoded data of the given size. The encoders default to
+// dictionaries of several megabytes, far larger than most sections, and the
+// matches never reach farther back than the start of the data.
+func shrinkLZMADict(header []byte, size int64) []byte {
+	header = append([]byte{}, header...)
+	if size >= 0 && size < int64(binary.LittleEndian.Uint32(header[1:])) {
+		binary.LittleEndian.PutUint32(header[1:], uint32(size))
+	}
+	return header
 }
 
 // Encode encodes a byte slice with LZMA.
@@ -41,6 +72,10 @@ func (c *LZMA) Encode(decodedData []byte) ([]byte, error) {
 		EOSMarker:    false,
 		Properties:   &lzma.Properties{LC: 3, LP: 0, PB: 2},
 		DictCap:      1 << lzmaDictCapExps[compressionLevel],
+		Matcher:      lzma.HashTable4,
+	}
+	if c.DictCap != 0 {
+		wc.DictCap = c.DictCap
 	}
 	if err := wc.Verify(); err != nil {
 		return nil, err
@@ -0,0 +1,19 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build js
+// +build js
+
+package compression
+
+// Commands cannot run in a browser, so the internal brotli and lzma
+// implementations are always used there.
+
+func systemBROTLI() Compressor {
+	return nil
+}
+
+func systemLZMA() Compressor {
+	return nil
+}
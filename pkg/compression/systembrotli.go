@@ -2,14 +2,29 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
+//go:build !js
+// +build !js
+
 package compression
 
 import (
 	"bytes"
-	"encoding/binary"
+	"flag"
+	"fmt"
 	"os/exec"
+	"strconv"
 )
 
+var brotliPath = flag.String("brotliPath", "brotli", "Path to system brotli command used for brotli encoding. If unset, an internal brotli implementation is used.")
+
+// systemBROTLI returns the system brotli command, or nil if it is not found.
+func systemBROTLI() Compressor {
+	if _, err := exec.LookPath(*brotliPath); err != nil {
+		return nil
+	}
+	return &SystemBROTLI{*brotliPath}
+}
+
 // SystemBROTLI implements Compression and calls out to the system's compressor
 type SystemBROTLI struct {
 	brotliPath string
@@ -25,8 +40,11 @@ func (c *SystemBROTLI) Decode(encodedData []byte) ([]byte, error) {
 	// The start of the brotli section contains an 8 byte header describing
 	// the final uncompressed size. The real data starts at 0x10
 
+	if len(encodedData) < brotliHeaderSize {
+		return nil, fmt.Errorf("SystemBROTLI.Decode: %d bytes is too short for the header", len(encodedData))
+	}
 	cmd := exec.Command(c.brotliPath, "--stdout", "-d")
-	cmd.Stdin = bytes.NewBuffer(encodedData[0x10:])
+	cmd.Stdin = bytes.NewBuffer(encodedData[brotliHeaderSize:])
 
 	decodedData, err := cmd.Output()
 	if err != nil {
@@ -38,7 +56,7 @@ func (c *SystemBROTLI) Decode(encodedData []byte) ([]byte, error) {
 
 // Encode encodes a byte slice with BROTLI.
 func (c *SystemBROTLI) Encode(decodedData []byte) ([]byte, error) {
-	cmd := exec.Command(c.brotliPath, "--stdout", "-q", "9")
+	cmd := exec.Command(c.brotliPath, "--stdout", "-q", strconv.Itoa(brotliQuality))
 	cmd.Stdin = bytes.NewBuffer(decodedData)
 
 	encodedData, err := cmd.Output()
@@ -46,19 +64,7 @@ func (c *SystemBROTLI) Encode(decodedData []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	// Generate the size of the decoded data for the header
-	decodedSize := &bytes.Buffer{}
-	if err := binary.Write(decodedSize, binary.LittleEndian, uint64(len(decodedData))); err != nil {
-		return nil, err
-	}
-
-	// This seems to be the buffer size needed by the UEFI decompressor
-	// 0x03000000 should suffice. The EDK2 base tools generates this header
-	// using Brotli internals. This needs to be tuned somehow
-	scratchBufferSize := []byte{0x00, 0x00, 0x00, 0x03, 0, 0, 0, 0}
-	header := append(decodedSize.Bytes(), scratchBufferSize...)
-
-	encodedData = append(header, encodedData...)
+	encodedData = append(brotliHeader(len(decodedData)), encodedData...)
 
 	return encodedData, nil
 }
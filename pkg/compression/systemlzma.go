@@ -2,14 +2,28 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
+//go:build !js
+// +build !js
+
 package compression
 
 import (
 	"bytes"
 	"encoding/binary"
+	"flag"
 	"os/exec"
 )
 
+var xzPath = flag.String("xzPath", "xz", "Path to system xz command used for lzma encoding. If unset, an internal lzma implementation is used.")
+
+// systemLZMA returns the system xz command, or nil if it is not found.
+func systemLZMA() Compressor {
+	if _, err := exec.LookPath(*xzPath); err != nil {
+		return nil
+	}
+	return &SystemLZMA{*xzPath}
+}
+
 // SystemLZMA implements Compression and calls out to the system's compressor
 // (except for Decode which uses the Go-based decompressor). The sytem's
 // compressor is typically faster and generates smaller files than the Go-based
@@ -0,0 +1,748 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compression
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"sort"
+)
+
+// The EFI 1.1 and Tiano compression formats are the LZ77 and Huffman coding
+// of EDK2's EfiCompress.c and TianoCompress.c. They only differ by the size
+// of the window, and so by the number of bits of the size of the position
+// table. The compressed data starts with its size and the size of the
+// decompressed data, as 32-bit little endian values.
+const (
+	tianoHeaderSize = 8
+	tianoThreshold  = 3
+	tianoMaxMatch   = 256
+	tianoCodeBit    = 16
+	// Characters, then match lengths from tianoThreshold to tianoMaxMatch.
+	tianoNC   = 0xff + tianoMaxMatch + 2 - tianoThreshold
+	tianoCBit = 9
+	// Code lengths of the character and length table: a zero, a run of 3
+	// to 18 zeros, a run of 20 to 531 zeros, or a length plus 2.
+	tianoNT   = tianoCodeBit + 3
+	tianoTBit = 5
+	// The decoder reads position tables of up to 31 entries, whatever the
+	// window.
+	tianoMaxPBit = 5
+	tianoMaxNP   = 1<<tianoMaxPBit - 1
+	tianoNPT     = tianoMaxNP
+
+	// Symbols of a block, whose count is 16 bits.
+	tianoBlockSize = 0x8000
+	// Candidates tried by the encoder for each match.
+	tianoMaxChain = 256
+)
+
+// Tiano implements Compressor for the compression of EDK2 custom
+// decompression GUIDed sections and, with EFI set, for the EFI 1.1
+// compression of EFI_SECTION_COMPRESSION sections.
+type Tiano struct {
+	EFI bool
+}
+
+// Name returns the type of compression employed.
+func (c *Tiano) Name() string {
+	if c.EFI {
+		return "EFI"
+	}
+	return "TIANO"
+}
+
+// windowBits returns the number of bits of the positions of matches, and
+// pBit the number of bits of the size of the position table.
+func (c *Tiano) windowBits() (windowBits, pBit uint) {
+	if c.EFI {
+		return 13, 4
+	}
+	return 19, 5
+}
+
+// tianoReader reads the bits of the compressed data, most significant bit
+// first, as EDK2's FillBuf and GetBits do. Bits past the end are zero.
+type tianoReader struct {
+	in      []byte
+	bitBuf  uint32
+	sub     uint32
+	count   uint
+	overrun uint
+}
+
+func newTianoReader(in []byte) *tianoReader {
+	r := &tianoReader{in: in}
+	r.fill(32)
+	return r
+}
+
+// fill shifts n bits out of the bit buffer.
+func (r *tianoReader) fill(n uint) {
+	r.bitBuf <<= n
+	for n > r.count {
+		n -= r.count
+		r.bitBuf |= r.sub << n
+		if len(r.in) > 0 {
+			r.sub = uint32(r.in[0])
+			r.in = r.in[1:]
+		} else {
+			r.sub = 0
+			r.overrun++
+		}
+		r.count = 8
+	}
+	r.count -= n
+	r.bitBuf |= r.sub >> r.count
+}
+
+// bits returns the next n bits.
+func (r *tianoReader) bits(n uint) uint32 {
+	v := r.bitBuf >> (32 - n)
+	r.fill(n)
+	return v
+}
+
+// tianoTable is a Huffman decoding table: the symbols of the codes of up to
+// tableBits bits are looked up directly, the longer ones walk a tree from
+// there.
+type tianoTable struct {
+	table       []uint16
+	lens        []uint8
+	left, right []uint16
+	tableBits   uint
+}
+
+func newTianoTable(n int, tableBits uint) *tianoTable {
+	return &tianoTable{
+		table:     make([]uint16, 1<<tableBits),
+		lens:      make([]uint8, n),
+		left:      make([]uint16, 2*tianoNC),
+		right:     make([]uint16, 2*tianoNC),
+		tableBits: tableBits,
+	}
+}
+
+var errTianoBadTable = errors.New("bad Huffman table")
+
+// make builds the table from the code lengths of the symbols, as EDK2's
+// MakeTable does. The code must be complete.
+func (t *tianoTable) make() error {
+	var count [17]uint32
+	for _, l := range t.lens {
+		if l > 16 {
+			return errTianoBadTable
+		}
+		count[l]++
+	}
+	var start [18]uint32
+	for i := 1; i <= 16; i++ {
+		start[i+1] = start[i] + count[i]<<(16-i)
+	}
+	if start[17] != 1<<16 {
+		return errTianoBadTable
+	}
+	tb := t.tableBits
+	ju := 16 - tb
+	var weight [17]uint32
+	for i := uint(1); i <= 16; i++ {
+		if i <= tb {
+			start[i] >>= ju
+			weight[i] = 1 << (tb - i)
+		} else {
+			weight[i] = 1 << (16 - i)
+		}
+	}
+	if i := start[tb+1] >> ju; i != 0 {
+		for ; i < 1<<tb; i++ {
+			t.table[i] = 0
+		}
+	}
+
+	avail := uint16(len(t.lens))
+	mask := uint32(1) << (15 - tb)
+	for c, l := range t.lens {
+		if l == 0 {
+			continue
+		}
+		next := start[l] + weight[l]
+		if uint(l) <= tb {
+			if next > 1<<tb {
+				return errTianoBadTable
+			}
+			for i := start[l]; i < next; i++ {
+				t.table[i] = uint16(c)
+			}
+		} else {
+			code := start[l]
+			p := &t.table[code>>ju]
+			for i := uint(l) - tb; i != 0; i-- {
+				if *p == 0 && int(avail) < 2*tianoNC-1 {
+					t.left[avail], t.right[avail] = 0, 0
+					*p = avail
+					avail++
+				}
+				if int(*p) < 2*tianoNC-1 {
+					if code&mask != 0 {
+						p = &t.right[*p]
+					} else {
+						p = &t.left[*p]
+					}
+				}
+				code <<= 1
+			}
+			*p = uint16(c)
+		}
+		start[l] = next
+	}
+	return nil
+}
+
+// single makes the table decode c without reading bits.
+func (t *tianoTable) single(c uint16) {
+	for i := range t.table {
+		t.table[i] = c
+	}
+	for i := range t.lens {
+		t.lens[i] = 0
+	}
+}
+
+// decode reads a symbol.
+func (t *tianoTable) decode(r *tianoReader) uint16 {
+	c := t.table[r.bitBuf>>(32-t.tableBits)]
+	mask := uint32(1) << (31 - t.tableBits)
+	for int(c) >= len(t.lens) {
+		if r.bitBuf&mask != 0 {
+			c = t.right[c]
+		} else {
+			c = t.left[c]
+		}
+		mask >>= 1
+	}
+	r.fill(uint(t.lens[c]))
+	return c
+}
+
+// readPTLen reads the code lengths of the position table or of the table of
+// the code lengths of the character and length table. The latter has a run
+// of zeros after its special'th length.
+func (t *tianoTable) readPTLen(r *tianoReader, nBit uint, special int) error {
+	num := int(r.bits(nBit))
+	if num == 0 {
+		t.single(uint16(r.bits(nBit)))
+		return nil
+	}
+	if num > tianoNPT {
+		return errTianoBadTable
+	}
+	i := 0
+	for i < num {
+		c := r.bitBuf >> 29
+		if c == 7 {
+			for mask := uint32(1) << 28; mask&r.bitBuf != 0 && c < 16; mask >>= 1 {
+				c++
+			}
+		}
+		if c < 7 {
+			r.fill(3)
+		} else {
+			r.fill(uint(c) - 3)
+		}
+		t.lens[i] = uint8(c)
+		i++
+		if i == special {
+			for z := r.bits(2); z > 0 && i < tianoNPT; z-- {
+				t.lens[i] = 0
+				i++
+			}
+		}
+	}
+	for ; i < len(t.lens); i++ {
+		t.lens[i] = 0
+	}
+	return t.make()
+}
+
+// readCLen reads the code lengths of the character and length table with
+// the table pt of their code lengths.
+func (t *tianoTable) readCLen(r *tianoReader, pt *tianoTable) error {
+	num := int(r.bits(tianoCBit))
+	if num == 0 {
+		t.single(uint16(r.bits(tianoCBit)))
+		return nil
+	}
+	if num > tianoNC {
+		return errTianoBadTable
+	}
+	i := 0
+	for i < num {
+		c := pt.decode(r)
+		if c > 2 {
+			t.lens[i] = uint8(c - 2)
+			i++
+			continue
+		}
+		zeros := 1
+		switch c {
+		case 1:
+			zeros = int(r.bits(4)) + 3
+		case 2:
+			zeros = int(r.bits(tianoCBit)) + 20
+		}
+		if i+zeros > tianoNC {
+			return errTianoBadTable
+		}
+		for ; zeros > 0; zeros-- {
+			t.lens[i] = 0
+			i++
+		}
+	}
+	for ; i < tianoNC; i++ {
+		t.lens[i] = 0
+	}
+	return t.make()
+}
+
+// Decode decodes a byte slice of EFI 1.1 or Tiano compressed data.
+func (c *Tiano) Decode(encodedData []byte) ([]byte, error) {
+	if len(encodedData) < tianoHeaderSize {
+		return nil, fmt.Errorf("%s.Decode: %d bytes is too short for the header", c.Name(), len(encodedData))
+	}
+	compSize := binary.LittleEndian.Uint32(encodedData)
+	origSize := binary.LittleEndian.Uint32(encodedData[4:])
+	if uint64(compSize) > uint64(len(encodedData)-tianoHeaderSize) {
+		return nil, fmt.Errorf("%s.Decode: compressed size %#x is larger than the %#x bytes of data", c.Name(), compSize, len(encodedData)-tianoHeaderSize)
+	}
+	if uint64(origSize) > MaxDecodedSize {
+		return nil, fmt.Errorf("%s.Decode: original size %#x: %w", c.Name(), origSize, ErrTooLarge)
+	}
+	out := make([]byte, 0, origSize)
+	if origSize == 0 {
+		return out, nil
+	}
+	_, pBit := c.windowBits()
+	r := newTianoReader(encodedData[tianoHeaderSize : tianoHeaderSize+compSize])
+	ct := newTianoTable(tianoNC, 12)
+	pt := newTianoTable(tianoNPT, 8)
+	for blockSize := 0; uint32(len(out)) < origSize; blockSize-- {
+		if blockSize == 0 {
+			blockSize = int(r.bits(16))
+			if err := pt.readPTLen(r, tianoTBit, 3); err != nil {
+				return nil, fmt.Errorf("%s.Decode: %w", c.Name(), err)
+			}
+			if err := ct.readCLen(r, pt); err != nil {
+				return nil, fmt.Errorf("%s.Decode: %w", c.Name(), err)
+			}
+			if err := pt.readPTLen(r, pBit, -1); err != nil {
+				return nil, fmt.Errorf("%s.Decode: %w", c.Name(), err)
+			}
+		}
+		s := ct.decode(r)
+		if s < 0x100 {
+			out = append(out, byte(s))
+			continue
+		}
+		n := int(s) - (0x100 - tianoThreshold)
+		pos := uint32(pt.decode(r))
+		if pos > 1 {
+			pos = 1<<(pos-1) + r.bits(uint(pos-1))
+		}
+		if pos >= uint32(len(out)) {
+			return nil, fmt.Errorf("%s.Decode: match at %#x bytes back at %#x", c.Name(), pos+1, len(out))
+		}
+		from := len(out) - int(pos) - 1
+		for ; n > 0 && uint32(len(out)) < origSize; n-- {
+			out = append(out, out[from])
+			from++
+		}
+	}
+	// The bit buffer holds 32 bits ahead.
+	if r.overrun > 4 {
+		return nil, fmt.Errorf("%s.Decode: data truncated", c.Name())
+	}
+	return out, nil
+}
+
+// tianoWriter writes bits most significant bit first.
+type tianoWriter struct {
+	out   []byte
+	acc   uint64
+	count uint
+}
+
+func (w *tianoWriter) put(n uint, v uint32) {
+	w.acc = w.acc<<n | uint64(v)&(1<<n-1)
+	w.count += n
+	for w.count >= 8 {
+		w.count -= 8
+		w.out = append(w.out, byte(w.acc>>w.count))
+	}
+}
+
+func (w *tianoWriter) flush() {
+	if w.count > 0 {
+		w.put(8-w.count, 0)
+	}
+}
+
+// tianoSymbol is a character or, with length set, a match of length bytes
+// pos+1 bytes back.
+type tianoSymbol struct {
+	length uint16
+	c      byte
+	pos    uint32
+}
+
+// code returns the symbol of the character and length table.
+func (s tianoSymbol) code() int {
+	if s.length == 0 {
+		return int(s.c)
+	}
+	return int(s.length) + 0x100 - tianoThreshold
+}
+
+// pCode returns the symbol of the position table, the number of bits of
+// the position.
+func (s tianoSymbol) pCode() int {
+	return bits.Len32(s.pos)
+}
+
+// tianoMatches finds the matches of b in a window of 1<<windowBits bytes.
+func tianoMatches(b []byte, windowBits uint) []tianoSymbol {
+	const hashBits = 15
+	window := 1 << windowBits
+	head := make([]int32, 1<<hashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int32, window)
+	hash := func(i int) int {
+		return int((uint32(b[i])<<16|uint32(b[i+1])<<8|uint32(b[i+2]))*2654435761) >> (32 - hashBits)
+	}
+	insert := func(i int) {
+		if i+tianoThreshold > len(b) {
+			return
+		}
+		h := hash(i)
+		prev[i&(window-1)] = head[h]
+		head[h] = int32(i)
+	}
+
+	var syms []tianoSymbol
+	for i := 0; i < len(b); {
+		best, bestPos := 0, 0
+		if i+tianoThreshold <= len(b) {
+			max := len(b) - i
+			if max > tianoMaxMatch {
+				max = tianoMaxMatch
+			}
+			for j, chain := int(head[hash(i)]), 0; j >= 0 && i-j <= window && chain < tianoMaxChain; j, chain = int(prev[j&(window-1)]), chain+1 {
+				if b[j+best] != b[i+best] {
+					continue
+				}
+				n := 0
+				for n < max && b[j+n] == b[i+n] {
+					n++
+				}
+				if n > best {
+					best, bestPos = n, i-j-1
+					if n == max {
+						break
+					}
+				}
+			}
+		}
+		if best < tianoThreshold {
+			syms = append(syms, tianoSymbol{c: b[i]})
+			insert(i)
+			i++
+			continue
+		}
+		syms = append(syms, tianoSymbol{length: uint16(best), pos: uint32(bestPos)})
+		for end := i + best; i < end; i++ {
+			insert(i)
+		}
+	}
+	return syms
+}
+
+// tianoNode is a node of a Huffman tree being built.
+type tianoNode struct {
+	freq  int
+	sym   int
+	depth int
+}
+
+type tianoNodeHeap []tianoNode
+
+func (h tianoNodeHeap) Len() int { return len(h) }
+func (h tianoNodeHeap) Less(i, j int) bool {
+	if h[i].freq != h[j].freq {
+		return h[i].freq < h[j].freq
+	}
+	return h[i].depth < h[j].depth
+}
+func (h tianoNodeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *tianoNodeHeap) Push(x any)   { *h = append(*h, x.(tianoNode)) }
+func (h *tianoNodeHeap) Pop() any {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
+
+// tianoCodeLens returns Huffman code lengths of up to 16 bits for the
+// frequencies, forming a complete code, and the number of symbols used.
+func tianoCodeLens(freq []int) ([]uint8, int) {
+	lens := make([]uint8, len(freq))
+	var used []int
+	for s, f := range freq {
+		if f > 0 {
+			used = append(used, s)
+		}
+	}
+	if len(used) < 2 {
+		return lens, len(used)
+	}
+
+	// Build the tree, then count the leaves at each depth.
+	parent := make([]int, 2*len(freq))
+	h := &tianoNodeHeap{}
+	for _, s := range used {
+		heap.Push(h, tianoNode{freq: freq[s], sym: s})
+	}
+	next := len(freq)
+	for h.Len() > 1 {
+		a, b := heap.Pop(h).(tianoNode), heap.Pop(h).(tianoNode)
+		parent[a.sym], parent[b.sym] = next, next
+		depth := a.depth
+		if b.depth > depth {
+			depth = b.depth
+		}
+		heap.Push(h, tianoNode{freq: a.freq + b.freq, sym: next, depth: depth + 1})
+		next++
+	}
+	root := next - 1
+	var count [tianoCodeBit + 1]int
+	for _, s := range used {
+		d := 0
+		for n := s; n != root; n = parent[n] {
+			d++
+		}
+		if d > tianoCodeBit {
+			d = tianoCodeBit
+		}
+		count[d]++
+	}
+
+	// Limit the lengths as EDK2's MakeLen does, keeping the code complete.
+	cum := 0
+	for d := 1; d <= tianoCodeBit; d++ {
+		cum += count[d] << (tianoCodeBit - d)
+	}
+	for ; cum != 1<<tianoCodeBit; cum-- {
+		count[tianoCodeBit]--
+		for d := tianoCodeBit - 1; d > 0; d-- {
+			if count[d] != 0 {
+				count[d]--
+				count[d+1] += 2
+				break
+			}
+		}
+	}
+
+	// The most frequent symbols get the shortest codes.
+	sort.SliceStable(used, func(i, j int) bool { return freq[used[i]] > freq[used[j]] })
+	i := 0
+	for d := 1; d <= tianoCodeBit; d++ {
+		for ; count[d] > 0; count[d]-- {
+			lens[used[i]] = uint8(d)
+			i++
+		}
+	}
+	return lens, len(used)
+}
+
+// tianoCodes returns the canonical codes of the code lengths, as the
+// decoder assigns them.
+func tianoCodes(lens []uint8) []uint32 {
+	var count [17]uint32
+	for _, l := range lens {
+		count[l]++
+	}
+	var start [18]uint32
+	for i := 1; i <= 16; i++ {
+		start[i+1] = (start[i] + count[i]) << 1
+	}
+	codes := make([]uint32, len(lens))
+	for s, l := range lens {
+		if l != 0 {
+			codes[s] = start[l]
+			start[l]++
+		}
+	}
+	return codes
+}
+
+// trimLens returns the number of code lengths without the trailing zeros.
+func trimLens(lens []uint8) int {
+	n := len(lens)
+	for n > 0 && lens[n-1] == 0 {
+		n--
+	}
+	return n
+}
+
+// writePTLen writes the code lengths of the position table or of the table
+// of the code lengths, as readPTLen reads them.
+func (w *tianoWriter) writePTLen(lens []uint8, nBit uint, special int) {
+	n := trimLens(lens)
+	w.put(nBit, uint32(n))
+	for i := 0; i < n; {
+		k := uint(lens[i])
+		if k <= 6 {
+			w.put(3, uint32(k))
+		} else {
+			w.put(k-3, 1<<(k-3)-2)
+		}
+		i++
+		if i == special {
+			for i < 6 && lens[i] == 0 {
+				i++
+			}
+			w.put(2, uint32(i-3))
+		}
+	}
+}
+
+// cLenRuns calls f with the symbols coding the code lengths of the character
+// and length table and their extra bits.
+func cLenRuns(lens []uint8, f func(t int, nExtra uint, extra uint32)) {
+	n := trimLens(lens)
+	for i := 0; i < n; {
+		k := lens[i]
+		i++
+		if k != 0 {
+			f(int(k)+2, 0, 0)
+			continue
+		}
+		zeros := 1
+		for i < n && lens[i] == 0 {
+			i++
+			zeros++
+		}
+		switch {
+		case zeros <= 2:
+			for ; zeros > 0; zeros-- {
+				f(0, 0, 0)
+			}
+		case zeros <= 18:
+			f(1, 4, uint32(zeros-3))
+		case zeros == 19:
+			f(0, 0, 0)
+			f(1, 4, 15)
+		default:
+			f(2, tianoCBit, uint32(zeros-20))
+		}
+	}
+}
+
+// writeBlock writes a block of symbols with its Huffman tables, as EDK2's
+// SendBlock does.
+func (w *tianoWriter) writeBlock(syms []tianoSymbol, np int, pBit uint) {
+	cFreq := make([]int, tianoNC)
+	pFreq := make([]int, np)
+	for _, s := range syms {
+		cFreq[s.code()]++
+		if s.length != 0 {
+			pFreq[s.pCode()]++
+		}
+	}
+	w.put(16, uint32(len(syms)))
+
+	cLens, cUsed := tianoCodeLens(cFreq)
+	if cUsed > 1 {
+		tFreq := make([]int, tianoNT)
+		cLenRuns(cLens, func(t int, _ uint, _ uint32) { tFreq[t]++ })
+		tLens, tUsed := tianoCodeLens(tFreq)
+		if tUsed > 1 {
+			w.writePTLen(tLens, tianoTBit, 3)
+		} else {
+			w.put(tianoTBit, 0)
+			w.put(tianoTBit, uint32(firstUsed(tFreq)))
+		}
+		tCodes := tianoCodes(tLens)
+		w.put(tianoCBit, uint32(trimLens(cLens)))
+		cLenRuns(cLens, func(t int, nExtra uint, extra uint32) {
+			w.put(uint(tLens[t]), tCodes[t])
+			if nExtra > 0 {
+				w.put(nExtra, extra)
+			}
+		})
+	} else {
+		w.put(tianoTBit, 0)
+		w.put(tianoTBit, 0)
+		w.put(tianoCBit, 0)
+		w.put(tianoCBit, uint32(firstUsed(cFreq)))
+	}
+
+	pLens, pUsed := tianoCodeLens(pFreq)
+	if pUsed > 1 {
+		w.writePTLen(pLens, pBit, -1)
+	} else {
+		w.put(pBit, 0)
+		w.put(pBit, uint32(firstUsed(pFreq)))
+	}
+
+	cCodes, pCodes := tianoCodes(cLens), tianoCodes(pLens)
+	for _, s := range syms {
+		c := s.code()
+		w.put(uint(cLens[c]), cCodes[c])
+		if s.length == 0 {
+			continue
+		}
+		p := s.pCode()
+		w.put(uint(pLens[p]), pCodes[p])
+		if p > 1 {
+			w.put(uint(p-1), s.pos)
+		}
+	}
+}
+
+// firstUsed returns the first symbol with a frequency, or 0.
+func firstUsed(freq []int) int {
+	for s, f := range freq {
+		if f > 0 {
+			return s
+		}
+	}
+	return 0
+}
+
+// Encode encodes a byte slice with EFI 1.1 or Tiano compression.
+func (c *Tiano) Encode(decodedData []byte) ([]byte, error) {
+	if uint64(len(decodedData)) > 0xffffffff {
+		return nil, fmt.Errorf("%s.Encode: %#x bytes do not fit in the header", c.Name(), len(decodedData))
+	}
+	windowBits, pBit := c.windowBits()
+	w := &tianoWriter{out: make([]byte, tianoHeaderSize)}
+	syms := tianoMatches(decodedData, windowBits)
+	for len(syms) > 0 {
+		n := len(syms)
+		if n > tianoBlockSize {
+			n = tianoBlockSize
+		}
+		w.writeBlock(syms[:n], int(windowBits)+1, pBit)
+		syms = syms[n:]
+	}
+	w.flush()
+	binary.LittleEndian.PutUint32(w.out, uint32(len(w.out)-tianoHeaderSize))
+	binary.LittleEndian.PutUint32(w.out[4:], uint32(len(decodedData)))
+	return w.out, nil
+}
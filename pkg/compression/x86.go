@@ -11,6 +11,12 @@ type LZMAX86 struct {
 	lzma Compressor
 }
 
+// NewLZMAX86 returns an LZMAX86 compressor with the x86 filter layered on
+// top of lzma.
+func NewLZMAX86(lzma Compressor) *LZMAX86 {
+	return &LZMAX86{lzma}
+}
+
 // Name returns the type of compression employed.
 func (c *LZMAX86) Name() string {
 	return "LZMAX86"
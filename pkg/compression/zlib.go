@@ -9,7 +9,6 @@ import (
 	"compress/zlib"
 	"encoding/binary"
 	"errors"
-	"io"
 )
 
 const (
@@ -49,7 +48,7 @@ func (c *ZLIB) Decode(encodedData []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	decodedData, err := io.ReadAll(r)
+	decodedData, err := readAllLimited(c.Name(), r, -1)
 	r.Close()
 	if err != nil {
 		return nil, err
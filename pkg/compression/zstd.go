@@ -0,0 +1,46 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compression
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Zstd implements Compressor and uses a Go-based implementation of
+// Zstandard. No GUID is specified for Zstandard sections, vendors using it
+// may be supported with RegisterGUID.
+type Zstd struct{}
+
+// Name returns the type of compression employed.
+func (c *Zstd) Name() string {
+	return "ZSTD"
+}
+
+// Decode decodes a byte slice of Zstandard frames.
+func (c *Zstd) Decode(encodedData []byte) ([]byte, error) {
+	r, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(MaxDecodedSize))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	decodedData, err := r.DecodeAll(encodedData, nil)
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+		return nil, fmt.Errorf("%s.Decode: %w", c.Name(), ErrTooLarge)
+	}
+	return decodedData, err
+}
+
+// Encode encodes a byte slice with Zstandard, in a single frame.
+func (c *Zstd) Encode(decodedData []byte) ([]byte, error) {
+	w, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+	return w.EncodeAll(decodedData, nil), nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package corim
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// CBOR major types, see RFC 8949.
+const (
+	majorUint   = 0
+	majorNegint = 1
+	majorBytes  = 2
+	majorText   = 3
+	majorArray  = 4
+	majorMap    = 5
+	majorTag    = 6
+)
+
+// cborMap is a CBOR map with integer keys, as all the maps of CoRIM, CoMID
+// and CoSWID. The pairs must be in increasing key order so that the encoding
+// is deterministic.
+type cborMap []cborPair
+
+type cborPair struct {
+	key   int
+	value interface{}
+}
+
+// cborTag is a tagged CBOR item.
+type cborTag struct {
+	tag   uint64
+	value interface{}
+}
+
+// encodeCBOR returns the deterministic encoding of v, which is made of
+// integers, strings, byte slices, slices, cborMap and cborTag.
+func encodeCBOR(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	if err := writeCBOR(&b, v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func writeCBOR(b *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			writeHead(b, majorNegint, uint64(-1-v))
+		} else {
+			writeHead(b, majorUint, uint64(v))
+		}
+	case uint64:
+		writeHead(b, majorUint, v)
+	case string:
+		writeHead(b, majorText, uint64(len(v)))
+		b.WriteString(v)
+	case []byte:
+		writeHead(b, majorBytes, uint64(len(v)))
+		b.Write(v)
+	case []interface{}:
+		writeHead(b, majorArray, uint64(len(v)))
+		for _, e := range v {
+			if err := writeCBOR(b, e); err != nil {
+				return err
+			}
+		}
+	case cborMap:
+		writeHead(b, majorMap, uint64(len(v)))
+		for i, p := range v {
+			if i > 0 && p.key <= v[i-1].key {
+				return fmt.Errorf("map keys are not in increasing order: %d after %d", p.key, v[i-1].key)
+			}
+			if err := writeCBOR(b, p.key); err != nil {
+				return err
+			}
+			if err := writeCBOR(b, p.value); err != nil {
+				return err
+			}
+		}
+	case cborTag:
+		writeHead(b, majorTag, v.tag)
+		return writeCBOR(b, v.value)
+	default:
+		return fmt.Errorf("cannot encode %T in CBOR", v)
+	}
+	return nil
+}
+
+// writeHead writes the initial bytes of an item, with the argument n in
+// its shortest form.
+func writeHead(b *bytes.Buffer, major byte, n uint64) {
+	switch {
+	case n < 24:
+		b.WriteByte(major<<5 | byte(n))
+	case n <= 0xff:
+		b.WriteByte(major<<5 | 24)
+		b.WriteByte(byte(n))
+	case n <= 0xffff:
+		b.WriteByte(major<<5 | 25)
+		b.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	case n <= 0xffffffff:
+		b.WriteByte(major<<5 | 26)
+		b.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	default:
+		b.WriteByte(major<<5 | 27)
+		b.Write(binary.BigEndian.AppendUint64(nil, n))
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package corim
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+func TestEncodeCBOR(t *testing.T) {
+	// The examples of RFC 8949, appendix A.
+	for _, test := range []struct {
+		v    interface{}
+		want string
+	}{
+		{0, "00"},
+		{23, "17"},
+		{24, "1818"},
+		{100, "1864"},
+		{1000, "1903e8"},
+		{1000000, "1a000f4240"},
+		{uint64(1000000000000), "1b000000e8d4a51000"},
+		{-1, "20"},
+		{-100, "3863"},
+		{-1000, "3903e7"},
+		{"", "60"},
+		{"IETF", "6449455446"},
+		{[]byte{1, 2, 3, 4}, "4401020304"},
+		{[]interface{}{1, []interface{}{2, 3}, []interface{}{4, 5}}, "8301820203820405"},
+		{cborMap{{1, 2}, {3, 4}}, "a201020304"},
+		{cborTag{1, 1363896240}, "c11a514b67b0"},
+	} {
+		got, err := encodeCBOR(test.v)
+		if err != nil {
+			t.Errorf("%v: %v", test.v, err)
+			continue
+		}
+		if hex.EncodeToString(got) != test.want {
+			t.Errorf("%v: got %x, want %s", test.v, got, test.want)
+		}
+	}
+
+	if _, err := encodeCBOR(cborMap{{3, 4}, {1, 2}}); err == nil {
+		t.Errorf("expected an error for unsorted map keys")
+	}
+	if _, err := encodeCBOR(1.5); err == nil {
+		t.Errorf("expected an error for a float")
+	}
+}
+
+// decodeCBOR decodes the items which encodeCBOR encodes, returning the
+// rest of b. Maps are decoded as map[int]interface{}, integers as int.
+func decodeCBOR(b []byte) (interface{}, []byte, error) {
+	if len(b) == 0 {
+		return nil, nil, fmt.Errorf("unexpected end of data")
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+	b = b[1:]
+	var n uint64
+	switch {
+	case info < 24:
+		n = uint64(info)
+	case info <= 27:
+		size := 1 << (info - 24)
+		if len(b) < size {
+			return nil, nil, fmt.Errorf("unexpected end of data")
+		}
+		var buf [8]byte
+		copy(buf[8-size:], b[:size])
+		n, b = binary.BigEndian.Uint64(buf[:]), b[size:]
+	default:
+		return nil, nil, fmt.Errorf("unsupported additional information %d", info)
+	}
+	switch major {
+	case majorUint:
+		return int(n), b, nil
+	case majorNegint:
+		return -1 - int(n), b, nil
+	case majorBytes, majorText:
+		if uint64(len(b)) < n {
+			return nil, nil, fmt.Errorf("unexpected end of data")
+		}
+		if major == majorText {
+			return string(b[:n]), b[n:], nil
+		}
+		return b[:n], b[n:], nil
+	case majorArray:
+		var a []interface{}
+		for i := uint64(0); i < n; i++ {
+			var (
+				e   interface{}
+				err error
+			)
+			if e, b, err = decodeCBOR(b); err != nil {
+				return nil, nil, err
+			}
+			a = append(a, e)
+		}
+		return a, b, nil
+	case majorMap:
+		m := map[int]interface{}{}
+		for i := uint64(0); i < n; i++ {
+			var (
+				k, v interface{}
+				err  error
+			)
+			if k, b, err = decodeCBOR(b); err != nil {
+				return nil, nil, err
+			}
+			if v, b, err = decodeCBOR(b); err != nil {
+				return nil, nil, err
+			}
+			m[k.(int)] = v
+		}
+		return m, b, nil
+	case majorTag:
+		v, b, err := decodeCBOR(b)
+		return cborTag{n, v}, b, err
+	}
+	return nil, nil, fmt.Errorf("unsupported major type %d", major)
+}
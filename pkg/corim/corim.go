@@ -0,0 +1,216 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package corim encodes reference values of a firmware image as a CoRIM
+// (Concise Reference Integrity Manifest, draft-ietf-rats-corim), so that
+// attestation verifiers can be provisioned from a golden image. The CoRIM
+// holds two tags describing the same measurements: a CoMID with a reference
+// value triple for the firmware, and a CoSWID (RFC 9393) listing them as
+// the files of the firmware payload.
+package corim
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+)
+
+// Digest algorithms, as in the IANA Named Information Hash Algorithm
+// Registry used by CoRIM and CoSWID.
+const (
+	SHA256 = 1
+	SHA384 = 7
+	SHA512 = 8
+)
+
+// AlgorithmNames are the names of the digest algorithms in the registry.
+var AlgorithmNames = map[int]string{
+	SHA256: "sha-256",
+	SHA384: "sha-384",
+	SHA512: "sha-512",
+}
+
+// CBOR tags of the CoRIM and of the tags it holds.
+const (
+	tagUnsignedCoRIM = 501
+	tagCoSWID        = 505
+	tagCoMID         = 506
+)
+
+// Keys of the CoMID maps.
+const (
+	comidKeyTagIdentity   = 1
+	comidKeyEntities      = 2
+	comidKeyTriples       = 4
+	triplesKeyReference   = 0
+	measurementKeyDigests = 2
+	measurementKeyName    = 11
+	comidRoleTagCreator   = 0
+)
+
+// Keys of the CoSWID maps, see RFC 9393.
+const (
+	coswidKeyTagID            = 0
+	coswidKeySoftwareName     = 1
+	coswidKeyEntity           = 2
+	coswidKeyPayload          = 6
+	coswidKeyHash             = 7
+	coswidKeyTagVersion       = 12
+	coswidKeySoftwareVersion  = 13
+	coswidKeyFile             = 17
+	coswidKeySize             = 20
+	coswidKeyFSName           = 24
+	coswidKeyEntityName       = 31
+	coswidKeyRole             = 33
+	coswidRoleTagCreator      = 1
+	coswidRoleSoftwareCreator = 2
+)
+
+// Digest is a digest of a measured component.
+type Digest struct {
+	Algorithm int
+	Value     []byte
+}
+
+// Measurement is the reference value of a component of the image.
+type Measurement struct {
+	Name string
+	// Size of the measured data, 0 if unknown.
+	Size    uint64 `json:",omitempty"`
+	Digests []Digest
+}
+
+// Manifest is a CoRIM of reference values.
+type Manifest struct {
+	// ID identifies the CoRIM. The IDs of its tags are derived from it.
+	ID guid.GUID
+	// Vendor and Model describe the platform of the firmware in the CoMID.
+	Vendor string
+	Model  string
+	// Creator is the name of the entity which creates the tags.
+	Creator string
+	// SoftwareName and SoftwareVersion describe the firmware in the CoSWID.
+	SoftwareName    string
+	SoftwareVersion string
+
+	Measurements []Measurement
+}
+
+// tagID returns the ID of one of the tags of the CoRIM.
+func (m *Manifest) tagID(kind string) []byte {
+	id := guid.NewV5(m.ID, []byte(kind))
+	return uuidBytes(id)
+}
+
+// uuidBytes returns the 16 bytes of a UUID in network order, which the GUIDs
+// hold in mixed endianness.
+func uuidBytes(g guid.GUID) []byte {
+	b := g[:]
+	return []byte{
+		b[3], b[2], b[1], b[0], b[5], b[4], b[7], b[6],
+		b[8], b[9], b[10], b[11], b[12], b[13], b[14], b[15],
+	}
+}
+
+// Marshal returns the CBOR encoding of the unsigned CoRIM.
+func (m *Manifest) Marshal() ([]byte, error) {
+	if len(m.Measurements) == 0 {
+		return nil, errors.New("no measurements")
+	}
+	switch {
+	case m.Vendor == "" && m.Model == "":
+		return nil, errors.New("the CoMID needs a vendor or a model")
+	case m.Creator == "":
+		return nil, errors.New("the tags need a creator")
+	case m.SoftwareName == "":
+		return nil, errors.New("the CoSWID needs a software name")
+	}
+	comid, err := encodeCBOR(m.comid())
+	if err != nil {
+		return nil, fmt.Errorf("CoMID: %w", err)
+	}
+	coswid, err := encodeCBOR(m.coswid())
+	if err != nil {
+		return nil, fmt.Errorf("CoSWID: %w", err)
+	}
+	return encodeCBOR(cborTag{tagUnsignedCoRIM, cborMap{
+		{0, uuidBytes(m.ID)},
+		{1, []interface{}{
+			cborTag{tagCoMID, comid},
+			cborTag{tagCoSWID, coswid},
+		}},
+	}})
+}
+
+func (m *Manifest) comid() cborMap {
+	class := cborMap{}
+	if m.Vendor != "" {
+		class = append(class, cborPair{1, m.Vendor})
+	}
+	if m.Model != "" {
+		class = append(class, cborPair{2, m.Model})
+	}
+	var measurements []interface{}
+	for _, ms := range m.Measurements {
+		var digests []interface{}
+		for _, d := range ms.Digests {
+			digests = append(digests, []interface{}{d.Algorithm, d.Value})
+		}
+		measurements = append(measurements, cborMap{
+			// mkey
+			{0, ms.Name},
+			// mval
+			{1, cborMap{
+				{measurementKeyDigests, digests},
+				{measurementKeyName, ms.Name},
+			}},
+		})
+	}
+	environment := cborMap{{0, class}}
+
+	return cborMap{
+		{comidKeyTagIdentity, cborMap{{0, m.tagID("comid")}}},
+		{comidKeyEntities, []interface{}{
+			cborMap{{0, m.Creator}, {2, []interface{}{comidRoleTagCreator}}},
+		}},
+		{comidKeyTriples, cborMap{
+			{triplesKeyReference, []interface{}{
+				[]interface{}{environment, measurements},
+			}},
+		}},
+	}
+}
+
+func (m *Manifest) coswid() cborMap {
+	var files []interface{}
+	for _, ms := range m.Measurements {
+		file := cborMap{}
+		if ms.Size != 0 {
+			file = append(file, cborPair{coswidKeySize, ms.Size})
+		}
+		file = append(file, cborPair{coswidKeyFSName, ms.Name})
+		// A file has a single hash, the first digest.
+		if len(ms.Digests) != 0 {
+			d := ms.Digests[0]
+			file = append(cborMap{{coswidKeyHash, []interface{}{d.Algorithm, d.Value}}}, file...)
+		}
+		files = append(files, file)
+	}
+
+	coswid := cborMap{
+		{coswidKeyTagID, m.tagID("coswid")},
+		{coswidKeySoftwareName, m.SoftwareName},
+		{coswidKeyEntity, cborMap{
+			{coswidKeyEntityName, m.Creator},
+			{coswidKeyRole, []interface{}{coswidRoleTagCreator, coswidRoleSoftwareCreator}},
+		}},
+		{coswidKeyPayload, cborMap{{coswidKeyFile, files}}},
+		{coswidKeyTagVersion, 0},
+	}
+	if m.SoftwareVersion != "" {
+		coswid = append(coswid, cborPair{coswidKeySoftwareVersion, m.SoftwareVersion})
+	}
+	return coswid
+}
@@ -0,0 +1,118 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package corim
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+)
+
+func testManifest() *Manifest {
+	return &Manifest{
+		ID:           *guid.MustParse("01234567-89AB-CDEF-0123-456789ABCDEF"),
+		Vendor:       "LinuxBoot",
+		Model:        "OVMF",
+		Creator:      "fiano",
+		SoftwareName: "OVMF firmware",
+		Measurements: []Measurement{
+			{Name: "bootguard-ibb", Digests: []Digest{{SHA256, bytes.Repeat([]byte{0xaa}, 32)}, {SHA384, bytes.Repeat([]byte{0xbb}, 48)}}},
+			{Name: "bios", Size: 0x1000, Digests: []Digest{{SHA256, bytes.Repeat([]byte{0xcc}, 32)}}},
+		},
+	}
+}
+
+// decodeTag decodes a tag of the CoRIM, a CBOR tag of a byte string holding
+// the map of the tag.
+func decodeTag(t *testing.T, v interface{}, tag uint64) map[int]interface{} {
+	t.Helper()
+	tagged, ok := v.(cborTag)
+	if !ok || tagged.tag != tag {
+		t.Fatalf("got %v, want tag %d", v, tag)
+	}
+	m, rest, err := decodeCBOR(tagged.value.([]byte))
+	if err != nil || len(rest) != 0 {
+		t.Fatalf("tag %d: %v, %d bytes left", tag, err, len(rest))
+	}
+	return m.(map[int]interface{})
+}
+
+func TestMarshal(t *testing.T) {
+	m := testManifest()
+	b, err := m.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b2, err := m.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, b2) {
+		t.Errorf("the encoding is not deterministic")
+	}
+
+	v, rest, err := decodeCBOR(b)
+	if err != nil || len(rest) != 0 {
+		t.Fatalf("%v, %d bytes left", err, len(rest))
+	}
+	corim, ok := v.(cborTag)
+	if !ok || corim.tag != tagUnsignedCoRIM {
+		t.Fatalf("got %v, want an unsigned CoRIM", v)
+	}
+	corimMap := corim.value.(map[int]interface{})
+	if got, want := corimMap[0], []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}; !bytes.Equal(got.([]byte), want) {
+		t.Errorf("CoRIM ID: got %x, want %x", got, want)
+	}
+	tags := corimMap[1].([]interface{})
+	if len(tags) != 2 {
+		t.Fatalf("got %d tags, want 2", len(tags))
+	}
+
+	comid := decodeTag(t, tags[0], tagCoMID)
+	reference := comid[comidKeyTriples].(map[int]interface{})[triplesKeyReference].([]interface{})[0].([]interface{})
+	class := reference[0].(map[int]interface{})[0]
+	if want := map[int]interface{}{1: "LinuxBoot", 2: "OVMF"}; !reflect.DeepEqual(class, want) {
+		t.Errorf("class: got %v, want %v", class, want)
+	}
+	measurements := reference[1].([]interface{})
+	if len(measurements) != 2 {
+		t.Fatalf("got %d measurements, want 2", len(measurements))
+	}
+	ibb := measurements[0].(map[int]interface{})
+	if ibb[0] != "bootguard-ibb" {
+		t.Errorf("mkey: got %v", ibb[0])
+	}
+	digests := ibb[1].(map[int]interface{})[measurementKeyDigests].([]interface{})
+	if len(digests) != 2 || digests[1].([]interface{})[0] != SHA384 {
+		t.Errorf("digests: got %v", digests)
+	}
+
+	coswid := decodeTag(t, tags[1], tagCoSWID)
+	if coswid[coswidKeySoftwareName] != "OVMF firmware" || coswid[coswidKeyTagVersion] != 0 {
+		t.Errorf("CoSWID: got %v", coswid)
+	}
+	files := coswid[coswidKeyPayload].(map[int]interface{})[coswidKeyFile].([]interface{})
+	bios := files[1].(map[int]interface{})
+	if bios[coswidKeyFSName] != "bios" || bios[coswidKeySize] != 0x1000 || bios[coswidKeyHash].([]interface{})[0] != SHA256 {
+		t.Errorf("file: got %v", bios)
+	}
+}
+
+func TestMarshalErrors(t *testing.T) {
+	for name, change := range map[string]func(m *Manifest){
+		"no measurements": func(m *Manifest) { m.Measurements = nil },
+		"no platform":     func(m *Manifest) { m.Vendor, m.Model = "", "" },
+		"no creator":      func(m *Manifest) { m.Creator = "" },
+		"no software":     func(m *Manifest) { m.SoftwareName = "" },
+	} {
+		m := testManifest()
+		change(m)
+		if _, err := m.Marshal(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
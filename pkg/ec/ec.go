@@ -0,0 +1,144 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package ec finds embedded controller firmware in flash images, either in
+// the EC region of the flash descriptor or bundled in the BIOS region, and
+// extracts their versions.
+//
+// The firmware is recognized by the markers its build embeds:
+//   - Chrome EC images hold an image_data structure, between two cookies,
+//     with the version of the RO and RW copies,
+//   - System76 EC images hold "76EC_BOARD=" and "76EC_VERSION=" strings,
+//
+// and, at the start of an EC region, by the header the boot ROM of the EC
+// reads:
+//   - Nuvoton NPCX images start with the anchor of their firmware header,
+//   - Microchip MEC images hold a "PHCM" header at a 256 bytes boundary.
+package ec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"strings"
+)
+
+// Types of EC firmware.
+const (
+	TypeChromeEC     = "Chrome EC"
+	TypeSystem76EC   = "System76 EC"
+	TypeNuvotonNPCX  = "Nuvoton NPCX"
+	TypeMicrochipMEC = "Microchip MEC"
+)
+
+// Blob is an EC firmware found in an image.
+type Blob struct {
+	Type string
+	// Offset of the marker or header in the data searched.
+	Offset uint64
+	// Board is the board the firmware was built for, if known.
+	Board   string `json:",omitempty"`
+	Version string `json:",omitempty"`
+}
+
+// Chrome EC image_data structure:
+//
+//	uint32_t cookie1;
+//	char version[32];
+//	uint32_t size;
+//	int32_t rollback_version;
+//	uint32_t cookie2;
+const (
+	chromeECCookie1      = 0xce778899
+	chromeECCookie2      = 0xceaabbdd
+	chromeECVersionLen   = 32
+	chromeECImageDataLen = 4 + chromeECVersionLen + 4 + 4 + 4
+)
+
+// System76 EC strings.
+const (
+	system76Board   = "76EC_BOARD="
+	system76Version = "76EC_VERSION="
+)
+
+// Headers read by the boot ROMs.
+const (
+	npcxAnchor = 0x2A3B4D5E
+	mecTag     = "PHCM"
+	// mecSearchLen is how far the MEC header is searched from the start
+	// of the EC region.
+	mecSearchLen = 0x10000
+)
+
+// Find returns the EC firmware whose markers are in b, sorted by offset.
+func Find(b []byte) []Blob {
+	blobs := append(findChromeEC(b), findSystem76EC(b)...)
+	sort.SliceStable(blobs, func(i, j int) bool {
+		return blobs[i].Offset < blobs[j].Offset
+	})
+	return blobs
+}
+
+// FindRegion returns the EC firmware of an EC region: the EC family
+// recognized from the header at its start, then the firmware of Find.
+func FindRegion(b []byte) []Blob {
+	var blobs []Blob
+	if len(b) >= 4 && binary.LittleEndian.Uint32(b) == npcxAnchor {
+		blobs = append(blobs, Blob{Type: TypeNuvotonNPCX})
+	}
+	for off := 0; off+len(mecTag) <= len(b) && off < mecSearchLen; off += 0x100 {
+		if string(b[off:off+len(mecTag)]) == mecTag {
+			blobs = append(blobs, Blob{Type: TypeMicrochipMEC, Offset: uint64(off)})
+			break
+		}
+	}
+	return append(blobs, Find(b)...)
+}
+
+// findChromeEC returns the Chrome EC images, one per image_data structure.
+func findChromeEC(b []byte) []Blob {
+	var cookie [4]byte
+	binary.LittleEndian.PutUint32(cookie[:], chromeECCookie1)
+	var blobs []Blob
+	for off := 0; ; {
+		i := bytes.Index(b[off:], cookie[:])
+		if i < 0 {
+			return blobs
+		}
+		off += i
+		if off+chromeECImageDataLen <= len(b) && binary.LittleEndian.Uint32(b[off+chromeECImageDataLen-4:]) == chromeECCookie2 {
+			version := cString(b[off+4 : off+4+chromeECVersionLen])
+			blob := Blob{Type: TypeChromeEC, Offset: uint64(off), Version: version}
+			// Versions are BOARD_vMAJOR.MINOR.BUILD-HASH.
+			if i := strings.Index(version, "_v"); i > 0 {
+				blob.Board = version[:i]
+			}
+			blobs = append(blobs, blob)
+		}
+		off += 4
+	}
+}
+
+// findSystem76EC returns the System76 EC image whose version string is in b.
+func findSystem76EC(b []byte) []Blob {
+	i := bytes.Index(b, []byte(system76Version))
+	if i < 0 {
+		return nil
+	}
+	blob := Blob{Type: TypeSystem76EC, Offset: uint64(i), Version: cString(b[i+len(system76Version):])}
+	if j := bytes.Index(b, []byte(system76Board)); j >= 0 {
+		blob.Board = cString(b[j+len(system76Board):])
+	}
+	return []Blob{blob}
+}
+
+// cString returns the printable string which starts b, up to a NUL byte.
+func cString(b []byte) string {
+	for i, c := range b {
+		if c < ' ' || c > '~' {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,61 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// chromeECImageData returns an image_data structure of the given version.
+func chromeECImageData(version string) []byte {
+	b := make([]byte, chromeECImageDataLen)
+	binary.LittleEndian.PutUint32(b, chromeECCookie1)
+	copy(b[4:], version)
+	binary.LittleEndian.PutUint32(b[chromeECImageDataLen-4:], chromeECCookie2)
+	return b
+}
+
+func TestFind(t *testing.T) {
+	image := bytes.Repeat([]byte{0xff}, 0x1000)
+	copy(image[0x100:], chromeECImageData("fizz_v1.1.7326-0a8b7d5ac"))
+	copy(image[0x800:], chromeECImageData("fizz_v1.1.7400-1b2c3d4e5"))
+	// A cookie without the second one is not an image_data.
+	binary.LittleEndian.PutUint32(image[0x400:], chromeECCookie1)
+	copy(image[0xa00:], "76EC_BOARD=system76/lemp9\x00")
+	copy(image[0xa20:], "76EC_VERSION=2023-05-05_1a2b3c4\x00")
+
+	want := []Blob{
+		{Type: TypeChromeEC, Offset: 0x100, Board: "fizz", Version: "fizz_v1.1.7326-0a8b7d5ac"},
+		{Type: TypeChromeEC, Offset: 0x800, Board: "fizz", Version: "fizz_v1.1.7400-1b2c3d4e5"},
+		{Type: TypeSystem76EC, Offset: 0xa20, Board: "system76/lemp9", Version: "2023-05-05_1a2b3c4"},
+	}
+	if got := Find(image); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got := Find(bytes.Repeat([]byte{0xff}, 0x100)); len(got) != 0 {
+		t.Errorf("got %+v in an erased image", got)
+	}
+}
+
+func TestFindRegion(t *testing.T) {
+	npcx := bytes.Repeat([]byte{0xff}, 0x1000)
+	binary.LittleEndian.PutUint32(npcx, npcxAnchor)
+	copy(npcx[0x200:], chromeECImageData("volteer_v2.0.5-abcdef"))
+	got := FindRegion(npcx)
+	if len(got) != 2 || got[0].Type != TypeNuvotonNPCX || got[1].Type != TypeChromeEC || got[1].Board != "volteer" {
+		t.Errorf("NPCX: got %+v", got)
+	}
+
+	mec := bytes.Repeat([]byte{0xff}, 0x1000)
+	copy(mec[0x100:], mecTag)
+	// The header is only searched at 256 bytes boundaries.
+	copy(mec[0x210:], mecTag)
+	if got := FindRegion(mec); !reflect.DeepEqual(got, []Blob{{Type: TypeMicrochipMEC, Offset: 0x100}}) {
+		t.Errorf("MEC: got %+v", got)
+	}
+}
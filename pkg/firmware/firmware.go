@@ -0,0 +1,233 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package firmware is a small facade over the uefi and visitors packages for
+// the programs which inspect or modify UEFI images. Unlike those packages,
+// whose types change along with the parsers, its API is kept stable:
+//
+//	im, err := firmware.Open("image.rom")
+//	if err != nil {
+//	    return err
+//	}
+//	shells, err := im.Find("name=^Shell$")
+//	if err != nil {
+//	    return err
+//	}
+//	fmt.Println(shells[0].Path, shells[0].Size)
+//	if err := im.Apply("remove", "Shell"); err != nil {
+//	    return err
+//	}
+//	return im.Save("image-noshell.rom")
+//
+// As the erase polarity of the uefi package is global, images must not be
+// opened or modified concurrently.
+package firmware
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+	"github.com/linuxboot/fiano/pkg/knownguids"
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/visitors"
+)
+
+// Image is a parsed firmware image.
+type Image struct {
+	root uefi.Firmware
+
+	// The tree of nodes, built on demand and dropped when the image is
+	// modified.
+	tree  *Node
+	nodes map[uefi.Firmware]*Node
+}
+
+// Node describes a node of the tree of an image, such as a firmware volume,
+// a file or a section.
+type Node struct {
+	// Type is the type of the node, e.g. "FirmwareVolume", "File" or
+	// "Section".
+	Type string
+	// Name is the name of the node, if any: the UI name or known name of
+	// a file, the type of a section or the type of a region.
+	Name string `json:",omitempty"`
+	// GUID is the GUID of a file, firmware volume or NVRAM variable.
+	GUID *guid.GUID `json:",omitempty"`
+	// Path is the path of the node in the tree, as in the errors, e.g.
+	// "FV 8C8CE578-8A3D-4F1C-9935-896185C32DD3/File D6A2CB7F-6A18-4E2F-B43B-9920A733700A".
+	// It is empty for the root.
+	Path string `json:",omitempty"`
+	// Offset is the offset of the node in the image. It is unknown for
+	// the nodes in compressed sections.
+	Offset *uint64 `json:",omitempty"`
+	// Size is the size of the node in bytes.
+	Size     int
+	Children []*Node `json:",omitempty"`
+
+	f uefi.Firmware
+}
+
+// Bytes returns the content of the node, which must not be modified.
+func (n *Node) Bytes() []byte {
+	return n.f.Buf()
+}
+
+// Open parses the image file at path.
+func Open(path string) (*Image, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// The buffer is not used elsewhere, so it need not be copied.
+	root, err := uefi.ParseWithMode(context.Background(), buf, uefi.ParseModeCopyOnWrite)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &Image{root: root}, nil
+}
+
+// Parse parses the image in buf, which the caller may reuse.
+func Parse(buf []byte) (*Image, error) {
+	root, err := uefi.ParseWithMode(context.Background(), buf, uefi.ParseModeCopy)
+	if err != nil {
+		return nil, err
+	}
+	return &Image{root: root}, nil
+}
+
+// Tree returns the tree of the nodes of the image. It must not be modified,
+// and it is outdated once the image is modified.
+func (im *Image) Tree() (*Node, error) {
+	if im.tree != nil {
+		return im.tree, nil
+	}
+	nodes := map[uefi.Firmware]*Node{}
+	err := uefi.Walk(im.root, func(f, parent uefi.Firmware, path string, offset *uint64) error {
+		n := describe(f)
+		n.Path, n.Offset = path, offset
+		nodes[f] = n
+		if parent != nil {
+			p := nodes[parent]
+			p.Children = append(p.Children, n)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	im.tree, im.nodes = nodes[im.root], nodes
+	return im.tree, nil
+}
+
+// describe describes f, without its location and children.
+func describe(f uefi.Firmware) *Node {
+	n := &Node{
+		Type: strings.TrimPrefix(fmt.Sprintf("%T", f), "*uefi."),
+		Size: len(f.Buf()),
+		f:    f,
+	}
+	switch f := f.(type) {
+	case *uefi.File:
+		g := f.Header.GUID
+		n.GUID = &g
+		if n.Name = visitors.FileUIName(f); n.Name == "" {
+			n.Name, _ = knownguids.Name(g)
+		}
+	case *uefi.FirmwareVolume:
+		g := f.FileSystemGUID
+		n.GUID = &g
+		if f.ExtHeaderOffset != 0 {
+			n.Name = f.FVName.String()
+		}
+	case *uefi.Section:
+		n.Name = f.Type
+		if f.Name != "" {
+			n.Name = f.Name
+		}
+	case *uefi.NVar:
+		g := f.GUID
+		n.GUID = &g
+		n.Name = f.Name
+	case uefi.Region:
+		n.Name = f.Type().String()
+	}
+	return n
+}
+
+// Find returns the nodes matching the query, as the find-query command of
+// utk, e.g. "filetype=DRIVER,compression=NONE". A match on a section
+// returns the file which contains it.
+func (im *Image) Find(query string) ([]*Node, error) {
+	if _, err := im.Tree(); err != nil {
+		return nil, err
+	}
+	pred, err := visitors.ParseFindQuery(query, im.root)
+	if err != nil {
+		return nil, err
+	}
+	find := &visitors.Find{Predicate: pred}
+	if err := find.Run(im.root); err != nil {
+		return nil, err
+	}
+	var matches []*Node
+	for _, m := range find.Matches {
+		if n, ok := im.nodes[m]; ok {
+			matches = append(matches, n)
+		}
+	}
+	return matches, nil
+}
+
+// Extract extracts the image to the directory dir, which must be empty or
+// not exist, as the extract command of utk. The directory can be opened
+// again with utk.
+func (im *Image) Extract(dir string) error {
+	return (&visitors.Extract{BasePath: dir, DirPath: ".", Index: new(uint64)}).Run(im.root)
+}
+
+// ExtractTar writes the extracted image to w as a tar archive, laid out as
+// the directory written by Extract.
+func (im *Image) ExtractTar(w io.Writer) error {
+	tw := tar.NewWriter(w)
+	if err := (&visitors.Extract{DirPath: ".", Index: new(uint64), Archive: tw}).Run(im.root); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// Apply runs utk commands on the image, given as on the command line of utk
+// after the image, e.g. Apply("remove", "Shell", "replace_pe32", "Foo",
+// "foo.efi"). See "utk -h" for the commands.
+func (im *Image) Apply(args ...string) error {
+	v, err := visitors.ParseCLI(args)
+	if err != nil {
+		return err
+	}
+	// The commands may modify the tree.
+	im.tree, im.nodes = nil, nil
+	im.root, err = visitors.ExecuteCLIRoot(context.Background(), im.root, v)
+	return err
+}
+
+// Bytes assembles the image and returns it.
+func (im *Image) Bytes() ([]byte, error) {
+	if err := (&visitors.Assemble{}).Run(im.root); err != nil {
+		return nil, err
+	}
+	return im.root.Buf(), nil
+}
+
+// Save assembles the image and writes it to the file at path.
+func (im *Image) Save(path string) error {
+	buf, err := im.Bytes()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf, 0666)
+}
@@ -0,0 +1,109 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package firmware
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const ovmfPath = "../../integration/roms/OVMF.rom"
+
+func TestImage(t *testing.T) {
+	im, err := Open(ovmfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := im.Tree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Type != "BIOSRegion" || tree.Path != "" || len(tree.Children) == 0 {
+		t.Errorf("got root %+v, want a BIOS region with children", tree)
+	}
+
+	shells, err := im.Find("name=^Shell$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shells) != 1 {
+		t.Fatalf("got %d shells, want 1", len(shells))
+	}
+	shell := shells[0]
+	if shell.Type != "File" || shell.Name != "Shell" || shell.GUID == nil || shell.Path == "" {
+		t.Errorf("got shell %+v", shell)
+	}
+	if shell.Size != len(shell.Bytes()) || shell.Size == 0 {
+		t.Errorf("got size %d for %d bytes", shell.Size, len(shell.Bytes()))
+	}
+
+	if err := im.Apply("remove", "Shell"); err != nil {
+		t.Fatal(err)
+	}
+	if shells, err := im.Find("name=^Shell$"); err != nil || len(shells) != 0 {
+		t.Errorf("got %v, %v after removing the shell, want none", shells, err)
+	}
+
+	out := filepath.Join(t.TempDir(), "out.rom")
+	if err := im.Save(out); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shells, err := saved.Find("name=^Shell$"); err != nil || len(shells) != 0 {
+		t.Errorf("got %v, %v in the saved image, want none", shells, err)
+	}
+	if buf, err := saved.Bytes(); err != nil || len(buf) != tree.Size {
+		t.Errorf("got %d bytes, %v, want %d bytes", len(buf), err, tree.Size)
+	}
+}
+
+func TestParse(t *testing.T) {
+	image, err := os.ReadFile(ovmfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	im, err := Parse(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The image is copied, so the caller may reuse its buffer.
+	original := append([]byte{}, image...)
+	for i := range image {
+		image[i] = 0
+	}
+	tree, err := im.Tree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tree.Bytes(), original) {
+		t.Errorf("the image was modified")
+	}
+
+	dir := filepath.Join(t.TempDir(), "extract")
+	if err := im.Extract(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "summary.json")); err != nil {
+		t.Error(err)
+	}
+	if err := im.Extract(dir); err == nil {
+		t.Errorf("extracted to a directory which is not empty")
+	}
+
+	var archive bytes.Buffer
+	if err := im.ExtractTar(&archive); err != nil {
+		t.Fatal(err)
+	}
+	if h, err := tar.NewReader(&archive).Next(); err != nil || h.Size == 0 {
+		t.Errorf("got %+v, %v, want a first file", h, err)
+	}
+}
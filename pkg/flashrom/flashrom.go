@@ -0,0 +1,76 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package flashrom reads and writes live flash chips by calling out to the
+// system's flashrom. Any flashrom programmer can be used, such as
+// "internal" for the chipset SPI controller, "linux_mtd:dev=0" for the
+// Linux MTD interface (used by the intel-spi driver) or "linux_spi" for
+// spidev.
+package flashrom
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Prefix marks an image path as a flashrom programmer, as in
+// "flashrom:internal".
+const Prefix = "flashrom:"
+
+// Path is the flashrom executable.
+var Path = "flashrom"
+
+// Programmer returns the programmer of a path starting with Prefix.
+func Programmer(path string) (string, bool) {
+	if !strings.HasPrefix(path, Prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(path, Prefix), true
+}
+
+// Read reads the whole flash chip through the given programmer.
+func Read(programmer string) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "flashrom")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	image := filepath.Join(dir, "read.rom")
+	if err := run("-p", programmer, "-r", image); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(image)
+}
+
+// Write writes the image to the flash chip through the given programmer.
+// flashrom only erases and writes the blocks which differ, and verifies
+// them afterwards.
+func Write(programmer string, image []byte) error {
+	dir, err := os.MkdirTemp("", "flashrom")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "write.rom")
+	if err := os.WriteFile(path, image, 0666); err != nil {
+		return err
+	}
+	return run("-p", programmer, "-w", path)
+}
+
+func run(args ...string) error {
+	var out bytes.Buffer
+	cmd := exec.Command(Path, args...)
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s: %v\n%s", Path, strings.Join(args, " "), err, out.String())
+	}
+	return nil
+}
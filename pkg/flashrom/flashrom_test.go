@@ -0,0 +1,66 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flashrom
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeFlashrom installs a script which emulates flashrom with a file as
+// the flash chip.
+func fakeFlashrom(t *testing.T, chip string) {
+	script := filepath.Join(t.TempDir(), "flashrom")
+	err := os.WriteFile(script, []byte(`#!/bin/sh
+[ "$1" = -p ] && [ "$2" = dummy ] || { echo "bad programmer $2"; exit 1; }
+case "$3" in
+-r) cp "`+chip+`" "$4" ;;
+-w) cp "$4" "`+chip+`" ;;
+*) exit 1 ;;
+esac
+`), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := Path
+	Path = script
+	t.Cleanup(func() { Path = prev })
+}
+
+func TestReadWrite(t *testing.T) {
+	chip := filepath.Join(t.TempDir(), "chip.rom")
+	if err := os.WriteFile(chip, []byte("old image"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	fakeFlashrom(t, chip)
+
+	got, err := Read("dummy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "old image" {
+		t.Errorf("read %q", got)
+	}
+	if err := Write("dummy", []byte("new image")); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(chip); !bytes.Equal(got, []byte("new image")) {
+		t.Errorf("chip holds %q after write", got)
+	}
+	if _, err := Read("internal"); err == nil {
+		t.Errorf("expected error from flashrom")
+	}
+}
+
+func TestProgrammer(t *testing.T) {
+	if p, ok := Programmer("flashrom:linux_mtd:dev=0"); !ok || p != "linux_mtd:dev=0" {
+		t.Errorf("got %q, %v", p, ok)
+	}
+	if _, ok := Programmer("bios.rom"); ok {
+		t.Errorf("file name taken as a programmer")
+	}
+}
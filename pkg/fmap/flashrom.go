@@ -0,0 +1,41 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fmap
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteFlashromLayout writes the areas at indexes, or all of them if indexes
+// is nil, as a layout file of "flashrom -l": a line "START:END NAME" per
+// area, END being the offset of its last byte. The areas can then be written
+// alone with "flashrom -l FILE -i NAME". Empty areas, which flashrom refuses,
+// are left out.
+func (f *FMap) WriteFlashromLayout(w io.Writer, indexes []int) error {
+	if indexes == nil {
+		for i := range f.Areas {
+			indexes = append(indexes, i)
+		}
+	}
+	var b strings.Builder
+	for _, i := range indexes {
+		if i < 0 || i >= len(f.Areas) {
+			return fmt.Errorf("area index %d out of range", i)
+		}
+		a := f.Areas[i]
+		name := a.Name.String()
+		if name == "" || strings.ContainsAny(name, " \t\n") {
+			return fmt.Errorf("area %d: flashrom cannot name an area %q", i, name)
+		}
+		if a.Size == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "%08x:%08x %s\n", a.Offset, uint64(a.Offset)+uint64(a.Size)-1, name)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,47 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fmap
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteFlashromLayout(t *testing.T) {
+	f, err := ParseLayout(strings.NewReader(testLayout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := f.WriteFlashromLayout(&b, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := `00000000:0000ffff SI_BIOS
+00000000:00007fff RO_SECTION
+00000000:000003ff FMAP
+00000400:00007fff COREBOOT
+00008000:0000ffff RW_LEGACY
+`
+	if b.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+
+	b.Reset()
+	f.Areas[4].Size = 0
+	if err := f.WriteFlashromLayout(&b, []int{3, 4}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "00000400:00007fff COREBOOT\n"; b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+
+	f.Areas[3].Name = mustString(t, "CORE BOOT")
+	if err := f.WriteFlashromLayout(&b, []int{3}); err == nil {
+		t.Errorf("wrote an area name with a space")
+	}
+	if err := f.WriteFlashromLayout(&b, []int{5}); err == nil {
+		t.Errorf("wrote an area out of range")
+	}
+}
@@ -15,6 +15,8 @@ import (
 	"io"
 	"strconv"
 	"strings"
+
+	bytes2 "github.com/linuxboot/fiano/pkg/bytes"
 )
 
 // Signature of the fmap structure.
@@ -134,12 +136,34 @@ func readField(r io.Reader, data interface{}) error {
 
 var errSigNotFound = errors.New("cannot find FMAP signature")
 var errMultipleFound = errors.New("found multiple fmap")
+var errInvalidHeader = errors.New("invalid fmap header")
+
+// parseAt parses the fmap whose signature is at start of data.
+func parseAt(data []byte, start int) (*FMap, error) {
+	// Reader anchored to the start of the fmap
+	r := bytes.NewReader(data[start:])
 
-// Read an FMap into the data structure.
+	// Read fields.
+	var fmap FMap
+	if err := readField(r, &fmap.Header); err != nil {
+		return nil, err
+	}
+	if !headerValid(&fmap.Header) {
+		return nil, errInvalidHeader
+	}
+	fmap.Areas = make([]Area, fmap.NAreas)
+	if err := readField(r, &fmap.Areas); err != nil {
+		return nil, err
+	}
+	return &fmap, nil
+}
+
+// Read an FMap into the data structure. It fails if the flash holds several
+// valid fmaps: see FindAll and ReadAt to pick one.
 func Read(f io.Reader) (*FMap, *Metadata, error) {
 	// Read flash into memory.
 	// TODO: it is possible to parse fmap without reading entire file into memory
-	data, err := io.ReadAll(f)
+	data, err := bytes2.ReadAll(f)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -147,7 +171,7 @@ func Read(f io.Reader) (*FMap, *Metadata, error) {
 	// Loop over __FMAP__ occurrences until a valid header is found
 	start := 0
 	validFmaps := 0
-	var fmap FMap
+	var fmap *FMap
 	var fmapMetadata Metadata
 	for {
 		if start >= len(data) {
@@ -160,26 +184,17 @@ func Read(f io.Reader) (*FMap, *Metadata, error) {
 		}
 		start += next
 
-		// Reader anchored to the start of the fmap
-		r := bytes.NewReader(data[start:])
-
-		// Read fields.
-		var testFmap FMap
-		if err := readField(r, &testFmap.Header); err != nil {
-			return nil, nil, err
-		}
-		if !headerValid(&testFmap.Header) {
+		testFmap, err := parseAt(data, start)
+		if err == errInvalidHeader {
 			start += len(Signature)
 			continue
 		}
-		fmap = testFmap
-		validFmaps++
-
-		fmap.Areas = make([]Area, fmap.NAreas)
-		err := readField(r, &fmap.Areas)
 		if err != nil {
 			return nil, nil, err
 		}
+		fmap = testFmap
+		validFmaps++
+
 		// Return useful metadata
 		fmapMetadata = Metadata{
 			Start: uint64(start),
@@ -189,11 +204,94 @@ func Read(f io.Reader) (*FMap, *Metadata, error) {
 	if validFmaps >= 2 {
 		return nil, nil, errMultipleFound
 	} else if validFmaps == 1 {
-		return &fmap, &fmapMetadata, nil
+		return fmap, &fmapMetadata, nil
 	}
 	return nil, nil, errSigNotFound
 }
 
+// Candidate is an fmap signature found in a flash.
+type Candidate struct {
+	Metadata
+	// FMap is the map starting at the signature, nil if it does not parse.
+	FMap *FMap
+	// Err tells why the map does not parse.
+	Err error
+	// Score grows with the checks the map passes, see FindAll.
+	Score int
+}
+
+// score rates how likely f, found at start of a flash of flashSize bytes, is
+// the fmap of the flash rather than a stale copy or the fmap of a nested
+// image.
+func score(f *FMap, start uint64, flashSize int) int {
+	s := 1
+	if uint64(f.Size) == uint64(flashSize) {
+		s += 2
+	}
+	inFlash, named := true, true
+	for _, a := range f.Areas {
+		if uint64(a.Offset)+uint64(a.Size) > uint64(f.Size) {
+			inFlash = false
+		}
+		if !bytes.Contains(a.Name.Value[:], []byte("\x00")) {
+			named = false
+		}
+		if a.Name.String() == "FMAP" && uint64(a.Offset) == start {
+			s += 2
+		}
+	}
+	if inFlash {
+		s++
+	}
+	if named {
+		s++
+	}
+	return s
+}
+
+// FindAll returns every fmap signature of the flash, in order, with the
+// score of the map it starts: 1 for a valid header, plus 2 if the size of
+// the flash is the one of the map, 2 if an FMAP area starts at the
+// signature, 1 if all the areas are in the flash and 1 if all their names
+// are NUL terminated. Signatures which do not start a valid map score 0.
+func FindAll(f io.Reader) ([]Candidate, error) {
+	data, err := bytes2.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	var c []Candidate
+	for start := 0; start < len(data); start += len(Signature) {
+		next := bytes.Index(data[start:], Signature)
+		if next == -1 {
+			break
+		}
+		start += next
+		fmap, err := parseAt(data, start)
+		cand := Candidate{Metadata: Metadata{Start: uint64(start)}, FMap: fmap, Err: err}
+		if err == nil {
+			cand.Score = score(fmap, cand.Start, len(data))
+		}
+		c = append(c, cand)
+	}
+	return c, nil
+}
+
+// ReadAt reads the fmap whose signature is at offset start of the flash.
+func ReadAt(f io.Reader, start uint64) (*FMap, *Metadata, error) {
+	data, err := bytes2.ReadAll(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	if start > uint64(len(data)) || !bytes.HasPrefix(data[start:], Signature) {
+		return nil, nil, fmt.Errorf("no fmap signature at %#x", start)
+	}
+	fmap, err := parseAt(data, int(start))
+	if err != nil {
+		return nil, nil, fmt.Errorf("fmap at %#x: %w", start, err)
+	}
+	return fmap, &Metadata{Start: start}, nil
+}
+
 // Write overwrites the fmap in the flash file.
 func Write(f io.WriteSeeker, fmap *FMap, m *Metadata) error {
 	if _, err := f.Seek(int64(m.Start), io.SeekStart); err != nil {
@@ -216,14 +314,30 @@ func (f *FMap) IndexOfArea(name string) int {
 	return -1
 }
 
+// SetAreaFlags sets the flags set and clears the flags clear of an area given
+// its index. Use Write to write the changed map to the flash.
+func (f *FMap) SetAreaFlags(i int, set, clear uint16) error {
+	if i < 0 || len(f.Areas) <= i {
+		return fmt.Errorf("area index %d out of range", i)
+	}
+	if set&clear != 0 {
+		return fmt.Errorf("flags %s are both set and cleared", FlagNames(set&clear))
+	}
+	f.Areas[i].Flags = f.Areas[i].Flags&^clear | set
+	return nil
+}
+
 // ReadArea reads an area from the flash image as a byte array given its index.
 func (f *FMap) ReadArea(r io.ReaderAt, i int) ([]byte, error) {
 	if i < 0 || int(f.NAreas) <= i {
 		return nil, fmt.Errorf("area index %d out of range", i)
 	}
 	buf := make([]byte, f.Areas[i].Size)
-	_, err := r.ReadAt(buf, int64(f.Areas[i].Offset))
-	return buf, err
+	if _, err := r.ReadAt(buf, int64(f.Areas[i].Offset)); err != nil {
+		a := f.Areas[i]
+		return nil, fmt.Errorf("reading area %q [%#x, %#x): %w", a.Name.String(), a.Offset, uint64(a.Offset)+uint64(a.Size), err)
+	}
+	return buf, nil
 }
 
 // ReadAreaByName is the same as ReadArea but uses the area's name.
@@ -259,12 +373,52 @@ func (f *FMap) WriteAreaByName(r io.WriterAt, name string, data []byte) error {
 	return f.WriteArea(r, i, data)
 }
 
+// ReplaceArea replaces the content of an area given its index. Unlike
+// WriteArea, data smaller than the area is padded with 0xff, as erased flash.
+func (f *FMap) ReplaceArea(w io.WriterAt, i int, data []byte) error {
+	if i < 0 || int(f.NAreas) <= i {
+		return fmt.Errorf("area index %d out of range", i)
+	}
+	if uint32(len(data)) > f.Areas[i].Size {
+		return fmt.Errorf("data too large for fmap area %q: %#x > %#x",
+			f.Areas[i].Name.String(), len(data), f.Areas[i].Size)
+	}
+	buf := bytes.Repeat([]byte{0xff}, int(f.Areas[i].Size))
+	copy(buf, data)
+	return f.WriteArea(w, i, buf)
+}
+
+// ReplaceAreaByName is the same as ReplaceArea but uses the area's name.
+func (f *FMap) ReplaceAreaByName(w io.WriterAt, name string, data []byte) error {
+	i := f.IndexOfArea(name)
+	if i == -1 {
+		return fmt.Errorf("FMAP area %q not found", name)
+	}
+	return f.ReplaceArea(w, i, data)
+}
+
 // Checksum performs a hash of the static areas.
 func (f *FMap) Checksum(r io.ReaderAt, h hash.Hash) ([]byte, error) {
+	return f.ChecksumAreas(r, h, f.AreasWithFlags(FmapAreaStatic))
+}
+
+// AreasWithFlags returns the indexes of the areas which have all the flags,
+// e.g. FmapAreaReadOnly for the read-only areas.
+func (f *FMap) AreasWithFlags(flags uint16) []int {
+	var indexes []int
 	for i, v := range f.Areas {
-		if v.Flags&FmapAreaStatic == 0 {
-			continue
+		if v.Flags&flags == flags {
+			indexes = append(indexes, i)
 		}
+	}
+	return indexes
+}
+
+// ChecksumAreas performs a hash of the areas given by their indexes, in
+// order. Areas which overlap, such as an area and the areas it contains, are
+// hashed as many times as they are given.
+func (f *FMap) ChecksumAreas(r io.ReaderAt, h hash.Hash, indexes []int) ([]byte, error) {
+	for _, i := range indexes {
 		areaReader, err := f.ReadArea(r, i)
 		if err != nil {
 			return nil, err
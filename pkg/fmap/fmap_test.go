@@ -276,6 +276,60 @@ func TestWriteAreaByName(t *testing.T) {
 	}
 }
 
+func TestReplaceAreaByName(t *testing.T) {
+	fmap := FMap{
+		Header: Header{
+			NAreas: 2,
+		},
+		Areas: []Area{
+			{
+				Offset: 0x0,
+				Size:   0x10,
+			}, {
+				Offset: 0x10,
+				Size:   0x20,
+			},
+		},
+	}
+	copy(fmap.Areas[0].Name.Value[:], []byte("Area 1\x00"))
+	copy(fmap.Areas[1].Name.Value[:], []byte("Area 2\x00"))
+	fakeFlash := bytes.Repeat([]byte{0x53, 0x11, 0x34, 0x22}, 0x10)
+	w := &testBuffer{fakeFlash}
+	data := []byte("AHHHHH!!!!!!!")
+	if err := fmap.ReplaceAreaByName(w, "Area 2", data); err != nil {
+		t.Fatal(err)
+	}
+	expected := append(append([]byte{}, data...), bytes.Repeat([]byte{0xff}, 0x20-len(data))...)
+	if got := fakeFlash[0x10:0x30]; !bytes.Equal(expected, got) {
+		t.Errorf("expected: %v; got: %v", expected, got)
+	}
+	if got := fakeFlash[0x30:0x40]; !bytes.Equal(got, bytes.Repeat([]byte{0x53, 0x11, 0x34, 0x22}, 4)) {
+		t.Errorf("the data after the area changed: %v", got)
+	}
+	if err := fmap.ReplaceAreaByName(w, "Area 1", make([]byte, 0x11)); err == nil {
+		t.Errorf("data too large: got nil, want error")
+	}
+	if err := fmap.ReplaceAreaByName(w, "Area 3", data); err == nil {
+		t.Errorf("missing area: got nil, want error")
+	}
+}
+
+func TestSetAreaFlags(t *testing.T) {
+	fmap := FMap{Areas: []Area{{Flags: FmapAreaStatic | 0x100}}}
+	if err := fmap.SetAreaFlags(0, FmapAreaReadOnly, FmapAreaStatic); err != nil {
+		t.Fatal(err)
+	}
+	if want := uint16(FmapAreaReadOnly | 0x100); fmap.Areas[0].Flags != want {
+		t.Errorf("got flags %s, want %s", FlagNames(fmap.Areas[0].Flags), FlagNames(want))
+	}
+	if err := fmap.SetAreaFlags(0, FmapAreaStatic, FmapAreaStatic); err == nil {
+		t.Errorf("setting and clearing a flag: got nil, want error")
+	}
+	if err := fmap.SetAreaFlags(1, FmapAreaStatic, 0); err == nil {
+		t.Errorf("area out of range: got nil, want error")
+	}
+}
+
 func TestChecksum(t *testing.T) {
 	fmap := FMap{
 		Header: Header{
@@ -310,3 +364,50 @@ func TestChecksum(t *testing.T) {
 		t.Errorf("want: %v; got: %v", want, got)
 	}
 }
+
+func TestChecksumAreas(t *testing.T) {
+	fmap := FMap{
+		Header: Header{
+			NAreas: 3,
+		},
+		Areas: []Area{
+			{
+				Offset: 0x00,
+				Size:   0x03,
+				Flags:  FmapAreaStatic | FmapAreaReadOnly,
+			}, {
+				Offset: 0x03,
+				Size:   0x04,
+				Flags:  FmapAreaReadOnly,
+			}, {
+				Offset: 0x23,
+				Size:   0x04,
+				Flags:  FmapAreaStatic,
+			},
+		},
+	}
+	if got, want := fmap.AreasWithFlags(FmapAreaReadOnly), []int{0, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got read-only areas %v, want %v", got, want)
+	}
+	r := bytes.NewReader(bytes.Repeat([]byte("abcd"), 0x70))
+	for _, tt := range []struct {
+		indexes []int
+		want    string
+	}{
+		// $ echo -n abcdabc | sha256sum
+		{fmap.AreasWithFlags(FmapAreaReadOnly), "8a50a4422d673f463f8e4141d8c4b68c4f001ba16f83ad77b8a31bde53ee7273"},
+		// $ echo -n dabcabc | sha256sum
+		{[]int{1, 0}, "924e4c5254001aa4b55ad7cda8676140d103cc5e2ae6767d53a6e7f2264849b2"},
+	} {
+		checksum, err := fmap.ChecksumAreas(r, sha256.New(), tt.indexes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprintf("%x", checksum); got != tt.want {
+			t.Errorf("areas %v: want: %v; got: %v", tt.indexes, tt.want, got)
+		}
+	}
+	if _, err := fmap.ChecksumAreas(r, sha256.New(), []int{3}); err == nil {
+		t.Errorf("area out of range: got nil, want error")
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fmap
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// fmdPreserve is the PRESERVE flag of coreboot, for the areas kept across
+// updates.
+const fmdPreserve = 1 << 3
+
+// WriteFMD writes the flash map as a coreboot flashmap descriptor (.fmd):
+// the flash, then each area nested in the smallest area containing it, see
+// Tree, as
+//
+//	NAME(FLAGS)@OFFSET SIZE {
+//		...
+//	}
+//
+// The offset of the flash is its base, that of an area is relative to its
+// parent. The flags are as printed by FlagNames, and omitted if there are
+// none. coreboot itself only knows the CBFS and PRESERVE annotations.
+func (f *FMap) WriteFMD(w io.Writer) error {
+	var b strings.Builder
+	var walk func(nodes []*AreaNode, parent uint32, depth int)
+	walk = func(nodes []*AreaNode, parent uint32, depth int) {
+		for _, n := range nodes {
+			indent := strings.Repeat("\t", depth)
+			fmt.Fprintf(&b, "%s%s", indent, n.Name.String())
+			if n.Flags != 0 {
+				fmt.Fprintf(&b, "(%s)", FlagNames(n.Flags))
+			}
+			fmt.Fprintf(&b, "@%#x %#x", n.Offset-parent, n.Size)
+			if len(n.Children) == 0 {
+				b.WriteString("\n")
+				continue
+			}
+			b.WriteString(" {\n")
+			walk(n.Children, n.Offset, depth+1)
+			fmt.Fprintf(&b, "%s}\n", indent)
+		}
+	}
+	fmt.Fprintf(&b, "%s@%#x %#x {\n", f.Name.String(), f.Base, f.Size)
+	walk(f.Tree(), 0, 1)
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// fmdTokens splits an fmd description into names, numbers and the
+// punctuation "@(){}", dropping the comments, which start with "#".
+func fmdTokens(s string) []string {
+	var toks []string
+	var cur strings.Builder
+	flush := func() {
+		if cur.Len() > 0 {
+			toks = append(toks, cur.String())
+			cur.Reset()
+		}
+	}
+	for _, line := range strings.Split(s, "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		for _, r := range line {
+			switch {
+			case strings.ContainsRune("@(){}", r):
+				flush()
+				toks = append(toks, string(r))
+			case r == ' ' || r == '\t' || r == '\r':
+				flush()
+			default:
+				cur.WriteRune(r)
+			}
+		}
+		flush()
+	}
+	return toks
+}
+
+// parseFMDNumber parses a number of an fmd description, which may have a K,
+// M or G suffix.
+func parseFMDNumber(s string) (uint64, error) {
+	var shift uint
+	switch {
+	case strings.HasSuffix(s, "K"):
+		shift = 10
+	case strings.HasSuffix(s, "M"):
+		shift = 20
+	case strings.HasSuffix(s, "G"):
+		shift = 30
+	}
+	if shift != 0 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseUint(s, 0, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n > (1<<64-1)>>shift {
+		return 0, fmt.Errorf("%s is out of range", s)
+	}
+	return n << shift, nil
+}
+
+// fmdRegion is a region of an fmd description, at an offset relative to its
+// parent.
+type fmdRegion struct {
+	name         string
+	flags        uint16
+	offset, size uint64
+	children     []*fmdRegion
+}
+
+// fmdParser parses the tokens of an fmd description.
+type fmdParser struct {
+	toks []string
+}
+
+// next returns the next token, or "" at the end.
+func (p *fmdParser) next() string {
+	t := p.peek()
+	if t != "" {
+		p.toks = p.toks[1:]
+	}
+	return t
+}
+
+// peek returns the next token without consuming it, or "" at the end.
+func (p *fmdParser) peek() string {
+	if len(p.toks) == 0 {
+		return ""
+	}
+	return p.toks[0]
+}
+
+// region parses a region and its children.
+func (p *fmdParser) region() (*fmdRegion, error) {
+	r := &fmdRegion{name: p.next()}
+	if r.name == "" || strings.ContainsAny(r.name, "@(){}") {
+		return nil, fmt.Errorf("want a region name, got %q", r.name)
+	}
+	if p.peek() == "(" {
+		p.next()
+		for t := p.next(); t != ")"; t = p.next() {
+			if t == "" {
+				return nil, fmt.Errorf("%s: unterminated flags", r.name)
+			}
+			for _, s := range strings.Split(t, "|") {
+				switch s {
+				case "", "CBFS":
+				case "PRESERVE":
+					r.flags |= fmdPreserve
+				default:
+					f, err := ParseFlags(s)
+					if err != nil {
+						return nil, fmt.Errorf("%s: %w", r.name, err)
+					}
+					r.flags |= f
+				}
+			}
+		}
+	}
+	if t := p.next(); t != "@" {
+		return nil, fmt.Errorf("%s: want @OFFSET, got %q", r.name, t)
+	}
+	var err error
+	if r.offset, err = parseFMDNumber(p.next()); err != nil {
+		return nil, fmt.Errorf("%s: offset: %w", r.name, err)
+	}
+	if r.size, err = parseFMDNumber(p.next()); err != nil {
+		return nil, fmt.Errorf("%s: size: %w", r.name, err)
+	}
+	if p.peek() != "{" {
+		return r, nil
+	}
+	p.next()
+	for p.peek() != "}" {
+		if p.peek() == "" {
+			return nil, fmt.Errorf("%s: unterminated region", r.name)
+		}
+		c, err := p.region()
+		if err != nil {
+			return nil, err
+		}
+		r.children = append(r.children, c)
+	}
+	p.next()
+	return r, nil
+}
+
+// fmdAreas appends the areas of the regions, whose parent starts at start, to
+// areas, parents first.
+func fmdAreas(areas []Area, regions []*fmdRegion, start uint64) ([]Area, error) {
+	for _, r := range regions {
+		off := start + r.offset
+		if off+r.size > 1<<32 {
+			return nil, fmt.Errorf("%s: [%#x, %#x) does not fit in 32 bits", r.name, off, off+r.size)
+		}
+		name, err := NewString(r.name)
+		if err != nil {
+			return nil, err
+		}
+		areas = append(areas, Area{Offset: uint32(off), Size: uint32(r.size), Name: name, Flags: r.flags})
+		if areas, err = fmdAreas(areas, r.children, off); err != nil {
+			return nil, err
+		}
+	}
+	return areas, nil
+}
+
+// ParseFMD parses a flash map from a coreboot flashmap descriptor (.fmd), as
+// written by WriteFMD. The offset and size of each region must be given;
+// numbers are decimal, or hexadecimal with a 0x prefix, with an optional K,
+// M or G suffix. The CBFS annotation is ignored, and PRESERVE is the flag
+// 0x8 of coreboot.
+func ParseFMD(r io.Reader) (*FMap, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &fmdParser{toks: fmdTokens(string(b))}
+	flash, err := p.region()
+	if err != nil {
+		return nil, fmt.Errorf("fmd: %w", err)
+	}
+	if t := p.peek(); t != "" {
+		return nil, fmt.Errorf("fmd: unexpected %q after the flash", t)
+	}
+	if flash.size > 1<<32-1 {
+		return nil, fmt.Errorf("fmd: flash size %#x does not fit in 32 bits", flash.size)
+	}
+	// The offset of the flash is its base, the areas are relative to it.
+	areas, err := fmdAreas(nil, flash.children, 0)
+	if err != nil {
+		return nil, fmt.Errorf("fmd: %w", err)
+	}
+	return New(flash.name, flash.offset, uint32(flash.size), areas)
+}
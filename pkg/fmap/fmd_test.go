@@ -0,0 +1,100 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fmap
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteFMD(t *testing.T) {
+	f, err := ParseLayout(strings.NewReader(testLayout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Areas[4].Flags = FmapAreaCompressed | 0x100
+	var b strings.Builder
+	if err := f.WriteFMD(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `FLASH@0xff000000 0x10000 {
+	SI_BIOS@0x0 0x10000 {
+		RO_SECTION(STATIC|READ_ONLY)@0x0 0x8000 {
+			FMAP@0x0 0x400
+			COREBOOT(READ_ONLY)@0x400 0x7c00
+		}
+		RW_LEGACY(COMPRESSED|0x100)@0x8000 0x8000
+	}
+}
+`
+	if got := b.String(); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	g, err := ParseFMD(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(g, f) {
+		t.Errorf("round trip: got %+v, want %+v", g, f)
+	}
+}
+
+func TestParseFMD(t *testing.T) {
+	// As in the coreboot tree, with relative offsets, suffixes, comments
+	// and annotations.
+	f, err := ParseFMD(strings.NewReader(`# A coreboot layout.
+FLASH@0xff800000 8M {
+	SI_ALL@0 2M
+	SI_BIOS@2M 6M {
+		RW_MRC_CACHE(PRESERVE)@0 64K
+		FMAP@64K 0x800
+		COREBOOT(CBFS)@0x10800 0x5ef800 # the rest
+	}
+}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name.String() != "FLASH" || f.Base != 0xff800000 || f.Size != 8<<20 {
+		t.Errorf("got header %+v", f.Header)
+	}
+	want := []struct {
+		name         string
+		offset, size uint32
+		flags        uint16
+	}{
+		{"SI_ALL", 0, 2 << 20, 0},
+		{"SI_BIOS", 2 << 20, 6 << 20, 0},
+		{"RW_MRC_CACHE", 2 << 20, 64 << 10, fmdPreserve},
+		{"FMAP", 2<<20 + 64<<10, 0x800, 0},
+		{"COREBOOT", 2<<20 + 0x10800, 0x5ef800, 0},
+	}
+	if len(f.Areas) != len(want) {
+		t.Fatalf("got %d areas, want %d", len(f.Areas), len(want))
+	}
+	for i, w := range want {
+		a := f.Areas[i]
+		if a.Name.String() != w.name || a.Offset != w.offset || a.Size != w.size || a.Flags != w.flags {
+			t.Errorf("area %d: got %s at %#x of %#x bytes, flags %#x, want %s at %#x of %#x bytes, flags %#x",
+				i, a.Name.String(), a.Offset, a.Size, a.Flags, w.name, w.offset, w.size, w.flags)
+		}
+	}
+
+	for _, bad := range []string{
+		"",
+		"FLASH 0x1000",
+		"FLASH@0 0x1000 {",
+		"FLASH@0 0x1000 { A@0 }",
+		"FLASH@0 0x1000 { A(UNKNOWN)@0 0x10 }",
+		"FLASH@0 0x1000 { A@0 0x2000 }",
+		"FLASH@0 0x1000 {} B@0 0x10",
+	} {
+		if _, err := ParseFMD(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q: got nil, want error", bad)
+		}
+	}
+}
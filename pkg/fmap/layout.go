@@ -0,0 +1,192 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// AreaName is the name of the area holding the flash map itself.
+const AreaName = "FMAP"
+
+// NewString returns s as a String. It fails if s does not fit with its NUL
+// terminator.
+func NewString(s string) (String, error) {
+	var str String
+	if len(s) >= len(str.Value) {
+		return str, fmt.Errorf("name %q is longer than %d bytes", s, len(str.Value)-1)
+	}
+	copy(str.Value[:], s)
+	return str, nil
+}
+
+// ParseFlags is the reverse of FlagNames: it returns the flags named in s,
+// separated by '|', each a name or a number.
+func ParseFlags(s string) (uint16, error) {
+	var flags uint16
+	for _, name := range strings.Split(s, "|") {
+		switch strings.ToUpper(strings.TrimSpace(name)) {
+		case "STATIC":
+			flags |= FmapAreaStatic
+		case "COMPRESSED":
+			flags |= FmapAreaCompressed
+		case "READ_ONLY", "RO":
+			flags |= FmapAreaReadOnly
+		default:
+			n, err := strconv.ParseUint(strings.TrimSpace(name), 0, 16)
+			if err != nil {
+				return 0, fmt.Errorf("unknown flag %q", name)
+			}
+			flags |= uint16(n)
+		}
+	}
+	return flags, nil
+}
+
+// New returns the flash map of a flash named name, of size bytes, mapped at
+// base, with the areas. The areas must be in the flash and may nest.
+func New(name string, base uint64, size uint32, areas []Area) (*FMap, error) {
+	n, err := NewString(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(areas) > 0xffff {
+		return nil, fmt.Errorf("too many areas: %d", len(areas))
+	}
+	f := &FMap{
+		Header: Header{
+			VerMajor: 1,
+			VerMinor: 1,
+			Base:     base,
+			Size:     size,
+			Name:     n,
+			NAreas:   uint16(len(areas)),
+		},
+		Areas: areas,
+	}
+	copy(f.Signature[:], Signature)
+	if !headerValid(&f.Header) {
+		return nil, fmt.Errorf("invalid flash map header: %+v", f.Header)
+	}
+	for i, a := range areas {
+		if uint64(a.Offset)+uint64(a.Size) > uint64(size) {
+			return nil, fmt.Errorf("area %d %q [%#x, %#x) is out of the flash of %#x bytes", i, a.Name.String(), a.Offset, uint64(a.Offset)+uint64(a.Size), size)
+		}
+		if !bytes.Contains(a.Name.Value[:], []byte("\x00")) {
+			return nil, fmt.Errorf("area %d: name %q is not NUL terminated", i, a.Name.String())
+		}
+	}
+	if i := f.IndexOfArea(AreaName); i != -1 && f.Areas[i].Size < uint32(f.Len()) {
+		return nil, fmt.Errorf("the %s area of %#x bytes is too small for the flash map of %#x bytes", AreaName, f.Areas[i].Size, f.Len())
+	}
+	return f, nil
+}
+
+// Len returns the size of the flash map once written.
+func (f *FMap) Len() int {
+	return binary.Size(f.Header) + len(f.Areas)*binary.Size(Area{})
+}
+
+// ParseLayout reads the description of a new flash map. Empty lines and
+// lines starting with '#' are skipped. The flash is described by a line
+//
+//	FLASH name base size
+//
+// and each area, in order, by a line
+//
+//	name offset size [flags]
+//
+// where flags are as printed by FlagNames, e.g. STATIC|READ_ONLY. Numbers
+// are decimal, or hexadecimal with a 0x prefix.
+func ParseLayout(r io.Reader) (*FMap, error) {
+	var (
+		name  string
+		base  uint64
+		size  uint64
+		flash bool
+		areas []Area
+	)
+	s := bufio.NewScanner(r)
+	for l := 1; s.Scan(); l++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		var err error
+		if fields[0] == "FLASH" {
+			if flash {
+				return nil, fmt.Errorf("line %d: the flash is described twice", l)
+			}
+			if len(fields) != 4 {
+				return nil, fmt.Errorf("line %d: want FLASH name base size, got %q", l, s.Text())
+			}
+			flash, name = true, fields[1]
+			if base, err = strconv.ParseUint(fields[2], 0, 64); err != nil {
+				return nil, fmt.Errorf("line %d: base: %w", l, err)
+			}
+			if size, err = strconv.ParseUint(fields[3], 0, 32); err != nil {
+				return nil, fmt.Errorf("line %d: size: %w", l, err)
+			}
+			continue
+		}
+		if len(fields) != 3 && len(fields) != 4 {
+			return nil, fmt.Errorf("line %d: want name offset size [flags], got %q", l, s.Text())
+		}
+		var a Area
+		if a.Name, err = NewString(fields[0]); err != nil {
+			return nil, fmt.Errorf("line %d: %w", l, err)
+		}
+		off, err := strconv.ParseUint(fields[1], 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: offset: %w", l, err)
+		}
+		sz, err := strconv.ParseUint(fields[2], 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: size: %w", l, err)
+		}
+		a.Offset, a.Size = uint32(off), uint32(sz)
+		if len(fields) == 4 {
+			if a.Flags, err = ParseFlags(fields[3]); err != nil {
+				return nil, fmt.Errorf("line %d: %w", l, err)
+			}
+		}
+		areas = append(areas, a)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if !flash {
+		return nil, fmt.Errorf("no FLASH line in the layout")
+	}
+	return New(name, base, uint32(size), areas)
+}
+
+// NewFlash returns an erased flash image of the size of the map, holding the
+// map at the start of the FMAP area, or at 0 if there is none.
+func (f *FMap) NewFlash() ([]byte, *Metadata, error) {
+	m := &Metadata{}
+	if i := f.IndexOfArea(AreaName); i != -1 {
+		m.Start = uint64(f.Areas[i].Offset)
+	}
+	if m.Start+uint64(f.Len()) > uint64(f.Size) {
+		return nil, nil, fmt.Errorf("the flash map at %#x does not fit in the flash of %#x bytes", m.Start, f.Size)
+	}
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.LittleEndian, f.Header); err != nil {
+		return nil, nil, err
+	}
+	if err := binary.Write(&b, binary.LittleEndian, f.Areas); err != nil {
+		return nil, nil, err
+	}
+	flash := bytes.Repeat([]byte{0xff}, int(f.Size))
+	copy(flash[m.Start:], b.Bytes())
+	return flash, m, nil
+}
@@ -0,0 +1,154 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fmap
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testLayout = `# A small coreboot-like layout.
+FLASH FLASH 0xff000000 0x10000
+
+SI_BIOS   0x0    0x10000
+RO_SECTION 0x0   0x8000 READ_ONLY|STATIC
+FMAP      0x0    0x400
+COREBOOT  0x400  0x7c00 RO
+RW_LEGACY 0x8000 0x8000 0
+`
+
+func mustString(t *testing.T, s string) String {
+	t.Helper()
+	str, err := NewString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return str
+}
+
+func TestParseLayout(t *testing.T) {
+	f, err := ParseLayout(strings.NewReader(testLayout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name.String() != "FLASH" || f.Base != 0xff000000 || f.Size != 0x10000 || f.NAreas != 5 {
+		t.Errorf("got header %+v", f.Header)
+	}
+	want := []Area{
+		{Offset: 0, Size: 0x10000, Name: mustString(t, "SI_BIOS")},
+		{Offset: 0, Size: 0x8000, Name: mustString(t, "RO_SECTION"), Flags: FmapAreaReadOnly | FmapAreaStatic},
+		{Offset: 0, Size: 0x400, Name: mustString(t, "FMAP")},
+		{Offset: 0x400, Size: 0x7c00, Name: mustString(t, "COREBOOT"), Flags: FmapAreaReadOnly},
+		{Offset: 0x8000, Size: 0x8000, Name: mustString(t, "RW_LEGACY")},
+	}
+	if !reflect.DeepEqual(f.Areas, want) {
+		t.Errorf("got areas %+v, want %+v", f.Areas, want)
+	}
+
+	flash, m, err := f.NewFlash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flash) != 0x10000 || flash[len(flash)-1] != 0xff {
+		t.Errorf("got a flash of %#x bytes ending with %#x, want 0x10000 erased bytes", len(flash), flash[len(flash)-1])
+	}
+	got, gotM, err := Read(bytes.NewReader(flash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, f) || !reflect.DeepEqual(gotM, m) {
+		t.Errorf("got %+v at %+v, want %+v at %+v", got, gotM, f, m)
+	}
+}
+
+func TestParseLayoutErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name, layout, err string
+	}{
+		{"no flash", "A 0 0x10\n", "no FLASH line"},
+		{"twice", "FLASH F 0 0x100\nFLASH F 0 0x100\n", "described twice"},
+		{"out of the flash", "FLASH F 0 0x100\nA 0x80 0x100\n", "out of the flash"},
+		{"bad flag", "FLASH F 0 0x100\nA 0 0x10 WRITABLE\n", "unknown flag"},
+		{"long name", "FLASH F 0 0x100\n" + strings.Repeat("A", 32) + " 0 0x10\n", "longer than 31 bytes"},
+		{"small FMAP", "FLASH F 0 0x100\nFMAP 0 0x10\n", "too small"},
+		{"fields", "FLASH F 0 0x100\nA 0\n", "want name offset size"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseLayout(strings.NewReader(tt.layout))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	for _, flags := range []uint16{0, FmapAreaStatic, FmapAreaStatic | FmapAreaReadOnly, FmapAreaCompressed | 0x100} {
+		got, err := ParseFlags(FlagNames(flags))
+		if err != nil || got != flags {
+			t.Errorf("ParseFlags(%q) = %#x, %v, want %#x", FlagNames(flags), got, err, flags)
+		}
+	}
+}
+
+func TestFindAll(t *testing.T) {
+	f, err := ParseLayout(strings.NewReader(testLayout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flash, _, err := f.NewFlash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A stale copy of the map, a nested image with its own map and a bare
+	// signature.
+	copy(flash[0x8000:], flash[:0x400])
+	nested, err := New("NESTED", 0, 0x1000, []Area{{Offset: 0, Size: 0x1000, Name: mustString(t, "NESTED")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, _, err := nested.NewFlash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(flash[0x9000:], n[:0x100])
+	copy(flash[0xa000:], Signature)
+
+	if _, _, err := Read(bytes.NewReader(flash)); err != errMultipleFound {
+		t.Errorf("Read: got %v, want %v", err, errMultipleFound)
+	}
+	c, err := FindAll(bytes.NewReader(flash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var starts, scores []int
+	for _, cand := range c {
+		starts, scores = append(starts, int(cand.Start)), append(scores, cand.Score)
+	}
+	if want := []int{0, 0x8000, 0x9000, 0xa000}; !reflect.DeepEqual(starts, want) {
+		t.Errorf("got candidates at %#x, want %#x", starts, want)
+	}
+	if want := []int{7, 5, 3, 0}; !reflect.DeepEqual(scores, want) {
+		t.Errorf("got scores %d, want %d", scores, want)
+	}
+	if c[3].FMap != nil || c[3].Err == nil {
+		t.Errorf("got %+v for a bare signature, want an error", c[3])
+	}
+
+	got, m, err := ReadAt(bytes.NewReader(flash), 0x9000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, nested) || m.Start != 0x9000 {
+		t.Errorf("got %+v at %#x, want %+v at 0x9000", got, m.Start, nested)
+	}
+	for _, start := range []uint64{0x100, 0xa000, 0x20000} {
+		if _, _, err := ReadAt(bytes.NewReader(flash), start); err == nil {
+			t.Errorf("ReadAt(%#x): got nil, want error", start)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fmap
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// AreaNode is an area with the areas it contains.
+type AreaNode struct {
+	Area
+	// Index is the index of the area in FMap.Areas.
+	Index    int
+	Children []*AreaNode
+}
+
+// end returns the end of the area.
+func (a *Area) end() uint64 {
+	return uint64(a.Offset) + uint64(a.Size)
+}
+
+// contains tells whether b is within a.
+func (a *Area) contains(b *Area) bool {
+	return b.Offset >= a.Offset && b.end() <= a.end()
+}
+
+// Tree reconstructs the hierarchy of the areas from their offsets and sizes,
+// e.g. RW_SECTION_A containing VBLOCK_A and FW_MAIN_A. An area is the child
+// of the smallest area containing it; of two identical areas, the first one
+// in the map is the parent. Areas which overlap without containing each
+// other are siblings. The nodes are sorted by offset.
+func (f *FMap) Tree() []*AreaNode {
+	nodes := make([]*AreaNode, len(f.Areas))
+	for i, a := range f.Areas {
+		nodes[i] = &AreaNode{Area: a, Index: i}
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		if nodes[i].Offset != nodes[j].Offset {
+			return nodes[i].Offset < nodes[j].Offset
+		}
+		return nodes[i].Size > nodes[j].Size
+	})
+
+	var roots, stack []*AreaNode
+	for _, n := range nodes {
+		for len(stack) > 0 && !stack[len(stack)-1].contains(&n.Area) {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			roots = append(roots, n)
+		} else {
+			p := stack[len(stack)-1]
+			p.Children = append(p.Children, n)
+		}
+		stack = append(stack, n)
+	}
+	return roots
+}
+
+// WriteTree writes the hierarchy of the areas as an indented tree, one area
+// per line with its offset, size and flags.
+func (f *FMap) WriteTree(w io.Writer) error {
+	var b strings.Builder
+	var walk func(nodes []*AreaNode, depth int)
+	walk = func(nodes []*AreaNode, depth int) {
+		for _, n := range nodes {
+			name := strings.Repeat("  ", depth) + n.Name.String()
+			fmt.Fprintf(&b, "%-40s 0x%08x 0x%08x %s\n", name, n.Offset, n.Size, FlagNames(n.Flags))
+			walk(n.Children, depth+1)
+		}
+	}
+	walk(f.Tree(), 0)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
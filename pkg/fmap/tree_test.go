@@ -0,0 +1,52 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fmap
+
+import (
+	"strings"
+	"testing"
+)
+
+const testVbootLayout = `FLASH FLASH 0 0x20000
+RW_SECTION_A 0x10000 0x8000
+VBLOCK_A     0x10000 0x1000
+FW_MAIN_A    0x11000 0x7000
+SI_BIOS      0x0     0x20000
+RO_SECTION   0x0     0x10000 READ_ONLY
+FMAP         0x0     0x800 READ_ONLY
+COREBOOT     0x800   0xf800 READ_ONLY
+RW_SECTION_B 0x18000 0x8000
+ALIAS_B      0x18000 0x8000
+OVERLAP      0xf000  0x2000
+`
+
+func TestTree(t *testing.T) {
+	f, err := ParseLayout(strings.NewReader(testVbootLayout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := f.Tree()
+	if len(roots) != 1 || roots[0].Name.String() != "SI_BIOS" || roots[0].Index != 3 {
+		t.Fatalf("got roots %+v, want SI_BIOS", roots)
+	}
+	var b strings.Builder
+	if err := f.WriteTree(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `SI_BIOS                                  0x00000000 0x00020000 0x0
+  RO_SECTION                             0x00000000 0x00010000 READ_ONLY
+    FMAP                                 0x00000000 0x00000800 READ_ONLY
+    COREBOOT                             0x00000800 0x0000f800 READ_ONLY
+  OVERLAP                                0x0000f000 0x00002000 0x0
+  RW_SECTION_A                           0x00010000 0x00008000 0x0
+    VBLOCK_A                             0x00010000 0x00001000 0x0
+    FW_MAIN_A                            0x00011000 0x00007000 0x0
+  RW_SECTION_B                           0x00018000 0x00008000 0x0
+    ALIAS_B                              0x00018000 0x00008000 0x0
+`
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
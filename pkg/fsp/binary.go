@@ -0,0 +1,230 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// HeaderFileGUID is the GUID of the FFS file holding the FSP info header, the
+// first file of the first firmware volume of each FSP component.
+var HeaderFileGUID = *guid.MustParse("912740BE-2284-4734-B971-84B027353F0C")
+
+// ffsChecksumOffset is the offset of the data checksum in an FFS file header,
+// from the UEFI PI specification volume 3.
+const ffsChecksumOffset = 0x11
+
+// ffsFile is an FFS file of a firmware volume, at Offset in the binary.
+type ffsFile struct {
+	*uefi.File
+	Offset uint64
+}
+
+// section is an FFS section of a file, at Offset in the binary.
+type section struct {
+	*uefi.Section
+	Offset uint64
+}
+
+// size returns the size of the file.
+func (f ffsFile) size() uint64 {
+	return f.Header.ExtendedSize
+}
+
+// headerLen returns the length of the header of the section.
+func (s section) headerLen() uint64 {
+	if s.Header.Size == [3]uint8{0xFF, 0xFF, 0xFF} {
+		return 8
+	}
+	return 4
+}
+
+// data returns the data of the section in b.
+func (s section) data(b []byte) []byte {
+	return b[s.Offset+s.headerLen() : s.Offset+uint64(s.Header.ExtendedSize)]
+}
+
+// parseFV parses the firmware volume at off of b.
+func parseFV(b []byte, off uint64) (*uefi.FirmwareVolume, error) {
+	if off >= uint64(len(b)) || uefi.FindFirmwareVolumeOffset(b[off:]) != 0 {
+		return nil, fmt.Errorf("no firmware volume at %#x", off)
+	}
+	fv, err := uefi.NewFirmwareVolume(b[off:], off, false)
+	if err != nil {
+		return nil, fmt.Errorf("firmware volume at %#x: %w", off, err)
+	}
+	return fv, nil
+}
+
+// fvFiles returns the FFS files of the firmware volume at off of b.
+func fvFiles(b []byte, off uint64) ([]ffsFile, error) {
+	fv, err := parseFV(b, off)
+	if err != nil {
+		return nil, err
+	}
+	return files(fv), nil
+}
+
+// files returns the FFS files of fv, with their offsets in the binary.
+func files(fv *uefi.FirmwareVolume) []ffsFile {
+	var files []ffsFile
+	rel := fv.DataOffset
+	for _, f := range fv.Files {
+		rel = uefi.Align8(rel)
+		files = append(files, ffsFile{File: f, Offset: fv.FVOffset + rel})
+		rel += f.Header.ExtendedSize
+	}
+	return files
+}
+
+// fixChecksum updates the data checksum of the file f of b, if it has one.
+func (f ffsFile) fixChecksum(b []byte) {
+	if f.Header.Attributes.HasChecksum() {
+		b[f.Offset+ffsChecksumOffset] = 0 - uefi.Checksum8(b[f.Offset+f.DataOffset:f.Offset+f.size()])
+	}
+}
+
+// sections returns the sections of the file f. Only the files of the types
+// holding sections have some.
+func (f ffsFile) sections() []section {
+	var secs []section
+	rel := f.DataOffset
+	for _, s := range f.Sections {
+		rel = uefi.Align4(rel)
+		secs = append(secs, section{Section: s, Offset: f.Offset + rel})
+		rel += uint64(s.Header.ExtendedSize)
+	}
+	return secs
+}
+
+// Component is a component of an FSP binary, e.g. FSP-M: the firmware volumes
+// from the one holding its info header up to ImageSize bytes.
+type Component struct {
+	// Offset is the offset of the component in the binary.
+	Offset uint64
+	// HeaderOffset is the offset of the FSP info header in the binary.
+	HeaderOffset uint64
+	Header       *CommonInfoHeader
+	// FVs are the offsets of the firmware volumes of the component in the
+	// binary.
+	FVs []uint64
+}
+
+// Type returns the type of the component, e.g. TypeM.
+func (c *Component) Type() Type {
+	return c.Header.ComponentAttribute.Type()
+}
+
+// MarshalJSON adds the type of the component.
+func (c *Component) MarshalJSON() ([]byte, error) {
+	type component Component
+	return json.Marshal(struct {
+		Type string
+		*component
+	}{c.Type().String(), (*component)(c)})
+}
+
+// ComponentOfType returns the component of type t, or nil if there is none.
+func ComponentOfType(comps []*Component, t Type) *Component {
+	for _, c := range comps {
+		if c.Type() == t {
+			return c
+		}
+	}
+	return nil
+}
+
+// fileAt returns the file of the component holding the size bytes at off
+// of b.
+func (c *Component) fileAt(b []byte, off, size uint64) (ffsFile, error) {
+	for _, fv := range c.FVs {
+		files, err := fvFiles(b, fv)
+		if err != nil {
+			return ffsFile{}, err
+		}
+		for _, f := range files {
+			if off >= f.Offset+f.DataOffset && off+size <= f.Offset+f.size() {
+				return f, nil
+			}
+		}
+	}
+	return ffsFile{}, fmt.Errorf("%v: no file holds [%#x, %#x)", c.Type(), off, off+size)
+}
+
+// headerOffset returns the offset of the FSP info header in the files of a
+// firmware volume, or false if its first file is not the FSP header file.
+func headerOffset(b []byte, files []ffsFile) (uint64, bool, error) {
+	if len(files) == 0 || files[0].Header.GUID != HeaderFileGUID {
+		return 0, false, nil
+	}
+	f := files[0]
+	// The info header is in a raw section, of a raw file whose sections
+	// uefi does not parse, or directly in the data of the file for some
+	// binaries.
+	p, end := f.Offset+f.DataOffset, f.Offset+f.size()
+	secs := f.sections()
+	if len(secs) == 0 {
+		if s, err := uefi.NewSection(b[p:end], 0); err == nil {
+			secs = []section{{Section: s, Offset: p}}
+		}
+	}
+	if len(secs) > 0 && secs[0].Header.Type == uefi.SectionTypeRaw {
+		p = secs[0].Offset + secs[0].headerLen()
+	}
+	if p+4 > end || !bytes.Equal(b[p:p+4], Signature[:]) {
+		return 0, false, fmt.Errorf("FSP header file at %#x holds no FSP info header", f.Offset)
+	}
+	return p, true, nil
+}
+
+// ParseComponents returns the components of an FSP binary, a sequence of
+// firmware volumes.
+func ParseComponents(b []byte) ([]*Component, error) {
+	var comps []*Component
+	var c *Component
+	// ends are the ends of the last firmware volumes of the components.
+	var ends []uint64
+	for off := uint64(0); off < uint64(len(b)); {
+		fv, err := parseFV(b, off)
+		if err != nil {
+			return nil, err
+		}
+		h, ok, err := headerOffset(b, files(fv))
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case ok:
+			hdr, err := NewInfoHeader(b[h:])
+			if err != nil {
+				return nil, fmt.Errorf("component at %#x: %w", off, err)
+			}
+			if off+uint64(hdr.ImageSize) > uint64(len(b)) {
+				return nil, fmt.Errorf("component at %#x of %#x bytes is out of the %#x bytes of the binary", off, hdr.ImageSize, len(b))
+			}
+			c = &Component{Offset: off, HeaderOffset: h, Header: hdr}
+			comps, ends = append(comps, c), append(ends, 0)
+		case c == nil || off+fv.Length > c.Offset+uint64(c.Header.ImageSize):
+			return nil, fmt.Errorf("firmware volume at %#x is in no FSP component", off)
+		}
+		c.FVs = append(c.FVs, off)
+		off += fv.Length
+		ends[len(ends)-1] = off
+	}
+	if len(comps) == 0 {
+		return nil, fmt.Errorf("no FSP component found")
+	}
+	for i, c := range comps {
+		if ends[i] != c.Offset+uint64(c.Header.ImageSize) {
+			return nil, fmt.Errorf("%v at %#x: its firmware volumes cover %#x bytes, its header says %#x", c.Type(), c.Offset, ends[i]-c.Offset, c.Header.ImageSize)
+		}
+	}
+	return comps, nil
+}
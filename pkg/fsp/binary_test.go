@@ -0,0 +1,91 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// newTestHeaderRev7 returns an FSP 2.4 info header of a component of size
+// bytes based at base.
+func newTestHeaderRev7(spec SpecVersion, typ Type, size, base uint32) []byte {
+	h := append([]byte{}, FSPTestHeaderRev6...)
+	binary.LittleEndian.PutUint32(h[4:], HeaderV7Length)
+	h[10], h[11] = byte(spec), 7
+	binary.LittleEndian.PutUint32(h[24:], size)
+	binary.LittleEndian.PutUint32(h[28:], base)
+	binary.LittleEndian.PutUint16(h[32:], 0x4)
+	binary.LittleEndian.PutUint16(h[34:], uint16(typ)<<12|0x3)
+	binary.LittleEndian.PutUint32(h[36:], 0)
+	binary.LittleEndian.PutUint32(h[40:], 0)
+	h = binary.LittleEndian.AppendUint32(h, 0x100)
+	return binary.LittleEndian.AppendUint32(h, 0x200)
+}
+
+// newTestFV returns an FFS2 firmware volume of size bytes holding a raw file
+// with GUID g whose raw section holds data.
+func newTestFV(size int, g [16]byte, data []byte) []byte {
+	const headerLen = 0x48
+	fv := bytes.Repeat([]byte{0xff}, size)
+	copy(fv, make([]byte, headerLen))
+	copy(fv[0x10:], uefi.FFS2[:])
+	binary.LittleEndian.PutUint64(fv[0x20:], uint64(size))
+	copy(fv[0x28:], "_FVH")
+	binary.LittleEndian.PutUint32(fv[0x2c:], 0x800)
+	binary.LittleEndian.PutUint16(fv[0x30:], headerLen)
+	f := fv[headerLen:]
+	fsize := uefi.FileHeaderMinLength + 4 + len(data)
+	copy(f, make([]byte, fsize))
+	copy(f, g[:])
+	f[ffsChecksumOffset], f[0x12] = uefi.EmptyBodyChecksum, byte(uefi.FVFileTypeRaw)
+	f[0x14], f[0x15], f[0x16] = byte(fsize), byte(fsize>>8), byte(fsize>>16)
+	s := f[uefi.FileHeaderMinLength:]
+	ssize := 4 + len(data)
+	s[0], s[1], s[2], s[3] = byte(ssize), byte(ssize>>8), byte(ssize>>16), byte(uefi.SectionTypeRaw)
+	copy(s[4:], data)
+	return fv
+}
+
+func TestParseComponentsMultiFV(t *testing.T) {
+	for _, spec := range []SpecVersion{0x24, 0x30} {
+		t.Run(spec.String(), func(t *testing.T) {
+			hdr := newTestHeaderRev7(spec, TypeI, 0x3000, 0xffe00000)
+			b := append(newTestFV(0x1000, HeaderFileGUID, hdr), newTestFV(0x2000, [16]byte{1}, []byte("second"))...)
+			comps, err := ParseComponents(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(comps) != 1 {
+				t.Fatalf("got %d components, want 1", len(comps))
+			}
+			c := comps[0]
+			if c.Type() != TypeI || len(c.FVs) != 2 || c.FVs[1] != 0x1000 {
+				t.Errorf("got %v with firmware volumes at %#x, want FSP-I at 0 and 0x1000", c.Type(), c.FVs)
+			}
+			if !c.Header.ImageAttribute.Is64BitModeSupported() {
+				t.Errorf("got image attribute %v, want 64-bit mode", c.Header.ImageAttribute)
+			}
+			if c.Header.FspMultiPhaseMemInitEntryOffset != 0x100 || c.Header.FspSmmInitEntryOffset != 0x200 {
+				t.Errorf("got entries %#x and %#x, want 0x100 and 0x200", c.Header.FspMultiPhaseMemInitEntryOffset, c.Header.FspSmmInitEntryOffset)
+			}
+			if err := c.SetHeaderField(b, "FspSmmInitEntryOffset", 0x300); err != nil {
+				t.Fatal(err)
+			}
+			if c.Header.FspSmmInitEntryOffset != 0x300 {
+				t.Errorf("got FspSmmInitEntryOffset %#x, want 0x300", c.Header.FspSmmInitEntryOffset)
+			}
+
+			// The second firmware volume does not fit in the image size.
+			binary.LittleEndian.PutUint32(b[c.HeaderOffset+24:], 0x1000)
+			if _, err := ParseComponents(b); err == nil {
+				t.Errorf("firmware volume out of the component: got nil, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// headerFields are the fields of the info header which may be edited, named
+// as in CommonInfoHeader, by their offset and size in FSP_INFO_HEADER and the
+// first header revision with them.
+var headerFields = map[string]struct {
+	offset, size uint64
+	rev          uint8
+}{
+	"ImageBase":                       {imageBaseOffset, 4, 3},
+	"ImageAttribute":                  {32, 2, 3},
+	"ComponentAttribute":              {34, 2, 3},
+	"CfgRegionOffset":                 {36, 4, 3},
+	"CfgRegionSize":                   {40, 4, 3},
+	"TempRAMInitEntryOffset":          {48, 4, 3},
+	"NotifyPhaseEntryOffset":          {56, 4, 3},
+	"FSPMemoryInitEntryOffset":        {60, 4, 3},
+	"TempRAMExitEntryOffset":          {64, 4, 3},
+	"FSPSiliconInitEntryOffset":       {68, 4, 3},
+	"FspMultiPhaseSiInitEntryOffset":  {72, 4, 5},
+	"FspMultiPhaseMemInitEntryOffset": {80, 4, 7},
+	"FspSmmInitEntryOffset":           {84, 4, 7},
+}
+
+// HeaderFields returns the names of the fields of the info header which
+// SetHeaderField may edit.
+func HeaderFields() []string {
+	var names []string
+	for n := range headerFields {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkHeader checks that the regions the header of the component points to
+// are in the component.
+func (c *Component) checkHeader() error {
+	h := c.Header
+	if uint64(h.ImageBase)+uint64(h.ImageSize) > 1<<32 {
+		return fmt.Errorf("image of %#x bytes at %#x is out of 4GiB", h.ImageSize, h.ImageBase)
+	}
+	if uint64(h.CfgRegionOffset)+uint64(h.CfgRegionSize) > uint64(h.ImageSize) {
+		return fmt.Errorf("configuration region [%#x, %#x) is out of the image of %#x bytes", h.CfgRegionOffset, uint64(h.CfgRegionOffset)+uint64(h.CfgRegionSize), h.ImageSize)
+	}
+	for _, e := range []uint32{h.TempRAMInitEntryOffset, h.NotifyPhaseEntryOffset, h.FSPMemoryInitEntryOffset, h.TempRAMExitEntryOffset, h.FSPSiliconInitEntryOffset, h.FspMultiPhaseSiInitEntryOffset, h.FspMultiPhaseMemInitEntryOffset, h.FspSmmInitEntryOffset} {
+		if e >= h.ImageSize {
+			return fmt.Errorf("entry point %#x is out of the image of %#x bytes", e, h.ImageSize)
+		}
+	}
+	if c.Type() == TypeReserved {
+		return fmt.Errorf("reserved component type in attribute %v", h.ComponentAttribute)
+	}
+	return nil
+}
+
+// SetHeaderField sets the field name of the info header of the component in
+// b to v, updates Header and the checksum of the file holding the header.
+// Only the fields listed by HeaderFields may be set. Unlike Rebase, setting
+// ImageBase moves nothing. The change is undone if the header is no longer
+// valid.
+func (c *Component) SetHeaderField(b []byte, name string, v uint64) error {
+	f, ok := headerFields[name]
+	if !ok {
+		return fmt.Errorf("%v: field %q of the info header may not be set", c.Type(), name)
+	}
+	if c.Header.HeaderRevision < f.rev {
+		return fmt.Errorf("%v: header revision %d has no %s", c.Type(), c.Header.HeaderRevision, name)
+	}
+	if v >= 1<<(f.size*8) {
+		return fmt.Errorf("%v: %s holds %d bytes, %#x does not fit", c.Type(), name, f.size, v)
+	}
+	file, err := c.fileAt(b, c.HeaderOffset, uint64(c.Header.HeaderLength))
+	if err != nil {
+		return err
+	}
+	d := b[c.HeaderOffset+f.offset : c.HeaderOffset+f.offset+f.size]
+	old := append([]byte{}, d...)
+	if f.size == 2 {
+		binary.LittleEndian.PutUint16(d, uint16(v))
+	} else {
+		binary.LittleEndian.PutUint32(d, uint32(v))
+	}
+	h, err := NewInfoHeader(b[c.HeaderOffset:])
+	if err == nil {
+		oldHeader := c.Header
+		c.Header = h
+		if err = c.checkHeader(); err != nil {
+			c.Header = oldHeader
+		}
+	}
+	if err != nil {
+		copy(d, old)
+		return fmt.Errorf("%v: %s %#x: %w", c.Type(), name, v, err)
+	}
+	file.fixChecksum(b)
+	return nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestSetHeaderField(t *testing.T) {
+	orig := readTestBinary(t)
+	b := append([]byte{}, orig...)
+	comps, err := ParseComponents(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := comps[1]
+	for _, f := range []struct {
+		name string
+		v    uint64
+	}{
+		{"ImageBase", 0xfef00000},
+		{"ImageAttribute", 0x3},
+		{"CfgRegionOffset", 0x200},
+		{"CfgRegionSize", 0x100},
+	} {
+		if err := m.SetHeaderField(b, f.name, f.v); err != nil {
+			t.Fatalf("setting %s: %v", f.name, err)
+		}
+	}
+	n, err := ParseComponents(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range []*CommonInfoHeader{m.Header, n[1].Header} {
+		if h.ImageBase != 0xfef00000 || h.ImageAttribute != 0x3 || h.CfgRegionOffset != 0x200 || h.CfgRegionSize != 0x100 {
+			t.Errorf("got %+v, want the new values", h)
+		}
+	}
+	file, err := m.fileAt(b, m.HeaderOffset, uint64(m.Header.HeaderLength))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Header.Attributes.HasChecksum() {
+		if sum := uefi.Checksum8(b[file.Offset+file.DataOffset:file.Offset+file.size()]) + b[file.Offset+ffsChecksumOffset]; sum != 0 {
+			t.Errorf("got file checksum off by %#x", sum)
+		}
+	}
+
+	before := append([]byte{}, b...)
+	for _, f := range []struct {
+		name string
+		v    uint64
+	}{
+		{"ImageSize", 0x1000},
+		{"ImageAttribute", 0x10000},
+		{"ImageBase", 0xffff0000},
+		{"CfgRegionSize", 0x59000},
+		{"ComponentAttribute", 0x5000},
+		{"FspMultiPhaseSiInitEntryOffset", 0},
+	} {
+		if err := m.SetHeaderField(b, f.name, f.v); err == nil {
+			t.Errorf("setting %s to %#x: got nil, want error", f.name, f.v)
+		}
+	}
+	if !bytes.Equal(b, before) || m.Header.ImageBase != 0xfef00000 {
+		t.Errorf("failed edits changed the binary")
+	}
+}
@@ -34,12 +34,13 @@ const (
 	HeaderV4Length        = 72
 	HeaderV5Length        = 76
 	HeaderV6Length        = 80
+	HeaderV7Length        = 88
 	// FSP 2.0
 	CurrentSpecVersion = SpecVersion(0x20)
 	HeaderMinRevision  = 3
-	HeaderMaxRevision  = 6
-	// FSP 3.0
-	UnsupportedSpecVersion = SpecVersion(0x30)
+	HeaderMaxRevision  = 7
+	// FSP 3.x headers are read as revision 7 ones; FSP 4.0 is unknown.
+	UnsupportedSpecVersion = SpecVersion(0x40)
 )
 
 // FixedInfoHeader is the common header among the various revisions of the FSP
@@ -88,28 +89,38 @@ type InfoHeaderRev6 struct {
 	Reserved4             uint16
 }
 
+// InfoHeaderRev7 represents the FSP_INFO_HEADER structure revision 7 (FSP
+// 2.4) as defined by Intel.
+type InfoHeaderRev7 struct {
+	InfoHeaderRev6
+	FspMultiPhaseMemInitEntryOffset uint32
+	FspSmmInitEntryOffset           uint32
+}
+
 // CommonInfoHeader represents the FSP_INFO_HEADER structure
 // revision independent
 type CommonInfoHeader struct {
-	Signature                      [4]byte
-	HeaderLength                   uint32
-	SpecVersion                    SpecVersion
-	HeaderRevision                 uint8
-	ImageRevision                  ImageRevision
-	ImageID                        [8]byte
-	ImageSize                      uint32
-	ImageBase                      uint32
-	ImageAttribute                 ImageAttribute
-	ComponentAttribute             ComponentAttribute
-	CfgRegionOffset                uint32
-	CfgRegionSize                  uint32
-	TempRAMInitEntryOffset         uint32
-	NotifyPhaseEntryOffset         uint32
-	FSPMemoryInitEntryOffset       uint32
-	TempRAMExitEntryOffset         uint32
-	FSPSiliconInitEntryOffset      uint32
-	FspMultiPhaseSiInitEntryOffset uint32
-	ExtendedImageRevision          uint16
+	Signature                       [4]byte
+	HeaderLength                    uint32
+	SpecVersion                     SpecVersion
+	HeaderRevision                  uint8
+	ImageRevision                   ImageRevision
+	ImageID                         [8]byte
+	ImageSize                       uint32
+	ImageBase                       uint32
+	ImageAttribute                  ImageAttribute
+	ComponentAttribute              ComponentAttribute
+	CfgRegionOffset                 uint32
+	CfgRegionSize                   uint32
+	TempRAMInitEntryOffset          uint32
+	NotifyPhaseEntryOffset          uint32
+	FSPMemoryInitEntryOffset        uint32
+	TempRAMExitEntryOffset          uint32
+	FSPSiliconInitEntryOffset       uint32
+	FspMultiPhaseSiInitEntryOffset  uint32
+	ExtendedImageRevision           uint16
+	FspMultiPhaseMemInitEntryOffset uint32
+	FspSmmInitEntryOffset           uint32
 }
 
 // Summary prints a multi-line summary of the header's content.
@@ -133,6 +144,10 @@ func (ih CommonInfoHeader) Summary() string {
 	s += fmt.Sprintf("FSPSiliconInit Entry Offset      : %#08x %d\n", ih.FSPSiliconInitEntryOffset, ih.FSPSiliconInitEntryOffset)
 	s += fmt.Sprintf("FspMultiPhaseSiInit Entry Offset : %#08x %d\n", ih.FspMultiPhaseSiInitEntryOffset, ih.FspMultiPhaseSiInitEntryOffset)
 	s += fmt.Sprintf("ExtendedImageRevision            : %#08x %d\n", ih.ExtendedImageRevision, ih.ExtendedImageRevision)
+	if ih.HeaderRevision >= 7 {
+		s += fmt.Sprintf("FspMultiPhaseMemInit Entry Offset: %#08x %d\n", ih.FspMultiPhaseMemInitEntryOffset, ih.FspMultiPhaseMemInitEntryOffset)
+		s += fmt.Sprintf("FspSmmInit Entry Offset          : %#08x %d\n", ih.FspSmmInitEntryOffset, ih.FspSmmInitEntryOffset)
+	}
 
 	return s
 }
@@ -207,7 +222,11 @@ func (ia ImageAttribute) String() string {
 	} else {
 		ret += " DispatchModeNotSupported"
 	}
-	if uint16(ia) & ^(uint16(1)) != 0 {
+	if ia.Is64BitModeSupported() {
+		ret += " 64BitModeSupported"
+	}
+	// bits 15:3 are reserved
+	if uint16(ia)&^0x7 != 0 {
 		ret += " (reserved bits are not zeroed)"
 	}
 	return ret
@@ -223,6 +242,12 @@ func (ia ImageAttribute) IsDispatchModeSupported() bool {
 	return uint16(ia)&0x2 == 2
 }
 
+// Is64BitModeSupported returns true if FSP supports being called in 64-bit
+// mode, from FSP 2.4.
+func (ia ImageAttribute) Is64BitModeSupported() bool {
+	return uint16(ia)&0x4 == 4
+}
+
 // Type identifies the FSP type.
 type Type uint8
 
@@ -231,6 +256,7 @@ var (
 	TypeT Type = 1
 	TypeM Type = 2
 	TypeS Type = 3
+	TypeI Type = 4
 	TypeO Type = 8
 	// TypeReserved is a fake type that represents a reserved FSP type.
 	TypeReserved Type
@@ -240,10 +266,28 @@ var fspTypeNames = map[Type]string{
 	TypeT:        "FSP-T",
 	TypeM:        "FSP-M",
 	TypeS:        "FSP-S",
+	TypeI:        "FSP-I",
 	TypeO:        "FSP-O",
 	TypeReserved: "FSP-ReservedType",
 }
 
+func (t Type) String() string {
+	if n, ok := fspTypeNames[t]; ok {
+		return n
+	}
+	return fmt.Sprintf("FSP-Type(%d)", uint8(t))
+}
+
+// ParseType returns the type named s, e.g. "FSP-M" or "m".
+func ParseType(s string) (Type, error) {
+	for t, n := range fspTypeNames {
+		if t != TypeReserved && (strings.EqualFold(s, n) || strings.EqualFold(s, n[len("FSP-"):])) {
+			return t, nil
+		}
+	}
+	return TypeReserved, fmt.Errorf("unknown FSP type %q", s)
+}
+
 // ComponentAttribute represents the component attribute.
 type ComponentAttribute uint16
 
@@ -314,7 +358,7 @@ func NewInfoHeader(b []byte) (*CommonInfoHeader, error) {
 		log.Warnf("reserved bytes must be zero, got %v", hdr.Reserved1)
 	}
 	// check spec version
-	// TODO currently, only FSP 2.x is supported
+	// FSP 2.x and 3.x are supported
 	if hdr.SpecVersion < CurrentSpecVersion || hdr.SpecVersion >= UnsupportedSpecVersion {
 		return nil, fmt.Errorf("cannot handle spec version %s; want %s", hdr.SpecVersion, CurrentSpecVersion)
 	}
@@ -335,7 +379,7 @@ func NewInfoHeader(b []byte) (*CommonInfoHeader, error) {
 	case 6:
 		l = HeaderV6Length
 	default:
-		l = HeaderV6Length
+		l = HeaderV7Length
 	}
 	if hdr.HeaderRevision <= HeaderMaxRevision {
 		// Intel violates their own spec! Warn here and don't care about additional fields.
@@ -350,12 +394,16 @@ func NewInfoHeader(b []byte) (*CommonInfoHeader, error) {
 	// now that we know it's an info header spec 2.0, re-read the
 	// buffer to fill the whole header.
 	reader = bytes.NewReader(b)
-	var InfoHeader InfoHeaderRev6
+	var InfoHeader InfoHeaderRev7
 
-	if hdr.HeaderRevision >= 6 {
+	if hdr.HeaderRevision >= 7 {
 		if err := binary.Read(reader, binary.LittleEndian, &InfoHeader); err != nil {
 			return nil, err
 		}
+	} else if hdr.HeaderRevision >= 6 {
+		if err := binary.Read(reader, binary.LittleEndian, &InfoHeader.InfoHeaderRev6); err != nil {
+			return nil, err
+		}
 	} else if hdr.HeaderRevision >= 5 {
 		if err := binary.Read(reader, binary.LittleEndian, &InfoHeader.InfoHeaderRev5); err != nil {
 			return nil, err
@@ -294,7 +294,7 @@ func TestErrorPath(t *testing.T) {
 
 	// Spec version too big
 	copy(tmp, FSPTestHeaderRev3)
-	tmp[10] = 0x30
+	tmp[10] = 0x40
 	_, err = NewInfoHeader(tmp)
 	if err == nil {
 		t.Errorf("Expected an error")
@@ -362,6 +362,17 @@ func TestImageAttribute(t *testing.T) {
 	if !ia.IsDispatchModeSupported() || !ia.IsGraphicsDisplaySupported() {
 		t.Errorf("Expected true, got false")
 	}
+	if ia.Is64BitModeSupported() {
+		t.Errorf("Expected false, got true")
+	}
+	// 64-bit mode supported
+	ia = ImageAttribute(4)
+	if !ia.Is64BitModeSupported() {
+		t.Errorf("Expected true, got false")
+	}
+	if ia.String() != "0x0004 GraphicsDisplayNotSupported DispatchModeNotSupported 64BitModeSupported" {
+		t.Errorf("Unexpected string %q", ia.String())
+	}
 }
 
 func TestNewInfoHeaderShortHeader(t *testing.T) {
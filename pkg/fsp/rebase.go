@@ -0,0 +1,228 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// The FSP extended header and patch table follow the info header, from the
+// FSP 2.0 spec and the EDK2 IntelFsp2Pkg SplitFspBin.py tool.
+var (
+	ExtendedHeaderSignature = [4]byte{'F', 'S', 'P', 'E'}
+	PatchTableSignature     = [4]byte{'F', 'S', 'P', 'P'}
+)
+
+const (
+	imageBaseOffset = 0x1c
+	patchTableLen   = 12
+)
+
+// Relocation types and layout of the PE/TE images of the components.
+const (
+	teHeaderLength  = 40
+	relAbsolute     = 0
+	relHighLow      = 3
+	relDir64        = 10
+	pe32Magic       = 0x10b
+	pe32PlusMagic   = 0x20b
+	dataDirReloc    = 5
+	peSignatureSize = 4
+	coffHeaderSize  = 20
+)
+
+// PatchTable returns the entries of the FSPP patch table of the component, or
+// nil if it has none.
+func (c *Component) PatchTable(b []byte) ([]uint32, error) {
+	p := c.HeaderOffset + uint64(c.Header.HeaderLength)
+	end := c.Offset + uint64(c.Header.ImageSize)
+	if p+8 <= end && bytes.Equal(b[p:p+4], ExtendedHeaderSignature[:]) {
+		p += uint64(binary.LittleEndian.Uint32(b[p+4:]))
+	}
+	if p+patchTableLen > end || !bytes.Equal(b[p:p+4], PatchTableSignature[:]) {
+		return nil, nil
+	}
+	n := uint64(binary.LittleEndian.Uint32(b[p+8:]))
+	p += uint64(binary.LittleEndian.Uint16(b[p+4:]))
+	if p+n*4 > end {
+		return nil, fmt.Errorf("%v: patch table of %d entries at %#x is out of the component", c.Type(), n, p)
+	}
+	entries := make([]uint32, n)
+	for i := range entries {
+		entries[i] = binary.LittleEndian.Uint32(b[p+uint64(i)*4:])
+	}
+	return entries, nil
+}
+
+// patch applies the patch table of the component: each entry is the offset,
+// from the start of the component, or from its end if bit 31 is set, of a
+// 32-bit address to move by delta.
+func (c *Component) patch(b []byte, delta uint32) error {
+	entries, err := c.PatchTable(b)
+	if err != nil {
+		return err
+	}
+	size := uint64(c.Header.ImageSize)
+	for _, e := range entries {
+		if t := (e >> 24) & 0xf; t != 0 && t != 0xf {
+			return fmt.Errorf("%v: patch entry %#08x has invalid type %#x", c.Type(), e, t)
+		}
+		off := uint64(e & 0xffffff)
+		if e&0x80000000 != 0 {
+			off = size - (0x1000000 - off)
+		}
+		// Entries out of the image are ignored, as SplitFspBin.py does.
+		if off+4 > size {
+			continue
+		}
+		v := b[c.Offset+off:]
+		binary.LittleEndian.PutUint32(v, binary.LittleEndian.Uint32(v)+delta)
+	}
+	return nil
+}
+
+// relocate applies the base relocations in relocs of the image img, whose
+// relative virtual addresses are turned into offsets in img by off.
+func relocate(img, relocs []byte, off func(uint32) int, delta uint64) error {
+	for len(relocs) >= 8 {
+		page := binary.LittleEndian.Uint32(relocs)
+		size := binary.LittleEndian.Uint32(relocs[4:])
+		if size < 8 || int(size) > len(relocs) {
+			return fmt.Errorf("relocation block for page %#x has invalid size %#x", page, size)
+		}
+		for r := relocs[8:size]; len(r) >= 2; r = r[2:] {
+			e := binary.LittleEndian.Uint16(r)
+			p := off(page + uint32(e&0xfff))
+			switch e >> 12 {
+			case relAbsolute:
+				continue
+			case relHighLow:
+				if p < 0 || p+4 > len(img) {
+					return fmt.Errorf("relocation at %#x is out of the image", page+uint32(e&0xfff))
+				}
+				binary.LittleEndian.PutUint32(img[p:], binary.LittleEndian.Uint32(img[p:])+uint32(delta))
+			case relDir64:
+				if p < 0 || p+8 > len(img) {
+					return fmt.Errorf("relocation at %#x is out of the image", page+uint32(e&0xfff))
+				}
+				binary.LittleEndian.PutUint64(img[p:], binary.LittleEndian.Uint64(img[p:])+delta)
+			default:
+				return fmt.Errorf("unsupported relocation type %d", e>>12)
+			}
+		}
+		relocs = relocs[size:]
+	}
+	return nil
+}
+
+// rebaseTE moves the TE image img by delta.
+func rebaseTE(img []byte, delta uint64) error {
+	if len(img) < teHeaderLength || !bytes.Equal(img[:2], []byte("VZ")) {
+		return fmt.Errorf("invalid TE image")
+	}
+	stripped := int(binary.LittleEndian.Uint16(img[6:]))
+	off := func(rva uint32) int { return int(rva) - stripped + teHeaderLength }
+	rva, size := binary.LittleEndian.Uint32(img[24:]), binary.LittleEndian.Uint32(img[28:])
+	if size != 0 {
+		p := off(rva)
+		if p < teHeaderLength || p+int(size) > len(img) {
+			return fmt.Errorf("TE relocations at %#x are out of the image", rva)
+		}
+		if err := relocate(img, img[p:p+int(size)], off, delta); err != nil {
+			return err
+		}
+	}
+	binary.LittleEndian.PutUint64(img[16:], binary.LittleEndian.Uint64(img[16:])+delta)
+	return nil
+}
+
+// rebasePE moves the PE32 or PE32+ image img by delta. Images of an FSP are
+// executed in place, so offsets and relative virtual addresses match.
+func rebasePE(img []byte, delta uint64) error {
+	if len(img) < 0x40 || !bytes.Equal(img[:2], []byte("MZ")) {
+		return fmt.Errorf("invalid PE image")
+	}
+	opt := int(binary.LittleEndian.Uint32(img[0x3c:])) + peSignatureSize + coffHeaderSize
+	if opt+2 > len(img) || !bytes.Equal(img[opt-coffHeaderSize-peSignatureSize:opt-coffHeaderSize], []byte("PE\x00\x00")) {
+		return fmt.Errorf("invalid PE signature")
+	}
+	var base, dirs int
+	switch binary.LittleEndian.Uint16(img[opt:]) {
+	case pe32Magic:
+		base, dirs = opt+28, opt+96
+	case pe32PlusMagic:
+		base, dirs = opt+24, opt+112
+	default:
+		return fmt.Errorf("unknown PE optional header magic %#x", binary.LittleEndian.Uint16(img[opt:]))
+	}
+	dir := dirs + dataDirReloc*8
+	if dir+8 > len(img) {
+		return fmt.Errorf("PE optional header is out of the image")
+	}
+	rva, size := binary.LittleEndian.Uint32(img[dir:]), binary.LittleEndian.Uint32(img[dir+4:])
+	if size != 0 {
+		if int(rva)+int(size) > len(img) {
+			return fmt.Errorf("PE relocations at %#x are out of the image", rva)
+		}
+		off := func(rva uint32) int { return int(rva) }
+		if err := relocate(img, img[rva:rva+size], off, delta); err != nil {
+			return err
+		}
+	}
+	if base == opt+28 {
+		binary.LittleEndian.PutUint32(img[base:], binary.LittleEndian.Uint32(img[base:])+uint32(delta))
+	} else {
+		binary.LittleEndian.PutUint64(img[base:], binary.LittleEndian.Uint64(img[base:])+delta)
+	}
+	return nil
+}
+
+// Rebase moves the component in b to the new base address, the way
+// SplitFspBin.py does: it updates the image base of the info header, applies
+// the patch table, and relocates the PE and TE images of the component. The
+// checksums of the files it changes are updated.
+func (c *Component) Rebase(b []byte, base uint32) error {
+	if uint64(base)+uint64(c.Header.ImageSize) > 1<<32 {
+		return fmt.Errorf("%v of %#x bytes does not fit at %#x", c.Type(), c.Header.ImageSize, base)
+	}
+	delta := base - c.Header.ImageBase
+	if delta == 0 {
+		return nil
+	}
+	if err := c.patch(b, delta); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(b[c.HeaderOffset+imageBaseOffset:], base)
+	// 64-bit addresses are moved by the signed delta.
+	d64 := uint64(int64(base) - int64(c.Header.ImageBase))
+	for _, fv := range c.FVs {
+		files, err := fvFiles(b, fv)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			for _, s := range f.sections() {
+				switch s.Header.Type {
+				case uefi.SectionTypeTE:
+					err = rebaseTE(s.data(b), d64)
+				case uefi.SectionTypePE32:
+					err = rebasePE(s.data(b), d64)
+				default:
+					continue
+				}
+				if err != nil {
+					return fmt.Errorf("file %v: %w", f.Header.GUID, err)
+				}
+			}
+			f.fixChecksum(b)
+		}
+	}
+	c.Header.ImageBase = base
+	return nil
+}
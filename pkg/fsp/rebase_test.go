@@ -0,0 +1,123 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+const testBinary = "../../cmds/fspinfo/test_blobs/ApolloLakeFspBinPkg/Fsp.fd"
+
+func readTestBinary(t *testing.T) []byte {
+	t.Helper()
+	b, err := os.ReadFile(testBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestParseComponents(t *testing.T) {
+	comps, err := ParseComponents(readTestBinary(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		typ    Type
+		offset uint64
+		base   uint32
+	}{
+		{TypeS, 0, 0x200000},
+		{TypeM, 0x2a000, 0xfef71000},
+		{TypeT, 0x83000, 0xffffe000},
+	}
+	if len(comps) != len(want) {
+		t.Fatalf("got %d components, want %d", len(comps), len(want))
+	}
+	for i, w := range want {
+		c := comps[i]
+		if c.Type() != w.typ || c.Offset != w.offset || c.Header.ImageBase != w.base {
+			t.Errorf("component %d: got %v at %#x based at %#x, want %v at %#x based at %#x", i, c.Type(), c.Offset, c.Header.ImageBase, w.typ, w.offset, w.base)
+		}
+	}
+	if _, err := ParseComponents(readTestBinary(t)[:0x1000]); err == nil {
+		t.Errorf("truncated binary: got nil, want error")
+	}
+}
+
+// teBases returns the image bases of the TE images of the component.
+func teBases(t *testing.T, b []byte, c *Component) []uint64 {
+	t.Helper()
+	var bases []uint64
+	for _, fv := range c.FVs {
+		files, err := fvFiles(b, fv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range files {
+			for _, s := range f.sections() {
+				if s.Header.Type == uefi.SectionTypeTE {
+					bases = append(bases, binary.LittleEndian.Uint64(s.data(b)[16:]))
+				}
+			}
+		}
+	}
+	return bases
+}
+
+func TestRebase(t *testing.T) {
+	orig := readTestBinary(t)
+	b := append([]byte{}, orig...)
+	comps, err := ParseComponents(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := comps[0]
+	const base, delta = 0x300000, 0x100000
+	before := teBases(t, b, s)
+	last := binary.LittleEndian.Uint32(b[s.Header.ImageSize-4:])
+	if err := s.Rebase(b, base); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := ParseComponents(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n[0].Header.ImageBase != base {
+		t.Errorf("got image base %#x, want %#x", n[0].Header.ImageBase, base)
+	}
+	after := teBases(t, b, n[0])
+	if len(after) == 0 || len(after) != len(before) {
+		t.Fatalf("got TE images based at %#x, want %d", after, len(before))
+	}
+	for i := range after {
+		if after[i] != before[i]+delta {
+			t.Errorf("TE image %d: got base %#x, want %#x", i, after[i], before[i]+delta)
+		}
+	}
+	// The only patch entry is the last 32 bits of the component.
+	if got := binary.LittleEndian.Uint32(b[s.Header.ImageSize-4:]); got != last+delta {
+		t.Errorf("got patched value %#x, want %#x", got, last+delta)
+	}
+	if !bytes.Equal(b[s.Header.ImageSize:], orig[s.Header.ImageSize:]) {
+		t.Errorf("rebasing FSP-S changed the other components")
+	}
+
+	if err := s.Rebase(b, 0x200000); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, orig) {
+		t.Errorf("rebasing back did not restore the binary")
+	}
+	if err := comps[1].Rebase(b, 0xfffff000); err == nil {
+		t.Errorf("rebasing out of 4GiB: got nil, want error")
+	}
+}
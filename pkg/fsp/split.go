@@ -0,0 +1,55 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsp
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Data returns the bytes of the component in the binary b.
+func (c *Component) Data(b []byte) []byte {
+	return b[c.Offset : c.Offset+uint64(c.Header.ImageSize)]
+}
+
+// Split splits the FSP binary b into its components, e.g. FSP-T, FSP-M and
+// FSP-S, in the order of b. Each part is a copy, and each component is
+// described with offsets in its part.
+func Split(b []byte) ([]*Component, [][]byte, error) {
+	all, err := ParseComponents(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	comps := make([]*Component, 0, len(all))
+	parts := make([][]byte, 0, len(all))
+	for _, c := range all {
+		p := append([]byte{}, c.Data(b)...)
+		pc, err := ParseComponents(p)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%v at %#x: %w", c.Type(), c.Offset, err)
+		}
+		comps, parts = append(comps, pc[0]), append(parts, p)
+	}
+	return comps, parts, nil
+}
+
+// Merge concatenates FSP binaries, e.g. the FSP-T, FSP-M and FSP-S parts of
+// Split, into one. A component type may appear only once.
+func Merge(parts ...[]byte) ([]byte, error) {
+	seen := map[Type]int{}
+	for i, p := range parts {
+		comps, err := ParseComponents(p)
+		if err != nil {
+			return nil, fmt.Errorf("part %d: %w", i, err)
+		}
+		for _, c := range comps {
+			if j, ok := seen[c.Type()]; ok {
+				return nil, fmt.Errorf("part %d: %v is already in part %d", i, c.Type(), j)
+			}
+			seen[c.Type()] = i
+		}
+	}
+	return bytes.Join(parts, nil), nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSplitMerge(t *testing.T) {
+	b := readTestBinary(t)
+	comps, parts, err := Split(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		typ  Type
+		size int
+	}{
+		{TypeS, 0x2a000},
+		{TypeM, 0x59000},
+		{TypeT, 0x2000},
+	}
+	if len(comps) != len(want) || len(parts) != len(want) {
+		t.Fatalf("got %d components and %d parts, want %d", len(comps), len(parts), len(want))
+	}
+	for i, w := range want {
+		if comps[i].Type() != w.typ || comps[i].Offset != 0 || len(parts[i]) != w.size {
+			t.Errorf("part %d: got %v at %#x in %#x bytes, want %v at 0 in %#x bytes", i, comps[i].Type(), comps[i].Offset, len(parts[i]), w.typ, w.size)
+		}
+		if !bytes.Equal(parts[i][comps[i].HeaderOffset:][:4], Signature[:]) {
+			t.Errorf("part %d: no info header at %#x", i, comps[i].HeaderOffset)
+		}
+	}
+
+	m, err := Merge(parts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(m, b) {
+		t.Errorf("merging the parts did not restore the binary")
+	}
+	m, err = Merge(parts[2], parts[1], parts[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	reordered, err := ParseComponents(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, typ := range []Type{TypeT, TypeM, TypeS} {
+		if reordered[i].Type() != typ {
+			t.Errorf("component %d: got %v, want %v", i, reordered[i].Type(), typ)
+		}
+	}
+	if _, err := Merge(parts[0], b); err == nil {
+		t.Errorf("merging FSP-S twice: got nil, want error")
+	}
+}
@@ -0,0 +1,402 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// UPDField is a setting of a UPD (Updatable Product Data) structure, the
+// configuration region of an FSP component.
+type UPDField struct {
+	Name string
+	// Offset is the offset of the field from the start of the structure.
+	Offset uint32
+	Size   uint32
+	// Default is the default value of the field, if the schema has one.
+	Default []byte
+	Help    string
+}
+
+// UPDStruct describes a UPD structure, identified by the 8-byte signature of
+// its FSP_UPD_HEADER, e.g. APLUPD_S.
+type UPDStruct struct {
+	Signature string
+	Fields    []UPDField
+}
+
+// Len returns the size of the structure, up to the end of its last field.
+func (s *UPDStruct) Len() uint32 {
+	var l uint32
+	for _, f := range s.Fields {
+		if e := f.Offset + f.Size; e > l {
+			l = e
+		}
+	}
+	return l
+}
+
+// Field returns the field named name, or nil if there is none.
+func (s *UPDStruct) Field(name string) *UPDField {
+	for i := range s.Fields {
+		if s.Fields[i].Name == name {
+			return &s.Fields[i]
+		}
+	}
+	return nil
+}
+
+// UPDSchema is the description of the UPD structures of an FSP, usually one
+// per component.
+type UPDSchema []*UPDStruct
+
+// Struct returns the structure with the signature sig, or nil if there is
+// none.
+func (s UPDSchema) Struct(sig string) *UPDStruct {
+	for _, st := range s {
+		if st.Signature == sig {
+			return st
+		}
+	}
+	return nil
+}
+
+// updName strips the '$' prefix and the PCD token space of a BSF name, e.g.
+// $gApolloLakeFspPkgTokenSpaceGuid_Revision is Revision.
+func updName(s string) string {
+	s = strings.TrimPrefix(s, "$")
+	if i := strings.Index(s, "TokenSpaceGuid_"); i != -1 {
+		s = s[i+len("TokenSpaceGuid_"):]
+	}
+	return s
+}
+
+// parseUPDValue returns a value of size bytes: a little endian number, or a
+// list of bytes in braces, e.g. {0x01, 0x02}.
+func parseUPDValue(s string, size uint32) ([]byte, error) {
+	v := make([]byte, size)
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+		elems := strings.Split(s[1:len(s)-1], ",")
+		if len(elems) > int(size) {
+			return nil, fmt.Errorf("%d bytes do not fit in %d", len(elems), size)
+		}
+		for i, e := range elems {
+			n, err := strconv.ParseUint(strings.TrimSpace(e), 0, 8)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = byte(n)
+		}
+		return v, nil
+	}
+	if size > 8 {
+		return nil, fmt.Errorf("a number does not fill %d bytes", size)
+	}
+	n, err := strconv.ParseUint(s, 0, int(size)*8)
+	if err != nil {
+		return nil, err
+	}
+	var u [8]byte
+	binary.LittleEndian.PutUint64(u[:], n)
+	copy(v, u[:])
+	return v, nil
+}
+
+// ParseBSF reads the UPD structures of a BSF (Boot Setting File) from the
+// StructDef section: each Find "SIGNATURE" line starts a structure, followed
+// by its fields, in order:
+//
+//	$Name N bytes [$_DEFAULT_ = VALUE]
+//	Skip N bytes
+//
+// The other sections of the file, which describe how to present the
+// settings, are ignored.
+func ParseBSF(r io.Reader) (UPDSchema, error) {
+	var (
+		schema UPDSchema
+		cur    *UPDStruct
+		off    uint32
+		in     bool
+	)
+	s := bufio.NewScanner(r)
+	for l := 1; s.Scan(); l++ {
+		line := strings.TrimSpace(s.Text())
+		if i := strings.Index(line, "//"); i != -1 {
+			line = strings.TrimSpace(line[:i])
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch {
+		case fields[0] == "StructDef":
+			in = true
+			continue
+		case fields[0] == "EndStruct":
+			in = false
+			continue
+		case !in:
+			continue
+		}
+		switch {
+		case fields[0] == "Find":
+			sig, err := strconv.Unquote(strings.TrimSpace(strings.TrimPrefix(line, "Find")))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: signature: %w", l, err)
+			}
+			cur, off = &UPDStruct{Signature: sig}, 0
+			schema = append(schema, cur)
+		case len(fields) >= 3 && fields[2] == "bytes" && (fields[0] == "Skip" || strings.HasPrefix(fields[0], "$")):
+			n, err := strconv.ParseUint(fields[1], 0, 32)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: size: %w", l, err)
+			}
+			if fields[0] == "Skip" {
+				off += uint32(n)
+				continue
+			}
+			if cur == nil {
+				return nil, fmt.Errorf("line %d: field %s before any Find", l, fields[0])
+			}
+			f := UPDField{Name: updName(fields[0]), Offset: off, Size: uint32(n)}
+			if i := strings.Index(line, "="); i != -1 && len(fields) > 3 {
+				if f.Default, err = parseUPDValue(line[i+1:], f.Size); err != nil {
+					return nil, fmt.Errorf("line %d: %s: %w", l, f.Name, err)
+				}
+			}
+			cur.Fields = append(cur.Fields, f)
+			off += uint32(n)
+		default:
+			return nil, fmt.Errorf("line %d: unknown statement %q", l, line)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(schema) == 0 {
+		return nil, fmt.Errorf("no UPD structure found")
+	}
+	return schema, nil
+}
+
+// updYAMLStruct is a structure of a YAML schema.
+type updYAMLStruct struct {
+	Signature string `yaml:"signature"`
+	Fields    []struct {
+		Name   string    `yaml:"name"`
+		Offset *uint32   `yaml:"offset"`
+		Length uint32    `yaml:"length"`
+		Value  yaml.Node `yaml:"value"`
+		Help   string    `yaml:"help"`
+	} `yaml:"fields"`
+}
+
+// yamlUPDValue returns the value of n in the syntax of parseUPDValue.
+func yamlUPDValue(n *yaml.Node) string {
+	if n.Kind != yaml.SequenceNode {
+		return n.Value
+	}
+	v := make([]string, len(n.Content))
+	for i, c := range n.Content {
+		v[i] = c.Value
+	}
+	return "{" + strings.Join(v, ", ") + "}"
+}
+
+// ParseUPDYAML reads UPD structures from YAML, a list of structures with the
+// same meaning as in ParseBSF:
+//
+//   - signature: APLUPD_S
+//     fields:
+//   - name: Revision
+//     offset: 0x8
+//     length: 1
+//     value: 0x01
+//     help: The revision of the structure.
+//
+// Fields without an offset follow the previous field. The value is the
+// default, as a number or a list of bytes, e.g. [0x01, 0x02].
+func ParseUPDYAML(r io.Reader) (UPDSchema, error) {
+	var structs []updYAMLStruct
+	if err := yaml.NewDecoder(r).Decode(&structs); err != nil {
+		return nil, err
+	}
+	var schema UPDSchema
+	for _, ys := range structs {
+		if ys.Signature == "" {
+			return nil, fmt.Errorf("UPD structure without a signature")
+		}
+		st := &UPDStruct{Signature: ys.Signature}
+		var off uint32
+		for _, yf := range ys.Fields {
+			if yf.Offset != nil {
+				off = *yf.Offset
+			}
+			if yf.Name == "" || yf.Length == 0 {
+				return nil, fmt.Errorf("%s: field at %#x needs a name and a length", st.Signature, off)
+			}
+			f := UPDField{Name: yf.Name, Offset: off, Size: yf.Length, Help: yf.Help}
+			if v := yamlUPDValue(&yf.Value); v != "" {
+				var err error
+				if f.Default, err = parseUPDValue(v, f.Size); err != nil {
+					return nil, fmt.Errorf("%s: %s: %w", st.Signature, f.Name, err)
+				}
+			}
+			st.Fields = append(st.Fields, f)
+			off += f.Size
+		}
+		schema = append(schema, st)
+	}
+	if len(schema) == 0 {
+		return nil, fmt.Errorf("no UPD structure found")
+	}
+	return schema, nil
+}
+
+// ErrUnknownUPD is returned for UPD regions which the schema does not
+// describe.
+var ErrUnknownUPD = errors.New("UPD structure not in the schema")
+
+// UPD is the UPD region of a component of a binary, as described by a schema.
+// Its data is part of the binary.
+type UPD struct {
+	Struct *UPDStruct
+	// Offset is the offset of the region in the binary.
+	Offset uint64
+	Data   []byte
+	file   ffsFile
+	b      []byte
+}
+
+// UPD returns the UPD region of the component in b, at CfgRegionOffset,
+// described by the structure of the schema with its signature.
+func (c *Component) UPD(b []byte, schema UPDSchema) (*UPD, error) {
+	off, size := c.Offset+uint64(c.Header.CfgRegionOffset), uint64(c.Header.CfgRegionSize)
+	if size < 8 || c.Header.CfgRegionOffset+c.Header.CfgRegionSize > c.Header.ImageSize || off+size > uint64(len(b)) {
+		return nil, fmt.Errorf("%v: invalid UPD region [%#x, %#x)", c.Type(), c.Header.CfgRegionOffset, uint64(c.Header.CfgRegionOffset)+size)
+	}
+	data := b[off : off+size]
+	sig := strings.TrimRight(string(data[:8]), "\x00")
+	st := schema.Struct(sig)
+	if st == nil {
+		return nil, fmt.Errorf("%v: %w: %q", c.Type(), ErrUnknownUPD, sig)
+	}
+	if uint64(st.Len()) > size {
+		return nil, fmt.Errorf("%v: UPD %q of %#x bytes does not fit in the region of %#x bytes", c.Type(), sig, st.Len(), size)
+	}
+	f, err := c.fileAt(b, off, size)
+	if err != nil {
+		return nil, err
+	}
+	return &UPD{Struct: st, Offset: off, Data: data, file: f, b: b}, nil
+}
+
+// Get returns the value of the field named name.
+func (u *UPD) Get(name string) ([]byte, error) {
+	f := u.Struct.Field(name)
+	if f == nil {
+		return nil, fmt.Errorf("%s: no field %q", u.Struct.Signature, name)
+	}
+	return u.Data[f.Offset : f.Offset+f.Size], nil
+}
+
+// Set sets the field named name to v, which must be of the size of the field,
+// and updates the checksum of the file holding the region.
+func (u *UPD) Set(name string, v []byte) error {
+	d, err := u.Get(name)
+	if err != nil {
+		return err
+	}
+	if len(v) != len(d) {
+		return fmt.Errorf("%s: %s holds %d bytes, got %d", u.Struct.Signature, name, len(d), len(v))
+	}
+	copy(d, v)
+	u.file.fixChecksum(u.b)
+	return nil
+}
+
+// SetString sets the field named name to the value s, a number or a list of
+// bytes in braces as in the schemas.
+func (u *UPD) SetString(name, s string) error {
+	f := u.Struct.Field(name)
+	if f == nil {
+		return fmt.Errorf("%s: no field %q", u.Struct.Signature, name)
+	}
+	v, err := parseUPDValue(s, f.Size)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return u.Set(name, v)
+}
+
+// FormatUPDValue returns v as a little endian number if it holds at most 8
+// bytes, or as a list of bytes in braces.
+func FormatUPDValue(v []byte) string {
+	if len(v) <= 8 {
+		var u [8]byte
+		copy(u[:], v)
+		return fmt.Sprintf("%#0*x", 2*len(v), binary.LittleEndian.Uint64(u[:]))
+	}
+	s := make([]string, len(v))
+	for i, c := range v {
+		s[i] = fmt.Sprintf("0x%02x", c)
+	}
+	return "{" + strings.Join(s, ", ") + "}"
+}
+
+// mUPDField is a field of a UPD with its value, for JSON.
+type mUPDField struct {
+	Name    string
+	Offset  uint32
+	Size    uint32
+	Value   string
+	Default string `json:",omitempty"`
+	Help    string `json:",omitempty"`
+}
+
+// MarshalJSON returns the layout of the UPD with the values of its fields,
+// formatted as by FormatUPDValue.
+func (u *UPD) MarshalJSON() ([]byte, error) {
+	m := struct {
+		Signature string
+		Offset    uint64
+		Size      int
+		Fields    []mUPDField
+	}{u.Struct.Signature, u.Offset, len(u.Data), []mUPDField{}}
+	for _, f := range u.Struct.Fields {
+		mf := mUPDField{Name: f.Name, Offset: f.Offset, Size: f.Size, Value: FormatUPDValue(u.Data[f.Offset : f.Offset+f.Size]), Help: f.Help}
+		if f.Default != nil {
+			mf.Default = FormatUPDValue(f.Default)
+		}
+		m.Fields = append(m.Fields, mf)
+	}
+	return json.Marshal(m)
+}
+
+// Summary prints a line per field: its offset, size, name and value, and
+// whether it differs from its default.
+func (u *UPD) Summary() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s at %#x\n", u.Struct.Signature, u.Offset)
+	for _, f := range u.Struct.Fields {
+		v := u.Data[f.Offset : f.Offset+f.Size]
+		fmt.Fprintf(&b, "0x%04x %4d %-40s %s", f.Offset, f.Size, f.Name, FormatUPDValue(v))
+		if f.Default != nil && string(f.Default) != string(v) {
+			fmt.Fprintf(&b, " (default %s)", FormatUPDValue(f.Default))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,138 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsp
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func readSchema(t *testing.T, path string, parse func(f *os.File) (UPDSchema, error)) UPDSchema {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s, err := parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestParseBSF(t *testing.T) {
+	s := readSchema(t, "testdata/upd.bsf", func(f *os.File) (UPDSchema, error) { return ParseBSF(f) })
+	if len(s) != 1 || s[0].Signature != "APLUPD_S" || len(s[0].Fields) != 15 {
+		t.Fatalf("got %+v, want APLUPD_S with 15 fields", s)
+	}
+	for _, w := range []struct {
+		name   string
+		offset uint32
+		def    []byte
+	}{
+		{"Signature", 0, []byte("APLUPD_S")},
+		{"ActiveProcessorCores", 0x20, []byte{0}},
+		{"PkgCStateLimit", 0x2c, []byte{2}},
+	} {
+		f := s[0].Field(w.name)
+		if f == nil || f.Offset != w.offset || !bytes.Equal(f.Default, w.def) {
+			t.Errorf("got %+v, want %s at %#x defaulting to %x", f, w.name, w.offset, w.def)
+		}
+	}
+	if l := s[0].Len(); l != 0x2d {
+		t.Errorf("got length %#x, want 0x2d", l)
+	}
+	for _, bad := range []string{
+		"StructDef\n$Foo 1 bytes\nEndStruct\n",
+		"StructDef\nFind \"A\"\n$Foo x bytes\nEndStruct\n",
+		"StructDef\nFind \"A\"\nBogus\nEndStruct\n",
+		"StructDef\nFind \"A\"\n$Foo 1 bytes $_DEFAULT_ = 0x100\nEndStruct\n",
+		"Page \"nothing\"\nEndPage\n",
+	} {
+		if _, err := ParseBSF(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q: got nil, want error", bad)
+		}
+	}
+}
+
+func TestUPD(t *testing.T) {
+	b := readTestBinary(t)
+	comps, err := ParseComponents(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := readSchema(t, "testdata/upd.bsf", func(f *os.File) (UPDSchema, error) { return ParseBSF(f) })
+	u, err := comps[0].UPD(b, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Offset != 0x124 {
+		t.Errorf("got UPD at %#x, want 0x124", u.Offset)
+	}
+	if v, err := u.Get("ProcTraceMemSize"); err != nil || !bytes.Equal(v, []byte{0xff}) {
+		t.Errorf("got ProcTraceMemSize %x, %v, want ff", v, err)
+	}
+	if strings.Contains(u.Summary(), "default") {
+		t.Errorf("got\n%s\nwant the default values", u.Summary())
+	}
+
+	if err := u.SetString("PkgCStateLimit", "5"); err != nil {
+		t.Fatal(err)
+	}
+	if b[0x124+0x2c] != 5 {
+		t.Errorf("got PkgCStateLimit %#x in the binary, want 5", b[0x124+0x2c])
+	}
+	if want := "0x002c    1 PkgCStateLimit                           0x05 (default 0x02)\n"; !strings.Contains(u.Summary(), want) {
+		t.Errorf("got\n%s\nwant a line %q", u.Summary(), want)
+	}
+	if u.file.Header.Attributes.HasChecksum() {
+		data := b[u.file.Offset+u.file.DataOffset : u.file.Offset+u.file.size()]
+		if sum := uefi.Checksum8(data) + b[u.file.Offset+ffsChecksumOffset]; sum != 0 {
+			t.Errorf("got file checksum off by %#x", sum)
+		}
+	}
+	for _, bad := range [][2]string{{"PkgCStateLimit", "0x100"}, {"Nonexistent", "0"}, {"Revision", "{1, 2}"}} {
+		if err := u.SetString(bad[0], bad[1]); err == nil {
+			t.Errorf("setting %s to %s: got nil, want error", bad[0], bad[1])
+		}
+	}
+	if _, err := comps[1].UPD(b, s); err == nil {
+		t.Errorf("FSP-M without a schema: got nil, want error")
+	}
+}
+
+func TestParseUPDYAML(t *testing.T) {
+	s := readSchema(t, "testdata/upd.yaml", func(f *os.File) (UPDSchema, error) { return ParseUPDYAML(f) })
+	b := readTestBinary(t)
+	comps, err := ParseComponents(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := comps[0].UPD(b, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := u.Struct.Field("DisableCores")
+	if f == nil || f.Offset != 0x21 || !bytes.Equal(f.Default, []byte{1, 1, 1}) {
+		t.Errorf("got %+v, want DisableCores at 0x21 defaulting to 010101", f)
+	}
+	if err := u.SetString("DisableCores", "{0, 1, 0}"); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b[0x124+0x21:][:3], []byte{0, 1, 0}) {
+		t.Errorf("got DisableCores %x in the binary, want 000100", b[0x124+0x21:][:3])
+	}
+	if want := "DisableCores                             0x000100 (default 0x010101)"; !strings.Contains(u.Summary(), want) {
+		t.Errorf("got\n%s\nwant %q", u.Summary(), want)
+	}
+	if _, err := ParseUPDYAML(strings.NewReader("- signature: A\n  fields:\n    - name: B\n")); err == nil {
+		t.Errorf("field without a length: got nil, want error")
+	}
+}
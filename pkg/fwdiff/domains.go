@@ -0,0 +1,214 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fwdiff
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+
+	amd_manifest "github.com/linuxboot/fiano/pkg/amd/manifest"
+	"github.com/linuxboot/fiano/pkg/amd/psb"
+	"github.com/linuxboot/fiano/pkg/cbfs"
+	"github.com/linuxboot/fiano/pkg/fmap"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/fit"
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/visitors"
+)
+
+// compareUEFI compares the flash regions, firmware volumes and files of the
+// images, as the diff visitor does.
+func compareUEFI(oldImage, newImage []byte) Section {
+	s := Section{Domain: DomainUEFI}
+	oldFw, oldErr := uefi.Parse(oldImage)
+	newFw, newErr := uefi.Parse(newImage)
+	switch {
+	case oldErr != nil && newErr != nil:
+		s.Status, s.Detail = StatusAbsent, "found in neither image"
+		return s
+	case oldErr != nil:
+		s.Status, s.Detail = StatusDifferent, fmt.Sprintf("not found in the old image: %v", oldErr)
+		return s
+	case newErr != nil:
+		s.Status, s.Detail = StatusDifferent, fmt.Sprintf("not found in the new image: %v", newErr)
+		return s
+	}
+	d := &visitors.Diff{Other: newFw}
+	if err := d.Run(oldFw); err != nil {
+		s.Status, s.Detail = StatusError, err.Error()
+		return s
+	}
+	s.Status = StatusSame
+	if len(d.Entries) > 0 {
+		s.Status, s.Detail = StatusDifferent, fmt.Sprintf("%d regions, volumes and files differ", len(d.Entries))
+	}
+	for _, e := range d.Entries {
+		c := Change{Change: e.Change, Key: e.Path}
+		if e.Change != ChangeAdded {
+			c.Old = uefiValue(e.Kind, e.Name, e.OldSize, e.OldOffset)
+		}
+		if e.Change != ChangeRemoved {
+			c.New = uefiValue(e.Kind, e.Name, e.NewSize, e.NewOffset)
+		}
+		s.Changes = append(s.Changes, c)
+	}
+	return s
+}
+
+func uefiValue(kind, name string, size, offset uint64) string {
+	if name != "" {
+		return fmt.Sprintf("%s %s, size %#x at %#x", kind, name, size, offset)
+	}
+	return fmt.Sprintf("%s, size %#x at %#x", kind, size, offset)
+}
+
+// fitItems lists the entries of the FIT, keyed by type.
+func fitItems(image []byte) ([]Item, error) {
+	table, err := fit.GetTable(image)
+	if err != nil {
+		return nil, errNotFound
+	}
+	var items []Item
+	for i, entry := range table.GetEntries(image) {
+		hdr := &table[i]
+		items = append(items, Item{
+			Key:   hdr.Type().String(),
+			Value: fmt.Sprintf("version %v, size %#x at %v", hdr.Version, hdr.Size.Uint32(), hdr.Address),
+			Sum:   sha256.Sum256(entry.GetEntryBase().DataSegmentBytes),
+		})
+	}
+	return items, nil
+}
+
+// bootGuardItems lists the key and boot policy manifests of the FIT.
+func bootGuardItems(image []byte) ([]Item, error) {
+	table, err := fit.GetTable(image)
+	if err != nil {
+		return nil, errNotFound
+	}
+	var items []Item
+	if hdr := table.First(fit.EntryTypeKeyManifestRecord); hdr != nil {
+		entry, ok := hdr.GetEntry(image).(*fit.EntryKeyManifestRecord)
+		if !ok {
+			return nil, fmt.Errorf("invalid key manifest entry")
+		}
+		value := fmt.Sprintf("size %#x", len(entry.DataSegmentBytes))
+		bgKM, cbntKM, err := entry.ParseData()
+		switch {
+		case err != nil:
+			value += fmt.Sprintf(", unparsable: %v", err)
+		case bgKM != nil:
+			value = fmt.Sprintf("BootGuard 1.0, KMID %#x, SVN %d, %s", bgKM.KMID, bgKM.KMSVN.SVN(), value)
+		case cbntKM != nil:
+			value = fmt.Sprintf("CBnT, KMID %#x, SVN %d, %s", cbntKM.KMID, cbntKM.KMSVN.SVN(), value)
+		}
+		items = append(items, Item{Key: "key manifest", Value: value, Sum: sha256.Sum256(entry.DataSegmentBytes)})
+	}
+	if hdr := table.First(fit.EntryTypeBootPolicyManifest); hdr != nil {
+		entry, ok := hdr.GetEntry(image).(*fit.EntryBootPolicyManifestRecord)
+		if !ok {
+			return nil, fmt.Errorf("invalid boot policy manifest entry")
+		}
+		value := fmt.Sprintf("size %#x", len(entry.DataSegmentBytes))
+		bgBPM, cbntBPM, err := entry.ParseData()
+		switch {
+		case err != nil:
+			value += fmt.Sprintf(", unparsable: %v", err)
+		case bgBPM != nil:
+			value = fmt.Sprintf("BootGuard 1.0, SVN %d, %s", bgBPM.BPMSVN.SVN(), value)
+		case cbntBPM != nil:
+			value = fmt.Sprintf("CBnT, SVN %d, %s", cbntBPM.BPMSVN.SVN(), value)
+		}
+		items = append(items, Item{Key: "boot policy manifest", Value: value, Sum: sha256.Sum256(entry.DataSegmentBytes)})
+	}
+	if len(items) == 0 {
+		return nil, errNotFound
+	}
+	return items, nil
+}
+
+// amdItems lists the entries of the PSP and BIOS directories, keyed by
+// directory, type and subprogram or instance.
+func amdItems(image []byte) ([]Item, error) {
+	if _, _, err := amd_manifest.FindEmbeddedFirmwareStructure(amd_manifest.FirmwareImage(image)); err != nil {
+		return nil, errNotFound
+	}
+	amdFw, err := psb.ParseAMDFirmware(image)
+	if err != nil {
+		return nil, err
+	}
+	pspFw := amdFw.PSPFirmware()
+	var items []Item
+	for i, dir := range []*amd_manifest.PSPDirectoryTable{pspFw.PSPDirectoryLevel1, pspFw.PSPDirectoryLevel2} {
+		if dir == nil {
+			continue
+		}
+		for _, e := range dir.Entries {
+			items = append(items, Item{
+				Key:   fmt.Sprintf("PSP L%d/type %#x subprogram %d", i+1, e.Type, e.Subprogram),
+				Value: fmt.Sprintf("size %#x at %#x", e.Size, e.LocationOrValue),
+				Sum:   rangeSum(image, e.LocationOrValue, uint64(e.Size)),
+			})
+		}
+	}
+	for i, dir := range []*amd_manifest.BIOSDirectoryTable{pspFw.BIOSDirectoryLevel1, pspFw.BIOSDirectoryLevel2} {
+		if dir == nil {
+			continue
+		}
+		for _, e := range dir.Entries {
+			items = append(items, Item{
+				Key:   fmt.Sprintf("BIOS L%d/type %#x instance %d", i+1, e.Type, e.Instance),
+				Value: fmt.Sprintf("size %#x at %#x, destination %#x", e.Size, e.SourceAddress, e.DestinationAddress),
+				Sum:   rangeSum(image, e.SourceAddress, uint64(e.Size)),
+			})
+		}
+	}
+	return items, nil
+}
+
+// rangeSum returns the hash of the length bytes at start of the image, or
+// of nothing if they are out of the image, e.g. for the entries holding a
+// value rather than a location.
+func rangeSum(image []byte, start, length uint64) [sha256.Size]byte {
+	b, err := psb.GetRangeBytes(image, start, length)
+	if err != nil {
+		return sha256.Sum256(nil)
+	}
+	return sha256.Sum256(b)
+}
+
+// cbfsItems lists the FMAP areas and the files of the CBFS of each area,
+// keyed by area and name. The empty space is not listed.
+func cbfsItems(image []byte) ([]Item, error) {
+	f, _, err := fmap.Read(bytes.NewReader(image))
+	if err != nil {
+		return nil, errNotFound
+	}
+	var items []Item
+	for _, a := range f.Areas {
+		items = append(items, Item{
+			Key:   "FMAP/" + a.Name.String(),
+			Value: fmt.Sprintf("size %#x at %#x", a.Size, a.Offset),
+		})
+	}
+	imgs, err := cbfs.NewRegionImages(bytes.NewReader(image))
+	if err != nil {
+		return nil, err
+	}
+	for _, img := range imgs {
+		for _, seg := range img.Segs {
+			file := seg.GetFile()
+			if file.Name == "" {
+				continue
+			}
+			items = append(items, Item{
+				Key:   img.Area.Name.String() + "/" + file.Name,
+				Value: fmt.Sprintf("%v, size %#x", file.Type, len(file.FData)),
+				Sum:   sha256.Sum256(file.FData),
+			})
+		}
+	}
+	return items, nil
+}
@@ -0,0 +1,207 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package fwdiff compares two firmware images across all the structures
+// fiano knows about: the UEFI tree, the FIT, the BootGuard manifests, the
+// AMD PSP and BIOS directories and the CBFS.
+package fwdiff
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// Domains of the comparison.
+const (
+	DomainUEFI      = "uefi"
+	DomainFIT       = "fit"
+	DomainBootGuard = "bootguard"
+	DomainAMD       = "amd"
+	DomainCBFS      = "cbfs"
+)
+
+// Statuses of the comparison of a domain.
+const (
+	// StatusSame is for a domain found in both images, without differences.
+	StatusSame = "same"
+	// StatusDifferent is for a domain with differences, including a domain
+	// found in one image only.
+	StatusDifferent = "different"
+	// StatusAbsent is for a domain found in neither image.
+	StatusAbsent = "absent"
+	// StatusError is for a domain which could not be parsed.
+	StatusError = "error"
+)
+
+// Changes of an item.
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+	// ChangeMoved is for an item with the same contents at another offset.
+	ChangeMoved = "moved"
+)
+
+// errNotFound is returned by the item listers of the domains missing from
+// an image.
+var errNotFound = errors.New("not found")
+
+// Item is an element of a domain, such as a FIT entry or a CBFS file.
+type Item struct {
+	// Key identifies the item in its domain, e.g. "COREBOOT/fallback/romstage".
+	Key string
+	// Value describes the item, e.g. its type, size and address.
+	Value string
+	// Sum is the hash of the contents of the item, compared along with
+	// Value.
+	Sum [sha256.Size]byte
+}
+
+// Change is a difference between the items of two images.
+type Change struct {
+	Change string
+	Key    string
+	Old    string `json:",omitempty"`
+	New    string `json:",omitempty"`
+}
+
+// Section is the comparison of one domain of the images.
+type Section struct {
+	Domain string
+	Status string
+	// Detail gives the number of items of each image, or the reason the
+	// domain was not compared.
+	Detail  string   `json:",omitempty"`
+	Changes []Change `json:",omitempty"`
+}
+
+// Report is the comparison of two images.
+type Report struct {
+	Old       string
+	New       string
+	OldSHA256 string
+	NewSHA256 string
+	Sections  []Section
+}
+
+// Different tells whether any domain of the images differs or could not be
+// compared.
+func (r *Report) Different() bool {
+	for _, s := range r.Sections {
+		if s.Status == StatusDifferent || s.Status == StatusError {
+			return true
+		}
+	}
+	return false
+}
+
+// domains lists the items of each domain of an image, in report order. The
+// UEFI tree is compared by compareUEFI.
+var domains = []struct {
+	name  string
+	items func(image []byte) ([]Item, error)
+}{
+	{DomainFIT, fitItems},
+	{DomainBootGuard, bootGuardItems},
+	{DomainAMD, amdItems},
+	{DomainCBFS, cbfsItems},
+}
+
+// Compare compares the images named oldName and newName.
+func Compare(oldName string, oldImage []byte, newName string, newImage []byte) *Report {
+	r := &Report{
+		Old:       oldName,
+		New:       newName,
+		OldSHA256: fmt.Sprintf("%x", sha256.Sum256(oldImage)),
+		NewSHA256: fmt.Sprintf("%x", sha256.Sum256(newImage)),
+		Sections:  []Section{compareUEFI(oldImage, newImage)},
+	}
+	for _, d := range domains {
+		r.Sections = append(r.Sections, compareDomain(d.name, d.items, oldImage, newImage))
+	}
+	return r
+}
+
+// compareDomain compares the items of a domain of both images.
+func compareDomain(name string, items func([]byte) ([]Item, error), oldImage, newImage []byte) Section {
+	s := Section{Domain: name}
+	oldItems, oldErr := items(oldImage)
+	newItems, newErr := items(newImage)
+	for _, err := range []error{oldErr, newErr} {
+		if err != nil && !errors.Is(err, errNotFound) {
+			s.Status, s.Detail = StatusError, err.Error()
+			return s
+		}
+	}
+	if oldErr != nil && newErr != nil {
+		s.Status, s.Detail = StatusAbsent, "found in neither image"
+		return s
+	}
+	s.Detail = fmt.Sprintf("old: %s, new: %s", countItems(oldItems, oldErr), countItems(newItems, newErr))
+	s.Changes = compareItems(oldItems, newItems)
+	s.Status = StatusSame
+	if len(s.Changes) > 0 || oldErr != nil || newErr != nil {
+		s.Status = StatusDifferent
+	}
+	return s
+}
+
+func countItems(items []Item, err error) string {
+	if err != nil {
+		return "not found"
+	}
+	if len(items) == 1 {
+		return "1 item"
+	}
+	return fmt.Sprintf("%d items", len(items))
+}
+
+// compareItems returns the removed items, then the added and changed ones,
+// in the order of the images. Items with the same key are told apart by
+// their rank.
+func compareItems(oldItems, newItems []Item) []Change {
+	oldItems, newItems = uniqueKeys(oldItems), uniqueKeys(newItems)
+	oldByKey := map[string]Item{}
+	for _, it := range oldItems {
+		oldByKey[it.Key] = it
+	}
+	newByKey := map[string]Item{}
+	for _, it := range newItems {
+		newByKey[it.Key] = it
+	}
+
+	var changes []Change
+	for _, o := range oldItems {
+		if _, ok := newByKey[o.Key]; !ok {
+			changes = append(changes, Change{Change: ChangeRemoved, Key: o.Key, Old: o.Value})
+		}
+	}
+	for _, n := range newItems {
+		o, ok := oldByKey[n.Key]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Change: ChangeAdded, Key: n.Key, New: n.Value})
+		case o.Sum != n.Sum || o.Value != n.Value:
+			changes = append(changes, Change{Change: ChangeChanged, Key: n.Key, Old: o.Value, New: n.Value})
+		}
+	}
+	return changes
+}
+
+// uniqueKeys suffixes the keys seen before with their rank, e.g. "x#1".
+func uniqueKeys(items []Item) []Item {
+	seen := map[string]int{}
+	out := make([]Item, len(items))
+	for i, it := range items {
+		if n := seen[it.Key]; n > 0 {
+			seen[it.Key]++
+			it.Key = fmt.Sprintf("%s#%d", it.Key, n)
+		} else {
+			seen[it.Key] = 1
+		}
+		out[i] = it
+	}
+	return out
+}
@@ -0,0 +1,162 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fwdiff
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/visitors"
+)
+
+const (
+	ovmfPath     = "../../integration/roms/OVMF.rom"
+	corebootPath = "../cbfs/testdata/coreboot.rom"
+)
+
+func readImage(t *testing.T, path string) []byte {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func section(t *testing.T, r *Report, domain string) Section {
+	t.Helper()
+	for _, s := range r.Sections {
+		if s.Domain == domain {
+			return s
+		}
+	}
+	t.Fatalf("no %s section in %+v", domain, r.Sections)
+	return Section{}
+}
+
+func TestCompareSame(t *testing.T) {
+	image := readImage(t, ovmfPath)
+	r := Compare("old", image, "new", image)
+	if r.Different() {
+		t.Errorf("identical images differ: %+v", r.Sections)
+	}
+	if s := section(t, r, DomainUEFI); s.Status != StatusSame {
+		t.Errorf("uefi: got %q, want %q", s.Status, StatusSame)
+	}
+	if s := section(t, r, DomainFIT); s.Status != StatusAbsent {
+		t.Errorf("fit: got %q, want %q", s.Status, StatusAbsent)
+	}
+}
+
+func TestCompareUEFI(t *testing.T) {
+	oldImage := readImage(t, ovmfPath)
+	f, err := uefi.Parse(append([]byte{}, oldImage...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pred, err := visitors.FindFileNamePredicate("^Shell$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&visitors.Remove{Predicate: pred}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&visitors.Assemble{}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+
+	r := Compare("old", oldImage, "new", f.Buf())
+	s := section(t, r, DomainUEFI)
+	if s.Status != StatusDifferent {
+		t.Fatalf("got %q, want %q", s.Status, StatusDifferent)
+	}
+	var removed bool
+	for _, c := range s.Changes {
+		if c.Change == ChangeRemoved && strings.HasSuffix(c.Key, "/File:7C04A583-9E3E-4F1C-AD65-E05268D0B4D1") {
+			removed = true
+		}
+	}
+	if !removed {
+		t.Errorf("the shell is not removed in %+v", s.Changes)
+	}
+}
+
+func TestCompareCBFS(t *testing.T) {
+	oldImage := readImage(t, corebootPath)
+	newImage := append([]byte{}, oldImage...)
+	// Change the config file, which is not compressed.
+	i := bytes.Index(newImage, []byte("CONFIG_"))
+	if i < 0 {
+		t.Fatal("no coreboot config in the image")
+	}
+	newImage[i] = 'X'
+
+	r := Compare("old", oldImage, "new", newImage)
+	s := section(t, r, DomainCBFS)
+	want := []Change{{
+		Change: ChangeChanged,
+		Key:    "COREBOOT/config",
+		Old:    "Raw, size 0x163",
+		New:    "Raw, size 0x163",
+	}}
+	if s.Status != StatusDifferent || !reflect.DeepEqual(s.Changes, want) {
+		t.Errorf("got %q %+v, want %q %+v", s.Status, s.Changes, StatusDifferent, want)
+	}
+}
+
+func TestCompareItems(t *testing.T) {
+	oldItems := []Item{
+		{Key: "a", Value: "1"},
+		{Key: "a", Value: "2"},
+		{Key: "b", Value: "3"},
+		{Key: "c", Value: "4", Sum: [32]byte{1}},
+	}
+	newItems := []Item{
+		{Key: "a", Value: "1"},
+		{Key: "c", Value: "4", Sum: [32]byte{2}},
+		{Key: "d", Value: "5"},
+	}
+	want := []Change{
+		{Change: ChangeRemoved, Key: "a#1", Old: "2"},
+		{Change: ChangeRemoved, Key: "b", Old: "3"},
+		{Change: ChangeChanged, Key: "c", Old: "4", New: "4"},
+		{Change: ChangeAdded, Key: "d", New: "5"},
+	}
+	if got := compareItems(oldItems, newItems); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestWriteHTML(t *testing.T) {
+	r := &Report{
+		Old: "old.rom",
+		New: "<new>.rom",
+		Sections: []Section{
+			{Domain: DomainUEFI, Status: StatusSame},
+			{Domain: DomainCBFS, Status: StatusDifferent, Detail: "old: 2 items, new: 1 item", Changes: []Change{
+				{Change: ChangeRemoved, Key: "COREBOOT/fallback/payload", Old: "SELF, size 0x100"},
+			}},
+		},
+	}
+	var b bytes.Buffer
+	if err := r.WriteHTML(&b); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		"&lt;new&gt;.rom",
+		"<details>\n<summary><b>uefi</b>",
+		"<details open>\n<summary><b>cbfs</b>",
+		`<tr class="removed"><td>removed</td><td>COREBOOT/fallback/payload</td><td>SELF, size 0x100</td><td></td></tr>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("%q not in the report:\n%s", want, out)
+		}
+	}
+}
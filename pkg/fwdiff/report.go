@@ -0,0 +1,88 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fwdiff
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+)
+
+// WriteText writes the report as a summary line per domain followed by its
+// changes.
+func (r *Report) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "old: %s (sha256 %s)\nnew: %s (sha256 %s)\n", r.Old, r.OldSHA256, r.New, r.NewSHA256)
+	for _, s := range r.Sections {
+		fmt.Fprintf(w, "\n%-9s %s", s.Domain, s.Status)
+		if s.Detail != "" {
+			fmt.Fprintf(w, ": %s", s.Detail)
+		}
+		fmt.Fprintln(w)
+		for _, c := range s.Changes {
+			fmt.Fprintf(w, "  %-8s %s", c.Change, c.Key)
+			switch {
+			case c.Old != "" && c.New != "":
+				fmt.Fprintf(w, ": %s -> %s", c.Old, c.New)
+			case c.Old != "":
+				fmt.Fprintf(w, ": %s", c.Old)
+			case c.New != "":
+				fmt.Fprintf(w, ": %s", c.New)
+			}
+			if _, err := fmt.Fprintln(w); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WriteHTML writes the report as a standalone HTML page, with a collapsible
+// section per domain, open for the domains which differ.
+func (r *Report) WriteHTML(w io.Writer) error {
+	return htmlReport.Execute(w, r)
+}
+
+var htmlReport = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>fwdiff {{.Old}} {{.New}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+code, td { font-family: monospace; }
+summary { cursor: pointer; font-size: 1.2em; margin: .5em 0; }
+table { border-collapse: collapse; margin: .5em 0 1em 1.5em; }
+th, td { border: 1px solid #ccc; padding: .2em .6em; text-align: left; }
+.same { color: #080; }
+.different, .error { color: #c00; }
+.absent { color: #888; }
+tr.added { background: #e6ffe6; }
+tr.removed { background: #ffe6e6; }
+tr.changed, tr.moved { background: #fff8e0; }
+</style>
+</head>
+<body>
+<h1>Firmware comparison</h1>
+<table>
+<tr><th></th><th>Image</th><th>SHA-256</th></tr>
+<tr><th>Old</th><td>{{.Old}}</td><td>{{.OldSHA256}}</td></tr>
+<tr><th>New</th><td>{{.New}}</td><td>{{.NewSHA256}}</td></tr>
+</table>
+{{range .Sections}}
+<details{{if or (eq .Status "different") (eq .Status "error")}} open{{end}}>
+<summary><b>{{.Domain}}</b>: <span class="{{.Status}}">{{.Status}}</span>{{if .Detail}} ({{.Detail}}){{end}}</summary>
+{{- if .Changes}}
+<table>
+<tr><th>Change</th><th>Item</th><th>Old</th><th>New</th></tr>
+{{- range .Changes}}
+<tr class="{{.Change}}"><td>{{.Change}}</td><td>{{.Key}}</td><td>{{.Old}}</td><td>{{.New}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+</details>
+{{- end}}
+</body>
+</html>
+`))
@@ -6,6 +6,7 @@
 package guid
 
 import (
+	"crypto/sha1"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -26,6 +27,17 @@ var (
 	fields = [...]int{4, 2, 2, 1, 1, 1, 1, 1, 1, 1, 1}
 )
 
+// Namespaces of name-based GUIDs. The first are specified by RFC 4122,
+// NamespaceFiano is NewV5(NamespaceURL, "https://github.com/linuxboot/fiano")
+// and is used for the GUIDs of the files fiano creates.
+var (
+	NamespaceDNS   = *MustParse("6BA7B810-9DAD-11D1-80B4-00C04FD430C8")
+	NamespaceURL   = *MustParse("6BA7B811-9DAD-11D1-80B4-00C04FD430C8")
+	NamespaceOID   = *MustParse("6BA7B812-9DAD-11D1-80B4-00C04FD430C8")
+	NamespaceX500  = *MustParse("6BA7B814-9DAD-11D1-80B4-00C04FD430C8")
+	NamespaceFiano = *MustParse("7854C1FF-2416-53A4-826D-E3728E93D6B5")
+)
+
 // GUID represents a unique identifier.
 type GUID [Size]byte
 
@@ -52,14 +64,36 @@ func Parse(s string) (*GUID, error) {
 	}
 
 	u := GUID{}
-	i := 0
 	copy(u[:], decoded[:])
 	// Correct for endianness.
+	u.swapEndian()
+	return &u, nil
+}
+
+// swapEndian converts between the mixed-endian and the big-endian byte
+// orders of the GUID.
+func (u *GUID) swapEndian() {
+	i := 0
 	for _, fieldlen := range fields {
 		reverse(u[i : i+fieldlen])
 		i += fieldlen
 	}
-	return &u, nil
+}
+
+// NewV5 returns the name-based GUID of name in the namespace ns, the version
+// 5 UUID of RFC 4122. The same name in the same namespace always gives the
+// same GUID.
+func NewV5(ns GUID, name []byte) GUID {
+	ns.swapEndian()
+	h := sha1.New()
+	h.Write(ns[:])
+	h.Write(name)
+	var u GUID
+	copy(u[:], h.Sum(nil))
+	u[6] = u[6]&0x0f | 0x50
+	u[8] = u[8]&0x3f | 0x80
+	u.swapEndian()
+	return u
 }
 
 // MustParse parses a guid string or panics.
@@ -73,12 +107,7 @@ func MustParse(s string) *GUID {
 
 func (u GUID) String() string {
 	// Not a pointer receiver so we don't have to manually copy.
-	i := 0
-	// reverse endianness.
-	for _, fieldlen := range fields {
-		reverse(u[i : i+fieldlen])
-		i += fieldlen
-	}
+	u.swapEndian()
 	// Convert to []interface{} for easy printing.
 	b := make([]interface{}, Size)
 	for i := range u[:] {
@@ -55,6 +55,23 @@ func TestParse(t *testing.T) {
 	}
 }
 
+func TestNewV5(t *testing.T) {
+	for _, tt := range []struct {
+		ns   GUID
+		name string
+		want string
+	}{
+		{NamespaceDNS, "python.org", "886313E1-3B8A-5372-9B90-0C9AEE199E5D"},
+		{NamespaceURL, "https://github.com/linuxboot/fiano", NamespaceFiano.String()},
+		{NamespaceFiano, "Shell", "494A645D-BBEF-5A5D-858A-C61673271151"},
+		{NamespaceFiano, "LinuxBoot", "D7CA5B08-0B91-5DD9-81AB-3697944E393C"},
+	} {
+		if got := NewV5(tt.ns, []byte(tt.name)); got.String() != tt.want {
+			t.Errorf("NewV5(%v, %q) = %v, want %v", tt.ns, tt.name, got, tt.want)
+		}
+	}
+}
+
 func TestMarshal(t *testing.T) {
 	var tests = []struct {
 		j string
@@ -48,7 +48,7 @@ func NewTemplateMapper(tmpl *template.Template) *TemplateMapper {
 
 // Map implements the Mapper.Map() function.
 func (f *TemplateMapper) Map(g guid.GUID) []byte {
-	name, isKnown := knownguids.GUIDs[g]
+	name, isKnown := knownguids.Name(g)
 	if !isKnown {
 		name = "UNKNOWN"
 	}
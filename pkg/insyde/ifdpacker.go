@@ -0,0 +1,663 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package insyde
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"unicode/utf16"
+
+	"github.com/linuxboot/fiano/pkg/compression"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// IFdPackerMarker ends the configuration of the 7-Zip self-extracting module
+// of an iFdPacker archive, which the 7z archive follows.
+const IFdPackerMarker = ";!@InstallEnd@!"
+
+// SevenZipSignature starts 7z archives.
+var SevenZipSignature = []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}
+
+// ErrEncrypted is returned for the iFdPacker archives protected by a
+// password, which are not supported.
+var ErrEncrypted = errors.New("the archive is encrypted")
+
+// findIFdPacker returns the 7z archive of an iFdPacker archive.
+func findIFdPacker(buf []byte) ([]byte, bool) {
+	i := bytes.Index(buf, []byte(IFdPackerMarker))
+	if i < 0 {
+		return nil, false
+	}
+	archive := bytes.TrimLeft(buf[i+len(IFdPackerMarker):], "\r\n")
+	if !bytes.HasPrefix(archive, SevenZipSignature) {
+		return nil, false
+	}
+	return archive, true
+}
+
+// IFdPackerFiles returns the files of an iFdPacker archive. ErrNotUpdate is
+// returned if buf is not an iFdPacker archive.
+func IFdPackerFiles(buf []byte) ([]Image, error) {
+	archive, ok := findIFdPacker(buf)
+	if !ok {
+		return nil, ErrNotUpdate
+	}
+	return extract7z(archive)
+}
+
+// 7z property IDs.
+const (
+	szEnd                   = 0x00
+	szHeader                = 0x01
+	szArchiveProperties     = 0x02
+	szAdditionalStreamsInfo = 0x03
+	szMainStreamsInfo       = 0x04
+	szFilesInfo             = 0x05
+	szPackInfo              = 0x06
+	szUnpackInfo            = 0x07
+	szSubStreamsInfo        = 0x08
+	szSize                  = 0x09
+	szCRC                   = 0x0a
+	szFolder                = 0x0b
+	szCodersUnpackSize      = 0x0c
+	szNumUnpackStream       = 0x0d
+	szEmptyStream           = 0x0e
+	szName                  = 0x11
+	szEncodedHeader         = 0x17
+)
+
+// 7z coder IDs, as strings of their bytes.
+const (
+	szCopy  = "\x00"
+	szLZMA  = "\x03\x01\x01"
+	szLZMA2 = "\x21"
+	szAES   = "\x06\xf1\x07\x01"
+)
+
+// szSignatureHeaderLen is the length of the header starting 7z archives.
+const szSignatureHeaderLen = 32
+
+// szFolderInfo is a 7z folder: the coder producing a stream, which the
+// substreams split into files.
+type szFolderInfo struct {
+	coder      string
+	properties []byte
+	unpackSize uint64
+	hasCRC     bool
+	// substreams are the sizes of the files of the folder.
+	substreams []uint64
+}
+
+// szStreams is the streams info of a 7z header.
+type szStreams struct {
+	packPos   uint64
+	packSizes []uint64
+	folders   []szFolderInfo
+}
+
+// szReader reads the properties of a 7z header.
+type szReader struct {
+	*bytes.Reader
+}
+
+// number reads a 7z variable length number.
+func (r szReader) number() (uint64, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	var value uint64
+	mask := byte(0x80)
+	for i := 0; i < 8; i++ {
+		if first&mask == 0 {
+			return value | uint64(first&(mask-1))<<(8*i), nil
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		value |= uint64(b) << (8 * i)
+		mask >>= 1
+	}
+	return value, nil
+}
+
+// count reads a number of elements, which is no larger than the header.
+func (r szReader) count() (int, error) {
+	n, err := r.number()
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(r.Size()) {
+		return 0, fmt.Errorf("%d elements in a header of %d bytes", n, r.Size())
+	}
+	return int(n), nil
+}
+
+// expect reads a property ID and checks it is id.
+func (r szReader) expect(id uint64) error {
+	got, err := r.number()
+	if err != nil {
+		return err
+	}
+	if got != id {
+		return fmt.Errorf("expected property %#x, got %#x", id, got)
+	}
+	return nil
+}
+
+// bits reads a vector of n bits.
+func (r szReader) bits(n int) ([]bool, error) {
+	v := make([]bool, n)
+	var b byte
+	for i := range v {
+		if i%8 == 0 {
+			var err error
+			if b, err = r.ReadByte(); err != nil {
+				return nil, err
+			}
+		}
+		v[i] = b&(0x80>>(i%8)) != 0
+	}
+	return v, nil
+}
+
+// skipDigests skips the CRCs of n streams and returns which are defined.
+func (r szReader) skipDigests(n int) ([]bool, error) {
+	all, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	defined := make([]bool, n)
+	if all == 0 {
+		if defined, err = r.bits(n); err != nil {
+			return nil, err
+		}
+	}
+	for i := range defined {
+		defined[i] = defined[i] || all != 0
+		if defined[i] {
+			if err := r.skip(4); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return defined, nil
+}
+
+// skip skips size bytes.
+func (r szReader) skip(size uint64) error {
+	if size > uint64(r.Len()) {
+		return io.ErrUnexpectedEOF
+	}
+	_, err := r.Seek(int64(size), io.SeekCurrent)
+	return err
+}
+
+// extract7z returns the files of a 7z archive.
+func extract7z(archive []byte) ([]Image, error) {
+	if len(archive) < szSignatureHeaderLen || !bytes.HasPrefix(archive, SevenZipSignature) {
+		return nil, fmt.Errorf("not a 7z archive")
+	}
+	if crc32.ChecksumIEEE(archive[12:32]) != binary.LittleEndian.Uint32(archive[8:]) {
+		return nil, fmt.Errorf("7z start header: CRC mismatch")
+	}
+	offset := binary.LittleEndian.Uint64(archive[12:])
+	size := binary.LittleEndian.Uint64(archive[20:])
+	if offset > uint64(len(archive)-szSignatureHeaderLen) || size > uint64(len(archive)-szSignatureHeaderLen)-offset {
+		return nil, fmt.Errorf("7z header of %#x bytes at %#x: %w", size, offset, io.ErrUnexpectedEOF)
+	}
+	header := archive[szSignatureHeaderLen+offset:][:size]
+	if crc32.ChecksumIEEE(header) != binary.LittleEndian.Uint32(archive[28:]) {
+		return nil, fmt.Errorf("7z header: CRC mismatch")
+	}
+
+	r := szReader{bytes.NewReader(header)}
+	id, err := r.number()
+	if err != nil {
+		return nil, err
+	}
+	// As in 7-Zip, the encoded header decodes to the header itself, never
+	// to another encoded header which could decode to itself forever.
+	if id == szEncodedHeader {
+		streams, err := szReadStreams(r)
+		if err != nil {
+			return nil, fmt.Errorf("7z encoded header: %w", err)
+		}
+		if len(streams.folders) == 0 {
+			return nil, fmt.Errorf("7z encoded header: no folder")
+		}
+		if header, err = streams.unpack(archive, 0); err != nil {
+			return nil, fmt.Errorf("7z encoded header: %w", err)
+		}
+		r = szReader{bytes.NewReader(header)}
+		if id, err = r.number(); err != nil {
+			return nil, fmt.Errorf("7z encoded header: %w", err)
+		}
+	}
+	if id != szHeader {
+		return nil, fmt.Errorf("7z header: unexpected property %#x", id)
+	}
+	return szReadHeader(r, archive)
+}
+
+// szReadHeader reads the files of a decoded 7z header.
+func szReadHeader(r szReader, archive []byte) ([]Image, error) {
+	id, err := r.number()
+	if err != nil {
+		return nil, err
+	}
+	if id == szArchiveProperties {
+		for {
+			property, err := r.number()
+			if err != nil {
+				return nil, err
+			}
+			if property == szEnd {
+				break
+			}
+			size, err := r.number()
+			if err != nil {
+				return nil, err
+			}
+			if err := r.skip(size); err != nil {
+				return nil, err
+			}
+		}
+		if id, err = r.number(); err != nil {
+			return nil, err
+		}
+	}
+	if id == szAdditionalStreamsInfo {
+		if _, err := szReadStreams(r); err != nil {
+			return nil, err
+		}
+		if id, err = r.number(); err != nil {
+			return nil, err
+		}
+	}
+	var streams szStreams
+	if id == szMainStreamsInfo {
+		if streams, err = szReadStreams(r); err != nil {
+			return nil, err
+		}
+		if id, err = r.number(); err != nil {
+			return nil, err
+		}
+	}
+	if id != szFilesInfo {
+		return nil, nil
+	}
+	names, empty, err := szReadFilesInfo(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []Image
+	folder, substream := 0, 0
+	var unpacked []byte
+	var unpackedOffset uint64
+	for i, name := range names {
+		if empty[i] {
+			continue
+		}
+		for folder < len(streams.folders) && substream == len(streams.folders[folder].substreams) {
+			folder, substream, unpacked = folder+1, 0, nil
+		}
+		if folder == len(streams.folders) {
+			return nil, fmt.Errorf("7z file %q: no stream left", name)
+		}
+		if unpacked == nil {
+			if unpacked, err = streams.unpack(archive, folder); err != nil {
+				return nil, fmt.Errorf("7z file %q: %w", name, err)
+			}
+			unpackedOffset = 0
+		}
+		size := streams.folders[folder].substreams[substream]
+		if size > uint64(len(unpacked))-unpackedOffset {
+			return nil, fmt.Errorf("7z file %q: %#x bytes past its folder", name, size)
+		}
+		files = append(files, Image{Name: name, Data: unpacked[unpackedOffset : unpackedOffset+size]})
+		unpackedOffset += size
+		substream++
+	}
+	return files, nil
+}
+
+// szReadFilesInfo reads the names of the files and whether they are empty.
+func szReadFilesInfo(r szReader) ([]string, []bool, error) {
+	n, err := r.count()
+	if err != nil {
+		return nil, nil, err
+	}
+	names := make([]string, n)
+	empty := make([]bool, n)
+	for {
+		id, err := r.number()
+		if err != nil {
+			return nil, nil, err
+		}
+		if id == szEnd {
+			return names, empty, nil
+		}
+		size, err := r.number()
+		if err != nil {
+			return nil, nil, err
+		}
+		if size > uint64(r.Len()) {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, nil, err
+		}
+		switch id {
+		case szEmptyStream:
+			if empty, err = (szReader{bytes.NewReader(data)}).bits(n); err != nil {
+				return nil, nil, err
+			}
+		case szName:
+			if len(data) == 0 || data[0] != 0 {
+				return nil, nil, fmt.Errorf("7z: external file names are not supported")
+			}
+			var name []uint16
+			i := 0
+			for j := 1; j+1 < len(data) && i < n; j += 2 {
+				c := binary.LittleEndian.Uint16(data[j:])
+				if c != 0 {
+					name = append(name, c)
+					continue
+				}
+				names[i] = string(utf16.Decode(name))
+				name = name[:0]
+				i++
+			}
+		}
+	}
+}
+
+// szReadStreams reads a streams info.
+func szReadStreams(r szReader) (szStreams, error) {
+	var s szStreams
+	for {
+		id, err := r.number()
+		if err != nil {
+			return s, err
+		}
+		switch id {
+		case szEnd:
+			return s, nil
+		case szPackInfo:
+			err = s.readPackInfo(r)
+		case szUnpackInfo:
+			err = s.readUnpackInfo(r)
+		case szSubStreamsInfo:
+			err = s.readSubStreamsInfo(r)
+		default:
+			err = fmt.Errorf("unexpected property %#x in streams info", id)
+		}
+		if err != nil {
+			return s, err
+		}
+	}
+}
+
+func (s *szStreams) readPackInfo(r szReader) error {
+	var err error
+	if s.packPos, err = r.number(); err != nil {
+		return err
+	}
+	n, err := r.count()
+	if err != nil {
+		return err
+	}
+	for {
+		id, err := r.number()
+		if err != nil {
+			return err
+		}
+		switch id {
+		case szEnd:
+			return nil
+		case szSize:
+			s.packSizes = make([]uint64, n)
+			for i := range s.packSizes {
+				if s.packSizes[i], err = r.number(); err != nil {
+					return err
+				}
+			}
+		case szCRC:
+			if _, err := r.skipDigests(n); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unexpected property %#x in pack info", id)
+		}
+	}
+}
+
+func (s *szStreams) readUnpackInfo(r szReader) error {
+	if err := r.expect(szFolder); err != nil {
+		return err
+	}
+	n, err := r.count()
+	if err != nil {
+		return err
+	}
+	if external, err := r.ReadByte(); err != nil || external != 0 {
+		return fmt.Errorf("external folders are not supported")
+	}
+	s.folders = make([]szFolderInfo, n)
+	for i := range s.folders {
+		if err := s.folders[i].read(r); err != nil {
+			return err
+		}
+	}
+	if err := r.expect(szCodersUnpackSize); err != nil {
+		return err
+	}
+	for i := range s.folders {
+		if s.folders[i].unpackSize, err = r.number(); err != nil {
+			return err
+		}
+		s.folders[i].substreams = []uint64{s.folders[i].unpackSize}
+	}
+	for {
+		id, err := r.number()
+		if err != nil {
+			return err
+		}
+		switch id {
+		case szEnd:
+			return nil
+		case szCRC:
+			defined, err := r.skipDigests(n)
+			if err != nil {
+				return err
+			}
+			for i, d := range defined {
+				s.folders[i].hasCRC = d
+			}
+		default:
+			return fmt.Errorf("unexpected property %#x in unpack info", id)
+		}
+	}
+}
+
+// read reads a folder, which must be made of a single coder with one input
+// and one output stream.
+func (f *szFolderInfo) read(r szReader) error {
+	coders, err := r.count()
+	if err != nil {
+		return err
+	}
+	if coders != 1 {
+		return fmt.Errorf("folders of %d coders are not supported", coders)
+	}
+	flags, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if flags&0x90 != 0 {
+		return fmt.Errorf("coders with several streams are not supported")
+	}
+	id := make([]byte, flags&0xf)
+	if _, err := io.ReadFull(r, id); err != nil {
+		return err
+	}
+	if flags&0x20 != 0 {
+		size, err := r.count()
+		if err != nil {
+			return err
+		}
+		f.properties = make([]byte, size)
+		if _, err := io.ReadFull(r, f.properties); err != nil {
+			return err
+		}
+	}
+	if string(id) == szAES {
+		return ErrEncrypted
+	}
+	f.coder = string(id)
+	return nil
+}
+
+func (s *szStreams) readSubStreamsInfo(r szReader) error {
+	id, err := r.number()
+	if err != nil {
+		return err
+	}
+	counts := make([]int, len(s.folders))
+	for i := range counts {
+		counts[i] = 1
+	}
+	if id == szNumUnpackStream {
+		for i := range counts {
+			if counts[i], err = r.count(); err != nil {
+				return err
+			}
+		}
+		if id, err = r.number(); err != nil {
+			return err
+		}
+	}
+	var digests int
+	for i := range s.folders {
+		f := &s.folders[i]
+		f.substreams = make([]uint64, counts[i])
+		left := f.unpackSize
+		for j := 0; j < counts[i]-1 && id == szSize; j++ {
+			if f.substreams[j], err = r.number(); err != nil {
+				return err
+			}
+			if f.substreams[j] > left {
+				return fmt.Errorf("substreams larger than their folder of %#x bytes", f.unpackSize)
+			}
+			left -= f.substreams[j]
+		}
+		if counts[i] != 0 {
+			f.substreams[counts[i]-1] = left
+		}
+		// The CRC of a single substream is that of its folder.
+		if counts[i] != 1 || !f.hasCRC {
+			digests += counts[i]
+		}
+	}
+	if id == szSize {
+		if id, err = r.number(); err != nil {
+			return err
+		}
+	}
+	for id != szEnd {
+		if id != szCRC {
+			return fmt.Errorf("unexpected property %#x in substreams info", id)
+		}
+		if _, err := r.skipDigests(digests); err != nil {
+			return err
+		}
+		if id, err = r.number(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unpack returns the decoded data of a folder, whose single coder reads the
+// packed stream of the same index.
+func (s *szStreams) unpack(archive []byte, folder int) ([]byte, error) {
+	if folder < 0 || folder >= len(s.folders) || folder >= len(s.packSizes) {
+		return nil, fmt.Errorf("folder %d: no packed stream", folder)
+	}
+	if s.packPos > uint64(len(archive)) {
+		return nil, fmt.Errorf("packed streams at %#x: %w", s.packPos, io.ErrUnexpectedEOF)
+	}
+	offset := szSignatureHeaderLen + s.packPos
+	for _, size := range s.packSizes[:folder+1] {
+		if offset > uint64(len(archive)) || size > uint64(len(archive))-offset {
+			return nil, fmt.Errorf("folder %d: packed stream of %#x bytes at %#x: %w", folder, size, offset, io.ErrUnexpectedEOF)
+		}
+		offset += size
+	}
+	size := s.packSizes[folder]
+	data := archive[offset-size : offset]
+
+	f := s.folders[folder]
+	if f.unpackSize > compression.MaxDecodedSize {
+		return nil, fmt.Errorf("folder %d: %#x bytes: %w", folder, f.unpackSize, compression.ErrTooLarge)
+	}
+	var out io.Reader
+	switch f.coder {
+	case szCopy:
+		out = bytes.NewReader(data)
+	case szLZMA:
+		if len(f.properties) != 5 {
+			return nil, fmt.Errorf("folder %d: LZMA properties of %d bytes", folder, len(f.properties))
+		}
+		// The coder properties are those of an LZMA header, without the
+		// size of the data, and the dictionary need not exceed the data.
+		header := make([]byte, lzma.HeaderLen)
+		copy(header, f.properties)
+		if uint64(binary.LittleEndian.Uint32(header[1:])) > f.unpackSize {
+			binary.LittleEndian.PutUint32(header[1:], uint32(f.unpackSize))
+		}
+		binary.LittleEndian.PutUint64(header[5:], f.unpackSize)
+		r, err := lzma.NewReader(io.MultiReader(bytes.NewReader(header), bytes.NewReader(data)))
+		if err != nil {
+			return nil, fmt.Errorf("folder %d: %w", folder, err)
+		}
+		out = r
+	case szLZMA2:
+		if len(f.properties) != 1 || f.properties[0] > 40 {
+			return nil, fmt.Errorf("folder %d: invalid LZMA2 properties %x", folder, f.properties)
+		}
+		dictCap := uint64(2|f.properties[0]&1) << (f.properties[0]/2 + 11)
+		if dictCap > f.unpackSize {
+			dictCap = f.unpackSize
+		}
+		if dictCap < lzma.MinDictCap {
+			dictCap = lzma.MinDictCap
+		}
+		r, err := lzma.Reader2Config{DictCap: int(dictCap)}.NewReader2(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("folder %d: %w", folder, err)
+		}
+		out = r
+	default:
+		return nil, fmt.Errorf("folder %d: coder %x is not supported", folder, f.coder)
+	}
+	// The declared size is not trusted for the allocation.
+	decoded, err := io.ReadAll(io.LimitReader(out, int64(f.unpackSize)))
+	if err != nil {
+		return nil, fmt.Errorf("folder %d: %w", folder, err)
+	}
+	if uint64(len(decoded)) != f.unpackSize {
+		return nil, fmt.Errorf("folder %d: decoded %#x bytes, want %#x: %w", folder, len(decoded), f.unpackSize, io.ErrUnexpectedEOF)
+	}
+	return decoded, nil
+}
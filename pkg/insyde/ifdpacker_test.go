@@ -0,0 +1,267 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package insyde
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// szFile is a file of a 7z archive built by sevenZip.
+type szFile struct {
+	name string
+	data []byte
+}
+
+// szNumber encodes a 7z number.
+func szNumber(n uint64) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	return binary.LittleEndian.AppendUint64([]byte{0xff}, n)
+}
+
+// szCoder returns a folder of a single coder and its packed data.
+func szCoder(t testing.TB, coder string, data []byte) ([]byte, []byte) {
+	t.Helper()
+	folder := append(szNumber(1), byte(len(coder)))
+	folder = append(folder, coder...)
+	switch coder {
+	case szCopy:
+		return folder, data
+	case szLZMA:
+		var b bytes.Buffer
+		w, err := lzma.WriterConfig{SizeInHeader: true, Size: int64(len(data))}.NewWriter(&b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		folder[1] |= 0x20
+		folder = append(folder, 5)
+		folder = append(folder, b.Bytes()[:5]...)
+		return folder, b.Bytes()[lzma.HeaderLen:]
+	case szAES:
+		folder[1] |= 0x20
+		return append(folder, 2, 0, 0), data
+	}
+	t.Fatalf("coder %x", coder)
+	return nil, nil
+}
+
+// szStreamsInfo returns the streams info of a single folder packed at
+// packPos, holding streams of the given sizes.
+func szStreamsInfo(folder []byte, packPos, packSize uint64, sizes ...uint64) []byte {
+	var total uint64
+	for _, size := range sizes {
+		total += size
+	}
+	b := []byte{szPackInfo}
+	b = append(b, szNumber(packPos)...)
+	b = append(b, szNumber(1)...)
+	b = append(b, szSize)
+	b = append(b, szNumber(packSize)...)
+	b = append(b, szEnd, szUnpackInfo, szFolder, 1, 0)
+	b = append(b, folder...)
+	b = append(b, szCodersUnpackSize)
+	b = append(b, szNumber(total)...)
+	b = append(b, szEnd, szSubStreamsInfo, szNumUnpackStream)
+	b = append(b, szNumber(uint64(len(sizes)))...)
+	b = append(b, szSize)
+	for _, size := range sizes[:len(sizes)-1] {
+		b = append(b, szNumber(size)...)
+	}
+	return append(b, szEnd, szEnd)
+}
+
+// sevenZip returns a solid 7z archive of files compressed with coder, whose
+// header is compressed with LZMA if encodeHeader is set.
+func sevenZip(t testing.TB, coder string, encodeHeader bool, files ...szFile) []byte {
+	t.Helper()
+	var data []byte
+	var sizes []uint64
+	var names []uint16
+	for _, f := range files {
+		data = append(data, f.data...)
+		sizes = append(sizes, uint64(len(f.data)))
+		names = append(append(names, utf16.Encode([]rune(f.name))...), 0)
+	}
+	folder, packed := szCoder(t, coder, data)
+
+	header := []byte{szHeader, szMainStreamsInfo}
+	header = append(header, szStreamsInfo(folder, 0, uint64(len(packed)), sizes...)...)
+	header = append(header, szFilesInfo)
+	header = append(header, szNumber(uint64(len(files)))...)
+	header = append(header, szName)
+	header = append(header, szNumber(uint64(1+2*len(names)))...)
+	header = append(header, 0)
+	for _, c := range names {
+		header = binary.LittleEndian.AppendUint16(header, c)
+	}
+	header = append(header, szEnd, szEnd)
+	if encodeHeader {
+		folder, packedHeader := szCoder(t, szLZMA, header)
+		streams := szStreamsInfo(folder, uint64(len(packed)), uint64(len(packedHeader)), uint64(len(header)))
+		packed = append(packed, packedHeader...)
+		header = append([]byte{szEncodedHeader}, streams...)
+	}
+
+	return szArchive(packed, header)
+}
+
+// szArchive returns the 7z archive of the packed streams and the header.
+func szArchive(packed, header []byte) []byte {
+	archive := make([]byte, szSignatureHeaderLen)
+	copy(archive, SevenZipSignature)
+	archive[7] = 4
+	binary.LittleEndian.PutUint64(archive[12:], uint64(len(packed)))
+	binary.LittleEndian.PutUint64(archive[20:], uint64(len(header)))
+	binary.LittleEndian.PutUint32(archive[28:], crc32.ChecksumIEEE(header))
+	binary.LittleEndian.PutUint32(archive[8:], crc32.ChecksumIEEE(archive[12:32]))
+	archive = append(archive, packed...)
+	return append(archive, header...)
+}
+
+// selfEncodedHeader returns a 7z archive whose encoded header is stored
+// with the copy coder in a packed stream which is the encoded header itself.
+func selfEncodedHeader(t testing.TB) []byte {
+	t.Helper()
+	folder, _ := szCoder(t, szCopy, nil)
+	// The sizes are encoded in a byte, so the length of the header does
+	// not depend on them.
+	size := uint64(1 + len(szStreamsInfo(folder, 0, 0, 0)))
+	header := append([]byte{szEncodedHeader}, szStreamsInfo(folder, 0, size, size)...)
+	// The packed stream starts after the signature header, where the
+	// header is stored.
+	archive := szArchive(nil, header)
+	if !bytes.Equal(archive[szSignatureHeaderLen:], header) {
+		t.Fatalf("the header is not its own packed stream")
+	}
+	return archive
+}
+
+// ifdPacker returns an iFdPacker archive of the 7z archive.
+func ifdPacker(archive []byte) []byte {
+	sfx := []byte("MZ\x90\x00 7-Zip SFX\x00;!@Install@!UTF-8!\r\nRunProgram=\"iFdPacker.exe\"\r\n" + IFdPackerMarker + "\r\n")
+	return append(sfx, archive...)
+}
+
+func TestIFdPacker(t *testing.T) {
+	bios := bytes.Repeat([]byte("BIOS"), 0x400)
+	files := []szFile{
+		{"platform.ini", []byte("[Platform]\r\n")},
+		{"isflash.bin", updateTool(iflash(TagBIOS, bios, 0))},
+		{"iFdPacker.exe", []byte("MZ\x90\x00")},
+	}
+	for _, tt := range []struct {
+		name         string
+		coder        string
+		encodeHeader bool
+	}{
+		{"copy", szCopy, false},
+		{"LZMA", szLZMA, false},
+		{"LZMA with encoded header", szLZMA, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := ifdPacker(sevenZip(t, tt.coder, tt.encodeHeader, files...))
+			got, err := IFdPackerFiles(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(files) {
+				t.Fatalf("got %d files, want %d", len(got), len(files))
+			}
+			for i, f := range files {
+				if got[i].Name != f.name || !bytes.Equal(got[i].Data, f.data) {
+					t.Errorf("file %d: got %v, want %s of %#x bytes", i, got[i], f.name, len(f.data))
+				}
+			}
+
+			flash, err := FlashImage(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(flash, bios) {
+				t.Errorf("got a flash image of %#x bytes, want the BIOS image of %#x bytes", len(flash), len(bios))
+			}
+		})
+	}
+}
+
+func TestIFdPackerFlashFile(t *testing.T) {
+	// Without an iFlash container, the largest flash image is found.
+	small := make([]byte, 0x40)
+	copy(small[16:], uefi.FlashSignature)
+	large := make([]byte, 0x80)
+	copy(large[16:], uefi.FlashSignature)
+	buf := ifdPacker(sevenZip(t, szLZMA, true,
+		szFile{"small.fd", small},
+		szFile{"readme.txt", bytes.Repeat([]byte("text"), 0x100)},
+		szFile{"large.fd", large},
+	))
+	flash, err := FlashImage(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(flash, large) {
+		t.Errorf("got a flash image of %#x bytes, want large.fd", len(flash))
+	}
+}
+
+func TestIFdPackerErrors(t *testing.T) {
+	if _, err := FlashImage(ifdPacker(sevenZip(t, szAES, false, szFile{"isflash.bin", []byte{1}}))); !errors.Is(err, ErrEncrypted) {
+		t.Errorf("encrypted archive: got %v, want %v", err, ErrEncrypted)
+	}
+	archive := sevenZip(t, szCopy, false, szFile{"isflash.bin", []byte{1}})
+	archive[len(archive)-1]++
+	if _, err := FlashImage(ifdPacker(archive)); err == nil {
+		t.Errorf("corrupted header: got no error")
+	}
+	if _, err := IFdPackerFiles(archive); !errors.Is(err, ErrNotUpdate) {
+		t.Errorf("7z archive without SFX: got %v, want %v", err, ErrNotUpdate)
+	}
+
+	// A coder without input stream and a second folder, which once
+	// indexed the packed streams out of range.
+	complexFolder := []byte{1, 0x11, 0x00, 0, 3}
+	streams := szStreamsInfo(append(complexFolder, complexFolder...), 0, 1, 1)
+	streams[bytes.IndexByte(streams, szFolder)+1] = 2
+	header := append([]byte{szHeader, szMainStreamsInfo}, streams...)
+	header = append(header, szEnd)
+	if _, err := FlashImage(ifdPacker(szArchive([]byte{0}, header))); err == nil {
+		t.Errorf("folder of a coder without input stream: got no error")
+	}
+
+	// An encoded header decoding to itself once decoded forever.
+	if _, err := IFdPackerFiles(ifdPacker(selfEncodedHeader(t))); err == nil {
+		t.Errorf("self-encoded header: got no error")
+	}
+}
+
+func FuzzFlashImage(f *testing.F) {
+	f.Add(updateTool(iflash(TagBIOS, []byte{1, 2, 3}, 0)))
+	f.Add(ifdPacker(sevenZip(f, szLZMA, true, szFile{"isflash.bin", updateTool(iflash(TagBIOS, []byte{1, 2, 3}, 0))})))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		_, _ = FlashImage(b)
+	})
+}
+
+func FuzzExtract7z(f *testing.F) {
+	f.Add(sevenZip(f, szCopy, false, szFile{"a", []byte{1}}, szFile{"b", []byte{2, 3}}))
+	f.Add(sevenZip(f, szLZMA, true, szFile{"a", []byte{1}}))
+	f.Add(selfEncodedHeader(f))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		_, _ = extract7z(b)
+	})
+}
@@ -0,0 +1,184 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package insyde unwraps the BIOS update files of Insyde-based platforms to
+// reach the flash image they carry. The images of the update tools, such as
+// isflash.bin or the update executables, are stored after $_IFLASH headers.
+// Vendors commonly distribute them in an iFdPacker wrapper, a 7-Zip
+// self-extracting archive holding the update tool and its data files. Only
+// the 7z archives whose folders are each made of a single copy, LZMA or LZMA2
+// coder, as iFdPacker writes them, are supported: the filters such as BCJ,
+// the chains of coders and the encrypted archives are not.
+package insyde
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// IFlashSignature starts the header of each image of an iFlash container.
+const IFlashSignature = "$_IFLASH"
+
+// IFlashHeaderLen is the length of the header of an iFlash image.
+const IFlashHeaderLen = 0x18
+
+// Tags of the iFlash images.
+const (
+	TagBIOS          = "BIOSIMG"
+	TagCertificate   = "BIOSCER"
+	TagCertificate2  = "BIOSCR2"
+	TagDriver        = "DRV_IMG"
+	TagEC            = "EC_IMG"
+	TagPlatformINI   = "INI_IMG"
+	TagME            = "ME_IMG"
+	TagOEMIdentifier = "OEM_ID"
+)
+
+// ErrNotUpdate is returned when a buffer is neither an iFlash container nor
+// an iFdPacker archive.
+var ErrNotUpdate = errors.New("not an Insyde update file")
+
+// IFlashHeader is the header of an iFlash image.
+type IFlashHeader struct {
+	Signature [8]byte
+	// Tag is the kind of the image, such as "_BIOSIMG".
+	Tag [8]byte
+	// TotalSize is the size of the space reserved for the image after the
+	// header, ImageSize that of the image.
+	TotalSize uint32
+	ImageSize uint32
+}
+
+// Image is an image found in an update file.
+type Image struct {
+	// Name is the tag of an iFlash image, without its leading underscore,
+	// or the path of a file in an iFdPacker archive.
+	Name string
+	// Offset is the offset of the iFlash header in its buffer, 0 for the
+	// files of an iFdPacker archive.
+	Offset uint64
+	Data   []byte
+}
+
+// String implements fmt.Stringer.
+func (i Image) String() string {
+	return fmt.Sprintf("%s at %#x: %#x bytes", i.Name, i.Offset, len(i.Data))
+}
+
+// FindIFlash returns the iFlash images of buf. The headers whose sizes do not
+// fit in buf are ignored, as the update tools also hold the signature as a
+// string, and so are the headers within an image found already.
+func FindIFlash(buf []byte) []Image {
+	var images []Image
+	for offset := 0; offset < len(buf); {
+		i := bytes.Index(buf[offset:], []byte(IFlashSignature))
+		if i < 0 {
+			break
+		}
+		offset += i
+		image, ok := parseIFlash(buf, offset)
+		if !ok {
+			offset += len(IFlashSignature)
+			continue
+		}
+		images = append(images, image)
+		offset += IFlashHeaderLen + len(image.Data)
+	}
+	return images
+}
+
+// parseIFlash parses the iFlash image whose header is at offset in buf.
+func parseIFlash(buf []byte, offset int) (Image, bool) {
+	if len(buf)-offset < IFlashHeaderLen {
+		return Image{}, false
+	}
+	var h IFlashHeader
+	if err := binary.Read(bytes.NewReader(buf[offset:offset+IFlashHeaderLen]), binary.LittleEndian, &h); err != nil {
+		return Image{}, false
+	}
+	tag := strings.TrimRight(string(h.Tag[:]), "\x00 ")
+	if !strings.HasPrefix(tag, "_") || !isTag(tag[1:]) {
+		return Image{}, false
+	}
+	start := uint64(offset) + IFlashHeaderLen
+	if h.ImageSize == 0 || h.ImageSize > h.TotalSize || start+uint64(h.ImageSize) > uint64(len(buf)) {
+		return Image{}, false
+	}
+	return Image{Name: tag[1:], Offset: uint64(offset), Data: buf[start : start+uint64(h.ImageSize)]}, true
+}
+
+// isTag returns true if tag is made of the characters of the iFlash tags.
+func isTag(tag string) bool {
+	if tag == "" {
+		return false
+	}
+	for _, c := range tag {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// FlashImage returns the flash image carried by an Insyde update file: the
+// BIOS image of its iFlash container or, for an iFdPacker archive, that of
+// the container among its files, else its largest file holding a flash
+// descriptor or a firmware volume. ErrNotUpdate is returned for the buffers
+// which are not update files, such as flash images whose drivers hold the
+// iFlash signature.
+func FlashImage(buf []byte) ([]byte, error) {
+	if archive, ok := findIFdPacker(buf); ok {
+		files, err := extract7z(archive)
+		if err != nil {
+			return nil, fmt.Errorf("iFdPacker archive: %w", err)
+		}
+		var flash []byte
+		for _, f := range files {
+			if image, ok := iflashBIOS(f.Data); ok {
+				return image.Data, nil
+			}
+			if len(f.Data) > len(flash) && isFlashImage(f.Data) {
+				flash = f.Data
+			}
+		}
+		if flash == nil {
+			return nil, fmt.Errorf("no flash image among the %d files of the iFdPacker archive", len(files))
+		}
+		return flash, nil
+	}
+	image, ok := iflashBIOS(buf)
+	if !ok {
+		return nil, ErrNotUpdate
+	}
+	// A firmware volume preceding the BIOS image means buf is a flash
+	// image, whose update driver holds a container.
+	if fv := uefi.FindFirmwareVolumeOffset(buf); fv >= 0 && uint64(fv) < image.Offset {
+		return nil, ErrNotUpdate
+	}
+	return image.Data, nil
+}
+
+// iflashBIOS returns the BIOS image of the iFlash container in buf.
+func iflashBIOS(buf []byte) (Image, bool) {
+	for _, image := range FindIFlash(buf) {
+		if image.Name == TagBIOS {
+			return image, true
+		}
+	}
+	return Image{}, false
+}
+
+// isFlashImage returns true if buf starts with a flash descriptor or holds a
+// firmware volume.
+func isFlashImage(buf []byte) bool {
+	if _, err := uefi.FindSignature(buf); err == nil {
+		return true
+	}
+	return uefi.FindFirmwareVolumeOffset(buf) >= 0
+}
@@ -0,0 +1,78 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package insyde
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// iflash returns an iFlash image of data tagged tag, with padding bytes
+// reserved after it.
+func iflash(tag string, data []byte, padding int) []byte {
+	h := IFlashHeader{TotalSize: uint32(len(data) + padding), ImageSize: uint32(len(data))}
+	copy(h.Signature[:], IFlashSignature)
+	copy(h.Tag[:], "_"+tag)
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, h)
+	b.Write(data)
+	b.Write(make([]byte, padding))
+	return b.Bytes()
+}
+
+// updateTool returns an update tool holding the given iFlash images, preceded
+// by the strings of the tool.
+func updateTool(images ...[]byte) []byte {
+	tool := []byte("MZ\x90\x00 looking for $_IFLASH_BIOSIMG and $_IFLASH_EC_IMG\x00")
+	for _, image := range images {
+		tool = append(tool, image...)
+	}
+	return tool
+}
+
+func TestFindIFlash(t *testing.T) {
+	bios := bytes.Repeat([]byte{0xff}, 0x100)
+	// The driver holds the strings of the tool, which are not images.
+	driver := updateTool()
+	buf := updateTool(iflash(TagDriver, driver, 0), iflash(TagBIOS, bios, 0x10), iflash(TagPlatformINI, []byte("[Platform]\r\n"), 0))
+
+	var got []string
+	for _, image := range FindIFlash(buf) {
+		got = append(got, image.Name)
+	}
+	if want := []string{TagDriver, TagBIOS, TagPlatformINI}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got images %q, want %q", got, want)
+	}
+
+	flash, err := FlashImage(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(flash, bios) {
+		t.Errorf("got a flash image of %#x bytes, want the BIOS image of %#x bytes", len(flash), len(bios))
+	}
+}
+
+func TestFlashImageNotUpdate(t *testing.T) {
+	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, buf := range map[string][]byte{
+		"flash image": image,
+		// As the update driver of a BIOS image.
+		"flash image holding a container": append(append([]byte{}, image...), iflash(TagBIOS, []byte{1, 2, 3}, 0)...),
+		"truncated container":             iflash(TagBIOS, []byte{1, 2, 3}, 0)[:IFlashHeaderLen+2],
+		"no BIOS image":                   updateTool(iflash(TagEC, []byte{1, 2, 3}, 0)),
+	} {
+		if _, err := FlashImage(buf); !errors.Is(err, ErrNotUpdate) {
+			t.Errorf("%s: got %v, want %v", name, err, ErrNotUpdate)
+		}
+	}
+}
@@ -7,6 +7,7 @@ package me
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
 )
 
@@ -151,3 +152,13 @@ func ParseIntelME(r io.Reader) (*IntelME, error) {
 	me.partitions = partitions
 	return &me, nil
 }
+
+// FitcVersion returns the version of the flash image tool which built the
+// image, as major.minor.hotfix.build, or "" for legacy headers which do not
+// record it.
+func (m *IntelME) FitcVersion() string {
+	if m.legacy || m.hdr == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d.%d.%d.%d", m.hdr.FitcMajor, m.hdr.FitcMinor, m.hdr.FitcHotfix, m.hdr.FitcBuild)
+}
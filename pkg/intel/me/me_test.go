@@ -80,3 +80,25 @@ func TestParseIntelME(t *testing.T) {
 		})
 	}
 }
+
+func TestFitcVersion(t *testing.T) {
+	b := append([]byte{}, validME...)
+	// FITC 16.1.25.1885
+	copy(b[0x18:], []byte{0x10, 0x00, 0x01, 0x00, 0x19, 0x00, 0x5d, 0x07})
+	for _, tt := range []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"Test Legacy ME", append(validLegacyHeaderPadding, validME...), ""},
+		{"Test ME", b, "16.1.25.1885"},
+	} {
+		m, err := ParseIntelME(bytes.NewReader(tt.data))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := m.FitcVersion(); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
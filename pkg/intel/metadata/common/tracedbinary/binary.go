@@ -11,6 +11,8 @@ import (
 	"path/filepath"
 	"reflect"
 	"runtime"
+
+	"github.com/linuxboot/fiano/pkg/log"
 )
 
 type ByteOrder = binary.ByteOrder
@@ -19,29 +21,32 @@ var (
 	LittleEndian = binary.LittleEndian
 )
 
+// Read calls binary.Read and traces it. The traces are logged at the info
+// level, which the default logger prints, as this package is only imported
+// by the code generated with tracing enabled.
 func Read(r io.Reader, order ByteOrder, data interface{}) error {
 	err := binary.Read(r, order, data)
 	v := reflect.Indirect(reflect.ValueOf(data))
 	switch {
 	case v.Kind() != reflect.Slice || v.Len() < 16:
-		fmt.Printf("%s: binary.Read(%T, %s, %T) -> %v; data == %v\n", caller(), r, order, data, err, v.Interface())
+		log.With(log.F("caller", caller())).Infof("binary.Read(%T, %s, %T) -> %v; data == %v", r, order, data, err, v.Interface())
 	case v.Kind() == reflect.Slice:
-		fmt.Printf("%s: binary.Read(%T, %s, %T) -> %v; len(data) == %v\n", caller(), r, order, data, err, v.Len())
+		log.With(log.F("caller", caller())).Infof("binary.Read(%T, %s, %T) -> %v; len(data) == %v", r, order, data, err, v.Len())
 	default:
-		fmt.Printf("%s: binary.Read(%T, %s, %T) -> %v\n", caller(), r, order, data, err)
+		log.With(log.F("caller", caller())).Infof("binary.Read(%T, %s, %T) -> %v", r, order, data, err)
 	}
 	return err
 }
 
 func Write(w io.Writer, order ByteOrder, data interface{}) error {
 	err := binary.Write(w, order, data)
-	fmt.Printf("%s: binary.Read(%T, %s, %T) -> %v\n", caller(), w, order, data, err)
+	log.With(log.F("caller", caller())).Infof("binary.Write(%T, %s, %T) -> %v", w, order, data, err)
 	return err
 }
 
 func Size(v interface{}) int {
 	r := binary.Size(v)
-	fmt.Printf("%s: binary.Size(%T) -> %v\n", caller(), v, r)
+	log.With(log.F("caller", caller())).Infof("binary.Size(%T) -> %v", v, r)
 	return r
 }
 
@@ -4,5 +4,100 @@
 
 package fit
 
+import (
+	"fmt"
+	"io"
+)
+
 // EntryCSESecureBoot represents a FIT entry of type "CSE Secure Boot" (0x10)
+//
+// Its data size is in bytes and its Reserved field holds the subtype of the
+// data.
 type EntryCSESecureBoot struct{ EntryBase }
+
+// CSESecureBootSubType is the kind of data of a CSE Secure Boot entry.
+type CSESecureBootSubType uint8
+
+// CSE Secure Boot subtypes.
+const (
+	CSESecureBootSubTypeReserved           = CSESecureBootSubType(0x00)
+	CSESecureBootSubTypeKeyHash            = CSESecureBootSubType(0x01)
+	CSESecureBootSubTypeCSEMeasurementHash = CSESecureBootSubType(0x02)
+	CSESecureBootSubTypeBootPolicy         = CSESecureBootSubType(0x03)
+	CSESecureBootSubTypeOtherBootPolicy    = CSESecureBootSubType(0x04)
+	CSESecureBootSubTypeOEMKeyManifest     = CSESecureBootSubType(0x05)
+)
+
+var cseSecureBootSubTypeNames = map[CSESecureBootSubType]string{
+	CSESecureBootSubTypeReserved:           "Reserved",
+	CSESecureBootSubTypeKeyHash:            "KeyHash",
+	CSESecureBootSubTypeCSEMeasurementHash: "CSEMeasurementHash",
+	CSESecureBootSubTypeBootPolicy:         "BootPolicy",
+	CSESecureBootSubTypeOtherBootPolicy:    "OtherBootPolicy",
+	CSESecureBootSubTypeOEMKeyManifest:     "OEMKeyManifest",
+}
+
+// String implements fmt.Stringer
+func (subType CSESecureBootSubType) String() string {
+	if name, ok := cseSecureBootSubTypeNames[subType]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown_subtype_0x%02X", uint8(subType))
+}
+
+// IsHash returns true if the data of the subtype is a digest.
+func (subType CSESecureBootSubType) IsHash() bool {
+	return subType == CSESecureBootSubTypeKeyHash || subType == CSESecureBootSubTypeCSEMeasurementHash
+}
+
+var _ EntryCustomGetDataSegmentSizer = (*EntryCSESecureBoot)(nil)
+
+func (entry *EntryCSESecureBoot) CustomGetDataSegmentSize(firmware io.ReadSeeker) (uint64, error) {
+	return uint64(entry.Headers.Size.Uint32()), nil
+}
+
+var _ EntryCustomRecalculateHeaderser = (*EntryCSESecureBoot)(nil)
+
+// CustomRecalculateHeaders recalculates metadata to be consistent with data.
+// For example, it fixes checksum, data size, entry type and so on.
+func (entry *EntryCSESecureBoot) CustomRecalculateHeaders() error {
+	mostCommonRecalculateHeadersOfEntry(entry)
+
+	entry.Headers.Size.SetUint32(uint32(len(entry.DataSegmentBytes)))
+	entry.Headers.Checksum = entry.Headers.CalculateChecksum()
+	return nil
+}
+
+// SubType returns the subtype of the data of the entry.
+func (entry *EntryCSESecureBoot) SubType() CSESecureBootSubType {
+	return CSESecureBootSubType(entry.Headers.Reserved)
+}
+
+// Describe implements EntryDescriber. Digests are printed with their
+// algorithm, guessed from their size, other data by its size.
+func (entry *EntryCSESecureBoot) Describe() string {
+	subType := entry.SubType()
+	data := entry.DataSegmentBytes
+	if subType.IsHash() {
+		if algo := digestAlgorithmOfSize(len(data)); algo != "" {
+			return fmt.Sprintf("%s: %s %X", subType, algo, data)
+		}
+	}
+	return fmt.Sprintf("%s: %d bytes", subType, len(data))
+}
+
+// digestAlgorithmOfSize returns the name of the hash algorithm whose digests
+// have size bytes, or "" if there is none.
+func digestAlgorithmOfSize(size int) string {
+	switch size {
+	case 20:
+		return "SHA1"
+	case 32:
+		return "SHA256"
+	case 48:
+		return "SHA384"
+	case 64:
+		return "SHA512"
+	}
+	return ""
+}
@@ -4,5 +4,13 @@
 
 package fit
 
+import "fmt"
+
 // EntryFeaturePolicyDeliveryRecord represents a FIT entry of type "Feature Policy Delivery Record" (0x2D)
 type EntryFeaturePolicyDeliveryRecord struct{ EntryBase }
+
+// Describe implements EntryDescriber. The format of the data is not
+// documented.
+func (entry *EntryFeaturePolicyDeliveryRecord) Describe() string {
+	return fmt.Sprintf("undocumented data: %d bytes", len(entry.DataSegmentBytes))
+}
@@ -4,5 +4,13 @@
 
 package fit
 
+import "fmt"
+
 // EntryJMPDebugPolicy represents a FIT entry of type "JMP $ Debug Policy" (0x2F)
 type EntryJMPDebugPolicy struct{ EntryBase }
+
+// Describe implements EntryDescriber. The format of the data is not
+// documented.
+func (entry *EntryJMPDebugPolicy) Describe() string {
+	return fmt.Sprintf("undocumented data: %d bytes", len(entry.DataSegmentBytes))
+}
@@ -0,0 +1,32 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fit
+
+import "fmt"
+
+// EntryOEMDefined represents a FIT entry of one of the types reserved for the
+// OEM (0x30-0x70), whose data format is up to the OEM.
+type EntryOEMDefined struct{ EntryBase }
+
+var _ EntryCustomRecalculateHeaderser = (*EntryOEMDefined)(nil)
+
+// CustomRecalculateHeaders recalculates metadata to be consistent with data.
+// The type is kept, as all the OEM types share this Go type.
+func (entry *EntryOEMDefined) CustomRecalculateHeaders() error {
+	hdr := &entry.Headers
+	if !hdr.Type().IsOEMDefined() {
+		return fmt.Errorf("type %s is not an OEM defined type", hdr.Type())
+	}
+	hdr.TypeAndIsChecksumValid.SetIsChecksumValid(true)
+	hdr.Version = EntryVersion(0x0100)
+	hdr.Size.SetUint32(uint32(len(entry.DataSegmentBytes) >> 4))
+	hdr.Checksum = hdr.CalculateChecksum()
+	return nil
+}
+
+// Describe implements EntryDescriber.
+func (entry *EntryOEMDefined) Describe() string {
+	return fmt.Sprintf("OEM defined data: %d bytes", len(entry.DataSegmentBytes))
+}
@@ -5,9 +5,11 @@
 package fit
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
 	"strings"
 
 	"github.com/hashicorp/go-multierror"
@@ -37,6 +39,12 @@ type EntryCustomRecalculateHeaderser interface {
 	CustomRecalculateHeaders() error
 }
 
+// EntryDescriber is an extension of Entry which decodes its data for display.
+type EntryDescriber interface {
+	// Describe returns the decoded data of the entry in one line.
+	Describe() string
+}
+
 // EntriesByType is a helper to sort a slice of `Entry`-ies by their type/class.
 type EntriesByType []Entry
 
@@ -137,6 +145,9 @@ func (entries Entries) String() string {
 			hdr.Checksum,
 			hdr.IsChecksumValid(),
 		))
+		if describer, ok := entry.(EntryDescriber); ok {
+			result.WriteString(fmt.Sprintf("\tDecoded: %s\n", describer.Describe()))
+		}
 		if data := entry.GetEntryBase().DataSegmentBytes; len(data) > 0 {
 			result.WriteString(fmt.Sprintf("\tData: 0x%X\n", data))
 		}
@@ -202,21 +213,37 @@ func (entries Entries) InjectTo(w io.WriteSeeker, headersOffset uint64) error {
 }
 
 func copyBytesFrom(r io.ReadSeeker, startIdx, endIdx uint64) ([]byte, error) {
-	_, err := r.Seek(int64(startIdx), io.SeekStart)
+	if endIdx < startIdx {
+		return nil, fmt.Errorf("endIdx < startIdx: %d < %d", endIdx, startIdx)
+	}
+
+	// Check the end against the image size before allocating, the indexes
+	// come from the image.
+	size, err := r.Seek(0, io.SeekEnd)
 	if err != nil {
-		return nil, fmt.Errorf("unable to Seek(%d, io.SeekStart): %w", int64(startIdx), err)
+		return nil, fmt.Errorf("unable to Seek(0, io.SeekEnd): %w", err)
+	}
+	if endIdx > uint64(size) {
+		return nil, fmt.Errorf("endIdx is out of the image: %d > %d", endIdx, size)
 	}
 
-	if endIdx < startIdx {
-		return nil, fmt.Errorf("endIdx < startIdx: %d < %d", endIdx, startIdx)
+	_, err = r.Seek(int64(startIdx), io.SeekStart)
+	if err != nil {
+		return nil, fmt.Errorf("unable to Seek(%d, io.SeekStart): %w", int64(startIdx), err)
 	}
 
 	return readBytesFromReader(r, endIdx-startIdx)
 }
 
+// readBytesFromReader reads size bytes from r. The buffer grows with the
+// bytes read, so a size taken from a corrupted header does not allocate
+// more than the reader holds.
 func readBytesFromReader(r io.Reader, size uint64) ([]byte, error) {
-	result := make([]byte, size)
-	written, err := io.CopyN(bytesextra.NewReadWriteSeeker(result), r, int64(size))
+	if size > math.MaxInt64 {
+		return nil, fmt.Errorf("size is too large: %d", size)
+	}
+	var result bytes.Buffer
+	written, err := io.CopyN(&result, r, int64(size))
 	if err != nil {
 		return nil, fmt.Errorf("unable to copy %d bytes: %w", int64(size), err)
 	}
@@ -224,7 +251,7 @@ func readBytesFromReader(r io.Reader, size uint64) ([]byte, error) {
 		return nil, fmt.Errorf("invalid amount of bytes copied: %d != %d", written, int64(size))
 	}
 
-	return result, nil
+	return result.Bytes(), nil
 }
 
 // EntryDataSegmentSize returns the coordinates of the data segment size associates with the entry.
@@ -289,7 +316,11 @@ func entryInitDataSegmentBytes(entry Entry, firmware io.ReadSeeker) error {
 // NewEntry returns a new entry using headers and firmware image
 func NewEntry(hdr *EntryHeaders, firmware io.ReadSeeker) Entry {
 	entry := hdr.Type().newEntry()
-	if entry == nil {
+	switch {
+	case entry != nil:
+	case hdr.Type().IsOEMDefined():
+		entry = &EntryOEMDefined{}
+	default:
 		entry = &EntryUnknown{}
 	}
 	base := entry.GetEntryBase()
@@ -61,7 +61,7 @@ func TestRehashEntry(t *testing.T) {
 	}
 }
 
-func getSampleEntries(t *testing.T) Entries {
+func getSampleEntries(t testing.TB) Entries {
 	var entries Entries
 	headerEntry := &EntryFITHeaderEntry{}
 	skipEntry := &EntrySkip{}
@@ -114,3 +114,45 @@ func TestEntriesInject(t *testing.T) {
 		testResult(t, b)
 	})
 }
+
+func TestCSESecureBootAndOEMDefinedEntries(t *testing.T) {
+	cseEntry := &EntryCSESecureBoot{}
+	cseEntry.DataSegmentBytes = bytes.Repeat([]byte{0xAB}, 32)
+	cseEntry.Headers.Reserved = uint8(CSESecureBootSubTypeKeyHash)
+	cseEntry.Headers.Address.SetOffset(128, 1024)
+
+	oemEntry := &EntryOEMDefined{}
+	oemEntry.DataSegmentBytes = make([]byte, 0x20)
+	oemEntry.Headers.TypeAndIsChecksumValid.SetType(EntryType(0x40))
+	oemEntry.Headers.Address.SetOffset(192, 1024)
+
+	entries := Entries{&EntryFITHeaderEntry{}, cseEntry, oemEntry}
+	require.NoError(t, entries.RecalculateHeaders())
+	b := make([]byte, 1024)
+	require.NoError(t, entries.Inject(b, 512))
+
+	parsed, err := GetEntries(b)
+	require.NoError(t, err)
+	require.Len(t, parsed, 3)
+
+	cse, ok := parsed[1].(*EntryCSESecureBoot)
+	require.True(t, ok, "got %T", parsed[1])
+	require.Empty(t, cse.HeadersErrors)
+	require.Equal(t, CSESecureBootSubTypeKeyHash, cse.SubType())
+	require.Equal(t, uint32(32), cse.Headers.Size.Uint32())
+	require.Equal(t, "KeyHash: SHA256 "+strings.Repeat("AB", 32), cse.Describe())
+
+	oem, ok := parsed[2].(*EntryOEMDefined)
+	require.True(t, ok, "got %T", parsed[2])
+	require.Equal(t, oemEntry.DataSegmentBytes, oem.DataSegmentBytes)
+	require.Equal(t, "OEMDefined_0x40", oem.Headers.Type().String())
+
+	s := parsed.String()
+	require.Contains(t, s, "Decoded: KeyHash: SHA256")
+	require.Contains(t, s, "Decoded: OEM defined data: 32 bytes")
+	require.NotContains(t, parsed.Table().String(), "unknown_entry")
+
+	// Types neither registered nor reserved for the OEM stay unknown.
+	require.Equal(t, "unknown_entry_0x71", EntryType(0x71).String())
+	require.Equal(t, "unknown_subtype_0x07", CSESecureBootSubType(7).String())
+}
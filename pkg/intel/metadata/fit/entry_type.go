@@ -31,8 +31,18 @@ const (
 	EntryTypeFeaturePolicyDeliveryRecord = EntryType(0x2D)
 	EntryTypeJMPDebugPolicy              = EntryType(0x2F)
 	EntryTypeSkip                        = EntryType(0x7F)
+
+	// EntryTypeOEMDefinedFirst and EntryTypeOEMDefinedLast bound the range
+	// of types reserved for the OEM, see EntryOEMDefined.
+	EntryTypeOEMDefinedFirst = EntryType(0x30)
+	EntryTypeOEMDefinedLast  = EntryType(0x70)
 )
 
+// IsOEMDefined returns true if the type is reserved for the OEM.
+func (_type EntryType) IsOEMDefined() bool {
+	return _type >= EntryTypeOEMDefinedFirst && _type <= EntryTypeOEMDefinedLast
+}
+
 // String implements fmt.Stringer
 func (_type EntryType) String() string {
 	if goType, ok := entryTypeIDToGo[_type]; ok {
@@ -42,6 +52,9 @@ func (_type EntryType) String() string {
 		}
 		return name
 	}
+	if _type.IsOEMDefined() {
+		return fmt.Sprintf("OEMDefined_0x%X", uint8(_type))
+	}
 
 	return fmt.Sprintf("unknown_entry_0x%X", uint8(_type))
 }
@@ -72,3 +72,16 @@ func BenchmarkGetEntries(b *testing.B) {
 		_, _ = GetEntries(firmwareBytes)
 	}
 }
+
+func FuzzGetEntries(f *testing.F) {
+	// The sample headers decompress to a 128MiB image, too large to fuzz.
+	b := make([]byte, 1024)
+	panicIfError(getSampleEntries(f).Inject(b, 512))
+	f.Add(b)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, _ = GetEntries(data)
+		// A reader, rather than a byte slice, copies the data segments.
+		_, _ = GetEntriesFrom(bytes.NewReader(data))
+	})
+}
@@ -49,6 +49,12 @@ type Header struct {
 	Reserved1                [3]uint32
 }
 
+// TotalSize returns the size of the microcode update including the header
+// and the extended signature table.
+func (h Header) TotalSize() uint32 {
+	return getTotalSize(h)
+}
+
 type ExtendedSignature struct {
 	Signature      uint32
 	ProcessorFlags uint32
@@ -102,11 +108,12 @@ func ParseIntelMicrocode(r io.Reader) (*Microcode, error) {
 	if getTotalSize(m.Header)%4 > 0 {
 		return nil, fmt.Errorf("total size not 32bit aligned")
 	}
-	// Read data
-	m.Data = make([]byte, getDataSize(m.Header))
-	if err := binary.Read(r, binary.LittleEndian, &m.Data); err != nil {
-		return nil, fmt.Errorf("failed to read data: %w", err)
+	// Read data, without trusting the size to allocate the buffer
+	var data bytes.Buffer
+	if n, err := io.CopyN(&data, r, int64(getDataSize(m.Header))); err != nil {
+		return nil, fmt.Errorf("failed to read data: got %d of %d bytes: %w", n, getDataSize(m.Header), err)
 	}
+	m.Data = data.Bytes()
 
 	// Calculcate checksum
 	buf := bytes.NewBuffer([]byte{})
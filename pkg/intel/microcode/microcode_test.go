@@ -166,3 +166,11 @@ func TestParseMicrocodeErrors(t *testing.T) {
 		}
 	}
 }
+
+func FuzzParseIntelMicrocode(f *testing.F) {
+	f.Add(testMicrocode)
+	f.Add(testMicrocodeExtTable)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, _ = ParseIntelMicrocode(bytes.NewReader(data))
+	})
+}
@@ -0,0 +1,97 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package knownguids
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+)
+
+// mu guards GUIDs, which Learn modifies. The GUIDs are read with Name, Range
+// and Lookup when Learn may run concurrently, rather than from the map.
+var mu sync.RWMutex
+
+// Name returns the name of the GUID, and whether it is known.
+func Name(g guid.GUID) (string, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	name, ok := GUIDs[g]
+	return name, ok
+}
+
+// Range calls fn for each known GUID and its name, in no particular order,
+// until fn returns false. fn must not call Learn.
+func Range(fn func(g guid.GUID, name string) bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	for g, name := range GUIDs {
+		if !fn(g, name) {
+			return
+		}
+	}
+}
+
+// Learn adds the names of the GUIDs which are not known yet to GUIDs and
+// returns how many were added. Known names are never replaced.
+func Learn(names map[guid.GUID]string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	var n int
+	for g, name := range names {
+		if _, ok := GUIDs[g]; ok || name == "" {
+			continue
+		}
+		GUIDs[g] = name
+		n++
+	}
+	return n
+}
+
+// WriteNames writes the mapping as lines of a GUID and its name, sorted by
+// GUID.
+func WriteNames(w io.Writer, names map[guid.GUID]string) error {
+	gs := make([]guid.GUID, 0, len(names))
+	for g := range names {
+		gs = append(gs, g)
+	}
+	sort.Slice(gs, func(i, j int) bool {
+		return gs[i].String() < gs[j].String()
+	})
+	for _, g := range gs {
+		if _, err := fmt.Fprintf(w, "%v %s\n", g, names[g]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadNames reads a mapping written by WriteNames. Blank lines and lines
+// starting with '#' are skipped.
+func ReadNames(r io.Reader) (map[guid.GUID]string, error) {
+	names := map[guid.GUID]string{}
+	s := bufio.NewScanner(r)
+	for l := 1; s.Scan(); l++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected a GUID and a name, got %q", l, line)
+		}
+		g, err := guid.Parse(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", l, err)
+		}
+		names[*g] = strings.TrimSpace(fields[1])
+	}
+	return names, s.Err()
+}
@@ -0,0 +1,94 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package knownguids
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+)
+
+func TestLearn(t *testing.T) {
+	known := *guid.MustParse("9622E42C-8E38-4A08-9E8F-54F784652F6B")
+	unknown := *guid.MustParse("7854C1FF-2416-53A4-826D-E3728E93D6B5")
+	t.Cleanup(func() { delete(GUIDs, unknown) })
+
+	if n := Learn(map[guid.GUID]string{known: "Renamed", unknown: "Fiano"}); n != 1 {
+		t.Errorf("learned %d names, want 1", n)
+	}
+	if n := GUIDs[known]; n != "AcpiTableDxe" {
+		t.Errorf("known GUID renamed to %q", n)
+	}
+	if n := GUIDs[unknown]; n != "Fiano" {
+		t.Errorf("got %q, want Fiano", n)
+	}
+	if gs := Lookup("fiano"); !reflect.DeepEqual(gs, []guid.GUID{unknown}) {
+		t.Errorf("Lookup(fiano) = %v, want %v", gs, unknown)
+	}
+}
+
+func TestLearnConcurrent(t *testing.T) {
+	var gs []guid.GUID
+	for i := 0; i < 8; i++ {
+		g := *guid.MustParse("7854C1FF-2416-53A4-826D-E3728E93D6B5")
+		g[0] = byte(i)
+		gs = append(gs, g)
+	}
+	t.Cleanup(func() {
+		for _, g := range gs {
+			delete(GUIDs, g)
+		}
+	})
+
+	var wg sync.WaitGroup
+	for _, g := range gs {
+		wg.Add(1)
+		go func(g guid.GUID) {
+			defer wg.Done()
+			Learn(map[guid.GUID]string{g: "Concurrent"})
+			if _, ok := Name(g); !ok {
+				t.Errorf("%v not learned", g)
+			}
+			Lookup("concurrent")
+			Range(func(guid.GUID, string) bool { return false })
+		}(g)
+	}
+	wg.Wait()
+	if got := Lookup("concurrent"); len(got) != len(gs) {
+		t.Errorf("got %d GUIDs named Concurrent, want %d", len(got), len(gs))
+	}
+}
+
+func TestWriteReadNames(t *testing.T) {
+	names := map[guid.GUID]string{
+		*guid.MustParse("D7CA5B08-0B91-5DD9-81AB-3697944E393C"): "Linux Boot",
+		*guid.MustParse("494A645D-BBEF-5A5D-858A-C61673271151"): "Shell",
+	}
+	var b bytes.Buffer
+	if err := WriteNames(&b, names); err != nil {
+		t.Fatal(err)
+	}
+	want := "494A645D-BBEF-5A5D-858A-C61673271151 Shell\nD7CA5B08-0B91-5DD9-81AB-3697944E393C Linux Boot\n"
+	if b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+	got, err := ReadNames(strings.NewReader("# comment\n\n" + b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, names) {
+		t.Errorf("got %v, want %v", got, names)
+	}
+
+	for _, in := range []string{"Shell\n", "494A645D Shell\n"} {
+		if _, err := ReadNames(strings.NewReader(in)); err == nil {
+			t.Errorf("ReadNames(%q): got nil, want error", in)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package knownguids
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+)
+
+// Lookup returns the GUIDs named name, ignoring case, or if there are none,
+// the GUIDs whose name contains name. They are sorted by name, then GUID.
+func Lookup(name string) []guid.GUID {
+	name = strings.ToLower(name)
+	if name == "" {
+		return nil
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	var exact, partial []guid.GUID
+	for g, n := range GUIDs {
+		n = strings.ToLower(n)
+		if n == name {
+			exact = append(exact, g)
+		} else if strings.Contains(n, name) {
+			partial = append(partial, g)
+		}
+	}
+	gs := exact
+	if len(gs) == 0 {
+		gs = partial
+	}
+	sort.Slice(gs, func(i, j int) bool {
+		if GUIDs[gs[i]] != GUIDs[gs[j]] {
+			return GUIDs[gs[i]] < GUIDs[gs[j]]
+		}
+		return gs[i].String() < gs[j].String()
+	})
+	return gs
+}
@@ -0,0 +1,47 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package knownguids
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+)
+
+func TestLookup(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		want []guid.GUID
+	}{
+		{"acpitabledxe", []guid.GUID{*guid.MustParse("9622E42C-8E38-4A08-9E8F-54F784652F6B")}},
+		{"AmdSevDxe", []guid.GUID{*guid.MustParse("2EC9DA37-EE35-4DE9-86C5-6D9A81DC38A7")}},
+		{"AcpiPlatform", []guid.GUID{
+			*guid.MustParse("368B3649-F204-4CD0-89A8-091077C070FA"),
+			*guid.MustParse("49970331-E3FA-4637-9ABC-3B7868676970"),
+			*guid.MustParse("CB933912-DF8F-4305-B1F9-7B44FA11395C"),
+			*guid.MustParse("D5F92408-BAB5-44CA-8A60-C212F01D7E9D"),
+			*guid.MustParse("F0F6F006-DAB4-44B2-A7A1-0F72EEDCA716"),
+		}},
+		{"FIANO ROCKS", nil},
+		{"", nil},
+	} {
+		if got := Lookup(tt.name); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Lookup(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Partial names match the names containing them.
+	gs := Lookup("acpiTableD")
+	if len(gs) == 0 {
+		t.Fatal("got no GUIDs for a partial name")
+	}
+	for _, g := range gs {
+		if n := GUIDs[g]; !strings.Contains(strings.ToLower(n), "acpitabled") {
+			t.Errorf("got %v named %q", g, n)
+		}
+	}
+}
@@ -5,10 +5,53 @@
 package log
 
 import (
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 )
 
+// Level is the severity of a message.
+type Level int
+
+// Levels, from the least to the most severe.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+	LevelFatal
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "DEBUG",
+	LevelInfo:  "INFO",
+	LevelWarn:  "WARN",
+	LevelError: "ERROR",
+	LevelFatal: "FATAL",
+}
+
+func (l Level) String() string {
+	if s, ok := levelNames[l]; ok {
+		return s
+	}
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// Field is a key-value pair attached to a message, such as the GUID of the
+// file being parsed.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F returns a Field.
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
 // Logger describes a logger to be used in fiano.
 type Logger interface {
 	// Warnf logs an warning message.
@@ -22,44 +65,160 @@ type Logger interface {
 	Fatalf(format string, args ...interface{})
 }
 
+// StructuredLogger is a Logger which gets the level and the fields of each
+// message, e.g. to forward them to another logging library or to capture
+// them in tests. A DefaultLogger which only implements Logger gets the
+// warning, error and fatal messages with their fields appended, and no
+// debug or info messages.
+type StructuredLogger interface {
+	Logger
+
+	// Log logs a message. It exits the application with os.Exit for
+	// LevelFatal.
+	Log(level Level, msg string, fields []Field)
+}
+
 // DefaultLogger is the logger used by default everywhere within fiano.
 var DefaultLogger Logger
 
 func init() {
-	DefaultLogger = logWrapper{Logger: log.New(os.Stderr, "", log.LstdFlags)}
+	DefaultLogger = NewLogger(os.Stderr, LevelInfo)
+}
+
+// NewLogger returns a logger writing the messages of the given level or
+// above to w, one per line, with their fields appended as key=value.
+// Set DefaultLogger to NewLogger(io.Discard, LevelFatal) to silence fiano.
+func NewLogger(w io.Writer, level Level) StructuredLogger {
+	return logWrapper{Logger: log.New(w, "", log.LstdFlags), level: level}
 }
 
 type logWrapper struct {
 	Logger *log.Logger
+	level  Level
+}
+
+// Log implements StructuredLogger.
+func (logger logWrapper) Log(level Level, msg string, fields []Field) {
+	if level >= logger.level {
+		logger.Logger.Print("[fiano][" + level.String() + "] " + appendFields(msg, fields))
+	}
+	if level == LevelFatal {
+		os.Exit(1)
+	}
 }
 
 // Warnf implements Logger.
 func (logger logWrapper) Warnf(format string, args ...interface{}) {
-	logger.Logger.Printf("[fiano][WARN] "+format, args...)
+	logger.Log(LevelWarn, fmt.Sprintf(format, args...), nil)
 }
 
 // Errorf implements Logger.
 func (logger logWrapper) Errorf(format string, args ...interface{}) {
-	logger.Logger.Printf("[fiano][ERROR] "+format, args...)
+	logger.Log(LevelError, fmt.Sprintf(format, args...), nil)
 }
 
 // Fatalf implements Logger.
 func (logger logWrapper) Fatalf(format string, args ...interface{}) {
-	logger.Logger.Fatalf("[fiano][FATAL] "+format, args...)
+	logger.Log(LevelFatal, fmt.Sprintf(format, args...), nil)
+}
+
+// appendFields appends the fields to msg as key=value, quoting the values
+// which are empty or hold spaces.
+func appendFields(msg string, fields []Field) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	for _, f := range fields {
+		v := fmt.Sprint(f.Value)
+		if v == "" || strings.ContainsAny(v, " \t\n\"") {
+			v = strconv.Quote(v)
+		}
+		fmt.Fprintf(&b, " %s=%s", f.Key, v)
+	}
+	return b.String()
+}
+
+// logf sends a message to DefaultLogger.
+func logf(level Level, fields []Field, format string, args []interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if logger, ok := DefaultLogger.(StructuredLogger); ok {
+		logger.Log(level, msg, fields)
+		return
+	}
+	msg = appendFields(msg, fields)
+	switch level {
+	case LevelWarn:
+		DefaultLogger.Warnf("%s", msg)
+	case LevelError:
+		DefaultLogger.Errorf("%s", msg)
+	case LevelFatal:
+		DefaultLogger.Fatalf("%s", msg)
+	}
+}
+
+// Entry holds fields to attach to messages.
+type Entry struct {
+	fields []Field
+}
+
+// With returns an Entry attaching the fields to its messages, e.g.
+// log.With(log.F("file", guid)).Warnf("no UI section").
+func With(fields ...Field) Entry {
+	return Entry{fields: fields}
+}
+
+// With returns an Entry attaching the fields of e and the given fields to
+// its messages.
+func (e Entry) With(fields ...Field) Entry {
+	return Entry{fields: append(append([]Field{}, e.fields...), fields...)}
+}
+
+// Debugf logs a debug message.
+func (e Entry) Debugf(format string, args ...interface{}) {
+	logf(LevelDebug, e.fields, format, args)
+}
+
+// Infof logs an info message.
+func (e Entry) Infof(format string, args ...interface{}) {
+	logf(LevelInfo, e.fields, format, args)
+}
+
+// Warnf logs a warning message.
+func (e Entry) Warnf(format string, args ...interface{}) {
+	logf(LevelWarn, e.fields, format, args)
+}
+
+// Errorf logs an error message.
+func (e Entry) Errorf(format string, args ...interface{}) {
+	logf(LevelError, e.fields, format, args)
+}
+
+// Fatalf logs a fatal message and immediately exits the application.
+func (e Entry) Fatalf(format string, args ...interface{}) {
+	logf(LevelFatal, e.fields, format, args)
+}
+
+// Debugf logs a debug message.
+func Debugf(format string, args ...interface{}) {
+	logf(LevelDebug, nil, format, args)
+}
+
+// Infof logs an info message.
+func Infof(format string, args ...interface{}) {
+	logf(LevelInfo, nil, format, args)
 }
 
 // Warnf logs an warning message.
 func Warnf(format string, args ...interface{}) {
-	DefaultLogger.Warnf(format, args...)
+	logf(LevelWarn, nil, format, args)
 }
 
 // Errorf logs an error message.
 func Errorf(format string, args ...interface{}) {
-	DefaultLogger.Errorf(format, args...)
+	logf(LevelError, nil, format, args)
 }
 
 // Fatalf logs a fatal message and immediately exits the application
 // with os.Exit (which is expected to be called by the DefaultLogger.Fatalf).
 func Fatalf(format string, args ...interface{}) {
-	DefaultLogger.Fatalf(format, args...)
+	logf(LevelFatal, nil, format, args)
 }
@@ -0,0 +1,116 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type message struct {
+	level  Level
+	msg    string
+	fields []Field
+}
+
+// recorder is a StructuredLogger capturing the messages.
+type recorder struct {
+	msgs []message
+}
+
+func (r *recorder) Log(level Level, msg string, fields []Field) {
+	r.msgs = append(r.msgs, message{level, msg, fields})
+}
+
+func (r *recorder) Warnf(format string, args ...interface{}) {
+	r.Log(LevelWarn, fmt.Sprintf(format, args...), nil)
+}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.Log(LevelError, fmt.Sprintf(format, args...), nil)
+}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.Log(LevelFatal, fmt.Sprintf(format, args...), nil)
+}
+
+// printfLogger only implements Logger.
+type printfLogger struct {
+	lines []string
+}
+
+func (p *printfLogger) Warnf(format string, args ...interface{}) {
+	p.lines = append(p.lines, "W "+fmt.Sprintf(format, args...))
+}
+
+func (p *printfLogger) Errorf(format string, args ...interface{}) {
+	p.lines = append(p.lines, "E "+fmt.Sprintf(format, args...))
+}
+
+func (p *printfLogger) Fatalf(format string, args ...interface{}) {
+	p.lines = append(p.lines, "F "+fmt.Sprintf(format, args...))
+}
+
+func setDefaultLogger(t *testing.T, l Logger) {
+	prev := DefaultLogger
+	DefaultLogger = l
+	t.Cleanup(func() { DefaultLogger = prev })
+}
+
+func TestStructuredLogger(t *testing.T) {
+	r := &recorder{}
+	setDefaultLogger(t, r)
+
+	Debugf("debug %d", 1)
+	e := With(F("file", "Shell"))
+	e.With(F("offset", "0x78")).Warnf("no UI section")
+	e.Errorf("error %d", 2)
+
+	want := []message{
+		{LevelDebug, "debug 1", nil},
+		{LevelWarn, "no UI section", []Field{{"file", "Shell"}, {"offset", "0x78"}}},
+		{LevelError, "error 2", []Field{{"file", "Shell"}}},
+	}
+	if !reflect.DeepEqual(r.msgs, want) {
+		t.Errorf("got %+v, want %+v", r.msgs, want)
+	}
+}
+
+func TestPrintfLogger(t *testing.T) {
+	p := &printfLogger{}
+	setDefaultLogger(t, p)
+
+	Debugf("debug")
+	Infof("info")
+	With(F("region", "BIOS"), F("name", "two words")).Warnf("out of bounds")
+	Errorf("%d%%", 100)
+
+	want := []string{
+		`W out of bounds region=BIOS name="two words"`,
+		"E 100%",
+	}
+	if !reflect.DeepEqual(p.lines, want) {
+		t.Errorf("got %q, want %q", p.lines, want)
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	var b bytes.Buffer
+	setDefaultLogger(t, NewLogger(&b, LevelWarn))
+
+	Infof("hidden")
+	With(F("fv", "FFF12B8D-7696-4C8B-A985-2747075B4F50")).Warnf("unsupported fv type")
+
+	out := b.String()
+	if strings.Contains(out, "hidden") {
+		t.Errorf("info message below the level logged: %q", out)
+	}
+	if want := "[fiano][WARN] unsupported fv type fv=FFF12B8D-7696-4C8B-A985-2747075B4F50\n"; !strings.HasSuffix(out, want) {
+		t.Errorf("got %q, want a line ending with %q", out, want)
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package remote downloads images from build servers and artifact stores,
+// over HTTP(S) or by calling out to the system's ssh. The expected checksum
+// of the image is given in the fragment of the URL, as in
+// "https://ci.example.com/ovmf.rom#sha256=HEX", and the download fails if the
+// image does not match, or if the URL has no checksum unless AllowUnverified
+// is set.
+package remote
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/linuxboot/fiano/pkg/log"
+)
+
+// SSHPath is the ssh executable, used for the ssh:// and scp:// URLs.
+var SSHPath = "ssh"
+
+// Client downloads the http:// and https:// URLs.
+var Client = http.DefaultClient
+
+// Timeout bounds the time a download takes, ssh included.
+var Timeout = 5 * time.Minute
+
+// MaxSize is the largest image downloaded, in bytes.
+var MaxSize int64 = 256 << 20
+
+// AllowUnverified lets Fetch download the URLs which give no checksum,
+// with a warning.
+var AllowUnverified bool
+
+// checksums are the hashes which the fragment of a URL can give.
+var checksums = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// IsURL tells whether path is a URL which Fetch downloads rather than a
+// local path.
+func IsURL(path string) bool {
+	for _, scheme := range []string{"http://", "https://", "ssh://", "scp://"} {
+		if strings.HasPrefix(path, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fetch downloads the image at the URL rawURL, which is either
+// "http(s)://HOST/PATH" or "ssh://[USER@]HOST[:PORT]/PATH", scp:// being
+// the same as ssh://. The ssh path is absolute unless it starts with "/~/".
+// The fragment of the URL, "sha256=HEX" or "sha512=HEX", gives the checksum
+// which the image must have. It may only be left out when AllowUnverified is
+// set. The download is limited to Timeout and MaxSize.
+func Fetch(rawURL string) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	var sum func(b []byte) error
+	if u.Fragment == "" {
+		if !AllowUnverified {
+			return nil, fmt.Errorf("%s has no checksum, add #sha256=HEX to the URL", u.Redacted())
+		}
+		log.Warnf("%s has no checksum, the image is not verified", u.Redacted())
+	} else if sum, err = parseChecksum(u.Fragment); err != nil {
+		return nil, fmt.Errorf("%s: %v", u.Redacted(), err)
+	}
+	u.Fragment = ""
+
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	var image []byte
+	switch u.Scheme {
+	case "http", "https":
+		image, err = fetchHTTP(ctx, u)
+	case "ssh", "scp":
+		image, err = fetchSSH(ctx, u)
+	default:
+		err = fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if err == nil && sum != nil {
+		err = sum(image)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", u.Redacted(), err)
+	}
+	return image, nil
+}
+
+// parseChecksum parses the fragment "ALGORITHM=HEX" and returns a function
+// checking a download against it.
+func parseChecksum(fragment string) (func(b []byte) error, error) {
+	alg, want, ok := strings.Cut(fragment, "=")
+	newHash, known := checksums[strings.ToLower(alg)]
+	if !ok || !known {
+		return nil, fmt.Errorf("unknown checksum %q, expected sha256=HEX or sha512=HEX", fragment)
+	}
+	wantSum, err := hex.DecodeString(want)
+	if err != nil || len(wantSum) != newHash().Size() {
+		return nil, fmt.Errorf("invalid %s checksum %q", alg, want)
+	}
+	return func(b []byte) error {
+		h := newHash()
+		h.Write(b)
+		if got := h.Sum(nil); !bytes.Equal(got, wantSum) {
+			return fmt.Errorf("%s checksum mismatch: got %x, want %x", alg, got, wantSum)
+		}
+		return nil
+	}, nil
+}
+
+// readImage reads r to the end, failing once it goes over MaxSize.
+func readImage(r io.Reader) ([]byte, error) {
+	b, err := io.ReadAll(io.LimitReader(r, MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > MaxSize {
+		return nil, fmt.Errorf("image larger than %d bytes", MaxSize)
+	}
+	return b, nil
+}
+
+func fetchHTTP(ctx context.Context, u *url.URL) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP status %s", resp.Status)
+	}
+	if resp.ContentLength > MaxSize {
+		return nil, fmt.Errorf("image larger than %d bytes", MaxSize)
+	}
+	return readImage(resp.Body)
+}
+
+func fetchSSH(ctx context.Context, u *url.URL) ([]byte, error) {
+	if u.Host == "" || u.Path == "" {
+		return nil, errors.New("expected ssh://[USER@]HOST[:PORT]/PATH")
+	}
+	var args []string
+	if port := u.Port(); port != "" {
+		args = append(args, "-p", port)
+	}
+	host := u.Hostname()
+	if u.User != nil {
+		host = u.User.Username() + "@" + host
+	}
+	path := u.Path
+	if strings.HasPrefix(path, "/~/") {
+		path = path[len("/~/"):]
+	}
+	// The path is run by the remote shell, so it is quoted.
+	args = append(args, "--", host, "cat -- '"+strings.ReplaceAll(path, "'", `'\''`)+"'")
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, SSHPath, args...)
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	image, err := readImage(stdout)
+	if err != nil {
+		// Stop ssh rather than wait for the rest of an oversized image.
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, err
+	}
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("%s %s: %v\n%s", SSHPath, strings.Join(args, " "), err, stderr.String())
+	}
+	return image, nil
+}
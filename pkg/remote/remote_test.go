@@ -0,0 +1,158 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package remote
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var image = []byte("remote image")
+
+func TestFetchHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ovmf.rom" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(image)
+	}))
+	defer srv.Close()
+
+	sum := sha256.Sum256(image)
+	for _, tt := range []struct {
+		url string
+		ok  bool
+	}{
+		{srv.URL + "/ovmf.rom", false},
+		{srv.URL + fmt.Sprintf("/ovmf.rom#sha256=%x", sum), true},
+		{srv.URL + fmt.Sprintf("/ovmf.rom#SHA256=%X", sum), true},
+		{srv.URL + fmt.Sprintf("/ovmf.rom#sha256=%x", sha256.Sum256(nil)), false},
+		{srv.URL + "/ovmf.rom#md5=00", false},
+		{srv.URL + "/ovmf.rom#sha256=00", false},
+		{srv.URL + "/missing.rom", false},
+	} {
+		got, err := Fetch(tt.url)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: got error %v, want success %v", tt.url, err, tt.ok)
+		}
+		if err == nil && string(got) != string(image) {
+			t.Errorf("%s: got %q", tt.url, got)
+		}
+	}
+
+	AllowUnverified = true
+	defer func() { AllowUnverified = false }()
+	if got, err := Fetch(srv.URL + "/ovmf.rom"); err != nil || string(got) != string(image) {
+		t.Errorf("got %q, %v without a checksum, want %q", got, err, image)
+	}
+}
+
+func TestFetchLimits(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow.rom" {
+			<-r.Context().Done()
+			return
+		}
+		w.Write(image)
+	}))
+	defer srv.Close()
+	dir := fakeSSH(t)
+	rom := filepath.Join(dir, "ovmf.rom")
+	if err := os.WriteFile(rom, image, 0666); err != nil {
+		t.Fatal(err)
+	}
+	sum := fmt.Sprintf("#sha256=%x", sha256.Sum256(image))
+
+	prevSize, prevTimeout := MaxSize, Timeout
+	defer func() { MaxSize, Timeout = prevSize, prevTimeout }()
+	MaxSize = int64(len(image)) - 1
+	for _, u := range []string{srv.URL + "/ovmf.rom" + sum, "ssh://ci" + rom + sum} {
+		if _, err := Fetch(u); err == nil || !strings.Contains(err.Error(), "larger than") {
+			t.Errorf("%s: got error %v, want the image to be too large", u, err)
+		}
+	}
+	MaxSize = prevSize
+
+	Timeout = 50 * time.Millisecond
+	if _, err := Fetch(srv.URL + "/slow.rom" + sum); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want the deadline to be exceeded", err)
+	}
+}
+
+// fakeSSH installs a script which emulates ssh by printing its arguments
+// and running the remote command locally.
+func fakeSSH(t *testing.T) string {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "ssh")
+	err := os.WriteFile(script, []byte(`#!/bin/sh
+echo "$@" > "`+dir+`/args"
+while [ "$1" != -- ]; do shift; done
+exec sh -c "$3"
+`), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := SSHPath
+	SSHPath = script
+	t.Cleanup(func() { SSHPath = prev })
+	return dir
+}
+
+func TestFetchSSH(t *testing.T) {
+	dir := fakeSSH(t)
+	rom := filepath.Join(dir, "it's.rom")
+	if err := os.WriteFile(rom, image, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Fetch(fmt.Sprintf("ssh://builder@ci:2222%s#sha256=%x", rom, sha256.Sum256(image)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(image) {
+		t.Errorf("got %q", got)
+	}
+	args, err := os.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(args), "-p 2222 -- builder@ci cat -- ") {
+		t.Errorf("got ssh arguments %q", args)
+	}
+
+	if _, err := Fetch(fmt.Sprintf("scp://ci%s#sha256=%x", filepath.Join(dir, "missing.rom"), sha256.Sum256(image))); err == nil {
+		t.Errorf("fetched a missing file")
+	}
+	if _, err := Fetch("ssh://ci"); err == nil {
+		t.Errorf("fetched without a path")
+	}
+}
+
+func TestIsURL(t *testing.T) {
+	for path, want := range map[string]bool{
+		"https://ci/ovmf.rom":  true,
+		"http://ci/ovmf.rom":   true,
+		"ssh://ci/ovmf.rom":    true,
+		"scp://ci/ovmf.rom":    true,
+		"ovmf.rom":             false,
+		"flashrom:internal":    false,
+		"ftp://ci/ovmf.rom":    false,
+		"/srv/http://ovmf.rom": false,
+	} {
+		if got := IsURL(path); got != want {
+			t.Errorf("IsURL(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
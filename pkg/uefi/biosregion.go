@@ -17,6 +17,8 @@ type BIOSPadding struct {
 
 	// Metadata
 	ExtractPath string
+
+	parsedNode
 }
 
 // NewBIOSPadding parses a sequence of bytes and returns a BIOSPadding
@@ -59,6 +61,8 @@ type BIOSRegion struct {
 	// This is a pointer to the FlashRegion struct laid out in the ifd.
 	FRegion    *FlashRegion
 	RegionType FlashRegionType
+
+	parsedNode
 }
 
 // Type returns the flash region type.
@@ -79,18 +83,18 @@ func (br *BIOSRegion) FlashRegion() (fr *FlashRegion) {
 // NewBIOSRegion parses a sequence of bytes and returns a Region
 // object, if a valid one is passed, or an error. It also points to the
 // Region struct uncovered in the ifd.
-func NewBIOSRegion(buf []byte, r *FlashRegion, _ FlashRegionType) (Region, error) {
+func NewBIOSRegion(buf []byte, r *FlashRegion, rt FlashRegionType) (Region, error) {
+	return newBIOSRegion(nil, buf, r, rt, false)
+}
+
+func newBIOSRegion(p *parser, buf []byte, r *FlashRegion, _ FlashRegionType, owned bool) (Region, error) {
 	br := BIOSRegion{FRegion: r, Length: uint64(len(buf)),
 		RegionType: RegionTypeBIOS}
 	var absOffset uint64
 
-	// Copy the buffer
-	if ReadOnly {
-		br.buf = buf
-	} else {
-		br.buf = make([]byte, len(buf))
-		copy(br.buf, buf)
-	}
+	// Copy the buffer, and parse the copy.
+	br.buf = p.ownBuf(buf, uint64(len(buf)), owned)
+	buf = br.buf
 
 	for {
 		offset := FindFirmwareVolumeOffset(buf)
@@ -109,16 +113,16 @@ func NewBIOSRegion(buf []byte, r *FlashRegion, _ FlashRegionType) (Region, error
 		if offset > 0 {
 			// There is some padding here, store it in case there is data.
 			// We could check and conditionally store, but that makes things more complicated
-			bp, err := NewBIOSPadding(buf[:offset], absOffset)
+			bp, err := NewBIOSPadding(ownBuf(buf, uint64(offset), true), absOffset)
 			if err != nil {
 				return nil, err
 			}
 			br.Elements = append(br.Elements, MakeTyped(bp))
 		}
-		absOffset += uint64(offset)                                  // Find start of volume relative to bios region.
-		fv, err := NewFirmwareVolume(buf[offset:], absOffset, false) // False as top level FVs are not resizable
+		absOffset += uint64(offset)                                              // Find start of volume relative to bios region.
+		fv, err := newFirmwareVolume(p, buf[offset:], absOffset, false, 0, true) // False as top level FVs are not resizable
 		if err != nil {
-			return nil, err
+			return nil, childError(err, fvNodeName(buf[offset:]), absOffset, true)
 		}
 		absOffset += fv.Length
 		buf = buf[uint64(offset)+fv.Length:]
@@ -0,0 +1,274 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+)
+
+// NodeError is an error about a node of the firmware tree. It records where
+// the node is, so that the error can be located in the image.
+type NodeError struct {
+	// Node describes the node, such as "File D6A2CB7F-6A18-4E2F-B43B-9920A733700A".
+	Node string
+	// Path describes the nodes from the root of the tree, excluded, to the
+	// node, separated by "/", such as
+	// "FV 8C8CE578-8A3D-4F1C-9935-896185C32DD3/File D6A2CB7F-6A18-4E2F-B43B-9920A733700A".
+	// It is empty for the root.
+	Path string `json:",omitempty"`
+	// Offset of the node from the start of the root, which is the offset
+	// in the flash image when the root is the whole image. It is unknown
+	// for the nodes in compressed sections.
+	Offset *uint64 `json:",omitempty"`
+	// Chip is the flash chip in which the failing node starts, for the
+	// images held by several chips, see LocateChip.
+	Chip *ChipLocation `json:",omitempty"`
+	Err  error
+}
+
+// Error returns the message of the wrapped error, prefixed with the path
+// and offset of the node.
+func (e *NodeError) Error() string {
+	switch {
+	case e.Path == "":
+		return e.Err.Error()
+	case e.Offset == nil:
+		return fmt.Sprintf("%s: %v", e.Path, e.Err)
+	case e.Chip != nil:
+		return fmt.Sprintf("%s at %#x (%v): %v", e.Path, *e.Offset, e.Chip, e.Err)
+	}
+	return fmt.Sprintf("%s at %#x: %v", e.Path, *e.Offset, e.Err)
+}
+
+// Unwrap returns the wrapped error.
+func (e *NodeError) Unwrap() error {
+	return e.Err
+}
+
+// childError returns err about the child node parsed at offset of its
+// parent. An error already about a node of the child's subtree is about the
+// same node, its path and offset are made relative to the parent. known is
+// false for the children in decompressed data, whose offset is not one of
+// the image.
+func childError(err error, node string, offset uint64, known bool) error {
+	e, ok := err.(*NodeError)
+	if ok {
+		e.Path = node + "/" + e.Path
+	} else {
+		e = &NodeError{Node: node, Path: node, Offset: new(uint64), Err: err}
+	}
+	switch {
+	case !known:
+		e.Offset = nil
+	case e.Offset != nil:
+		*e.Offset += offset
+	}
+	return e
+}
+
+// The names of the nodes which fail to parse, read from their headers.
+
+func fileNodeName(buf []byte) string {
+	if len(buf) < guid.Size {
+		return "File"
+	}
+	return "File " + guid.GUID(buf[:guid.Size]).String()
+}
+
+func sectionNodeName(buf []byte) string {
+	if len(buf) < 4 {
+		return "Section"
+	}
+	return "Section " + SectionType(buf[3]).String()
+}
+
+func fvNodeName(buf []byte) string {
+	if len(buf) < 16+guid.Size {
+		return "FV"
+	}
+	return "FV " + guid.GUID(buf[16:16+guid.Size]).String()
+}
+
+func regionNodeName(rt FlashRegionType) string {
+	switch rt {
+	case RegionTypeBIOS:
+		return "BIOSRegion"
+	case RegionTypeME:
+		return "MERegion"
+	}
+	return rt.String()
+}
+
+// NodeName describes a node of the firmware tree by its type and, when it
+// has one, its GUID.
+func NodeName(f Firmware) string {
+	switch f := f.(type) {
+	case *FirmwareVolume:
+		return "FV " + f.FileSystemGUID.String()
+	case *File:
+		return "File " + f.Header.GUID.String()
+	case *Section:
+		return "Section " + f.Type
+	case *NVar:
+		return "NVAR " + f.GUID.String()
+	case *RawRegion:
+		return f.Type().String()
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", f), "*uefi.")
+}
+
+// Locate returns the path and the offset of node in the tree of root, as in
+// a NodeError. ok is false if node is not in the tree.
+func Locate(root, node Firmware) (path string, offset *uint64, ok bool) {
+	_ = Walk(root, func(f, _ Firmware, p string, o *uint64) error {
+		if f != node {
+			return nil
+		}
+		path, offset, ok = p, o, true
+		return errStopWalk
+	})
+	return path, offset, ok
+}
+
+// errStopWalk stops a Walk once its node is found.
+var errStopWalk = errors.New("stop walking")
+
+// WalkFunc is called by Walk for each node f of a tree, with its parent, nil
+// for the root, and its path and offset, as in a NodeError. A non-nil error
+// stops the walk.
+type WalkFunc func(f, parent Firmware, path string, offset *uint64) error
+
+// Walk calls fn for each node of the tree of root, parents first, in the
+// order of ApplyChildren. The paths and offsets are computed along, so
+// walking the tree once costs less than locating each of its nodes. It
+// returns the error of fn.
+func Walk(root Firmware, fn WalkFunc) error {
+	return walk(root, nil, "", new(uint64), fn)
+}
+
+func walk(f, parent Firmware, path string, offset *uint64, fn WalkFunc) error {
+	if err := fn(f, parent, path, offset); err != nil {
+		return err
+	}
+	visit := func(c Firmware, rel uint64, known bool) error {
+		p := NodeName(c)
+		if path != "" {
+			p = path + "/" + p
+		}
+		var o *uint64
+		if known && offset != nil {
+			o = new(uint64)
+			*o = *offset + rel
+		}
+		return walk(c, f, p, o, fn)
+	}
+	switch f := f.(type) {
+	case *FlashImage:
+		if err := visit(&f.IFD, 0, true); err != nil {
+			return err
+		}
+		for _, r := range f.Regions {
+			var rel uint64
+			fr := r.Value.(Region).FlashRegion()
+			if fr != nil {
+				rel = uint64(fr.BaseOffset())
+			}
+			if err := visit(r.Value, rel, fr != nil); err != nil {
+				return err
+			}
+		}
+	case *BIOSRegion:
+		for _, e := range f.Elements {
+			var rel uint64
+			switch e := e.Value.(type) {
+			case *FirmwareVolume:
+				rel = e.FVOffset
+			case *BIOSPadding:
+				rel = e.Offset
+			}
+			if err := visit(e.Value, rel, true); err != nil {
+				return err
+			}
+		}
+	case *FirmwareVolume:
+		rel := f.DataOffset
+		for _, file := range f.Files {
+			rel = Align8(rel)
+			if err := visit(file, rel, true); err != nil {
+				return err
+			}
+			rel += uint64(len(file.Buf()))
+		}
+	case *File:
+		if f.NVarStore != nil {
+			return visit(f.NVarStore, f.DataOffset, true)
+		}
+		rel := f.DataOffset
+		for _, s := range f.Sections {
+			rel = Align4(rel)
+			if err := visit(s, rel, true); err != nil {
+				return err
+			}
+			rel += uint64(len(s.Buf()))
+		}
+	case *Section:
+		rel, known := f.encapsulatedOffset()
+		for _, e := range f.Encapsulated {
+			rel = Align4(rel)
+			if err := visit(e.Value, rel, known); err != nil {
+				return err
+			}
+			rel += uint64(len(e.Value.Buf()))
+		}
+	case *NVarStore:
+		for _, v := range f.Entries {
+			if err := visit(v, v.Offset, true); err != nil {
+				return err
+			}
+		}
+	case *NVar:
+		if f.NVarStore != nil {
+			return visit(f.NVarStore, uint64(f.DataOffset), true)
+		}
+	case *MERegion:
+		if f.FPT != nil {
+			return visit(f.FPT, 0, false)
+		}
+	}
+	return nil
+}
+
+// encapsulatedOffset returns the offset of the encapsulated firmware in the
+// section, known is false if it is compressed.
+func (s *Section) encapsulatedOffset() (offset uint64, known bool) {
+	headerSize := uint64(4)
+	if s.Header.Size == [3]uint8{0xFF, 0xFF, 0xFF} {
+		headerSize = 8
+	}
+	if s.TypeSpecific == nil && s.Header.Type != SectionTypeFirmwareVolumeImage {
+		return 0, false
+	}
+	switch s.Header.Type {
+	case SectionTypeFirmwareVolumeImage:
+		return headerSize, true
+	case SectionTypeCompression:
+		ts, ok := s.TypeSpecific.Header.(*SectionCompression)
+		if !ok || ts.CompressionType != CompressionTypeNone {
+			return 0, false
+		}
+		return headerSize + uint64(ts.GetBinHeaderLen()), true
+	case SectionTypeGUIDDefined:
+		ts, ok := s.TypeSpecific.Header.(*SectionGUIDDefined)
+		if !ok || ts.Attributes&uint16(GUIDEDSectionProcessingRequired) != 0 {
+			return 0, false
+		}
+		return uint64(ts.DataOffset), true
+	}
+	return 0, false
+}
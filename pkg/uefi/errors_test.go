@@ -0,0 +1,163 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// firstSection returns the first section of a file of the firmware volume.
+func firstSection(t *testing.T, f Firmware) (*File, *Section) {
+	t.Helper()
+	fv := f.(*BIOSRegion).Elements[0].Value.(*FirmwareVolume)
+	for _, file := range fv.Files {
+		if len(file.Sections) != 0 {
+			return file, file.Sections[0]
+		}
+	}
+	t.Fatal("no file with sections")
+	return nil, nil
+}
+
+func TestParseErrorLocation(t *testing.T) {
+	f, err := Parse(sampleFV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, s := firstSection(t, f)
+	path, offset, ok := Locate(f, s)
+	if !ok || offset == nil {
+		t.Fatalf("section not located")
+	}
+	wantPath := NodeName(f.(*BIOSRegion).Elements[0].Value) + "/" + NodeName(file) + "/" + NodeName(s)
+	if path != wantPath {
+		t.Errorf("got path %q, want %q", path, wantPath)
+	}
+
+	// Make the section larger than its file.
+	image := append([]byte{}, sampleFV...)
+	size := Write3Size(0xfffff0)
+	copy(image[*offset:], size[:])
+	_, err = Parse(image)
+	var ne *NodeError
+	if !errors.As(err, &ne) {
+		t.Fatalf("got %v, want a *NodeError", err)
+	}
+	if ne.Node != NodeName(s) || ne.Path != path || ne.Offset == nil || *ne.Offset != *offset {
+		t.Errorf("got node %q, path %q at %v, want %q, %q at %#x", ne.Node, ne.Path, ne.Offset, NodeName(s), path, *offset)
+	}
+	if msg := err.Error(); !strings.HasPrefix(msg, path+" at ") || !strings.Contains(msg, "section size mismatch") {
+		t.Errorf("got message %q", msg)
+	}
+}
+
+func TestLocateCompressed(t *testing.T) {
+	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := Parse(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The DXE volume is in a compressed section of the last volume.
+	var fvs []*FirmwareVolume
+	var find func(f Firmware)
+	find = func(f Firmware) {
+		switch f := f.(type) {
+		case *BIOSRegion:
+			for _, e := range f.Elements {
+				find(e.Value)
+			}
+		case *FirmwareVolume:
+			fvs = append(fvs, f)
+			for _, file := range f.Files {
+				find(file)
+			}
+		case *File:
+			for _, s := range f.Sections {
+				find(s)
+			}
+		case *Section:
+			for _, e := range f.Encapsulated {
+				find(e.Value)
+			}
+		}
+	}
+	find(f)
+
+	var top, nested int
+	for _, fv := range fvs {
+		path, offset, ok := Locate(f, fv)
+		if !ok {
+			t.Fatalf("%s not located", NodeName(fv))
+		}
+		if strings.Contains(path, "/") {
+			nested++
+			if offset != nil {
+				t.Errorf("%s: got offset %#x in compressed data", path, *offset)
+			}
+			continue
+		}
+		top++
+		if offset == nil || *offset != fv.FVOffset {
+			t.Errorf("%s: got offset %v, want %#x", path, offset, fv.FVOffset)
+		}
+	}
+	if top == 0 || nested == 0 {
+		t.Errorf("got %d top level and %d nested volumes, want some of each", top, nested)
+	}
+	if _, _, ok := Locate(f, &File{}); ok {
+		t.Errorf("located a file which is not in the tree")
+	}
+}
+
+func TestWalk(t *testing.T) {
+	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := Parse(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parents := map[Firmware]Firmware{}
+	err = Walk(f, func(n, parent Firmware, path string, offset *uint64) error {
+		if parent != nil {
+			if _, ok := parents[parent]; !ok {
+				t.Errorf("%s: walked before its parent", path)
+			}
+		}
+		parents[n] = parent
+		wantPath, wantOffset, ok := Locate(f, n)
+		if !ok || path != wantPath {
+			t.Errorf("got path %q, Locate returned %q", path, wantPath)
+		}
+		if (offset == nil) != (wantOffset == nil) || offset != nil && *offset != *wantOffset {
+			t.Errorf("%s: got offset %v, Locate returned %v", path, offset, wantOffset)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parents) < 100 {
+		t.Errorf("walked %d nodes, want the whole tree", len(parents))
+	}
+
+	errStop := errors.New("stop")
+	var n int
+	if err := Walk(f, func(Firmware, Firmware, string, *uint64) error {
+		if n++; n == 3 {
+			return errStop
+		}
+		return nil
+	}); err != errStop || n != 3 {
+		t.Errorf("got %v after %d nodes, want %v after 3", err, n, errStop)
+	}
+}
@@ -120,6 +120,9 @@ func (f FVFileType) String() string {
 var (
 	ZeroGUID = guid.MustParse("00000000-0000-0000-0000-000000000000")
 	FFGUID   = guid.MustParse("FFFFFFFF-FFFF-FFFF-FFFF-FFFFFFFFFFFF")
+	// VTFGUID identifies the volume top file, which must end at the end of
+	// its firmware volume.
+	VTFGUID = guid.MustParse("1BA0062E-C779-4582-8566-336AE8F78F09")
 )
 
 // FileAlignments specifies the correct alignments based on the field in the file header.
@@ -277,6 +280,8 @@ type File struct {
 	buf         []byte
 	ExtractPath string
 	DataOffset  uint64
+
+	parsedNode
 }
 
 // Buf returns the buffer.
@@ -429,6 +434,12 @@ func CreatePadFile(size uint64) (*File, error) {
 // object, if a valid one is passed, or an error. If no error is returned and the File
 // pointer is nil, it means we've reached the volume free space at the end of the FV.
 func NewFile(buf []byte) (*File, error) {
+	return newFile(nil, buf, 0, false)
+}
+
+// newFile parses a file nested in depth encapsulation sections and firmware
+// volume images. owned tells whether buf is owned by the parser, see ownBuf.
+func newFile(p *parser, buf []byte, depth int, owned bool) (*File, error) {
 	f := File{}
 	f.DataOffset = FileHeaderMinLength
 	// Read in standard header.
@@ -464,20 +475,13 @@ func NewFile(buf []byte) (*File, error) {
 			f.Header.GUID, f.Header.ExtendedSize, buflen)
 	}
 
-	if ReadOnly {
-		f.buf = buf[:f.Header.ExtendedSize]
-	} else {
-		// Copy out the buffer.
-		newBuf := buf[:f.Header.ExtendedSize]
-		f.buf = make([]byte, f.Header.ExtendedSize)
-		copy(f.buf, newBuf)
-	}
+	f.buf = p.ownBuf(buf, f.Header.ExtendedSize, owned)
 
 	// Special case for NVAR Store stored in raw file
 	if f.Header.Type == FVFileTypeRaw && f.Header.GUID == *NVAR {
 		ns, err := NewNVarStore(f.buf[f.DataOffset:])
 		if err != nil {
-			log.Errorf("error parsing NVAR store in file %v: %v", f.Header.GUID, err)
+			log.With(log.F("file", f.Header.GUID)).Errorf("error parsing NVAR store: %v", err)
 		}
 		// Note that ns is nil if there was an error, so this assign is fine either way.
 		f.NVarStore = ns
@@ -489,9 +493,9 @@ func NewFile(buf []byte) (*File, error) {
 	}
 
 	for i, offset := 0, f.DataOffset; offset < f.Header.ExtendedSize; i++ {
-		s, err := NewSection(f.buf[offset:], i)
+		s, err := newSection(p, f.buf[offset:], i, depth, true)
 		if err != nil {
-			return nil, fmt.Errorf("error parsing sections of file %v: %v", f.Header.GUID, err)
+			return nil, childError(err, sectionNodeName(f.buf[offset:]), offset, true)
 		}
 		if s.Header.ExtendedSize == 0 {
 			return nil, fmt.Errorf("invalid length of section of file %v", f.Header.GUID)
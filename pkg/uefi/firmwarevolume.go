@@ -102,6 +102,8 @@ type FirmwareVolume struct {
 	ExtractPath string
 	Resizable   bool   // Determines if this FV is resizable.
 	FreeSpace   uint64 `json:"-"`
+
+	parsedNode
 }
 
 // Buf returns the buffer.
@@ -194,6 +196,13 @@ func FindFirmwareVolumeOffset(data []byte) int64 {
 // NewFirmwareVolume parses a sequence of bytes and returns a FirmwareVolume
 // object, if a valid one is passed, or an error
 func NewFirmwareVolume(data []byte, fvOffset uint64, resizable bool) (*FirmwareVolume, error) {
+	return newFirmwareVolume(nil, data, fvOffset, resizable, 0, false)
+}
+
+// newFirmwareVolume parses a firmware volume nested in depth encapsulation
+// sections and firmware volume images. owned tells whether data is owned by
+// the parser, see ownBuf.
+func newFirmwareVolume(p *parser, data []byte, fvOffset uint64, resizable bool, depth int, owned bool) (*FirmwareVolume, error) {
 	fv := FirmwareVolume{Resizable: resizable}
 
 	if len(data) < FirmwareVolumeMinSize {
@@ -231,6 +240,10 @@ func NewFirmwareVolume(data []byte, fvOffset uint64, resizable bool) (*FirmwareV
 		return nil, fmt.Errorf("invalid FV length (is greater than the data length): %d > %d",
 			fv.Length, len(data))
 	}
+	if fv.Length < FirmwareVolumeMinSize {
+		return nil, fmt.Errorf("invalid FV length (is smaller than the minimum FV size): %d < %d",
+			fv.Length, FirmwareVolumeMinSize)
+	}
 
 	// Parse the extended header and figure out the start of data
 	fv.DataOffset = uint64(fv.HeaderLen)
@@ -249,40 +262,41 @@ func NewFirmwareVolume(data []byte, fvOffset uint64, resizable bool) (*FirmwareV
 	// Make sure DataOffset is 8 byte aligned at least.
 	// TODO: handle alignment field in header.
 	fv.DataOffset = Align8(fv.DataOffset)
+	if fv.DataOffset > fv.Length {
+		return nil, fmt.Errorf("invalid FV data offset (is greater than the FV length): %#x > %#x",
+			fv.DataOffset, fv.Length)
+	}
 
 	fv.FVType = FVGUIDs[fv.FileSystemGUID]
 	fv.FVOffset = fvOffset
 
-	if ReadOnly {
-		fv.buf = data[:fv.Length]
-	} else {
-		// copy out the buffer.
-		newBuf := data[:fv.Length]
-		fv.buf = make([]byte, fv.Length)
-		copy(fv.buf, newBuf)
-	}
+	fv.buf = p.ownBuf(data, fv.Length, owned)
 
 	// Parse the files.
 	// TODO: handle fv data alignment.
 	// Start from the end of the fv header.
 	// Test if the fv type is supported.
 	if _, ok := supportedFVs[fv.FileSystemGUID]; !ok {
-		log.Warnf("unsupported fv type %v,%v not parsing it", fv.FileSystemGUID.String(), fv.FVType)
+		log.With(log.F("fv", fv.FileSystemGUID), log.F("offset", fmt.Sprintf("%#x", fv.FVOffset))).Warnf("unsupported fv type %v, not parsing it", fv.FVType)
 		return &fv, nil
 	}
 	lh := fv.Length - FileHeaderMinLength
 	var prevLen uint64
 	for offset := fv.DataOffset; offset < lh; offset += prevLen {
 		offset = Align8(offset)
-		file, err := NewFile(data[offset:])
+		if err := p.err(); err != nil {
+			return nil, err
+		}
+		file, err := newFile(p, fv.buf[offset:], depth, true)
 		if err != nil {
-			return nil, fmt.Errorf("unable to construct firmware file at offset %#x into FV: %v", offset, err)
+			return nil, childError(err, fileNodeName(fv.buf[offset:]), offset, true)
 		}
 		if file == nil {
 			// We've reached free space. Terminate
 			fv.FreeSpace = fv.Length - offset
 			break
 		}
+		p.stepFile()
 		fv.Files = append(fv.Files, file)
 		prevLen = file.Header.ExtendedSize
 		if prevLen == 0 {
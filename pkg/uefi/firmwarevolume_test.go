@@ -5,6 +5,8 @@
 package uefi
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
 	"os"
 	"testing"
@@ -47,3 +49,15 @@ func TestNewFirmwareVolume(t *testing.T) {
 		})
 	}
 }
+
+func FuzzNewFirmwareVolume(f *testing.F) {
+	// The header of the sample FV followed by free space, as the whole
+	// sample is too large for the fuzzer to mutate efficiently.
+	hdrLen := binary.LittleEndian.Uint16(sampleFV[48:])
+	seed := append(append([]byte{}, sampleFV[:hdrLen]...), bytes.Repeat([]byte{0xff}, 0x100)...)
+	binary.LittleEndian.PutUint64(seed[32:], uint64(len(seed)))
+	f.Add(seed)
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		_, _ = NewFirmwareVolume(buf, 0, false)
+	})
+}
@@ -9,6 +9,8 @@ import (
 	"encoding/hex"
 	"fmt"
 	"sort"
+
+	"github.com/linuxboot/fiano/pkg/log"
 )
 
 // FlashSignature is the sequence of bytes that a Flash image is expected to
@@ -32,9 +34,13 @@ type FlashDescriptor struct {
 	DescriptorMap      *FlashDescriptorMap
 	Region             *FlashRegionSection
 	Master             *FlashMasterSection
+	// Component holds the parameters of the flash chips.
+	Component *FlashParams `json:",omitempty"`
 
 	//Metadata for extraction and recovery
 	ExtractPath string
+
+	parsedNode
 }
 
 // FindSignature searches for an Intel flash signature.
@@ -113,6 +119,13 @@ func (fd *FlashDescriptor) ParseFlashDescriptor() error {
 	}
 	fd.Master = master
 
+	// Component
+	if componentStart := uint(fd.DescriptorMap.ComponentBase) * 0x10; componentStart+FlashParamsSize <= uint(len(fd.buf)) {
+		if fd.Component, err = NewFlashParams(fd.buf[componentStart : componentStart+FlashParamsSize]); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -129,6 +142,8 @@ type FlashImage struct {
 	// Metadata for extraction and recovery
 	ExtractPath string
 	FlashSize   uint64
+
+	parsedNode
 }
 
 // Buf returns the buffer.
@@ -206,7 +221,7 @@ func (f *FlashImage) fillRegionGaps() error {
 			// There is a gap, create an unknown region
 			tempFR := &FlashRegion{Base: uint16(offset / RegionBlockSize),
 				Limit: uint16(nextBase/RegionBlockSize) - 1}
-			newRegions = append(newRegions, MakeTyped(&RawRegion{buf: f.buf[offset:nextBase],
+			newRegions = append(newRegions, MakeTyped(&RawRegion{buf: ownBuf(f.buf[offset:], nextBase-offset, true),
 				FRegion:    tempFR,
 				RegionType: RegionTypeUnknown}))
 		}
@@ -217,7 +232,7 @@ func (f *FlashImage) fillRegionGaps() error {
 	if offset != f.FlashSize {
 		tempFR := &FlashRegion{Base: uint16(offset / RegionBlockSize),
 			Limit: uint16(f.FlashSize/RegionBlockSize) - 1}
-		newRegions = append(newRegions, MakeTyped(&RawRegion{buf: f.buf[offset:f.FlashSize],
+		newRegions = append(newRegions, MakeTyped(&RawRegion{buf: ownBuf(f.buf[offset:], f.FlashSize-offset, true),
 			FRegion:    tempFR,
 			RegionType: RegionTypeUnknown}))
 	}
@@ -229,6 +244,10 @@ func (f *FlashImage) fillRegionGaps() error {
 // and an error if any. This only works with images that operate in Descriptor
 // mode.
 func NewFlashImage(buf []byte) (*FlashImage, error) {
+	return newFlashImage(nil, buf)
+}
+
+func newFlashImage(p *parser, buf []byte) (*FlashImage, error) {
 	if len(buf) < FlashDescriptorLength {
 		return nil, fmt.Errorf("flash Descriptor Map size too small: expected %v bytes, got %v",
 			FlashDescriptorLength,
@@ -237,11 +256,9 @@ func NewFlashImage(buf []byte) (*FlashImage, error) {
 	}
 	f := FlashImage{FlashSize: uint64(len(buf))}
 
-	// Copy out buffers
-	f.buf = make([]byte, len(buf))
-	copy(f.buf, buf)
-	f.IFD.buf = make([]byte, FlashDescriptorLength)
-	copy(f.IFD.buf, buf[:FlashDescriptorLength])
+	// Copy out the buffer, the descriptor and the regions are parts of it.
+	f.buf = p.ownBuf(buf, uint64(len(buf)), false)
+	f.IFD.buf = ownBuf(f.buf, FlashDescriptorLength, true)
 
 	if err := f.IFD.ParseFlashDescriptor(); err != nil {
 		return nil, err
@@ -268,18 +285,22 @@ func NewFlashImage(buf []byte) (*FlashImage, error) {
 			continue
 		}
 		if o := uint64(fr.BaseOffset()); o >= f.FlashSize {
-			fmt.Printf("region %s (%d, %v) out of bounds: BaseOffset %#x, Flash size %#x, skipping...\n",
-				flashRegionTypeNames[FlashRegionType(i)], i, fr, o, f.FlashSize)
+			log.With(log.F("region", flashRegionTypeNames[FlashRegionType(i)])).Warnf("region %d (%v) out of bounds: BaseOffset %#x, Flash size %#x, skipping",
+				i, fr, o, f.FlashSize)
 			continue
 		}
 		if o := uint64(fr.EndOffset()); o > f.FlashSize {
-			fmt.Printf("region %s (%d, %v) out of bounds: EndOffset %#x, Flash size %#x, skipping...\n",
-				flashRegionTypeNames[FlashRegionType(i)], i, fr, o, f.FlashSize)
+			log.With(log.F("region", flashRegionTypeNames[FlashRegionType(i)])).Warnf("region %d (%v) out of bounds: EndOffset %#x, Flash size %#x, skipping",
+				i, fr, o, f.FlashSize)
 			continue
 		}
 		if c, ok := regionConstructors[FlashRegionType(i)]; ok {
-			r, err := c(buf[fr.BaseOffset():fr.EndOffset()], &frs[i], FlashRegionType(i))
+			r, err := c(p, f.buf[fr.BaseOffset():fr.EndOffset()], &frs[i], FlashRegionType(i), true)
 			if err != nil {
+				err = childError(err, regionNodeName(FlashRegionType(i)), uint64(fr.BaseOffset()), true)
+				if e := err.(*NodeError); e.Offset != nil {
+					e.Chip = LocateChip(&f, *e.Offset)
+				}
 				return nil, err
 			}
 			f.Regions = append(f.Regions, MakeTyped(r))
@@ -0,0 +1,128 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// MaxFlashChips is the number of flash chips a descriptor can declare.
+const MaxFlashChips = 2
+
+// FlashChip is one of the SPI flash chips holding a flash image. The chips
+// are mapped one after the other, so the image is their concatenation.
+type FlashChip struct {
+	Index int
+	// Offset of the chip in the image.
+	Offset uint64
+	Size   uint64
+}
+
+// ChipLocation locates an offset of a flash image in one of its chips.
+type ChipLocation struct {
+	Chip int
+	// Offset in the chip.
+	Offset uint64
+}
+
+func (l *ChipLocation) String() string {
+	return fmt.Sprintf("chip %d at %#x", l.Chip, l.Offset)
+}
+
+// ChipDensitySize returns the size of a flash chip of density d, as in
+// FlashParams. Density 0xf means there is no chip.
+func ChipDensitySize(d uint) (uint64, bool) {
+	if d >= 0xf {
+		return 0, false
+	}
+	return 512 * 1024 << d, true
+}
+
+// Chips returns the flash chips holding the image, as declared by the
+// component section of the descriptor. When the descriptor declares a single
+// chip, or chips whose sizes do not add up to the size of the image, the image
+// is held by a single chip.
+func (f *FlashImage) Chips() []FlashChip {
+	single := []FlashChip{{Size: f.FlashSize}}
+	if f.IFD.DescriptorMap == nil || f.IFD.Component == nil {
+		return single
+	}
+	n := int(f.IFD.DescriptorMap.NumberOfFlashChips&0x3) + 1
+	if n == 1 || n > MaxFlashChips {
+		return single
+	}
+	densities := []uint{f.IFD.Component.FirstChipDensity(), f.IFD.Component.SecondChipDensity()}
+	var chips []FlashChip
+	var offset uint64
+	for i := 0; i < n; i++ {
+		size, ok := ChipDensitySize(densities[i])
+		if !ok {
+			return single
+		}
+		chips = append(chips, FlashChip{Index: i, Offset: offset, Size: size})
+		offset += size
+	}
+	if offset != f.FlashSize {
+		return single
+	}
+	return chips
+}
+
+// LocateChip returns the chip holding offset in the image of root, and the
+// offset in this chip. It returns nil unless root is a flash image held by
+// several chips, where the offsets in the image are not those in the chips.
+func LocateChip(root Firmware, offset uint64) *ChipLocation {
+	f, ok := root.(*FlashImage)
+	if !ok {
+		return nil
+	}
+	chips := f.Chips()
+	if len(chips) < 2 {
+		return nil
+	}
+	for _, c := range chips {
+		if offset >= c.Offset && offset < c.Offset+c.Size {
+			return &ChipLocation{Chip: c.Index, Offset: offset - c.Offset}
+		}
+	}
+	return nil
+}
+
+// SplitChips returns the contents of the flash chips holding the image, see
+// Chips.
+func (f *FlashImage) SplitChips() [][]byte {
+	var contents [][]byte
+	for _, c := range f.Chips() {
+		contents = append(contents, f.buf[c.Offset:c.Offset+c.Size])
+	}
+	return contents
+}
+
+// JoinChips returns the flash image held by the chips whose contents are
+// given, in order. The descriptor in the first chip must declare chips of
+// these sizes.
+func JoinChips(contents ...[]byte) ([]byte, error) {
+	image := bytes.Join(contents, nil)
+	f := &FlashImage{buf: image, FlashSize: uint64(len(image))}
+	if len(image) < FlashDescriptorLength {
+		return nil, fmt.Errorf("the first chip is too small for a flash descriptor: %#x bytes", len(image))
+	}
+	f.IFD.buf = image[:FlashDescriptorLength]
+	if err := f.IFD.ParseFlashDescriptor(); err != nil {
+		return nil, err
+	}
+	chips := f.Chips()
+	if len(chips) != len(contents) {
+		return nil, fmt.Errorf("the descriptor declares %d chips for an image of %#x bytes, got %d chips",
+			int(f.IFD.DescriptorMap.NumberOfFlashChips&0x3)+1, len(image), len(contents))
+	}
+	for i, c := range chips {
+		if c.Size != uint64(len(contents[i])) {
+			return nil, fmt.Errorf("chip %d: expected %#x bytes, got %#x", i, c.Size, len(contents[i]))
+		}
+	}
+	return image, nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi/uefitest"
+)
+
+const testChipSize = uefitest.ChipSize
+
+// twoChipImage returns an image held by two chips of 512KiB, whose BIOS
+// region fills the second chip and starts with sampleFV.
+func twoChipImage(t *testing.T) []byte {
+	return uefitest.TwoChipImage(sampleFV)
+}
+
+func TestChips(t *testing.T) {
+	image := twoChipImage(t)
+	f, err := Parse(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi := f.(*FlashImage)
+	want := []FlashChip{{Index: 0, Offset: 0, Size: testChipSize}, {Index: 1, Offset: testChipSize, Size: testChipSize}}
+	if got := fi.Chips(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got chips %+v, want %+v", got, want)
+	}
+	if got := LocateChip(f, testChipSize+0x10); got == nil || *got != (ChipLocation{Chip: 1, Offset: 0x10}) {
+		t.Errorf("got location %v, want chip 1 at 0x10", got)
+	}
+	if got := LocateChip(fi.Regions[len(fi.Regions)-1].Value, 0x10); got != nil {
+		t.Errorf("got location %v in a BIOS region", got)
+	}
+
+	chips := fi.SplitChips()
+	if len(chips) != 2 || !bytes.Equal(chips[0], image[:testChipSize]) || !bytes.Equal(chips[1], image[testChipSize:]) {
+		t.Fatalf("the chips differ from the image")
+	}
+	if joined, err := JoinChips(chips...); err != nil || !bytes.Equal(joined, image) {
+		t.Errorf("got %v, want the image", err)
+	}
+	if _, err := JoinChips(image); err == nil {
+		t.Errorf("joined a single chip for two")
+	}
+	if _, err := JoinChips(chips[0], chips[1][:testChipSize/2]); err == nil {
+		t.Errorf("joined a chip of the wrong size")
+	}
+
+	// A descriptor declaring a single chip.
+	image[21] = 0
+	if f, err = Parse(image); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.(*FlashImage).Chips(); len(got) != 1 || got[0].Size != 2*testChipSize {
+		t.Errorf("got chips %+v, want a single one", got)
+	}
+	if got := LocateChip(f, testChipSize); got != nil {
+		t.Errorf("got location %v in a single chip", got)
+	}
+}
+
+func TestChipsParseError(t *testing.T) {
+	image := twoChipImage(t)
+	f, err := Parse(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bios := f.(*FlashImage).Regions[1].Value
+	_, s := firstSection(t, bios)
+	_, offset, ok := Locate(f, s)
+	if !ok || offset == nil {
+		t.Fatalf("section not located")
+	}
+
+	// Make the section larger than its file.
+	size := Write3Size(0xfffff0)
+	copy(image[*offset:], size[:])
+	_, err = Parse(image)
+	var ne *NodeError
+	if !errors.As(err, &ne) {
+		t.Fatalf("got %v, want a *NodeError", err)
+	}
+	if ne.Chip == nil || *ne.Chip != (ChipLocation{Chip: 1, Offset: *offset - testChipSize}) {
+		t.Errorf("got chip location %v, want chip 1 at %#x", ne.Chip, *offset-testChipSize)
+	}
+	if !strings.Contains(ne.Error(), "(chip 1 at ") {
+		t.Errorf("got error %q, want the chip location", ne.Error())
+	}
+}
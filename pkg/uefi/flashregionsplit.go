@@ -0,0 +1,124 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Names of the flash region parts which are not regions.
+const (
+	FlashPartDescriptor = "descriptor"
+	FlashPartGap        = "gap"
+)
+
+// FlashRegionPart is a part of a flash image: its descriptor, one of the
+// regions the descriptor declares, or bytes between them.
+type FlashRegionPart struct {
+	// Name is FlashPartDescriptor, FlashPartGap or the lower case name of
+	// the region type, such as "me" or "bios".
+	Name   string
+	Offset uint64
+	Size   uint64
+	Data   []byte `json:"-"`
+}
+
+// FlashRegionLayout returns the parts of a flash image of size bytes whose
+// descriptor is ifd, sorted by offset and without their data. The parts
+// cover the whole image: the bytes which neither the descriptor nor a region
+// holds make gaps.
+func FlashRegionLayout(ifd []byte, size uint64) ([]FlashRegionPart, error) {
+	if len(ifd) < FlashDescriptorLength || size < FlashDescriptorLength {
+		return nil, fmt.Errorf("the image is too small for a flash descriptor: %#x bytes", size)
+	}
+	fd := &FlashDescriptor{buf: ifd[:FlashDescriptorLength]}
+	if err := fd.ParseFlashDescriptor(); err != nil {
+		return nil, err
+	}
+	regions := []FlashRegionPart{{Name: FlashPartDescriptor, Size: FlashDescriptorLength}}
+	nr := int(fd.DescriptorMap.NumberOfRegions)
+	for i, fr := range fd.Region.FlashRegions {
+		// As in NewFlashImage, older descriptors have falsely valid
+		// regions past their number of regions.
+		if nr != 0 && i >= nr {
+			break
+		}
+		if !fr.Valid() {
+			continue
+		}
+		name := strings.ToLower(FlashRegionType(i).String())
+		if uint64(fr.EndOffset()) > size {
+			return nil, fmt.Errorf("%s region %v ends at %#x, past the image of %#x bytes", name, &fr, fr.EndOffset(), size)
+		}
+		regions = append(regions, FlashRegionPart{Name: name, Offset: uint64(fr.BaseOffset()), Size: uint64(fr.EndOffset() - fr.BaseOffset())})
+	}
+	sort.SliceStable(regions, func(i, j int) bool {
+		return regions[i].Offset < regions[j].Offset
+	})
+
+	var parts []FlashRegionPart
+	var offset uint64
+	for _, r := range regions {
+		if r.Offset < offset {
+			return nil, fmt.Errorf("%s region at %#x overlaps %s", r.Name, r.Offset, parts[len(parts)-1].Name)
+		}
+		if r.Offset > offset {
+			parts = append(parts, FlashRegionPart{Name: FlashPartGap, Offset: offset, Size: r.Offset - offset})
+		}
+		parts = append(parts, r)
+		offset = r.Offset + r.Size
+	}
+	if offset < size {
+		parts = append(parts, FlashRegionPart{Name: FlashPartGap, Offset: offset, Size: size - offset})
+	}
+	return parts, nil
+}
+
+// SplitRegions returns the parts of a flash image, see FlashRegionLayout,
+// with their data.
+func SplitRegions(image []byte) ([]FlashRegionPart, error) {
+	parts, err := FlashRegionLayout(image, uint64(len(image)))
+	if err != nil {
+		return nil, err
+	}
+	for i := range parts {
+		parts[i].Data = image[parts[i].Offset : parts[i].Offset+parts[i].Size]
+	}
+	return parts, nil
+}
+
+// JoinRegions returns the flash image made of parts, as returned by
+// SplitRegions. Each part must have the name, offset and size the
+// descriptor, which is the first part, declares, and no part may be missing.
+func JoinRegions(parts ...FlashRegionPart) ([]byte, error) {
+	if len(parts) == 0 || parts[0].Name != FlashPartDescriptor {
+		return nil, fmt.Errorf("the first part is not the flash descriptor")
+	}
+	var size uint64
+	for _, p := range parts {
+		size += uint64(len(p.Data))
+	}
+	layout, err := FlashRegionLayout(parts[0].Data, size)
+	if err != nil {
+		return nil, err
+	}
+	if len(layout) != len(parts) {
+		return nil, fmt.Errorf("the descriptor declares %d parts for an image of %#x bytes, got %d parts", len(layout), size, len(parts))
+	}
+	image := make([]byte, 0, size)
+	for i, want := range layout {
+		p := parts[i]
+		if p.Name != want.Name || p.Offset != want.Offset {
+			return nil, fmt.Errorf("part %d: expected %s at %#x, got %s at %#x", i, want.Name, want.Offset, p.Name, p.Offset)
+		}
+		if uint64(len(p.Data)) != want.Size {
+			return nil, fmt.Errorf("%s at %#x: expected %#x bytes, got %#x", p.Name, p.Offset, want.Size, len(p.Data))
+		}
+		image = append(image, p.Data...)
+	}
+	return image, nil
+}
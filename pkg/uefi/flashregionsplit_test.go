@@ -0,0 +1,82 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// regionsImage returns twoChipImage with an ME region at 0x1000 and a GbE
+// region at 0x4000.
+func regionsImage(t *testing.T) []byte {
+	image := twoChipImage(t)
+	binary.LittleEndian.PutUint16(image[0x48:], 0x1)
+	binary.LittleEndian.PutUint16(image[0x4a:], 0x2)
+	binary.LittleEndian.PutUint16(image[0x4c:], 0x4)
+	binary.LittleEndian.PutUint16(image[0x4e:], 0x4)
+	return image
+}
+
+func TestSplitRegions(t *testing.T) {
+	image := regionsImage(t)
+	parts, err := SplitRegions(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []FlashRegionPart
+	for _, p := range parts {
+		if !bytes.Equal(p.Data, image[p.Offset:p.Offset+p.Size]) {
+			t.Errorf("%s: the data is not that of the image", p.Name)
+		}
+		p.Data = nil
+		got = append(got, p)
+	}
+	want := []FlashRegionPart{
+		{Name: FlashPartDescriptor, Offset: 0, Size: 0x1000},
+		{Name: "me", Offset: 0x1000, Size: 0x2000},
+		{Name: FlashPartGap, Offset: 0x3000, Size: 0x1000},
+		{Name: "gbe", Offset: 0x4000, Size: 0x1000},
+		{Name: FlashPartGap, Offset: 0x5000, Size: testChipSize - 0x5000},
+		{Name: "bios", Offset: testChipSize, Size: testChipSize},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got parts %+v, want %+v", got, want)
+	}
+
+	joined, err := JoinRegions(parts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(joined, image) {
+		t.Error("the joined image differs")
+	}
+
+	// A region of the wrong size.
+	bad := append([]FlashRegionPart{}, parts...)
+	bad[1].Data = bad[1].Data[:0x1000]
+	if _, err := JoinRegions(bad...); err == nil {
+		t.Error("joining a short ME region succeeded")
+	}
+	// A missing region.
+	if _, err := JoinRegions(append(append([]FlashRegionPart{}, parts[:3]...), parts[4:]...)...); err == nil {
+		t.Error("joining without the GbE region succeeded")
+	}
+	// The descriptor is first.
+	if _, err := JoinRegions(parts[1:]...); err == nil {
+		t.Error("joining without the descriptor succeeded")
+	}
+
+	// Regions may not overlap.
+	binary.LittleEndian.PutUint16(image[0x4c:], 0x2)
+	if _, err := SplitRegions(image); err == nil {
+		t.Error("splitting overlapping regions succeeded")
+	}
+	if _, err := SplitRegions(image[:testChipSize]); err == nil {
+		t.Error("splitting a truncated image succeeded")
+	}
+}
@@ -41,6 +41,8 @@ type MEFPT struct {
 	Entries           []MEPartitionEntry
 	// Metadata for extraction and recovery
 	ExtractPath string
+
+	parsedNode
 }
 
 // MEPartitionEntry is an entry in FTP
@@ -174,6 +176,8 @@ type MERegion struct {
 	RegionType FlashRegionType
 	// Computed free space after parsing the partition table
 	FreeSpaceOffset uint64
+
+	parsedNode
 }
 
 // SetFlashRegion sets the flash region.
@@ -188,12 +192,15 @@ func (rr *MERegion) FlashRegion() (fr *FlashRegion) {
 
 // NewMERegion creates a new region.
 func NewMERegion(buf []byte, r *FlashRegion, rt FlashRegionType) (Region, error) {
+	return newMERegion(nil, buf, r, rt, false)
+}
+
+func newMERegion(p *parser, buf []byte, r *FlashRegion, rt FlashRegionType, owned bool) (Region, error) {
 	rr := &MERegion{FRegion: r, RegionType: rt}
-	rr.buf = make([]byte, len(buf))
-	copy(rr.buf, buf)
+	rr.buf = p.ownBuf(buf, uint64(len(buf)), owned)
 	fp, err := NewMEFPT(buf)
 	if err != nil {
-		log.Errorf("error parsing ME Flash Partition Table: %v", err)
+		log.With(log.F("region", rt)).Errorf("error parsing ME Flash Partition Table: %v", err)
 		return rr, nil
 	}
 	rr.FPT = fp
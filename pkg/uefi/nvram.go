@@ -109,7 +109,7 @@ type NVar struct {
 	Checksum                    *uint8            `json:",omitempty"`
 	ExpectedChecksum            *uint8            `json:",omitempty"`
 	TimeStamp                   *uint64           `json:",omitempty"`
-	Hash                        []byte            `json:",omitempty"`
+	Hash                        HexBytes          `json:",omitempty"`
 	UnknownExtendedHeaderFormat bool              `json:",omitempty"`
 
 	//Metadata for extraction and recovery
@@ -117,6 +117,8 @@ type NVar struct {
 	ExtractPath string
 	DataOffset  int64
 	ExtOffset   int64 `json:",omitempty"`
+
+	parsedNode
 }
 
 // String returns the String value of the NVAR: Type and Name if valid
@@ -164,6 +166,8 @@ type NVarStore struct {
 	FreeSpaceOffset uint64
 	GUIDStoreOffset uint64
 	Length          uint64
+
+	parsedNode
 }
 
 // Buf returns the buffer.
@@ -195,16 +199,16 @@ func (s *NVarStore) ApplyChildren(v Visitor) error {
 
 func (s *NVarStore) getGUIDFromStore(i uint8) guid.GUID {
 	var GUID guid.GUID
-	if len(s.GUIDStore) < int(i+1) {
+	if len(s.GUIDStore) < int(i)+1 {
 		// Read GUID in reverse order from the buffer
 		r := bytes.NewReader(s.buf)
-		if _, err := r.Seek(-int64(binary.Size(GUID))*int64(i+1), io.SeekEnd); err != nil {
+		if _, err := r.Seek(-int64(binary.Size(GUID))*(int64(i)+1), io.SeekEnd); err != nil {
 			// not returning an error as this is really unlikely, in most
 			// overflow case we will read NVAR content as GUID as the store
 			// buffer is expected to be big enough...
 			return *ZeroGUID
 		}
-		a := make([]guid.GUID, int(i+1)-len(s.GUIDStore))
+		a := make([]guid.GUID, int(i)+1-len(s.GUIDStore))
 		for j := int(i) - len(s.GUIDStore); j >= 0; j-- {
 			// no error check as the Seek will fail first
 			if err := binary.Read(r, binary.LittleEndian, &a[j]); err != nil {
@@ -229,6 +233,9 @@ func (v *NVar) parseHeader(buf []byte) error {
 		return fmt.Errorf("NVAR Size bigger than remaining size")
 	}
 	v.DataOffset = int64(binary.Size(v.Header))
+	if int64(v.Header.Size) < v.DataOffset {
+		return fmt.Errorf("NVAR Size smaller than header size")
+	}
 	return nil
 }
 
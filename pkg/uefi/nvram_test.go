@@ -348,3 +348,12 @@ func TestNVarStore_GetGUIDStoreBuf(t *testing.T) {
 		})
 	}
 }
+
+func FuzzNewNVarStore(f *testing.F) {
+	f.Add(testNVarStore)
+	f.Add(stored1GUIDASCIINameNVar)
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		Attributes.ErasePolarity = 0xFF
+		_, _ = NewNVarStore(buf)
+	})
+}
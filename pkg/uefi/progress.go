@@ -0,0 +1,92 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Phases of the long operations which report their progress.
+const (
+	ProgressParse      = "parse"
+	ProgressDecompress = "decompress"
+	ProgressValidate   = "validate"
+	ProgressAssemble   = "assemble"
+)
+
+// ProgressFunc receives the progress of a phase: done units of work out of
+// total, or out of an unknown number when total is 0. The units are files,
+// except for decompression which counts compressed sections. It may be
+// called concurrently.
+type ProgressFunc func(phase string, done, total uint64)
+
+var (
+	progressMu   sync.Mutex
+	progressFunc ProgressFunc
+)
+
+// SetProgress sets the function receiving the progress reports of the
+// operations whose context carries none, see WithProgress. Progress is not
+// reported by default, and nil disables it again.
+func SetProgress(f ProgressFunc) {
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	progressFunc = f
+}
+
+type progressKey struct{}
+
+// WithProgress returns a copy of ctx whose operations, such as the Parse of
+// ParseContext, report their progress to f rather than to the function set
+// by SetProgress. A nil f disables the reports.
+func WithProgress(ctx context.Context, f ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, f)
+}
+
+// progressFuncOf returns the function receiving the progress reports of the
+// operations of ctx.
+func progressFuncOf(ctx context.Context) ProgressFunc {
+	if f, ok := ctx.Value(progressKey{}).(ProgressFunc); ok {
+		return f
+	}
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	return progressFunc
+}
+
+// ProgressEnabled reports whether the operations of ctx report their
+// progress, so callers can skip computing the total units of work otherwise.
+func ProgressEnabled(ctx context.Context) bool {
+	return progressFuncOf(ctx) != nil
+}
+
+// Progress counts the units of work done in a phase and reports them.
+type Progress struct {
+	f     ProgressFunc
+	phase string
+	total uint64
+	done  atomic.Uint64
+}
+
+// NewProgress returns a counter of the total units of work of a phase of an
+// operation of ctx, or nil when its progress is not reported. The methods of
+// a nil *Progress do nothing, so callers need not check.
+func NewProgress(ctx context.Context, phase string, total uint64) *Progress {
+	f := progressFuncOf(ctx)
+	if f == nil {
+		return nil
+	}
+	return &Progress{f: f, phase: phase, total: total}
+}
+
+// Step counts one more unit of work done and reports it.
+func (p *Progress) Step() {
+	if p == nil {
+		return
+	}
+	p.f(p.phase, p.done.Add(1), p.total)
+}
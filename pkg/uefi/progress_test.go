@@ -0,0 +1,86 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestParseProgress(t *testing.T) {
+	if p := NewProgress(context.Background(), ProgressParse, 0); p != nil {
+		t.Errorf("got a counter while progress is disabled")
+	}
+
+	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]uint64{}
+	SetProgress(func(phase string, done, total uint64) {
+		if total != 0 {
+			t.Errorf("%s: got total %d, want 0 as it is unknown while parsing", phase, total)
+		}
+		got[phase] = done
+	})
+	defer SetProgress(nil)
+	if _, err := Parse(image); err != nil {
+		t.Fatal(err)
+	}
+
+	// OVMF has 118 files, with the DXE and PEI volumes in one compressed
+	// section.
+	if got[ProgressParse] != 118 {
+		t.Errorf("got %d parsed files, want 118", got[ProgressParse])
+	}
+	if got[ProgressDecompress] != 1 {
+		t.Errorf("got %d decompressed sections, want 1", got[ProgressDecompress])
+	}
+}
+
+func TestParseProgressContext(t *testing.T) {
+	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each parse counts its own files, and the reports of a parse go to the
+	// function of its context, which may itself query the progress.
+	const parses = 4
+	var wg sync.WaitGroup
+	done := make([]uint64, parses)
+	errs := make([]error, parses)
+	for i := 0; i < parses; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx := context.Background()
+			ctx = WithProgress(ctx, func(phase string, n, total uint64) {
+				if phase == ProgressParse && ProgressEnabled(ctx) && n > done[i] {
+					done[i] = n
+				}
+			})
+			_, errs[i] = ParseContext(ctx, image)
+		}(i)
+	}
+	wg.Wait()
+	for i := range done {
+		if errs[i] != nil {
+			t.Errorf("parse %d: %v", i, errs[i])
+		} else if done[i] != 118 {
+			t.Errorf("parse %d: got %d parsed files, want 118", i, done[i])
+		}
+	}
+
+	// A nil function disables the reports set by SetProgress.
+	SetProgress(func(phase string, done, total uint64) {
+		t.Errorf("got a report of %s while disabled by the context", phase)
+	})
+	defer SetProgress(nil)
+	if _, err := ParseContext(WithProgress(context.Background(), nil), image); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -14,6 +14,8 @@ type RawRegion struct {
 	FRegion *FlashRegion
 	// Region Type as per the IFD
 	RegionType FlashRegionType
+
+	parsedNode
 }
 
 // SetFlashRegion sets the flash region.
@@ -28,9 +30,12 @@ func (rr *RawRegion) FlashRegion() (fr *FlashRegion) {
 
 // NewRawRegion creates a new region.
 func NewRawRegion(buf []byte, r *FlashRegion, rt FlashRegionType) (Region, error) {
+	return newRawRegion(nil, buf, r, rt, false)
+}
+
+func newRawRegion(p *parser, buf []byte, r *FlashRegion, rt FlashRegionType, owned bool) (Region, error) {
 	rr := &RawRegion{FRegion: r, RegionType: rt}
-	rr.buf = make([]byte, len(buf))
-	copy(rr.buf, buf)
+	rr.buf = p.ownBuf(buf, uint64(len(buf)), owned)
 	return rr, nil
 }
 
@@ -96,23 +96,25 @@ func (r *FlashRegion) EndOffset() uint32 {
 	return (uint32(r.Limit) + 1) * RegionBlockSize
 }
 
-var regionConstructors = map[FlashRegionType]func(buf []byte, r *FlashRegion, rt FlashRegionType) (Region, error){
-	RegionTypeBIOS:      NewBIOSRegion,
-	RegionTypeME:        NewMERegion,
-	RegionTypeGBE:       NewRawRegion,
-	RegionTypePD:        NewRawRegion,
-	RegionTypeDevExp1:   NewRawRegion,
-	RegionTypeBIOS2:     NewRawRegion,
-	RegionTypeMicrocode: NewRawRegion,
-	RegionTypeEC:        NewRawRegion,
-	RegionTypeDevExp2:   NewRawRegion,
-	RegionTypeIE:        NewRawRegion,
-	RegionTypeTGBE1:     NewRawRegion,
-	RegionTypeTGBE2:     NewRawRegion,
-	RegionTypeReserved1: NewRawRegion,
-	RegionTypeReserved2: NewRawRegion,
-	RegionTypePTT:       NewRawRegion,
-	RegionTypeUnknown:   NewRawRegion,
+// regionConstructors parse the regions of a flash image, whose buffer is
+// owned by the parser, see ownBuf.
+var regionConstructors = map[FlashRegionType]func(p *parser, buf []byte, r *FlashRegion, rt FlashRegionType, owned bool) (Region, error){
+	RegionTypeBIOS:      newBIOSRegion,
+	RegionTypeME:        newMERegion,
+	RegionTypeGBE:       newRawRegion,
+	RegionTypePD:        newRawRegion,
+	RegionTypeDevExp1:   newRawRegion,
+	RegionTypeBIOS2:     newRawRegion,
+	RegionTypeMicrocode: newRawRegion,
+	RegionTypeEC:        newRawRegion,
+	RegionTypeDevExp2:   newRawRegion,
+	RegionTypeIE:        newRawRegion,
+	RegionTypeTGBE1:     newRawRegion,
+	RegionTypeTGBE2:     newRawRegion,
+	RegionTypeReserved1: newRawRegion,
+	RegionTypeReserved2: newRawRegion,
+	RegionTypePTT:       newRawRegion,
+	RegionTypeUnknown:   newRawRegion,
 }
 
 // Region contains the start and end of a region in flash. This can be a BIOS, ME, PDR or GBE region.
@@ -10,6 +10,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"unsafe"
 
 	"github.com/linuxboot/fiano/pkg/compression"
@@ -66,6 +67,16 @@ var sectionTypeNames = map[SectionType]string{
 	SectionMMDepEx:                 "EFI_SECTION_MM_DEPEX",
 }
 
+// NamesToSectionType maps from common section type strings to the actual type.
+var NamesToSectionType map[string]SectionType
+
+func init() {
+	NamesToSectionType = make(map[string]SectionType)
+	for k, v := range sectionTypeNames {
+		NamesToSectionType[strings.TrimPrefix(v, "EFI_SECTION_")] = k
+	}
+}
+
 // String creates a string representation for the section type.
 func (s SectionType) String() string {
 	if t, ok := sectionTypeNames[s]; ok {
@@ -83,6 +94,12 @@ const (
 	GUIDEDSectionAuthStatusValid    GUIDEDSectionAttribute = 0x02
 )
 
+// Compression types of EFI_SECTION_COMPRESSION sections.
+const (
+	CompressionTypeNone     uint8 = 0x00
+	CompressionTypeStandard uint8 = 0x01
+)
+
 // SectionHeader represents an EFI_COMMON_SECTION_HEADER as specified in
 // UEFI PI Spec 3.2.4 Firmware File Section
 type SectionHeader struct {
@@ -119,6 +136,40 @@ func (s *SectionGUIDDefined) GetBinHeaderLen() uint32 {
 	return uint32(unsafe.Sizeof(s.SectionGUIDDefinedHeader))
 }
 
+// SectionCompressionHeader contains the fields for a EFI_SECTION_COMPRESSION
+// encapsulated section header.
+type SectionCompressionHeader struct {
+	UncompressedLength uint32
+	CompressionType    uint8
+}
+
+// SectionCompression contains the type specific fields for a
+// EFI_SECTION_COMPRESSION section.
+type SectionCompression struct {
+	SectionCompressionHeader
+
+	// Metadata
+	Compression string
+}
+
+// GetBinHeaderLen returns the length of the binary typ specific header
+func (s *SectionCompression) GetBinHeaderLen() uint32 {
+	return uint32(binary.Size(s.SectionCompressionHeader))
+}
+
+// Compressor returns the compressor of the section data, nil if it is not
+// compressed. Standard compression is EFI 1.1 compression but some vendors
+// use Tiano compression, which parsing detects.
+func (s *SectionCompression) Compressor() compression.Compressor {
+	if s.CompressionType != CompressionTypeStandard {
+		return nil
+	}
+	if s.Compression == "TIANO" {
+		return &compression.Tiano{}
+	}
+	return &compression.Tiano{EFI: true}
+}
+
 // TypeHeader interface forces type specific headers to report their length
 type TypeHeader interface {
 	GetBinHeaderLen() uint32
@@ -132,6 +183,7 @@ type TypeSpecificHeader struct {
 
 var headerTypes = map[SectionType]func() TypeHeader{
 	SectionTypeGUIDDefined: func() TypeHeader { return &SectionGUIDDefined{} },
+	SectionTypeCompression: func() TypeHeader { return &SectionCompression{} },
 }
 
 // UnmarshalJSON unmarshals a TypeSpecificHeader struct and correctly deduces the
@@ -211,6 +263,8 @@ type Section struct {
 
 	// Encapsulated firmware
 	Encapsulated []*TypedFirmware `json:",omitempty"`
+
+	parsedNode
 }
 
 // String returns the String value of the section if it makes sense,
@@ -281,18 +335,17 @@ func CreateSection(t SectionType, buf []byte, encap []Firmware, g *guid.GUID) (*
 		}
 		guidDefHeader := &SectionGUIDDefined{}
 		guidDefHeader.GUID = *g
-		switch *g {
-		case compression.BROTLIGUID:
-			guidDefHeader.Compression = "BROTLI"
-		case compression.LZMAGUID:
-			guidDefHeader.Compression = "LZMA"
-		case compression.LZMAX86GUID:
-			guidDefHeader.Compression = "LZMAX86"
-		default:
-			guidDefHeader.Compression = "UNKNOWN"
+		guidDefHeader.Compression = "UNKNOWN"
+		if c := compression.CompressorFromGUID(g); c != nil {
+			guidDefHeader.Compression = c.Name()
 		}
 		guidDefHeader.Attributes = uint16(GUIDEDSectionProcessingRequired)
 		s.TypeSpecific = &TypeSpecificHeader{SectionTypeGUIDDefined, guidDefHeader}
+	case SectionTypeCompression:
+		compHeader := &SectionCompression{}
+		compHeader.CompressionType = CompressionTypeStandard
+		compHeader.Compression = "EFI"
+		s.TypeSpecific = &TypeSpecificHeader{SectionTypeCompression, compHeader}
 	}
 
 	return s, nil
@@ -327,6 +380,14 @@ func (s *Section) GenSecHeader() error {
 		}
 		s.buf = append(tsh.Bytes(), s.buf...)
 	}
+	if s.Header.Type == SectionTypeCompression {
+		c := s.TypeSpecific.Header.(*SectionCompression)
+		tsh := new(bytes.Buffer)
+		if err = binary.Write(tsh, binary.LittleEndian, &c.SectionCompressionHeader); err != nil {
+			return err
+		}
+		s.buf = append(tsh.Bytes(), s.buf...)
+	}
 
 	// Append common header
 	s.Header.Size = Write3Size(uint64(s.Header.ExtendedSize))
@@ -346,6 +407,16 @@ func (s *Section) GenSecHeader() error {
 // NewSection parses a sequence of bytes and returns a Section
 // object, if a valid one is passed, or an error.
 func NewSection(buf []byte, fileOrder int) (*Section, error) {
+	return newSection(nil, buf, fileOrder, 0, false)
+}
+
+// newSection parses a section nested in depth encapsulation sections and
+// firmware volume images. owned tells whether buf is owned by the parser, see
+// ownBuf.
+func newSection(p *parser, buf []byte, fileOrder int, depth int, owned bool) (*Section, error) {
+	if depth > MaxNestingDepth {
+		return nil, fmt.Errorf("sections are nested more than %d levels deep", MaxNestingDepth)
+	}
 	s := Section{FileOrder: fileOrder}
 	// Read in standard header.
 	r := bytes.NewReader(buf)
@@ -387,16 +458,13 @@ func NewSection(buf []byte, fileOrder int) (*Section, error) {
 		return nil, fmt.Errorf("section size mismatch! Section has size %v, but buffer is %v bytes big",
 			s.Header.ExtendedSize, buflen)
 	}
-
-	if ReadOnly {
-		s.buf = buf[:s.Header.ExtendedSize]
-	} else {
-		// Copy out the buffer.
-		newBuf := buf[:s.Header.ExtendedSize]
-		s.buf = make([]byte, s.Header.ExtendedSize)
-		copy(s.buf, newBuf)
+	if uint64(s.Header.ExtendedSize) < uint64(headerSize) {
+		return nil, fmt.Errorf("section size %v is smaller than its header (%v bytes)",
+			s.Header.ExtendedSize, headerSize)
 	}
 
+	s.buf = p.ownBuf(buf, uint64(s.Header.ExtendedSize), owned)
+
 	// Section type specific data
 	switch s.Header.Type {
 	case SectionTypeGUIDDefined:
@@ -405,60 +473,136 @@ func NewSection(buf []byte, fileOrder int) (*Section, error) {
 			return nil, err
 		}
 		s.TypeSpecific = &TypeSpecificHeader{Type: SectionTypeGUIDDefined, Header: typeSpec}
+		if uint32(typeSpec.DataOffset) > s.Header.ExtendedSize {
+			return nil, fmt.Errorf("GUID defined section data offset %#x is past the section size %#x",
+				typeSpec.DataOffset, s.Header.ExtendedSize)
+		}
 
 		// Determine how to interpret the section based on the GUID.
 		var encapBuf []byte
 		if typeSpec.Attributes&uint16(GUIDEDSectionProcessingRequired) != 0 && !DisableDecompression {
 			if compressor := compression.CompressorFromGUID(&typeSpec.GUID); compressor != nil {
+				if err := p.err(); err != nil {
+					return nil, err
+				}
 				typeSpec.Compression = compressor.Name()
+				_, endTrace := StartTrace(p.context(), ProgressDecompress)
 				var err error
-				encapBuf, err = compressor.Decode(buf[typeSpec.DataOffset:])
+				encapBuf, err = compressor.Decode(s.buf[typeSpec.DataOffset:])
+				endTrace(err)
 				if err != nil {
-					log.Errorf("%v", err)
+					log.With(log.F("guid", typeSpec.GUID)).Errorf("%v", err)
 					typeSpec.Compression = "UNKNOWN"
 					encapBuf = []byte{}
 				}
+				p.stepDecompress()
 			} else {
 				typeSpec.Compression = "UNKNOWN"
 			}
 		}
 
-		for i, offset := 0, uint64(0); offset < uint64(len(encapBuf)); i++ {
-			encapS, err := NewSection(encapBuf[offset:], i)
-			if err != nil {
-				return nil, fmt.Errorf("error parsing encapsulated section #%d at offset %d: %v",
-					i, offset, err)
+		var err error
+		if s.Encapsulated, err = parseEncapsulated(p, encapBuf, depth+1, 0, false); err != nil {
+			return nil, err
+		}
+
+	case SectionTypeCompression:
+		typeSpec := &SectionCompression{}
+		if err := binary.Read(r, binary.LittleEndian, &typeSpec.SectionCompressionHeader); err != nil {
+			return nil, err
+		}
+		s.TypeSpecific = &TypeSpecificHeader{Type: SectionTypeCompression, Header: typeSpec}
+		dataOffset := uint32(headerSize) + typeSpec.GetBinHeaderLen()
+		if dataOffset > s.Header.ExtendedSize {
+			return nil, fmt.Errorf("compression section size %#x is smaller than its header (%#x bytes)",
+				s.Header.ExtendedSize, dataOffset)
+		}
+		data := s.buf[dataOffset:]
+
+		switch {
+		case typeSpec.CompressionType == CompressionTypeNone:
+			typeSpec.Compression = "NONE"
+			var err error
+			if s.Encapsulated, err = parseEncapsulated(p, data, depth+1, uint64(dataOffset), true); err != nil {
+				return nil, err
+			}
+		case typeSpec.CompressionType != CompressionTypeStandard:
+			typeSpec.Compression = "UNKNOWN"
+		case !DisableDecompression:
+			if err := p.err(); err != nil {
+				return nil, err
+			}
+			// The data is EFI 1.1 or, for some vendors, Tiano compressed.
+			// Either may decode the other, take the first whose sections
+			// parse.
+			_, endTrace := StartTrace(p.context(), ProgressDecompress)
+			typeSpec.Compression = "UNKNOWN"
+			for _, c := range []compression.Compressor{&compression.Tiano{EFI: true}, &compression.Tiano{}} {
+				encapBuf, err := c.Decode(data)
+				if err != nil || uint32(len(encapBuf)) != typeSpec.UncompressedLength {
+					continue
+				}
+				if encap, err := parseEncapsulated(p, encapBuf, depth+1, 0, false); err == nil {
+					typeSpec.Compression = c.Name()
+					s.Encapsulated = encap
+					break
+				}
+			}
+			var err error
+			if typeSpec.Compression == "UNKNOWN" {
+				err = fmt.Errorf("unable to decompress section of %d bytes with EFI 1.1 or Tiano compression", len(data))
+				log.With(log.F("section", s.Type)).Errorf("%v", err)
 			}
-			// Align to 4 bytes for now. The PI Spec doesn't say what alignment it should be
-			// but UEFITool aligns to 4 bytes, and this seems to work on everything I have.
-			offset = Align4(offset + uint64(encapS.Header.ExtendedSize))
-			s.Encapsulated = append(s.Encapsulated, MakeTyped(encapS))
+			endTrace(err)
+			p.stepDecompress()
 		}
 
 	case SectionTypeUserInterface:
 		s.Name = unicode.UCS2ToUTF8(s.buf[headerSize:])
 
 	case SectionTypeVersion:
+		if len(s.buf) < int(headerSize)+2 {
+			return nil, fmt.Errorf("version section size %#x has no build number", s.Header.ExtendedSize)
+		}
 		s.BuildNumber = binary.LittleEndian.Uint16(s.buf[headerSize : headerSize+2])
 		s.Version = unicode.UCS2ToUTF8(s.buf[headerSize+2:])
 
 	case SectionTypeFirmwareVolumeImage:
-		fv, err := NewFirmwareVolume(s.buf[headerSize:], 0, true)
+		fv, err := newFirmwareVolume(p, s.buf[headerSize:], 0, true, depth+1, true)
 		if err != nil {
-			return nil, err
+			return nil, childError(err, fvNodeName(s.buf[headerSize:]), uint64(headerSize), true)
 		}
 		s.Encapsulated = []*TypedFirmware{MakeTyped(fv)}
 
 	case SectionTypeDXEDepEx, SectionTypePEIDepEx, SectionMMDepEx:
 		var err error
 		if s.DepEx, err = parseDepEx(s.buf[headerSize:]); err != nil {
-			log.Warnf("%v", err)
+			log.With(log.F("section", s.Type)).Warnf("invalid dependency expression: %v", err)
 		}
 	}
 
 	return &s, nil
 }
 
+// parseEncapsulated parses the sections of the data of an encapsulation
+// section, nested in depth encapsulation sections and firmware volume images.
+// The data is owned by the parser. It is at offset base of the section,
+// known is false if it is decompressed.
+func parseEncapsulated(p *parser, buf []byte, depth int, base uint64, known bool) ([]*TypedFirmware, error) {
+	var encap []*TypedFirmware
+	for i, offset := 0, uint64(0); offset < uint64(len(buf)); i++ {
+		encapS, err := newSection(p, buf[offset:], i, depth, true)
+		if err != nil {
+			return nil, childError(err, sectionNodeName(buf[offset:]), base+offset, known)
+		}
+		// Align to 4 bytes for now. The PI Spec doesn't say what alignment it should be
+		// but UEFITool aligns to 4 bytes, and this seems to work on everything I have.
+		offset = Align4(offset + uint64(encapS.Header.ExtendedSize))
+		encap = append(encap, MakeTyped(encapS))
+	}
+	return encap, nil
+}
+
 func parseDepEx(b []byte) ([]DepExOp, error) {
 	depEx := []DepExOp{}
 	r := bytes.NewBuffer(b)
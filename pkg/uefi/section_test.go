@@ -220,3 +220,34 @@ func TestParseDepEx(t *testing.T) {
 		})
 	}
 }
+
+// nestedSec returns a raw section wrapped in depth uncompressed compression
+// sections.
+func nestedSec(depth int) []byte {
+	buf := tinySec
+	for i := 0; i < depth; i++ {
+		size := len(buf) + 9
+		hdr := []byte{byte(size), byte(size >> 8), byte(size >> 16), byte(SectionTypeCompression)}
+		hdr = append(hdr, byte(len(buf)), byte(len(buf)>>8), byte(len(buf)>>16), 0, CompressionTypeNone)
+		buf = append(hdr, buf...)
+	}
+	return buf
+}
+
+func TestNewSectionNesting(t *testing.T) {
+	if _, err := NewSection(nestedSec(MaxNestingDepth), 0); err != nil {
+		t.Errorf("sections nested %d levels deep: %v", MaxNestingDepth, err)
+	}
+	if _, err := NewSection(nestedSec(MaxNestingDepth+1), 0); err == nil {
+		t.Errorf("sections nested %d levels deep: no error", MaxNestingDepth+1)
+	}
+}
+
+func FuzzNewSection(f *testing.F) {
+	for _, buf := range [][]byte{tinySec, smallSec, linuxSec, nestedSec(3)} {
+		f.Add(buf)
+	}
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		_, _ = NewSection(buf, 0)
+	})
+}
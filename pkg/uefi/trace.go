@@ -0,0 +1,58 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"context"
+	"sync"
+)
+
+// TraceFunc is called when a phase of a long operation starts: a Parse, the
+// decompression of a section during a Parse, a validation or an assembly.
+// The phases are named as in the progress reports. ctx is the context of
+// the operation, such as the one given to ParseContext, so it can carry
+// which image is processed. The returned context is the one of the phase,
+// which the phases nested in it get, and the returned function is called
+// with the error of the phase, or nil, once it ends. It may be called
+// concurrently.
+//
+// For example, a service can time the phases with OpenTelemetry spans:
+//
+//	uefi.SetTrace(func(ctx context.Context, phase string) (context.Context, func(error)) {
+//	    ctx, span := tracer.Start(ctx, phase)
+//	    return ctx, func(err error) {
+//	        if err != nil {
+//	            span.RecordError(err)
+//	        }
+//	        span.End()
+//	    }
+//	})
+type TraceFunc func(ctx context.Context, phase string) (context.Context, func(err error))
+
+var (
+	traceMu   sync.Mutex
+	traceFunc TraceFunc
+)
+
+// SetTrace sets the function tracing the phases. Phases are not traced by
+// default, and nil disables it again.
+func SetTrace(f TraceFunc) {
+	traceMu.Lock()
+	defer traceMu.Unlock()
+	traceFunc = f
+}
+
+// StartTrace starts tracing a phase, see TraceFunc. When tracing is
+// disabled, it returns ctx and a function doing nothing, so callers need not
+// check.
+func StartTrace(ctx context.Context, phase string) (context.Context, func(err error)) {
+	traceMu.Lock()
+	f := traceFunc
+	traceMu.Unlock()
+	if f == nil {
+		return ctx, func(error) {}
+	}
+	return f(ctx, phase)
+}
@@ -0,0 +1,135 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+)
+
+type traceKey struct{}
+
+// span is a traced phase.
+type span struct {
+	phase, parent string
+	ended         bool
+	err           error
+}
+
+// traceRecorder records the traced phases.
+type traceRecorder struct {
+	mu    sync.Mutex
+	spans []*span
+}
+
+func (r *traceRecorder) trace(ctx context.Context, phase string) (context.Context, func(error)) {
+	s := &span{phase: phase}
+	s.parent, _ = ctx.Value(traceKey{}).(string)
+	r.mu.Lock()
+	r.spans = append(r.spans, s)
+	r.mu.Unlock()
+	return context.WithValue(ctx, traceKey{}, phase), func(err error) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		s.ended, s.err = true, err
+	}
+}
+
+func setTrace(t *testing.T) *traceRecorder {
+	r := &traceRecorder{}
+	SetTrace(r.trace)
+	t.Cleanup(func() { SetTrace(nil) })
+	return r
+}
+
+func TestTraceParse(t *testing.T) {
+	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := setTrace(t)
+	ctx := context.WithValue(context.Background(), traceKey{}, "image")
+	if _, err := ParseContext(ctx, image); err != nil {
+		t.Fatal(err)
+	}
+
+	// OVMF has one compressed section, which is decompressed while parsing.
+	want := []span{
+		{phase: ProgressParse, parent: "image", ended: true},
+		{phase: ProgressDecompress, parent: ProgressParse, ended: true},
+	}
+	if len(r.spans) != len(want) {
+		t.Fatalf("got %d spans, want %d", len(r.spans), len(want))
+	}
+	for i, s := range r.spans {
+		if *s != want[i] {
+			t.Errorf("span %d: got %+v, want %+v", i, *s, want[i])
+		}
+	}
+}
+
+func TestTraceConcurrentParses(t *testing.T) {
+	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Count the decompression spans of each parse, labeled by the chain of
+	// their ancestors.
+	var mu sync.Mutex
+	decompressions := map[string]int{}
+	SetTrace(func(ctx context.Context, phase string) (context.Context, func(error)) {
+		label, _ := ctx.Value(traceKey{}).(string)
+		if phase == ProgressDecompress {
+			mu.Lock()
+			decompressions[label]++
+			mu.Unlock()
+		}
+		return context.WithValue(ctx, traceKey{}, label+"/"+phase), func(error) {}
+	})
+	t.Cleanup(func() { SetTrace(nil) })
+
+	const parses = 8
+	var wg sync.WaitGroup
+	errs := make([]error, parses)
+	for i := 0; i < parses; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx := context.WithValue(context.Background(), traceKey{}, fmt.Sprintf("image %d", i))
+			_, errs[i] = ParseContext(ctx, image)
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("parse %d: %v", i, err)
+		}
+		// OVMF has one compressed section.
+		if n := decompressions[fmt.Sprintf("image %d/%s", i, ProgressParse)]; n != 1 {
+			t.Errorf("parse %d: got %d decompression spans, want 1", i, n)
+		}
+	}
+}
+
+func TestTraceParseError(t *testing.T) {
+	r := setTrace(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ParseContext(ctx, sampleFV); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+	if len(r.spans) != 1 || !r.spans[0].ended || !errors.Is(r.spans[0].err, context.Canceled) {
+		t.Errorf("got spans %+v, want a canceled parse", r.spans)
+	}
+
+	SetTrace(nil)
+	if ctx, end := StartTrace(context.Background(), ProgressParse); ctx != context.Background() || end == nil {
+		t.Errorf("tracing while it is disabled")
+	}
+}
@@ -8,7 +8,10 @@ package uefi
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"reflect"
@@ -16,7 +19,8 @@ import (
 
 var (
 	// ReadOnly breaks firmware modification operations, but optimizes
-	// memory and CPU consumption for read-only operations.
+	// memory and CPU consumption for read-only operations. Parse and
+	// ParseContext then parse in ParseModeReadOnly.
 	//
 	// DO NOT USE THIS OPTION UNLESS YOU KNOW WHAT ARE YOU DOING. IF YOU
 	// WILL MODIFY A FIRMWARE WITH THIS OPTION BEING ENABLED, THIS FIRMWARE
@@ -29,6 +33,11 @@ var (
 	// WILL MODIFY A FIRMWARE WITH THIS OPTION BEING ENABLED, THIS FIRMWARE
 	// MIGHT BRICK YOUR DEVICE.
 	DisableDecompression = false
+
+	// MaxNestingDepth bounds the nesting of encapsulation sections and
+	// firmware volume images, so that crafted images cannot exhaust the
+	// stack. Real images nest a handful of levels.
+	MaxNestingDepth = 32
 )
 
 // ROMAttributes is used to hold global variables that apply across the whole image.
@@ -139,6 +148,10 @@ var firmwareTypes = map[string]func() Firmware{
 }
 
 // MarshalFirmware marshals the firmware element to JSON, including the type information at the top.
+// The JSON of a tree only depends on the tree, so it can be diffed and hashed:
+// the fields keep the order of the types, the children keep the order of the
+// image, the paths of the extracted files are separated with slashes and the
+// byte strings are encoded in lowercase hexadecimal.
 func MarshalFirmware(f Firmware) ([]byte, error) {
 	b, err := json.MarshalIndent(f, "", "    ")
 	if err != nil {
@@ -164,17 +177,251 @@ func UnmarshalFirmware(b []byte) (Firmware, error) {
 	return f, err
 }
 
+// HexBytes is a byte string encoded in JSON as a lowercase hexadecimal
+// string rather than in base64.
+type HexBytes []byte
+
+// MarshalJSON implements the json.Marshaler interface.
+func (b HexBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(b))
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface. It only reads
+// hexadecimal strings, and the base64 strings of the JSON written before
+// HexBytes, see isLegacyBase64.
+func (b *HexBytes) UnmarshalJSON(j []byte) error {
+	var s string
+	if err := json.Unmarshal(j, &s); err != nil {
+		return err
+	}
+	if isLegacyBase64(s) {
+		buf, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return fmt.Errorf("%q is neither hexadecimal nor base64: %v", s, err)
+		}
+		*b = buf
+		return nil
+	}
+	buf, err := hex.DecodeString(s)
+	if err != nil {
+		return fmt.Errorf("%q is not hexadecimal: %v", s, err)
+	}
+	*b = buf
+	return nil
+}
+
+// isLegacyBase64 tells whether s is meant as base64 rather than hexadecimal:
+// it is padded to a multiple of 4 characters, one of which at least is not
+// a hexadecimal digit. The strings of hexadecimal digits are always read as
+// hexadecimal.
+func isLegacyBase64(s string) bool {
+	if len(s)%4 != 0 {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseMode tells how Parse shares the image with the parsed tree.
+type ParseMode int
+
+// Parse modes.
+const (
+	// ParseModeCopy copies the image, which the caller may then reuse.
+	ParseModeCopy ParseMode = iota
+	// ParseModeReadOnly shares the image with the tree, which must not be
+	// modified: the visitors which modify the firmware refuse to run on
+	// it, and the others may run concurrently. The image must not change
+	// while the tree is in use.
+	ParseModeReadOnly
+	// ParseModeCopyOnWrite shares the image with the tree until the tree
+	// is modified. The modified nodes get new buffers rather than being
+	// written in place, so the image is never written to.
+	ParseModeCopyOnWrite
+)
+
+var parseModeNames = map[ParseMode]string{
+	ParseModeCopy:        "copy",
+	ParseModeReadOnly:    "read-only",
+	ParseModeCopyOnWrite: "copy-on-write",
+}
+
+func (m ParseMode) String() string {
+	if s, ok := parseModeNames[m]; ok {
+		return s
+	}
+	return fmt.Sprintf("ParseMode(%d)", int(m))
+}
+
+// parsedNode is embedded in the nodes of the tree to record the mode they
+// were parsed in.
+type parsedNode struct {
+	mode ParseMode
+}
+
+func (n *parsedNode) parsedMode() ParseMode {
+	return n.mode
+}
+
+func (n *parsedNode) setParsedMode(mode ParseMode) {
+	n.mode = mode
+}
+
+// TreeMode returns the mode in which the node was parsed. The nodes which
+// are not built by Parse, such as the ones inserted in the tree, are in
+// ParseModeCopy.
+func TreeMode(f Firmware) ParseMode {
+	if n, ok := f.(interface{ parsedMode() ParseMode }); ok {
+		return n.parsedMode()
+	}
+	return ParseModeCopy
+}
+
+// modeSetter records the parse mode in all the nodes of a tree.
+type modeSetter struct {
+	mode ParseMode
+}
+
+func (v *modeSetter) Run(f Firmware) error {
+	return f.Apply(v)
+}
+
+func (v *modeSetter) Visit(f Firmware) error {
+	if n, ok := f.(interface{ setParsedMode(ParseMode) }); ok {
+		n.setParsedMode(v.mode)
+	}
+	return f.ApplyChildren(v)
+}
+
+// parser is the state of a Parse, passed down to the parsers of the nodes
+// rather than shared by concurrent parses. The exported constructors of the
+// nodes parse with a nil parser.
+type parser struct {
+	// ctx is the context of the Parse, which stops it once done and whose
+	// trace the decompression spans belong to.
+	ctx context.Context
+	// shares tells whether the tree shares the image rather than copying
+	// it, see ParseMode.
+	shares bool
+	// Counters of the parsed files and of the decompressed sections.
+	files        *Progress
+	decompressed *Progress
+}
+
+// context returns the context of the Parse, or the background context for
+// a nil parser.
+func (p *parser) context() context.Context {
+	if p == nil || p.ctx == nil {
+		return context.Background()
+	}
+	return p.ctx
+}
+
+// err returns the error of the context of the Parse once it is done, and
+// nil otherwise.
+func (p *parser) err() error {
+	return p.context().Err()
+}
+
+// stepFile counts one more parsed file.
+func (p *parser) stepFile() {
+	if p != nil {
+		p.files.Step()
+	}
+}
+
+// stepDecompress counts one more decompressed section.
+func (p *parser) stepDecompress() {
+	if p != nil {
+		p.decompressed.Step()
+	}
+}
+
+// ownBuf returns the n first bytes of buf as the buffer of a parsed element.
+// They are copied unless the parse shares the image, or ReadOnly is set for
+// a nil parser, or buf is owned by the parser already, i.e. it is a part of
+// the buffer of the parent element or of decompressed data. The parsers then
+// copy the image once rather than once per nesting level. The capacity is
+// n, so that appending to the buffer of the element reallocates it rather
+// than overwriting what follows.
+func (p *parser) ownBuf(buf []byte, n uint64, owned bool) []byte {
+	if p == nil {
+		return ownBuf(buf, n, owned || ReadOnly)
+	}
+	return ownBuf(buf, n, owned || p.shares)
+}
+
 // Parse exposes a high-level parser for generic firmware types. It does not
 // implement any parser itself, but it calls known parsers that implement the
 // Firmware interface.
 func Parse(buf []byte) (Firmware, error) {
+	return ParseContext(context.Background(), buf)
+}
+
+// ParseContext is Parse, stopping with the error of ctx once ctx is done.
+// The context is checked before each file is parsed and each section is
+// decompressed. Parses may run concurrently, each stopping with its own
+// context, as long as the images have the same erase polarity, which is
+// global.
+func ParseContext(ctx context.Context, buf []byte) (Firmware, error) {
+	mode := ParseModeCopy
+	if ReadOnly {
+		mode = ParseModeReadOnly
+	}
+	return ParseWithMode(ctx, buf, mode)
+}
+
+// ParseWithMode is ParseContext, sharing buf with the tree as told by mode.
+// The read-only and copy-on-write modes save copying the image, which is
+// worth it for large images which are mapped or already held in memory.
+func ParseWithMode(ctx context.Context, buf []byte, mode ParseMode) (Firmware, error) {
+	if _, ok := parseModeNames[mode]; !ok {
+		return nil, fmt.Errorf("unknown parse mode %v", mode)
+	}
+	ctx, endTrace := StartTrace(ctx, ProgressParse)
+	p := &parser{
+		ctx:    ctx,
+		shares: mode != ParseModeCopy,
+		// The number of files is not known before parsing.
+		files:        NewProgress(ctx, ProgressParse, 0),
+		decompressed: NewProgress(ctx, ProgressDecompress, 0),
+	}
+	f, err := parse(p, buf)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		// The parsers wrap errors as text, return the cause as is.
+		f, err = nil, ctx.Err()
+	case err == nil:
+		err = (&modeSetter{mode: mode}).Run(f)
+	}
+	endTrace(err)
+	return f, err
+}
+
+func parse(p *parser, buf []byte) (Firmware, error) {
 	if _, err := FindSignature(buf); err == nil {
 		// Intel rom.
-		return NewFlashImage(buf)
+		return newFlashImage(p, buf)
 	}
 	// Non intel image such as edk2's OVMF
 	// We don't know how to parse this header, so treat it as a large BIOSRegion
-	return NewBIOSRegion(buf, nil, RegionTypeBIOS)
+	return newBIOSRegion(p, buf, nil, RegionTypeBIOS, false)
+}
+
+// ownBuf returns the n first bytes of buf as the buffer of a parsed element.
+// They are copied unless the parse shares the image or buf is owned by the
+// parser already, see parser.ownBuf.
+func ownBuf(buf []byte, n uint64, owned bool) []byte {
+	if owned {
+		return buf[:n:n]
+	}
+	b := make([]byte, n)
+	copy(b, buf)
+	return b
 }
 
 // Checksum8 does a 8 bit checksum of the slice passed in.
@@ -234,10 +481,10 @@ func Align8(val uint64) uint64 {
 	return Align(val, 8)
 }
 
-// Erase sets the buffer to be ErasePolarity
+// Erase sets the bytes of the buffer to polarity.
 func Erase(buf []byte, polarity byte) {
 	for j, blen := 0, len(buf); j < blen; j++ {
-		buf[j] = Attributes.ErasePolarity
+		buf[j] = polarity
 	}
 }
 
@@ -5,8 +5,13 @@
 package uefi
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"sync"
 	"testing"
 )
 
@@ -73,6 +78,17 @@ func TestSetErasePolarity(t *testing.T) {
 	}
 }
 
+func TestErase(t *testing.T) {
+	// The polarity given is used, whatever the one of the images parsed.
+	defer func(ep byte) { Attributes.ErasePolarity = ep }(Attributes.ErasePolarity)
+	Attributes.ErasePolarity = 0xFF
+	buf := []byte{1, 2, 3}
+	Erase(buf, 0x00)
+	if !IsErased(buf, 0x00) {
+		t.Errorf("got %x, want it erased with 0x00", buf)
+	}
+}
+
 func TestUnmarshalTypedFirmware(t *testing.T) {
 	inFirmware := MakeTyped(&Section{Name: "CHARLIE"})
 
@@ -99,6 +115,60 @@ func TestUnmarshalTypedFirmware(t *testing.T) {
 	}
 }
 
+func TestMarshalFirmwareDeterministic(t *testing.T) {
+	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []byte
+	for _, mode := range []ParseMode{ParseModeCopy, ParseModeReadOnly, ParseModeCopy} {
+		f, err := ParseWithMode(context.Background(), image, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		j, err := MarshalFirmware(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want == nil {
+			want = j
+		} else if !bytes.Equal(j, want) {
+			t.Errorf("%v: the JSON differs", mode)
+		}
+	}
+}
+
+func TestHexBytes(t *testing.T) {
+	in := &NVar{Hash: HexBytes{0xde, 0xad, 0xbe, 0xef}}
+	j, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(j, []byte(`"Hash":"deadbeef"`)) {
+		t.Errorf("got %s, want the hash in hexadecimal", j)
+	}
+	for _, j := range []string{`{"Hash":"deadbeef"}`, `{"Hash":"3q2+7w=="}`} {
+		var out NVar
+		if err := json.Unmarshal([]byte(j), &out); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out.Hash, in.Hash) {
+			t.Errorf("%s: got hash %x, want %x", j, out.Hash, in.Hash)
+		}
+	}
+	// Strings of hexadecimal digits are hexadecimal, even when they are
+	// valid base64 too.
+	var out NVar
+	if err := json.Unmarshal([]byte(`{"Hash":"beefcafe"}`), &out); err != nil || !bytes.Equal(out.Hash, []byte{0xbe, 0xef, 0xca, 0xfe}) {
+		t.Errorf("got hash %x, %v, want beefcafe", out.Hash, err)
+	}
+	for _, j := range []string{`{"Hash":"not hex!"}`, `{"Hash":"deadbee"}`, `{"Hash":"3q2+7w="}`, `{"Hash":"3q2+7w=!"}`} {
+		if err := json.Unmarshal([]byte(j), &out); err == nil {
+			t.Errorf("%s: unmarshaled an invalid hash", j)
+		}
+	}
+}
+
 var (
 	// Checksum Tests
 	emptyBuf  = []byte{}
@@ -228,3 +298,195 @@ func TestAlign8(t *testing.T) {
 		}
 	}
 }
+
+func TestParseContext(t *testing.T) {
+	if _, err := ParseContext(context.Background(), sampleFV); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ParseContext(ctx, sampleFV); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestParseContextConcurrent(t *testing.T) {
+	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The cancellation of a parse does not stop the others.
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	const parses = 8
+	var wg sync.WaitGroup
+	errs := make([]error, parses)
+	for i := 0; i < parses; i++ {
+		ctx := context.Background()
+		if i%2 == 1 {
+			ctx = canceled
+		}
+		wg.Add(1)
+		go func(i int, ctx context.Context) {
+			defer wg.Done()
+			_, errs[i] = ParseContext(ctx, image)
+		}(i, ctx)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if i%2 == 1 {
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("parse %d: got %v, want %v", i, err, context.Canceled)
+			}
+		} else if err != nil {
+			t.Errorf("parse %d: %v", i, err)
+		}
+	}
+}
+
+func TestParseCopiesOnce(t *testing.T) {
+	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := Parse(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	br := f.(*BIOSRegion)
+	if &br.buf[0] == &image[0] {
+		t.Fatalf("the BIOS region shares the buffer of the caller")
+	}
+	// The firmware volumes and their files are parts of the copy, and do
+	// not reach into what follows them.
+	var files int
+	for _, e := range br.Elements {
+		fv, ok := e.Value.(*FirmwareVolume)
+		if !ok {
+			continue
+		}
+		off := fv.FVOffset
+		if &fv.buf[0] != &br.buf[off] || cap(fv.buf) != len(fv.buf) {
+			t.Errorf("FV at %#x is not a part of the BIOS region", off)
+		}
+		for _, file := range fv.Files {
+			if len(file.buf) != cap(file.buf) {
+				t.Errorf("file %v: capacity %d past its length %d", file.Header.GUID, cap(file.buf), len(file.buf))
+			}
+			files++
+		}
+	}
+	if files == 0 {
+		t.Errorf("no files parsed")
+	}
+}
+
+func TestParseWithMode(t *testing.T) {
+	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mode := range []ParseMode{ParseModeCopy, ParseModeReadOnly, ParseModeCopyOnWrite} {
+		t.Run(mode.String(), func(t *testing.T) {
+			f, err := ParseWithMode(context.Background(), image, mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			br := f.(*BIOSRegion)
+			if shared := &br.buf[0] == &image[0]; shared != (mode != ParseModeCopy) {
+				t.Errorf("got image shared %v", shared)
+			}
+			// All the nodes record the mode, down to the decompressed ones.
+			var nodes int
+			var walk func(f Firmware)
+			walk = func(f Firmware) {
+				nodes++
+				if got := TreeMode(f); got != mode {
+					t.Fatalf("%s: got mode %v", NodeName(f), got)
+				}
+				switch f := f.(type) {
+				case *BIOSRegion:
+					for _, e := range f.Elements {
+						walk(e.Value)
+					}
+				case *FirmwareVolume:
+					for _, file := range f.Files {
+						walk(file)
+					}
+				case *File:
+					for _, s := range f.Sections {
+						walk(s)
+					}
+				case *Section:
+					for _, e := range f.Encapsulated {
+						walk(e.Value)
+					}
+				}
+			}
+			walk(f)
+			if nodes < 100 {
+				t.Errorf("walked %d nodes only", nodes)
+			}
+		})
+	}
+	if _, err := ParseWithMode(context.Background(), image, ParseMode(3)); err == nil {
+		t.Errorf("parsed with an unknown mode")
+	}
+	if got := TreeMode(&File{}); got != ParseModeCopy {
+		t.Errorf("got mode %v for a new file, want %v", got, ParseModeCopy)
+	}
+}
+
+func TestParseWithModeConcurrent(t *testing.T) {
+	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The mode of a parse does not leak into the others running alongside.
+	const parses = 8
+	var wg sync.WaitGroup
+	shared := make([]bool, parses)
+	errs := make([]error, parses)
+	for i := 0; i < parses; i++ {
+		mode := ParseModeCopy
+		if i%2 == 1 {
+			mode = ParseModeReadOnly
+		}
+		wg.Add(1)
+		go func(i int, mode ParseMode) {
+			defer wg.Done()
+			f, err := ParseWithMode(context.Background(), image, mode)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			shared[i] = &f.(*BIOSRegion).buf[0] == &image[0]
+		}(i, mode)
+	}
+	wg.Wait()
+	for i := range shared {
+		if errs[i] != nil {
+			t.Errorf("parse %d: %v", i, errs[i])
+		} else if shared[i] != (i%2 == 1) {
+			t.Errorf("parse %d: got image shared %v", i, shared[i])
+		}
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, mode := range []ParseMode{ParseModeCopy, ParseModeReadOnly, ParseModeCopyOnWrite} {
+		b.Run(mode.String(), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(image)))
+			for i := 0; i < b.N; i++ {
+				if _, err := ParseWithMode(context.Background(), image, mode); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package uefitest builds the synthetic images shared by the tests of the
+// uefi package and its users. It does not import the uefi package so that
+// the tests of the uefi package can use it.
+package uefitest
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// ChipSize is the size of each flash chip of TwoChipImage.
+const ChipSize = 512 * 1024
+
+// TwoChipImage returns an image held by two chips of ChipSize, whose flash
+// descriptor lists both chips and whose BIOS region fills the second chip
+// and starts with fv.
+func TwoChipImage(fv []byte) []byte {
+	image := bytes.Repeat([]byte{0xff}, 2*ChipSize)
+	ifd := image[:0x1000]
+	for i := range ifd {
+		ifd[i] = 0
+	}
+	// The descriptor signature, 0x0FF0A55A.
+	copy(ifd[16:], []byte{0x5a, 0xa5, 0xf0, 0x0f})
+	// FLMAP0: the components at 0x30, two chips, the regions at 0x40.
+	copy(ifd[20:], []byte{0x03, 0x01, 0x04, 0x00})
+	// FLMAP1: the masters at 0x60.
+	ifd[24] = 0x06
+	// FLCOMP at 0x30 is left zero: both chips have density 0, 512KiB.
+	// FLREG1, the BIOS region, is the second chip, in blocks of 4KiB.
+	binary.LittleEndian.PutUint16(ifd[0x44:], ChipSize/0x1000)
+	binary.LittleEndian.PutUint16(ifd[0x46:], 2*ChipSize/0x1000-1)
+	copy(image[ChipSize:], fv)
+	return image
+}
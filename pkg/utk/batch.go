@@ -0,0 +1,144 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package utk
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// BatchResult is the outcome of running the operations on one image.
+type BatchResult struct {
+	Image string
+	// Report holds the standard output of the operations and Log their
+	// standard error.
+	Report   string
+	Log      string
+	ExitCode int
+}
+
+// Batch runs the same operations on every image of a directory. Each image
+// is processed by its own utk process, so the images are independent and
+// can be processed in parallel.
+type Batch struct {
+	// Command is the utk executable followed by its flags. The image and the
+	// operations are appended to it.
+	Command []string
+	// Dir holds the images. Only the regular files directly in Dir are
+	// processed.
+	Dir string
+	// ReportDir receives IMAGE.report and IMAGE.log for each image.
+	ReportDir string
+	// Jobs is the number of images processed in parallel, at least 1.
+	Jobs int
+	// Operations are run on each image. "$IMAGE" is replaced with the base
+	// name of the image, so for example each image is saved to its own file.
+	Operations []string
+}
+
+// Run processes all the images and returns their results, sorted by image
+// name. The returned error is classified with an *Error and is not nil if
+// any image failed.
+func (b *Batch) Run() ([]BatchResult, error) {
+	if len(b.Command) == 0 {
+		return nil, newError(KindUsage, errors.New("no utk command to run"))
+	}
+	entries, err := os.ReadDir(b.Dir)
+	if err != nil {
+		return nil, newError(KindIO, err)
+	}
+	if err := os.MkdirAll(b.ReportDir, 0777); err != nil {
+		return nil, newError(KindIO, err)
+	}
+
+	var results []BatchResult
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			results = append(results, BatchResult{Image: e.Name()})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Image < results[j].Image })
+
+	jobs := b.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	var (
+		wg   sync.WaitGroup
+		next = make(chan *BatchResult)
+		errs = make([]error, len(results))
+	)
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range next {
+				b.runImage(r)
+			}
+		}()
+	}
+	for i := range results {
+		next <- &results[i]
+	}
+	close(next)
+	wg.Wait()
+
+	failed := 0
+	for i, r := range results {
+		if r.ExitCode != ExitOK {
+			failed++
+			errs[i] = fmt.Errorf("%s: exit code %d, see %s", r.Image, r.ExitCode, r.Log)
+		}
+	}
+	if failed != 0 {
+		return results, newError(KindVisitor, fmt.Errorf("%d of %d images failed: %w", failed, len(results), errors.Join(errs...)))
+	}
+	return results, nil
+}
+
+// runImage runs the operations on one image and fills in its result.
+func (b *Batch) runImage(r *BatchResult) {
+	r.Report = filepath.Join(b.ReportDir, r.Image+".report")
+	r.Log = filepath.Join(b.ReportDir, r.Image+".log")
+
+	args := append([]string{}, b.Command[1:]...)
+	args = append(args, filepath.Join(b.Dir, r.Image))
+	for _, op := range b.Operations {
+		args = append(args, strings.ReplaceAll(op, "$IMAGE", r.Image))
+	}
+
+	report, err := os.Create(r.Report)
+	if err != nil {
+		r.ExitCode = ExitIO
+		return
+	}
+	defer report.Close()
+	log, err := os.Create(r.Log)
+	if err != nil {
+		r.ExitCode = ExitIO
+		return
+	}
+	defer log.Close()
+
+	cmd := exec.Command(b.Command[0], args...)
+	cmd.Stdout, cmd.Stderr = report, log
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		r.ExitCode = ExitOK
+	case errors.As(err, &exitErr):
+		r.ExitCode = exitErr.ExitCode()
+	default:
+		fmt.Fprintln(log, err)
+		r.ExitCode = ExitFailure
+	}
+}
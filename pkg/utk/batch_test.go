@@ -0,0 +1,71 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package utk
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	tmp := t.TempDir()
+	// The fake utk prints its arguments and fails on images named bad.rom.
+	script := filepath.Join(tmp, "utk")
+	err := os.WriteFile(script, []byte(`#!/bin/sh
+echo "$@"
+case "$2" in
+*/bad.rom) echo "cannot parse" >&2; exit 3 ;;
+esac
+`), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(tmp, "images")
+	if err := os.MkdirAll(filepath.Join(dir, "subdir"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"b.rom", "bad.rom", "a.rom"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b := &Batch{
+		Command:    []string{script, "-format=json"},
+		Dir:        dir,
+		ReportDir:  filepath.Join(tmp, "reports"),
+		Jobs:       2,
+		Operations: []string{"save", "out/$IMAGE"},
+	}
+	results, err := b.Run()
+	if got := ExitCode(err); got != ExitVisitor {
+		t.Errorf("got exit code %d, want %d", got, ExitVisitor)
+	}
+
+	want := []struct {
+		image    string
+		exitCode int
+	}{{"a.rom", ExitOK}, {"b.rom", ExitOK}, {"bad.rom", ExitParse}}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	for i, w := range want {
+		r := results[i]
+		if r.Image != w.image || r.ExitCode != w.exitCode {
+			t.Errorf("result %d: got %s with exit code %d, want %s with exit code %d", i, r.Image, r.ExitCode, w.image, w.exitCode)
+		}
+		report, err := os.ReadFile(r.Report)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(report), "-format=json "+filepath.Join(dir, w.image)+" save out/"+w.image+"\n"; got != want {
+			t.Errorf("%s: got report %q, want %q", w.image, got, want)
+		}
+	}
+	if log, err := os.ReadFile(results[2].Log); err != nil || string(log) != "cannot parse\n" {
+		t.Errorf("got log %q, %v, want %q", log, err, "cannot parse\n")
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package utk
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/visitors"
+)
+
+// ReportDryRun writes to w the regions, volumes and files which the
+// operations of a dry run changed in f, with their sizes and offsets.
+// original is the image before the operations.
+func ReportDryRun(original []byte, f uefi.Firmware, w io.Writer) error {
+	// A read-only tree is unchanged, so there is nothing to assemble.
+	if uefi.TreeMode(f) != uefi.ParseModeReadOnly {
+		if err := (&visitors.Assemble{}).Run(f); err != nil {
+			return err
+		}
+	}
+	// Both images are parsed again, so that the offsets account for the pad
+	// files inserted by the assembly.
+	old, err := uefi.Parse(original)
+	if err != nil {
+		return fmt.Errorf("original image: %w", err)
+	}
+	modified, err := uefi.Parse(f.Buf())
+	if err != nil {
+		return fmt.Errorf("modified image: %w", err)
+	}
+	d := &visitors.Diff{Other: modified, W: w}
+	if err := d.Run(old); err != nil {
+		return err
+	}
+	if len(d.Entries) == 0 && visitors.OutputFormat == visitors.FormatText {
+		fmt.Fprintln(w, "no changes")
+	}
+	return nil
+}
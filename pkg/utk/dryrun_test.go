@@ -0,0 +1,50 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package utk
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/visitors"
+)
+
+func TestDryRun(t *testing.T) {
+	f, err := Load("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := append([]byte{}, f.Buf()...)
+
+	visitors.DryRun = true
+	defer func() { visitors.DryRun = false }()
+	out := filepath.Join(t.TempDir(), "out.rom")
+	v, err := visitors.ParseCLI([]string{"remove", "Shell", "save", out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := visitors.ExecuteCLI(f, v); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("dry run saved the image: %v", err)
+	}
+
+	var report bytes.Buffer
+	if err := ReportDryRun(original, f, &report); err != nil {
+		t.Fatal(err)
+	}
+	var removed, moved bool
+	for _, line := range strings.Split(report.String(), "\n") {
+		removed = removed || (strings.HasPrefix(line, "removed") && strings.Contains(line, "(Shell)"))
+		moved = moved || strings.HasPrefix(line, "moved")
+	}
+	if !removed || !moved {
+		t.Errorf("expected the Shell removal and moved files, got:\n%s", report.String())
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package utk
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+
+	"github.com/linuxboot/fiano/pkg/visitors"
+)
+
+// Exit codes of utk, so wrappers can tell failures apart.
+const (
+	ExitOK = 0
+	// ExitFailure is used for errors which are not classified.
+	ExitFailure = 1
+	// ExitUsage is used for invalid flags or operations. This is the exit
+	// code of the flag package as well.
+	ExitUsage      = 2
+	ExitParse      = 3
+	ExitValidation = 4
+	ExitVisitor    = 5
+	ExitIO         = 6
+)
+
+// Error kinds.
+const (
+	KindUsage      = "usage"
+	KindParse      = "parse"
+	KindValidation = "validation"
+	KindVisitor    = "visitor"
+	KindIO         = "io"
+)
+
+var exitCodes = map[string]int{
+	KindUsage:      ExitUsage,
+	KindParse:      ExitParse,
+	KindValidation: ExitValidation,
+	KindVisitor:    ExitVisitor,
+	KindIO:         ExitIO,
+}
+
+// Error is an error of the utk command, classified by kind.
+type Error struct {
+	Kind string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// newError classifies err as kind. I/O errors are classified as such
+// whatever the step which failed, and validation errors are recognized.
+func newError(kind string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var pe *fs.PathError
+	var ve *visitors.ValidationError
+	switch {
+	case errors.As(err, &ve):
+		kind = KindValidation
+	case errors.As(err, &pe):
+		kind = KindIO
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+// ExitCode returns the exit code for err.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		if code, ok := exitCodes[e.Kind]; ok {
+			return code
+		}
+	}
+	return ExitFailure
+}
+
+// errorJSON is the JSON form of an error.
+type errorJSON struct {
+	visitors.ErrorReport
+	Kind     string `json:",omitempty"`
+	ExitCode int
+	// Errors lists the validation errors.
+	Errors []visitors.ErrorReport `json:",omitempty"`
+}
+
+// ErrorJSON returns err as JSON, with its kind, exit code and, when known,
+// the node of the firmware tree which caused it.
+func ErrorJSON(err error) ([]byte, error) {
+	j := errorJSON{
+		ErrorReport: visitors.NewErrorReport(err),
+		ExitCode:    ExitCode(err),
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		j.Kind = e.Kind
+	}
+	var ve *visitors.ValidationError
+	if errors.As(err, &ve) {
+		for _, err := range ve.Errors {
+			j.Errors = append(j.Errors, visitors.NewErrorReport(err))
+		}
+	}
+	return json.MarshalIndent(j, "", "\t")
+}
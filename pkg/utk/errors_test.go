@@ -0,0 +1,57 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package utk
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/visitors"
+)
+
+func TestExitCode(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"ok", []string{"../../integration/roms/OVMF.rom", "validate"}, ExitOK},
+		{"no arguments", nil, ExitUsage},
+		{"unknown operation", []string{"../../integration/roms/OVMF.rom", "bogus"}, ExitUsage},
+		{"missing image", []string{"missing.rom", "table"}, ExitIO},
+		{"missing operation file", []string{"../../integration/roms/OVMF.rom", "replace_pe32", "Shell", "missing.efi"}, ExitIO},
+		{"failed operation", []string{"../../integration/roms/OVMF.rom", "remove_dxes_except", "missing.txt"}, ExitIO},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExitCode(Run(tt.args...)); got != tt.want {
+				t.Errorf("got exit code %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorJSON(t *testing.T) {
+	verr := &visitors.ValidationError{Errors: []error{
+		&visitors.NodeError{Node: "File D6A2CB7F-6A18-4E2F-B43B-9920A733700A", Err: errors.New("bad checksum")},
+	}}
+	err := newError(KindVisitor, verr)
+	if got := ExitCode(err); got != ExitValidation {
+		t.Errorf("got exit code %d, want %d", got, ExitValidation)
+	}
+
+	b, err := ErrorJSON(err)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got errorJSON
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Kind != KindValidation || got.ExitCode != ExitValidation || len(got.Errors) != 1 ||
+		got.Errors[0].Node != "File D6A2CB7F-6A18-4E2F-B43B-9920A733700A" || got.Errors[0].Message != "bad checksum" {
+		t.Errorf("unexpected JSON %s", b)
+	}
+}
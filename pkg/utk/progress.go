@@ -0,0 +1,110 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package utk
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progressBarWidth is the number of characters of a progress bar.
+const progressBarWidth = 30
+
+// ProgressBar draws the progress reported through uefi.SetProgress on a
+// terminal line, such as
+//
+//	assemble   [#############                 ]  45% 120/267
+//
+// Phases of unknown length, such as parsing and the decompression done
+// while parsing, only show a count and share a line. Any other phase
+// starts a new line.
+type ProgressBar struct {
+	W io.Writer
+	// Interval is the minimum time between two redraws.
+	Interval time.Duration
+
+	mu     sync.Mutex
+	phases []progressPhase
+	last   time.Time
+}
+
+// progressPhase is the state of a phase drawn on the current line.
+type progressPhase struct {
+	name        string
+	done, total uint64
+}
+
+// NewProgressBar returns a progress bar drawn on w, typically stderr.
+func NewProgressBar(w io.Writer) *ProgressBar {
+	return &ProgressBar{W: w, Interval: 100 * time.Millisecond}
+}
+
+// Report draws the progress of a phase. It is a uefi.ProgressFunc.
+func (b *ProgressBar) Report(phase string, done, total uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	i := 0
+	for i < len(b.phases) && b.phases[i].name != phase {
+		i++
+	}
+	if i == len(b.phases) {
+		if total != 0 || (len(b.phases) != 0 && b.phases[0].total != 0) {
+			b.endLine()
+			i = 0
+		}
+		b.phases = append(b.phases, progressPhase{name: phase})
+	}
+	b.phases[i].done, b.phases[i].total = done, total
+
+	now := time.Now()
+	if now.Sub(b.last) < b.Interval && (total == 0 || done < total) {
+		return
+	}
+	b.last = now
+	b.draw()
+}
+
+// Finish ends the current line.
+func (b *ProgressBar) Finish() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.endLine()
+}
+
+// endLine draws the final state of the current line and ends it.
+func (b *ProgressBar) endLine() {
+	if len(b.phases) == 0 {
+		return
+	}
+	b.draw()
+	fmt.Fprintln(b.W)
+	b.phases, b.last = nil, time.Time{}
+}
+
+func (b *ProgressBar) draw() {
+	var line strings.Builder
+	for i, p := range b.phases {
+		if i != 0 {
+			line.WriteString("  ")
+		}
+		if p.total == 0 {
+			fmt.Fprintf(&line, "%s %d", p.name, p.done)
+			continue
+		}
+		done := p.done
+		if done > p.total {
+			done = p.total
+		}
+		filled := int(done * progressBarWidth / p.total)
+		fmt.Fprintf(&line, "%-10s [%s%s] %3d%% %d/%d", p.name,
+			strings.Repeat("#", filled), strings.Repeat(" ", progressBarWidth-filled),
+			done*100/p.total, done, p.total)
+	}
+	fmt.Fprintf(b.W, "\r%s", line.String())
+}
@@ -0,0 +1,33 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package utk
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProgressBar(t *testing.T) {
+	var out strings.Builder
+	b := NewProgressBar(&out)
+	b.Interval = 0
+	b.Report("parse", 1, 0)
+	b.Report("decompress", 1, 0)
+	b.Report("parse", 2, 0)
+	b.Report("assemble", 1, 2)
+	b.Report("assemble", 2, 2)
+	b.Finish()
+
+	want := "\rparse 1" +
+		"\rparse 1  decompress 1" +
+		"\rparse 2  decompress 1" +
+		"\rparse 2  decompress 1\n" +
+		"\rassemble   [###############               ]  50% 1/2" +
+		"\rassemble   [##############################] 100% 2/2" +
+		"\rassemble   [##############################] 100% 2/2\n"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
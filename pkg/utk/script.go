@@ -0,0 +1,77 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package utk
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/visitors"
+)
+
+var assignmentRE = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)=(.*)$`)
+
+// RunScriptFile parses the image at path once and runs the script in
+// scriptPath against it. The IMAGE variable is set to path.
+func RunScriptFile(path, scriptPath string) error {
+	script, err := os.Open(scriptPath)
+	if err != nil {
+		return newError(KindIO, err)
+	}
+	defer script.Close()
+
+	parsedRoot, err := Load(path)
+	if err != nil {
+		return err
+	}
+	if err := RunScript(parsedRoot, script, map[string]string{"IMAGE": path}); err != nil {
+		return fmt.Errorf("%s:%w", scriptPath, err)
+	}
+	return nil
+}
+
+// RunScript runs the operations read from r against f. Each line holds one
+// or more operations, in the same form as on the utk command line. Text
+// after an unquoted "#" is a comment. A line of the form NAME=VALUE sets a
+// variable, which later lines reference as $NAME or ${NAME} outside single
+// quotes, where "$$" stands for a "$". The references are replaced as the
+// line is split into words, so a value is a single word. vars holds the
+// initial variables and is updated by the assignments. The script stops at
+// the first error, which is classified with an *Error.
+func RunScript(f uefi.Firmware, r io.Reader, vars map[string]string) error {
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		words, err := splitWords(s.Text(), func(name string) (string, error) {
+			v, ok := vars[name]
+			if !ok {
+				return "", fmt.Errorf("undefined variable %q", name)
+			}
+			return v, nil
+		})
+		if err != nil {
+			return newError(KindUsage, fmt.Errorf("%d: %w", line, err))
+		}
+		if len(words) == 0 {
+			continue
+		}
+		if m := assignmentRE.FindStringSubmatch(words[0]); m != nil && len(words) == 1 {
+			vars[m[1]] = m[2]
+			continue
+		}
+		v, err := visitors.ParseCLI(words)
+		if err != nil {
+			return newError(KindUsage, fmt.Errorf("%d: %w", line, err))
+		}
+		if f, err = visitors.ExecuteCLIRoot(context.Background(), f, v); err != nil {
+			return newError(KindVisitor, fmt.Errorf("%d: %w", line, err))
+		}
+	}
+	return newError(KindIO, s.Err())
+}
@@ -0,0 +1,164 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package utk
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/visitors"
+)
+
+const shellPrompt = "utk> "
+
+// Interactive parses the image at path once and runs the commands read from
+// in against the in-memory tree.
+func Interactive(path string, in io.Reader, out io.Writer) error {
+	parsedRoot, err := Load(path)
+	if err != nil {
+		return err
+	}
+	return Shell(parsedRoot, in, out)
+}
+
+// Shell reads one line of commands at a time from in and runs them against
+// f, until "exit", "quit" or the end of the input. Errors are reported to
+// out and do not end the shell. Modifications to the tree are kept between
+// lines, so "save" writes all the modifications made so far.
+func Shell(f uefi.Firmware, in io.Reader, out io.Writer) error {
+	s := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, shellPrompt)
+		if !s.Scan() {
+			fmt.Fprintln(out)
+			return s.Err()
+		}
+		args, err := SplitWords(s.Text())
+		if err != nil {
+			fmt.Fprintf(out, "error: %v\n", err)
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+		switch args[0] {
+		case "exit", "quit":
+			return nil
+		case "help":
+			fmt.Fprintf(out, "Commands:\n%s  %-22s: %s\n  %-22s: %s\n",
+				visitors.ListCLI(), "help", "print this help", "exit", "leave the shell")
+			continue
+		}
+		v, err := visitors.ParseCLI(args)
+		if err == nil {
+			f, err = visitors.ExecuteCLIRoot(context.Background(), f, v)
+		}
+		if err != nil {
+			fmt.Fprintf(out, "error: %v\n", err)
+		}
+	}
+}
+
+// SplitWords splits a line into words separated by white space. Single and
+// double quotes group words containing white space. Text after an unquoted
+// "#" is a comment and is ignored.
+func SplitWords(line string) ([]string, error) {
+	return splitWords(line, nil)
+}
+
+// splitWords is SplitWords, replacing the variable references $NAME and
+// ${NAME} outside single quotes with their value returned by expand, when
+// it is not nil. The values are not split into words, and "$$" stands for a
+// "$".
+func splitWords(line string, expand func(name string) (string, error)) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	var inWord bool
+	var quote rune
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '$' && expand != nil && quote != '\'':
+			name, n := variableName(runes[i+1:])
+			if name == "$" {
+				word.WriteRune('$')
+			} else if name != "" {
+				v, err := expand(name)
+				if err != nil {
+					return nil, err
+				}
+				word.WriteString(v)
+			} else {
+				word.WriteRune(r)
+			}
+			i += n
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == '#' && !inWord:
+			return words, nil
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// variableName returns the name of the variable referenced by the runes
+// following a "$", "$" for "$$", and the number of runes of the reference.
+// The name is empty when the "$" is not a reference.
+func variableName(runes []rune) (string, int) {
+	isNameRune := func(r rune, first bool) bool {
+		return r == '_' || r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || !first && r >= '0' && r <= '9'
+	}
+	if len(runes) == 0 {
+		return "", 0
+	}
+	if runes[0] == '$' {
+		return "$", 1
+	}
+	if runes[0] == '{' {
+		for n, r := range runes[1:] {
+			if r == '}' {
+				if n == 0 {
+					return "", 0
+				}
+				return string(runes[1 : n+1]), n + 2
+			}
+		}
+		return "", 0
+	}
+	n := 0
+	for n < len(runes) && isNameRune(runes[n], n == 0) {
+		n++
+	}
+	return string(runes[:n]), n
+}
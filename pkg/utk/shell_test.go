@@ -0,0 +1,152 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package utk
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/visitors"
+)
+
+func TestSplitWords(t *testing.T) {
+	for _, tt := range []struct {
+		line string
+		want []string
+	}{
+		{"", nil},
+		{"  find   Shell ", []string{"find", "Shell"}},
+		{`replace_pe32 "My Driver" 'a b.efi'`, []string{"replace_pe32", "My Driver", "a b.efi"}},
+		{"count # comment", []string{"count"}},
+		{`find a#b`, []string{"find", "a#b"}},
+		{`find ""`, []string{"find", ""}},
+	} {
+		got, err := SplitWords(tt.line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SplitWords(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+	if _, err := SplitWords(`find "Shell`); err == nil {
+		t.Errorf("expected error for unterminated quote")
+	}
+}
+
+func TestSplitWordsExpand(t *testing.T) {
+	vars := map[string]string{"NAME": "My Driver", "N": "1"}
+	expand := func(name string) (string, error) {
+		v, ok := vars[name]
+		if !ok {
+			return "", fmt.Errorf("undefined variable %q", name)
+		}
+		return v, nil
+	}
+	for _, tt := range []struct {
+		line string
+		want []string
+	}{
+		// The values are not split into words.
+		{"find $NAME", []string{"find", "My Driver"}},
+		{`find "${NAME}s" x${N}y`, []string{"find", "My Drivers", "x1y"}},
+		// Single quotes and "$$" keep the "$".
+		{`find 'Foo$' '$NAME'`, []string{"find", "Foo$", "$NAME"}},
+		{"find Foo$$ $$NAME", []string{"find", "Foo$", "$NAME"}},
+		{"find Foo$ ${ $1", []string{"find", "Foo$", "${", "$1"}},
+	} {
+		got, err := splitWords(tt.line, expand)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.line, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitWords(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+	if _, err := splitWords("find ${MISSING}", expand); err == nil {
+		t.Errorf("expected error for an undefined variable")
+	}
+}
+
+func TestShell(t *testing.T) {
+	f, err := Load("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "out.rom")
+	in := strings.NewReader(strings.Join([]string{
+		"bogus",
+		"# comment",
+		"",
+		"remove Shell",
+		"save " + out,
+		"exit",
+		"save never",
+	}, "\n"))
+	var b bytes.Buffer
+	if err := Shell(f, in, &b); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "error: could not find command 'bogus'") {
+		t.Errorf("expected error for unknown command, got %q", b.String())
+	}
+
+	saved, err := Load(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pred, err := visitors.FindFilePredicate("Shell")
+	if err != nil {
+		t.Fatal(err)
+	}
+	find := &visitors.Find{Predicate: pred}
+	if err := find.Run(saved); err != nil {
+		t.Fatal(err)
+	}
+	if len(find.Matches) != 0 {
+		t.Errorf("Shell was not removed from the saved image")
+	}
+}
+
+func TestRunScript(t *testing.T) {
+	f, err := Load("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	script := `# Remove the shell and save.
+DIR=` + dir + `
+NAME=Shell
+remove ${NAME}   # trailing comment
+save $DIR/out.rom
+`
+	if err := RunScript(f, strings.NewReader(script), map[string]string{}); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := Load(filepath.Join(dir, "out.rom"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pred, err := visitors.FindFilePredicate("Shell")
+	if err != nil {
+		t.Fatal(err)
+	}
+	find := &visitors.Find{Predicate: pred}
+	if err := find.Run(saved); err != nil {
+		t.Fatal(err)
+	}
+	if len(find.Matches) != 0 {
+		t.Errorf("Shell was not removed from the saved image")
+	}
+
+	err = RunScript(f, strings.NewReader("count\nfind $MISSING\n"), map[string]string{})
+	if err == nil || !strings.HasPrefix(err.Error(), "2: ") {
+		t.Errorf("expected error on line 2, got %v", err)
+	}
+}
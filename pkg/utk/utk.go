@@ -6,54 +6,188 @@
 package utk
 
 import (
+	"context"
 	"errors"
+	"io"
 	"os"
+	"strings"
 
+	bytes2 "github.com/linuxboot/fiano/pkg/bytes"
+	"github.com/linuxboot/fiano/pkg/capsule"
+	"github.com/linuxboot/fiano/pkg/flashrom"
+	"github.com/linuxboot/fiano/pkg/insyde"
+	"github.com/linuxboot/fiano/pkg/remote"
 	"github.com/linuxboot/fiano/pkg/uefi"
 	"github.com/linuxboot/fiano/pkg/visitors"
 )
 
-// Run runs the utk command with the given arguments.
+// Run runs the utk command with the given arguments. The returned errors
+// are classified with an *Error.
 func Run(args ...string) error {
 	if len(args) == 0 {
-		return errors.New("at least one argument is required")
+		return newError(KindUsage, errors.New("at least one argument is required"))
+	}
+
+	// "utk diff OLD NEW" is a shorthand for "utk OLD diff NEW".
+	if args[0] == "diff" && len(args) == 3 {
+		args = []string{args[1], "diff", args[2]}
 	}
 
 	v, err := visitors.ParseCLI(args[1:])
 	if err != nil {
-		return err
+		return newError(KindUsage, err)
 	}
 
 	// Load and parse the image.
-	path := args[0]
-	f, err := os.Stat(path)
+	parsedRoot, err := Load(args[0])
 	if err != nil {
 		return err
 	}
+
+	// Keep the original image to report the changes of a dry run. The
+	// image of a parsed file is never written to, unless it was copied.
+	var original []byte
+	if visitors.DryRun {
+		original = parsedRoot.Buf()
+		if uefi.TreeMode(parsedRoot) == uefi.ParseModeCopy {
+			original = append([]byte{}, original...)
+		}
+	}
+
+	// Execute the instructions from the command line.
+	if parsedRoot, err = visitors.ExecuteCLIRoot(context.Background(), parsedRoot, v); err != nil {
+		return newError(KindVisitor, err)
+	}
+	if visitors.DryRun {
+		return newError(KindVisitor, ReportDryRun(original, parsedRoot, os.Stdout))
+	}
+	return nil
+}
+
+// Load parses the image at path. The path is either an image file, a
+// directory created by the extract command, "flashrom:PROGRAMMER" to read
+// the flash chip with flashrom, an http(s):// or ssh:// URL to download the
+// image, see remote.Fetch, the files of the flash chips holding the image
+// separated with ChipSeparator, or "-" to read the image from stdin. The
+// flash image carried by an Insyde update file, see insyde.FlashImage, or
+// by an FMP capsule, see capsule.Payload, is parsed rather than the file.
+// The returned errors are classified with an *Error.
+func Load(path string) (uefi.Firmware, error) {
+	if path == visitors.StdioPath {
+		image, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, newError(KindIO, err)
+		}
+		f, err := parse(image)
+		return f, newError(KindParse, err)
+	}
+	if programmer, ok := flashrom.Programmer(path); ok {
+		image, err := flashrom.Read(programmer)
+		if err != nil {
+			return nil, newError(KindIO, err)
+		}
+		f, err := parse(image)
+		return f, newError(KindParse, err)
+	}
+	if remote.IsURL(path) {
+		image, err := remote.Fetch(path)
+		if err != nil {
+			return nil, newError(KindIO, err)
+		}
+		f, err := parse(image)
+		return f, newError(KindParse, err)
+	}
+	if chips := strings.Split(path, ChipSeparator); len(chips) > 1 {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return loadChips(chips)
+		}
+	}
+	f, err := os.Stat(path)
+	if err != nil {
+		return nil, newError(KindIO, err)
+	}
 	var parsedRoot uefi.Firmware
 	if m := f.Mode(); m.IsDir() {
 		// Call ParseDir
 		pd := visitors.ParseDir{BasePath: path}
 		if parsedRoot, err = pd.Parse(); err != nil {
-			return err
+			return nil, newError(KindParse, err)
 		}
 		// Assemble the tree from the bottom up
 		a := visitors.Assemble{}
 		if err = a.Run(parsedRoot); err != nil {
-			return err
+			return nil, newError(KindVisitor, err)
 		}
 	} else {
-		// Regular file
-		image, err := os.ReadFile(path)
+		// Regular file. A read-only tree can be parsed in place from the
+		// mapped file, which stays mapped as long as the tree lives.
+		var image []byte
+		if uefi.ReadOnly {
+			var m *bytes2.MmapReader
+			if m, err = bytes2.Mmap(path); err == nil {
+				image = m.Bytes()
+			}
+		} else {
+			image, err = os.ReadFile(path)
+		}
 		if err != nil {
-			return err
+			return nil, newError(KindIO, err)
 		}
-		parsedRoot, err = uefi.Parse(image)
+		parsedRoot, err = parse(image)
 		if err != nil {
-			return err
+			return nil, newError(KindParse, err)
 		}
 	}
+	return parsedRoot, nil
+}
 
-	// Execute the instructions from the command line.
-	return visitors.ExecuteCLI(parsedRoot, v)
+// ChipSeparator separates the files of the flash chips holding an image, as
+// in "chip0.rom+chip1.rom".
+const ChipSeparator = "+"
+
+// loadChips parses the image held by flash chips whose contents are in the
+// files at paths.
+func loadChips(paths []string) (uefi.Firmware, error) {
+	var contents [][]byte
+	for _, p := range paths {
+		c, err := os.ReadFile(p)
+		if err != nil {
+			return nil, newError(KindIO, err)
+		}
+		contents = append(contents, c)
+	}
+	image, err := uefi.JoinChips(contents...)
+	if err != nil {
+		return nil, newError(KindParse, err)
+	}
+	f, err := parse(image)
+	return f, newError(KindParse, err)
+}
+
+// parse parses an image read by Load, which is not used elsewhere. It is
+// shared with the tree rather than copied: read-only when uefi.ReadOnly is
+// set, copy-on-write otherwise.
+func parse(image []byte) (uefi.Firmware, error) {
+	mode := uefi.ParseModeCopyOnWrite
+	if uefi.ReadOnly {
+		mode = uefi.ParseModeReadOnly
+	}
+	if _, err := uefi.FindSignature(image); err != nil {
+		payload, err := capsule.Payload(image)
+		switch {
+		case err == nil:
+			image = payload
+		case !errors.Is(err, capsule.ErrNotCapsule):
+			return nil, err
+		default:
+			flash, err := insyde.FlashImage(image)
+			switch {
+			case err == nil:
+				image = flash
+			case !errors.Is(err, insyde.ErrNotUpdate):
+				return nil, err
+			}
+		}
+	}
+	return uefi.ParseWithMode(context.Background(), image, mode)
 }
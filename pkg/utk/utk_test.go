@@ -0,0 +1,170 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package utk
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/capsule"
+	"github.com/linuxboot/fiano/pkg/insyde"
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/visitors"
+)
+
+func TestRunStdio(t *testing.T) {
+	// Saving reassembles the image, compare with an image saved to a file.
+	dir := t.TempDir()
+	wantPath := filepath.Join(dir, "want.rom")
+	if err := Run("../../integration/roms/OVMF.rom", "remove", "Shell", "save", wantPath); err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile(wantPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in, err := os.Open("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	outPath := filepath.Join(dir, "out.rom")
+	out, err := os.Create(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	stdin, stdout := os.Stdin, os.Stdout
+	os.Stdin, os.Stdout = in, out
+	err = Run("-", "remove", "Shell", "save", "-")
+	os.Stdin, os.Stdout = stdin, stdout
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("image saved to stdout differs from the image saved to a file")
+	}
+}
+
+func TestLoadReadOnly(t *testing.T) {
+	count := func() *visitors.Count {
+		t.Helper()
+		f, err := Load("../../integration/roms/OVMF.rom")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := &visitors.Count{}
+		if err := c.Run(f); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	want := count()
+	uefi.ReadOnly = true
+	defer func() { uefi.ReadOnly = false }()
+	if got := count(); !reflect.DeepEqual(got, want) {
+		t.Errorf("mapped image: got %+v, want %+v", got, want)
+	}
+}
+
+func TestLoadURL(t *testing.T) {
+	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(image)
+	}))
+	defer srv.Close()
+
+	sum := sha256.Sum256(image)
+	if _, err := Load(fmt.Sprintf("%s/OVMF.rom#sha256=%x", srv.URL, sum)); err != nil {
+		t.Fatal(err)
+	}
+	sum[0]++
+	var e *Error
+	if _, err := Load(fmt.Sprintf("%s/OVMF.rom#sha256=%x", srv.URL, sum)); !errors.As(err, &e) || e.Kind != KindIO {
+		t.Errorf("got %v, want an I/O error", err)
+	}
+}
+
+func TestLoadInsydeUpdate(t *testing.T) {
+	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := insyde.IFlashHeader{TotalSize: uint32(len(image)), ImageSize: uint32(len(image))}
+	copy(h.Signature[:], insyde.IFlashSignature)
+	copy(h.Tag[:], "_"+insyde.TagBIOS)
+	var update bytes.Buffer
+	update.WriteString("MZ\x90\x00")
+	binary.Write(&update, binary.LittleEndian, h)
+	update.Write(image)
+	path := filepath.Join(t.TempDir(), "update.exe")
+	if err := os.WriteFile(path, update.Bytes(), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(f.Buf(), image) {
+		t.Errorf("got an image of %#x bytes, want the BIOS image of the update of %#x bytes", len(f.Buf()), len(image))
+	}
+}
+
+func TestLoadCapsule(t *testing.T) {
+	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &capsule.Capsule{
+		Header:    capsule.Header{GUID: capsule.FMPCapsuleGUID},
+		FMPHeader: capsule.FMPHeader{Version: 1},
+		Items:     []*capsule.Item{{Header: capsule.ImageHeader{Version: 3}, Payload: image}},
+	}
+	b, err := c.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "update.cap")
+	if err := os.WriteFile(path, b, 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(f.Buf(), image) {
+		t.Errorf("got an image of %#x bytes, want the payload of the capsule of %#x bytes", len(f.Buf()), len(image))
+	}
+
+	// A truncated capsule is reported, not parsed as an image.
+	var e *Error
+	if err := os.WriteFile(path, b[:len(b)/2], 0o666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); !errors.As(err, &e) || e.Kind != KindParse {
+		t.Errorf("got %v, want a parse error", err)
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vboot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// GBBSignature starts the GBB.
+var GBBSignature = [4]byte{'$', 'G', 'B', 'B'}
+
+// GBBMajorVersion is the only major version of the GBB.
+const GBBMajorVersion = 1
+
+// GBBFlags control the developer features of vboot.
+type GBBFlags uint32
+
+var gbbFlagNames = []string{
+	"DEV_SCREEN_SHORT_DELAY",
+	"LOAD_OPTION_ROMS",
+	"ENABLE_ALTERNATE_OS",
+	"FORCE_DEV_SWITCH_ON",
+	"FORCE_DEV_BOOT_USB",
+	"DISABLE_FW_ROLLBACK_CHECK",
+	"ENTER_TRIGGERS_TONORM",
+	"FORCE_DEV_BOOT_ALTFW",
+	"RUNNING_FAFT",
+	"DISABLE_EC_SOFTWARE_SYNC",
+	"DEFAULT_DEV_BOOT_ALTFW",
+	"DISABLE_PD_SOFTWARE_SYNC",
+	"DISABLE_LID_SHUTDOWN",
+	"FORCE_DEV_BOOT_FASTBOOT_FULL_CAP",
+	"FORCE_MANUAL_RECOVERY",
+	"DISABLE_FWMP",
+	"ENABLE_UDC",
+}
+
+func (f GBBFlags) String() string {
+	return flagNames(uint32(f), gbbFlagNames)
+}
+
+// ParseGBBFlags is the reverse of GBBFlags.String: it returns the flags
+// named in s, separated by '|', each a name or a number.
+func ParseGBBFlags(s string) (GBBFlags, error) {
+	var flags GBBFlags
+	for _, name := range strings.Split(s, "|") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		found := false
+		for i, n := range gbbFlagNames {
+			if name == n {
+				flags |= 1 << i
+				found = true
+			}
+		}
+		if found {
+			continue
+		}
+		n, err := strconv.ParseUint(name, 0, 32)
+		if err != nil {
+			return 0, fmt.Errorf("unknown GBB flag %q", name)
+		}
+		flags |= GBBFlags(n)
+	}
+	return flags, nil
+}
+
+// GBBHeader is struct vb2_gbb_header. The offsets count from the start of
+// the header.
+type GBBHeader struct {
+	Signature         [4]byte
+	MajorVersion      uint16
+	MinorVersion      uint16
+	HeaderSize        uint32
+	Flags             GBBFlags
+	HWIDOffset        uint32
+	HWIDSize          uint32
+	RootKeyOffset     uint32
+	RootKeySize       uint32
+	BmpFVOffset       uint32
+	BmpFVSize         uint32
+	RecoveryKeyOffset uint32
+	RecoveryKeySize   uint32
+	HWIDDigest        [32]byte
+	_                 [48]byte
+}
+
+// Offsets of the fields of GBBHeader which may be set.
+const (
+	gbbFlagsOffset      = 12
+	gbbHWIDDigestOffset = 48
+)
+
+// GBBMinorVersionHWIDDigest is the first minor version with the HWID digest.
+const GBBMinorVersionHWIDDigest = 2
+
+// GBB is the Google Binary Block, holding the hardware ID and the keys
+// which verify the rest of the firmware.
+type GBB struct {
+	GBBHeader
+	HWID        string
+	RootKey     *PackedKey
+	RecoveryKey *PackedKey
+}
+
+// ParseGBB parses the GBB starting at the start of b, typically the GBB area
+// of the FMAP.
+func ParseGBB(b []byte) (*GBB, error) {
+	g := &GBB{}
+	if err := read(b, 0, &g.GBBHeader); err != nil {
+		return nil, fmt.Errorf("GBB header: %w", err)
+	}
+	if g.Signature != GBBSignature {
+		return nil, fmt.Errorf("GBB signature is %q, want %q", g.Signature[:], GBBSignature[:])
+	}
+	if g.MajorVersion != GBBMajorVersion {
+		return nil, fmt.Errorf("GBB version is %d.%d, want %d.x", g.MajorVersion, g.MinorVersion, GBBMajorVersion)
+	}
+	hwid, err := slice(b, g.HWIDOffset, g.HWIDSize)
+	if err != nil {
+		return nil, fmt.Errorf("GBB HWID: %w", err)
+	}
+	if n := bytes.IndexByte(hwid, 0); n >= 0 {
+		hwid = hwid[:n]
+	}
+	g.HWID = string(hwid)
+
+	if g.RootKey, err = parseGBBKey(b, g.RootKeyOffset, g.RootKeySize); err != nil {
+		return nil, fmt.Errorf("GBB root key: %w", err)
+	}
+	if g.RecoveryKey, err = parseGBBKey(b, g.RecoveryKeyOffset, g.RecoveryKeySize); err != nil {
+		return nil, fmt.Errorf("GBB recovery key: %w", err)
+	}
+	return g, nil
+}
+
+// parseGBBKey parses the key of the size bytes at off of b.
+func parseGBBKey(b []byte, off, size uint32) (*PackedKey, error) {
+	area, err := slice(b, off, size)
+	if err != nil {
+		return nil, err
+	}
+	return parsePackedKey(area, 0)
+}
+
+// SetFlags sets the flags of the GBB parsed from b, in b and in g.
+func (g *GBB) SetFlags(b []byte, flags GBBFlags) error {
+	if uint64(len(b)) < gbbFlagsOffset+4 {
+		return fmt.Errorf("GBB of %#x bytes has no flags", len(b))
+	}
+	binary.LittleEndian.PutUint32(b[gbbFlagsOffset:], uint32(flags))
+	g.Flags = flags
+	return nil
+}
+
+// SetHWID sets the HWID of the GBB parsed from b, in b and in g. It must fit
+// in the HWID area with its terminating NUL, the rest of the area is zeroed.
+// The HWID digest, from version 1.2 on, is updated.
+func (g *GBB) SetHWID(b []byte, hwid string) error {
+	area, err := slice(b, g.HWIDOffset, g.HWIDSize)
+	if err != nil {
+		return fmt.Errorf("GBB HWID: %w", err)
+	}
+	if strings.IndexByte(hwid, 0) >= 0 {
+		return fmt.Errorf("GBB HWID %q holds a NUL", hwid)
+	}
+	if len(hwid)+1 > len(area) {
+		return fmt.Errorf("GBB HWID %q does not fit in %d bytes", hwid, len(area))
+	}
+	copy(area, hwid)
+	for i := len(hwid); i < len(area); i++ {
+		area[i] = 0
+	}
+	g.HWID = hwid
+	if g.MinorVersion >= GBBMinorVersionHWIDDigest {
+		if len(b) < gbbHWIDDigestOffset+sha256.Size {
+			return fmt.Errorf("GBB of %#x bytes has no HWID digest", len(b))
+		}
+		g.HWIDDigest = sha256.Sum256([]byte(hwid))
+		copy(b[gbbHWIDDigestOffset:], g.HWIDDigest[:])
+	}
+	return nil
+}
+
+// SetRootKey replaces the root key of the GBB parsed from b, in b and in g,
+// with the packed key, as in a .vbpubk file. The rest of the area is zeroed.
+func (g *GBB) SetRootKey(b []byte, key []byte) error {
+	k, err := setGBBKey(b, g.RootKeyOffset, g.RootKeySize, key)
+	if err != nil {
+		return fmt.Errorf("GBB root key: %w", err)
+	}
+	g.RootKey = k
+	return nil
+}
+
+// SetRecoveryKey replaces the recovery key of the GBB parsed from b, in b and
+// in g, with the packed key, as in a .vbpubk file. The rest of the area is
+// zeroed.
+func (g *GBB) SetRecoveryKey(b []byte, key []byte) error {
+	k, err := setGBBKey(b, g.RecoveryKeyOffset, g.RecoveryKeySize, key)
+	if err != nil {
+		return fmt.Errorf("GBB recovery key: %w", err)
+	}
+	g.RecoveryKey = k
+	return nil
+}
+
+// setGBBKey writes the packed key to the area of the size bytes at off of b
+// and returns it parsed from b.
+func setGBBKey(b []byte, off, size uint32, key []byte) (*PackedKey, error) {
+	area, err := slice(b, off, size)
+	if err != nil {
+		return nil, err
+	}
+	k, err := parsePackedKey(key, 0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := k.PublicKey(); err != nil {
+		return nil, err
+	}
+	if len(key) > len(area) {
+		return nil, fmt.Errorf("key of %#x bytes does not fit in %#x bytes", len(key), len(area))
+	}
+	copy(area, key)
+	for i := len(key); i < len(area); i++ {
+		area[i] = 0
+	}
+	return parsePackedKey(area, 0)
+}
@@ -0,0 +1,94 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vboot
+
+import "fmt"
+
+// KeyblockMagic starts a keyblock.
+var KeyblockMagic = [8]byte{'C', 'H', 'R', 'O', 'M', 'E', 'O', 'S'}
+
+// KeyblockMajorVersion is the only major version of the keyblocks.
+const KeyblockMajorVersion = 2
+
+// KeyblockFlags tell in which boot modes a keyblock is valid.
+type KeyblockFlags uint32
+
+var keyblockFlagNames = []string{
+	"DEVELOPER_0",
+	"DEVELOPER_1",
+	"RECOVERY_0",
+	"RECOVERY_1",
+	"MINIOS_0",
+	"MINIOS_1",
+}
+
+func (f KeyblockFlags) String() string {
+	return flagNames(uint32(f), keyblockFlagNames)
+}
+
+// Offsets of the structures in a keyblock, which their own offsets count
+// from.
+const (
+	keyblockSignatureOffset = 24
+	keyblockHashOffset      = 48
+	keyblockDataKeyOffset   = 80
+)
+
+// KeyblockHeader is struct vb2_keyblock.
+type KeyblockHeader struct {
+	Magic              [8]byte
+	HeaderVersionMajor uint32
+	HeaderVersionMinor uint32
+	KeyblockSize       uint32
+	_                  uint32
+	KeyblockSignature  SignatureHeader
+	KeyblockHash       SignatureHeader
+	KeyblockFlags      KeyblockFlags
+	_                  uint32
+	DataKey            PackedKeyHeader
+}
+
+// Keyblock holds the data key, which signs a firmware preamble, signed by
+// the root key of the GBB.
+type Keyblock struct {
+	KeyblockHeader
+	// Signature signs the first DataSize bytes of Raw with the root key.
+	Signature *Signature
+	// Hash is the SHA512 digest of the first DataSize bytes of Raw.
+	Hash    *Signature
+	DataKey *PackedKey
+	// Raw is the whole keyblock.
+	Raw []byte
+}
+
+// ParseKeyblock parses the keyblock starting at the start of b.
+func ParseKeyblock(b []byte) (*Keyblock, error) {
+	k := &Keyblock{}
+	if err := read(b, 0, &k.KeyblockHeader); err != nil {
+		return nil, fmt.Errorf("keyblock header: %w", err)
+	}
+	if k.Magic != KeyblockMagic {
+		return nil, fmt.Errorf("keyblock magic is %q, want %q", k.Magic[:], KeyblockMagic[:])
+	}
+	if k.HeaderVersionMajor != KeyblockMajorVersion {
+		return nil, fmt.Errorf("keyblock version is %d.%d, want %d.x", k.HeaderVersionMajor, k.HeaderVersionMinor, KeyblockMajorVersion)
+	}
+	raw, err := slice(b, 0, k.KeyblockSize)
+	if err != nil {
+		return nil, fmt.Errorf("keyblock: %w", err)
+	}
+	k.Raw = raw
+
+	if k.Signature, err = parseSignature(raw, keyblockSignatureOffset); err != nil {
+		return nil, fmt.Errorf("keyblock signature: %w", err)
+	}
+	if k.Hash, err = parseSignature(raw, keyblockHashOffset); err != nil {
+		return nil, fmt.Errorf("keyblock hash: %w", err)
+	}
+	if k.DataKey, err = parsePackedKey(raw, keyblockDataKeyOffset); err != nil {
+		return nil, fmt.Errorf("keyblock data key: %w", err)
+	}
+	return k, nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vboot
+
+import "fmt"
+
+// FirmwarePreambleMajorVersion is the only major version of the firmware
+// preambles.
+const FirmwarePreambleMajorVersion = 2
+
+// PreambleFlags are the flags of a firmware preamble.
+type PreambleFlags uint32
+
+var preambleFlagNames = []string{
+	"USE_RO_NORMAL",
+}
+
+func (f PreambleFlags) String() string {
+	return flagNames(uint32(f), preambleFlagNames)
+}
+
+// Offsets of the structures in a firmware preamble, which their own offsets
+// count from.
+const (
+	preambleSignatureOffset     = 8
+	preambleKernelSubkeyOffset  = 48
+	preambleBodySignatureOffset = 80
+)
+
+// FirmwarePreambleHeader is struct vb2_fw_preamble.
+type FirmwarePreambleHeader struct {
+	PreambleSize       uint32
+	_                  uint32
+	PreambleSignature  SignatureHeader
+	HeaderVersionMajor uint32
+	HeaderVersionMinor uint32
+	FirmwareVersion    uint32
+	_                  uint32
+	KernelSubkey       PackedKeyHeader
+	BodySignature      SignatureHeader
+	Flags              PreambleFlags
+}
+
+// FirmwarePreamble follows the keyblock in a VBLOCK area. It is signed by
+// the data key of the keyblock and signs the firmware body, the FW_MAIN area
+// of the slot.
+type FirmwarePreamble struct {
+	FirmwarePreambleHeader
+	// Signature signs the first DataSize bytes of Raw with the data key.
+	Signature    *Signature
+	KernelSubkey *PackedKey
+	// BodySignature signs the first DataSize bytes of the firmware body
+	// with the data key.
+	BodySignature *Signature
+	// Raw is the whole preamble.
+	Raw []byte
+}
+
+// ParseFirmwarePreamble parses the firmware preamble starting at the start
+// of b.
+func ParseFirmwarePreamble(b []byte) (*FirmwarePreamble, error) {
+	p := &FirmwarePreamble{}
+	if err := read(b, 0, &p.FirmwarePreambleHeader); err != nil {
+		return nil, fmt.Errorf("firmware preamble header: %w", err)
+	}
+	if p.HeaderVersionMajor != FirmwarePreambleMajorVersion {
+		return nil, fmt.Errorf("firmware preamble version is %d.%d, want %d.x", p.HeaderVersionMajor, p.HeaderVersionMinor, FirmwarePreambleMajorVersion)
+	}
+	raw, err := slice(b, 0, p.PreambleSize)
+	if err != nil {
+		return nil, fmt.Errorf("firmware preamble: %w", err)
+	}
+	p.Raw = raw
+
+	if p.Signature, err = parseSignature(raw, preambleSignatureOffset); err != nil {
+		return nil, fmt.Errorf("firmware preamble signature: %w", err)
+	}
+	if p.KernelSubkey, err = parsePackedKey(raw, preambleKernelSubkeyOffset); err != nil {
+		return nil, fmt.Errorf("firmware preamble kernel subkey: %w", err)
+	}
+	if p.BodySignature, err = parseSignature(raw, preambleBodySignatureOffset); err != nil {
+		return nil, fmt.Errorf("firmware preamble body signature: %w", err)
+	}
+	return p, nil
+}
+
+// VBlock is the content of a VBLOCK area: a keyblock followed by a firmware
+// preamble.
+type VBlock struct {
+	Keyblock *Keyblock
+	Preamble *FirmwarePreamble
+}
+
+// ParseVBlock parses the VBLOCK area b.
+func ParseVBlock(b []byte) (*VBlock, error) {
+	k, err := ParseKeyblock(b)
+	if err != nil {
+		return nil, err
+	}
+	p, err := ParseFirmwarePreamble(b[len(k.Raw):])
+	if err != nil {
+		return nil, err
+	}
+	return &VBlock{Keyblock: k, Preamble: p}, nil
+}
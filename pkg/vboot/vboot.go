@@ -0,0 +1,222 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package vboot implements parsing of the verified boot structures of
+// ChromeOS-style firmware images: the Google Binary Block (GBB) and the
+// keyblocks and firmware preambles of the VBLOCK areas.
+//
+// The layouts follow vboot_reference, firmware/2lib/include/2struct.h.
+// All fields are little endian.
+package vboot
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Algorithm is the signature algorithm of a key: an RSA key size and a hash.
+type Algorithm uint32
+
+// Algorithms from vboot_reference, firmware/2lib/include/2crypto.h.
+const (
+	RSA1024SHA1 Algorithm = iota
+	RSA1024SHA256
+	RSA1024SHA512
+	RSA2048SHA1
+	RSA2048SHA256
+	RSA2048SHA512
+	RSA4096SHA1
+	RSA4096SHA256
+	RSA4096SHA512
+	RSA8192SHA1
+	RSA8192SHA256
+	RSA8192SHA512
+	RSA2048Exp3SHA1
+	RSA2048Exp3SHA256
+	RSA2048Exp3SHA512
+	RSA3072Exp3SHA1
+	RSA3072Exp3SHA256
+	RSA3072Exp3SHA512
+)
+
+var algorithmKeyBits = []int{1024, 2048, 4096, 8192}
+
+// KeyBits returns the size of the RSA modulus, or 0 for unknown algorithms.
+func (a Algorithm) KeyBits() int {
+	switch {
+	case a <= RSA8192SHA512:
+		return algorithmKeyBits[a/3]
+	case a <= RSA2048Exp3SHA512:
+		return 2048
+	case a <= RSA3072Exp3SHA512:
+		return 3072
+	}
+	return 0
+}
+
+// Exponent returns the public exponent of the RSA keys.
+func (a Algorithm) Exponent() int {
+	if a >= RSA2048Exp3SHA1 {
+		return 3
+	}
+	return 65537
+}
+
+// Hash returns the hash function of the signatures, or 0 for unknown
+// algorithms.
+func (a Algorithm) Hash() crypto.Hash {
+	if a > RSA3072Exp3SHA512 {
+		return 0
+	}
+	return []crypto.Hash{crypto.SHA1, crypto.SHA256, crypto.SHA512}[a%3]
+}
+
+func (a Algorithm) String() string {
+	if a.KeyBits() == 0 {
+		return fmt.Sprintf("unknown algorithm %d", uint32(a))
+	}
+	exp := ""
+	if a.Exponent() == 3 {
+		exp = " EXP3"
+	}
+	return fmt.Sprintf("RSA%d%s %s", a.KeyBits(), exp, a.Hash())
+}
+
+// PackedKeyHeader is struct vb2_packed_key. The key data starts KeyOffset
+// bytes after the start of the header.
+type PackedKeyHeader struct {
+	KeyOffset  uint32
+	_          uint32
+	KeySize    uint32
+	_          uint32
+	Algorithm  Algorithm
+	_          uint32
+	KeyVersion uint32
+	_          uint32
+}
+
+// PackedKey is a public key with its header.
+type PackedKey struct {
+	PackedKeyHeader
+	Data []byte
+}
+
+// SignatureHeader is struct vb2_signature. The signature starts SigOffset
+// bytes after the start of the header and signs DataSize bytes.
+type SignatureHeader struct {
+	SigOffset uint32
+	_         uint32
+	SigSize   uint32
+	_         uint32
+	DataSize  uint32
+	_         uint32
+}
+
+// Signature is a signature, or a digest, with its header.
+type Signature struct {
+	SignatureHeader
+	Data []byte
+}
+
+// read decodes the fixed size v at off of b.
+func read(b []byte, off uint32, v interface{}) error {
+	size := uint32(binary.Size(v))
+	if uint64(off)+uint64(size) > uint64(len(b)) {
+		return fmt.Errorf("%T at %#x: want %#x bytes, have %#x", v, off, size, len(b))
+	}
+	return binary.Read(bytes.NewReader(b[off:off+size]), binary.LittleEndian, v)
+}
+
+// slice returns the size bytes at off of b.
+func slice(b []byte, off, size uint32) ([]byte, error) {
+	return sliceAt(b, off, 0, size)
+}
+
+// sliceAt returns the size bytes at rel from off of b, as the offsets of the
+// data of keys and signatures count from their headers. The offsets are
+// added in 64 bits so that they cannot wrap.
+func sliceAt(b []byte, off, rel, size uint32) ([]byte, error) {
+	start := uint64(off) + uint64(rel)
+	end := start + uint64(size)
+	if end > uint64(len(b)) {
+		return nil, fmt.Errorf("[%#x, %#x) is outside of %#x bytes", start, end, len(b))
+	}
+	return b[start:end], nil
+}
+
+// parsePackedKey parses the packed key at off of b.
+func parsePackedKey(b []byte, off uint32) (*PackedKey, error) {
+	k := &PackedKey{}
+	if err := read(b, off, &k.PackedKeyHeader); err != nil {
+		return nil, err
+	}
+	data, err := sliceAt(b, off, k.KeyOffset, k.KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("key data: %w", err)
+	}
+	k.Data = data
+	return k, nil
+}
+
+// parseSignature parses the signature at off of b.
+func parseSignature(b []byte, off uint32) (*Signature, error) {
+	s := &Signature{}
+	if err := read(b, off, &s.SignatureHeader); err != nil {
+		return nil, err
+	}
+	data, err := sliceAt(b, off, s.SigOffset, s.SigSize)
+	if err != nil {
+		return nil, fmt.Errorf("signature data: %w", err)
+	}
+	s.Data = data
+	return s, nil
+}
+
+// PublicKey decodes the RSA public key. The key data is the number of 32
+// bit words of the modulus, -1/n mod 2^32, the modulus and R^2 mod n, all
+// little endian.
+func (k *PackedKey) PublicKey() (*rsa.PublicKey, error) {
+	bits := k.Algorithm.KeyBits()
+	if bits == 0 {
+		return nil, fmt.Errorf("key version %d: %v", k.KeyVersion, k.Algorithm)
+	}
+	words := uint32(bits / 32)
+	var arrSize uint32
+	if err := read(k.Data, 0, &arrSize); err != nil {
+		return nil, err
+	}
+	if arrSize != words {
+		return nil, fmt.Errorf("%v key has %d words, want %d", k.Algorithm, arrSize, words)
+	}
+	le, err := slice(k.Data, 8, 4*words)
+	if err != nil {
+		return nil, fmt.Errorf("modulus: %w", err)
+	}
+	be := make([]byte, len(le))
+	for i := range le {
+		be[len(be)-1-i] = le[i]
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(be), E: k.Algorithm.Exponent()}, nil
+}
+
+// flagNames returns the names of the bits set in flags, named after names,
+// and the remaining bits in hex.
+func flagNames(flags uint32, names []string) string {
+	var s []string
+	for i, n := range names {
+		if flags&(1<<i) != 0 {
+			s = append(s, n)
+			flags &^= 1 << i
+		}
+	}
+	if flags != 0 || len(s) == 0 {
+		s = append(s, fmt.Sprintf("%#x", flags))
+	}
+	return strings.Join(s, "|")
+}
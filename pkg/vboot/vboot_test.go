@@ -0,0 +1,297 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vboot
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"math/big"
+	"testing"
+)
+
+func le(t *testing.T, v ...interface{}) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	for _, v := range v {
+		if err := binary.Write(&b, binary.LittleEndian, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return b.Bytes()
+}
+
+// leWords returns n as little endian 32 bit words.
+func leWords(n *big.Int, words int) []byte {
+	be := n.FillBytes(make([]byte, 4*words))
+	for i, j := 0, len(be)-1; i < j; i, j = i+1, j-1 {
+		be[i], be[j] = be[j], be[i]
+	}
+	return be
+}
+
+// keyData encodes k as vboot does.
+func keyData(t *testing.T, k *rsa.PublicKey) []byte {
+	words := k.N.BitLen() / 32
+	r32 := new(big.Int).Lsh(big.NewInt(1), 32)
+	n0inv := new(big.Int).ModInverse(k.N, r32)
+	n0inv.Sub(r32, n0inv)
+	rr := new(big.Int).Lsh(big.NewInt(1), uint(2*k.N.BitLen()))
+	rr.Mod(rr, k.N)
+	return append(append(le(t, uint32(words), uint32(n0inv.Uint64())), leWords(k.N, words)...), leWords(rr, words)...)
+}
+
+func sign(t *testing.T, k *rsa.PrivateKey, a Algorithm, data []byte) []byte {
+	t.Helper()
+	h := a.Hash().New()
+	h.Write(data)
+	s, err := rsa.SignPKCS1v15(rand.Reader, k, a.Hash(), h.Sum(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+var testKeys = map[int]*rsa.PrivateKey{}
+
+func testKey(t *testing.T, bits int) *rsa.PrivateKey {
+	t.Helper()
+	if k, ok := testKeys[bits]; ok {
+		return k
+	}
+	k, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testKeys[bits] = k
+	return k
+}
+
+// buildGBB returns a GBB holding the root and recovery keys.
+func buildGBB(t *testing.T, hwid string, flags GBBFlags, root, recovery *rsa.PublicKey) []byte {
+	const hdrSize = 128
+	hw := append([]byte(hwid), 0)
+	rootData, recData := keyData(t, root), keyData(t, recovery)
+	rootKey := append(le(t, PackedKeyHeader{KeyOffset: 32, KeySize: uint32(len(rootData)), Algorithm: RSA4096SHA256, KeyVersion: 1}), rootData...)
+	recKey := append(le(t, PackedKeyHeader{KeyOffset: 32, KeySize: uint32(len(recData)), Algorithm: RSA4096SHA256, KeyVersion: 1}), recData...)
+	h := GBBHeader{
+		Signature:         GBBSignature,
+		MajorVersion:      1,
+		MinorVersion:      2,
+		HeaderSize:        hdrSize,
+		Flags:             flags,
+		HWIDOffset:        hdrSize,
+		HWIDSize:          uint32(len(hw)),
+		RootKeyOffset:     hdrSize + uint32(len(hw)),
+		RootKeySize:       uint32(len(rootKey)),
+		RecoveryKeyOffset: hdrSize + uint32(len(hw)+len(rootKey)),
+		RecoveryKeySize:   uint32(len(recKey)),
+	}
+	b := append(le(t, h), hw...)
+	b = append(b, rootKey...)
+	return append(b, recKey...)
+}
+
+// buildKeyblock returns a keyblock holding the data key, signed by the root
+// key.
+func buildKeyblock(t *testing.T, root *rsa.PrivateKey, data *rsa.PublicKey, flags KeyblockFlags, version uint32) []byte {
+	const hdrSize = 112
+	kd := keyData(t, data)
+	signed := uint32(hdrSize + len(kd))
+	sigSize := uint32(root.Size())
+	h := KeyblockHeader{
+		Magic:              KeyblockMagic,
+		HeaderVersionMajor: 2,
+		HeaderVersionMinor: 1,
+		KeyblockSize:       signed + sigSize + sha512.Size,
+		KeyblockSignature:  SignatureHeader{SigOffset: signed - keyblockSignatureOffset, SigSize: sigSize, DataSize: signed},
+		KeyblockHash:       SignatureHeader{SigOffset: signed + sigSize - keyblockHashOffset, SigSize: sha512.Size, DataSize: signed},
+		KeyblockFlags:      flags,
+		DataKey:            PackedKeyHeader{KeyOffset: hdrSize - keyblockDataKeyOffset, KeySize: uint32(len(kd)), Algorithm: RSA2048SHA256, KeyVersion: version},
+	}
+	b := append(le(t, h), kd...)
+	hash := sha512.Sum512(b)
+	return append(append(b, sign(t, root, RSA4096SHA256, b)...), hash[:]...)
+}
+
+// buildPreamble returns a firmware preamble signed by the data key and
+// signing the body.
+func buildPreamble(t *testing.T, data *rsa.PrivateKey, body []byte, version uint32) []byte {
+	const hdrSize = 108
+	subkey := keyData(t, &testKey(t, 1024).PublicKey)
+	sigSize := uint32(data.Size())
+	signed := uint32(hdrSize+len(subkey)) + sigSize
+	h := FirmwarePreambleHeader{
+		PreambleSize:       signed + sigSize,
+		PreambleSignature:  SignatureHeader{SigOffset: signed - preambleSignatureOffset, SigSize: sigSize, DataSize: signed},
+		HeaderVersionMajor: 2,
+		HeaderVersionMinor: 1,
+		FirmwareVersion:    version,
+		KernelSubkey:       PackedKeyHeader{KeyOffset: hdrSize - preambleKernelSubkeyOffset, KeySize: uint32(len(subkey)), Algorithm: RSA1024SHA256, KeyVersion: 1},
+		BodySignature:      SignatureHeader{SigOffset: hdrSize + uint32(len(subkey)) - preambleBodySignatureOffset, SigSize: sigSize, DataSize: uint32(len(body))},
+	}
+	b := append(le(t, h), subkey...)
+	b = append(b, sign(t, data, RSA2048SHA256, body)...)
+	return append(b, sign(t, data, RSA2048SHA256, b)...)
+}
+
+func TestParseGBB(t *testing.T) {
+	root, recovery := testKey(t, 4096), testKey(t, 4096)
+	b := buildGBB(t, "FIANO TEST 1234", 0x39, &root.PublicKey, &recovery.PublicKey)
+	g, err := ParseGBB(append(b, make([]byte, 0x100)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.HWID != "FIANO TEST 1234" {
+		t.Errorf("got HWID %q, want %q", g.HWID, "FIANO TEST 1234")
+	}
+	if want := "DEV_SCREEN_SHORT_DELAY|FORCE_DEV_SWITCH_ON|FORCE_DEV_BOOT_USB|DISABLE_FW_ROLLBACK_CHECK"; g.Flags.String() != want {
+		t.Errorf("got flags %v, want %v", g.Flags, want)
+	}
+	for _, k := range []struct {
+		name string
+		got  *PackedKey
+		want *rsa.PublicKey
+	}{
+		{"root", g.RootKey, &root.PublicKey},
+		{"recovery", g.RecoveryKey, &recovery.PublicKey},
+	} {
+		pub, err := k.got.PublicKey()
+		if err != nil {
+			t.Fatalf("%s key: %v", k.name, err)
+		}
+		if !pub.Equal(k.want) {
+			t.Errorf("%s key: got a different key", k.name)
+		}
+	}
+
+	b[0] = 'X'
+	if _, err := ParseGBB(b); err == nil {
+		t.Errorf("bad signature: got nil, want error")
+	}
+}
+
+func TestEditGBB(t *testing.T) {
+	root, recovery := testKey(t, 4096), testKey(t, 4096)
+	b := buildGBB(t, "FIANO TEST 1234", 0x39, &root.PublicKey, &recovery.PublicKey)
+	g, err := ParseGBB(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flags, err := ParseGBBFlags("force_dev_switch_on|0x100000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.SetFlags(b, flags); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.SetHWID(b, "FIANO 42"); err != nil {
+		t.Fatal(err)
+	}
+	recKey := append([]byte{}, b[g.RecoveryKeyOffset:g.RecoveryKeyOffset+g.RecoveryKeySize]...)
+	if err := g.SetRootKey(b, recKey); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ParseGBB(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "FORCE_DEV_SWITCH_ON|0x100000"; got.Flags.String() != want || g.Flags != got.Flags {
+		t.Errorf("got flags %v and %v, want %v", got.Flags, g.Flags, want)
+	}
+	if got.HWID != "FIANO 42" || got.HWIDDigest != sha256.Sum256([]byte("FIANO 42")) || g.HWIDDigest != got.HWIDDigest {
+		t.Errorf("got HWID %q with digest %x", got.HWID, got.HWIDDigest)
+	}
+	for _, k := range []*PackedKey{got.RootKey, g.RootKey} {
+		pub, err := k.PublicKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !pub.Equal(&recovery.PublicKey) {
+			t.Errorf("root key was not replaced")
+		}
+	}
+
+	if err := g.SetHWID(b, "FIANO TEST 12345"); err == nil {
+		t.Errorf("long HWID: got nil, want error")
+	}
+	if err := g.SetRecoveryKey(b, recKey[:40]); err == nil {
+		t.Errorf("short key: got nil, want error")
+	}
+	if _, err := ParseGBBFlags("FORCE_DEV_SWITCH"); err == nil {
+		t.Errorf("unknown flag: got nil, want error")
+	}
+}
+
+func TestParseVBlock(t *testing.T) {
+	root, data := testKey(t, 4096), testKey(t, 2048)
+	body := bytes.Repeat([]byte("body"), 0x100)
+	b := append(buildKeyblock(t, root, &data.PublicKey, 0x7, 3), buildPreamble(t, data, body, 42)...)
+	v, err := ParseVBlock(append(b, 0xff, 0xff))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := v.Keyblock
+	if k.KeyblockFlags.String() != "DEVELOPER_0|DEVELOPER_1|RECOVERY_0" {
+		t.Errorf("got keyblock flags %v, want DEVELOPER_0|DEVELOPER_1|RECOVERY_0", k.KeyblockFlags)
+	}
+	if k.DataKey.KeyVersion != 3 || k.DataKey.Algorithm != RSA2048SHA256 {
+		t.Errorf("got data key version %d %v, want version 3 %v", k.DataKey.KeyVersion, k.DataKey.Algorithm, RSA2048SHA256)
+	}
+	pub, err := k.DataKey.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pub.Equal(&data.PublicKey) {
+		t.Errorf("got a different data key")
+	}
+	if len(k.Signature.Data) != 512 || len(k.Hash.Data) != sha512.Size {
+		t.Errorf("got %d signature and %d hash bytes, want 512 and %d", len(k.Signature.Data), len(k.Hash.Data), sha512.Size)
+	}
+
+	p := v.Preamble
+	if p.FirmwareVersion != 42 {
+		t.Errorf("got firmware version %d, want 42", p.FirmwareVersion)
+	}
+	if p.BodySignature.DataSize != uint32(len(body)) {
+		t.Errorf("got a body of %#x bytes, want %#x", p.BodySignature.DataSize, len(body))
+	}
+	if p.Flags.String() != "0x0" {
+		t.Errorf("got preamble flags %v, want 0x0", p.Flags)
+	}
+
+	if _, err := ParseVBlock(b[:len(b)-1]); err == nil {
+		t.Errorf("truncated vblock: got nil, want error")
+	}
+	// The offset of the data key wraps to the start of the keyblock in 32
+	// bits.
+	bad := append([]byte{}, b...)
+	binary.LittleEndian.PutUint32(bad[keyblockDataKeyOffset:], 1<<32-keyblockDataKeyOffset)
+	if _, err := ParseVBlock(bad); err == nil {
+		t.Errorf("data key offset wrapping around: got nil, want error")
+	}
+}
+
+func TestAlgorithm(t *testing.T) {
+	for _, tt := range []struct {
+		a    Algorithm
+		want string
+	}{
+		{RSA1024SHA1, "RSA1024 SHA-1"},
+		{RSA8192SHA512, "RSA8192 SHA-512"},
+		{RSA3072Exp3SHA256, "RSA3072 EXP3 SHA-256"},
+		{Algorithm(18), "unknown algorithm 18"},
+	} {
+		if got := tt.a.String(); got != tt.want {
+			t.Errorf("%d: got %q, want %q", uint32(tt.a), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vboot
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/linuxboot/fiano/pkg/fmap"
+)
+
+// Verify checks that sig signs the first sig.DataSize bytes of data with k.
+func (k *PackedKey) Verify(sig *Signature, data []byte) error {
+	if uint64(sig.DataSize) > uint64(len(data)) {
+		return fmt.Errorf("signature covers %#x bytes, have %#x", sig.DataSize, len(data))
+	}
+	pub, err := k.PublicKey()
+	if err != nil {
+		return err
+	}
+	h := k.Algorithm.Hash().New()
+	h.Write(data[:sig.DataSize])
+	return rsa.VerifyPKCS1v15(pub, k.Algorithm.Hash(), h.Sum(nil), sig.Data)
+}
+
+// inside reports whether the first size bytes of a structure hold its
+// member at off, of hdrSize bytes, and the data of the member at dataOffset
+// from it, of dataSize bytes, as vb2_verify_member_inside checks.
+func inside(size, off, hdrSize, dataOffset, dataSize uint32) bool {
+	return uint64(off)+uint64(hdrSize) <= uint64(size) &&
+		uint64(off)+uint64(dataOffset)+uint64(dataSize) <= uint64(size)
+}
+
+// Verify checks the hash of the keyblock and its signature with key. As in
+// vb2_verify_keyblock, the signature must cover the data key.
+func (k *Keyblock) Verify(key *PackedKey) error {
+	if !inside(k.Signature.DataSize, keyblockDataKeyOffset, uint32(binary.Size(k.DataKey.PackedKeyHeader)), k.DataKey.KeyOffset, k.DataKey.KeySize) {
+		return fmt.Errorf("keyblock signature covers %#x bytes, not the data key", k.Signature.DataSize)
+	}
+	if uint64(k.Hash.DataSize) > uint64(len(k.Raw)) {
+		return fmt.Errorf("keyblock hash covers %#x bytes, have %#x", k.Hash.DataSize, len(k.Raw))
+	}
+	sum := sha512.Sum512(k.Raw[:k.Hash.DataSize])
+	if !bytes.Equal(sum[:], k.Hash.Data) {
+		return errors.New("keyblock hash mismatch")
+	}
+	if err := key.Verify(k.Signature, k.Raw); err != nil {
+		return fmt.Errorf("keyblock signature: %w", err)
+	}
+	return nil
+}
+
+// Verify checks the signature of the preamble with the data key of the
+// keyblock. As in vb2_verify_fw_preamble, the signature must cover the body
+// signature and the kernel subkey.
+func (p *FirmwarePreamble) Verify(dataKey *PackedKey) error {
+	size := p.Signature.DataSize
+	if uint64(size) < uint64(binary.Size(p.FirmwarePreambleHeader)) {
+		return fmt.Errorf("firmware preamble signature covers %#x bytes, not the header", size)
+	}
+	if !inside(size, preambleBodySignatureOffset, uint32(binary.Size(p.BodySignature.SignatureHeader)), p.BodySignature.SigOffset, p.BodySignature.SigSize) {
+		return fmt.Errorf("firmware preamble signature covers %#x bytes, not the body signature", size)
+	}
+	if !inside(size, preambleKernelSubkeyOffset, uint32(binary.Size(p.KernelSubkey.PackedKeyHeader)), p.KernelSubkey.KeyOffset, p.KernelSubkey.KeySize) {
+		return fmt.Errorf("firmware preamble signature covers %#x bytes, not the kernel subkey", size)
+	}
+	if err := dataKey.Verify(p.Signature, p.Raw); err != nil {
+		return fmt.Errorf("firmware preamble signature: %w", err)
+	}
+	return nil
+}
+
+// VerifyBody checks the signature of the firmware body with the data key of
+// the keyblock.
+func (p *FirmwarePreamble) VerifyBody(dataKey *PackedKey, body []byte) error {
+	if err := dataKey.Verify(p.BodySignature, body); err != nil {
+		return fmt.Errorf("firmware body signature: %w", err)
+	}
+	return nil
+}
+
+// SlotResult is the outcome of verifying an RW firmware slot.
+type SlotResult struct {
+	Slot string
+	// Key is the GBB key which verified the keyblock, "root" or
+	// "recovery".
+	Key      string `json:",omitempty"`
+	Verified bool
+	// The versions checked against the rollback counters.
+	DataKeyVersion  uint32
+	FirmwareVersion uint32
+	Error           string `json:",omitempty"`
+}
+
+// VerifySlot verifies the keyblock of vblock with the keys of the GBB, then
+// the preamble and the firmware body with the data key of the keyblock.
+func VerifySlot(gbb *GBB, vblock, body []byte) SlotResult {
+	var r SlotResult
+	v, err := ParseVBlock(vblock)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	r.DataKeyVersion = v.Keyblock.DataKey.KeyVersion
+	r.FirmwareVersion = v.Preamble.FirmwareVersion
+
+	if err = v.Keyblock.Verify(gbb.RootKey); err == nil {
+		r.Key = "root"
+	} else if v.Keyblock.Verify(gbb.RecoveryKey) == nil {
+		r.Key = "recovery"
+	} else {
+		r.Error = err.Error()
+		return r
+	}
+	if err := v.Preamble.Verify(v.Keyblock.DataKey); err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	if err := v.Preamble.VerifyBody(v.Keyblock.DataKey, body); err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	r.Verified = true
+	return r
+}
+
+// Slots are the RW firmware slots, named after their FMAP areas VBLOCK_x
+// and FW_MAIN_x.
+var Slots = []string{"A", "B"}
+
+// VerifyImage verifies the RW slots of the image with the keys of its GBB,
+// all found through the FMAP. Slots missing from the FMAP are skipped.
+func VerifyImage(image []byte) ([]SlotResult, error) {
+	r := bytes.NewReader(image)
+	f, _, err := fmap.Read(r)
+	if err != nil {
+		return nil, err
+	}
+	b, err := f.ReadAreaByName(r, "GBB")
+	if err != nil {
+		return nil, err
+	}
+	gbb, err := ParseGBB(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []SlotResult
+	for _, slot := range Slots {
+		if f.IndexOfArea("VBLOCK_"+slot) < 0 {
+			continue
+		}
+		res := SlotResult{Slot: slot}
+		vblock, err := f.ReadAreaByName(r, "VBLOCK_"+slot)
+		if err == nil {
+			var body []byte
+			if body, err = f.ReadAreaByName(r, "FW_MAIN_"+slot); err == nil {
+				res = VerifySlot(gbb, vblock, body)
+				res.Slot = slot
+			}
+		}
+		if err != nil {
+			res.Error = err.Error()
+		}
+		results = append(results, res)
+	}
+	if len(results) == 0 {
+		return nil, errors.New("no VBLOCK area in the FMAP")
+	}
+	return results, nil
+}
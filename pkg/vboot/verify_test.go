@@ -0,0 +1,184 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vboot
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/fmap"
+)
+
+func TestVerifySlot(t *testing.T) {
+	root, data := testKey(t, 4096), testKey(t, 2048)
+	recovery, err := rsa.GenerateKey(rand.Reader, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gbb, err := ParseGBB(buildGBB(t, "TEST", 0, &root.PublicKey, &recovery.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := bytes.Repeat([]byte("body"), 0x100)
+	vblock := append(buildKeyblock(t, root, &data.PublicKey, 0, 1), buildPreamble(t, data, body, 7)...)
+
+	r := VerifySlot(gbb, vblock, body)
+	if want := (SlotResult{Key: "root", Verified: true, DataKeyVersion: 1, FirmwareVersion: 7}); r != want {
+		t.Errorf("got %+v, want %+v", r, want)
+	}
+
+	rec := append(buildKeyblock(t, recovery, &data.PublicKey, 0, 1), buildPreamble(t, data, body, 7)...)
+	if r := VerifySlot(gbb, rec, body); !r.Verified || r.Key != "recovery" {
+		t.Errorf("keyblock signed with the recovery key: got %+v, want it verified with the recovery key", r)
+	}
+
+	bad := append([]byte{}, body...)
+	bad[0] ^= 1
+	if r := VerifySlot(gbb, vblock, bad); r.Verified || r.Error == "" {
+		t.Errorf("modified body: got %+v, want an error", r)
+	}
+	bad = append([]byte{}, vblock...)
+	bad[len(bad)-1] ^= 1
+	if r := VerifySlot(gbb, bad, body); r.Verified || r.Error == "" {
+		t.Errorf("modified preamble signature: got %+v, want an error", r)
+	}
+	other := testKey(t, 2048)
+	bad = append(buildKeyblock(t, other, &data.PublicKey, 0, 1), buildPreamble(t, data, body, 7)...)
+	if r := VerifySlot(gbb, bad, body); r.Verified || r.Key != "" {
+		t.Errorf("keyblock signed with another key: got %+v, want an error", r)
+	}
+}
+
+func TestVerifyCoverage(t *testing.T) {
+	root, data := testKey(t, 4096), testKey(t, 2048)
+	body := bytes.Repeat([]byte("body"), 0x100)
+
+	// The keyblock signature covers the header of the data key, not its
+	// data. The hash still covers both.
+	kb := buildKeyblock(t, root, &data.PublicKey, 0, 1)
+	k, err := ParseKeyblock(kb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := k.Hash.DataSize
+	binary.LittleEndian.PutUint32(kb[keyblockSignatureOffset+16:], keyblockDataKeyOffset+32)
+	copy(kb[signed:], sign(t, root, RSA4096SHA256, kb[:keyblockDataKeyOffset+32]))
+	hash := sha512.Sum512(kb[:signed])
+	copy(kb[signed+uint32(root.Size()):], hash[:])
+	if k, err = ParseKeyblock(kb); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Verify(mustKey(t, root)); err == nil || !strings.Contains(err.Error(), "data key") {
+		t.Errorf("keyblock signature not covering the data key: got %v, want an error", err)
+	}
+
+	// The preamble signature stops before the data of the body signature,
+	// which follows the kernel subkey, or before the end of the header.
+	for _, tt := range []struct {
+		size func(p *FirmwarePreamble) uint32
+		want string
+	}{
+		{func(p *FirmwarePreamble) uint32 { return preambleBodySignatureOffset + p.BodySignature.SigOffset }, "body signature"},
+		{func(p *FirmwarePreamble) uint32 { return preambleBodySignatureOffset }, "header"},
+	} {
+		pb := buildPreamble(t, data, body, 7)
+		p, err := ParseFirmwarePreamble(pb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Verify(mustKey(t, data)); err != nil {
+			t.Fatal(err)
+		}
+		size := tt.size(p)
+		binary.LittleEndian.PutUint32(pb[preambleSignatureOffset+16:], size)
+		copy(pb[preambleSignatureOffset+p.Signature.SigOffset:], sign(t, data, RSA2048SHA256, pb[:size]))
+		if p, err = ParseFirmwarePreamble(pb); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Verify(mustKey(t, data)); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("preamble signature covering %#x bytes: got %v, want an error about the %s", size, err, tt.want)
+		}
+	}
+}
+
+// mustKey returns the packed public key of k.
+func mustKey(t *testing.T, k *rsa.PrivateKey) *PackedKey {
+	t.Helper()
+	a := RSA2048SHA256
+	if k.Size() == 512 {
+		a = RSA4096SHA256
+	}
+	return &PackedKey{PackedKeyHeader: PackedKeyHeader{Algorithm: a}, Data: keyData(t, &k.PublicKey)}
+}
+
+// buildImage returns an image with an FMAP, a GBB and the given areas.
+func buildImage(t *testing.T, gbb []byte, areas map[string][]byte) []byte {
+	t.Helper()
+	const fmapSize = 0x400
+	f := &fmap.FMap{Header: fmap.Header{VerMajor: 1, VerMinor: 1}}
+	copy(f.Signature[:], "__FMAP__")
+	copy(f.Name.Value[:], "FLASH")
+	image := make([]byte, fmapSize)
+	add := func(name string, b []byte) {
+		a := fmap.Area{Offset: uint32(len(image)), Size: uint32(len(b))}
+		copy(a.Name.Value[:], name)
+		f.Areas = append(f.Areas, a)
+		image = append(image, b...)
+	}
+	add("GBB", gbb)
+	for _, name := range []string{"VBLOCK_A", "FW_MAIN_A", "VBLOCK_B", "FW_MAIN_B"} {
+		if b, ok := areas[name]; ok {
+			add(name, b)
+		}
+	}
+	f.NAreas = uint16(len(f.Areas))
+	f.Size = uint32(len(image))
+	var hdr bytes.Buffer
+	if err := binary.Write(&hdr, binary.LittleEndian, f.Header); err != nil {
+		t.Fatal(err)
+	}
+	if err := binary.Write(&hdr, binary.LittleEndian, f.Areas); err != nil {
+		t.Fatal(err)
+	}
+	copy(image, hdr.Bytes())
+	return image
+}
+
+func TestVerifyImage(t *testing.T) {
+	root, data := testKey(t, 4096), testKey(t, 2048)
+	body := bytes.Repeat([]byte("body"), 0x100)
+	badBody := append([]byte{}, body...)
+	badBody[0] ^= 1
+	image := buildImage(t, buildGBB(t, "TEST", 0, &root.PublicKey, &root.PublicKey), map[string][]byte{
+		"VBLOCK_A":  append(buildKeyblock(t, root, &data.PublicKey, 0, 1), buildPreamble(t, data, body, 3)...),
+		"FW_MAIN_A": body,
+		"VBLOCK_B":  append(buildKeyblock(t, root, &data.PublicKey, 0, 1), buildPreamble(t, data, body, 2)...),
+		"FW_MAIN_B": badBody,
+	})
+
+	results, err := VerifyImage(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d slots, want 2", len(results))
+	}
+	if want := (SlotResult{Slot: "A", Key: "root", Verified: true, DataKeyVersion: 1, FirmwareVersion: 3}); results[0] != want {
+		t.Errorf("got %+v, want %+v", results[0], want)
+	}
+	if r := results[1]; r.Slot != "B" || r.Verified || r.FirmwareVersion != 2 || r.Error == "" {
+		t.Errorf("got %+v, want slot B failing with firmware version 2", r)
+	}
+
+	noSlots := buildImage(t, buildGBB(t, "TEST", 0, &root.PublicKey, &root.PublicKey), nil)
+	if _, err := VerifyImage(noSlots); err == nil {
+		t.Errorf("image without slots: got nil, want error")
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// Annotations maps the GUID of a firmware node to a human comment, or its
+// path in the tree, as in the errors, for the nodes without a GUID. They are
+// stored in a JSON sidecar file next to the image so that analysis notes
+// carry across image revisions.
+type Annotations map[string]string
+
+// ReadAnnotations reads an annotation sidecar file. A missing file yields
+// an empty set of annotations.
+func ReadAnnotations(path string) (Annotations, error) {
+	a := Annotations{}
+	buf, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]string
+	if err := json.Unmarshal(buf, &raw); err != nil {
+		return nil, fmt.Errorf("unable to parse annotations %q: %v", path, err)
+	}
+	for k, note := range raw {
+		if _, err := guid.Parse(k); err == nil {
+			k = strings.ToUpper(k)
+		}
+		a[k] = note
+	}
+	return a, nil
+}
+
+// Write writes the annotations to a sidecar file.
+func (a Annotations) Write(path string) error {
+	buf, err := json.MarshalIndent(a, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFile(path, append(buf, '\n'))
+}
+
+// Lookup returns the annotation of a firmware node at path in the tree, by
+// its GUID or else by its path, if any. The root has an empty path.
+func (a Annotations) Lookup(f uefi.Firmware, path string) (string, bool) {
+	if key := tableGUID(f); key != "" {
+		if note, ok := a[strings.ToUpper(key)]; ok {
+			return note, true
+		}
+	}
+	if path == "" {
+		return "", false
+	}
+	note, ok := a[path]
+	return note, ok
+}
+
+// childPath returns the path of the node f, a child of the node at path, as
+// in the errors.
+func childPath(path string, f uefi.Firmware) string {
+	if path == "" {
+		return uefi.NodeName(f)
+	}
+	return path + "/" + uefi.NodeName(f)
+}
+
+// Annotate attaches a comment to all nodes matching Predicate and saves it
+// to the sidecar file. The nodes without a GUID are annotated by path.
+type Annotate struct {
+	// Input
+	// Path selects the node by its path in the tree, as in the errors.
+	// Predicate is used when it is empty.
+	Path        string
+	Predicate   FindPredicate
+	Text        string
+	SidecarPath string
+
+	// Output
+	Matches []uefi.Firmware
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Annotate) Run(f uefi.Firmware) error {
+	if v.Path != "" {
+		node, err := findPath(f, v.Path)
+		if err != nil {
+			return err
+		}
+		v.Matches = []uefi.Firmware{node}
+	} else {
+		find := &Find{Predicate: v.Predicate}
+		if err := find.Run(f); err != nil {
+			return err
+		}
+		v.Matches = find.Matches
+	}
+	if len(v.Matches) == 0 {
+		return errors.New("no matches found for annotation")
+	}
+
+	a, err := ReadAnnotations(v.SidecarPath)
+	if err != nil {
+		return err
+	}
+	for _, m := range v.Matches {
+		if key := tableGUID(m); key != "" {
+			a[strings.ToUpper(key)] = v.Text
+			continue
+		}
+		path, _, _ := uefi.Locate(f, m)
+		if path == "" {
+			return fmt.Errorf("cannot annotate the root %s, it has no GUID", uefi.NodeName(m))
+		}
+		a[path] = v.Text
+	}
+	return a.Write(v.SidecarPath)
+}
+
+// Visit applies the Annotate visitor to any Firmware type.
+func (v *Annotate) Visit(f uefi.Firmware) error {
+	return nil
+}
+
+// AnnotatedNode is the JSON output of ShowAnnotations.
+type AnnotatedNode struct {
+	GUID       string `json:",omitempty"`
+	Path       string `json:",omitempty"`
+	Name       string `json:",omitempty"`
+	Type       string
+	Annotation string
+}
+
+// ShowAnnotations prints all annotated nodes of the image as JSON.
+type ShowAnnotations struct {
+	// Input
+	Annotations Annotations
+
+	// JSON is written to this writer.
+	W io.Writer
+
+	// Output
+	Nodes []AnnotatedNode
+
+	// The path of the parent of the visited nodes, and whether they are
+	// below the root.
+	parent string
+	nested bool
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *ShowAnnotations) Run(f uefi.Firmware) error {
+	v.Nodes = []AnnotatedNode{}
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	if v.W != nil {
+		return writeStructured(v.W, v.Nodes)
+	}
+	return nil
+}
+
+// Visit applies the ShowAnnotations visitor to any Firmware type.
+func (v *ShowAnnotations) Visit(f uefi.Firmware) error {
+	var path string
+	if v.nested {
+		path = childPath(v.parent, f)
+	}
+	if note, ok := v.Annotations.Lookup(f, path); ok {
+		v.Nodes = append(v.Nodes, AnnotatedNode{
+			GUID:       tableGUID(f),
+			Path:       path,
+			Name:       tableName(f, ""),
+			Type:       strings.TrimPrefix(fmt.Sprintf("%T", f), "*uefi."),
+			Annotation: note,
+		})
+	}
+	children := &ShowAnnotations{Annotations: v.Annotations, parent: path, nested: true}
+	err := f.ApplyChildren(children)
+	v.Nodes = append(v.Nodes, children.Nodes...)
+	return err
+}
+
+func init() {
+	RegisterCLI("annotate", "attach a comment to the nodes matching a GUID or NAME or at a tree PATH, stored in a JSON sidecar: annotate SIDECAR (GUID|NAME|PATH) TEXT", 3, func(args []string) (uefi.Visitor, error) {
+		if strings.ContainsAny(args[1], "/ ") {
+			return &Annotate{
+				SidecarPath: args[0],
+				Path:        args[1],
+				Text:        args[2],
+			}, nil
+		}
+		pred, err := FindFileFVPredicate(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return &Annotate{
+			SidecarPath: args[0],
+			Predicate:   pred,
+			Text:        args[2],
+		}, nil
+	})
+	RegisterCLI("annotations", "print the annotated nodes from a JSON sidecar as JSON", 1, func(args []string) (uefi.Visitor, error) {
+		a, err := ReadAnnotations(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &ShowAnnotations{
+			Annotations: a,
+			W:           os.Stdout,
+		}, nil
+	})
+	RegisterCLI("annotated-table", "print out important information in a pretty table, with annotations from a JSON sidecar", 1, func(args []string) (uefi.Visitor, error) {
+		a, err := ReadAnnotations(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &Table{Annotations: a}, nil
+	})
+}
@@ -0,0 +1,113 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAnnotate(t *testing.T) {
+	f := parseImage(t)
+	sidecar := filepath.Join(t.TempDir(), "notes.json")
+
+	pred, err := FindFilePredicate("DxeCore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	annotate := &Annotate{Predicate: pred, Text: "entry point audited", SidecarPath: sidecar}
+	if err := annotate.Run(f); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := ReadAnnotations(sidecar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := a[dxeCoreGUID.String()]; got != "entry point audited" {
+		t.Fatalf("got annotation %q for DxeCore", got)
+	}
+
+	show := &ShowAnnotations{Annotations: a}
+	if err := show.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(show.Nodes) != 1 || show.Nodes[0].Name != "DxeCore" {
+		t.Fatalf("got annotated nodes %+v, want only DxeCore", show.Nodes)
+	}
+
+	var b bytes.Buffer
+	table := &Table{Format: "csv", Columns: []string{"name", "annotation"}, Out: &b, Annotations: a}
+	if err := table.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "DxeCore,entry point audited\n") {
+		t.Errorf("annotation missing from table output")
+	}
+}
+
+func TestAnnotatePath(t *testing.T) {
+	f := parseImage(t)
+	sidecar := filepath.Join(t.TempDir(), "notes.json")
+
+	// The PE32 section of DxeCore has no GUID, so it is annotated by path.
+	const path = "FV 8C8CE578-8A3D-4F1C-9935-896185C32DD3/File 9E21FD93-9C72-4C15-8C4B-E77F1DB2D792/Section EFI_SECTION_GUID_DEFINED/Section EFI_SECTION_FIRMWARE_VOLUME_IMAGE/FV 8C8CE578-8A3D-4F1C-9935-896185C32DD3/File D6A2CB7F-6A18-4E2F-B43B-9920A733700A/Section EFI_SECTION_PE32"
+	v, err := ParseCLI([]string{"annotate", sidecar, path, "entry point"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ExecuteCLI(f, v); err != nil {
+		t.Fatal(err)
+	}
+	a, err := ReadAnnotations(sidecar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := a[path]; got != "entry point" {
+		t.Fatalf("got annotation %q for %s", got, path)
+	}
+
+	show := &ShowAnnotations{Annotations: a}
+	if err := show.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(show.Nodes) != 1 || show.Nodes[0].Path != path {
+		t.Fatalf("got annotated nodes %+v, want only %s", show.Nodes, path)
+	}
+
+	// The structured rows of the table carry the annotations.
+	var b bytes.Buffer
+	table := &Table{Format: FormatJSON, Out: &b, Annotations: a}
+	if err := table.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	var rows []TableRow
+	if err := json.Unmarshal(b.Bytes(), &rows); err != nil {
+		t.Fatal(err)
+	}
+	var notes []string
+	for _, r := range rows {
+		if r.Annotation != "" {
+			notes = append(notes, r.Node+" "+r.Annotation)
+		}
+	}
+	if want := []string{"Sec entry point"}; !reflect.DeepEqual(notes, want) {
+		t.Errorf("got annotated rows %q, want %q", notes, want)
+	}
+}
+
+func TestReadAnnotationsMissing(t *testing.T) {
+	a, err := ReadAnnotations(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a) != 0 {
+		t.Errorf("expected no annotations, got %v", a)
+	}
+}
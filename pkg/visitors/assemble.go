@@ -6,9 +6,12 @@ package visitors
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
+	"runtime"
 	"sort"
+	"sync"
 
 	"github.com/linuxboot/fiano/pkg/compression"
 	"github.com/linuxboot/fiano/pkg/guid"
@@ -26,17 +29,157 @@ type Assemble struct {
 	// also use the FFSV3 GUID? In that case we should fix this since only the innermost
 	// enclosing FV changes to FFSV3
 	useFFS3 bool
+
+	// Parallel assembles the files of each firmware volume and the sections
+	// of each file or section concurrently. They are independent subtrees,
+	// so the result is identical to a serial assembly. The number of
+	// goroutines is bounded by GOMAXPROCS.
+	Parallel bool
+	sem      chan struct{}
+
+	// The progress of the files is counted from the root of the assembly,
+	// which is visited at depth 0.
+	depth    int
+	progress *uefi.Progress
+	// The root of the assembly, to locate the nodes of the errors.
+	root uefi.Firmware
+}
+
+// Mutates implements Mutator.
+func (v *Assemble) Mutates() bool {
+	return true
 }
 
 // Run just applies the visitor.
 func (v *Assemble) Run(f uefi.Firmware) error {
-	return f.Apply(v)
+	return v.RunContext(context.Background(), f)
+}
+
+// RunContext applies the visitor, stopping with the error of ctx once ctx
+// is done. The context is checked before each file is assembled.
+func (v *Assemble) RunContext(ctx context.Context, f uefi.Firmware) error {
+	if err := writable(f); err != nil {
+		return err
+	}
+	ctx, endTrace := uefi.StartTrace(ctx, uefi.ProgressAssemble)
+	defer func() { v.root = nil }()
+	err := f.Apply(contextVisitor{ctx, v.visitContext})
+	endTrace(err)
+	return err
+}
+
+// parallelChildren returns the children of f which are assembled
+// concurrently in parallel mode: the files of a firmware volume, the
+// sections of a file and the sections encapsulated by a section. Sibling
+// compressed sections, which take most of the time, are then encoded
+// concurrently, since LZMA streams cannot be split.
+func parallelChildren(f uefi.Firmware) []uefi.Firmware {
+	var children []uefi.Firmware
+	switch f := f.(type) {
+	case *uefi.FirmwareVolume:
+		for _, file := range f.Files {
+			children = append(children, file)
+		}
+	case *uefi.File:
+		if f.NVarStore != nil {
+			return nil
+		}
+		for _, s := range f.Sections {
+			children = append(children, s)
+		}
+	case *uefi.Section:
+		for _, es := range f.Encapsulated {
+			children = append(children, es.Value)
+		}
+	}
+	return children
+}
+
+// assembleChildren assembles the children of f, concurrently for those
+// returned by parallelChildren when running in parallel mode.
+func (v *Assemble) assembleChildren(ctx context.Context, f uefi.Firmware) error {
+	children := parallelChildren(f)
+	if !v.Parallel || len(children) < 2 {
+		return f.ApplyChildren(contextVisitor{ctx, v.visitContext})
+	}
+	if v.sem == nil {
+		v.sem = make(chan struct{}, runtime.GOMAXPROCS(0))
+	}
+
+	var wg sync.WaitGroup
+	visitors := make([]*Assemble, len(children))
+	errs := make([]error, len(children))
+	for i, c := range children {
+		visitors[i] = &Assemble{Parallel: true, sem: v.sem, depth: v.depth, progress: v.progress, root: v.root}
+		select {
+		case v.sem <- struct{}{}:
+			wg.Add(1)
+			go func(i int, c uefi.Firmware) {
+				defer wg.Done()
+				defer func() { <-v.sem }()
+				errs[i] = c.Apply(contextVisitor{ctx, visitors[i].visitContext})
+			}(i, c)
+		default:
+			// All workers are busy, possibly with our ancestors. Assemble on
+			// this goroutine rather than waiting to avoid a deadlock.
+			errs[i] = c.Apply(contextVisitor{ctx, visitors[i].visitContext})
+		}
+	}
+	wg.Wait()
+
+	for i := range children {
+		if errs[i] != nil {
+			return errs[i]
+		}
+		v.useFFS3 = v.useFFS3 || visitors[i].useFFS3
+	}
+	return nil
+}
+
+// alignFileOffset returns the offset at which the file is placed when the
+// previous file ends at the 8 byte aligned offset alignedOffset. Any gap is
+// filled with a pad file by the caller.
+func alignFileOffset(alignedOffset uint64, file *uefi.File) uint64 {
+	alignBase := file.Header.Attributes.GetAlignment()
+	if alignBase == 1 {
+		return alignedOffset
+	}
+	hl := file.HeaderLen()
+	// We need to align the data, not the header. This is so terrible.
+	fileDataOffset := uefi.Align(alignedOffset+hl, alignBase)
+	// Calculate the starting offset of the file
+	newOffset := fileDataOffset - hl
+	if gap := (newOffset - alignedOffset); gap >= 8 && gap < uefi.FileHeaderMinLength {
+		// We need to re align to the next boundary cause we can't put a pad file in here.
+		// Who thought this was a good idea?
+		fileDataOffset = uefi.Align(fileDataOffset+1, alignBase)
+		newOffset = fileDataOffset - hl
+	}
+	return newOffset
 }
 
 // Visit applies the Assemble visitor to any Firmware type.
 func (v *Assemble) Visit(f uefi.Firmware) error {
+	return v.visitContext(context.Background(), f)
+}
+
+// visitContext is Visit, stopping with the error of ctx once it is done.
+func (v *Assemble) visitContext(ctx context.Context, f uefi.Firmware) error {
 	var err error
 
+	if v.depth == 0 {
+		v.progress = newFileProgress(ctx, uefi.ProgressAssemble, f)
+		v.root = f
+	}
+	v.depth++
+	defer func() { v.depth-- }()
+	if _, ok := f.(*uefi.File); ok {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		defer v.progress.Step()
+	}
+
 	// Get the damn Erase Polarity
 	if f, ok := f.(*uefi.FirmwareVolume); ok {
 		// Set Erase Polarity
@@ -47,10 +190,18 @@ func (v *Assemble) Visit(f uefi.Firmware) error {
 
 	// We first assemble the children.
 	// Sounds horrible but has to be done =(
-	if err = f.ApplyChildren(v); err != nil {
+	if err = v.assembleChildren(ctx, f); err != nil {
 		return err
 	}
+	if err = v.assemble(f); err != nil {
+		return newNodeError(v.root, f, err)
+	}
+	return nil
+}
 
+// assemble assembles f from its assembled children.
+func (v *Assemble) assemble(f uefi.Firmware) error {
+	var err error
 	switch f := f.(type) {
 
 	case *uefi.FirmwareVolume:
@@ -70,12 +221,10 @@ func (v *Assemble) Visit(f uefi.Firmware) error {
 				f.Length, fBufLen)
 		}
 
+		// Start a new buffer with the header rather than appending to the
+		// old one, as the parsed files share it.
 		fileOffset := f.DataOffset
-		if f.DataOffset != fBufLen {
-			// remove all old file data
-			fBuf = fBuf[:f.DataOffset]
-			f.SetBuf(fBuf)
-		}
+		f.SetBuf(append(make([]byte, 0, f.Length), fBuf[:f.DataOffset]...))
 
 		for _, file := range f.Files {
 			fileBuf := file.Buf()
@@ -87,27 +236,14 @@ func (v *Assemble) Visit(f uefi.Firmware) error {
 			// Pad to the 8 byte alignments.
 			alignedOffset := uefi.Align8(fileOffset)
 			// Read out the file alignment requirements
-			if alignBase := file.Header.Attributes.GetAlignment(); alignBase != 1 {
-				hl := file.HeaderLen()
-				// We need to align the data, not the header. This is so terrible.
-				fileDataOffset := uefi.Align(alignedOffset+hl, alignBase)
-				// Calculate the starting offset of the file
-				newOffset := fileDataOffset - hl
-				if gap := (newOffset - alignedOffset); gap >= 8 && gap < uefi.FileHeaderMinLength {
-					// We need to re align to the next boundary cause we can't put a pad file in here.
-					// Who thought this was a good idea?
-					fileDataOffset = uefi.Align(fileDataOffset+1, alignBase)
-					newOffset = fileDataOffset - hl
+			if newOffset := alignFileOffset(alignedOffset, file); newOffset != alignedOffset {
+				// Add a pad file starting from alignedOffset to newOffset
+				pfile, err := uefi.CreatePadFile(newOffset - alignedOffset)
+				if err != nil {
+					return err
 				}
-				if newOffset != alignedOffset {
-					// Add a pad file starting from alignedOffset to newOffset
-					pfile, err := uefi.CreatePadFile(newOffset - alignedOffset)
-					if err != nil {
-						return err
-					}
-					if err = f.InsertFile(alignedOffset, pfile.Buf()); err != nil {
-						return fmt.Errorf("file %s: %v", pfile.Header.GUID, err)
-					}
+				if err = f.InsertFile(alignedOffset, pfile.Buf()); err != nil {
+					return fmt.Errorf("file %s: %v", pfile.Header.GUID, err)
 				}
 				alignedOffset = newOffset
 			}
@@ -320,6 +456,17 @@ func (v *Assemble) Visit(f uefi.Firmware) error {
 					return err
 				}
 			}
+		case uefi.SectionTypeCompression:
+			ts := f.TypeSpecific.Header.(*uefi.SectionCompression)
+			ts.UncompressedLength = uint32(len(secData))
+			if compressor := ts.Compressor(); compressor != nil {
+				fBuf, err := compressor.Encode(secData)
+				if err != nil {
+					return err
+				}
+				secData = fBuf
+			}
+			f.SetBuf(secData)
 		default:
 			f.SetBuf(secData)
 		}
@@ -376,7 +523,8 @@ func (v *Assemble) Visit(f uefi.Firmware) error {
 		// We only parse Descriptor, Region and Master, so regenerate only that and keep the rest of the buffer.
 		// We assume the location in the Flash Descriptor sector have not changed.
 		// TODO: verify the integrity of the Start offsets
-		fBuf := f.Buf()
+		// The descriptor is written to a copy, as it may share the image.
+		fBuf := append([]byte{}, f.Buf()...)
 		// Regenerate DescriptorMap
 		desc := new(bytes.Buffer)
 		err = binary.Write(desc, binary.LittleEndian, f.DescriptorMap)
@@ -5,6 +5,9 @@
 package visitors
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"testing"
 
@@ -46,3 +49,80 @@ func TestBadDepex(t *testing.T) {
 		})
 	}
 }
+
+func TestAssembleParallel(t *testing.T) {
+	serial := parseImage(t)
+	if err := (&Assemble{}).Run(serial); err != nil {
+		t.Fatal(err)
+	}
+	parallel := parseImage(t)
+	if err := (&Assemble{Parallel: true}).Run(parallel); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(serial.Buf(), parallel.Buf()) {
+		t.Errorf("parallel assembly differs from serial assembly")
+	}
+}
+
+func TestAssembleContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, parallel := range []bool{false, true} {
+		f := parseImage(t)
+		if err := (&Assemble{Parallel: parallel}).RunContext(ctx, f); !errors.Is(err, context.Canceled) {
+			t.Errorf("parallel=%v: got %v, want %v", parallel, err, context.Canceled)
+		}
+	}
+}
+
+func BenchmarkAssemble(b *testing.B) {
+	for _, parallel := range []bool{false, true} {
+		b.Run(fmt.Sprintf("parallel=%v", parallel), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				f := parseImage(b)
+				b.StartTimer()
+				if err := (&Assemble{Parallel: parallel}).Run(f); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestAssembleCompressionSection(t *testing.T) {
+	raw, err := uefi.CreateSection(uefi.SectionTypeRaw, bytes.Repeat([]byte("FIANO ROCKS! "), 100), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := raw.GenSecHeader(); err != nil {
+		t.Fatal(err)
+	}
+	for _, compression := range []string{"EFI", "TIANO"} {
+		t.Run(compression, func(t *testing.T) {
+			s, err := uefi.CreateSection(uefi.SectionTypeCompression, nil, []uefi.Firmware{raw}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.TypeSpecific.Header.(*uefi.SectionCompression).Compression = compression
+			if err := (&Assemble{}).Run(s); err != nil {
+				t.Fatal(err)
+			}
+			if len(s.Buf()) >= len(raw.Buf()) {
+				t.Errorf("got a section of %d bytes, want it compressed", len(s.Buf()))
+			}
+
+			got, err := uefi.NewSection(s.Buf(), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := got.TypeSpecific.Header.(*uefi.SectionCompression)
+			if h.Compression != compression || h.UncompressedLength != uint32(len(raw.Buf())) {
+				t.Errorf("got %+v, want %s compression of %d bytes", h, compression, len(raw.Buf()))
+			}
+			if len(got.Encapsulated) != 1 || !bytes.Equal(got.Encapsulated[0].Value.Buf(), raw.Buf()) {
+				t.Errorf("got %d encapsulated sections, want the raw section", len(got.Encapsulated))
+			}
+		})
+	}
+}
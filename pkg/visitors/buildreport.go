@@ -0,0 +1,40 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"fmt"
+
+	"github.com/linuxboot/fiano/pkg/buildreport"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// BuildReport holds the modules of the EDK2 build reports and FV maps of
+// the image. When set, table, find and diff show the source module of the
+// files.
+var BuildReport buildreport.Report
+
+// fileModule returns the build module of a file.
+func fileModule(f uefi.Firmware) (*buildreport.Module, bool) {
+	file, ok := f.(*uefi.File)
+	if !ok || BuildReport == nil {
+		return nil, false
+	}
+	m, ok := BuildReport[file.Header.GUID]
+	return m, ok
+}
+
+// moduleSource describes a module as its INF path and build-time base
+// address, such as "MdeModulePkg/Core/Dxe/DxeMain.inf @ 0x1000".
+func moduleSource(m *buildreport.Module) string {
+	s := m.INFPath
+	if s == "" {
+		s = m.Name
+	}
+	if m.BaseAddress != nil {
+		s += fmt.Sprintf(" @ %#x", *m.BaseAddress)
+	}
+	return s
+}
@@ -0,0 +1,112 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/buildreport"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// setTestBuildReport sets BuildReport to list DxeCore and Shell until the
+// test ends.
+func setTestBuildReport(t *testing.T, f uefi.Firmware) {
+	t.Helper()
+	pred, err := FindFilePredicate("Shell")
+	if err != nil {
+		t.Fatal(err)
+	}
+	find := &Find{Predicate: pred}
+	if err := find.Run(f); err != nil || len(find.Matches) != 1 {
+		t.Fatalf("Shell: %v, %d matches", err, len(find.Matches))
+	}
+	base := uint64(0x1000)
+	shell := find.Matches[0].(*uefi.File).Header.GUID
+	BuildReport = buildreport.Report{
+		*dxeCoreGUID: {GUID: *dxeCoreGUID, Name: "DxeCore", INFPath: "MdeModulePkg/Core/Dxe/DxeMain.inf", BaseAddress: &base},
+		shell:        {GUID: shell, Name: "Shell", INFPath: "ShellPkg/Application/Shell/Shell.inf"},
+	}
+	t.Cleanup(func() { BuildReport = nil })
+}
+
+func TestTableBuildReport(t *testing.T) {
+	f := parseImage(t)
+	setTestBuildReport(t, f)
+
+	var b bytes.Buffer
+	if err := (&Table{Format: "csv", Columns: []string{"guid", "inf", "base-address"}, Out: &b}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&b).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, r := range records[1:] {
+		if r[0] == dxeCoreGUID.String() {
+			found = true
+			if r[1] != "MdeModulePkg/Core/Dxe/DxeMain.inf" || r[2] != "0x1000" {
+				t.Errorf("DxeCore: got %v", r)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("DxeCore not found in CSV output")
+	}
+
+	b.Reset()
+	if err := (&Table{Format: "json", Out: &b}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `"INFPath": "MdeModulePkg/Core/Dxe/DxeMain.inf"`) || !strings.Contains(b.String(), `"BaseAddress": 4096`) {
+		t.Errorf("DxeCore module missing from JSON output")
+	}
+}
+
+func TestFindBuildReport(t *testing.T) {
+	f := parseImage(t)
+	setTestBuildReport(t, f)
+
+	pred, err := FindFileNamePredicate("Dxe/DxeMain.inf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	find := &Find{Predicate: pred}
+	if err := find.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(find.Matches) != 1 || find.Matches[0].(*uefi.File).Header.GUID != *dxeCoreGUID {
+		t.Errorf("got %d matches, want DxeCore", len(find.Matches))
+	}
+}
+
+func TestDiffBuildReport(t *testing.T) {
+	old := parseImage(t)
+	setTestBuildReport(t, old)
+	modified := parseImage(t)
+	pred, err := FindFilePredicate("Shell")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Remove{Predicate: pred}).Run(modified); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Assemble{}).Run(modified); err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	v := &Diff{Other: modified, W: &b}
+	if err := v.Run(old); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "(Shell, ShellPkg/Application/Shell/Shell.inf)") {
+		t.Errorf("Shell module missing from output:\n%s", b.String())
+	}
+}
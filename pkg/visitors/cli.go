@@ -8,12 +8,18 @@
 package visitors
 
 import (
+	"context"
 	"fmt"
 	"sort"
+	"sync"
 
 	"github.com/linuxboot/fiano/pkg/uefi"
 )
 
+// registryMu guards visitorRegistry, which LoadPlugin modifies at run time.
+// The visitors are looked up with lookupCLI.
+var registryMu sync.RWMutex
+
 var visitorRegistry = map[string]visitorEntry{}
 
 type visitorEntry struct {
@@ -31,6 +37,13 @@ const (
 // command line, it should have an init function which registers a
 // `createVisitor` function here.
 func RegisterCLI(name string, help string, numArgs int, createVisitor func([]string) (uefi.Visitor, error)) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registerCLI(name, help, numArgs, createVisitor)
+}
+
+// registerCLI is RegisterCLI with registryMu held.
+func registerCLI(name string, help string, numArgs int, createVisitor func([]string) (uefi.Visitor, error)) {
 	if _, ok := visitorRegistry[name]; ok {
 		panic(fmt.Sprintf("two visitors registered the same name: '%s'", name))
 	}
@@ -41,38 +54,118 @@ func RegisterCLI(name string, help string, numArgs int, createVisitor func([]str
 	}
 }
 
+// lookupCLI returns the visitor registered as name.
+func lookupCLI(name string) (visitorEntry, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	o, ok := visitorRegistry[name]
+	return o, ok
+}
+
 // ParseCLI constructs a list of visitors from the given CLI argument list.
 func ParseCLI(args []string) ([]uefi.Visitor, error) {
-	visitors := []uefi.Visitor{}
+	script, err := ParseScript(args)
+	if err != nil {
+		return []uefi.Visitor{}, err
+	}
+	return script.Visitors()
+}
+
+// ParseScript splits the given CLI argument list into commands.
+func ParseScript(args []string) (Script, error) {
+	script := Script{Commands: []ScriptCommand{}}
 	for len(args) > 0 {
 		cmd := args[0]
 		args = args[1:]
-		o, ok := visitorRegistry[cmd]
+		o, ok := lookupCLI(cmd)
 		if !ok {
-			return []uefi.Visitor{}, fmt.Errorf("could not find command '%s'\n%s", cmd, helpMessage)
+			return Script{}, fmt.Errorf("could not find command '%s'\n%s", cmd, helpMessage)
 		}
 		if o.numArgs > len(args) {
-			return []uefi.Visitor{}, fmt.Errorf("too few arguments for command '%s', got %d, expected %d.\nSynopsis: %s",
+			return Script{}, fmt.Errorf("too few arguments for command '%s', got %d, expected %d.\nSynopsis: %s",
 				cmd, len(args), o.numArgs, o.help)
 		}
-		visitor, err := o.createVisitor(args[:o.numArgs])
-		if err != nil {
-			return []uefi.Visitor{}, err
-		}
-		visitors = append(visitors, visitor)
+		script.Commands = append(script.Commands, ScriptCommand{
+			Name: cmd,
+			Args: args[:o.numArgs],
+		})
 		args = args[o.numArgs:]
 	}
-	return visitors, nil
+	return script, nil
 }
 
 // ExecuteCLI applies each Visitor over the firmware in sequence.
 func ExecuteCLI(f uefi.Firmware, v []uefi.Visitor) error {
+	return ExecuteCLIContext(context.Background(), f, v)
+}
+
+// ContextRunner is implemented by the visitors which can stop in the middle
+// of a long run, such as Assemble and Validate.
+type ContextRunner interface {
+	// RunContext is Run, stopping with the error of ctx once ctx is done.
+	RunContext(ctx context.Context, f uefi.Firmware) error
+}
+
+// RootReplacer is implemented by the visitors which may replace the whole
+// firmware, such as Plugin, rather than modify it in place.
+type RootReplacer interface {
+	// Root returns the firmware which replaced the one of the last run, or
+	// nil if it was kept.
+	Root() uefi.Firmware
+}
+
+// ExecuteCLIContext applies each Visitor over the firmware in sequence,
+// stopping with the error of ctx once ctx is done. The context is checked
+// between visitors, and during those which are a ContextRunner. None is
+// applied if one modifies a read-only firmware, see CheckWritable.
+func ExecuteCLIContext(ctx context.Context, f uefi.Firmware, v []uefi.Visitor) error {
+	_, err := ExecuteCLIRoot(ctx, f, v)
+	return err
+}
+
+// ExecuteCLIRoot is ExecuteCLIContext, returning the firmware once the
+// visitors ran: f, or the firmware a RootReplacer replaced it with, to which
+// the visitors which follow it are applied.
+func ExecuteCLIRoot(ctx context.Context, f uefi.Firmware, v []uefi.Visitor) (uefi.Firmware, error) {
+	if err := CheckWritable(f, v); err != nil {
+		return f, err
+	}
 	for i := range v {
-		if err := v[i].Run(f); err != nil {
-			return err
+		if err := ctx.Err(); err != nil {
+			return f, err
+		}
+		var err error
+		if r, ok := v[i].(ContextRunner); ok {
+			err = r.RunContext(ctx, f)
+		} else {
+			err = v[i].Run(f)
+		}
+		if err != nil {
+			return f, err
+		}
+		if r, ok := v[i].(RootReplacer); ok && r.Root() != nil {
+			f = r.Root()
 		}
 	}
-	return nil
+	return f, nil
+}
+
+// contextVisitor applies the visit function of a ContextRunner with the
+// context of its run, which is passed down the tree rather than stored in the
+// runner.
+type contextVisitor struct {
+	ctx   context.Context
+	visit func(ctx context.Context, f uefi.Firmware) error
+}
+
+// Run implements uefi.Visitor.
+func (v contextVisitor) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit implements uefi.Visitor.
+func (v contextVisitor) Visit(f uefi.Firmware) error {
+	return v.visit(v.ctx, f)
 }
 
 // ListCLI prints out the help entries in the visitor struct
@@ -80,6 +173,8 @@ func ExecuteCLI(f uefi.Firmware, v []uefi.Visitor) error {
 //
 //	name: help
 func ListCLI() string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
 	var s string
 	names := []string{}
 	for n := range visitorRegistry {
@@ -20,6 +20,9 @@ type Comment struct {
 
 // Run wraps Visit and performs some setup and teardown tasks.
 func (v *Comment) Run(f uefi.Firmware) error {
+	if structuredOutput() {
+		return writeStructured(v.W, struct{ Comment string }{v.s})
+	}
 	fmt.Fprintf(v.W, "%s\n", v.s)
 	return nil
 }
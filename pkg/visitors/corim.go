@@ -0,0 +1,268 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	amd_manifest "github.com/linuxboot/fiano/pkg/amd/manifest"
+	"github.com/linuxboot/fiano/pkg/amd/psb"
+	bytes2 "github.com/linuxboot/fiano/pkg/bytes"
+	"github.com/linuxboot/fiano/pkg/corim"
+	"github.com/linuxboot/fiano/pkg/guid"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/cbnt"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/fit"
+	"github.com/linuxboot/fiano/pkg/log"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// CoRIM exports the reference values of the image as a CoRIM, see package
+// corim. The reference values are:
+//   - the IBB digests of the BootGuard or CBnT boot policy manifest, and
+//     the digests of the key and boot policy manifests,
+//   - the digests of the AMD PSB RTM volume and OEM signing key entries,
+//   - the digests of the selected regions.
+type CoRIM struct {
+	// Input
+	// Path is the file the CBOR CoRIM is written to, or "-" for stdout.
+	Path string
+	// Model and Vendor describe the platform, Creator the entity creating
+	// the CoRIM.
+	Model   string
+	Vendor  string
+	Creator string
+	// Regions are flash regions, named as in the layouts of
+	// "flashrom --ifd" such as "bios", or OFFSET:LENGTH ranges of the
+	// image.
+	Regions []string
+
+	// Output
+	Manifest corim.Manifest
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *CoRIM) Run(f uefi.Firmware) error {
+	image := f.Buf()
+	imageHash := sha256.Sum256(image)
+	v.Manifest = corim.Manifest{
+		// The same image always gets the same CoRIM ID.
+		ID:           guid.NewV5(guid.NamespaceFiano, imageHash[:]),
+		Vendor:       v.Vendor,
+		Model:        v.Model,
+		Creator:      v.Creator,
+		SoftwareName: v.Model + " firmware",
+	}
+
+	ms, err := bootGuardReferenceValues(image)
+	if err != nil {
+		return fmt.Errorf("BootGuard: %w", err)
+	}
+	v.Manifest.Measurements = append(v.Manifest.Measurements, ms...)
+	if ms, err = psbReferenceValues(image); err != nil {
+		return fmt.Errorf("PSB: %w", err)
+	}
+	v.Manifest.Measurements = append(v.Manifest.Measurements, ms...)
+	for _, r := range v.Regions {
+		m, err := regionReferenceValue(f, r)
+		if err != nil {
+			return err
+		}
+		v.Manifest.Measurements = append(v.Manifest.Measurements, m)
+	}
+	if len(v.Manifest.Measurements) == 0 {
+		return errors.New("no BootGuard or PSB reference values in the image, select regions to measure")
+	}
+
+	b, err := v.Manifest.Marshal()
+	if err != nil {
+		return err
+	}
+	for _, m := range v.Manifest.Measurements {
+		log.With(log.F("name", m.Name)).Infof("reference value")
+	}
+	if v.Path == StdioPath {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	return writeFile(v.Path, b)
+}
+
+// Visit applies the CoRIM visitor to any Firmware type.
+func (v *CoRIM) Visit(f uefi.Firmware) error {
+	return nil
+}
+
+// newReferenceValue returns the SHA-256 and SHA-384 digests of data.
+func newReferenceValue(name string, data []byte) corim.Measurement {
+	h256 := sha256.Sum256(data)
+	h384 := sha512.Sum384(data)
+	return corim.Measurement{
+		Name: name,
+		Size: uint64(len(data)),
+		Digests: []corim.Digest{
+			{Algorithm: corim.SHA256, Value: h256[:]},
+			{Algorithm: corim.SHA384, Value: h384[:]},
+		},
+	}
+}
+
+// tpmDigestAlgorithm returns the CoRIM algorithm of a digest of the
+// BootGuard and CBnT manifests, which use TPM algorithm IDs.
+func tpmDigestAlgorithm(id uint16) (int, bool) {
+	switch cbnt.Algorithm(id) {
+	case cbnt.AlgSHA256:
+		return corim.SHA256, true
+	case cbnt.AlgSHA384:
+		return corim.SHA384, true
+	case cbnt.AlgSHA512:
+		return corim.SHA512, true
+	}
+	return 0, false
+}
+
+// bootGuardReferenceValues returns the digests of the key and boot policy
+// manifests of the FIT and the IBB digests the boot policy manifest holds.
+func bootGuardReferenceValues(image []byte) ([]corim.Measurement, error) {
+	table, err := fit.GetTable(image)
+	if err != nil {
+		return nil, nil
+	}
+	var ms []corim.Measurement
+	for _, e := range []struct {
+		name string
+		typ  fit.EntryType
+	}{
+		{"bootguard-key-manifest", fit.EntryTypeKeyManifestRecord},
+		{"bootguard-boot-policy-manifest", fit.EntryTypeBootPolicyManifest},
+	} {
+		if hdr := table.First(e.typ); hdr != nil {
+			ms = append(ms, newReferenceValue(e.name, hdr.GetEntry(image).GetEntryBase().DataSegmentBytes))
+		}
+	}
+	if table.First(fit.EntryTypeBootPolicyManifest) == nil {
+		return ms, nil
+	}
+
+	bgBPM, cbntBPM, err := table.ParseBootPolicyManifest(image)
+	if err != nil {
+		return nil, fmt.Errorf("boot policy manifest: %w", err)
+	}
+	type digest struct {
+		alg uint16
+		buf []byte
+	}
+	var digests []digest
+	switch {
+	case bgBPM != nil && len(bgBPM.SE) != 0:
+		digests = append(digests, digest{uint16(bgBPM.SE[0].Digest.HashAlg), bgBPM.SE[0].Digest.HashBuffer})
+	case cbntBPM != nil && len(cbntBPM.SE) != 0:
+		for _, d := range cbntBPM.SE[0].DigestList.List {
+			digests = append(digests, digest{uint16(d.HashAlg), d.HashBuffer})
+		}
+	default:
+		return nil, errors.New("boot policy manifest: no IBB element")
+	}
+	ibb := corim.Measurement{Name: "bootguard-ibb"}
+	for _, d := range digests {
+		alg, ok := tpmDigestAlgorithm(d.alg)
+		if !ok {
+			log.With(log.F("algorithm", fmt.Sprintf("%#x", d.alg))).Warnf("skipping the IBB digest, which has no CoRIM algorithm")
+			continue
+		}
+		ibb.Digests = append(ibb.Digests, corim.Digest{Algorithm: alg, Value: d.buf})
+	}
+	if len(ibb.Digests) != 0 {
+		ms = append(ms, ibb)
+	}
+	return ms, nil
+}
+
+// psbReferenceValues returns the digests of the RTM volume and of the OEM
+// signing key, when PSB is enabled.
+func psbReferenceValues(image []byte) ([]corim.Measurement, error) {
+	if _, _, err := amd_manifest.FindEmbeddedFirmwareStructure(amd_manifest.FirmwareImage(image)); err != nil {
+		return nil, nil
+	}
+	amdFw, err := psb.ParseAMDFirmware(image)
+	if err != nil {
+		return nil, err
+	}
+	enabled, err := psb.IsPSBEnabled(amdFw)
+	if err != nil || !enabled {
+		return nil, err
+	}
+	level := uint(1)
+	if amdFw.PSPFirmware().BIOSDirectoryLevel2 != nil {
+		level = 2
+	}
+	var ms []corim.Measurement
+	for _, e := range []struct {
+		name string
+		typ  amd_manifest.BIOSDirectoryTableEntryType
+	}{
+		{"psb-rtm-volume", psb.BIOSRTMVolumeEntry},
+		{"psb-oem-signing-key", psb.OEMSigningKeyEntry},
+	} {
+		data, err := psb.ExtractBIOSEntry(amdFw, level, e.typ, 0)
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, newReferenceValue(e.name, data))
+	}
+	return ms, nil
+}
+
+// regionReferenceValue returns the digests of a flash region named as in
+// the layouts of "flashrom --ifd", or of an OFFSET:LENGTH range.
+func regionReferenceValue(f uefi.Firmware, region string) (corim.Measurement, error) {
+	if strings.Contains(region, ":") {
+		ranges, err := parseRanges(region)
+		if err != nil {
+			return corim.Measurement{}, err
+		}
+		r := ranges[0]
+		if r.End() > uint64(len(f.Buf())) || r.End() < r.Offset {
+			return corim.Measurement{}, fmt.Errorf("range %#x:%#x is outside of the image (%#x bytes)", r.Offset, r.Length, len(f.Buf()))
+		}
+		return newReferenceValue(fmt.Sprintf("range %#x:%#x", r.Offset, r.Length), bytes2.Ranges{r}.Compile(f.Buf())), nil
+	}
+	fi, ok := f.(*uefi.FlashImage)
+	if !ok {
+		return corim.Measurement{}, fmt.Errorf("region %q: a %T has no flash descriptor regions, only a flash image", region, f)
+	}
+	if region == "fd" {
+		return newReferenceValue(region, fi.Buf()[:uefi.FlashDescriptorLength]), nil
+	}
+	for _, t := range fi.Regions {
+		r, ok := t.Value.(uefi.Region)
+		if ok && r.Type() != uefi.RegionTypeUnknown && flashromRegionName(r.Type()) == region {
+			return newReferenceValue(region, r.Buf()), nil
+		}
+	}
+	return corim.Measurement{}, fmt.Errorf("no %q region in the image", region)
+}
+
+func init() {
+	RegisterCLI("corim", "corim PATH MODEL\n write the BootGuard and AMD PSB reference values of the image as a CoRIM for the platform MODEL", 2, func(args []string) (uefi.Visitor, error) {
+		return &CoRIM{
+			Path:    args[0],
+			Model:   args[1],
+			Creator: "fiano",
+		}, nil
+	})
+	RegisterCLI("corim-regions", "corim-regions PATH MODEL REGIONS\n write a CoRIM as corim, adding the comma-separated flash regions such as bios or OFFSET:LENGTH ranges", 3, func(args []string) (uefi.Visitor, error) {
+		return &CoRIM{
+			Path:    args[0],
+			Model:   args[1],
+			Creator: "fiano",
+			Regions: strings.Split(args[2], ","),
+		}, nil
+	})
+}
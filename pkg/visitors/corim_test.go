@@ -0,0 +1,71 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/corim"
+)
+
+func TestCoRIM(t *testing.T) {
+	f := parseImage(t)
+	path := filepath.Join(t.TempDir(), "ovmf.corim")
+
+	// OVMF has neither BootGuard nor PSB.
+	if err := (&CoRIM{Path: path, Model: "OVMF", Creator: "fiano"}).Run(f); err == nil {
+		t.Errorf("expected an error without reference values")
+	}
+
+	v := &CoRIM{Path: path, Model: "OVMF", Creator: "fiano", Regions: []string{"0x0:0x1000"}}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	ms := v.Manifest.Measurements
+	sum := sha256.Sum256(f.Buf()[:0x1000])
+	if len(ms) != 1 || ms[0].Name != "range 0x0:0x1000" || ms[0].Digests[0].Algorithm != corim.SHA256 || !bytes.Equal(ms[0].Digests[0].Value, sum[:]) {
+		t.Errorf("got measurements %+v", ms)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := v.Manifest.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, want) {
+		t.Errorf("the file does not hold the CoRIM")
+	}
+
+	if err := (&CoRIM{Path: path, Model: "OVMF", Creator: "fiano", Regions: []string{"bios"}}).Run(f); err == nil {
+		t.Errorf("expected an error for a region of an image without flash descriptor")
+	}
+}
+
+func TestCoRIMRegions(t *testing.T) {
+	image, f := parseTwoChipImage(t)
+	v := &CoRIM{Path: filepath.Join(t.TempDir(), "corim"), Model: "test", Creator: "fiano", Regions: []string{"fd", "bios"}}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	ms := v.Manifest.Measurements
+	if len(ms) != 2 || ms[0].Name != "fd" || ms[1].Name != "bios" {
+		t.Fatalf("got measurements %+v", ms)
+	}
+	sum := sha256.Sum256(image[testChipSize:])
+	if ms[1].Size != testChipSize || !bytes.Equal(ms[1].Digests[0].Value, sum[:]) {
+		t.Errorf("bios: got %+v, want the second chip", ms[1])
+	}
+
+	v.Regions = []string{"me"}
+	if err := v.Run(f); err == nil {
+		t.Errorf("expected an error for a missing region")
+	}
+}
@@ -5,7 +5,7 @@
 package visitors
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -36,12 +36,7 @@ func (v *Count) Run(f uefi.Firmware) error {
 	}
 
 	if v.W != nil {
-		b, err := json.MarshalIndent(v, "", "\t")
-		if err != nil {
-			return err
-		}
-		_, err = fmt.Fprintln(v.W, string(b))
-		return err
+		return writeStructured(v.W, v)
 	}
 	return nil
 }
@@ -66,6 +61,19 @@ func (v *Count) Visit(f uefi.Firmware) error {
 	return f.ApplyChildren(v)
 }
 
+// newFileProgress returns a counter of the files of f for the phase of an
+// operation of ctx, or nil when its progress is not reported.
+func newFileProgress(ctx context.Context, phase string, f uefi.Firmware) *uefi.Progress {
+	if !uefi.ProgressEnabled(ctx) {
+		return nil
+	}
+	c := &Count{}
+	if err := c.Run(f); err != nil {
+		return uefi.NewProgress(ctx, phase, 0)
+	}
+	return uefi.NewProgress(ctx, phase, uint64(c.FirmwareTypeCount["File"]))
+}
+
 func init() {
 	RegisterCLI("count", "count the number of each firmware type", 0, func(args []string) (uefi.Visitor, error) {
 		return &Count{
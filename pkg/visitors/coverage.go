@@ -0,0 +1,182 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	amd_manifest "github.com/linuxboot/fiano/pkg/amd/manifest"
+	"github.com/linuxboot/fiano/pkg/amd/psb"
+	bytes2 "github.com/linuxboot/fiano/pkg/bytes"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/fit"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// CoverageSource is the part of the image protected by one mechanism.
+type CoverageSource struct {
+	// Name is "IBB" for the BootGuard or CBnT initial boot block and
+	// "RTM" for the AMD PSB RTM volume and BIOS directories.
+	Name   string
+	Ranges bytes2.Ranges
+	Bytes  uint64
+}
+
+// CoverageReport tells which bytes of an image are protected by a
+// signature or a hash verified at boot, and which are not.
+type CoverageReport struct {
+	Size        uint64
+	Sources     []CoverageSource
+	Protected   bytes2.Ranges
+	Unprotected bytes2.Ranges
+	// ProtectedBytes and UnprotectedBytes add up to Size.
+	ProtectedBytes   uint64
+	UnprotectedBytes uint64
+}
+
+// newCoverageReport returns the coverage of an image of size bytes by the
+// ranges of sources, which are clipped to the image.
+func newCoverageReport(size uint64, sources []CoverageSource) CoverageReport {
+	image := bytes2.Range{Length: size}
+	r := CoverageReport{Size: size, Sources: sources}
+	var protected bytes2.RangeSet
+	for i, s := range sources {
+		set := bytes2.NewRangeSet(s.Ranges...).Intersect(bytes2.NewRangeSet(image))
+		r.Sources[i].Ranges, r.Sources[i].Bytes = set.Ranges(), set.Len()
+		protected = protected.Union(set)
+	}
+	unprotected := protected.Gaps(image)
+	r.Protected, r.ProtectedBytes = protected.Ranges(), protected.Len()
+	r.Unprotected, r.UnprotectedBytes = unprotected.Ranges(), unprotected.Len()
+	return r
+}
+
+// Coverage reports which bytes of the image are protected by the BootGuard
+// or CBnT IBB and by the AMD PSB RTM signature, and which are not.
+type Coverage struct {
+	// The report is written to this writer.
+	W io.Writer
+
+	// Output
+	Report CoverageReport
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Coverage) Run(f uefi.Firmware) error {
+	image := f.Buf()
+	var sources []CoverageSource
+	for _, get := range []func([]byte) (*CoverageSource, error){ibbCoverage, rtmCoverage} {
+		s, err := get(image)
+		if err != nil {
+			return err
+		}
+		if s != nil {
+			sources = append(sources, *s)
+		}
+	}
+	v.Report = newCoverageReport(uint64(len(image)), sources)
+
+	if v.W == nil {
+		return nil
+	}
+	if structuredOutput() {
+		return writeStructured(v.W, v.Report)
+	}
+	return writeCoverageReport(v.W, f, v.Report)
+}
+
+// Visit applies the Coverage visitor to any Firmware type.
+func (v *Coverage) Visit(f uefi.Firmware) error {
+	return nil
+}
+
+// writeCoverageReport writes the report in text, locating the unprotected
+// ranges in the flash chips of root.
+func writeCoverageReport(w io.Writer, root uefi.Firmware, r CoverageReport) error {
+	if len(r.Sources) == 0 {
+		fmt.Fprintln(w, "no BootGuard IBB nor PSB RTM found, nothing is protected")
+	}
+	for _, s := range r.Sources {
+		fmt.Fprintf(w, "%-6s %#x bytes in %d ranges\n", s.Name, s.Bytes, len(s.Ranges))
+	}
+	fmt.Fprintf(w, "protected   %#x of %#x bytes (%.1f%%)\n", r.ProtectedBytes, r.Size, percent(r.ProtectedBytes, r.Size))
+	fmt.Fprintf(w, "unprotected %#x of %#x bytes (%.1f%%)\n", r.UnprotectedBytes, r.Size, percent(r.UnprotectedBytes, r.Size))
+	for _, u := range r.Unprotected {
+		fmt.Fprintf(w, "\t%08x:%08x", u.Offset, u.End()-1)
+		if c := uefi.LocateChip(root, u.Offset); c != nil {
+			fmt.Fprintf(w, " (%v)", c)
+		}
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func percent(n, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(total)
+}
+
+// ibbCoverage returns the IBB segments hashed by the BootGuard or CBnT boot
+// policy manifest, or nil if the FIT has none.
+func ibbCoverage(image []byte) (*CoverageSource, error) {
+	table, err := fit.GetTable(image)
+	if err != nil || table.First(fit.EntryTypeBootPolicyManifest) == nil {
+		return nil, nil
+	}
+	bgBPM, cbntBPM, err := table.ParseBootPolicyManifest(image)
+	if err != nil {
+		return nil, fmt.Errorf("boot policy manifest: %w", err)
+	}
+	size := uint64(len(image))
+	var ranges bytes2.Ranges
+	switch {
+	case bgBPM != nil && len(bgBPM.SE) != 0:
+		ranges = bgBPM.IBBDataRanges(size)
+	case cbntBPM != nil && len(cbntBPM.SE) != 0:
+		ranges = cbntBPM.IBBDataRanges(size)
+	default:
+		return nil, errors.New("boot policy manifest: no IBB element")
+	}
+	return &CoverageSource{Name: "IBB", Ranges: ranges}, nil
+}
+
+// rtmCoverage returns the ranges signed by the AMD PSB RTM signature, or nil
+// if PSB is not enabled.
+func rtmCoverage(image []byte) (*CoverageSource, error) {
+	if _, _, err := amd_manifest.FindEmbeddedFirmwareStructure(amd_manifest.FirmwareImage(image)); err != nil {
+		return nil, nil
+	}
+	amdFw, err := psb.ParseAMDFirmware(image)
+	if err != nil {
+		return nil, err
+	}
+	enabled, err := psb.IsPSBEnabled(amdFw)
+	if err != nil || !enabled {
+		return nil, err
+	}
+	level := uint(1)
+	if amdFw.PSPFirmware().BIOSDirectoryLevel2 != nil {
+		level = 2
+	}
+	ranges, err := psb.RTMRanges(amdFw, level)
+	if err != nil {
+		return nil, fmt.Errorf("RTM volume: %w", err)
+	}
+	return &CoverageSource{Name: "RTM", Ranges: ranges}, nil
+}
+
+func init() {
+	RegisterCLI("coverage", "report which bytes of the image are protected by the BootGuard IBB and the AMD PSB RTM signature, and which are not", 0, func(args []string) (uefi.Visitor, error) {
+		return &Coverage{
+			W: os.Stdout,
+		}, nil
+	})
+}
@@ -0,0 +1,53 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	bytes2 "github.com/linuxboot/fiano/pkg/bytes"
+)
+
+func TestCoverage(t *testing.T) {
+	f := parseImage(t)
+
+	var out bytes.Buffer
+	v := &Coverage{W: &out}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	// OVMF has neither BootGuard nor PSB.
+	size := uint64(len(f.Buf()))
+	want := bytes2.Ranges{{Length: size}}
+	if len(v.Report.Sources) != 0 || v.Report.ProtectedBytes != 0 || !reflect.DeepEqual(v.Report.Unprotected, want) {
+		t.Errorf("got %+v, want everything unprotected", v.Report)
+	}
+	if !strings.Contains(out.String(), "nothing is protected") {
+		t.Errorf("unexpected report:\n%s", out.String())
+	}
+}
+
+func TestCoverageReport(t *testing.T) {
+	r := newCoverageReport(0x1000, []CoverageSource{
+		{Name: "IBB", Ranges: bytes2.Ranges{{Offset: 0xe00, Length: 0x200}, {Offset: 0x800, Length: 0x100}}},
+		// The RTM overlaps the IBB and goes past the end of the image.
+		{Name: "RTM", Ranges: bytes2.Ranges{{Offset: 0x880, Length: 0x100}, {Offset: 0xf00, Length: 0x200}}},
+	})
+	if got, want := r.Sources[1].Ranges, (bytes2.Ranges{{Offset: 0x880, Length: 0x100}, {Offset: 0xf00, Length: 0x100}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("RTM ranges: got %v, want %v", got, want)
+	}
+	if got, want := r.Protected, (bytes2.Ranges{{Offset: 0x800, Length: 0x180}, {Offset: 0xe00, Length: 0x200}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("protected: got %v, want %v", got, want)
+	}
+	if got, want := r.Unprotected, (bytes2.Ranges{{Length: 0x800}, {Offset: 0x980, Length: 0x480}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("unprotected: got %v, want %v", got, want)
+	}
+	if r.ProtectedBytes != 0x380 || r.ProtectedBytes+r.UnprotectedBytes != r.Size {
+		t.Errorf("got %#x protected and %#x unprotected bytes of %#x", r.ProtectedBytes, r.UnprotectedBytes, r.Size)
+	}
+}
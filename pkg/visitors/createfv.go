@@ -24,8 +24,16 @@ type CreateFV struct {
 	found bool
 }
 
+// Mutates implements Mutator.
+func (v *CreateFV) Mutates() bool {
+	return true
+}
+
 // Run wraps Visit and performs some setup and teardown tasks.
 func (v *CreateFV) Run(f uefi.Firmware) error {
+	if err := writable(f); err != nil {
+		return err
+	}
 	err := f.Apply(v)
 	if err != nil {
 		return err
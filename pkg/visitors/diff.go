@@ -0,0 +1,247 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// DiffEntry is a difference between two images.
+type DiffEntry struct {
+	// Change is "added", "removed", "changed" or "moved". Moved nodes have
+	// the same contents at another offset.
+	Change string
+	// Kind is "region", "fv" or "file".
+	Kind string
+	// Path identifies the node in the tree, such as
+	// "BIOS/FV:8C8CE578-8A3D-4F1C-9935-896185C32DD3/File:D6A2CB7F-6A18-4E2F-B43B-9920A733700A".
+	Path    string
+	Name    string `json:",omitempty"`
+	OldSize uint64
+	NewSize uint64
+	Delta   int64
+	// Offsets of the node: regions from the start of the flash image,
+	// firmware volumes from the start of the BIOS region and files from the
+	// start of their volume.
+	OldOffset uint64
+	NewOffset uint64
+	// INFPath is the source module of a file, from BuildReport.
+	INFPath string `json:",omitempty"`
+}
+
+// Diff compares the image with another one. Regions, firmware volumes and
+// files are matched by their type, name or GUID rather than by their
+// offset, so recompressed images can be compared.
+type Diff struct {
+	// Input
+	// Other is the new image, the visited image is the old one.
+	Other uefi.Firmware
+
+	// The differences are written to this writer.
+	W io.Writer
+
+	// Output
+	Entries []DiffEntry
+}
+
+// diffNode is a node of the image indexed for comparison.
+type diffNode struct {
+	kind   string
+	path   string
+	name   string
+	inf    string
+	size   uint64
+	offset uint64
+	sum    [sha256.Size]byte
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Diff) Run(f uefi.Firmware) error {
+	oldIdx := &diffIndex{}
+	if err := oldIdx.Run(f); err != nil {
+		return err
+	}
+	newIdx := &diffIndex{}
+	if err := newIdx.Run(v.Other); err != nil {
+		return err
+	}
+
+	oldNodes := map[string]*diffNode{}
+	for _, n := range oldIdx.nodes {
+		oldNodes[n.path] = n
+	}
+	newNodes := map[string]*diffNode{}
+	for _, n := range newIdx.nodes {
+		newNodes[n.path] = n
+	}
+
+	v.Entries = []DiffEntry{}
+	for _, o := range oldIdx.nodes {
+		if _, ok := newNodes[o.path]; !ok {
+			v.Entries = append(v.Entries, newDiffEntry("removed", o, nil))
+		}
+	}
+	for _, n := range newIdx.nodes {
+		o, ok := oldNodes[n.path]
+		switch {
+		case !ok:
+			v.Entries = append(v.Entries, newDiffEntry("added", nil, n))
+		case o.sum != n.sum:
+			v.Entries = append(v.Entries, newDiffEntry("changed", o, n))
+		case o.offset != n.offset:
+			v.Entries = append(v.Entries, newDiffEntry("moved", o, n))
+		}
+	}
+
+	if v.W == nil {
+		return nil
+	}
+	if structuredOutput() {
+		return writeStructured(v.W, v.Entries)
+	}
+	for _, e := range v.Entries {
+		name := e.Path
+		switch {
+		case e.Name != "" && e.INFPath != "":
+			name = fmt.Sprintf("%s (%s, %s)", e.Path, e.Name, e.INFPath)
+		case e.Name != "":
+			name = fmt.Sprintf("%s (%s)", e.Path, e.Name)
+		}
+		fmt.Fprintf(v.W, "%-8s %-6s %s: size %#x -> %#x (%+d), offset %#x -> %#x\n", e.Change, e.Kind, name, e.OldSize, e.NewSize, e.Delta, e.OldOffset, e.NewOffset)
+	}
+	return nil
+}
+
+// Visit applies the Diff visitor to any Firmware type.
+func (v *Diff) Visit(f uefi.Firmware) error {
+	return nil
+}
+
+func newDiffEntry(change string, o, n *diffNode) DiffEntry {
+	e := DiffEntry{Change: change}
+	for _, d := range []*diffNode{o, n} {
+		if d != nil {
+			e.Kind, e.Path, e.Name, e.INFPath = d.kind, d.path, d.name, d.inf
+		}
+	}
+	if o != nil {
+		e.OldSize, e.OldOffset = o.size, o.offset
+	}
+	if n != nil {
+		e.NewSize, e.NewOffset = n.size, n.offset
+	}
+	e.Delta = int64(e.NewSize) - int64(e.OldSize)
+	return e
+}
+
+// diffIndex lists the regions, firmware volumes and files of an image, in
+// tree order, keyed by their path. The file offsets are only right for a
+// freshly parsed image, as assembling may insert pad files in the buffer of
+// a volume without adding them to its files.
+type diffIndex struct {
+	nodes []*diffNode
+
+	path        string
+	count       map[string]int
+	fileOffsets map[*uefi.File]uint64
+}
+
+func (v *diffIndex) Run(f uefi.Firmware) error {
+	v.count = map[string]int{}
+	v.fileOffsets = map[*uefi.File]uint64{}
+	return f.Apply(v)
+}
+
+func (v *diffIndex) Visit(f uefi.Firmware) error {
+	var kind, key, name, inf string
+	var offset uint64
+	if r, ok := f.(uefi.Region); ok && r.FlashRegion() != nil {
+		offset = uint64(r.FlashRegion().BaseOffset())
+	}
+	switch f := f.(type) {
+	case *uefi.FlashDescriptor:
+		kind, key = "region", "IFD"
+	case *uefi.BIOSRegion:
+		kind, key = "region", "BIOS"
+	case *uefi.MERegion:
+		kind, key = "region", "ME"
+	case *uefi.RawRegion:
+		kind, key = "region", f.Type().String()
+	case *uefi.FirmwareVolume:
+		kind, key = "fv", "FV:"+f.FileSystemGUID.String()
+		if f.ExtHeaderOffset != 0 {
+			key = "FV:" + f.FVName.String()
+		}
+		offset = f.FVOffset
+		fileOffset := f.DataOffset
+		for _, file := range f.Files {
+			fileOffset = uefi.Align8(fileOffset)
+			v.fileOffsets[file] = fileOffset
+			fileOffset += uint64(len(file.Buf()))
+		}
+	case *uefi.File:
+		if f.Header.Type == uefi.FVFileTypePad {
+			return nil
+		}
+		kind, key, name = "file", "File:"+f.Header.GUID.String(), FileUIName(f)
+		if m, ok := fileModule(f); ok {
+			if name == "" {
+				name = m.Name
+			}
+			inf = m.INFPath
+		}
+		offset = v.fileOffsets[f]
+	default:
+		return f.ApplyChildren(v)
+	}
+
+	path := key
+	if v.path != "" {
+		path = v.path + "/" + key
+	}
+	// Tell apart nodes with the same key in the same parent.
+	if n := v.count[path]; n > 0 {
+		v.count[path]++
+		path = fmt.Sprintf("%s#%d", path, n)
+	} else {
+		v.count[path] = 1
+	}
+	v.nodes = append(v.nodes, &diffNode{
+		kind:   kind,
+		path:   path,
+		name:   name,
+		inf:    inf,
+		size:   uint64(len(f.Buf())),
+		offset: offset,
+		sum:    sha256.Sum256(f.Buf()),
+	})
+
+	prev := v.path
+	v.path = path
+	defer func() { v.path = prev }()
+	return f.ApplyChildren(v)
+}
+
+func init() {
+	RegisterCLI("diff", "compare the image with the given image file, listing the added, removed and changed regions, volumes and files", 1, func(args []string) (uefi.Visitor, error) {
+		image, err := os.ReadFile(args[0])
+		if err != nil {
+			return nil, err
+		}
+		other, err := uefi.Parse(image)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", args[0], err)
+		}
+		return &Diff{
+			Other: other,
+			W:     os.Stdout,
+		}, nil
+	})
+}
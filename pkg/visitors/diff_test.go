@@ -0,0 +1,70 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestDiff(t *testing.T) {
+	old := parseImage(t)
+	modified := parseImage(t)
+	pred, err := FindFilePredicate("Shell")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Remove{Predicate: pred}).Run(modified); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Assemble{}).Run(modified); err != nil {
+		t.Fatal(err)
+	}
+	// Diff against a freshly parsed image, as the command line does.
+	other, err := uefi.Parse(modified.Buf())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	v := &Diff{Other: other, W: &b}
+	if err := v.Run(old); err != nil {
+		t.Fatal(err)
+	}
+	var removed, changedFV bool
+	for _, e := range v.Entries {
+		switch {
+		case e.Change == "removed" && e.Kind == "file" && e.Name == "Shell":
+			removed = true
+			if e.Delta != -int64(e.OldSize) {
+				t.Errorf("unexpected delta %d for %+v", e.Delta, e)
+			}
+		case e.Change == "changed" && e.Kind == "fv":
+			changedFV = true
+		case e.Change == "added":
+			t.Errorf("unexpected added entry %+v", e)
+		case e.Change == "removed":
+			t.Errorf("unexpected removed entry %+v", e)
+		}
+	}
+	if !removed || !changedFV {
+		t.Errorf("expected the Shell removal and a changed volume, got %+v", v.Entries)
+	}
+	if !strings.Contains(b.String(), "(Shell)") {
+		t.Errorf("Shell missing from output:\n%s", b.String())
+	}
+
+	// An image does not differ from itself.
+	same := &Diff{Other: parseImage(t)}
+	if err := same.Run(parseImage(t)); err != nil {
+		t.Fatal(err)
+	}
+	if len(same.Entries) != 0 {
+		t.Errorf("expected no differences, got %+v", same.Entries)
+	}
+}
@@ -0,0 +1,493 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+	"github.com/linuxboot/fiano/pkg/knownguids"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// Dispatch statuses of the DXE drivers.
+const (
+	DispatchDispatched = "dispatched"
+	// DispatchOnRequest drivers have a SOR DEPEX and are only dispatched
+	// once a driver schedules them.
+	DispatchOnRequest   = "schedule-on-request"
+	DispatchUnsatisfied = "unsatisfied"
+)
+
+// archProtocols are the architectural protocols which the DXE core waits for,
+// and which a driver without DEPEX depends on.
+var archProtocols = map[guid.GUID]string{
+	*guid.MustParse("A46423E3-4617-49F1-B9FF-D1BFA9115839"): "gEfiSecurityArchProtocolGuid",
+	*guid.MustParse("26BACCB1-6F42-11D4-BCE7-0080C73C8881"): "gEfiCpuArchProtocolGuid",
+	*guid.MustParse("26BACCB2-6F42-11D4-BCE7-0080C73C8881"): "gEfiMetronomeArchProtocolGuid",
+	*guid.MustParse("26BACCB3-6F42-11D4-BCE7-0080C73C8881"): "gEfiTimerArchProtocolGuid",
+	*guid.MustParse("665E3FF6-46CC-11D4-9A38-0090273FC14D"): "gEfiBdsArchProtocolGuid",
+	*guid.MustParse("665E3FF5-46CC-11D4-9A38-0090273FC14D"): "gEfiWatchdogTimerArchProtocolGuid",
+	*guid.MustParse("B7DFB4E1-052F-449F-87BE-9818FC91B733"): "gEfiRuntimeArchProtocolGuid",
+	*guid.MustParse("1E5668E2-8481-11D4-BCF1-0080C73C8881"): "gEfiVariableArchProtocolGuid",
+	*guid.MustParse("6441F818-6362-4E44-B570-7DBA31DD2453"): "gEfiVariableWriteArchProtocolGuid",
+	*guid.MustParse("5053697E-2CBC-4819-90D9-0580DEEE5754"): "gEfiCapsuleArchProtocolGuid",
+	*guid.MustParse("1DA97072-BDDC-4B30-99F1-72A0B56FFF2A"): "gEfiMonotonicCounterArchProtocolGuid",
+	*guid.MustParse("27CFAC88-46CC-11D4-9A38-0090273FC14D"): "gEfiResetArchProtocolGuid",
+	*guid.MustParse("27CFAC87-46CC-11D4-9A38-0090273FC14D"): "gEfiRealTimeClockArchProtocolGuid",
+}
+
+// protocolName returns the name of a protocol GUID, or the GUID if it has no
+// known name.
+func protocolName(g guid.GUID) string {
+	if name, ok := archProtocols[g]; ok {
+		return name
+	}
+	if name, ok := knownguids.Name(g); ok {
+		return name
+	}
+	return g.String()
+}
+
+// DispatchEntry is a DXE driver in the estimated dispatch order.
+type DispatchEntry struct {
+	GUID guid.GUID
+	Name string `json:",omitempty"`
+	// DepEx is the DEPEX of the driver in infix notation, empty if the
+	// driver has none.
+	DepEx  string `json:",omitempty"`
+	Status string
+	// Missing are the protocols of the DEPEX which were not installed
+	// when the dispatch stopped, for the drivers which were not dispatched.
+	Missing []string `json:",omitempty"`
+	// Error tells why the DEPEX could not be evaluated.
+	Error string `json:",omitempty"`
+}
+
+// DispatchOrder estimates the order in which the DXE dispatcher starts the
+// drivers of the image, by evaluating their DEPEX as the dispatcher does,
+// and flags the drivers whose DEPEX is never satisfied, such as after a
+// driver they depend on was removed.
+//
+// The protocols which a driver installs are not declared in the image, so
+// they are estimated: a driver is assumed to install the protocols of the
+// DEPEX of the other drivers whose GUID appears in its executable, unless
+// its own DEPEX requires them. This may dispatch a driver earlier than the
+// firmware does, but a driver whose protocols no driver of the image refers
+// to is reliably found unsatisfied.
+type DispatchOrder struct {
+	// The order is written to this writer.
+	W io.Writer
+
+	// Output
+	// Dispatched are the drivers in their estimated dispatch order, the
+	// DXE core first.
+	Dispatched []DispatchEntry
+	// NotDispatched are the other drivers, in the order of the image.
+	NotDispatched []DispatchEntry
+	// Uninstalled are the protocols missing for the drivers which were
+	// not dispatched, which no driver of the image is assumed to install.
+	// They are the likely cause of the other missing protocols.
+	Uninstalled []string
+}
+
+// dxeDriver is a driver of the image with its DEPEX and the protocols it
+// is assumed to install.
+type dxeDriver struct {
+	file     *uefi.File
+	entry    DispatchEntry
+	depEx    []uefi.DepExOp
+	installs []guid.GUID
+	done     bool
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *DispatchOrder) Run(f uefi.Firmware) error {
+	find := &Find{Predicate: func(f uefi.Firmware) bool {
+		file, ok := f.(*uefi.File)
+		if !ok {
+			return false
+		}
+		switch file.Header.Type {
+		case uefi.FVFileTypeDXECore, uefi.FVFileTypeDriver, uefi.FVFileTypeCombinedSMMDXE:
+			return true
+		}
+		return false
+	}}
+	if err := find.Run(f); err != nil {
+		return err
+	}
+
+	var (
+		core    *uefi.File
+		drivers []*dxeDriver
+	)
+	// The protocols worth tracking are those of the DEPEX.
+	protocols := map[guid.GUID]bool{}
+	for g := range archProtocols {
+		protocols[g] = true
+	}
+	for _, m := range find.Matches {
+		file := m.(*uefi.File)
+		if file.Header.Type == uefi.FVFileTypeDXECore {
+			if core == nil {
+				core = file
+			}
+			continue
+		}
+		d := &dxeDriver{file: file, entry: DispatchEntry{GUID: file.Header.GUID, Name: FileUIName(file)}}
+		walkSections(file.Sections, func(s *uefi.Section) {
+			if s.Header.Type == uefi.SectionTypeDXEDepEx && d.depEx == nil {
+				d.depEx = s.DepEx
+			}
+		})
+		for _, op := range d.depEx {
+			if op.GUID != nil && op.OpCode == "PUSH" {
+				protocols[*op.GUID] = true
+			}
+		}
+		var err error
+		if d.entry.DepEx, err = depExString(d.depEx); err != nil {
+			d.entry.Error = err.Error()
+		}
+		drivers = append(drivers, d)
+	}
+	if core == nil {
+		return errors.New("found no DXE core in firmware image")
+	}
+
+	installed := map[guid.GUID]bool{}
+	for _, g := range referencedGUIDs(core, protocols) {
+		// The DXE core waits for the architectural protocols.
+		if _, ok := archProtocols[g]; !ok {
+			installed[g] = true
+		}
+	}
+	for _, d := range drivers {
+		required := map[guid.GUID]bool{}
+		for _, op := range d.depEx {
+			if op.GUID != nil {
+				required[*op.GUID] = true
+			}
+		}
+		for _, g := range referencedGUIDs(d.file, protocols) {
+			if !required[g] {
+				d.installs = append(d.installs, g)
+			}
+		}
+	}
+
+	v.Dispatched = []DispatchEntry{{GUID: core.Header.GUID, Name: FileUIName(core), Status: DispatchDispatched}}
+	v.NotDispatched, v.Uninstalled = nil, nil
+	installable := map[guid.GUID]bool{}
+	for g := range installed {
+		installable[g] = true
+	}
+	for _, d := range drivers {
+		for _, g := range d.installs {
+			installable[g] = true
+		}
+	}
+	uninstalled := map[guid.GUID]bool{}
+	v.dispatch(drivers, installed)
+	for _, d := range drivers {
+		if d.done {
+			continue
+		}
+		d.entry.Status = DispatchUnsatisfied
+		if len(d.depEx) != 0 && d.depEx[0].OpCode == "SOR" {
+			d.entry.Status = DispatchOnRequest
+		}
+		missing := map[guid.GUID]bool{}
+		for _, op := range d.depEx {
+			switch {
+			case op.GUID != nil && (op.OpCode == "BEFORE" || op.OpCode == "AFTER"):
+				d.entry.Missing = append(d.entry.Missing, "driver "+op.GUID.String())
+			case op.GUID != nil && !installed[*op.GUID] && !missing[*op.GUID]:
+				missing[*op.GUID] = true
+				d.entry.Missing = append(d.entry.Missing, protocolName(*op.GUID))
+				if !installable[*op.GUID] && !uninstalled[*op.GUID] {
+					uninstalled[*op.GUID] = true
+					v.Uninstalled = append(v.Uninstalled, protocolName(*op.GUID))
+				}
+			}
+		}
+		if d.depEx == nil {
+			for g, name := range archProtocols {
+				if !installed[g] {
+					d.entry.Missing = append(d.entry.Missing, name)
+				}
+			}
+			sort.Strings(d.entry.Missing)
+		}
+		v.NotDispatched = append(v.NotDispatched, d.entry)
+	}
+
+	if v.W == nil {
+		return nil
+	}
+	if structuredOutput() {
+		return writeStructured(v.W, struct {
+			Dispatched    []DispatchEntry
+			NotDispatched []DispatchEntry
+			Uninstalled   []string
+		}{v.Dispatched, v.NotDispatched, v.Uninstalled})
+	}
+	return v.write()
+}
+
+// dispatch simulates the DXE dispatcher: each round evaluates the DEPEX of
+// the drivers which were not dispatched yet, in the order of the image,
+// then starts the drivers whose DEPEX is satisfied. BEFORE and AFTER
+// drivers are started right before and after their driver.
+func (v *DispatchOrder) dispatch(drivers []*dxeDriver, installed map[guid.GUID]bool) {
+	before := map[guid.GUID][]*dxeDriver{}
+	after := map[guid.GUID][]*dxeDriver{}
+	for _, d := range drivers {
+		if len(d.depEx) == 0 || d.depEx[0].GUID == nil {
+			continue
+		}
+		switch d.depEx[0].OpCode {
+		case "BEFORE":
+			before[*d.depEx[0].GUID] = append(before[*d.depEx[0].GUID], d)
+		case "AFTER":
+			after[*d.depEx[0].GUID] = append(after[*d.depEx[0].GUID], d)
+		}
+	}
+
+	var start func(d *dxeDriver)
+	start = func(d *dxeDriver) {
+		if d.done {
+			return
+		}
+		d.done = true
+		for _, b := range before[d.entry.GUID] {
+			start(b)
+		}
+		d.entry.Status = DispatchDispatched
+		v.Dispatched = append(v.Dispatched, d.entry)
+		for _, g := range d.installs {
+			installed[g] = true
+		}
+		for _, a := range after[d.entry.GUID] {
+			start(a)
+		}
+	}
+
+	for {
+		var scheduled []*dxeDriver
+		for _, d := range drivers {
+			if d.done {
+				continue
+			}
+			ok, err := evalDepEx(d.depEx, installed)
+			if err != nil {
+				d.entry.Error = err.Error()
+			}
+			if ok {
+				scheduled = append(scheduled, d)
+			}
+		}
+		if len(scheduled) == 0 {
+			return
+		}
+		for _, d := range scheduled {
+			start(d)
+		}
+	}
+}
+
+// evalDepEx evaluates a DXE DEPEX with the protocols installed. A driver
+// without DEPEX depends on all the architectural protocols. SOR, BEFORE and
+// AFTER DEPEX are never satisfied, as their drivers are scheduled otherwise.
+func evalDepEx(ops []uefi.DepExOp, installed map[guid.GUID]bool) (bool, error) {
+	if ops == nil {
+		for g := range archProtocols {
+			if !installed[g] {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+	if len(ops) != 0 {
+		switch ops[0].OpCode {
+		case "SOR", "BEFORE", "AFTER":
+			return false, nil
+		}
+	}
+	var stack []bool
+	pop := func() (bool, error) {
+		if len(stack) == 0 {
+			return false, errors.New("invalid DEPEX, stack underflow")
+		}
+		b := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return b, nil
+	}
+	for _, op := range ops {
+		switch op.OpCode {
+		case "PUSH":
+			g, err := depExGUID(op)
+			if err != nil {
+				return false, err
+			}
+			stack = append(stack, installed[g])
+		case "TRUE":
+			stack = append(stack, true)
+		case "FALSE":
+			stack = append(stack, false)
+		case "NOT":
+			b, err := pop()
+			if err != nil {
+				return false, err
+			}
+			stack = append(stack, !b)
+		case "AND", "OR":
+			b1, err := pop()
+			if err != nil {
+				return false, err
+			}
+			b2, err := pop()
+			if err != nil {
+				return false, err
+			}
+			if op.OpCode == "AND" {
+				stack = append(stack, b1 && b2)
+			} else {
+				stack = append(stack, b1 || b2)
+			}
+		case "END":
+			return pop()
+		default:
+			return false, fmt.Errorf("invalid DEPEX, unexpected %s", op.OpCode)
+		}
+	}
+	return false, errors.New("invalid DEPEX, no END")
+}
+
+// depExGUID returns the GUID operand of a PUSH, BEFORE or AFTER opcode.
+func depExGUID(op uefi.DepExOp) (guid.GUID, error) {
+	if op.GUID == nil {
+		return guid.GUID{}, fmt.Errorf("invalid DEPEX, %s without a GUID", op.OpCode)
+	}
+	return *op.GUID, nil
+}
+
+// depExString returns the DEPEX in infix notation, such as
+// "gEfiVariableArchProtocolGuid AND NOT gEfiPcdProtocolGuid".
+func depExString(ops []uefi.DepExOp) (string, error) {
+	type expr struct {
+		s string
+		// op is the operator of a compound expression.
+		op uefi.DepExOpCode
+	}
+	// Compound operands are parenthesized, unless they chain the same
+	// associative operator, as in "A AND B AND C".
+	operand := func(e expr, op uefi.DepExOpCode) string {
+		if e.op != "" && e.op != op {
+			return "(" + e.s + ")"
+		}
+		return e.s
+	}
+	var stack []expr
+	for _, op := range ops {
+		switch op.OpCode {
+		case "PUSH", "BEFORE", "AFTER":
+			g, err := depExGUID(op)
+			if err != nil {
+				return "", err
+			}
+			if op.OpCode == "PUSH" {
+				stack = append(stack, expr{s: protocolName(g)})
+			} else {
+				stack = append(stack, expr{s: string(op.OpCode) + " " + g.String()})
+			}
+		case "TRUE", "FALSE", "SOR":
+			stack = append(stack, expr{s: string(op.OpCode)})
+		case "NOT":
+			if len(stack) < 1 {
+				return "", errors.New("invalid DEPEX, stack underflow")
+			}
+			stack[len(stack)-1] = expr{s: "NOT " + operand(stack[len(stack)-1], op.OpCode)}
+		case "AND", "OR":
+			n := len(stack)
+			if n < 2 {
+				return "", errors.New("invalid DEPEX, stack underflow")
+			}
+			e := expr{s: operand(stack[n-2], op.OpCode) + " " + string(op.OpCode) + " " + operand(stack[n-1], op.OpCode), op: op.OpCode}
+			stack = append(stack[:n-2], e)
+		}
+	}
+	var s []string
+	for _, e := range stack {
+		s = append(s, e.s)
+	}
+	return strings.Join(s, " "), nil
+}
+
+// referencedGUIDs returns the GUIDs of protocols which appear in the
+// executables of the file, in the order of the protocols found.
+func referencedGUIDs(file *uefi.File, protocols map[guid.GUID]bool) []guid.GUID {
+	var (
+		found []guid.GUID
+		seen  = map[guid.GUID]bool{}
+	)
+	walkSections(file.Sections, func(s *uefi.Section) {
+		if s.Header.Type != uefi.SectionTypePE32 && s.Header.Type != uefi.SectionTypeTE {
+			return
+		}
+		buf := s.Buf()[sectionHeaderLen(s):]
+		var g guid.GUID
+		for i := 0; i+len(g) <= len(buf); i++ {
+			copy(g[:], buf[i:])
+			if protocols[g] && !seen[g] {
+				seen[g] = true
+				found = append(found, g)
+			}
+		}
+	})
+	return found
+}
+
+func (v *DispatchOrder) write() error {
+	for i, e := range v.Dispatched {
+		fmt.Fprintf(v.W, "%4d %v %s\n", i, e.GUID, e.Name)
+	}
+	for _, e := range v.NotDispatched {
+		fmt.Fprintf(v.W, "%4s %v %s: %s\n", "-", e.GUID, e.Name, e.Status)
+		if e.DepEx != "" {
+			fmt.Fprintf(v.W, "\tDEPEX: %s\n", e.DepEx)
+		}
+		if len(e.Missing) != 0 {
+			fmt.Fprintf(v.W, "\tmissing: %s\n", strings.Join(e.Missing, ", "))
+		}
+		if e.Error != "" {
+			fmt.Fprintf(v.W, "\t%s\n", e.Error)
+		}
+	}
+	if len(v.Uninstalled) != 0 {
+		fmt.Fprintf(v.W, "no driver installs: %s\n", strings.Join(v.Uninstalled, ", "))
+	}
+	_, err := fmt.Fprintf(v.W, "%d drivers dispatched, %d not dispatched\n", len(v.Dispatched), len(v.NotDispatched))
+	return err
+}
+
+// Visit applies the DispatchOrder visitor to any Firmware type.
+func (v *DispatchOrder) Visit(f uefi.Firmware) error {
+	return nil
+}
+
+func init() {
+	RegisterCLI("dispatch-order", "estimate the order in which the DXE drivers are dispatched from their DEPEX, and list the drivers whose DEPEX is never satisfied", 0, func(args []string) (uefi.Visitor, error) {
+		return &DispatchOrder{
+			W: os.Stdout,
+		}, nil
+	})
+}
@@ -0,0 +1,124 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// pcdProtocolGUID is installed by PcdDxe, which most OVMF drivers depend on.
+var pcdProtocolGUID = guid.MustParse("13A3F0F6-264A-3EF0-F2E0-DEC512342F34")
+
+func TestDispatchOrder(t *testing.T) {
+	f := parseImage(t)
+
+	var out bytes.Buffer
+	v := &DispatchOrder{W: &out}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Dispatched) < 2 || v.Dispatched[0].GUID != *dxeCoreGUID {
+		t.Fatalf("the DXE core is not dispatched first:\n%s", out.String())
+	}
+	if len(v.NotDispatched) != 0 || len(v.Uninstalled) != 0 {
+		t.Errorf("OVMF has drivers which are not dispatched:\n%s", out.String())
+	}
+	pcd := -1
+	for i, e := range v.Dispatched {
+		if e.Name == "PcdDxe" {
+			pcd = i
+		}
+		if strings.Contains(e.DepEx, pcdProtocolGUID.String()) && pcd == -1 {
+			t.Errorf("%s is dispatched before PcdDxe", e.Name)
+		}
+	}
+	if pcd == -1 {
+		t.Errorf("PcdDxe is not dispatched")
+	}
+}
+
+func TestDispatchOrderRemoved(t *testing.T) {
+	f := parseImage(t)
+	pred, err := FindFilePredicate("PcdDxe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Remove{Predicate: pred}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	v := &DispatchOrder{W: &out}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Uninstalled) != 1 || v.Uninstalled[0] != pcdProtocolGUID.String() {
+		t.Errorf("got uninstalled protocols %v, want %v", v.Uninstalled, pcdProtocolGUID)
+	}
+	var found bool
+	for _, e := range v.NotDispatched {
+		if e.Name == "VariableRuntimeDxe" {
+			found = true
+			if e.Status != DispatchUnsatisfied || len(e.Missing) != 1 || e.Missing[0] != pcdProtocolGUID.String() {
+				t.Errorf("VariableRuntimeDxe: got %+v", e)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("VariableRuntimeDxe is dispatched without PcdDxe:\n%s", out.String())
+	}
+}
+
+func TestEvalDepEx(t *testing.T) {
+	a, b := guid.MustParse("00000000-0000-0000-0000-00000000000A"), guid.MustParse("00000000-0000-0000-0000-00000000000B")
+	installed := map[guid.GUID]bool{*a: true}
+	for _, test := range []struct {
+		ops  []uefi.DepExOp
+		want bool
+		str  string
+	}{
+		{[]uefi.DepExOp{{OpCode: "PUSH", GUID: a}, {OpCode: "END"}}, true, a.String()},
+		{[]uefi.DepExOp{{OpCode: "PUSH", GUID: a}, {OpCode: "PUSH", GUID: b}, {OpCode: "AND"}, {OpCode: "END"}}, false, a.String() + " AND " + b.String()},
+		{[]uefi.DepExOp{{OpCode: "PUSH", GUID: a}, {OpCode: "PUSH", GUID: b}, {OpCode: "NOT"}, {OpCode: "AND"}, {OpCode: "END"}}, true, a.String() + " AND NOT " + b.String()},
+		{[]uefi.DepExOp{{OpCode: "PUSH", GUID: b}, {OpCode: "PUSH", GUID: a}, {OpCode: "PUSH", GUID: b}, {OpCode: "AND"}, {OpCode: "OR"}, {OpCode: "END"}}, false, b.String() + " OR (" + a.String() + " AND " + b.String() + ")"},
+		{[]uefi.DepExOp{{OpCode: "TRUE"}, {OpCode: "END"}}, true, "TRUE"},
+		{[]uefi.DepExOp{{OpCode: "SOR"}, {OpCode: "TRUE"}, {OpCode: "END"}}, false, "SOR TRUE"},
+		{[]uefi.DepExOp{{OpCode: "AFTER", GUID: a}, {OpCode: "END"}}, false, "AFTER " + a.String()},
+	} {
+		t.Run(test.str, func(t *testing.T) {
+			got, err := evalDepEx(test.ops, installed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+			if s, err := depExString(test.ops); err != nil || s != test.str {
+				t.Errorf("got %q, %v, want %q", s, err, test.str)
+			}
+		})
+	}
+
+	for _, ops := range [][]uefi.DepExOp{
+		{{OpCode: "AND"}, {OpCode: "END"}},
+		{{OpCode: "PUSH"}, {OpCode: "END"}},
+		{{OpCode: "PUSH", GUID: a}, {OpCode: "PUSH"}, {OpCode: "OR"}, {OpCode: "END"}},
+	} {
+		if _, err := evalDepEx(ops, installed); err == nil || !strings.HasPrefix(err.Error(), "invalid DEPEX") {
+			t.Errorf("%v: got error %v, want an invalid DEPEX", ops, err)
+		}
+		if _, err := depExString(ops); err == nil || !strings.HasPrefix(err.Error(), "invalid DEPEX") {
+			t.Errorf("%v: got string error %v, want an invalid DEPEX", ops, err)
+		}
+	}
+	if _, err := depExString([]uefi.DepExOp{{OpCode: "AFTER"}, {OpCode: "END"}}); err == nil {
+		t.Errorf("expected an error for an AFTER without a GUID")
+	}
+}
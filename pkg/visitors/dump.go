@@ -58,7 +58,7 @@ func init() {
 			return nil, err
 		}
 
-		file, err := os.OpenFile(args[1], os.O_RDWR|os.O_CREATE, 0755)
+		file, err := openFile(args[1], os.O_RDWR|os.O_CREATE, 0755)
 		if err != nil {
 			return nil, err
 		}
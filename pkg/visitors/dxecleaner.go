@@ -44,10 +44,19 @@ type DXECleaner struct {
 	W io.Writer
 }
 
+// Mutates implements Mutator.
+func (v *DXECleaner) Mutates() bool {
+	return true
+}
+
 // Run wraps Visit and performs some setup and teardown tasks.
 func (v *DXECleaner) Run(f uefi.Firmware) error {
+	if err := writable(f); err != nil {
+		return err
+	}
 	var printf = func(format string, a ...interface{}) {
-		if v.W != nil {
+		// Logs would corrupt structured output.
+		if v.W != nil && !structuredOutput() {
 			fmt.Fprintf(v.W, format, a...)
 		}
 	}
@@ -160,7 +169,7 @@ func init() {
 			fileName := args[1]
 			fileContents, err := os.ReadFile(fileName)
 			if err != nil {
-				return nil, fmt.Errorf("cannot read blacklist file %q: %v", fileName, err)
+				return nil, fmt.Errorf("cannot read blacklist file %q: %w", fileName, err)
 			}
 			blackListRegex, err := parseBlackList(fileName, string(fileContents))
 			if err != nil {
@@ -184,7 +193,7 @@ func init() {
 				defer os.RemoveAll(tmpDir)
 				tmpFile := filepath.Join(tmpDir, "bios.bin")
 
-				if err := (&Save{tmpFile}).Run(f); err != nil {
+				if err := (&Save{DirPath: tmpFile}).Run(f); err != nil {
 					return true, err
 				}
 				cmd := exec.CommandContext(ctx, args[0], tmpFile)
@@ -209,7 +218,7 @@ func init() {
 				return true, nil
 			},
 			Predicate: predicate,
-			W:         os.Stdout,
+			W:         os.Stderr,
 		}, nil
 	}
 
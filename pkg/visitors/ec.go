@@ -0,0 +1,107 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/linuxboot/fiano/pkg/ec"
+	"github.com/linuxboot/fiano/pkg/guid"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// ECFirmware is an embedded controller firmware found in the image.
+type ECFirmware struct {
+	ec.Blob
+	// File is the GUID of the file of the BIOS region holding the firmware,
+	// nil for the EC region.
+	File *guid.GUID `json:",omitempty"`
+	// SHA256 is the hash of the EC region or of the file.
+	SHA256 string
+}
+
+// ECVersions lists the EC firmware of the EC region and of the files of the
+// BIOS region, with their versions, see package ec.
+type ECVersions struct {
+	// Output
+	Firmware []ECFirmware
+
+	// The list is written to this writer.
+	W io.Writer
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *ECVersions) Run(f uefi.Firmware) error {
+	v.Firmware = nil
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	if v.W == nil {
+		return nil
+	}
+	if structuredOutput() {
+		return writeStructured(v.W, v.Firmware)
+	}
+	for _, fw := range v.Firmware {
+		where := "EC region"
+		if fw.File != nil {
+			where = "file " + fw.File.String()
+		}
+		version := fw.Version
+		if version == "" {
+			version = "-"
+		}
+		fmt.Fprintf(v.W, "%-14s %-32s %s at %#x\n", fw.Type, version, where, fw.Offset)
+	}
+	return nil
+}
+
+// Visit applies the ECVersions visitor to any Firmware type.
+func (v *ECVersions) Visit(f uefi.Firmware) error {
+	v.Firmware = append(v.Firmware, ecFirmware(f)...)
+	return f.ApplyChildren(v)
+}
+
+// ecFirmware returns the EC firmware held by an EC region or by a file. Files
+// holding firmware volumes are skipped, their own files are searched.
+func ecFirmware(f uefi.Firmware) []ECFirmware {
+	var blobs []ec.Blob
+	var file *guid.GUID
+	switch f := f.(type) {
+	case *uefi.RawRegion:
+		if f.Type() != uefi.RegionTypeEC {
+			return nil
+		}
+		blobs = ec.FindRegion(f.Buf())
+	case *uefi.File:
+		var fv bool
+		walkSections(f.Sections, func(s *uefi.Section) {
+			fv = fv || s.Header.Type == uefi.SectionTypeFirmwareVolumeImage
+		})
+		if fv || f.Header.Type == uefi.FVFileTypePad {
+			return nil
+		}
+		blobs = ec.Find(f.Buf())
+		g := f.Header.GUID
+		file = &g
+	}
+	if len(blobs) == 0 {
+		return nil
+	}
+	sum := sha256Hex(f.Buf())
+	fws := make([]ECFirmware, len(blobs))
+	for i, b := range blobs {
+		fws[i] = ECFirmware{Blob: b, File: file, SHA256: sum}
+	}
+	return fws
+}
+
+func init() {
+	RegisterCLI("ec", "list the embedded controller firmware of the EC region and of the BIOS files with their versions", 0, func(args []string) (uefi.Visitor, error) {
+		return &ECVersions{W: os.Stdout}, nil
+	})
+}
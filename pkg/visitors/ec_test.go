@@ -0,0 +1,69 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/ec"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// testChromeEC returns erased data holding a Chrome EC image_data structure
+// of the given version at 0x100.
+func testChromeEC(size int, version string) []byte {
+	b := bytes.Repeat([]byte{0xff}, size)
+	binary.LittleEndian.PutUint32(b[0x100:], 0xce778899)
+	copy(b[0x104:], append([]byte(version), 0))
+	binary.LittleEndian.PutUint32(b[0x100+44:], 0xceaabbdd)
+	return b
+}
+
+func TestECVersions(t *testing.T) {
+	// Parsing sets the erase polarity of the files.
+	f := parseImage(t)
+	region, err := uefi.NewRawRegion(testChromeEC(0x1000, "fizz_v1.1.7326-0a8b7d5ac"), nil, uefi.RegionTypeEC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &ECVersions{}
+	if err := v.Run(region); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Firmware) != 1 || v.Firmware[0].Type != ec.TypeChromeEC || v.Firmware[0].Version != "fizz_v1.1.7326-0a8b7d5ac" || v.Firmware[0].File != nil {
+		t.Errorf("EC region: got %+v", v.Firmware)
+	}
+
+	s := &SBOM{Format: SBOMSPDX}
+	if err := s.Run(region); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Components) != 2 || s.Components[0].Version != "fizz_v1.1.7326-0a8b7d5ac" || s.Components[1].Kind != SBOMKindEC || s.Components[1].Name != "Chrome EC fizz" {
+		t.Errorf("SBOM: got %+v", s.Components)
+	}
+
+	// The EC firmware bundled in a file of the BIOS region.
+	file, err := uefi.CreatePadFile(0x1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Header.Type = uefi.FVFileTypeRaw
+	copy(file.Buf()[file.DataOffset:], testChromeEC(0x200, "volteer_v2.0.5-abcdef"))
+	if err := v.Run(file); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Firmware) != 1 || v.Firmware[0].Board != "volteer" || v.Firmware[0].File == nil || *v.Firmware[0].File != file.Header.GUID {
+		t.Errorf("file: got %+v", v.Firmware)
+	}
+
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Firmware) != 0 {
+		t.Errorf("OVMF: got %+v", v.Firmware)
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// Defaults for the EntropyScan visitor.
+const (
+	DefaultEntropyWindow    = 4096
+	DefaultEntropyThreshold = 7.5
+)
+
+// EntropyRegion is a run of high entropy data inside a leaf node.
+type EntropyRegion struct {
+	// Node describes the node holding the data.
+	Node string
+	// GUID of the enclosing file, if any.
+	GUID string `json:",omitempty"`
+	// Offset and Length within the buffer of the node.
+	Offset uint64
+	Length uint64
+	// Entropy is the highest entropy, in bits per byte, of the windows in
+	// the run.
+	Entropy float64
+}
+
+// EntropyScan computes the entropy over a sliding window of leaf content
+// (raw sections, raw files, BIOS padding and raw regions) and reports the
+// runs which are likely to be encrypted or compressed. Data hidden outside
+// firmware volumes often shows up this way.
+type EntropyScan struct {
+	// Input
+	// Window is the size of the sliding window in bytes. The window slides
+	// by half its size.
+	Window int
+	// Threshold is the entropy in bits per byte above which a window is
+	// flagged. The maximum is 8.
+	Threshold float64
+
+	// JSON is written to this writer.
+	W io.Writer
+
+	// Output
+	Regions []EntropyRegion
+
+	// Private
+	curFile *uefi.File
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *EntropyScan) Run(f uefi.Firmware) error {
+	if v.Window <= 0 {
+		v.Window = DefaultEntropyWindow
+	}
+	if v.Threshold == 0 {
+		v.Threshold = DefaultEntropyThreshold
+	}
+	v.Regions = []EntropyRegion{}
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+
+	if v.W != nil {
+		return writeStructured(v.W, v.Regions)
+	}
+	return nil
+}
+
+// Visit applies the EntropyScan visitor to any Firmware type.
+func (v *EntropyScan) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.File:
+		prev := v.curFile
+		v.curFile = f
+		defer func() { v.curFile = prev }()
+		if f.Header.Type == uefi.FVFileTypeRaw && len(f.Sections) == 0 && f.NVarStore == nil {
+			v.scan(f, f.Buf()[f.DataOffset:], f.DataOffset)
+			return nil
+		}
+
+	case *uefi.Section:
+		if f.Header.Type == uefi.SectionTypeRaw {
+			hl := uint64(sectionHeaderLen(f))
+			v.scan(f, f.Buf()[hl:], hl)
+		}
+
+	case *uefi.BIOSPadding:
+		v.scan(f, f.Buf(), 0)
+
+	case *uefi.RawRegion:
+		v.scan(f, f.Buf(), 0)
+	}
+	return f.ApplyChildren(v)
+}
+
+// scan flags the high entropy runs of buf, which starts at offset within the
+// buffer of f.
+func (v *EntropyScan) scan(f uefi.Firmware, buf []byte, offset uint64) {
+	var cur *EntropyRegion
+	flush := func() {
+		if cur != nil {
+			v.Regions = append(v.Regions, *cur)
+			cur = nil
+		}
+	}
+	step := v.Window / 2
+	if step == 0 {
+		step = 1
+	}
+	for start := 0; start < len(buf); start += step {
+		end := start + v.Window
+		if end > len(buf) {
+			end = len(buf)
+		}
+		// Skip trailing windows which are too short to be meaningful.
+		if end-start < v.Window && start != 0 {
+			break
+		}
+		e := Entropy(buf[start:end])
+		if e < v.Threshold {
+			flush()
+			continue
+		}
+		if cur == nil {
+			cur = &EntropyRegion{
+				Node:   strings.TrimPrefix(fmt.Sprintf("%T", f), "*uefi."),
+				Offset: offset + uint64(start),
+			}
+			if v.curFile != nil {
+				cur.GUID = v.curFile.Header.GUID.String()
+			}
+		}
+		cur.Length = offset + uint64(end) - cur.Offset
+		if e > cur.Entropy {
+			cur.Entropy = e
+		}
+	}
+	flush()
+}
+
+// Entropy returns the Shannon entropy of buf in bits per byte.
+func Entropy(buf []byte) float64 {
+	if len(buf) == 0 {
+		return 0
+	}
+	var counts [256]int
+	for _, b := range buf {
+		counts[b]++
+	}
+	var e float64
+	n := float64(len(buf))
+	for _, c := range counts {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / n
+		e -= p * math.Log2(p)
+	}
+	return e
+}
+
+func init() {
+	RegisterCLI("entropy", "flag high entropy data in raw sections, raw files, padding and raw regions", 0, func(args []string) (uefi.Visitor, error) {
+		return &EntropyScan{
+			W: os.Stdout,
+		}, nil
+	})
+	RegisterCLI("entropy_threshold", "flag data in leaf content above the given entropy in bits per byte, using a window of the given size", 2, func(args []string) (uefi.Visitor, error) {
+		threshold, err := strconv.ParseFloat(args[0], 64)
+		if err != nil {
+			return nil, err
+		}
+		if threshold <= 0 || threshold > 8 {
+			return nil, fmt.Errorf("threshold %v out of range (0, 8]", threshold)
+		}
+		window, err := strconv.ParseUint(args[1], 0, 32)
+		if err != nil {
+			return nil, err
+		}
+		return &EntropyScan{
+			Window:    int(window),
+			Threshold: threshold,
+			W:         os.Stdout,
+		}, nil
+	})
+}
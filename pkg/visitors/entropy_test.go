@@ -0,0 +1,51 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestEntropy(t *testing.T) {
+	if e := Entropy(make([]byte, 64)); e != 0 {
+		t.Errorf("entropy of zeros: got %v, want 0", e)
+	}
+	buf := make([]byte, 256)
+	for i := range buf {
+		buf[i] = byte(i)
+	}
+	if e := Entropy(buf); e != 8 {
+		t.Errorf("entropy of all byte values: got %v, want 8", e)
+	}
+}
+
+func TestEntropyScan(t *testing.T) {
+	buf := make([]byte, 64*1024)
+	// Fill the middle with random data.
+	rand.New(rand.NewSource(1)).Read(buf[16*1024 : 48*1024])
+	r, err := uefi.NewRawRegion(buf, nil, uefi.RegionTypeGBE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := &EntropyScan{Window: 1024}
+	if err := v.Run(r); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Regions) != 1 {
+		t.Fatalf("expected one region, got %+v", v.Regions)
+	}
+	got := v.Regions[0]
+	if got.Node != "RawRegion" || got.Offset < 15*1024 || got.Offset > 16*1024 ||
+		got.Offset+got.Length < 48*1024 || got.Offset+got.Length > 49*1024 {
+		t.Errorf("unexpected region %+v", got)
+	}
+	if got.Entropy < DefaultEntropyThreshold {
+		t.Errorf("entropy %v below threshold", got.Entropy)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// NodeError is an error about a node of the firmware tree, see
+// uefi.NodeError.
+type NodeError = uefi.NodeError
+
+// newNodeError wraps err with the description and the location of f in the
+// tree of root. Errors which already have a node are returned as is.
+func newNodeError(root, f uefi.Firmware, err error) error {
+	if _, ok := err.(*NodeError); ok {
+		return err
+	}
+	e := &NodeError{Node: uefi.NodeName(f), Err: err}
+	if root == nil {
+		root = f
+	}
+	e.Path, e.Offset, _ = uefi.Locate(root, f)
+	if e.Offset != nil {
+		e.Chip = uefi.LocateChip(root, *e.Offset)
+	}
+	return e
+}
+
+// ErrorReport is the structured form of an error.
+type ErrorReport struct {
+	Message string
+	Node    string             `json:",omitempty"`
+	Path    string             `json:",omitempty"`
+	Offset  *uint64            `json:",omitempty"`
+	Chip    *uefi.ChipLocation `json:",omitempty"`
+}
+
+// NewErrorReport returns the structured form of err, including the node,
+// path, offset and chip location of a *NodeError. They are not repeated in
+// the message of a *NodeError.
+func NewErrorReport(err error) ErrorReport {
+	r := ErrorReport{Message: err.Error()}
+	var ne *NodeError
+	if errors.As(err, &ne) {
+		r.Node, r.Path, r.Offset, r.Chip = ne.Node, ne.Path, ne.Offset, ne.Chip
+		if err == error(ne) {
+			r.Message = ne.Err.Error()
+		}
+	}
+	return r
+}
+
+// ValidationError is returned by Validate when the image has errors.
+type ValidationError struct {
+	Errors []error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("validation failed with %d errors", len(e.Errors))
+}
@@ -5,11 +5,17 @@
 package visitors
 
 import (
+	"archive/tar"
+	"compress/gzip"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/linuxboot/fiano/pkg/uefi"
 )
@@ -24,6 +30,10 @@ type Extract struct {
 	BasePath string
 	DirPath  string
 	Index    *uint64
+
+	// Archive, if not nil, receives the extracted files, summary.json
+	// last, instead of BasePath. The caller closes it.
+	Archive *tar.Writer
 }
 
 // extractBinary simply dumps the binary to a specified directory and filename.
@@ -31,26 +41,48 @@ type Extract struct {
 // It returns the filepath of the binary, and an error if it exists.
 // This is meant as a helper function for other Extract functions.
 func (v *Extract) extractBinary(buf []byte, filename string) (string, error) {
+	// The returned path is separated with slashes, so that the JSON does
+	// not depend on the OS.
+	rel := path.Join(filepath.ToSlash(v.DirPath), filename)
+	if v.Archive != nil {
+		if err := writeTarFile(v.Archive, rel, buf); err != nil {
+			return "", err
+		}
+		return rel, nil
+	}
+
 	// Create the directory if it doesn't exist
 	dirPath := filepath.Join(v.BasePath, v.DirPath)
-	if err := os.MkdirAll(dirPath, 0755); err != nil {
+	if err := mkdirAll(dirPath); err != nil {
 		return "", err
 	}
 
 	// Dump the binary.
 	fp := filepath.Join(dirPath, filename)
-	if err := os.WriteFile(fp, buf, 0666); err != nil {
+	if err := writeFile(fp, buf); err != nil {
 		// Make sure we return "" since we don't want an invalid path to be serialized out.
 		return "", err
 	}
 	// Return only the relative path from the root of the tree
-	return filepath.Join(v.DirPath, filename), nil
+	return rel, nil
 }
 
 // Run wraps Visit and performs some setup and teardown tasks.
 func (v *Extract) Run(f uefi.Firmware) error {
+	if v.Archive != nil {
+		*v.Index = 0
+		if err := f.Apply(v); err != nil {
+			return err
+		}
+		json, err := uefi.MarshalFirmware(f)
+		if err != nil {
+			return err
+		}
+		return writeTarFile(v.Archive, "summary.json", json)
+	}
+
 	// Optionally remove directory if it already exists.
-	if *remove {
+	if *remove && !DryRun {
 		if err := os.RemoveAll(v.BasePath); err != nil {
 			return err
 		}
@@ -70,7 +102,7 @@ func (v *Extract) Run(f uefi.Firmware) error {
 	}
 
 	// Create the directory if it does not exist.
-	if err := os.MkdirAll(v.BasePath, 0755); err != nil {
+	if err := mkdirAll(v.BasePath); err != nil {
 		return err
 	}
 
@@ -85,7 +117,7 @@ func (v *Extract) Run(f uefi.Firmware) error {
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(filepath.Join(v.BasePath, "summary.json"), json, 0666)
+	return writeFile(filepath.Join(v.BasePath, "summary.json"), json)
 }
 
 // Visit applies the Extract visitor to any Firmware type.
@@ -166,6 +198,68 @@ func (v *Extract) Visit(f uefi.Firmware) error {
 	return f.ApplyChildren(&v2)
 }
 
+// writeTarFile writes buf to tw as the file at the relative slash-separated
+// path name. The modification times are left out so that archiving an image
+// twice gives the same archive.
+func writeTarFile(tw *tar.Writer, name string, buf []byte) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(buf)),
+		ModTime:  time.Unix(0, 0),
+	}); err != nil {
+		return err
+	}
+	_, err := tw.Write(buf)
+	return err
+}
+
+// ExtractTar extracts any Firmware node to a tar archive at Path, laid out as
+// the directory written by Extract. The archive is compressed with gzip if
+// Path ends with ".gz" or ".tgz", and StdioPath writes it to the standard
+// output. Many small files are costly to store, so this suits archiving the
+// analysis of many images.
+type ExtractTar struct {
+	Path string
+}
+
+// Run writes the archive.
+func (v *ExtractTar) Run(f uefi.Firmware) (err error) {
+	var w io.Writer = os.Stdout
+	if v.Path != StdioPath {
+		out, oerr := openFile(v.Path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+		if oerr != nil {
+			return oerr
+		}
+		defer func() {
+			if cerr := out.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		w = out
+	}
+	if strings.HasSuffix(v.Path, ".gz") || strings.HasSuffix(v.Path, ".tgz") {
+		gz := gzip.NewWriter(w)
+		defer func() {
+			if cerr := gz.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	if err := (&Extract{DirPath: ".", Index: new(uint64), Archive: tw}).Run(f); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// Visit writes the archive of f.
+func (v *ExtractTar) Visit(f uefi.Firmware) error {
+	return v.Run(f)
+}
+
 func init() {
 	var fileIndex uint64
 	RegisterCLI("extract", "extract dir\n extract the files to directory `dir`", 1, func(args []string) (uefi.Visitor, error) {
@@ -175,4 +269,7 @@ func init() {
 			Index:    &fileIndex,
 		}, nil
 	})
+	RegisterCLI("extract-tar", "extract-tar archive\n extract the files to the tar `archive`, compressed with gzip if it ends with .gz or .tgz, \"-\" writes it to stdout", 1, func(args []string) (uefi.Visitor, error) {
+		return &ExtractTar{Path: args[0]}, nil
+	})
 }
@@ -5,7 +5,13 @@
 package visitors
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/linuxboot/fiano/pkg/log"
@@ -221,3 +227,61 @@ func TestExtractAssembleSection(t *testing.T) {
 		})
 	}
 }
+
+func TestExtractTar(t *testing.T) {
+	f := parseImage(t)
+	dir := t.TempDir()
+	if err := (&Extract{BasePath: filepath.Join(dir, "tree"), DirPath: ".", Index: new(uint64)}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]byte{}
+	if err := filepath.WalkDir(filepath.Join(dir, "tree"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(filepath.Join(dir, "tree"), path)
+		if err != nil {
+			return err
+		}
+		want[filepath.ToSlash(rel)], err = os.ReadFile(path)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(dir, "tree.tar.gz")
+	if err := (&ExtractTar{Path: archive}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	in, err := os.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	got := map[string][]byte{}
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got[h.Name], err = io.ReadAll(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %d files in the archive, want %d", len(got), len(want))
+	}
+	for name, buf := range want {
+		if !bytes.Equal(got[name], buf) {
+			t.Errorf("%s differs in the archive", name)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// ExtractExecutables writes every PE32 and TE image to DirPath. Images are
+// named after the UI section of their file, falling back to the file GUID,
+// followed by the file type, e.g. "DxeCore_DXE_CORE.efi".
+type ExtractExecutables struct {
+	// Input
+	DirPath string
+
+	// Output
+	Paths []string
+
+	// Private
+	currentFile *uefi.File
+	currentName string
+	names       map[string]bool
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *ExtractExecutables) Run(f uefi.Firmware) error {
+	if err := mkdirAll(v.DirPath); err != nil {
+		return err
+	}
+	v.Paths = nil
+	v.names = map[string]bool{}
+	return f.Apply(v)
+}
+
+// Visit applies the ExtractExecutables visitor to any Firmware type.
+func (v *ExtractExecutables) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.File:
+		name := FileUIName(f)
+		if name == "" {
+			name = f.Header.GUID.String()
+		}
+		prevFile, prevName := v.currentFile, v.currentName
+		v.currentFile, v.currentName = f, name
+		err := f.ApplyChildren(v)
+		v.currentFile, v.currentName = prevFile, prevName
+		return err
+
+	case *uefi.Section:
+		var ext string
+		switch f.Header.Type {
+		case uefi.SectionTypePE32:
+			ext = ".efi"
+		case uefi.SectionTypeTE:
+			ext = ".te"
+		default:
+			return f.ApplyChildren(v)
+		}
+		if v.currentFile == nil {
+			return nil
+		}
+		return v.write(f.Buf()[sectionHeaderLen(f):], ext)
+	}
+	return f.ApplyChildren(v)
+}
+
+func (v *ExtractExecutables) write(buf []byte, ext string) error {
+	fileType := strings.TrimPrefix(v.currentFile.Header.Type.String(), "EFI_FV_FILETYPE_")
+	base := sanitizeFilename(v.currentName) + "_" + fileType
+	name := base + ext
+	for i := 1; v.names[name]; i++ {
+		name = fmt.Sprintf("%s_%d%s", base, i, ext)
+	}
+	v.names[name] = true
+
+	path := filepath.Join(v.DirPath, name)
+	if err := writeFile(path, buf); err != nil {
+		return err
+	}
+	v.Paths = append(v.Paths, path)
+	return nil
+}
+
+// FileUIName returns the string of the first UI section in the file,
+// including sections nested in encapsulation sections.
+func FileUIName(f *uefi.File) string {
+	var name string
+	walkSections(f.Sections, func(s *uefi.Section) {
+		if name == "" && s.Header.Type == uefi.SectionTypeUserInterface {
+			name = s.Name
+		}
+	})
+	return name
+}
+
+// sanitizeFilename replaces characters which are not safe in a file name.
+func sanitizeFilename(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '/' || r == '\\' || r == ':' || r < ' ':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+func init() {
+	RegisterCLI("extract-executables", "extract all PE32 and TE images to a directory, named by their UI section and file type", 1, func(args []string) (uefi.Visitor, error) {
+		return &ExtractExecutables{
+			DirPath: args[0],
+		}, nil
+	})
+}
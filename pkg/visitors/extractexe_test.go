@@ -0,0 +1,33 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtractExecutables(t *testing.T) {
+	f := parseImage(t)
+
+	dir := t.TempDir()
+	v := &ExtractExecutables{DirPath: dir}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Paths) < 2 {
+		t.Fatalf("expected at least 2 executables, got %d", len(v.Paths))
+	}
+
+	buf, err := os.ReadFile(filepath.Join(dir, "DxeCore_DXE_CORE.efi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf, []byte("MZ")) {
+		t.Errorf("DxeCore_DXE_CORE.efi is not a PE32 image")
+	}
+}
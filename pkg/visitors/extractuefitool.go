@@ -0,0 +1,253 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// ExtractUEFITool extracts the image to DirPath laid out as UEFIExtract, the
+// command line tool of UEFITool, dumps it, so that scripts written for that
+// layout work unchanged. The node given to Run is dumped to DirPath and
+// every child to a directory of its parent named after its index among its
+// siblings and its name, such as "2 BIOS region/0 8C8CE578-8A3D-4F1C-9935-
+// 896185C32DD3/5 DxeCore/0 Compressed section". Files are named after their
+// UI section when they have one. Each directory holds the header of the
+// node in header.bin and the rest in body.bin, when not empty, and its
+// type, subtype, name and sizes in info.txt.
+type ExtractUEFITool struct {
+	DirPath string
+
+	// Private
+	// dir is the directory of the parent of the visited nodes, index the
+	// index of the next one.
+	dir   string
+	index *int
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *ExtractUEFITool) Run(f uefi.Firmware) error {
+	// UEFIExtract refuses to overwrite a dump.
+	if files, err := os.ReadDir(v.DirPath); err == nil && len(files) != 0 {
+		return fmt.Errorf("%s: %w", v.DirPath, os.ErrExist)
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	node := uefiToolNodeOf(f)
+	// Images without flash descriptor are UEFI images.
+	if _, ok := f.(*uefi.BIOSRegion); ok {
+		node.typ, node.subtype, node.name = "Image", "UEFI", "UEFI image"
+	}
+	return v.dump(f, v.DirPath, node)
+}
+
+// Visit applies the ExtractUEFITool visitor to any Firmware type.
+func (v *ExtractUEFITool) Visit(f uefi.Firmware) error {
+	node := uefiToolNodeOf(f)
+	return v.dump(f, v.childDir(node.dirName()), node)
+}
+
+// childDir returns the directory of the next child named name.
+func (v *ExtractUEFITool) childDir(name string) string {
+	dir := filepath.Join(v.dir, fmt.Sprintf("%d %s", *v.index, sanitizeFilename(name)))
+	*v.index++
+	return dir
+}
+
+// dump writes the node f to dir, then its children.
+func (v *ExtractUEFITool) dump(f uefi.Firmware, dir string, node uefiToolNode) error {
+	if err := node.write(dir); err != nil {
+		return err
+	}
+	v2 := &ExtractUEFITool{DirPath: v.DirPath, dir: dir, index: new(int)}
+	if err := f.ApplyChildren(v2); err != nil {
+		return err
+	}
+	// The free space ends the volume.
+	if fv, ok := f.(*uefi.FirmwareVolume); ok && fv.FreeSpace != 0 && fv.FreeSpace <= uint64(len(fv.Buf())) {
+		buf := fv.Buf()
+		free := uefiToolNode{typ: "Free space", name: "Volume free space", body: buf[uint64(len(buf))-fv.FreeSpace:]}
+		return free.write(v2.childDir(free.name))
+	}
+	return nil
+}
+
+// uefiToolNode is a node of the tree as UEFITool shows it.
+type uefiToolNode struct {
+	typ, subtype string
+	// name is the GUID or the kind of the node, text the name UEFITool
+	// gives to files and variables, used as the directory name instead.
+	name, text   string
+	header, body []byte
+}
+
+func (n uefiToolNode) dirName() string {
+	if n.text != "" {
+		return n.text
+	}
+	return n.name
+}
+
+// write writes the header, body and info of the node to dir.
+func (n uefiToolNode) write(dir string) error {
+	if err := mkdirAll(dir); err != nil {
+		return err
+	}
+	for name, buf := range map[string][]byte{"header.bin": n.header, "body.bin": n.body} {
+		if len(buf) == 0 {
+			continue
+		}
+		if err := writeFile(filepath.Join(dir, name), buf); err != nil {
+			return err
+		}
+	}
+	var info strings.Builder
+	fmt.Fprintf(&info, "Type: %s\n", n.typ)
+	if n.subtype != "" {
+		fmt.Fprintf(&info, "Subtype: %s\n", n.subtype)
+	}
+	if n.text != "" {
+		fmt.Fprintf(&info, "Text: %s\n", n.text)
+	}
+	fmt.Fprintf(&info, "Name: %s\n", n.name)
+	full := len(n.header) + len(n.body)
+	fmt.Fprintf(&info, "Full size: %Xh (%d)\nHeader size: %Xh (%d)\nBody size: %Xh (%d)\n", full, full, len(n.header), len(n.header), len(n.body), len(n.body))
+	return writeFile(filepath.Join(dir, "info.txt"), []byte(info.String()))
+}
+
+// UEFITool names of the region types.
+var uefiToolRegionNames = map[uefi.FlashRegionType]string{
+	uefi.RegionTypeGBE: "GbE",
+	uefi.RegionTypePD:  "PDR",
+	uefi.RegionTypeEC:  "EC",
+}
+
+// UEFITool names of the file types.
+var uefiToolFileTypes = map[uefi.FVFileType]string{
+	uefi.FVFileTypeRaw:                "Raw",
+	uefi.FVFileTypeFreeForm:           "Freeform",
+	uefi.FVFileTypeSECCore:            "SEC core",
+	uefi.FVFileTypePEICore:            "PEI core",
+	uefi.FVFileTypeDXECore:            "DXE core",
+	uefi.FVFileTypePEIM:               "PEI module",
+	uefi.FVFileTypeDriver:             "DXE driver",
+	uefi.FVFileTypeCombinedPEIMDriver: "Combined PEI/DXE",
+	uefi.FVFileTypeApplication:        "Application",
+	uefi.FVFileTypeSMM:                "SMM module",
+	uefi.FVFileTypeVolumeImage:        "Volume image",
+	uefi.FVFileTypeCombinedSMMDXE:     "Combined SMM/DXE",
+	uefi.FVFileTypeSMMCore:            "SMM core",
+	uefi.FVFileTypeSMMStandalone:      "MM standalone module",
+	uefi.FVFileTypeSMMCoreStandalone:  "MM standalone core",
+	uefi.FVFileTypePad:                "Pad",
+}
+
+// UEFITool names of the section types.
+var uefiToolSectionTypes = map[uefi.SectionType]string{
+	uefi.SectionTypeCompression:         "Compressed",
+	uefi.SectionTypeGUIDDefined:         "GUID defined",
+	uefi.SectionTypeDisposable:          "Disposable",
+	uefi.SectionTypePE32:                "PE32 image",
+	uefi.SectionTypePIC:                 "PIC image",
+	uefi.SectionTypeTE:                  "TE image",
+	uefi.SectionTypeDXEDepEx:            "DXE dependency",
+	uefi.SectionTypeVersion:             "Version",
+	uefi.SectionTypeUserInterface:       "User interface",
+	uefi.SectionTypeCompatibility16:     "16-bit image",
+	uefi.SectionTypeFirmwareVolumeImage: "Volume image",
+	uefi.SectionTypeFreeformSubtypeGUID: "Freeform subtype GUID",
+	uefi.SectionTypeRaw:                 "Raw",
+	uefi.SectionTypePEIDepEx:            "PEI dependency",
+	uefi.SectionMMDepEx:                 "MM dependency",
+}
+
+// uefiToolNodeOf returns the node of f.
+func uefiToolNodeOf(f uefi.Firmware) uefiToolNode {
+	buf := f.Buf()
+	switch f := f.(type) {
+	case *uefi.FlashImage:
+		return uefiToolNode{typ: "Image", subtype: "Intel", name: "Intel image", body: buf}
+	case *uefi.FlashDescriptor:
+		return uefiToolNode{typ: "Region", subtype: "Descriptor", name: "Descriptor region", body: buf}
+	case *uefi.BIOSRegion:
+		return uefiToolNode{typ: "Region", subtype: "BIOS", name: "BIOS region", body: buf}
+	case *uefi.MERegion:
+		return uefiToolNode{typ: "Region", subtype: "ME", name: "ME region", body: buf}
+	case *uefi.RawRegion:
+		name, ok := uefiToolRegionNames[f.Type()]
+		if !ok {
+			name = f.Type().String()
+		}
+		return uefiToolNode{typ: "Region", subtype: name, name: name + " region", body: buf}
+	case *uefi.BIOSPadding:
+		subtype := "Non-empty"
+		if uefi.IsErased(buf, uefi.Attributes.ErasePolarity) {
+			subtype = "Empty"
+		}
+		return uefiToolNode{typ: "Padding", subtype: subtype, name: "Padding", body: buf}
+	case *uefi.FirmwareVolume:
+		subtype, ok := uefi.FVGUIDs[f.FileSystemGUID]
+		if !ok {
+			subtype = "Unknown"
+		}
+		return uefiToolNode{typ: "Volume", subtype: strings.Replace(subtype, "FFS", "FFSv", 1), name: f.String(), header: buf[:f.DataOffset], body: buf[f.DataOffset:]}
+	case *uefi.File:
+		subtype, ok := uefiToolFileTypes[f.Header.Type]
+		if !ok {
+			subtype = fmt.Sprintf("%02Xh", uint8(f.Header.Type))
+		}
+		n := uefiToolNode{typ: "File", subtype: subtype, name: f.Header.GUID.String(), text: FileUIName(f), header: buf[:f.DataOffset], body: buf[f.DataOffset:]}
+		if f.Header.Type == uefi.FVFileTypePad {
+			n.name = "Pad-file"
+		}
+		return n
+	case *uefi.Section:
+		subtype, ok := uefiToolSectionTypes[f.Header.Type]
+		if !ok {
+			subtype = fmt.Sprintf("%02Xh", uint8(f.Header.Type))
+		}
+		hdr := uefiToolSectionHeaderLen(f)
+		return uefiToolNode{typ: "Section", subtype: subtype, name: subtype + " section", header: buf[:hdr], body: buf[hdr:]}
+	case *uefi.NVar:
+		hdr := f.DataOffset
+		if hdr < 0 || hdr > int64(len(buf)) {
+			hdr = int64(len(buf))
+		}
+		return uefiToolNode{typ: "NVAR entry", subtype: f.Type.String(), name: f.GUID.String(), text: f.Name, header: buf[:hdr], body: buf[hdr:]}
+	case *uefi.NVarStore:
+		return uefiToolNode{typ: "NVAR store", name: "NVAR store", body: buf}
+	}
+	name := strings.TrimPrefix(fmt.Sprintf("%T", f), "*uefi.")
+	return uefiToolNode{typ: name, name: name, body: buf}
+}
+
+// uefiToolSectionHeaderLen returns the length of the header of a section,
+// including the header specific to its type, up to its data.
+func uefiToolSectionHeaderLen(s *uefi.Section) uint32 {
+	n := sectionHeaderLen(s)
+	if s.TypeSpecific != nil && s.TypeSpecific.Header != nil {
+		if h, ok := s.TypeSpecific.Header.(*uefi.SectionGUIDDefined); ok {
+			n = uint32(h.DataOffset)
+		} else {
+			n += s.TypeSpecific.Header.GetBinHeaderLen()
+		}
+	}
+	if n > uint32(len(s.Buf())) {
+		n = uint32(len(s.Buf()))
+	}
+	return n
+}
+
+func init() {
+	RegisterCLI("extract-uefitool", "extract-uefitool dir\n extract every node to directory `dir` laid out as the dumps of UEFIExtract, with header.bin, body.bin and info.txt", 1, func(args []string) (uefi.Visitor, error) {
+		return &ExtractUEFITool{DirPath: args[0]}, nil
+	})
+}
@@ -0,0 +1,78 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestExtractUEFITool(t *testing.T) {
+	f := parseImage(t)
+	dir := filepath.Join(t.TempDir(), "dump")
+	v := &ExtractUEFITool{DirPath: dir}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.ReadFile(filepath.Join(dir, "info.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(info), "Type: Image\nSubtype: UEFI\n") {
+		t.Errorf("root info.txt: got %q", info)
+	}
+
+	// SecMain is the first file of the third volume.
+	file := filepath.Join(dir, "2 763BED0D-DE9F-48F5-81F1-3E90E1B1A015", "0 SecMain")
+	info, err = os.ReadFile(filepath.Join(file, "info.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Type: File\n", "Subtype: SEC core\n", "Text: SecMain\n", "Header size: 18h (24)\n"} {
+		if !strings.Contains(string(info), want) {
+			t.Errorf("SecMain info.txt: missing %q in %q", want, info)
+		}
+	}
+	header, err := os.ReadFile(filepath.Join(file, "header.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := os.ReadFile(filepath.Join(file, "body.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pred, err := FindFileNamePredicate("SecMain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	find := &Find{Predicate: pred}
+	if err := find.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(find.Matches) != 1 {
+		t.Fatalf("found %d SecMain, want 1", len(find.Matches))
+	}
+	secMain := find.Matches[0].(*uefi.File)
+	if !bytes.Equal(append(header, body...), secMain.Buf()) {
+		t.Error("SecMain header.bin and body.bin do not make up the file")
+	}
+	for _, section := range []string{"0 PE32 image section", "1 User interface section", "2 Version section"} {
+		if _, err := os.Stat(filepath.Join(file, section, "info.txt")); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// UEFIExtract does not overwrite a dump.
+	if err := v.Run(f); !errors.Is(err, os.ErrExist) {
+		t.Errorf("second run: got %v, want %v", err, os.ErrExist)
+	}
+}
@@ -5,13 +5,14 @@
 package visitors
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"regexp"
+	"strings"
 
 	"github.com/linuxboot/fiano/pkg/guid"
+	"github.com/linuxboot/fiano/pkg/knownguids"
 	"github.com/linuxboot/fiano/pkg/log"
 	"github.com/linuxboot/fiano/pkg/uefi"
 )
@@ -42,11 +43,14 @@ func (v *Find) Run(f uefi.Firmware) error {
 		return err
 	}
 	if v.W != nil {
-		b, err := json.MarshalIndent(v.Matches, "", "\t")
-		if err != nil {
+		for _, m := range v.Matches {
+			if mod, ok := fileModule(m); ok {
+				log.With(log.F("file", mod.GUID)).Infof("%s", moduleSource(mod))
+			}
+		}
+		if err := writeStructured(v.W, v.Matches); err != nil {
 			log.Fatalf("%v", err)
 		}
-		fmt.Fprintln(v.W, string(b))
 	}
 	return nil
 }
@@ -131,6 +135,30 @@ func FindFilePredicate(r string) (func(f uefi.Firmware) bool, error) {
 	}, nil
 }
 
+// FindFileNamePredicate is FindFilePredicate which also matches the files
+// whose GUID has the known name r, as resolved by knownguids.Lookup, for
+// images without UI sections, and the files built from the INF path r of
+// BuildReport.
+func FindFileNamePredicate(r string) (func(f uefi.Firmware) bool, error) {
+	pred, err := FindFilePredicate(r)
+	if err != nil {
+		return nil, err
+	}
+	known := map[guid.GUID]bool{}
+	for _, g := range knownguids.Lookup(r) {
+		known[g] = true
+	}
+	for _, g := range BuildReport.LookupINF(r) {
+		known[g] = true
+	}
+	return func(f uefi.Firmware) bool {
+		if file, ok := f.(*uefi.File); ok && known[file.Header.GUID] {
+			return true
+		}
+		return pred(f)
+	}, nil
+}
+
 // FindFileFVPredicate is a generic predicate for searching FVs, files and UI sections.
 func FindFileFVPredicate(r string) (func(f uefi.Firmware) bool, error) {
 	ciRE, err := regexp.Compile("^(?i)" + r + "$")
@@ -150,6 +178,115 @@ func FindFileFVPredicate(r string) (func(f uefi.Firmware) bool, error) {
 	}, nil
 }
 
+// FindSectionTypePredicate is a generic predicate for searching section types.
+// It matches the sections themselves as well as the files containing them.
+func FindSectionTypePredicate(t uefi.SectionType) FindPredicate {
+	return func(f uefi.Firmware) bool {
+		switch f := f.(type) {
+		case *uefi.Section:
+			return f.Header.Type == t
+		case *uefi.File:
+			found := false
+			walkSections(f.Sections, func(s *uefi.Section) {
+				found = found || s.Header.Type == t
+			})
+			return found
+		}
+		return false
+	}
+}
+
+// FindSizePredicate is a generic predicate for searching nodes whose buffer
+// size is within [min, max]. A max of 0 means there is no upper bound.
+func FindSizePredicate(min, max uint64) FindPredicate {
+	return func(f uefi.Firmware) bool {
+		size := uint64(len(f.Buf()))
+		return size >= min && (max == 0 || size <= max)
+	}
+}
+
+// FindFileCompressionPredicate is a generic predicate for searching files by
+// the compression of their sections, e.g. "LZMA". The name "NONE" matches
+// files without compressed sections.
+func FindFileCompressionPredicate(name string) FindPredicate {
+	name = strings.ToUpper(name)
+	return func(f uefi.Firmware) bool {
+		file, ok := f.(*uefi.File)
+		if !ok {
+			return false
+		}
+		compressions := fileCompressions(file.Sections)
+		if name == "NONE" {
+			return len(compressions) == 0
+		}
+		return compressions[name]
+	}
+}
+
+// fileCompressions returns the set of compressions used by the sections.
+func fileCompressions(sections []*uefi.Section) map[string]bool {
+	c := map[string]bool{}
+	walkSections(sections, func(s *uefi.Section) {
+		if s.TypeSpecific != nil {
+			if h, ok := s.TypeSpecific.Header.(*uefi.SectionGUIDDefined); ok && h.Compression != "" {
+				c[h.Compression] = true
+			}
+			if h, ok := s.TypeSpecific.Header.(*uefi.SectionCompression); ok && h.Compression != "" {
+				c[h.Compression] = true
+			}
+		}
+	})
+	return c
+}
+
+// walkSections calls fn for each section and, recursively, for each
+// encapsulated section. It does not descend into encapsulated firmware
+// volumes.
+func walkSections(sections []*uefi.Section, fn func(s *uefi.Section)) {
+	for _, s := range sections {
+		fn(s)
+		for _, e := range s.Encapsulated {
+			if es, ok := e.Value.(*uefi.Section); ok {
+				walkSections([]*uefi.Section{es}, fn)
+			}
+		}
+	}
+}
+
+// FindDepthPredicate is a generic predicate for searching nodes whose depth
+// below root is within [min, max]. The root has depth 0. A max of -1 means
+// there is no upper bound.
+func FindDepthPredicate(root uefi.Firmware, min, max int) (FindPredicate, error) {
+	d := &depthIndex{depths: map[uefi.Firmware]int{}}
+	if err := root.Apply(d); err != nil {
+		return nil, err
+	}
+	return func(f uefi.Firmware) bool {
+		depth, ok := d.depths[f]
+		return ok && depth >= min && (max < 0 || depth <= max)
+	}, nil
+}
+
+// depthIndex records the depth of every node in the tree.
+type depthIndex struct {
+	depths map[uefi.Firmware]int
+	depth  int
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *depthIndex) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the depthIndex visitor to any Firmware type.
+func (v *depthIndex) Visit(f uefi.Firmware) error {
+	v.depths[f] = v.depth
+	v.depth++
+	err := f.ApplyChildren(v)
+	v.depth--
+	return err
+}
+
 // FindNotPredicate is a generic predicate which takes the logical NOT of an existing predicate.
 func FindNotPredicate(predicate FindPredicate) FindPredicate {
 	return func(f uefi.Firmware) bool {
@@ -157,13 +294,20 @@ func FindNotPredicate(predicate FindPredicate) FindPredicate {
 	}
 }
 
-// FindAndPredicate is a generic predicate which takes the logical OR of two existing predicates.
+// FindAndPredicate is a generic predicate which takes the logical AND of two existing predicates.
 func FindAndPredicate(predicate1 FindPredicate, predicate2 FindPredicate) FindPredicate {
 	return func(f uefi.Firmware) bool {
 		return predicate1(f) && predicate2(f)
 	}
 }
 
+// FindOrPredicate is a generic predicate which takes the logical OR of two existing predicates.
+func FindOrPredicate(predicate1 FindPredicate, predicate2 FindPredicate) FindPredicate {
+	return func(f uefi.Firmware) bool {
+		return predicate1(f) || predicate2(f)
+	}
+}
+
 // FindExactlyOne does a find using a supplied predicate and errors if there's more than one.
 func FindExactlyOne(f uefi.Firmware, pred func(f uefi.Firmware) bool) (uefi.Firmware, error) {
 	find := &Find{
@@ -240,7 +384,7 @@ func FindNVarPredicate(r string) (func(f uefi.Firmware) bool, error) {
 
 func init() {
 	RegisterCLI("find", "find a file by GUID or Name", 1, func(args []string) (uefi.Visitor, error) {
-		pred, err := FindFilePredicate(args[0])
+		pred, err := FindFileNamePredicate(args[0])
 		if err != nil {
 			return nil, err
 		}
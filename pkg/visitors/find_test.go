@@ -7,6 +7,7 @@ package visitors
 import (
 	"testing"
 
+	"github.com/linuxboot/fiano/pkg/guid"
 	"github.com/linuxboot/fiano/pkg/uefi"
 )
 
@@ -20,6 +21,41 @@ func TestFind(t *testing.T) {
 	}
 }
 
+func TestFindFileNamePredicate(t *testing.T) {
+	f := parseImage(t)
+	pcdPeim := find(t, f, guid.MustParse("9B3ADA4F-AE56-4C24-8DEA-F03B7558AE50"))[0].(*uefi.File)
+	// Strip the UI section, so only the known name of the GUID is left.
+	var sections []*uefi.Section
+	for _, s := range pcdPeim.Sections {
+		if s.Header.Type != uefi.SectionTypeUserInterface {
+			sections = append(sections, s)
+		}
+	}
+	pcdPeim.Sections = sections
+
+	for _, tt := range []struct {
+		name string
+		want int
+	}{
+		{"pcdpeim", 1},
+		{"PcdPei", 1},
+		{"9B3ADA4F-AE56-4C24-8DEA-F03B7558AE50", 1},
+		{"NoSuchFile", 0},
+	} {
+		pred, err := FindFileNamePredicate(tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		find := &Find{Predicate: pred}
+		if err := find.Run(f); err != nil {
+			t.Fatal(err)
+		}
+		if len(find.Matches) != tt.want {
+			t.Errorf("%s: got %d matches, want %d", tt.name, len(find.Matches), tt.want)
+		}
+	}
+}
+
 func TestFindExactlyOne(t *testing.T) {
 	f := parseImage(t)
 	_, err := FindExactlyOne(f, func(_ uefi.Firmware) bool {
@@ -0,0 +1,161 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// FindQuery finds nodes matching a query expression. A query is a list of
+// alternatives separated by "|", each of which is a list of terms separated
+// by "," which must all match. A term is KEY=VALUE and may be negated with
+// a leading "!". As with Find, a match on a section reports the enclosing
+// file, so negated terms should be combined with a positive file term. The
+// supported keys are:
+//
+//	guid=REGEX         file GUID
+//	name=REGEX         file GUID or UI section name
+//	filetype=TYPE      file type, e.g. DRIVER or PEIM
+//	sectiontype=TYPE   section type, e.g. PE32 or RAW
+//	compression=NAME   section compression, e.g. LZMA, or NONE
+//	minsize=SIZE       minimum size in bytes, e.g. 0x1000 or 1MiB
+//	maxsize=SIZE       maximum size in bytes
+//	mindepth=N         minimum depth in the tree, the root has depth 0
+//	maxdepth=N         maximum depth in the tree
+//
+// For example, all uncompressed DXE drivers over 1MiB:
+//
+//	filetype=DRIVER,compression=NONE,minsize=1MiB
+type FindQuery struct {
+	Query string
+
+	// Find is used to perform the search and receives the output.
+	Find
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *FindQuery) Run(f uefi.Firmware) error {
+	pred, err := ParseFindQuery(v.Query, f)
+	if err != nil {
+		return err
+	}
+	v.Find.Predicate = pred
+	return v.Find.Run(f)
+}
+
+// ParseFindQuery builds a predicate from a query expression. The root is
+// used to compute depths.
+func ParseFindQuery(query string, root uefi.Firmware) (FindPredicate, error) {
+	var or FindPredicate
+	for _, alt := range strings.Split(query, "|") {
+		var and FindPredicate
+		for _, term := range strings.Split(alt, ",") {
+			pred, err := parseFindTerm(strings.TrimSpace(term), root)
+			if err != nil {
+				return nil, err
+			}
+			if and == nil {
+				and = pred
+			} else {
+				and = FindAndPredicate(and, pred)
+			}
+		}
+		if or == nil {
+			or = and
+		} else {
+			or = FindOrPredicate(or, and)
+		}
+	}
+	return or, nil
+}
+
+func parseFindTerm(term string, root uefi.Firmware) (FindPredicate, error) {
+	negate := strings.HasPrefix(term, "!")
+	term = strings.TrimPrefix(term, "!")
+	key, value, ok := strings.Cut(term, "=")
+	if !ok {
+		return nil, fmt.Errorf("invalid query term %q, expected KEY=VALUE", term)
+	}
+
+	var pred FindPredicate
+	var err error
+	switch key {
+	case "guid":
+		var re *regexp.Regexp
+		if re, err = regexp.Compile("^(?i)(" + value + ")$"); err == nil {
+			pred = func(f uefi.Firmware) bool {
+				file, ok := f.(*uefi.File)
+				return ok && re.MatchString(file.Header.GUID.String())
+			}
+		}
+	case "name":
+		pred, err = FindFilePredicate(value)
+	case "filetype":
+		t, ok := uefi.NamesToFileType[strings.TrimPrefix(strings.ToUpper(value), "EFI_FV_FILETYPE_")]
+		if !ok {
+			return nil, fmt.Errorf("unknown file type %q", value)
+		}
+		pred = FindFileTypePredicate(t)
+	case "sectiontype":
+		t, ok := uefi.NamesToSectionType[strings.TrimPrefix(strings.ToUpper(value), "EFI_SECTION_")]
+		if !ok {
+			return nil, fmt.Errorf("unknown section type %q", value)
+		}
+		pred = FindSectionTypePredicate(t)
+	case "compression":
+		pred = FindFileCompressionPredicate(value)
+	case "minsize", "maxsize":
+		var size uint64
+		if size, err = parseSize(value); err == nil {
+			if key == "minsize" {
+				pred = FindSizePredicate(size, 0)
+			} else {
+				pred = FindSizePredicate(0, size)
+			}
+		}
+	case "mindepth", "maxdepth":
+		var depth int
+		if depth, err = strconv.Atoi(value); err == nil {
+			if key == "mindepth" {
+				pred, err = FindDepthPredicate(root, depth, -1)
+			} else {
+				pred, err = FindDepthPredicate(root, 0, depth)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown query key %q", key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid query term %q: %v", term, err)
+	}
+	if negate {
+		pred = FindNotPredicate(pred)
+	}
+	return pred, nil
+}
+
+// parseSize parses a number or a human readable size such as 1MiB.
+func parseSize(s string) (uint64, error) {
+	if n, err := strconv.ParseUint(s, 0, 64); err == nil {
+		return n, nil
+	}
+	return humanize.ParseBytes(s)
+}
+
+func init() {
+	RegisterCLI("find-where", "find nodes matching a query, e.g. filetype=DRIVER,compression=NONE,minsize=1MiB", 1, func(args []string) (uefi.Visitor, error) {
+		return &FindQuery{
+			Query: args[0],
+			Find:  Find{W: os.Stdout},
+		}, nil
+	})
+}
@@ -0,0 +1,68 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestFindQuery(t *testing.T) {
+	f := parseImage(t)
+
+	var tests = []struct {
+		query   string
+		atLeast int
+		atMost  int
+		check   func(m uefi.Firmware) bool
+	}{
+		{"filetype=DXE_CORE", 1, 1, func(m uefi.Firmware) bool {
+			return m.(*uefi.File).Header.GUID == *dxeCoreGUID
+		}},
+		{"filetype=DRIVER,compression=NONE,minsize=0x1000", 1, -1, func(m uefi.Firmware) bool {
+			file := m.(*uefi.File)
+			return file.Header.Type == uefi.FVFileTypeDriver && len(file.Buf()) >= 0x1000
+		}},
+		{"filetype=FIRMWARE_VOLUME_IMAGE,compression=LZMA", 1, -1, nil},
+		{"filetype=PEIM,!name=.*Pei.*", 1, -1, nil},
+		{"filetype=DXE_CORE|filetype=PEI_CORE", 2, 2, nil},
+		{"filetype=SECURITY_CORE,sectiontype=PE32", 1, 1, nil},
+		{"filetype=SECURITY_CORE,sectiontype=TE", 0, 0, nil},
+		{"maxdepth=1", 1, -1, func(m uefi.Firmware) bool {
+			_, ok := m.(*uefi.File)
+			return !ok
+		}},
+		{"filetype=DRIVER,maxsize=1", 0, 0, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			q := &FindQuery{Query: test.query}
+			if err := q.Run(f); err != nil {
+				t.Fatal(err)
+			}
+			if n := len(q.Matches); n < test.atLeast || (test.atMost >= 0 && n > test.atMost) {
+				t.Fatalf("got %d matches, want between %d and %d", n, test.atLeast, test.atMost)
+			}
+			if test.check == nil {
+				return
+			}
+			for _, m := range q.Matches {
+				if !test.check(m) {
+					t.Errorf("unexpected match %v", m)
+				}
+			}
+		})
+	}
+}
+
+func TestFindQueryErrors(t *testing.T) {
+	f := parseImage(t)
+	for _, query := range []string{"bogus", "color=red", "filetype=BOGUS", "minsize=big", "maxdepth=x"} {
+		if err := (&FindQuery{Query: query}).Run(f); err == nil {
+			t.Errorf("query %q: expected error", query)
+		}
+	}
+}
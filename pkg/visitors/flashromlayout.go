@@ -0,0 +1,95 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// flashromRegionNames are the names of the regions of the flash descriptor
+// in the layouts of "flashrom --ifd", by their index in the region section,
+// the descriptor itself being region 0.
+var flashromRegionNames = []string{"fd", "bios", "me", "gbe", "pd", "reg5", "bios2", "reg7", "ec", "reg9", "ie", "10gbe", "reg12", "reg13", "reg14", "reg15"}
+
+// flashromRegionName returns the name of a region in the layouts of flashrom.
+func flashromRegionName(t uefi.FlashRegionType) string {
+	if i := int(t) + 1; i < len(flashromRegionNames) {
+		return flashromRegionNames[i]
+	}
+	return fmt.Sprintf("reg%d", int(t)+1)
+}
+
+// FlashromLayout prints the regions of a flash image as a layout file of
+// "flashrom -l", named as in the layouts of "flashrom --ifd". The regions
+// can then be written alone with "flashrom -l FILE -i NAME".
+type FlashromLayout struct {
+	// Original, when set, restricts the layout to the regions which differ
+	// from this image, such as the image before it was modified, so that
+	// only the modified regions are written.
+	Original []byte
+
+	// The layout is written to this writer.
+	W io.Writer
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *FlashromLayout) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit prints the layout of the flash image f.
+func (v *FlashromLayout) Visit(f uefi.Firmware) error {
+	fi, ok := f.(*uefi.FlashImage)
+	if !ok {
+		return fmt.Errorf("a %T has no flash descriptor regions, only a flash image", f)
+	}
+	// The buffers of a modified tree are only up to date once assembled.
+	if writable(f) == nil {
+		if err := (&Assemble{}).Run(f); err != nil {
+			return err
+		}
+	}
+	image := fi.Buf()
+	if v.Original != nil && len(v.Original) != len(image) {
+		return fmt.Errorf("the original image has %#x bytes, the image %#x", len(v.Original), len(image))
+	}
+
+	var b bytes.Buffer
+	add := func(name string, start, end uint64) {
+		if v.Original != nil && bytes.Equal(v.Original[start:end], image[start:end]) {
+			return
+		}
+		fmt.Fprintf(&b, "%08x:%08x %s\n", start, end-1, name)
+	}
+	add("fd", 0, uefi.FlashDescriptorLength)
+	for _, t := range fi.Regions {
+		r, ok := t.Value.(uefi.Region)
+		if !ok || r.Type() == uefi.RegionTypeUnknown || r.FlashRegion() == nil {
+			continue
+		}
+		fr := r.FlashRegion()
+		add(flashromRegionName(r.Type()), uint64(fr.BaseOffset()), uint64(fr.EndOffset()))
+	}
+	_, err := v.W.Write(b.Bytes())
+	return err
+}
+
+func init() {
+	RegisterCLI("flashrom-layout", "print a flashrom -l layout file of the flash descriptor regions", 0, func(args []string) (uefi.Visitor, error) {
+		return &FlashromLayout{W: os.Stdout}, nil
+	})
+	RegisterCLI("flashrom-layout-changed", "flashrom-layout-changed ORIGINAL\n print a flashrom -l layout file of the flash descriptor regions which differ from the image file ORIGINAL", 1, func(args []string) (uefi.Visitor, error) {
+		original, err := os.ReadFile(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &FlashromLayout{Original: original, W: os.Stdout}, nil
+	})
+}
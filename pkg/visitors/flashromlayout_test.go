@@ -0,0 +1,45 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFlashromLayout(t *testing.T) {
+	image, f := parseTwoChipImage(t)
+	original := append([]byte{}, image...)
+	for _, tt := range []struct {
+		name     string
+		original func() []byte
+		want     string
+	}{
+		{"all", func() []byte { return nil }, "00000000:00000fff fd\n00080000:000fffff bios\n"},
+		{"unchanged", func() []byte { return original }, ""},
+		{"bios", func() []byte {
+			o := append([]byte{}, original...)
+			o[len(o)-1] ^= 0xff
+			return o
+		}, "00080000:000fffff bios\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := (&FlashromLayout{Original: tt.original(), W: &b}).Run(f); err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != tt.want {
+				t.Errorf("got %q, want %q", b.String(), tt.want)
+			}
+		})
+	}
+
+	if err := (&FlashromLayout{Original: original[:1], W: &bytes.Buffer{}}).Run(f); err == nil {
+		t.Errorf("compared with an image of another size")
+	}
+	if err := (&FlashromLayout{W: &bytes.Buffer{}}).Run(parseImage(t)); err == nil {
+		t.Errorf("printed the layout of a BIOS region")
+	}
+}
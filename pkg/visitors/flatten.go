@@ -5,7 +5,6 @@
 package visitors
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -60,12 +59,7 @@ func (v *Flatten) Run(f uefi.Firmware) error {
 
 	// Optionally print as JSON
 	if v.W != nil {
-		b, err := json.MarshalIndent(v.List, "", "\t")
-		if err != nil {
-			return err
-		}
-		_, err = fmt.Fprintln(v.W, string(b))
-		return err
+		return writeStructured(v.W, v.List)
 	}
 	return nil
 }
@@ -11,13 +11,14 @@ import (
 
 	"github.com/linuxboot/fiano/pkg/guid"
 	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uefi/uefitest"
 )
 
 // This GUID exists somewhere in the OVMF image.
 var testGUID = guid.MustParse("DF1CCEF6-F301-4A63-9661-FC6030DCC880")
 var dxeCoreGUID = guid.MustParse("D6A2CB7F-6A18-4E2F-B43B-9920A733700A")
 
-func parseImage(t *testing.T) uefi.Firmware {
+func parseImage(t testing.TB) uefi.Firmware {
 	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
 	if err != nil {
 		t.Fatal(err)
@@ -50,3 +51,17 @@ func comment(t *testing.T, f uefi.Firmware) []uefi.Firmware {
 	}
 	return nil
 }
+
+const testChipSize = uefitest.ChipSize
+
+// parseTwoChipImage parses an image held by two chips of 512KiB, whose BIOS
+// region fills the second chip and starts with sampleFV.
+func parseTwoChipImage(t *testing.T) ([]byte, uefi.Firmware) {
+	t.Helper()
+	image := uefitest.TwoChipImage(sampleFV)
+	f, err := uefi.Parse(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return image, f
+}
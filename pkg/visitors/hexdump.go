@@ -0,0 +1,203 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/log"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// HexdumpOutput is the structured output of Hexdump.
+type HexdumpOutput struct {
+	Path string
+	// Offset of the first byte, in the flash if Absolute is set and in
+	// the node otherwise.
+	Offset   uint64
+	Absolute bool
+	// Chip is the flash chip in which the dump starts, only known with an
+	// absolute Offset into an image spanning several chips.
+	Chip *uefi.ChipLocation `json:",omitempty"`
+	Data uefi.HexBytes
+}
+
+// Hexdump prints the content of a node in the format of "hexdump -C", with
+// the flash offsets in the left column. The offsets are relative to the node
+// when it is in a compressed section.
+type Hexdump struct {
+	// Input
+	// Path selects the node by its path in the tree, as in the errors,
+	// e.g. "FV 8C8CE578-8A3D-4F1C-9935-896185C32DD3/File D6A2CB7F-6A18-4E2F-B43B-9920A733700A".
+	// Predicate is used when it is empty.
+	Path      string
+	Predicate FindPredicate
+	// Start and Length limit the dump to a range of the node. A Length of
+	// 0 dumps up to the end.
+	Start, Length uint64
+
+	// The dump is written to this writer.
+	W io.Writer
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Hexdump) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit dumps the node selected in the tree of f.
+func (v *Hexdump) Visit(f uefi.Firmware) error {
+	var (
+		node uefi.Firmware
+		err  error
+	)
+	if v.Path != "" {
+		node, err = findPath(f, v.Path)
+	} else {
+		node, err = FindExactlyOne(f, v.Predicate)
+	}
+	if err != nil {
+		return err
+	}
+	path, offset, _ := uefi.Locate(f, node)
+
+	buf := node.Buf()
+	if v.Start > uint64(len(buf)) {
+		return fmt.Errorf("start %#x is past the end of the %#x bytes of %s", v.Start, len(buf), uefi.NodeName(node))
+	}
+	buf = buf[v.Start:]
+	if v.Length != 0 && v.Length < uint64(len(buf)) {
+		buf = buf[:v.Length]
+	}
+	out := HexdumpOutput{Path: path, Offset: v.Start, Absolute: offset != nil, Data: buf}
+	if offset != nil {
+		out.Offset += *offset
+		if out.Chip = uefi.LocateChip(f, out.Offset); out.Chip != nil {
+			log.With(log.F("path", path)).Infof("starts in %v", out.Chip)
+		}
+	} else {
+		log.With(log.F("path", path)).Warnf("in a compressed section, the offsets are relative to it")
+	}
+
+	if structuredOutput() {
+		return writeStructured(v.W, out)
+	}
+	return writeHexdump(v.W, out.Offset, buf)
+}
+
+// writeHexdump writes buf as "hexdump -C" does, numbering the lines from
+// offset.
+func writeHexdump(w io.Writer, offset uint64, buf []byte) error {
+	for i := 0; i < len(buf); i += 16 {
+		end := i + 16
+		if end > len(buf) {
+			end = len(buf)
+		}
+		// hex.Dump numbers the lines from 0 on 8 digits.
+		if _, err := fmt.Fprintf(w, "%08x%s", offset+uint64(i), hex.Dump(buf[i:end])[8:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findPath returns the node at path in the tree of root. The path must
+// select exactly one node.
+func findPath(root uefi.Firmware, path string) (uefi.Firmware, error) {
+	p := &pathIndex{path: path}
+	if err := root.ApplyChildren(p); err != nil {
+		return nil, err
+	}
+	switch len(p.matches) {
+	case 0:
+		return nil, fmt.Errorf("no node at %q", path)
+	case 1:
+		return p.matches[0], nil
+	}
+	return nil, fmt.Errorf("%d nodes at %q, expected exactly one", len(p.matches), path)
+}
+
+// pathIndex finds the nodes at a path.
+type pathIndex struct {
+	path    string
+	prefix  string
+	matches []uefi.Firmware
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *pathIndex) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the pathIndex visitor to any Firmware type.
+func (v *pathIndex) Visit(f uefi.Firmware) error {
+	p := v.prefix + uefi.NodeName(f)
+	if p == v.path {
+		v.matches = append(v.matches, f)
+		return nil
+	}
+	if len(p) >= len(v.path) || v.path[:len(p)+1] != p+"/" {
+		return nil
+	}
+	v2 := &pathIndex{path: v.path, prefix: p + "/"}
+	err := f.ApplyChildren(v2)
+	v.matches = append(v.matches, v2.matches...)
+	return err
+}
+
+// parseHexdumpRange parses the range of the hexdump-range command.
+func parseHexdumpRange(start, length string) (uint64, uint64, error) {
+	s, err := strconv.ParseUint(start, 0, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	l, err := strconv.ParseUint(length, 0, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	if l == 0 {
+		return 0, 0, errors.New("the length must not be 0")
+	}
+	return s, l, nil
+}
+
+// newHexdump creates the visitor of the hexdump commands. node is a tree path
+// if it contains a slash or a space, such as "FV GUID", and a file GUID or
+// name regexp otherwise.
+func newHexdump(node string) (*Hexdump, error) {
+	v := &Hexdump{W: os.Stdout}
+	if strings.ContainsAny(node, "/ ") {
+		v.Path = node
+		return v, nil
+	}
+	pred, err := FindFilePredicate(node)
+	if err != nil {
+		return nil, err
+	}
+	v.Predicate = pred
+	return v, nil
+}
+
+func init() {
+	RegisterCLI("hexdump", "hexdump NODE\n print the content of the node with flash offsets, NODE is a file GUID or name regexp or a tree path such as \"FV GUID/File GUID\"", 1, func(args []string) (uefi.Visitor, error) {
+		return newHexdump(args[0])
+	})
+	RegisterCLI("hexdump-range", "hexdump-range NODE START LENGTH\n print LENGTH bytes of the node from START, see hexdump", 3, func(args []string) (uefi.Visitor, error) {
+		v, err := newHexdump(args[0])
+		if err != nil {
+			return nil, err
+		}
+		if v.Start, v.Length, err = parseHexdumpRange(args[1], args[2]); err != nil {
+			return nil, err
+		}
+		return v, nil
+	})
+}
@@ -0,0 +1,92 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestHexdump(t *testing.T) {
+	f := parseImage(t)
+	file := find(t, f, testGUID)[0]
+	path, offset, _ := uefi.Locate(f, file)
+	if offset == nil {
+		t.Fatalf("%s has no flash offset", path)
+	}
+
+	for _, v := range []*Hexdump{
+		{Predicate: FindFileGUIDPredicate(*testGUID), Start: 0x10, Length: 0x18},
+		{Path: path, Start: 0x10, Length: 0x18},
+	} {
+		var b bytes.Buffer
+		v.W = &b
+		if err := v.Run(f); err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("got %d lines, want 2:\n%s", len(lines), b.String())
+		}
+		buf := file.Buf()[0x10:0x28]
+		for i, line := range lines {
+			if want := fmt.Sprintf("%08x  %02x", *offset+0x10+uint64(16*i), buf[16*i]); !strings.HasPrefix(line, want) {
+				t.Errorf("got line %q, want it to start with %q", line, want)
+			}
+		}
+	}
+
+	OutputFormat = FormatJSON
+	defer func() { OutputFormat = FormatText }()
+	var b bytes.Buffer
+	if err := (&Hexdump{Path: path, W: &b}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	var out HexdumpOutput
+	if err := json.Unmarshal(b.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Path != path || out.Offset != *offset || !out.Absolute || !bytes.Equal(out.Data, file.Buf()) {
+		t.Errorf("got %+v", out)
+	}
+}
+
+func TestHexdumpErrors(t *testing.T) {
+	f := parseImage(t)
+	for _, v := range []*Hexdump{
+		{Path: "FV 00000000-0000-0000-0000-000000000000"},
+		{Predicate: FindFileGUIDPredicate(*testGUID), Start: 1 << 32},
+	} {
+		v.W = &bytes.Buffer{}
+		if err := v.Run(f); err == nil {
+			t.Errorf("%+v: dumped", v)
+		}
+	}
+	// DXE core is in a compressed section.
+	var b bytes.Buffer
+	if err := (&Hexdump{Predicate: FindFileGUIDPredicate(*dxeCoreGUID), Length: 1, W: &b}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(b.String(), "00000000  ") {
+		t.Errorf("got %q, want offsets relative to the file", b.String())
+	}
+}
+
+func TestNewHexdump(t *testing.T) {
+	if v, err := newHexdump("FV 8C8CE578-8A3D-4F1C-9935-896185C32DD3"); err != nil || v.Path == "" {
+		t.Errorf("got %+v, %v, want a path", v, err)
+	}
+	if v, err := newHexdump("Shell"); err != nil || v.Predicate == nil {
+		t.Errorf("got %+v, %v, want a predicate", v, err)
+	}
+	if _, err := ParseCLI([]string{"hexdump-range", "Shell", "0", "0"}); err == nil {
+		t.Errorf("parsed an empty range")
+	}
+}
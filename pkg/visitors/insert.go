@@ -177,8 +177,16 @@ type Insert struct {
 	FileMatch uefi.Firmware
 }
 
+// Mutates implements Mutator.
+func (v *Insert) Mutates() bool {
+	return true
+}
+
 // Run wraps Visit and performs some setup and teardown tasks.
 func (v *Insert) Run(f uefi.Firmware) error {
+	if err := writable(f); err != nil {
+		return err
+	}
 	// First run "find" to generate a position to insert into.
 	find := Find{
 		Predicate: v.Predicate,
@@ -0,0 +1,304 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/fit"
+	"github.com/linuxboot/fiano/pkg/intel/microcode"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// MicrocodeFileGUID is the GUID of the raw file holding the microcode
+// updates in EDK2 based images.
+var MicrocodeFileGUID = *guid.MustParse("197DB236-F856-4924-90F8-CDF12FB875F3")
+
+// microcodeAlignment is the alignment of microcode updates required by the
+// FIT specification.
+const microcodeAlignment = 16
+
+// InsertMicrocode places a microcode update into the microcode file. An
+// existing update for the same processor signature and flags is replaced,
+// otherwise the update is added after the existing ones. The FIT microcode
+// entries (type 0x01) are then rewritten to point to every update in the
+// file.
+type InsertMicrocode struct {
+	// Input
+	Microcode []byte
+	// Predicate selects the microcode file. It defaults to MicrocodeFileGUID.
+	Predicate FindPredicate
+
+	// Output
+	// Offsets of all updates in the microcode file, relative to the flash
+	// image.
+	Offsets []uint64
+}
+
+// Mutates implements Mutator.
+func (v *InsertMicrocode) Mutates() bool {
+	return true
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *InsertMicrocode) Run(f uefi.Firmware) error {
+	if err := writable(f); err != nil {
+		return err
+	}
+	if _, err := microcode.ParseIntelMicrocode(bytes.NewReader(v.Microcode)); err != nil {
+		return fmt.Errorf("invalid microcode update: %v", err)
+	}
+	pred := v.Predicate
+	if pred == nil {
+		pred = FindFileGUIDPredicate(MicrocodeFileGUID)
+	}
+	m, err := FindExactlyOne(f, pred)
+	if err != nil {
+		return fmt.Errorf("unable to find microcode file: %v", err)
+	}
+	file, ok := m.(*uefi.File)
+	if !ok {
+		return fmt.Errorf("microcode must be stored in a file, got %T", m)
+	}
+	// The FIT points to flash offsets, which the file lacks when it is
+	// compressed.
+	if _, err := fileOffset(f, file); err != nil {
+		return err
+	}
+
+	body, offsets, err := placeMicrocode(file.Buf()[file.DataOffset:], v.Microcode)
+	if err != nil {
+		return err
+	}
+	if err := setFileBody(file, body); err != nil {
+		return err
+	}
+	if err := (&Assemble{}).Run(f); err != nil {
+		return err
+	}
+
+	base, err := fileOffset(f, file)
+	if err != nil {
+		return err
+	}
+	v.Offsets = nil
+	for _, o := range offsets {
+		v.Offsets = append(v.Offsets, base+file.DataOffset+o)
+	}
+	return updateFITMicrocode(f, v.Offsets)
+}
+
+// fileOffset returns the offset of file in the image of root.
+func fileOffset(root uefi.Firmware, file *uefi.File) (uint64, error) {
+	path, offset, ok := uefi.Locate(root, file)
+	if !ok {
+		return 0, errors.New("unable to locate the microcode file in the image")
+	}
+	if offset == nil {
+		return 0, fmt.Errorf("the microcode file %s has no flash offset, as it is compressed", path)
+	}
+	return *offset, nil
+}
+
+// Visit applies the InsertMicrocode visitor to any Firmware type.
+func (v *InsertMicrocode) Visit(f uefi.Firmware) error {
+	return nil
+}
+
+// placeMicrocode places the microcode update mc into the body of the
+// microcode file. The body is a sequence of updates followed by free space.
+// The updates after a replaced one move into the free space, and the body
+// only grows when the free space is too small. It returns the new body and
+// the offsets of all updates in it.
+func placeMicrocode(body, mc []byte) ([]byte, []uint64, error) {
+	newHdr, err := readMicrocodeHeader(mc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bodyLen := uint64(len(body))
+	body = append([]byte{}, body...)
+	var offsets []uint64
+	replaced := false
+	offset := uint64(0)
+	for offset < uint64(len(body)) {
+		hdr, err := readMicrocodeHeader(body[offset:])
+		if err != nil || hdr.HeaderVersion != 1 {
+			// Reached the free space.
+			break
+		}
+		size, err := microcodeSize(hdr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("microcode update at %#x: %v", offset, err)
+		}
+		if offset+size > uint64(len(body)) {
+			return nil, nil, fmt.Errorf("microcode update at %#x exceeds the file", offset)
+		}
+		if hdr.HeaderProcessorSignature == newHdr.HeaderProcessorSignature &&
+			hdr.HeaderProcessorFlags == newHdr.HeaderProcessorFlags {
+			// Replace the update, moving the following ones.
+			tail := append([]byte{}, body[offset+size:]...)
+			body = append(append(body[:offset], alignMicrocode(mc)...), tail...)
+			size = uint64(len(alignMicrocode(mc)))
+			replaced = true
+		}
+		offsets = append(offsets, offset)
+		offset += uefi.Align(size, microcodeAlignment)
+	}
+
+	if !replaced {
+		free := body[offset:]
+		mc = alignMicrocode(mc)
+		if uint64(len(free)) >= uint64(len(mc)) && uefi.IsErased(free[:len(mc)], uefi.Attributes.ErasePolarity) {
+			copy(free, mc)
+		} else {
+			body = append(body[:offset], mc...)
+		}
+		offsets = append(offsets, offset)
+		offset += uint64(len(mc))
+	}
+
+	// Keep the size of the body unless the updates need more, and erase
+	// whatever is left after the last update.
+	if offset > bodyLen {
+		bodyLen = offset
+	}
+	if uint64(len(body)) < bodyLen {
+		body = append(body, make([]byte, bodyLen-uint64(len(body)))...)
+	}
+	body = body[:bodyLen]
+	uefi.Erase(body[offset:], uefi.Attributes.ErasePolarity)
+	return body, offsets, nil
+}
+
+// microcodeSize returns the total size of the microcode update of header
+// hdr, checking that it holds the header and the data.
+func microcodeSize(hdr microcode.Header) (uint64, error) {
+	size := uint64(hdr.TotalSize())
+	min := uint64(binary.Size(hdr))
+	if hdr.HeaderDataSize > 0 {
+		min += uint64(hdr.HeaderDataSize)
+	}
+	if size < min {
+		return 0, fmt.Errorf("total size %#x is smaller than the header and data, %#x bytes", size, min)
+	}
+	return size, nil
+}
+
+func readMicrocodeHeader(b []byte) (microcode.Header, error) {
+	var hdr microcode.Header
+	if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, &hdr); err != nil {
+		return hdr, fmt.Errorf("unable to read microcode header: %v", err)
+	}
+	return hdr, nil
+}
+
+// alignMicrocode pads the microcode with erased bytes to the microcode
+// alignment.
+func alignMicrocode(mc []byte) []byte {
+	padded := make([]byte, uefi.Align(uint64(len(mc)), microcodeAlignment))
+	uefi.Erase(padded, uefi.Attributes.ErasePolarity)
+	copy(padded, mc)
+	return padded
+}
+
+// setFileBody replaces the data of a file without sections, fixing up the
+// size and checksums.
+func setFileBody(file *uefi.File, body []byte) error {
+	if len(file.Sections) != 0 || file.NVarStore != nil {
+		return fmt.Errorf("file %v has sections, expected a raw file", file.Header.GUID)
+	}
+	file.SetSize(uefi.FileHeaderMinLength+uint64(len(body)), true)
+	return file.ChecksumAndAssemble(body)
+}
+
+// updateFITMicrocode replaces the microcode entries of the FIT with entries
+// pointing to the given flash offsets. The FIT is patched in the file which
+// contains it.
+func updateFITMicrocode(f uefi.Firmware, offsets []uint64) error {
+	image := f.Buf()
+	table, err := fit.GetTable(image)
+	if err != nil {
+		return err
+	}
+	if len(table) == 0 || table[0].Type() != fit.EntryTypeFITHeaderEntry {
+		return errors.New("the first FIT entry should be of type 0x00")
+	}
+	oldTable := new(bytes.Buffer)
+	if _, err := table.WriteTo(oldTable); err != nil {
+		return err
+	}
+
+	// Entries are sorted by type, so microcode entries go right after the
+	// header entry.
+	newTable := fit.Table{table[0]}
+	for _, o := range offsets {
+		hdr := fit.EntryHeaders{Version: fit.EntryVersion(0x0100)}
+		hdr.TypeAndIsChecksumValid.SetType(fit.EntryTypeMicrocodeUpdateEntry)
+		hdr.Address.SetOffset(o, uint64(len(image)))
+		newTable = append(newTable, hdr)
+	}
+	for _, e := range table[1:] {
+		if e.Type() != fit.EntryTypeMicrocodeUpdateEntry {
+			newTable = append(newTable, e)
+		}
+	}
+	newTable[0].Size.SetUint32(uint32(len(newTable)))
+	if newTable[0].IsChecksumValid() {
+		newTable[0].Checksum = 0
+		b := new(bytes.Buffer)
+		if _, err := newTable.WriteTo(b); err != nil {
+			return err
+		}
+		newTable[0].Checksum = 0 - uefi.Checksum8(b.Bytes())
+	}
+	newTableBuf := new(bytes.Buffer)
+	if _, err := newTable.WriteTo(newTableBuf); err != nil {
+		return err
+	}
+
+	// Find the file containing the FIT and patch it.
+	m, err := FindExactlyOne(f, func(f uefi.Firmware) bool {
+		file, ok := f.(*uefi.File)
+		return ok && len(file.Sections) == 0 && file.NVarStore == nil &&
+			bytes.Contains(file.Buf(), oldTable.Bytes())
+	})
+	if err != nil {
+		return fmt.Errorf("unable to find the file containing the FIT: %v", err)
+	}
+	file := m.(*uefi.File)
+	body := append([]byte{}, file.Buf()[file.DataOffset:]...)
+	start := bytes.Index(body, oldTable.Bytes())
+	end := start + newTableBuf.Len()
+	if end > len(body) {
+		return errors.New("not enough space in the FIT file for the new entries")
+	}
+	if grown := body[start+oldTable.Len() : end]; len(grown) > 0 &&
+		!uefi.IsErased(grown, 0xFF) && !uefi.IsErased(grown, 0x00) {
+		return errors.New("not enough free space after the FIT for the new entries")
+	}
+	copy(body[start:], newTableBuf.Bytes())
+	if err := setFileBody(file, body); err != nil {
+		return err
+	}
+	return (&Assemble{}).Run(f)
+}
+
+func init() {
+	RegisterCLI("insert_microcode", "insert or replace a microcode update in the microcode file and update the FIT", 1, func(args []string) (uefi.Visitor, error) {
+		mc, err := os.ReadFile(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &InsertMicrocode{
+			Microcode: mc,
+		}, nil
+	})
+}
@@ -0,0 +1,166 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+	"github.com/linuxboot/fiano/pkg/intel/microcode"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// makeMicrocode builds a valid microcode update with dataSize bytes of data.
+func makeMicrocode(t *testing.T, sig, pf, rev uint32, dataSize int) []byte {
+	hdr := microcode.Header{
+		HeaderVersion:            1,
+		HeaderRevision:           rev,
+		HeaderProcessorSignature: sig,
+		HeaderLoaderRevision:     1,
+		HeaderProcessorFlags:     pf,
+		HeaderDataSize:           uint32(dataSize),
+		HeaderTotalSize:          uint32(dataSize + binary.Size(microcode.Header{})),
+	}
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, hdr); err != nil {
+		t.Fatal(err)
+	}
+	buf.Write(bytes.Repeat([]byte{byte(rev)}, dataSize))
+	b := buf.Bytes()
+	var sum uint32
+	for i := 0; i < len(b); i += 4 {
+		sum += binary.LittleEndian.Uint32(b[i:])
+	}
+	binary.LittleEndian.PutUint32(b[16:], 0-sum)
+	if _, err := microcode.ParseIntelMicrocode(bytes.NewReader(b)); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestPlaceMicrocode(t *testing.T) {
+	mcA := makeMicrocode(t, 0x806ec, 0x94, 1, 16)
+	mcB := makeMicrocode(t, 0x906ea, 0x22, 1, 32)
+	mcA2 := makeMicrocode(t, 0x806ec, 0x94, 2, 48)
+
+	uefi.Attributes.ErasePolarity = 0xFF
+	free := make([]byte, 0x200)
+	uefi.Erase(free, 0xFF)
+
+	// Insert into an empty file.
+	body, offsets, err := placeMicrocode(free, mcA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(offsets, []uint64{0}) || len(body) != len(free) {
+		t.Fatalf("got offsets %v and %d bytes", offsets, len(body))
+	}
+
+	// Append a second update.
+	body, offsets, err = placeMicrocode(body, mcB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint64{0, 0x40}; !reflect.DeepEqual(offsets, want) {
+		t.Fatalf("got offsets %v, want %v", offsets, want)
+	}
+
+	// Replace the first update with a larger one, moving the second.
+	body, offsets, err = placeMicrocode(body, mcA2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint64{0, 0x60}; !reflect.DeepEqual(offsets, want) {
+		t.Fatalf("got offsets %v, want %v", offsets, want)
+	}
+	if len(body) != len(free) {
+		t.Errorf("got %#x bytes, want the free space to absorb the growth in %#x bytes", len(body), len(free))
+	}
+	if !bytes.Equal(body[:len(mcA2)], mcA2) || !bytes.Equal(body[0x60:0x60+len(mcB)], mcB) {
+		t.Errorf("microcode updates not in place")
+	}
+	if !uefi.IsErased(body[0x60+len(mcB):], 0xFF) {
+		t.Errorf("free space is not erased")
+	}
+
+	// Grow the file when there is no free space.
+	mcC := makeMicrocode(t, 0xa0671, 0x02, 1, 16)
+	body, offsets, err = placeMicrocode(body[:0x60+len(mcB)], mcC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint64{0, 0x60, 0xb0}; !reflect.DeepEqual(offsets, want) {
+		t.Fatalf("got offsets %v, want %v", offsets, want)
+	}
+	if !bytes.Equal(body[0xb0:], mcC) {
+		t.Errorf("microcode update not appended")
+	}
+}
+
+func TestPlaceMicrocodeMalformed(t *testing.T) {
+	uefi.Attributes.ErasePolarity = 0xFF
+	mc := makeMicrocode(t, 0x806ec, 0x94, 1, 16)
+	for _, tt := range []struct {
+		name      string
+		totalSize uint32
+	}{
+		{"past the file", 0x1000},
+		{"total size of 0", 0},
+		{"total size smaller than the data", 0x30},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			body := make([]byte, 0x200)
+			uefi.Erase(body, 0xFF)
+			copy(body, makeMicrocode(t, 0x906ea, 0x22, 1, 32))
+			binary.LittleEndian.PutUint32(body[32:], tt.totalSize)
+			if _, _, err := placeMicrocode(body, mc); err == nil {
+				t.Errorf("got no error for an existing update of total size %#x", tt.totalSize)
+			}
+			if comps := microcodeComponents(body); len(comps) != 0 {
+				t.Errorf("got SBOM components %v", comps)
+			}
+		})
+	}
+}
+
+func TestInsertMicrocodeNoFile(t *testing.T) {
+	f := parseImage(t)
+	v := &InsertMicrocode{Microcode: makeMicrocode(t, 0x806ec, 0x94, 1, 16)}
+	if err := v.Run(f); err == nil {
+		t.Errorf("expected error, OVMF has no microcode file")
+	}
+}
+
+func TestPlaceMicrocodeErasePolarity(t *testing.T) {
+	defer func(ep byte) { uefi.Attributes.ErasePolarity = ep }(uefi.Attributes.ErasePolarity)
+	uefi.Attributes.ErasePolarity = 0x00
+	mc := makeMicrocode(t, 0x806ec, 0x94, 1, 20)
+	body, _, err := placeMicrocode(make([]byte, 0x100), mc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body[:len(mc)], mc) || !uefi.IsErased(body[len(mc):], 0x00) {
+		t.Errorf("the microcode update is not padded and followed by erased bytes")
+	}
+}
+
+func TestMicrocodeFileOffset(t *testing.T) {
+	f := parseImage(t)
+	volume := find(t, f, guid.MustParse("9E21FD93-9C72-4C15-8C4B-E77F1DB2D792"))[0].(*uefi.File)
+	offset, err := fileOffset(f, volume)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(f.Buf()[offset:], volume.Buf()) {
+		t.Errorf("the file is not at %#x", offset)
+	}
+	// The files of a compressed volume have no flash offset.
+	if _, err := fileOffset(f, find(t, f, dxeCoreGUID)[0].(*uefi.File)); err == nil {
+		t.Errorf("got an offset for a compressed file")
+	}
+}
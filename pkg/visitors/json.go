@@ -5,8 +5,6 @@
 package visitors
 
 import (
-	"encoding/json"
-	"fmt"
 	"io"
 	"os"
 
@@ -26,12 +24,7 @@ func (v *JSON) Run(f uefi.Firmware) error {
 
 // Visit applies the JSON visitor to any Firmware type.
 func (v *JSON) Visit(f uefi.Firmware) error {
-	b, err := json.MarshalIndent(f, "", "\t")
-	if err != nil {
-		return err
-	}
-	fmt.Fprintln(v.W, string(b))
-	return nil
+	return writeStructured(v.W, f)
 }
 
 func init() {
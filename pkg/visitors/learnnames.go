@@ -0,0 +1,78 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+	"github.com/linuxboot/fiano/pkg/knownguids"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// LearnNames collects the names of the UI sections of the files, by file
+// GUID, so that files without a UI section in other images can be named.
+type LearnNames struct {
+	// Input
+	// The mapping is written to this writer, as by knownguids.WriteNames.
+	W io.Writer
+	// Path, if set and W is nil, is the file the mapping is written to.
+	Path string
+	// Learn adds the names to knownguids.GUIDs for the operations which
+	// follow.
+	Learn bool
+
+	// Output
+	Names map[guid.GUID]string
+	// Learned is the number of names which were added to knownguids.GUIDs.
+	Learned int
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *LearnNames) Run(f uefi.Firmware) error {
+	v.Names = map[guid.GUID]string{}
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	if v.Learn {
+		v.Learned = knownguids.Learn(v.Names)
+	}
+	if v.W != nil {
+		return knownguids.WriteNames(v.W, v.Names)
+	}
+	if v.Path != "" {
+		var b bytes.Buffer
+		if err := knownguids.WriteNames(&b, v.Names); err != nil {
+			return err
+		}
+		return writeFile(v.Path, b.Bytes())
+	}
+	return nil
+}
+
+// Visit applies the LearnNames visitor to any Firmware type.
+func (v *LearnNames) Visit(f uefi.Firmware) error {
+	if f, ok := f.(*uefi.File); ok {
+		// Files with the same GUID in several volumes usually have the
+		// same name, the first one is kept.
+		if name := FileUIName(f); name != "" {
+			if _, ok := v.Names[f.Header.GUID]; !ok {
+				v.Names[f.Header.GUID] = name
+			}
+		}
+	}
+	return f.ApplyChildren(v)
+}
+
+func init() {
+	RegisterCLI("learn-names", "write the GUID and UI section name of each file to a file, or stdout for \"-\", and name the GUIDs for the following operations", 1, func(args []string) (uefi.Visitor, error) {
+		if args[0] == StdioPath {
+			return &LearnNames{W: os.Stdout, Learn: true}, nil
+		}
+		return &LearnNames{Path: args[0], Learn: true}, nil
+	})
+}
@@ -0,0 +1,40 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/knownguids"
+)
+
+func TestLearnNames(t *testing.T) {
+	f := parseImage(t)
+
+	var b bytes.Buffer
+	v := &LearnNames{W: &b}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if n := v.Names[*dxeCoreGUID]; n != "DxeCore" {
+		t.Errorf("got DXE core named %q, want DxeCore", n)
+	}
+	if v.Learned != 0 {
+		t.Errorf("learned %d names without Learn", v.Learned)
+	}
+	names, err := knownguids.ReadNames(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != len(v.Names) {
+		t.Errorf("wrote %d names, want %d", len(names), len(v.Names))
+	}
+	for g, n := range v.Names {
+		if names[g] != n {
+			t.Errorf("%v: wrote %q, want %q", g, names[g], n)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"crypto"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	bytes2 "github.com/linuxboot/fiano/pkg/bytes"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// MeasureAlgorithms lists the supported digest algorithms.
+var MeasureAlgorithms = map[string]crypto.Hash{
+	"sha1":   crypto.SHA1,
+	"sha256": crypto.SHA256,
+	"sha384": crypto.SHA384,
+	"sha512": crypto.SHA512,
+}
+
+// Measurement is a digest over a part of the image.
+type Measurement struct {
+	Name      string
+	Algorithm string
+	Digest    string
+	// Ranges which were hashed, relative to the measured node.
+	Ranges bytes2.Ranges
+}
+
+// Measure computes digests which can be used as reference measurements
+// for attestation. It measures, in order:
+//   - the concatenation of Ranges of the top level image, if set,
+//   - every node matching Predicate, if set,
+//   - the BIOS region without its NVRAM volumes, if BIOS is set.
+type Measure struct {
+	// Input
+	Algorithm string
+	Ranges    bytes2.Ranges
+	Predicate FindPredicate
+	BIOS      bool
+
+	// JSON is written to this writer.
+	W io.Writer
+
+	// Output
+	Measurements []Measurement
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Measure) Run(f uefi.Firmware) error {
+	if _, ok := MeasureAlgorithms[v.Algorithm]; !ok {
+		return fmt.Errorf("unknown digest algorithm %q", v.Algorithm)
+	}
+	v.Measurements = nil
+
+	if len(v.Ranges) != 0 {
+		if err := v.measure("ranges", f.Buf(), v.Ranges); err != nil {
+			return err
+		}
+	}
+	if v.Predicate != nil {
+		find := &Find{Predicate: v.Predicate}
+		if err := find.Run(f); err != nil {
+			return err
+		}
+		if len(find.Matches) == 0 {
+			return errors.New("no matches found for measurement")
+		}
+		for _, m := range find.Matches {
+			name := tableGUID(m)
+			if name == "" {
+				name = strings.TrimPrefix(fmt.Sprintf("%T", m), "*uefi.")
+			}
+			buf := m.Buf()
+			if err := v.measure(name, buf, bytes2.Ranges{{Length: uint64(len(buf))}}); err != nil {
+				return err
+			}
+		}
+	}
+	if v.BIOS {
+		if err := f.Apply(v); err != nil {
+			return err
+		}
+	}
+
+	if v.W != nil {
+		return writeStructured(v.W, v.Measurements)
+	}
+	return nil
+}
+
+// Visit applies the Measure visitor to any Firmware type. It measures the
+// BIOS region.
+func (v *Measure) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.BIOSRegion:
+		bios := bytes2.Range{Length: uint64(len(f.Buf()))}
+		var nvram bytes2.Ranges
+		for _, e := range f.Elements {
+			fv, ok := e.Value.(*uefi.FirmwareVolume)
+			if ok && isNVRAMVolume(fv) {
+				nvram = append(nvram, bytes2.Range{Offset: fv.FVOffset, Length: fv.Length})
+			}
+		}
+		return v.measure("BIOS", f.Buf(), bios.Exclude(nvram...))
+	}
+	return f.ApplyChildren(v)
+}
+
+func (v *Measure) measure(name string, buf []byte, ranges bytes2.Ranges) error {
+	h := newMeasureHash(v.Algorithm)
+	for _, r := range ranges {
+		if r.End() > uint64(len(buf)) || r.End() < r.Offset {
+			return fmt.Errorf("range %v is outside of %s (%#x bytes)", r, name, len(buf))
+		}
+		h.Write(buf[r.Offset:r.End()])
+	}
+	v.Measurements = append(v.Measurements, Measurement{
+		Name:      name,
+		Algorithm: v.Algorithm,
+		Digest:    hex.EncodeToString(h.Sum(nil)),
+		Ranges:    ranges,
+	})
+	return nil
+}
+
+func newMeasureHash(algorithm string) hash.Hash {
+	switch MeasureAlgorithms[algorithm] {
+	case crypto.SHA1:
+		return sha1.New()
+	case crypto.SHA384:
+		return sha512.New384()
+	case crypto.SHA512:
+		return sha512.New()
+	}
+	return sha256.New()
+}
+
+// isNVRAMVolume returns true for volumes holding mutable variable stores,
+// which do not belong in reference measurements.
+func isNVRAMVolume(fv *uefi.FirmwareVolume) bool {
+	switch fv.FileSystemGUID {
+	case *uefi.EVSA, *uefi.EVSA2, *uefi.NVAR:
+		return true
+	}
+	return false
+}
+
+// parseRanges parses a comma-separated list of OFFSET:LENGTH ranges.
+func parseRanges(s string) (bytes2.Ranges, error) {
+	var ranges bytes2.Ranges
+	for _, r := range strings.Split(s, ",") {
+		offset, length, ok := strings.Cut(r, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid range %q, expected OFFSET:LENGTH", r)
+		}
+		o, err := strconv.ParseUint(offset, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid range offset %q: %v", offset, err)
+		}
+		l, err := strconv.ParseUint(length, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid range length %q: %v", length, err)
+		}
+		ranges = append(ranges, bytes2.Range{Offset: o, Length: l})
+	}
+	return ranges, nil
+}
+
+func init() {
+	RegisterCLI("measure", "print digests of the nodes matching a GUID or NAME: measure ALGORITHM (GUID|NAME)", 2, func(args []string) (uefi.Visitor, error) {
+		pred, err := FindFileFVPredicate(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return &Measure{
+			Algorithm: args[0],
+			Predicate: pred,
+			W:         os.Stdout,
+		}, nil
+	})
+	RegisterCLI("measure-ranges", "print the digest of comma-separated flash ranges: measure-ranges ALGORITHM OFFSET:LENGTH[,...]", 2, func(args []string) (uefi.Visitor, error) {
+		ranges, err := parseRanges(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return &Measure{
+			Algorithm: args[0],
+			Ranges:    ranges,
+			W:         os.Stdout,
+		}, nil
+	})
+	RegisterCLI("measure-bios", "print the digest of the BIOS region excluding NVRAM volumes: measure-bios ALGORITHM", 1, func(args []string) (uefi.Visitor, error) {
+		return &Measure{
+			Algorithm: args[0],
+			BIOS:      true,
+			W:         os.Stdout,
+		}, nil
+	})
+}
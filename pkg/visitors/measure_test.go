@@ -0,0 +1,72 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	bytes2 "github.com/linuxboot/fiano/pkg/bytes"
+)
+
+func TestMeasureRanges(t *testing.T) {
+	f := parseImage(t)
+
+	ranges, err := parseRanges("0x0:0x10,0x100:0x20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Measure{Algorithm: "sha256", Ranges: ranges}
+	if err := m.Run(f); err != nil {
+		t.Fatal(err)
+	}
+
+	h := sha256.New()
+	h.Write(f.Buf()[0x0:0x10])
+	h.Write(f.Buf()[0x100:0x120])
+	if got, want := m.Measurements[0].Digest, hex.EncodeToString(h.Sum(nil)); got != want {
+		t.Errorf("got digest %s, want %s", got, want)
+	}
+}
+
+func TestMeasureBIOS(t *testing.T) {
+	f := parseImage(t)
+
+	m := &Measure{Algorithm: "sha384", BIOS: true}
+	if err := m.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Measurements) != 1 {
+		t.Fatalf("got %d measurements, want 1", len(m.Measurements))
+	}
+	// The NVRAM volume at the start of OVMF is excluded.
+	want := bytes2.Ranges{{Offset: 0x84000, Length: uint64(len(f.Buf())) - 0x84000}}
+	if got := m.Measurements[0].Ranges; got.String() != want.String() {
+		t.Errorf("got ranges %v, want %v", got, want)
+	}
+	if len(m.Measurements[0].Digest) != 96 {
+		t.Errorf("got digest %q, expected 48 bytes of hex", m.Measurements[0].Digest)
+	}
+}
+
+func TestMeasurePredicate(t *testing.T) {
+	f := parseImage(t)
+
+	m := &Measure{Algorithm: "sha1", Predicate: FindFileGUIDPredicate(*dxeCoreGUID)}
+	if err := m.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Measurements) != 1 || m.Measurements[0].Name != dxeCoreGUID.String() {
+		t.Fatalf("got measurements %+v, want DxeCore", m.Measurements)
+	}
+}
+
+func TestMeasureUnknownAlgorithm(t *testing.T) {
+	f := parseImage(t)
+	if err := (&Measure{Algorithm: "md5", BIOS: true}).Run(f); err == nil {
+		t.Errorf("expected error for unknown algorithm")
+	}
+}
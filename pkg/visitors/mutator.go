@@ -0,0 +1,54 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// ErrReadOnly is returned when a visitor which modifies the firmware is
+// applied to a tree parsed with uefi.ParseModeReadOnly.
+var ErrReadOnly = errors.New("the firmware was parsed read-only")
+
+// Mutator is implemented by the visitors which modify the firmware. They
+// refuse to run on read-only trees, which the other visitors may then share
+// concurrently. Parse the image with uefi.ParseModeCopyOnWrite to modify it
+// without copying it upfront.
+type Mutator interface {
+	// Mutates tells whether the visitor modifies the firmware.
+	Mutates() bool
+}
+
+// mutates tells whether the visitor v modifies the firmware.
+func mutates(v uefi.Visitor) bool {
+	m, ok := v.(Mutator)
+	return ok && m.Mutates()
+}
+
+// writable returns ErrReadOnly if f was parsed read-only.
+func writable(f uefi.Firmware) error {
+	if uefi.TreeMode(f) == uefi.ParseModeReadOnly {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// CheckWritable returns an error wrapping ErrReadOnly if one of the visitors
+// modifies f and f was parsed read-only. ExecuteCLI checks it before applying
+// any visitor.
+func CheckWritable(f uefi.Firmware, v []uefi.Visitor) error {
+	if writable(f) == nil {
+		return nil
+	}
+	for i := range v {
+		if mutates(v[i]) {
+			return fmt.Errorf("%T: %w", v[i], ErrReadOnly)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func parseImageWithMode(t *testing.T, mode uefi.ParseMode) ([]byte, uefi.Firmware) {
+	t.Helper()
+	image, err := os.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := uefi.ParseWithMode(context.Background(), image, mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return image, f
+}
+
+func TestReadOnlyRefusesMutators(t *testing.T) {
+	image, f := parseImageWithMode(t, uefi.ParseModeReadOnly)
+
+	count := &Count{}
+	v, err := ParseCLI([]string{"remove", "Shell"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ExecuteCLI(f, append([]uefi.Visitor{count}, v...)); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("got %v, want %v", err, ErrReadOnly)
+	}
+	if len(count.FirmwareTypeCount) != 0 {
+		t.Errorf("a visitor ran before the read-only error")
+	}
+	// Applied directly, the mutating visitors refuse the tree and its nodes.
+	file := find(t, f, dxeCoreGUID)[0]
+	if err := (&Remove{Predicate: FindFileGUIDPredicate(*dxeCoreGUID)}).Run(file); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("got %v, want %v", err, ErrReadOnly)
+	}
+	if err := (&Assemble{}).Run(f); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("got %v, want %v", err, ErrReadOnly)
+	}
+	if err := ExecuteCLI(f, []uefi.Visitor{count}); err != nil {
+		t.Fatal(err)
+	}
+	if count.FirmwareTypeCount["File"] == 0 {
+		t.Errorf("no files counted")
+	}
+	// Saving assembles the image, so it is refused as well.
+	out := filepath.Join(t.TempDir(), "out.rom")
+	save, err := ParseCLI([]string{"save", out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ExecuteCLI(f, save); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("save: got %v, want %v", err, ErrReadOnly)
+	}
+	if err := ExecuteCLI(f, []uefi.Visitor{&SaveChips{Paths: []string{out}}}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("save-chips: got %v, want %v", err, ErrReadOnly)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("the read-only image was saved: %v", err)
+	}
+	if !bytes.Equal(f.Buf(), image) {
+		t.Errorf("the read-only image was modified")
+	}
+}
+
+func TestCopyOnWrite(t *testing.T) {
+	image, f := parseImageWithMode(t, uefi.ParseModeCopyOnWrite)
+	original := append([]byte{}, image...)
+
+	v, err := ParseCLI([]string{"remove", "Shell"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ExecuteCLI(f, append(v, &Assemble{})); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(image, original) {
+		t.Errorf("the image was written to")
+	}
+	if bytes.Equal(f.Buf(), original) {
+		t.Errorf("the tree was not modified")
+	}
+	if len(find(t, f, guid.MustParse("7C04A583-9E3E-4F1C-AD65-E05268D0B4D1"))) != 0 {
+		t.Errorf("the shell was not removed")
+	}
+}
@@ -24,13 +24,22 @@ type NVarInvalidate struct {
 }
 
 func (v *NVarInvalidate) printf(format string, a ...interface{}) {
-	if v.W != nil {
+	// Logs would corrupt structured output.
+	if v.W != nil && !structuredOutput() {
 		fmt.Fprintf(v.W, format, a...)
 	}
 }
 
+// Mutates implements Mutator.
+func (v *NVarInvalidate) Mutates() bool {
+	return true
+}
+
 // Run uses find and wraps Visit.
 func (v *NVarInvalidate) Run(f uefi.Firmware) error {
+	if err := writable(f); err != nil {
+		return err
+	}
 	// First run "find" to generate a list of matches to replace.
 	find := Find{
 		Predicate: v.Predicate,
@@ -66,14 +75,14 @@ func init() {
 		}
 		return &NVarInvalidate{
 			Predicate: pred,
-			W:         os.Stdout,
+			W:         os.Stderr,
 		}, nil
 	})
 	RegisterCLI("invalidate_nvar_except", "invalidate all NVar except those in the specified file", 1, func(args []string) (uefi.Visitor, error) {
 		fileName := args[0]
 		fileContents, err := os.ReadFile(fileName)
 		if err != nil {
-			return nil, fmt.Errorf("cannot read blacklist file %q: %v", fileName, err)
+			return nil, fmt.Errorf("cannot read blacklist file %q: %w", fileName, err)
 		}
 		blackListRegex, err := parseBlackList(fileName, string(fileContents))
 		if err != nil {
@@ -87,7 +96,7 @@ func init() {
 
 		return &NVarInvalidate{
 			Predicate: pred,
-			W:         os.Stdout,
+			W:         os.Stderr,
 		}, nil
 	})
 
@@ -67,8 +67,16 @@ func compactNVarStore(s *uefi.NVarStore) error {
 type NVRamCompact struct {
 }
 
+// Mutates implements Mutator.
+func (v *NVRamCompact) Mutates() bool {
+	return true
+}
+
 // Run wraps Visit and performs some setup and teardown tasks.
 func (v *NVRamCompact) Run(f uefi.Firmware) error {
+	if err := writable(f); err != nil {
+		return err
+	}
 	return f.Apply(v)
 }
 
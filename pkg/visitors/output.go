@@ -0,0 +1,89 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Output formats.
+const (
+	// FormatText is the default. Visitors print their bespoke output,
+	// which is either human-readable text or JSON.
+	FormatText = "text"
+	// FormatJSON makes every visitor print structured JSON.
+	FormatJSON = "json"
+	// FormatYAML makes every visitor print structured YAML.
+	FormatYAML = "yaml"
+)
+
+// OutputFormat is the output format of the visitors created from the
+// command line. Use SetOutputFormat to change it.
+var OutputFormat = FormatText
+
+// SetOutputFormat sets OutputFormat after checking the format is known.
+func SetOutputFormat(format string) error {
+	switch format {
+	case FormatText, FormatJSON, FormatYAML:
+		OutputFormat = format
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q, expected %s, %s or %s", format, FormatText, FormatJSON, FormatYAML)
+}
+
+// structuredOutput reports whether text output must be replaced with
+// structured output.
+func structuredOutput() bool {
+	return OutputFormat != FormatText
+}
+
+// writeStructured writes v to w in YAML if OutputFormat is FormatYAML and
+// in indented JSON otherwise.
+func writeStructured(w io.Writer, v interface{}) error {
+	return writeFormat(w, OutputFormat, v)
+}
+
+// writeFormat writes v to w in YAML if format is FormatYAML and in
+// indented JSON otherwise.
+func writeFormat(w io.Writer, format string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	if format == FormatYAML {
+		if b, err = jsonToYAML(b); err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+// jsonToYAML converts JSON to YAML. Going through JSON keeps the field names
+// and custom marshalers, such as the GUID one, of the JSON output. The order
+// of the fields is kept as well.
+func jsonToYAML(b []byte) ([]byte, error) {
+	var node yaml.Node
+	if err := yaml.Unmarshal(b, &node); err != nil {
+		return nil, err
+	}
+	// JSON is parsed in flow style with quoted strings, switch to the
+	// default style. Strings are still quoted where needed.
+	var clearStyle func(n *yaml.Node)
+	clearStyle = func(n *yaml.Node) {
+		n.Style = 0
+		for _, c := range n.Content {
+			clearStyle(c)
+		}
+	}
+	clearStyle(&node)
+	return yaml.Marshal(&node)
+}
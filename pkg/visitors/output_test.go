@@ -0,0 +1,76 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestSetOutputFormat(t *testing.T) {
+	defer func() { OutputFormat = FormatText }()
+	if err := SetOutputFormat("xml"); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+	if OutputFormat != FormatText {
+		t.Errorf("format changed to %q on error", OutputFormat)
+	}
+	if err := SetOutputFormat(FormatYAML); err != nil {
+		t.Fatal(err)
+	}
+	if !structuredOutput() {
+		t.Errorf("yaml is not structured output")
+	}
+}
+
+func TestWriteFormatYAML(t *testing.T) {
+	v := []struct {
+		Name   string
+		Offset uint64
+		Tags   []string `json:",omitempty"`
+	}{
+		{"b", 1, []string{"x", "1"}},
+		{"a", 2, nil},
+	}
+	var b bytes.Buffer
+	if err := writeFormat(&b, FormatYAML, v); err != nil {
+		t.Fatal(err)
+	}
+	want := `- Name: b
+  Offset: 1
+  Tags:
+    - x
+    - "1"
+- Name: a
+  Offset: 2
+`
+	if b.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestTableStructured(t *testing.T) {
+	f := parseImage(t)
+
+	var b bytes.Buffer
+	table := &Table{Format: FormatJSON, Out: &b}
+	if err := table.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	var rows []TableRow
+	if err := json.Unmarshal(b.Bytes(), &rows); err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, r := range rows {
+		if r.Node == "File" && r.GUID == dxeCoreGUID.String() && r.Name == "DxeCore" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("DxeCore not found in %d rows", len(rows))
+	}
+}
@@ -42,7 +42,7 @@ func (v *ParseDir) Parse() (uefi.Firmware, error) {
 
 func (v *ParseDir) readBuf(ExtractPath string) ([]byte, error) {
 	if ExtractPath != "" {
-		return os.ReadFile(filepath.Join(v.BasePath, ExtractPath))
+		return os.ReadFile(filepath.Join(v.BasePath, filepath.FromSlash(ExtractPath)))
 	}
 	return nil, nil
 }
@@ -0,0 +1,166 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// PluginCommand describes a command provided by a plugin.
+type PluginCommand struct {
+	Name    string
+	Help    string
+	NumArgs int
+}
+
+// PluginDescription is printed by "PLUGIN describe".
+type PluginDescription struct {
+	Commands []PluginCommand
+}
+
+// PluginResponse is printed by "PLUGIN run".
+type PluginResponse struct {
+	// Image optionally replaces the image. It is base64 encoded in JSON.
+	Image []byte `json:",omitempty"`
+	// Output is printed as is.
+	Output string `json:",omitempty"`
+	// Error makes the command fail.
+	Error string `json:",omitempty"`
+}
+
+// Plugin runs a command of a plugin on the image.
+type Plugin struct {
+	// Input
+	Path    string
+	Command string
+	Args    []string
+
+	// The output of the plugin is written to this writer.
+	W io.Writer
+
+	// Output
+	root uefi.Firmware
+}
+
+// Mutates implements Mutator.
+func (v *Plugin) Mutates() bool {
+	return true
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Plugin) Run(f uefi.Firmware) error {
+	v.root = nil
+	if err := writable(f); err != nil {
+		return err
+	}
+	if err := (&Assemble{}).Run(f); err != nil {
+		return err
+	}
+
+	args := append([]string{"run", v.Command}, v.Args...)
+	var resp PluginResponse
+	if err := runPlugin(v.Path, f.Buf(), &resp, args...); err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("plugin command %s: %s", v.Command, resp.Error)
+	}
+	if resp.Output != "" && v.W != nil {
+		if _, err := io.WriteString(v.W, resp.Output); err != nil {
+			return err
+		}
+	}
+	if resp.Image == nil {
+		return nil
+	}
+
+	// The returned image is parsed as f was, and replaces it, see Root.
+	parsed, err := uefi.ParseWithMode(context.Background(), resp.Image, uefi.TreeMode(f))
+	if err != nil {
+		return fmt.Errorf("plugin command %s: cannot parse the returned image: %v", v.Command, err)
+	}
+	v.root = parsed
+	return nil
+}
+
+// Root implements RootReplacer: it is the image returned by the plugin, if
+// any.
+func (v *Plugin) Root() uefi.Firmware {
+	return v.root
+}
+
+// Visit applies the Plugin visitor to any Firmware type.
+func (v *Plugin) Visit(f uefi.Firmware) error {
+	return nil
+}
+
+// runPlugin runs the plugin with the given stdin and decodes its JSON
+// stdout into v.
+func runPlugin(path string, stdin []byte, v interface{}, args ...string) error {
+	var stdout bytes.Buffer
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout, cmd.Stderr = &stdout, os.Stderr
+	cmd.Env = append(os.Environ(), "UTK_FORMAT="+OutputFormat)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("plugin %s: %v", path, err)
+	}
+	if err := json.Unmarshal(stdout.Bytes(), v); err != nil {
+		return fmt.Errorf("plugin %s: invalid response: %v", path, err)
+	}
+	return nil
+}
+
+// LoadPlugin registers the commands of the plugin at path with the CLI, so
+// transformations can be kept out of tree. A plugin is an executable
+// talking JSON over stdin and stdout:
+//
+//	PLUGIN describe
+//
+// prints a PluginDescription, and
+//
+//	PLUGIN run COMMAND ARGS...
+//
+// reads the assembled image on stdin and prints a PluginResponse. Messages
+// written to stderr are passed on. The UTK_FORMAT environment variable
+// holds OutputFormat. Unlike the built-in visitors, a command clashing with
+// an existing one is an error.
+func LoadPlugin(path string) error {
+	var desc PluginDescription
+	if err := runPlugin(path, nil, &desc, "describe"); err != nil {
+		return err
+	}
+	// The commands are checked and registered at once, so that plugins
+	// loaded concurrently can not register the same command.
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	seen := map[string]bool{}
+	for _, c := range desc.Commands {
+		if _, ok := visitorRegistry[c.Name]; ok || seen[c.Name] {
+			return fmt.Errorf("plugin %s: command %q is already registered", path, c.Name)
+		}
+		seen[c.Name] = true
+	}
+	for _, c := range desc.Commands {
+		c := c
+		registerCLI(c.Name, c.Help, c.NumArgs, func(args []string) (uefi.Visitor, error) {
+			return &Plugin{
+				Path:    path,
+				Command: c.Name,
+				Args:    args,
+				W:       os.Stdout,
+			}, nil
+		})
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// writePlugin writes a plugin with the commands plugin_size, which prints
+// the size of the image, and plugin_same, which returns the image as is.
+func writePlugin(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "plugin")
+	err := os.WriteFile(path, []byte(`#!/bin/sh
+case "$1 $2" in
+"describe ")
+	echo '{"Commands": [{"Name": "plugin_size", "Help": "print the size", "NumArgs": 1},
+		{"Name": "plugin_same", "Help": "return the image", "NumArgs": 0}]}' ;;
+"run plugin_size")
+	printf '{"Output": "%s %s\\n"}\n' "$3" "$(wc -c | tr -d ' ')" ;;
+"run plugin_same")
+	echo "{\"Image\": \"$(base64 | tr -d '\n')\"}" ;;
+*)
+	exit 1 ;;
+esac
+`), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPlugin(t *testing.T) {
+	path := writePlugin(t)
+	if err := LoadPlugin(path); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		registryMu.Lock()
+		delete(visitorRegistry, "plugin_size")
+		delete(visitorRegistry, "plugin_same")
+		registryMu.Unlock()
+	}()
+	if err := LoadPlugin(path); err == nil {
+		t.Errorf("loading the plugin twice should fail")
+	}
+
+	_, f := parseImageWithMode(t, uefi.ParseModeCopyOnWrite)
+	size := len(f.Buf())
+	v, err := ParseCLI([]string{"plugin_size", "size:", "plugin_same", "plugin_size", "again:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	for _, p := range v {
+		p.(*Plugin).W = &out
+	}
+	root, err := ExecuteCLIRoot(context.Background(), f, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("size: %d\nagain: %d\n", size, size); out.String() != want {
+		t.Errorf("got output %q, want %q", out.String(), want)
+	}
+	// The returned image replaces the root, parsed as it was.
+	if root == f {
+		t.Fatalf("got the same root, want the image returned by the plugin")
+	}
+	if mode := uefi.TreeMode(root); mode != uefi.ParseModeCopyOnWrite {
+		t.Errorf("got the returned image parsed %v, want %v", mode, uefi.ParseModeCopyOnWrite)
+	}
+	if find(t, root, dxeCoreGUID) == nil {
+		t.Errorf("DxeCore missing from the image returned by the plugin")
+	}
+
+	_, f = parseImageWithMode(t, uefi.ParseModeReadOnly)
+	if err := ExecuteCLI(f, v); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("read-only image: got %v, want %v", err, ErrReadOnly)
+	}
+}
+
+func TestLoadPluginConcurrent(t *testing.T) {
+	path := writePlugin(t)
+	t.Cleanup(func() {
+		registryMu.Lock()
+		delete(visitorRegistry, "plugin_size")
+		delete(visitorRegistry, "plugin_same")
+		registryMu.Unlock()
+	})
+
+	// Only one of the plugins loaded at once registers the commands.
+	errs := make(chan error, 4)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- LoadPlugin(path)
+			ParseScript([]string{"plugin_same"})
+			ListCLI()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	var loaded int
+	for err := range errs {
+		if err == nil {
+			loaded++
+		}
+	}
+	if loaded != 1 {
+		t.Errorf("loaded the plugin %d times, want once", loaded)
+	}
+}
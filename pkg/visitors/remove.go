@@ -15,7 +15,10 @@ import (
 // Remove all firmware files with the given GUID.
 type Remove struct {
 	// Input
-	Predicate  func(f uefi.Firmware) bool
+	Predicate func(f uefi.Firmware) bool
+	// Pad replaces the removed files with pad files of the same size, so
+	// the offsets of the other files do not move. PEIMs are always
+	// replaced this way since they execute in place.
 	Pad        bool
 	RemoveDxes bool // I hate this, but there's no good way to work around our current structure
 
@@ -23,18 +26,28 @@ type Remove struct {
 	Matches []uefi.Firmware
 	// Calling this function undoes the removals performed by this visitor.
 	Undo func()
-	// logs are written to this writer.
+	// logs are written to this writer. The CLI uses stderr, so the logs do
+	// not mix with an image saved to stdout.
 	W io.Writer
 }
 
 func (v *Remove) printf(format string, a ...interface{}) {
-	if v.W != nil {
+	// Logs would corrupt structured output.
+	if v.W != nil && !structuredOutput() {
 		fmt.Fprintf(v.W, format, a...)
 	}
 }
 
+// Mutates implements Mutator.
+func (v *Remove) Mutates() bool {
+	return true
+}
+
 // Run wraps Visit and performs some setup and teardown tasks.
 func (v *Remove) Run(f uefi.Firmware) error {
+	if err := writable(f); err != nil {
+		return err
+	}
 	// First run "find" to generate a list of matches to delete.
 	find := Find{
 		Predicate: v.Predicate,
@@ -116,48 +129,55 @@ func (v *Remove) Visit(f uefi.Firmware) error {
 	return f.ApplyChildren(v)
 }
 
+// removeDxesExcept creates a Remove visitor for all the DXEs except those
+// listed in the blacklist file.
+func removeDxesExcept(fileName string, pad bool) (uefi.Visitor, error) {
+	fileContents, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read blacklist file %q: %w", fileName, err)
+	}
+	blackListRegex, err := parseBlackList(fileName, string(fileContents))
+	if err != nil {
+		return nil, err
+	}
+	pred, err := FindFilePredicate(blackListRegex)
+	if err != nil {
+		return nil, err
+	}
+	return &Remove{
+		Predicate:  pred,
+		Pad:        pad,
+		RemoveDxes: true,
+	}, nil
+}
+
 func init() {
 	RegisterCLI("remove", "remove a file from the volume", 1, func(args []string) (uefi.Visitor, error) {
-		pred, err := FindFilePredicate(args[0])
+		pred, err := FindFileNamePredicate(args[0])
 		if err != nil {
 			return nil, err
 		}
 		return &Remove{
 			Predicate: pred,
 			Pad:       false,
-			W:         os.Stdout,
+			W:         os.Stderr,
 		}, nil
 	})
 	RegisterCLI("remove_pad", "remove a file from the volume and replace it with a pad file of the same size", 1, func(args []string) (uefi.Visitor, error) {
-		pred, err := FindFilePredicate(args[0])
+		pred, err := FindFileNamePredicate(args[0])
 		if err != nil {
 			return nil, err
 		}
 		return &Remove{
 			Predicate: pred,
 			Pad:       true,
-			W:         os.Stdout,
+			W:         os.Stderr,
 		}, nil
 	})
 	RegisterCLI("remove_dxes_except", "remove all files from the volume except those in the specified file", 1, func(args []string) (uefi.Visitor, error) {
-		fileName := args[0]
-		fileContents, err := os.ReadFile(fileName)
-		if err != nil {
-			return nil, fmt.Errorf("cannot read blacklist file %q: %v", fileName, err)
-		}
-		blackListRegex, err := parseBlackList(fileName, string(fileContents))
-		if err != nil {
-			return nil, err
-		}
-		blackListPredicate, err := FindFilePredicate(blackListRegex)
-		if err != nil {
-			return nil, err
-		}
-		pred := blackListPredicate
-
-		return &Remove{
-			Predicate:  pred,
-			RemoveDxes: true,
-		}, nil
+		return removeDxesExcept(args[0], false)
+	})
+	RegisterCLI("remove_dxes_except_pad", "remove all files from the volume except those in the specified file, replacing them with pad files of the same size", 1, func(args []string) (uefi.Visitor, error) {
+		return removeDxesExcept(args[0], true)
 	})
 }
@@ -82,6 +82,40 @@ func TestRemovePad(t *testing.T) {
 	}
 }
 
+func TestRemovePadKeepsOffsets(t *testing.T) {
+	f := parseImage(t)
+	if err := (&Assemble{}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	before := fileOffsets(t, f)
+
+	pred, err := FindFilePredicate(dxeCoreGUID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	remove := &Remove{
+		Predicate:  pred,
+		Pad:        true,
+		RemoveDxes: true,
+	}
+	if err := remove.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Assemble{}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+
+	after := fileOffsets(t, f)
+	if offset, ok := after[*dxeCoreGUID]; !ok || offset != before[*dxeCoreGUID] {
+		t.Errorf("DxeCore moved from %#x to %#x", before[*dxeCoreGUID], offset)
+	}
+	for g, offset := range after {
+		if offset != before[g] {
+			t.Errorf("file %v moved from %#x to %#x", g, before[g], offset)
+		}
+	}
+}
+
 func TestRemoveExcept(t *testing.T) {
 	f := parseImage(t)
 
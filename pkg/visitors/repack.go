@@ -6,13 +6,13 @@ package visitors
 
 import (
 	"bytes"
-	"crypto/sha1"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"unsafe"
 
 	"github.com/linuxboot/fiano/pkg/compression"
+	"github.com/linuxboot/fiano/pkg/guid"
 	"github.com/linuxboot/fiano/pkg/uefi"
 )
 
@@ -120,13 +120,12 @@ func createVolumeImageFile(cs *uefi.Section) (*uefi.File, error) {
 
 	f.Sections = []*uefi.Section{cs}
 
-	// Call assemble to populate cs's buffer. then sha1 it for the guid.
+	// Call assemble to populate cs's buffer, then use its name-based GUID.
 	a := &Assemble{}
 	if err := a.Run(cs); err != nil {
 		return nil, err
 	}
-	sum := sha1.Sum(cs.Buf())
-	copy(f.Header.GUID[:], sum[:]) // GUIDs are smaller, so only 16 bytes are copied
+	f.Header.GUID = guid.NewV5(guid.NamespaceFiano, cs.Buf())
 
 	return f, nil
 }
@@ -169,8 +168,16 @@ func repackFV(fv *uefi.FirmwareVolume) error {
 	return nil
 }
 
+// Mutates implements Mutator.
+func (v *Repack) Mutates() bool {
+	return true
+}
+
 // Run wraps Visit and performs some setup and teardown tasks.
 func (v *Repack) Run(f uefi.Firmware) error {
+	if err := writable(f); err != nil {
+		return err
+	}
 	// Check that fv being repacked isn't already nested.
 	// First run "find" to generate a position to insert into.
 	find := Find{
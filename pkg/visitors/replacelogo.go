@@ -0,0 +1,232 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"  // Register the GIF decoder for image.DecodeConfig.
+	_ "image/jpeg" // Register the JPEG decoder for image.DecodeConfig.
+	_ "image/png"  // Register the PNG decoder for image.DecodeConfig.
+	"os"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// LogoFileGUIDs are the GUIDs of files commonly holding the boot logo.
+var LogoFileGUIDs = []guid.GUID{
+	// EDK2 and AMI logo file.
+	*guid.MustParse("7BB28B99-61BB-11D5-9A5D-0090273FC14D"),
+}
+
+// imageSignatures maps the magic numbers of the supported image formats to
+// the name of the format.
+var imageSignatures = []struct {
+	magic  []byte
+	format string
+}{
+	{[]byte("BM"), "bmp"},
+	{[]byte{0xFF, 0xD8, 0xFF}, "jpeg"},
+	{[]byte("GIF87a"), "gif"},
+	{[]byte("GIF89a"), "gif"},
+	{[]byte("\x89PNG\r\n\x1a\n"), "png"},
+}
+
+// ReplaceLogo replaces the boot logo. The logo is the image held in a raw
+// or freeform section of one of the LogoFileGUIDs files. When there is no
+// such file and no Predicate, any raw section starting with an image
+// signature is used. The new image must have the same format and dimensions
+// as the original.
+type ReplaceLogo struct {
+	// Input
+	NewImage []byte
+	// Predicate optionally selects the file holding the logo.
+	Predicate FindPredicate
+
+	// Output
+	Match *uefi.Section
+	File  *uefi.File
+
+	// Private
+	curFile *uefi.File
+	byGUID  []*uefi.Section
+	bySig   []*uefi.Section
+	files   map[*uefi.Section]*uefi.File
+}
+
+// Mutates implements Mutator.
+func (v *ReplaceLogo) Mutates() bool {
+	return true
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *ReplaceLogo) Run(f uefi.Firmware) error {
+	if err := writable(f); err != nil {
+		return err
+	}
+	format, width, height, err := imageDimensions(v.NewImage)
+	if err != nil {
+		return fmt.Errorf("new image: %v", err)
+	}
+
+	v.byGUID, v.bySig = nil, nil
+	v.files = map[*uefi.Section]*uefi.File{}
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	candidates := v.byGUID
+	if len(candidates) == 0 && v.Predicate == nil {
+		candidates = v.bySig
+	}
+	if len(candidates) == 0 {
+		return errors.New("no boot logo found")
+	}
+	if len(candidates) > 1 {
+		return fmt.Errorf("%d boot logo candidates found, there can be only one", len(candidates))
+	}
+	v.Match = candidates[0]
+	v.File = v.files[v.Match]
+
+	oldFormat, oldWidth, oldHeight, err := imageDimensions(sectionData(v.Match))
+	if err != nil {
+		return fmt.Errorf("original logo: %v", err)
+	}
+	if format != oldFormat {
+		return fmt.Errorf("new image is %s, original logo is %s", format, oldFormat)
+	}
+	if width != oldWidth || height != oldHeight {
+		return fmt.Errorf("new image is %dx%d, original logo is %dx%d", width, height, oldWidth, oldHeight)
+	}
+
+	buf := v.NewImage
+	if v.Match.Header.Type == uefi.SectionTypeFreeformSubtypeGUID {
+		// Keep the subtype GUID.
+		hl := sectionHeaderLen(v.Match)
+		buf = append(append([]byte{}, v.Match.Buf()[hl:hl+guid.Size]...), v.NewImage...)
+	}
+	v.Match.SetBuf(buf)
+	if err := v.Match.GenSecHeader(); err != nil {
+		return err
+	}
+	// Fix up the sizes and checksums of the file and of any encapsulating
+	// sections.
+	return (&Assemble{}).Run(v.File)
+}
+
+// Visit applies the ReplaceLogo visitor to any Firmware type.
+func (v *ReplaceLogo) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.File:
+		prev := v.curFile
+		v.curFile = f
+		defer func() { v.curFile = prev }()
+
+	case *uefi.Section:
+		if v.curFile == nil || len(f.Encapsulated) != 0 {
+			break
+		}
+		if f.Header.Type != uefi.SectionTypeRaw && f.Header.Type != uefi.SectionTypeFreeformSubtypeGUID {
+			break
+		}
+		if imageFormat(sectionData(f)) == "" {
+			break
+		}
+		v.files[f] = v.curFile
+		if v.isLogoFile(v.curFile) {
+			v.byGUID = append(v.byGUID, f)
+		} else if f.Header.Type == uefi.SectionTypeRaw {
+			v.bySig = append(v.bySig, f)
+		}
+	}
+	return f.ApplyChildren(v)
+}
+
+func (v *ReplaceLogo) isLogoFile(f *uefi.File) bool {
+	if v.Predicate != nil {
+		return v.Predicate(f)
+	}
+	for _, g := range LogoFileGUIDs {
+		if f.Header.GUID == g {
+			return true
+		}
+	}
+	return false
+}
+
+// sectionData returns the data of a section, without the header. Freeform
+// subtype GUID sections have a GUID in front of their data.
+func sectionData(s *uefi.Section) []byte {
+	hl := sectionHeaderLen(s)
+	if s.Header.Type == uefi.SectionTypeFreeformSubtypeGUID {
+		hl += guid.Size
+	}
+	buf := s.Buf()
+	if uint32(len(buf)) < hl {
+		return nil
+	}
+	return buf[hl:]
+}
+
+// imageFormat returns the format of an image from its signature, or "" if
+// the format is not supported.
+func imageFormat(buf []byte) string {
+	for _, s := range imageSignatures {
+		if bytes.HasPrefix(buf, s.magic) {
+			return s.format
+		}
+	}
+	return ""
+}
+
+// imageDimensions returns the format, width and height of an image.
+func imageDimensions(buf []byte) (string, int, int, error) {
+	format := imageFormat(buf)
+	switch format {
+	case "":
+		return "", 0, 0, errors.New("unsupported image format")
+	case "bmp":
+		// Width and height are in the BITMAPINFOHEADER following the
+		// 14 byte file header. The height is negative for top-down bitmaps.
+		if len(buf) < 26 {
+			return "", 0, 0, errors.New("truncated BMP header")
+		}
+		width := int32(binary.LittleEndian.Uint32(buf[18:]))
+		height := int32(binary.LittleEndian.Uint32(buf[22:]))
+		if height < 0 {
+			height = -height
+		}
+		return format, int(width), int(height), nil
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(buf))
+	if err != nil {
+		return "", 0, 0, err
+	}
+	return format, config.Width, config.Height, nil
+}
+
+func init() {
+	RegisterCLI("replace_logo", "replace the boot logo with an image of the same format and dimensions", 1, func(args []string) (uefi.Visitor, error) {
+		img, err := os.ReadFile(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &ReplaceLogo{NewImage: img}, nil
+	})
+	RegisterCLI("replace_logo_file", "replace the boot logo held in the file matching (GUID|NAME) with an image of the same format and dimensions", 2, func(args []string) (uefi.Visitor, error) {
+		pred, err := FindFilePredicate(args[0])
+		if err != nil {
+			return nil, err
+		}
+		img, err := os.ReadFile(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return &ReplaceLogo{NewImage: img, Predicate: pred}, nil
+	})
+}
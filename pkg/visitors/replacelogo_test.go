@@ -0,0 +1,90 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/gif"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// makeBMP builds a minimal 24-bit BMP header of the given dimensions
+// followed by fill bytes.
+func makeBMP(width, height int32, fill byte) []byte {
+	buf := make([]byte, 54+64)
+	copy(buf, "BM")
+	binary.LittleEndian.PutUint32(buf[2:], uint32(len(buf)))
+	binary.LittleEndian.PutUint32(buf[10:], 54)
+	binary.LittleEndian.PutUint32(buf[14:], 40)
+	binary.LittleEndian.PutUint32(buf[18:], uint32(width))
+	binary.LittleEndian.PutUint32(buf[22:], uint32(height))
+	for i := 54; i < len(buf); i++ {
+		buf[i] = fill
+	}
+	return buf
+}
+
+func TestImageDimensions(t *testing.T) {
+	var g bytes.Buffer
+	if err := gif.Encode(&g, image.NewGray(image.Rect(0, 0, 3, 5)), nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		buf           []byte
+		format        string
+		width, height int
+	}{
+		{makeBMP(640, -480, 0), "bmp", 640, 480},
+		{g.Bytes(), "gif", 3, 5},
+	} {
+		format, w, h, err := imageDimensions(tt.buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if format != tt.format || w != tt.width || h != tt.height {
+			t.Errorf("got %s %dx%d, want %s %dx%d", format, w, h, tt.format, tt.width, tt.height)
+		}
+	}
+	if _, _, _, err := imageDimensions([]byte("not an image")); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+}
+
+func TestReplaceLogo(t *testing.T) {
+	f := parseImage(t)
+	file := find(t, f, dxeCoreGUID)[0].(*uefi.File)
+	logo, err := uefi.CreateSection(uefi.SectionTypeRaw, makeBMP(16, 16, 0x11), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := logo.GenSecHeader(); err != nil {
+		t.Fatal(err)
+	}
+	file.Sections = append(file.Sections, logo)
+	if err := (&Assemble{}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+
+	// Different dimensions are rejected.
+	if err := (&ReplaceLogo{NewImage: makeBMP(32, 16, 0x22)}).Run(f); err == nil {
+		t.Errorf("expected error for mismatched dimensions")
+	}
+
+	newLogo := makeBMP(16, 16, 0x22)
+	v := &ReplaceLogo{NewImage: newLogo}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if v.File != file {
+		t.Errorf("logo found in file %v, want %v", v.File.Header.GUID, file.Header.GUID)
+	}
+	if !bytes.Equal(sectionData(logo), newLogo) {
+		t.Errorf("logo was not replaced")
+	}
+}
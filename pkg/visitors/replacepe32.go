@@ -6,7 +6,9 @@ package visitors
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"os"
 
 	"github.com/linuxboot/fiano/pkg/uefi"
@@ -18,12 +20,24 @@ type ReplacePE32 struct {
 	Predicate func(f uefi.Firmware) bool
 	NewPE32   []byte
 
+	// CheckMachine requires the machine type of NewPE32 to match the
+	// machine type of the PE32 being replaced.
+	CheckMachine bool
+
 	// Output
 	Matches []uefi.Firmware
 }
 
+// Mutates implements Mutator.
+func (v *ReplacePE32) Mutates() bool {
+	return true
+}
+
 // Run wraps Visit and performs some setup and teardown tasks.
 func (v *ReplacePE32) Run(f uefi.Firmware) error {
+	if err := writable(f); err != nil {
+		return err
+	}
 	// Check that we're actually replacing with a PE32 image
 	if !bytes.HasPrefix(v.NewPE32, []byte("MZ")) {
 		return errors.New("supplied binary is not a valid pe32 image")
@@ -59,10 +73,20 @@ func (v *ReplacePE32) Visit(f uefi.Firmware) error {
 	switch f := f.(type) {
 
 	case *uefi.File:
-		return f.ApplyChildren(v)
+		if err := f.ApplyChildren(v); err != nil {
+			return err
+		}
+		// Fix up the sizes and checksums of the file and of any
+		// encapsulating sections.
+		return (&Assemble{}).Run(f)
 
 	case *uefi.Section:
 		if f.Header.Type == uefi.SectionTypePE32 {
+			if v.CheckMachine {
+				if err := checkPE32Machine(f.Buf()[sectionHeaderLen(f):], v.NewPE32); err != nil {
+					return err
+				}
+			}
 			f.SetBuf(v.NewPE32)
 			f.Encapsulated = nil // Should already be empty
 			if err := f.GenSecHeader(); err != nil {
@@ -77,6 +101,43 @@ func (v *ReplacePE32) Visit(f uefi.Firmware) error {
 	}
 }
 
+// sectionHeaderLen returns the length of the common header of a leaf section.
+func sectionHeaderLen(s *uefi.Section) uint32 {
+	if s.Header.ExtendedSize >= 0xFFFFFF {
+		return uefi.SectionExtMinLength
+	}
+	return uefi.SectionMinLength
+}
+
+// peMachine returns the machine type from the COFF header of a PE32 image.
+func peMachine(buf []byte) (uint16, error) {
+	if len(buf) < 0x40 || !bytes.HasPrefix(buf, []byte("MZ")) {
+		return 0, errors.New("not a pe32 image")
+	}
+	off := binary.LittleEndian.Uint32(buf[0x3c:])
+	if uint64(off)+6 > uint64(len(buf)) || !bytes.Equal(buf[off:off+4], []byte("PE\x00\x00")) {
+		return 0, errors.New("pe32 image has no PE signature")
+	}
+	return binary.LittleEndian.Uint16(buf[off+4:]), nil
+}
+
+// checkPE32Machine checks that both images are built for the same machine.
+func checkPE32Machine(oldPE32, newPE32 []byte) error {
+	oldMachine, err := peMachine(oldPE32)
+	if err != nil {
+		return fmt.Errorf("existing image: %v", err)
+	}
+	newMachine, err := peMachine(newPE32)
+	if err != nil {
+		return fmt.Errorf("supplied image: %v", err)
+	}
+	if oldMachine != newMachine {
+		return fmt.Errorf("pe32 machine type mismatch: existing image is %#04x, supplied image is %#04x",
+			oldMachine, newMachine)
+	}
+	return nil
+}
+
 func init() {
 	RegisterCLI("replace_pe32", "replace a pe32 given a GUID and new file", 2, func(args []string) (uefi.Visitor, error) {
 		pred, err := FindFilePredicate(args[0])
@@ -96,4 +157,21 @@ func init() {
 			NewPE32:   newPE32,
 		}, nil
 	})
+	RegisterCLI("replace_pe32_checked", "replace a pe32 given a GUID and new file, checking that the machine types match", 2, func(args []string) (uefi.Visitor, error) {
+		pred, err := FindFilePredicate(args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		newPE32, err := os.ReadFile(args[1])
+		if err != nil {
+			return nil, err
+		}
+
+		return &ReplacePE32{
+			Predicate:    pred,
+			NewPE32:      newPE32,
+			CheckMachine: true,
+		}, nil
+	})
 }
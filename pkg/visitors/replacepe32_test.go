@@ -5,6 +5,7 @@
 package visitors
 
 import (
+	"encoding/binary"
 	"reflect"
 	"testing"
 
@@ -80,3 +81,50 @@ func TestErrors(t *testing.T) {
 		})
 	}
 }
+
+func TestReplacePE32FixesFileSize(t *testing.T) {
+	f := parseImage(t)
+
+	replace := &ReplacePE32{
+		Predicate: FindFileGUIDPredicate(*testGUID),
+		NewPE32:   []byte("MZbanana"),
+	}
+	if err := replace.Run(f); err != nil {
+		t.Fatal(err)
+	}
+
+	file := replace.Matches[0].(*uefi.File)
+	if got, want := file.Header.ExtendedSize, uint64(len(file.Buf())); got != want {
+		t.Errorf("file size in header is %d, buffer is %d bytes", got, want)
+	}
+	if sum := file.ChecksumHeader(); sum != 0 {
+		t.Errorf("file header checksum is %#x, want 0", sum)
+	}
+}
+
+func TestReplacePE32CheckMachine(t *testing.T) {
+	f := parseImage(t)
+
+	// Extract the existing PE32 and patch its machine type.
+	file := find(t, f, testGUID)[0].(*uefi.File)
+	newPE32 := append([]byte{}, file.Sections[0].Buf()[uefi.SectionMinLength:]...)
+	machine, err := peMachine(newPE32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replace := &ReplacePE32{
+		Predicate:    FindFileGUIDPredicate(*testGUID),
+		NewPE32:      newPE32,
+		CheckMachine: true,
+	}
+	if err := replace.Run(f); err != nil {
+		t.Fatalf("replacing with same machine type: %v", err)
+	}
+
+	peOffset := binary.LittleEndian.Uint32(newPE32[0x3c:])
+	binary.LittleEndian.PutUint16(newPE32[peOffset+4:], machine+1)
+	if err := replace.Run(f); err == nil {
+		t.Fatalf("expected machine type mismatch error")
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/linuxboot/fiano/pkg/capsule"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// ResignCapsule assembles the image, puts it in an item of an FMP capsule
+// in place of its payload and signs the payload again, then writes the
+// capsule to a file. This produces test capsules holding an edited image,
+// as utk loads the payload of a capsule rather than the capsule.
+type ResignCapsule struct {
+	// Capsule is the FMP capsule the image was loaded from.
+	Capsule     []byte
+	Certificate *x509.Certificate
+	Key         crypto.Signer
+	// Item is the index of the item whose payload is the image.
+	Item int
+	Path string
+}
+
+// Mutates implements Mutator, as the image is assembled before being saved.
+func (v *ResignCapsule) Mutates() bool {
+	return true
+}
+
+// Run just applies the visitor.
+func (v *ResignCapsule) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit assembles the image and writes the re-signed capsule.
+func (v *ResignCapsule) Visit(f uefi.Firmware) error {
+	if err := (&Assemble{}).Run(f); err != nil {
+		return err
+	}
+	c, err := capsule.Parse(v.Capsule)
+	if err != nil {
+		return err
+	}
+	if v.Item < 0 || v.Item >= len(c.Items) {
+		return fmt.Errorf("the capsule has %d items, no item %d", len(c.Items), v.Item)
+	}
+	item := c.Items[v.Item]
+	item.Payload = f.Buf()
+	if err := item.Sign(v.Certificate, v.Key); err != nil {
+		return err
+	}
+	b, err := c.Bytes()
+	if err != nil {
+		return err
+	}
+	return writeFile(v.Path, b)
+}
+
+// parseSigner parses a PEM certificate and its PEM private key, in PKCS#8,
+// PKCS#1 or SEC 1 form.
+func parseSigner(certPEM, keyPEM []byte) (*x509.Certificate, crypto.Signer, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, nil, errors.New("no PEM certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ = pem.Decode(keyPEM)
+	if block == nil {
+		return nil, nil, errors.New("no PEM private key")
+	}
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported %T private key", key)
+	}
+	return cert, signer, nil
+}
+
+func init() {
+	RegisterCLI("resign_capsule", "resign_capsule CAPSULE CERT KEY OUT\n put the image in the FMP capsule it was loaded from, sign it with the PEM certificate and key and write the capsule to OUT", 4, func(args []string) (uefi.Visitor, error) {
+		c, err := os.ReadFile(args[0])
+		if err != nil {
+			return nil, err
+		}
+		certPEM, err := os.ReadFile(args[1])
+		if err != nil {
+			return nil, err
+		}
+		keyPEM, err := os.ReadFile(args[2])
+		if err != nil {
+			return nil, err
+		}
+		cert, key, err := parseSigner(certPEM, keyPEM)
+		if err != nil {
+			return nil, err
+		}
+		return &ResignCapsule{Capsule: c, Certificate: cert, Key: key, Path: args[3]}, nil
+	})
+}
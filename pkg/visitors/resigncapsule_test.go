@@ -0,0 +1,135 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/linuxboot/fiano/pkg/capsule"
+)
+
+// selfSigned returns a self-signed PEM certificate for key.
+func selfSigned(t *testing.T, key crypto.Signer) []byte {
+	t.Helper()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "fiano test capsule signer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestParseSigner(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		key   crypto.Signer
+		block *pem.Block
+	}{
+		{rsaKey, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}},
+		{ecKey, &pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER}},
+		{ecKey, &pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}},
+	} {
+		cert, key, err := parseSigner(selfSigned(t, tt.key), pem.EncodeToMemory(tt.block))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.block.Type, err)
+		}
+		if cert.SerialNumber.Int64() != 1 || !key.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(tt.key.Public()) {
+			t.Errorf("%s: got a different certificate or key", tt.block.Type)
+		}
+	}
+	if _, _, err := parseSigner([]byte("not PEM"), nil); err == nil {
+		t.Errorf("expected an error for a certificate which is not PEM")
+	}
+}
+
+func TestResignCapsule(t *testing.T) {
+	f := parseImage(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, signer, err := parseSigner(selfSigned(t, key), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orig := &capsule.Capsule{
+		Header:    capsule.Header{GUID: capsule.FMPCapsuleGUID},
+		FMPHeader: capsule.FMPHeader{Version: 1},
+		Items: []*capsule.Item{{
+			Header:  capsule.ImageHeader{Version: 3},
+			Auth:    &capsule.Authentication{MonotonicCount: 5, CertType: capsule.CertTypePKCS7GUID, CertData: []byte("stale")},
+			Payload: []byte("original image"),
+		}},
+	}
+	b, err := orig.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (&Remove{Predicate: FindFileGUIDPredicate(*testGUID)}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "resigned.cap")
+	if err := (&ResignCapsule{Capsule: b, Item: 1, Certificate: cert, Key: signer, Path: path}).Run(f); err == nil {
+		t.Errorf("expected an error for a missing item")
+	}
+	if err := (&ResignCapsule{Capsule: b, Certificate: cert, Key: signer, Path: path}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := capsule.Parse(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := c.Items[0]
+	if !bytes.Equal(item.Payload, f.Buf()) {
+		t.Errorf("the payload is not the edited image")
+	}
+	if item.Auth == nil || item.Auth.MonotonicCount != 5 || !bytes.Contains(item.Auth.CertData, cert.Raw) {
+		t.Errorf("got authentication %+v, want a signature by the certificate with the monotonic count kept", item.Auth)
+	}
+}
@@ -5,14 +5,73 @@
 package visitors
 
 import (
+	"fmt"
+	"io"
 	"os"
 
+	"github.com/linuxboot/fiano/pkg/flashrom"
+	"github.com/linuxboot/fiano/pkg/log"
 	"github.com/linuxboot/fiano/pkg/uefi"
 )
 
-// Save calls Assemble, then outputs the top image to a file.
+// StdioPath is the path standing for the standard input when loading an
+// image and for the standard output when saving one.
+const StdioPath = "-"
+
+// DryRun makes Save assemble the image without writing it, and the other
+// visitors writing files, such as Extract, run without writing them, so the
+// effect of the operations can be reported without producing any output
+// file. The visitors write their files through writeFile, mkdirAll and
+// openFile, which honor it.
+var DryRun = false
+
+// writeFile writes data to the file at path, unless DryRun is set.
+func writeFile(path string, data []byte) error {
+	if DryRun {
+		log.With(log.F("path", path)).Debugf("dry run, not writing")
+		return nil
+	}
+	return os.WriteFile(path, data, 0666)
+}
+
+// mkdirAll creates the directory at path and its parents, unless DryRun is
+// set.
+func mkdirAll(path string) error {
+	if DryRun {
+		return nil
+	}
+	return os.MkdirAll(path, 0755)
+}
+
+// discardCloser discards what is written to it.
+type discardCloser struct{}
+
+func (discardCloser) Write(b []byte) (int, error) { return len(b), nil }
+func (discardCloser) Close() error                { return nil }
+
+// openFile opens the file at path for writing as os.OpenFile does, unless
+// DryRun is set, where what is written is discarded.
+func openFile(path string, flag int, perm os.FileMode) (io.WriteCloser, error) {
+	if DryRun {
+		log.With(log.F("path", path)).Debugf("dry run, not writing")
+		return discardCloser{}, nil
+	}
+	return os.OpenFile(path, flag, perm)
+}
+
+// Save calls Assemble, then outputs the top image to a file. A DirPath of
+// the form "flashrom:PROGRAMMER" writes the image to the flash chip with
+// flashrom instead, and StdioPath writes it to the standard output.
 type Save struct {
 	DirPath string
+
+	// Parallel assembles the image using the parallel mode of Assemble.
+	Parallel bool
+}
+
+// Mutates implements Mutator, as the image is assembled before being saved.
+func (v *Save) Mutates() bool {
+	return true
 }
 
 // Run just applies the visitor.
@@ -23,18 +82,80 @@ func (v *Save) Run(f uefi.Firmware) error {
 // Visit calls the assemble visitor to make sure everything is reconstructed.
 // It then outputs the top level buffer to a file.
 func (v *Save) Visit(f uefi.Firmware) error {
-	a := &Assemble{}
+	a := &Assemble{Parallel: v.Parallel}
 	// Assemble the binary to make sure the top level buffer is correct
-	if err := f.Apply(a); err != nil {
+	if err := a.Run(f); err != nil {
+		return err
+	}
+	if DryRun {
+		log.With(log.F("path", v.DirPath)).Warnf("dry run, not saving the image")
+		return nil
+	}
+	if programmer, ok := flashrom.Programmer(v.DirPath); ok {
+		return flashrom.Write(programmer, f.Buf())
+	}
+	if v.DirPath == StdioPath {
+		_, err := os.Stdout.Write(f.Buf())
 		return err
 	}
-	return os.WriteFile(v.DirPath, f.Buf(), 0666)
+	return writeFile(v.DirPath, f.Buf())
+}
+
+// SaveChips calls Assemble, then outputs the contents of the flash chips
+// holding the image to one file each, see uefi.FlashImage.Chips. Saving to a
+// single file with Save rejoins them.
+type SaveChips struct {
+	Paths []string
+}
+
+// Mutates implements Mutator, as the image is assembled before being saved.
+func (v *SaveChips) Mutates() bool {
+	return true
+}
+
+// Run just applies the visitor.
+func (v *SaveChips) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit assembles the flash image and writes its chips.
+func (v *SaveChips) Visit(f uefi.Firmware) error {
+	fi, ok := f.(*uefi.FlashImage)
+	if !ok {
+		return fmt.Errorf("the chips of a %T cannot be saved, only those of a flash image", f)
+	}
+	if err := (&Assemble{}).Run(f); err != nil {
+		return err
+	}
+	chips := fi.SplitChips()
+	if len(chips) != len(v.Paths) {
+		return fmt.Errorf("the image is held by %d chips, got %d files", len(chips), len(v.Paths))
+	}
+	if DryRun {
+		log.With(log.F("paths", v.Paths)).Warnf("dry run, not saving the chips")
+		return nil
+	}
+	for i, c := range chips {
+		if err := writeFile(v.Paths[i], c); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func init() {
-	RegisterCLI("save", "assemble a firmware volume from a directory tree", 1, func(args []string) (uefi.Visitor, error) {
+	RegisterCLI("save", "assemble a firmware volume from a directory tree, \"-\" writes it to stdout", 1, func(args []string) (uefi.Visitor, error) {
 		return &Save{
 			DirPath: args[0],
 		}, nil
 	})
+	RegisterCLI("save-parallel", "assemble a firmware volume from a directory tree, compressing independent files and sections concurrently", 1, func(args []string) (uefi.Visitor, error) {
+		return &Save{
+			DirPath:  args[0],
+			Parallel: true,
+		}, nil
+	})
+	RegisterCLI("save-chips", "save-chips CHIP0 CHIP1\n assemble a flash image held by two flash chips and write the content of each chip to its file", uefi.MaxFlashChips, func(args []string) (uefi.Visitor, error) {
+		return &SaveChips{Paths: args}, nil
+	})
 }
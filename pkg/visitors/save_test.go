@@ -0,0 +1,65 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveChips(t *testing.T) {
+	image, f := parseTwoChipImage(t)
+	dir := t.TempDir()
+	paths := []string{filepath.Join(dir, "chip0.rom"), filepath.Join(dir, "chip1.rom")}
+	if err := (&SaveChips{Paths: paths}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range paths {
+		got, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, image[i*testChipSize:(i+1)*testChipSize]) {
+			t.Errorf("chip %d differs", i)
+		}
+	}
+
+	if err := (&SaveChips{Paths: paths[:1]}).Run(f); err == nil {
+		t.Errorf("saved two chips to a single file")
+	}
+	if err := (&SaveChips{Paths: paths}).Run(parseImage(t)); err == nil {
+		t.Errorf("saved the chips of a BIOS region")
+	}
+}
+
+func TestDryRunWritesNothing(t *testing.T) {
+	DryRun = true
+	defer func() { DryRun = false }()
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"record", filepath.Join(dir, "script.json"), "count"},
+		{"extract", filepath.Join(dir, "extract")},
+		{"extract-tar", filepath.Join(dir, "extract.tar")},
+		{"extract-executables", filepath.Join(dir, "executables")},
+		{"extract-uefitool", filepath.Join(dir, "uefitool")},
+		{"annotate", filepath.Join(dir, "notes.json"), "Shell", "the shell"},
+		{"learn-names", filepath.Join(dir, "names.txt")},
+		{"dump", "Shell", filepath.Join(dir, "shell.ffs")},
+		{"save", filepath.Join(dir, "out.rom")},
+	} {
+		v, err := ParseCLI(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ExecuteCLI(parseImage(t), v); err != nil {
+			t.Fatalf("%s: %v", args[0], err)
+		}
+	}
+	if files, err := os.ReadDir(dir); err != nil || len(files) != 0 {
+		t.Errorf("the dry run wrote %v: %v", files, err)
+	}
+}
@@ -0,0 +1,425 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/linuxboot/fiano/pkg/fsp"
+	"github.com/linuxboot/fiano/pkg/guid"
+	"github.com/linuxboot/fiano/pkg/intel/me"
+	"github.com/linuxboot/fiano/pkg/knownguids"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// SBOM formats.
+const (
+	SBOMSPDX      = "spdx"
+	SBOMCycloneDX = "cyclonedx"
+)
+
+// Kinds of SBOM components.
+const (
+	SBOMKindRegion    = "region"
+	SBOMKindModule    = "module"
+	SBOMKindMicrocode = "microcode"
+	SBOMKindFSP       = "fsp"
+	SBOMKindEC        = "ec"
+)
+
+// SBOMComponent is a component of the image listed in the SBOM.
+type SBOMComponent struct {
+	Kind string
+	Name string
+	GUID *guid.GUID `json:",omitempty"`
+	// Type is the region type for regions, the file type for modules, the
+	// component type for FSP components and the firmware type for EC
+	// firmware.
+	Type string `json:",omitempty"`
+	// Version is the version section of modules, the revision of microcode
+	// updates, the image revision of FSP components and the version of EC
+	// firmware, also given to the EC region.
+	Version string `json:",omitempty"`
+	// FITCVersion is the version of the flash image tool which built the ME
+	// region, not the version of the ME firmware.
+	FITCVersion string   `json:",omitempty"`
+	Compression []string `json:",omitempty"`
+	SHA256      string
+}
+
+// SBOM lists the flash regions, modules, microcode updates, FSP components
+// and EC firmware of the image, with their versions and hashes, as an SPDX or
+// CycloneDX software bill of materials.
+type SBOM struct {
+	// Input
+	// Format is SBOMSPDX or SBOMCycloneDX.
+	Format string
+	// Created is the creation time recorded in the SBOM, now if zero.
+	Created time.Time
+
+	// The SBOM is written to this writer.
+	W io.Writer
+
+	// Output
+	Components []SBOMComponent
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *SBOM) Run(f uefi.Firmware) error {
+	if v.Format != SBOMSPDX && v.Format != SBOMCycloneDX {
+		return fmt.Errorf("unknown SBOM format %q, expected %s or %s", v.Format, SBOMSPDX, SBOMCycloneDX)
+	}
+	v.Components = nil
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	if v.W == nil {
+		return nil
+	}
+	created := v.Created
+	if created.IsZero() {
+		created = time.Now()
+	}
+	imageHash := sha256.Sum256(f.Buf())
+	image := SBOMComponent{Name: "firmware image", SHA256: hex.EncodeToString(imageHash[:])}
+	// The same image always gets the same serial number.
+	serial := strings.ToLower(guid.NewV5(guid.NamespaceFiano, imageHash[:]).String())
+	if v.Format == SBOMSPDX {
+		return writeStructured(v.W, newSPDXDocument(image, v.Components, serial, created))
+	}
+	return writeStructured(v.W, newCycloneDXBOM(image, v.Components, serial, created))
+}
+
+// Visit applies the SBOM visitor to any Firmware type.
+func (v *SBOM) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case uefi.Region:
+		c := SBOMComponent{Kind: SBOMKindRegion, Name: f.Type().String(), Type: f.Type().String(), SHA256: sha256Hex(f.Buf())}
+		if _, ok := f.(*uefi.MERegion); ok {
+			if m, err := me.ParseIntelME(bytes.NewReader(f.Buf())); err == nil {
+				c.FITCVersion = m.FitcVersion()
+			}
+		}
+		ecs := ecComponents(f)
+		for _, e := range ecs {
+			if c.Version == "" {
+				c.Version = e.Version
+			}
+		}
+		v.Components = append(v.Components, c)
+		v.Components = append(v.Components, ecs...)
+
+	case *uefi.File:
+		if f.Header.Type == uefi.FVFileTypePad {
+			return nil
+		}
+		g := f.Header.GUID
+		c := SBOMComponent{
+			Kind:   SBOMKindModule,
+			Name:   FileUIName(f),
+			GUID:   &g,
+			Type:   f.Header.Type.String(),
+			SHA256: sha256Hex(f.Buf()),
+		}
+		if c.Name == "" {
+			c.Name, _ = knownguids.Name(g)
+		}
+		if c.Name == "" {
+			c.Name = g.String()
+		}
+		walkSections(f.Sections, func(s *uefi.Section) {
+			if c.Version == "" && s.Header.Type == uefi.SectionTypeVersion {
+				c.Version = s.Version
+			}
+		})
+		for comp := range fileCompressions(f.Sections) {
+			c.Compression = append(c.Compression, comp)
+		}
+		sort.Strings(c.Compression)
+		v.Components = append(v.Components, c)
+
+		v.Components = append(v.Components, ecComponents(f)...)
+
+		body := f.Buf()[f.DataOffset:]
+		switch g {
+		case MicrocodeFileGUID:
+			v.Components = append(v.Components, microcodeComponents(body)...)
+		case fsp.HeaderFileGUID:
+			if c, ok := fspComponent(body); ok {
+				v.Components = append(v.Components, c)
+			}
+		}
+	}
+	return f.ApplyChildren(v)
+}
+
+// microcodeComponents returns the microcode updates at the start of the
+// body of the microcode file.
+func microcodeComponents(body []byte) []SBOMComponent {
+	var comps []SBOMComponent
+	for offset := uint64(0); offset < uint64(len(body)); {
+		hdr, err := readMicrocodeHeader(body[offset:])
+		if err != nil || hdr.HeaderVersion != 1 {
+			// Reached the free space.
+			break
+		}
+		size, err := microcodeSize(hdr)
+		if err != nil || offset+size > uint64(len(body)) {
+			break
+		}
+		comps = append(comps, SBOMComponent{
+			Kind:    SBOMKindMicrocode,
+			Name:    fmt.Sprintf("microcode sig=%#x pf=%#x", hdr.HeaderProcessorSignature, hdr.HeaderProcessorFlags),
+			Version: fmt.Sprintf("%#x", hdr.HeaderRevision),
+			SHA256:  sha256Hex(body[offset : offset+size]),
+		})
+		offset += uefi.Align(size, microcodeAlignment)
+	}
+	return comps
+}
+
+// ecComponents returns the EC firmware held by an EC region or a file.
+func ecComponents(f uefi.Firmware) []SBOMComponent {
+	var comps []SBOMComponent
+	for _, fw := range ecFirmware(f) {
+		name := fw.Type
+		if fw.Board != "" {
+			name += " " + fw.Board
+		}
+		comps = append(comps, SBOMComponent{
+			Kind:    SBOMKindEC,
+			Name:    name,
+			GUID:    fw.File,
+			Type:    fw.Type,
+			Version: fw.Version,
+			SHA256:  fw.SHA256,
+		})
+	}
+	return comps
+}
+
+// fspComponent returns the FSP component whose info header is in the body
+// of the FSP header file. The header is either directly in the body or in
+// a raw section.
+func fspComponent(body []byte) (SBOMComponent, bool) {
+	p := bytes.Index(body, fsp.Signature[:])
+	if p < 0 || p > 8 {
+		return SBOMComponent{}, false
+	}
+	h, err := fsp.NewInfoHeader(body[p:])
+	if err != nil || p+int(h.HeaderLength) > len(body) {
+		return SBOMComponent{}, false
+	}
+	t := h.ComponentAttribute.Type().String()
+	return SBOMComponent{
+		Kind:    SBOMKindFSP,
+		Name:    strings.TrimRight(fmt.Sprintf("%s %s", t, h.ImageID[:]), "\x00 "),
+		Type:    t,
+		Version: h.ImageRevision.String(),
+		SHA256:  sha256Hex(body[p : p+int(h.HeaderLength)]),
+	}, true
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// description returns the details of the component which have no field of
+// their own in the SBOM formats.
+func (c SBOMComponent) description() string {
+	s := c.Kind
+	if c.Type != "" {
+		s += " " + c.Type
+	}
+	if c.GUID != nil {
+		s += " " + c.GUID.String()
+	}
+	if c.FITCVersion != "" {
+		s += " built by FITC " + c.FITCVersion
+	}
+	if len(c.Compression) != 0 {
+		s += " compressed with " + strings.Join(c.Compression, ", ")
+	}
+	return s
+}
+
+// SPDX 2.3 JSON, see https://spdx.github.io/spdx-spec/v2.3/.
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name                  string         `json:"name"`
+	SPDXID                string         `json:"SPDXID"`
+	VersionInfo           string         `json:"versionInfo,omitempty"`
+	DownloadLocation      string         `json:"downloadLocation"`
+	FilesAnalyzed         bool           `json:"filesAnalyzed"`
+	Checksums             []spdxChecksum `json:"checksums"`
+	PrimaryPackagePurpose string         `json:"primaryPackagePurpose"`
+	Comment               string         `json:"comment,omitempty"`
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+func newSPDXPackage(c SBOMComponent, id string) spdxPackage {
+	return spdxPackage{
+		Name:                  c.Name,
+		SPDXID:                id,
+		VersionInfo:           c.Version,
+		DownloadLocation:      "NOASSERTION",
+		Checksums:             []spdxChecksum{{Algorithm: "SHA256", ChecksumValue: c.SHA256}},
+		PrimaryPackagePurpose: "FIRMWARE",
+		Comment:               c.description(),
+	}
+}
+
+func newSPDXDocument(image SBOMComponent, comps []SBOMComponent, serial string, created time.Time) spdxDocument {
+	const imageID = "SPDXRef-Image"
+	d := spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              image.Name,
+		DocumentNamespace: "https://github.com/linuxboot/fiano/spdx/" + serial,
+		CreationInfo: spdxCreationInfo{
+			Created:  created.UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: fiano-utk"},
+		},
+		Packages: []spdxPackage{newSPDXPackage(image, imageID)},
+		Relationships: []spdxRelationship{
+			{"SPDXRef-DOCUMENT", "DESCRIBES", imageID},
+		},
+	}
+	for i, c := range comps {
+		id := fmt.Sprintf("SPDXRef-%s-%d", c.Kind, i)
+		d.Packages = append(d.Packages, newSPDXPackage(c, id))
+		d.Relationships = append(d.Relationships, spdxRelationship{imageID, "CONTAINS", id})
+	}
+	return d
+}
+
+// CycloneDX 1.5 JSON, see https://cyclonedx.org/docs/1.5/json/.
+type cdxBOM struct {
+	BOMFormat    string         `json:"bomFormat"`
+	SpecVersion  string         `json:"specVersion"`
+	SerialNumber string         `json:"serialNumber"`
+	Version      int            `json:"version"`
+	Metadata     cdxMetadata    `json:"metadata"`
+	Components   []cdxComponent `json:"components"`
+}
+
+type cdxMetadata struct {
+	Timestamp string       `json:"timestamp"`
+	Tools     cdxTools     `json:"tools"`
+	Component cdxComponent `json:"component"`
+}
+
+type cdxTools struct {
+	Components []cdxComponent `json:"components"`
+}
+
+type cdxComponent struct {
+	Type        string        `json:"type"`
+	BOMRef      string        `json:"bom-ref,omitempty"`
+	Name        string        `json:"name"`
+	Version     string        `json:"version,omitempty"`
+	Description string        `json:"description,omitempty"`
+	Hashes      []cdxHash     `json:"hashes,omitempty"`
+	Properties  []cdxProperty `json:"properties,omitempty"`
+}
+
+type cdxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func newCycloneDXComponent(c SBOMComponent, ref string) cdxComponent {
+	dc := cdxComponent{
+		Type:    "firmware",
+		BOMRef:  ref,
+		Name:    c.Name,
+		Version: c.Version,
+		Hashes:  []cdxHash{{Alg: "SHA-256", Content: c.SHA256}},
+	}
+	if c.Kind != "" {
+		dc.Properties = append(dc.Properties, cdxProperty{"fiano:kind", c.Kind})
+	}
+	if c.Type != "" {
+		dc.Properties = append(dc.Properties, cdxProperty{"fiano:type", c.Type})
+	}
+	if c.GUID != nil {
+		dc.Properties = append(dc.Properties, cdxProperty{"fiano:guid", c.GUID.String()})
+	}
+	if c.FITCVersion != "" {
+		dc.Properties = append(dc.Properties, cdxProperty{"fiano:fitcVersion", c.FITCVersion})
+	}
+	for _, comp := range c.Compression {
+		dc.Properties = append(dc.Properties, cdxProperty{"fiano:compression", comp})
+	}
+	return dc
+}
+
+func newCycloneDXBOM(image SBOMComponent, comps []SBOMComponent, serial string, created time.Time) cdxBOM {
+	b := cdxBOM{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + serial,
+		Version:      1,
+		Metadata: cdxMetadata{
+			Timestamp: created.UTC().Format(time.RFC3339),
+			Tools:     cdxTools{Components: []cdxComponent{{Type: "application", Name: "utk"}}},
+			Component: newCycloneDXComponent(image, "image"),
+		},
+		Components: []cdxComponent{},
+	}
+	for i, c := range comps {
+		b.Components = append(b.Components, newCycloneDXComponent(c, fmt.Sprintf("%s-%d", c.Kind, i)))
+	}
+	return b
+}
+
+func init() {
+	RegisterCLI("sbom", "write an SBOM of the regions, modules, microcode, FSP components and EC firmware in the given format, spdx or cyclonedx", 1, func(args []string) (uefi.Visitor, error) {
+		if args[0] != SBOMSPDX && args[0] != SBOMCycloneDX {
+			return nil, fmt.Errorf("unknown SBOM format %q, expected %s or %s", args[0], SBOMSPDX, SBOMCycloneDX)
+		}
+		return &SBOM{Format: args[0], W: os.Stdout}, nil
+	})
+}
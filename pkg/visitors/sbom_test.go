@@ -0,0 +1,126 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestSBOM(t *testing.T) {
+	f := parseImage(t)
+	created := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	var b bytes.Buffer
+	v := &SBOM{Format: SBOMSPDX, Created: created, W: &b}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	var dxeCore, compressed bool
+	for _, c := range v.Components {
+		if c.GUID != nil && *c.GUID == *dxeCoreGUID {
+			dxeCore = c.Name == "DxeCore" && c.Kind == SBOMKindModule
+		}
+		if len(c.Compression) != 0 {
+			compressed = true
+		}
+	}
+	if !dxeCore || !compressed {
+		t.Errorf("got DXE core %t and compressed modules %t, want both", dxeCore, compressed)
+	}
+
+	var doc spdxDocument
+	if err := json.Unmarshal(b.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Packages) != len(v.Components)+1 || len(doc.Relationships) != len(doc.Packages) {
+		t.Errorf("got %d packages and %d relationships for %d components", len(doc.Packages), len(doc.Relationships), len(v.Components))
+	}
+	if doc.CreationInfo.Created != "2023-05-01T12:00:00Z" {
+		t.Errorf("got creation time %q", doc.CreationInfo.Created)
+	}
+
+	b.Reset()
+	v = &SBOM{Format: SBOMCycloneDX, Created: created, W: &b}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	var bom cdxBOM
+	if err := json.Unmarshal(b.Bytes(), &bom); err != nil {
+		t.Fatal(err)
+	}
+	if bom.BOMFormat != "CycloneDX" || len(bom.Components) != len(v.Components) {
+		t.Errorf("got %s with %d components, want CycloneDX with %d", bom.BOMFormat, len(bom.Components), len(v.Components))
+	}
+	if want := "urn:uuid:" + doc.DocumentNamespace[len("https://github.com/linuxboot/fiano/spdx/"):]; bom.SerialNumber != want {
+		t.Errorf("got serial number %q, want %q", bom.SerialNumber, want)
+	}
+
+	if err := (&SBOM{Format: "swid"}).Run(f); err == nil {
+		t.Errorf("unknown format: got nil, want error")
+	}
+}
+
+func TestSBOMFSP(t *testing.T) {
+	image, err := os.ReadFile("../../cmds/fspinfo/test_blobs/ApolloLakeFspBinPkg/Fsp.fd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := uefi.Parse(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &SBOM{Format: SBOMCycloneDX}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	var fsps []string
+	for _, c := range v.Components {
+		if c.Kind == SBOMKindFSP {
+			fsps = append(fsps, c.Name+" "+c.Version)
+		}
+	}
+	if len(fsps) != 3 || fsps[0] != "FSP-S $APLFSP$ 1.4.3.1" {
+		t.Errorf("got FSP components %q", fsps)
+	}
+}
+
+func TestMicrocodeComponents(t *testing.T) {
+	free := make([]byte, 0x200)
+	uefi.Erase(free, 0xFF)
+	body, _, err := placeMicrocode(free, makeMicrocode(t, 0x806ec, 0x94, 0xca, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _, err = placeMicrocode(body, makeMicrocode(t, 0x906ea, 0x22, 0xf4, 32)); err != nil {
+		t.Fatal(err)
+	}
+	comps := microcodeComponents(body)
+	if len(comps) != 2 {
+		t.Fatalf("got %d microcode updates, want 2", len(comps))
+	}
+	if c := comps[1]; c.Name != "microcode sig=0x906ea pf=0x22" || c.Version != "0xf4" {
+		t.Errorf("got %+v", c)
+	}
+}
+
+func TestSBOMFITCVersion(t *testing.T) {
+	c := SBOMComponent{Kind: SBOMKindRegion, Name: "ME", Type: "ME", FITCVersion: "11.8.50.3425"}
+	if got, want := c.description(), "region ME built by FITC 11.8.50.3425"; got != want {
+		t.Errorf("got description %q, want %q", got, want)
+	}
+	dc := newCycloneDXComponent(c, "ref")
+	if dc.Version != "" {
+		t.Errorf("got version %q, want none", dc.Version)
+	}
+	if p := dc.Properties[len(dc.Properties)-1]; p != (cdxProperty{"fiano:fitcVersion", "11.8.50.3425"}) {
+		t.Errorf("got property %+v", p)
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// Script is a sequence of visitor invocations which can be saved to a JSON
+// file and replayed against other images.
+type Script struct {
+	Commands []ScriptCommand
+}
+
+// ScriptCommand is a single visitor invocation as it appears on the command
+// line.
+type ScriptCommand struct {
+	Name string
+	Args []string `json:",omitempty"`
+}
+
+// ReadScript reads a script from a JSON file.
+func ReadScript(path string) (Script, error) {
+	var script Script
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return script, err
+	}
+	if err := json.Unmarshal(buf, &script); err != nil {
+		return script, fmt.Errorf("unable to parse script %q: %v", path, err)
+	}
+	return script, nil
+}
+
+// Write writes the script to a JSON file.
+func (s Script) Write(path string) error {
+	buf, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFile(path, append(buf, '\n'))
+}
+
+// Visitors constructs the visitors of the script. Record visitors receive
+// the commands which follow them.
+func (s Script) Visitors() ([]uefi.Visitor, error) {
+	visitors := []uefi.Visitor{}
+	for i, c := range s.Commands {
+		o, ok := lookupCLI(c.Name)
+		if !ok {
+			return []uefi.Visitor{}, fmt.Errorf("could not find command '%s'\n%s", c.Name, helpMessage)
+		}
+		if o.numArgs != len(c.Args) {
+			return []uefi.Visitor{}, fmt.Errorf("wrong number of arguments for command '%s', got %d, expected %d.\nSynopsis: %s",
+				c.Name, len(c.Args), o.numArgs, o.help)
+		}
+		visitor, err := o.createVisitor(c.Args)
+		if err != nil {
+			return []uefi.Visitor{}, err
+		}
+		if r, ok := visitor.(*Record); ok {
+			r.Script = Script{Commands: s.Commands[i+1:]}
+		}
+		visitors = append(visitors, visitor)
+	}
+	return visitors, nil
+}
+
+// Record saves the commands which follow it on the command line to a script
+// file. It does not modify the image.
+type Record struct {
+	Path   string
+	Script Script
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Record) Run(f uefi.Firmware) error {
+	return v.Script.Write(v.Path)
+}
+
+// Visit applies the Record visitor to any Firmware type.
+func (v *Record) Visit(f uefi.Firmware) error {
+	return nil
+}
+
+// Replay applies the commands of a script to the image.
+type Replay struct {
+	Script Script
+
+	// Output
+	root uefi.Firmware
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Replay) Run(f uefi.Firmware) error {
+	v.root = nil
+	visitors, err := v.Script.Visitors()
+	if err != nil {
+		return err
+	}
+	root, err := ExecuteCLIRoot(context.Background(), f, visitors)
+	if root != f {
+		v.root = root
+	}
+	return err
+}
+
+// Root implements RootReplacer: it is the firmware which a command of the
+// script replaced the image with, if any.
+func (v *Replay) Root() uefi.Firmware {
+	return v.root
+}
+
+// Mutates implements Mutator, it is true if one of the commands of the
+// script modifies the firmware.
+func (v *Replay) Mutates() bool {
+	visitors, err := v.Script.Visitors()
+	if err != nil {
+		// Run fails the same.
+		return false
+	}
+	for _, visitor := range visitors {
+		if mutates(visitor) {
+			return true
+		}
+	}
+	return false
+}
+
+// Visit applies the Replay visitor to any Firmware type.
+func (v *Replay) Visit(f uefi.Firmware) error {
+	return nil
+}
+
+func init() {
+	RegisterCLI("record", "save the commands which follow to a JSON script file", 1, func(args []string) (uefi.Visitor, error) {
+		return &Record{
+			Path: args[0],
+		}, nil
+	})
+	RegisterCLI("replay", "apply the commands of a JSON script file", 1, func(args []string) (uefi.Visitor, error) {
+		script, err := ReadScript(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &Replay{
+			Script: script,
+		}, nil
+	})
+}
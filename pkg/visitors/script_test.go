@@ -0,0 +1,57 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "script.json")
+	v, err := ParseCLI([]string{"record", path, "remove", testGUID.String(), "count"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v) != 3 {
+		t.Fatalf("got %d visitors, want 3", len(v))
+	}
+
+	f := parseImage(t)
+	if err := ExecuteCLI(f, v[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	script, err := ReadScript(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Script{Commands: []ScriptCommand{
+		{Name: "remove", Args: []string{testGUID.String()}},
+		{Name: "count"},
+	}}
+	if !reflect.DeepEqual(script, want) {
+		t.Fatalf("got script %+v, want %+v", script, want)
+	}
+
+	if err := (&Replay{Script: script}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if results := find(t, f, testGUID); len(results) != 0 {
+		t.Errorf("replayed remove did not remove %v", testGUID)
+	}
+}
+
+func TestScriptVisitorsErrors(t *testing.T) {
+	for _, s := range []Script{
+		{Commands: []ScriptCommand{{Name: "bogus"}}},
+		{Commands: []ScriptCommand{{Name: "remove"}}},
+	} {
+		if _, err := s.Visitors(); err == nil {
+			t.Errorf("script %+v: expected error", s)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+	"github.com/linuxboot/fiano/pkg/knownguids"
+	"github.com/linuxboot/fiano/pkg/log"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+//go:embed web/index.html
+var serveIndex []byte
+
+// ServeNode describes a node of the image served by Serve. Nodes are
+// numbered in tree order, the root is 0.
+type ServeNode struct {
+	ID       int
+	Type     string
+	Name     string     `json:",omitempty"`
+	GUID     *guid.GUID `json:",omitempty"`
+	Size     int
+	Children []*ServeNode `json:",omitempty"`
+}
+
+// Serve serves the image over HTTP, so it can be browsed without the
+// toolchain. It serves a web viewer at / and a JSON API:
+//
+//	GET /api/tree               the tree of ServeNodes
+//	GET /api/node/ID            the ServeNode ID, without its children
+//	GET /api/node/ID/content    the buffer of node ID
+//	GET /api/search?q=REGEX     the ServeNodes of the files matched as by find
+//
+// Run only returns on error.
+type Serve struct {
+	// Input
+	Addr string
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Serve) Run(f uefi.Firmware) error {
+	h, err := NewServeHandler(f)
+	if err != nil {
+		return err
+	}
+	log.Infof("serving the image on http://%s/", v.Addr)
+	return http.ListenAndServe(v.Addr, h)
+}
+
+// Visit applies the Serve visitor to any Firmware type.
+func (v *Serve) Visit(f uefi.Firmware) error {
+	return nil
+}
+
+// serveHandler serves the index of the nodes of an image.
+type serveHandler struct {
+	root  uefi.Firmware
+	tree  *ServeNode
+	nodes []uefi.Firmware
+	info  []*ServeNode
+	ids   map[uefi.Firmware]int
+}
+
+// childCollector lists the direct children of a node.
+type childCollector struct {
+	children []uefi.Firmware
+}
+
+func (v *childCollector) Run(f uefi.Firmware) error {
+	return f.ApplyChildren(v)
+}
+
+func (v *childCollector) Visit(f uefi.Firmware) error {
+	v.children = append(v.children, f)
+	return nil
+}
+
+// NewServeHandler returns the handler of Serve for the image f. The image
+// must not be modified while it is served.
+func NewServeHandler(f uefi.Firmware) (http.Handler, error) {
+	h := &serveHandler{root: f, ids: map[uefi.Firmware]int{}}
+	var err error
+	if h.tree, err = h.index(f); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// index numbers f and its descendants and returns the tree of their nodes.
+func (h *serveHandler) index(f uefi.Firmware) (*ServeNode, error) {
+	n := serveNode(f)
+	n.ID = len(h.nodes)
+	h.nodes = append(h.nodes, f)
+	h.info = append(h.info, n)
+	h.ids[f] = n.ID
+	c := &childCollector{}
+	if err := c.Run(f); err != nil {
+		return nil, err
+	}
+	for _, child := range c.children {
+		cn, err := h.index(child)
+		if err != nil {
+			return nil, err
+		}
+		n.Children = append(n.Children, cn)
+	}
+	return n, nil
+}
+
+// serveNode describes f, without its children.
+func serveNode(f uefi.Firmware) *ServeNode {
+	n := &ServeNode{
+		Type: strings.TrimPrefix(fmt.Sprintf("%T", f), "*uefi."),
+		Size: len(f.Buf()),
+	}
+	switch f := f.(type) {
+	case *uefi.File:
+		g := f.Header.GUID
+		n.GUID = &g
+		if n.Name = FileUIName(f); n.Name == "" {
+			n.Name, _ = knownguids.Name(g)
+		}
+	case *uefi.FirmwareVolume:
+		g := f.FileSystemGUID
+		n.GUID = &g
+		if f.ExtHeaderOffset != 0 {
+			n.Name = f.FVName.String()
+		}
+	case *uefi.Section:
+		n.Name = f.Type
+		if f.Name != "" {
+			n.Name = f.Name
+		}
+	case *uefi.NVar:
+		g := f.GUID
+		n.GUID = &g
+		n.Name = f.Name
+	case uefi.Region:
+		n.Name = f.Type().String()
+	}
+	return n
+}
+
+func (h *serveHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch p := r.URL.Path; {
+	case p == "/":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(serveIndex)
+	case p == "/api/tree":
+		writeServeJSON(w, h.tree)
+	case p == "/api/search":
+		h.search(w, r.URL.Query().Get("q"))
+	case strings.HasPrefix(p, "/api/node/"):
+		h.node(w, strings.TrimPrefix(p, "/api/node/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// node serves the node, or its content for a path ending in /content.
+func (h *serveHandler) node(w http.ResponseWriter, p string) {
+	p, content := strings.CutSuffix(p, "/content")
+	id, err := strconv.Atoi(p)
+	if err != nil || id < 0 || id >= len(h.nodes) {
+		http.Error(w, fmt.Sprintf("no node %q", p), http.StatusNotFound)
+		return
+	}
+	if !content {
+		n := *h.info[id]
+		n.Children = nil
+		writeServeJSON(w, n)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("node%d.bin", id)))
+	w.Write(h.nodes[id].Buf())
+}
+
+// search serves the files matching q as by the find command.
+func (h *serveHandler) search(w http.ResponseWriter, q string) {
+	if q == "" {
+		http.Error(w, "missing query q", http.StatusBadRequest)
+		return
+	}
+	pred, err := FindFileNamePredicate(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	find := &Find{Predicate: pred}
+	if err := find.Run(h.root); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	matches := []ServeNode{}
+	for _, m := range find.Matches {
+		if id, ok := h.ids[m]; ok {
+			n := *h.info[id]
+			n.Children = nil
+			matches = append(matches, n)
+		}
+	}
+	writeServeJSON(w, matches)
+}
+
+func writeServeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("serving JSON: %v", err)
+	}
+}
+
+func init() {
+	RegisterCLI("serve", "serve the image over HTTP on the given address, with a web viewer and a JSON API", 1, func(args []string) (uefi.Visitor, error) {
+		return &Serve{Addr: args[0]}, nil
+	})
+}
@@ -0,0 +1,81 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServe(t *testing.T) {
+	f := parseImage(t)
+	h, err := NewServeHandler(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	get := func(path string, wantStatus int) []byte {
+		t.Helper()
+		resp, err := http.Get(s.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != wantStatus {
+			t.Fatalf("GET %s: got status %d, want %d: %s", path, resp.StatusCode, wantStatus, b)
+		}
+		return b
+	}
+
+	if b := get("/", http.StatusOK); !strings.Contains(string(b), "/api/tree") {
+		t.Errorf("got an index page without the viewer")
+	}
+
+	var tree ServeNode
+	if err := json.Unmarshal(get("/api/tree", http.StatusOK), &tree); err != nil {
+		t.Fatal(err)
+	}
+	if tree.ID != 0 || tree.Size != len(f.Buf()) || len(tree.Children) == 0 {
+		t.Errorf("got root %+v", tree)
+	}
+
+	var matches []ServeNode
+	if err := json.Unmarshal(get("/api/search?q=DxeCore", http.StatusOK), &matches); err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || matches[0].GUID == nil || *matches[0].GUID != *dxeCoreGUID || matches[0].Name != "DxeCore" {
+		t.Fatalf("got matches %+v, want the DXE core", matches)
+	}
+	id := matches[0].ID
+
+	var n ServeNode
+	if err := json.Unmarshal(get(fmt.Sprintf("/api/node/%d", id), http.StatusOK), &n); err != nil {
+		t.Fatal(err)
+	}
+	if n.ID != id || n.Name != "DxeCore" || n.Children != nil {
+		t.Errorf("got node %+v", n)
+	}
+	content := get(fmt.Sprintf("/api/node/%d/content", id), http.StatusOK)
+	if file := find(t, f, dxeCoreGUID)[0]; !bytes.Equal(content, file.Buf()) {
+		t.Errorf("got %d bytes of content, want the %d bytes of the DXE core", len(content), len(file.Buf()))
+	}
+
+	get("/api/node/100000", http.StatusNotFound)
+	get("/api/node/x/content", http.StatusNotFound)
+	get("/api/search?q=(", http.StatusBadRequest)
+	get("/api/nothing", http.StatusNotFound)
+}
@@ -0,0 +1,193 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// DefaultStringsMinLength is the default minimum length of extracted strings.
+const DefaultStringsMinLength = 6
+
+// FoundString is a printable string found in a module.
+type FoundString struct {
+	GUID string
+	Name string `json:",omitempty"`
+	// Section is the type of the section holding the string.
+	Section string
+	// Offset of the string within the section data.
+	Offset uint64
+	// Encoding is either "ascii" or "utf16".
+	Encoding string
+	Value    string
+}
+
+// Strings extracts printable ASCII and UTF-16 strings from the leaf
+// sections of all files. Compressed sections are visited after they have
+// been decompressed, so the strings come from the decompressed modules.
+type Strings struct {
+	// Input
+	// MinLength is the minimum number of characters of a string.
+	MinLength int
+	// Regexp optionally filters the strings.
+	Regexp *regexp.Regexp
+
+	// Strings are written to this writer, one per line, or as a list
+	// when OutputFormat selects structured output.
+	W io.Writer
+
+	// Output
+	Matches []FoundString
+
+	// Private
+	curFile *uefi.File
+	curName string
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Strings) Run(f uefi.Firmware) error {
+	if v.MinLength <= 0 {
+		v.MinLength = DefaultStringsMinLength
+	}
+	v.Matches = []FoundString{}
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	if v.W != nil && structuredOutput() {
+		return writeStructured(v.W, v.Matches)
+	}
+	return nil
+}
+
+// Visit applies the Strings visitor to any Firmware type.
+func (v *Strings) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.File:
+		prevFile, prevName := v.curFile, v.curName
+		v.curFile, v.curName = f, FileUIName(f)
+		defer func() { v.curFile, v.curName = prevFile, prevName }()
+
+	case *uefi.Section:
+		if len(f.Encapsulated) == 0 && v.curFile != nil {
+			hl := uint64(sectionHeaderLen(f))
+			buf := f.Buf()
+			if uint64(len(buf)) > hl {
+				v.extract(f, buf[hl:])
+			}
+		}
+	}
+	return f.ApplyChildren(v)
+}
+
+func (v *Strings) extract(s *uefi.Section, buf []byte) {
+	add := func(offset int, enc, value string) {
+		if v.Regexp != nil && !v.Regexp.MatchString(value) {
+			return
+		}
+		m := FoundString{
+			GUID:     v.curFile.Header.GUID.String(),
+			Name:     v.curName,
+			Section:  s.Type,
+			Offset:   uint64(offset),
+			Encoding: enc,
+			Value:    value,
+		}
+		v.Matches = append(v.Matches, m)
+		if v.W != nil && !structuredOutput() {
+			fmt.Fprintf(v.W, "%s\t%s\t%#x\t%s\t%q\n", m.GUID, m.Name, m.Offset, m.Encoding, m.Value)
+		}
+	}
+	for _, r := range asciiStrings(buf, v.MinLength) {
+		add(r.offset, "ascii", r.value)
+	}
+	for _, r := range utf16Strings(buf, v.MinLength) {
+		add(r.offset, "utf16", r.value)
+	}
+}
+
+type foundRun struct {
+	offset int
+	value  string
+}
+
+func isPrintable(b byte) bool {
+	return (b >= ' ' && b <= '~') || b == '\t'
+}
+
+// asciiStrings returns the runs of at least min printable ASCII characters.
+func asciiStrings(buf []byte, min int) []foundRun {
+	var runs []foundRun
+	start := -1
+	for i := 0; i <= len(buf); i++ {
+		if i < len(buf) && isPrintable(buf[i]) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 && i-start >= min {
+			runs = append(runs, foundRun{start, string(buf[start:i])})
+		}
+		start = -1
+	}
+	return runs
+}
+
+// utf16Strings returns the runs of at least min printable ASCII characters
+// encoded as UTF-16LE.
+func utf16Strings(buf []byte, min int) []foundRun {
+	var runs []foundRun
+	for i := 0; i+1 < len(buf); {
+		j := i
+		var s []byte
+		for j+1 < len(buf) && isPrintable(buf[j]) && buf[j+1] == 0 {
+			s = append(s, buf[j])
+			j += 2
+		}
+		if len(s) >= min {
+			runs = append(runs, foundRun{i, string(s)})
+		}
+		if j > i {
+			i = j
+		} else {
+			i++
+		}
+	}
+	return runs
+}
+
+func init() {
+	RegisterCLI("strings", "extract printable strings of at least the given length from all modules", 1, func(args []string) (uefi.Visitor, error) {
+		min, err := strconv.Atoi(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &Strings{
+			MinLength: min,
+			W:         os.Stdout,
+		}, nil
+	})
+	RegisterCLI("strings_match", "extract printable strings of at least the given length matching the regex from all modules", 2, func(args []string) (uefi.Visitor, error) {
+		min, err := strconv.Atoi(args[0])
+		if err != nil {
+			return nil, err
+		}
+		re, err := regexp.Compile(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return &Strings{
+			MinLength: min,
+			Regexp:    re,
+			W:         os.Stdout,
+		}, nil
+	})
+}
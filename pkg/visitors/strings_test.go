@@ -0,0 +1,47 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestFindStringRuns(t *testing.T) {
+	buf := []byte("\x00\x01hello world\x00ab\x00\xffH\x00e\x00l\x00l\x00o\x00\x00\x00")
+	if got, want := asciiStrings(buf, 4), []foundRun{{2, "hello world"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("asciiStrings: got %v, want %v", got, want)
+	}
+	if got, want := utf16Strings(buf, 4), []foundRun{{18, "Hello"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("utf16Strings: got %v, want %v", got, want)
+	}
+}
+
+func TestStrings(t *testing.T) {
+	f := parseImage(t)
+
+	v := &Strings{MinLength: 7, Regexp: regexp.MustCompile(`DxeCore`)}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Matches) == 0 {
+		t.Fatalf("no strings found")
+	}
+	for _, m := range v.Matches {
+		if !v.Regexp.MatchString(m.Value) || len(m.Value) < 7 {
+			t.Errorf("unexpected match %+v", m)
+		}
+	}
+	var found bool
+	for _, m := range v.Matches {
+		if m.GUID == dxeCoreGUID.String() && m.Encoding == "utf16" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("UI name of DxeCore not found in %+v", v.Matches)
+	}
+}
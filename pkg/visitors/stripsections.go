@@ -0,0 +1,190 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// StripSavings reports the space reclaimed in a firmware volume.
+type StripSavings struct {
+	FV       string
+	Sections int
+	Saved    uint64
+}
+
+// StripSections removes UI and version sections to reclaim space in tightly
+// packed volumes. The image is reassembled to compute the bytes saved in
+// each firmware volume.
+type StripSections struct {
+	// Input
+	// FileTypes restricts the stripping to files of these types. When
+	// empty, all files are stripped.
+	FileTypes []uefi.FVFileType
+
+	// JSON is written to this writer.
+	W io.Writer
+
+	// Output
+	Savings []StripSavings
+
+	// Private
+	fvs     []*uefi.FirmwareVolume
+	removed map[*uefi.FirmwareVolume]int
+	curFV   *uefi.FirmwareVolume
+}
+
+// Mutates implements Mutator.
+func (v *StripSections) Mutates() bool {
+	return true
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *StripSections) Run(f uefi.Firmware) error {
+	if err := writable(f); err != nil {
+		return err
+	}
+	v.fvs = nil
+	v.removed = map[*uefi.FirmwareVolume]int{}
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+
+	used := make([]uint64, len(v.fvs))
+	for i, fv := range v.fvs {
+		used[i] = fv.Length - fv.FreeSpace
+	}
+	if err := (&Assemble{}).Run(f); err != nil {
+		return err
+	}
+	v.Savings = []StripSavings{}
+	for i, fv := range v.fvs {
+		if v.removed[fv] == 0 {
+			continue
+		}
+		var saved uint64
+		if newUsed := fv.Length - fv.FreeSpace; newUsed < used[i] {
+			saved = used[i] - newUsed
+		}
+		v.Savings = append(v.Savings, StripSavings{
+			FV:       fv.String(),
+			Sections: v.removed[fv],
+			Saved:    saved,
+		})
+	}
+
+	if v.W != nil {
+		return writeStructured(v.W, v.Savings)
+	}
+	return nil
+}
+
+// Visit applies the StripSections visitor to any Firmware type.
+func (v *StripSections) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.FirmwareVolume:
+		v.fvs = append(v.fvs, f)
+		prev := v.curFV
+		v.curFV = f
+		err := f.ApplyChildren(v)
+		v.curFV = prev
+		return err
+
+	case *uefi.File:
+		if v.matchType(f.Header.Type) {
+			var n int
+			f.Sections, n = stripSections(f.Sections)
+			if v.curFV != nil {
+				v.removed[v.curFV] += n
+			}
+		}
+	}
+	return f.ApplyChildren(v)
+}
+
+func (v *StripSections) matchType(t uefi.FVFileType) bool {
+	if len(v.FileTypes) == 0 {
+		return true
+	}
+	for _, ft := range v.FileTypes {
+		if ft == t {
+			return true
+		}
+	}
+	return false
+}
+
+// stripSections removes UI and version sections from the list, including
+// the sections within encapsulation sections. It returns the new list and
+// the number of sections removed.
+func stripSections(sections []*uefi.Section) ([]*uefi.Section, int) {
+	var n int
+	kept := sections[:0]
+	for _, s := range sections {
+		if isStrippable(s) {
+			n++
+			continue
+		}
+		n += stripEncapsulated(s)
+		kept = append(kept, s)
+	}
+	return kept, n
+}
+
+func stripEncapsulated(s *uefi.Section) int {
+	var n int
+	kept := s.Encapsulated[:0]
+	for _, e := range s.Encapsulated {
+		if es, ok := e.Value.(*uefi.Section); ok {
+			if isStrippable(es) {
+				n++
+				continue
+			}
+			n += stripEncapsulated(es)
+		}
+		kept = append(kept, e)
+	}
+	s.Encapsulated = kept
+	return n
+}
+
+func isStrippable(s *uefi.Section) bool {
+	return s.Header.Type == uefi.SectionTypeUserInterface || s.Header.Type == uefi.SectionTypeVersion
+}
+
+// parseFileTypes parses a comma-separated list of file types, such as
+// "DRIVER,PEIM". "all" yields an empty list, which matches all files.
+func parseFileTypes(arg string) ([]uefi.FVFileType, error) {
+	if arg == "all" {
+		return nil, nil
+	}
+	var types []uefi.FVFileType
+	for _, name := range strings.Split(arg, ",") {
+		t, ok := uefi.NamesToFileType[strings.TrimPrefix(strings.ToUpper(name), "EFI_FV_FILETYPE_")]
+		if !ok {
+			return nil, fmt.Errorf("unknown file type %q", name)
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
+
+func init() {
+	RegisterCLI("strip_ui", "remove UI and version sections from files of the given comma-separated types, or \"all\"", 1, func(args []string) (uefi.Visitor, error) {
+		types, err := parseFileTypes(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &StripSections{
+			FileTypes: types,
+			W:         os.Stdout,
+		}, nil
+	})
+}
@@ -0,0 +1,60 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestStripSections(t *testing.T) {
+	f := parseImage(t)
+
+	v := &StripSections{FileTypes: []uefi.FVFileType{uefi.FVFileTypeDriver}}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Savings) == 0 {
+		t.Fatalf("expected savings to be reported")
+	}
+	var saved uint64
+	for _, s := range v.Savings {
+		saved += s.Saved
+	}
+	if saved == 0 {
+		t.Errorf("expected bytes to be saved, got %+v", v.Savings)
+	}
+
+	count := &Count{}
+	if err := count.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	// Only the drivers were stripped.
+	if count.SectionTypeCount["EFI_SECTION_USER_INTERFACE"] == 0 {
+		t.Errorf("UI sections of other file types were removed")
+	}
+	pred := FindAndPredicate(FindFileTypePredicate(uefi.FVFileTypeDriver), FindSectionTypePredicate(uefi.SectionTypeUserInterface))
+	find := &Find{Predicate: pred}
+	if err := find.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(find.Matches) != 0 {
+		t.Errorf("%d drivers still have UI sections", len(find.Matches))
+	}
+}
+
+func TestParseFileTypes(t *testing.T) {
+	types, err := parseFileTypes("DRIVER,EFI_FV_FILETYPE_PEIM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(types) != 2 || types[0] != uefi.FVFileTypeDriver || types[1] != uefi.FVFileTypePEIM {
+		t.Errorf("got %v", types)
+	}
+	if _, err := parseFileTypes("BOGUS"); err == nil {
+		t.Errorf("expected error for unknown type")
+	}
+}
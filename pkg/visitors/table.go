@@ -6,30 +6,89 @@ package visitors
 
 import (
 	"bytes"
+	"encoding/csv"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"text/tabwriter"
 
+	"github.com/linuxboot/fiano/pkg/guid"
 	"github.com/linuxboot/fiano/pkg/knownguids"
 	"github.com/linuxboot/fiano/pkg/uefi"
 )
 
+// TableColumns lists the columns which can be selected for CSV and TSV
+// output, in their default order. The chip columns are only filled for the
+// images held by several flash chips.
+var TableColumns = []string{"depth", "node", "guid", "name", "type", "offset", "size", "compressed-size", "annotation", "chip", "chip-offset", "inf", "base-address"}
+
 // Table prints the GUIDS, types and sizes as a compact table.
 type Table struct {
-	W         *tabwriter.Writer
-	Scan      bool
-	Layout    bool
-	Depth     int
+	W      *tabwriter.Writer
+	Scan   bool
+	Layout bool
+	Depth  int
+
+	// Format selects machine-readable output: "csv", "tsv", "json" or
+	// "yaml". The default empty string prints the human-readable table to
+	// W, unless OutputFormat selects structured output.
+	Format string
+	// Columns selects the columns printed in CSV or TSV format. When empty,
+	// all of TableColumns are printed.
+	Columns []string
+	// Out receives the machine-readable output. Defaults to os.Stdout.
+	Out io.Writer
+	// Annotations are printed in an extra column when set.
+	Annotations Annotations
+
 	indent    int
 	offset    uint64
 	curOffset uint64
-	printRow  func(v *Table, node, name, typez interface{}, offset, length uint64)
+	// image is the flash image, to locate the offsets in its chips, and
+	// relative is set in the decompressed sections, whose offsets are not
+	// flash offsets.
+	image    *uefi.FlashImage
+	relative bool
+	// path is the path of the printed node, and parent that of its parent
+	// when nested is set, i.e. below the root, to look the annotations up.
+	path     string
+	parent   string
+	nested   bool
+	csv      *csv.Writer
+	rows     *[]TableRow
+	printRow func(v *Table, f uefi.Firmware, node, name, typez interface{}, offset, length uint64)
+}
+
+// TableRow is a row of the table in JSON or YAML format.
+type TableRow struct {
+	Depth          int
+	Node           string
+	GUID           string `json:",omitempty"`
+	Name           string `json:",omitempty"`
+	Type           string `json:",omitempty"`
+	Offset         uint64
+	Size           uint64
+	CompressedSize uint64 `json:",omitempty"`
+	Annotation     string `json:",omitempty"`
+	// Chip is the flash chip holding the start of the row, and the offset
+	// in that chip, when the image spans several chips.
+	Chip *uefi.ChipLocation `json:",omitempty"`
+	// INFPath and BaseAddress are the source module of a file and its
+	// build-time address, from BuildReport.
+	INFPath     string  `json:",omitempty"`
+	BaseAddress *uint64 `json:",omitempty"`
 }
 
 // Run wraps Visit and performs some setup and teardown tasks.
 func (v *Table) Run(f uefi.Firmware) error {
-	return f.Apply(v)
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	if v.rows != nil {
+		return writeFormat(v.out(), v.Format, *v.rows)
+	}
+	return nil
 }
 
 // Visit applies the Table visitor to any Firmware type.
@@ -40,6 +99,7 @@ func (v *Table) Visit(f uefi.Firmware) error {
 		if v.Depth > 0 { // Depth <= 0 means all
 			v.Depth++
 		}
+		v.image = f
 		return v.printFirmware(f, "Image", "", "", 0, 0)
 	case *uefi.FirmwareVolume:
 		return v.printFirmware(f, "FV", f.String(), f.FVType, v.offset+f.FVOffset, v.offset+f.FVOffset+f.DataOffset)
@@ -84,14 +144,15 @@ func indent(n int) string {
 }
 
 func scanGUID(v *Table, b []byte) {
-	for g := range knownguids.GUIDs {
+	knownguids.Range(func(g guid.GUID, _ string) bool {
 		if bytes.Contains(b, g[:]) {
 			fmt.Fprintf(v.W, "%s\t(RAW)\t%s\n", indent(v.indent), g.String())
 		}
 		if strings.Contains(string(b), g.String()) {
 			fmt.Fprintf(v.W, "%s\t(STRING)\t%s\n", indent(v.indent), g.String())
 		}
-	}
+		return true
+	})
 }
 
 func (v *Table) printFirmware(f uefi.Firmware, node, name, typez interface{}, offset, dataOffset uint64) error {
@@ -99,29 +160,47 @@ func (v *Table) printFirmware(f uefi.Firmware, node, name, typez interface{}, of
 	if v.W == nil {
 		v.W = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
 		defer func() { v.W.Flush() }()
-		if v.Layout {
-			fmt.Fprintf(v.W, "%sNode\tGUID/Name/Type\tOffset\tSize\n", indent(v.indent))
+		if v.Format == "" && structuredOutput() {
+			v.Format = OutputFormat
+		}
+		if v.Format == FormatJSON || v.Format == FormatYAML {
+			v.rows = &[]TableRow{}
+			v.printRow = printRowStructured
+		} else if v.Format != "" {
+			if err := v.initCSV(); err != nil {
+				return err
+			}
+			defer func() { v.csv.Flush() }()
+		} else if v.Layout {
+			fmt.Fprintf(v.W, "%sNode\tGUID/Name/Type\tOffset\tSize%s\n", indent(v.indent), v.noteHeader())
 			v.printRow = printRowLayout
 		} else {
-			fmt.Fprintf(v.W, "%sNode\tGUID/Name\tType\tSize\n", indent(v.indent))
+			fmt.Fprintf(v.W, "%sNode\tGUID/Name\tType\tSize%s\n", indent(v.indent), v.noteHeader())
 			v.printRow = printRowStd
 		}
 	}
 
 	// Prepare data and print
+	if v.nested {
+		v.path = childPath(v.parent, f)
+	}
 	length := uint64(len(f.Buf()))
 	if typez == "" {
 		if uefi.IsErased(f.Buf(), uefi.Attributes.ErasePolarity) {
 			typez = "(empty)"
 		}
 	}
-	v.printRow(v, node, name, typez, offset, length)
+	v.printRow(v, f, node, name, typez, offset, length)
 	v2 := *v
 	v2.indent++
 	v2.offset = dataOffset
 	v2.curOffset = v2.offset
+	v2.parent, v2.nested = v.path, true
+	if _, ok := f.(*uefi.Section); ok {
+		v2.relative = true
+	}
 
-	if v.Scan {
+	if v.Scan && v.rows == nil {
 		switch s := f.(type) {
 		case *uefi.Section:
 			switch s.Header.Type {
@@ -146,19 +225,24 @@ func (v *Table) printFirmware(f uefi.Firmware, node, name, typez interface{}, of
 			return err
 		}
 	}
+	if v.csv != nil {
+		if err := v.csv.Error(); err != nil {
+			return err
+		}
+	}
 	v.curOffset += length
 
 	// Print footer
 	switch f := f.(type) {
 	case *uefi.FirmwareVolume:
 		// Print free space at the end of the volume
-		v2.printRow(&v2, "Free", "", "", offset+length-f.FreeSpace, f.FreeSpace)
+		v2.printRow(&v2, nil, "Free", "", "", offset+length-f.FreeSpace, f.FreeSpace)
 	case *uefi.NVarStore:
 		// Print free space and GUID store
-		v2.printRow(&v2, "Free", "", "", offset+f.FreeSpaceOffset, f.GUIDStoreOffset-f.FreeSpaceOffset)
-		v2.printRow(&v2, "GUIDStore", "", fmt.Sprintf("%d GUID", len(f.GUIDStore)), offset+f.GUIDStoreOffset, f.Length-f.GUIDStoreOffset)
+		v2.printRow(&v2, nil, "Free", "", "", offset+f.FreeSpaceOffset, f.GUIDStoreOffset-f.FreeSpaceOffset)
+		v2.printRow(&v2, nil, "GUIDStore", "", fmt.Sprintf("%d GUID", len(f.GUIDStore)), offset+f.GUIDStoreOffset, f.Length-f.GUIDStoreOffset)
 	case *uefi.MERegion:
-		v2.printRow(&v2, "Free", "", "", offset+f.FreeSpaceOffset, length-f.FreeSpaceOffset)
+		v2.printRow(&v2, nil, "Free", "", "", offset+f.FreeSpaceOffset, length-f.FreeSpaceOffset)
 	case *uefi.MEFPT:
 		// MERegion is not entered, simply print the $FPT content here
 		for _, p := range f.Entries {
@@ -166,7 +250,7 @@ func (v *Table) printFirmware(f uefi.Firmware, node, name, typez interface{}, of
 			if p.OffsetIsValid() {
 				po = offset + uint64(p.Offset)
 			}
-			v2.printRow(&v2, p.Name, "", p.Type(), po, uint64(p.Length))
+			v2.printRow(&v2, nil, p.Name, "", p.Type(), po, uint64(p.Length))
 		}
 	case *uefi.File:
 		// Align
@@ -175,15 +259,240 @@ func (v *Table) printFirmware(f uefi.Firmware, node, name, typez interface{}, of
 	return nil
 }
 
-func printRowLayout(v *Table, node, name, typez interface{}, offset, length uint64) {
+func printRowLayout(v *Table, f uefi.Firmware, node, name, typez interface{}, offset, length uint64) {
 	if name == "" {
 		name = typez
 	}
-	fmt.Fprintf(v.W, "%s%v\t%v\t%#08x\t%#08x\n", indent(v.indent), node, name, offset, length)
+	o := fmt.Sprintf("%#08x", offset)
+	if c := v.chip(offset); c != nil {
+		o += fmt.Sprintf(" (%v)", c)
+	}
+	fmt.Fprintf(v.W, "%s%v\t%v\t%s\t%#08x%s\n", indent(v.indent), node, name, o, length, v.note(f))
+}
+
+// chip locates offset in the flash chips, see uefi.LocateChip.
+func (v *Table) chip(offset uint64) *uefi.ChipLocation {
+	if v.image == nil || v.relative {
+		return nil
+	}
+	return uefi.LocateChip(v.image, offset)
+}
+
+func printRowStd(v *Table, f uefi.Firmware, node, name, typez interface{}, offset, length uint64) {
+	fmt.Fprintf(v.W, "%s%v\t%v\t%v\t%#8x%s\n", indent(v.indent), node, name, typez, length, v.note(f))
+}
+
+func (v *Table) noteHeader() string {
+	var h string
+	if v.Annotations != nil {
+		h += "\tNote"
+	}
+	if BuildReport != nil {
+		h += "\tSource"
+	}
+	return h
+}
+
+// note returns the annotation and source module columns of the text table.
+func (v *Table) note(f uefi.Firmware) string {
+	var s string
+	if v.Annotations != nil {
+		s += "\t" + v.annotation(f)
+	}
+	if BuildReport != nil {
+		var source string
+		if m, ok := fileModule(f); ok {
+			source = moduleSource(m)
+		}
+		s += "\t" + source
+	}
+	return s
+}
+
+// annotation returns the annotation of the printed node f, if any.
+func (v *Table) annotation(f uefi.Firmware) string {
+	if f == nil {
+		return ""
+	}
+	note, _ := v.Annotations.Lookup(f, v.path)
+	return note
+}
+
+func (v *Table) out() io.Writer {
+	if v.Out == nil {
+		return os.Stdout
+	}
+	return v.Out
+}
+
+func (v *Table) initCSV() error {
+	v.csv = csv.NewWriter(v.out())
+	switch v.Format {
+	case "csv":
+	case "tsv":
+		v.csv.Comma = '\t'
+	default:
+		return fmt.Errorf("unknown table format %q, expected csv, tsv, json or yaml", v.Format)
+	}
+	if len(v.Columns) == 0 {
+		v.Columns = TableColumns
+	}
+	for _, c := range v.Columns {
+		if !isTableColumn(c) {
+			return fmt.Errorf("unknown table column %q, expected one of %v", c, TableColumns)
+		}
+	}
+	v.printRow = printRowCSV
+	return v.csv.Write(v.Columns)
 }
 
-func printRowStd(v *Table, node, name, typez interface{}, offset, length uint64) {
-	fmt.Fprintf(v.W, "%s%v\t%v\t%v\t%#8x\n", indent(v.indent), node, name, typez, length)
+func isTableColumn(c string) bool {
+	for _, col := range TableColumns {
+		if c == col {
+			return true
+		}
+	}
+	return false
+}
+
+func printRowCSV(v *Table, f uefi.Firmware, node, name, typez interface{}, offset, length uint64) {
+	record := make([]string, len(v.Columns))
+	for i, c := range v.Columns {
+		switch c {
+		case "depth":
+			record[i] = fmt.Sprint(v.indent)
+		case "node":
+			record[i] = fmt.Sprint(node)
+		case "guid":
+			record[i] = tableGUID(f)
+		case "name":
+			record[i] = tableName(f, name)
+		case "type":
+			record[i] = fmt.Sprint(typez)
+		case "offset":
+			record[i] = fmt.Sprintf("%#x", offset)
+		case "size":
+			record[i] = fmt.Sprintf("%#x", length)
+		case "compressed-size":
+			if n, ok := compressedSize(f); ok {
+				record[i] = fmt.Sprintf("%#x", n)
+			}
+		case "annotation":
+			record[i] = v.annotation(f)
+		case "chip":
+			if c := v.chip(offset); c != nil {
+				record[i] = fmt.Sprint(c.Chip)
+			}
+		case "chip-offset":
+			if c := v.chip(offset); c != nil {
+				record[i] = fmt.Sprintf("%#x", c.Offset)
+			}
+		case "inf":
+			if m, ok := fileModule(f); ok {
+				record[i] = m.INFPath
+			}
+		case "base-address":
+			if m, ok := fileModule(f); ok && m.BaseAddress != nil {
+				record[i] = fmt.Sprintf("%#x", *m.BaseAddress)
+			}
+		}
+	}
+	// Errors are sticky and checked by printFirmware.
+	_ = v.csv.Write(record)
+}
+
+func printRowStructured(v *Table, f uefi.Firmware, node, name, typez interface{}, offset, length uint64) {
+	row := TableRow{
+		Depth:  v.indent,
+		Node:   fmt.Sprint(node),
+		GUID:   tableGUID(f),
+		Name:   tableName(f, name),
+		Type:   fmt.Sprint(typez),
+		Offset: offset,
+		Size:   length,
+	}
+	row.CompressedSize, _ = compressedSize(f)
+	row.Annotation = v.annotation(f)
+	row.Chip = v.chip(offset)
+	if m, ok := fileModule(f); ok {
+		row.INFPath, row.BaseAddress = m.INFPath, m.BaseAddress
+	}
+	*v.rows = append(*v.rows, row)
+}
+
+// tableGUID returns the GUID identifying the firmware node, if any.
+func tableGUID(f uefi.Firmware) string {
+	switch f := f.(type) {
+	case *uefi.FirmwareVolume:
+		return f.FVName.String()
+	case *uefi.File:
+		return f.Header.GUID.String()
+	case *uefi.NVar:
+		return f.GUID.String()
+	case *uefi.Section:
+		if f.TypeSpecific != nil && f.TypeSpecific.Header != nil {
+			if h, ok := f.TypeSpecific.Header.(*uefi.SectionGUIDDefined); ok {
+				return h.GUID.String()
+			}
+		}
+	}
+	return ""
+}
+
+// tableName returns the human-readable name of the firmware node. Files
+// take their name from the user interface section.
+func tableName(f uefi.Firmware, name interface{}) string {
+	switch f := f.(type) {
+	case *uefi.File:
+		for _, s := range f.Sections {
+			if s.Header.Type == uefi.SectionTypeUserInterface {
+				return s.Name
+			}
+		}
+		if m, ok := fileModule(f); ok {
+			return m.Name
+		}
+		return ""
+	case *uefi.NVar:
+		return f.Name
+	case *uefi.Section:
+		return f.String()
+	case *uefi.FirmwareVolume:
+		return ""
+	}
+	return fmt.Sprint(name)
+}
+
+// compressedSize returns the size of the compressed payload of a section
+// which was decompressed during parsing.
+func compressedSize(f uefi.Firmware) (uint64, bool) {
+	s, ok := f.(*uefi.Section)
+	if !ok || s.TypeSpecific == nil || s.TypeSpecific.Header == nil {
+		return 0, false
+	}
+	h, ok := s.TypeSpecific.Header.(*uefi.SectionGUIDDefined)
+	if !ok || h.Compression == "" || h.Compression == "UNKNOWN" {
+		return 0, false
+	}
+	if int(h.DataOffset) > len(s.Buf()) {
+		return 0, false
+	}
+	return uint64(len(s.Buf()) - int(h.DataOffset)), true
+}
+
+// parseTableColumns parses a comma-separated list of columns. "all" selects
+// every column.
+func parseTableColumns(arg string) ([]string, error) {
+	if arg == "all" {
+		return TableColumns, nil
+	}
+	cols := strings.Split(arg, ",")
+	for _, c := range cols {
+		if !isTableColumn(c) {
+			return nil, fmt.Errorf("unknown table column %q, expected one of %v", c, TableColumns)
+		}
+	}
+	return cols, nil
 }
 
 func init() {
@@ -196,6 +505,20 @@ func init() {
 	RegisterCLI("layout-table-full", "print out offset and size information in a pretty table", 0, func(args []string) (uefi.Visitor, error) {
 		return &Table{Layout: true}, nil
 	})
+	RegisterCLI("table-csv", "print the table as CSV with the given comma-separated columns, or \"all\"", 1, func(args []string) (uefi.Visitor, error) {
+		cols, err := parseTableColumns(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &Table{Format: "csv", Columns: cols}, nil
+	})
+	RegisterCLI("table-tsv", "print the table as TSV with the given comma-separated columns, or \"all\"", 1, func(args []string) (uefi.Visitor, error) {
+		cols, err := parseTableColumns(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &Table{Format: "tsv", Columns: cols}, nil
+	})
 	RegisterCLI("scan", "scan the table for GUIDs and print those found", 0, func(args []string) (uefi.Visitor, error) {
 		return &Table{Scan: true}, nil
 	})
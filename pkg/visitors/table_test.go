@@ -0,0 +1,101 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+)
+
+func TestTableCSV(t *testing.T) {
+	f := parseImage(t)
+
+	var b bytes.Buffer
+	table := &Table{Format: "csv", Columns: []string{"node", "guid", "name", "compressed-size"}, Out: &b}
+	if err := table.Run(f); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(&b).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(records[0], ","); got != "node,guid,name,compressed-size" {
+		t.Fatalf("got header %q", got)
+	}
+	var foundDXECore, foundCompressed bool
+	for _, r := range records[1:] {
+		if len(r) != 4 {
+			t.Fatalf("expected 4 columns, got %v", r)
+		}
+		if r[0] == "File" && r[1] == dxeCoreGUID.String() && r[2] == "DxeCore" {
+			foundDXECore = true
+		}
+		if r[0] == "Sec" && r[3] != "" {
+			foundCompressed = true
+		}
+	}
+	if !foundDXECore {
+		t.Errorf("DxeCore file not found in CSV output")
+	}
+	if !foundCompressed {
+		t.Errorf("no compressed section found in CSV output")
+	}
+}
+
+func TestTableTSV(t *testing.T) {
+	f := parseImage(t)
+
+	var b bytes.Buffer
+	table := &Table{Format: "tsv", Out: &b}
+	if err := table.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	header, _, _ := strings.Cut(b.String(), "\n")
+	if want := strings.Join(TableColumns, "\t"); header != want {
+		t.Fatalf("got header %q, want %q", header, want)
+	}
+}
+
+func TestParseTableColumns(t *testing.T) {
+	if _, err := parseTableColumns("guid,size"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := parseTableColumns("guid,bogus"); err == nil {
+		t.Errorf("expected error for unknown column")
+	}
+}
+
+func TestTableChips(t *testing.T) {
+	_, f := parseTwoChipImage(t)
+	var b bytes.Buffer
+	table := &Table{Format: "csv", Columns: []string{"node", "offset", "chip", "chip-offset"}, Out: &b}
+	if err := table.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&b).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var foundFV bool
+	for _, r := range records[1:] {
+		switch r[0] {
+		case "IFD":
+			if r[2] != "0" || r[3] != "0x0" {
+				t.Errorf("got IFD row %v, want chip 0 at 0x0", r)
+			}
+		case "FV":
+			foundFV = true
+			if r[1] != "0x80000" || r[2] != "1" || r[3] != "0x0" {
+				t.Errorf("got FV row %v, want chip 1 at 0x0", r)
+			}
+		}
+	}
+	if !foundFV {
+		t.Errorf("no FV row")
+	}
+}
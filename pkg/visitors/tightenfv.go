@@ -0,0 +1,106 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"fmt"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// TightenFV repacks the files of each firmware volume to drop pad files and
+// move all the free space to the end of the volume. Alignment requirements
+// are kept. Pinned files, and the volume top file, keep their offsets; pad
+// files are inserted in front of them as needed.
+type TightenFV struct {
+	// Input
+	// Pinned selects the files which must keep their offset. May be nil.
+	Pinned FindPredicate
+}
+
+// Mutates implements Mutator.
+func (v *TightenFV) Mutates() bool {
+	return true
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *TightenFV) Run(f uefi.Firmware) error {
+	if err := writable(f); err != nil {
+		return err
+	}
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	return (&Assemble{}).Run(f)
+}
+
+// Visit applies the TightenFV visitor to any Firmware type.
+func (v *TightenFV) Visit(f uefi.Firmware) error {
+	if err := f.ApplyChildren(v); err != nil {
+		return err
+	}
+	if fv, ok := f.(*uefi.FirmwareVolume); ok && len(fv.Files) > 0 {
+		return v.tighten(fv)
+	}
+	return nil
+}
+
+func (v *TightenFV) pinned(file *uefi.File) bool {
+	if file.Header.GUID == *uefi.VTFGUID {
+		return true
+	}
+	return v.Pinned != nil && v.Pinned(file)
+}
+
+func (v *TightenFV) tighten(fv *uefi.FirmwareVolume) error {
+	// Replay the current layout to learn the offsets of the files.
+	offsets := make([]uint64, len(fv.Files))
+	offset := fv.DataOffset
+	for i, file := range fv.Files {
+		offsets[i] = uefi.Align8(offset)
+		offset = offsets[i] + uint64(len(file.Buf()))
+	}
+
+	var files []*uefi.File
+	offset = fv.DataOffset
+	for i, file := range fv.Files {
+		if file.Header.Type == uefi.FVFileTypePad {
+			continue
+		}
+		alignedOffset := uefi.Align8(offset)
+		newOffset := alignFileOffset(alignedOffset, file)
+		if v.pinned(file) {
+			newOffset = offsets[i]
+			if newOffset < alignedOffset {
+				return fmt.Errorf("file %v: cannot keep offset %#x, previous files end at %#x",
+					file.Header.GUID, newOffset, alignedOffset)
+			}
+			if gap := newOffset - alignedOffset; gap != 0 {
+				pfile, err := uefi.CreatePadFile(gap)
+				if err != nil {
+					return fmt.Errorf("file %v: cannot pad to offset %#x: %v", file.Header.GUID, newOffset, err)
+				}
+				files = append(files, pfile)
+			}
+		}
+		files = append(files, file)
+		offset = newOffset + uint64(len(file.Buf()))
+	}
+	fv.Files = files
+	return nil
+}
+
+func init() {
+	RegisterCLI("tighten_fv", "repack the files of each firmware volume to move free space to the end", 0, func(args []string) (uefi.Visitor, error) {
+		return &TightenFV{}, nil
+	})
+	RegisterCLI("tighten_fv_pinned", "repack the files of each firmware volume, keeping the offsets of files matching (GUID|NAME)", 1, func(args []string) (uefi.Visitor, error) {
+		pred, err := FindFilePredicate(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &TightenFV{Pinned: pred}, nil
+	})
+}
@@ -0,0 +1,78 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/guid"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// fileOffsets reparses the image and returns the offset of each non-pad
+// file within its firmware volume, keyed by GUID.
+func fileOffsets(t *testing.T, f uefi.Firmware) map[guid.GUID]uint64 {
+	parsed, err := uefi.Parse(f.Buf())
+	if err != nil {
+		t.Fatal(err)
+	}
+	offsets := map[guid.GUID]uint64{}
+	fvs := &Find{Predicate: func(f uefi.Firmware) bool {
+		_, ok := f.(*uefi.FirmwareVolume)
+		return ok
+	}}
+	if err := fvs.Run(parsed); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range fvs.Matches {
+		fv := m.(*uefi.FirmwareVolume)
+		offset := fv.DataOffset
+		for _, file := range fv.Files {
+			offset = uefi.Align8(offset)
+			if align := file.Header.Attributes.GetAlignment(); (offset+file.HeaderLen())%align != 0 {
+				t.Errorf("file %v at %#x is not aligned to %#x", file.Header.GUID, offset, align)
+			}
+			if file.Header.Type != uefi.FVFileTypePad {
+				offsets[file.Header.GUID] = offset
+			}
+			offset += uint64(len(file.Buf()))
+		}
+	}
+	return offsets
+}
+
+func TestTightenFV(t *testing.T) {
+	f := parseImage(t)
+	if err := (&Assemble{}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	before := fileOffsets(t, f)
+	countBefore := &Count{}
+	if err := countBefore.Run(f); err != nil {
+		t.Fatal(err)
+	}
+
+	v := &TightenFV{Pinned: FindFileGUIDPredicate(*dxeCoreGUID)}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+
+	after := fileOffsets(t, f)
+	for _, g := range []guid.GUID{*dxeCoreGUID, *uefi.VTFGUID} {
+		if after[g] != before[g] {
+			t.Errorf("file %v moved from %#x to %#x", g, before[g], after[g])
+		}
+	}
+
+	countAfter := &Count{}
+	if err := countAfter.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	pad := uefi.FVFileTypePad.String()
+	if countAfter.FileTypeCount[pad] >= countBefore.FileTypeCount[pad] {
+		t.Errorf("expected fewer pad files, got %d before and %d after",
+			countBefore.FileTypeCount[pad], countAfter.FileTypeCount[pad])
+	}
+}
@@ -19,8 +19,16 @@ type TightenME struct {
 	br  *uefi.BIOSRegion
 }
 
+// Mutates implements Mutator.
+func (v *TightenME) Mutates() bool {
+	return true
+}
+
 // Run wraps Visit and performs some setup and teardown tasks.
 func (v *TightenME) Run(f uefi.Firmware) error {
+	if err := writable(f); err != nil {
+		return err
+	}
 	err := f.Apply(v)
 	if err != nil {
 		return fmt.Errorf("error looking for IFD, ME and BIOS regions: %v", err)
@@ -82,7 +90,7 @@ func (v *TightenME) process() error {
 	v.br.FRegion.Base = uint16(updateBase)
 	v.br.Length += offsetShift
 	if v.br.Length > 16*1024*1024 {
-		log.Warnf("new BIOS Regions length %d (%#x) exceed 16MiB limit", v.br.Length, v.br.Length)
+		log.With(log.F("length", fmt.Sprintf("%#x", v.br.Length))).Warnf("new BIOS Region length exceeds the 16MiB limit")
 	}
 	// update elements offsets
 	for i, e := range v.br.Elements {
@@ -5,6 +5,7 @@
 package visitors
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -17,31 +18,82 @@ import (
 // Validate performs extra checks on the firmware image.
 type Validate struct {
 	// An optional Writer for writing errors when validation is complete.
-	// When the writer it set, Run will also call os.Exit(1) upon finding
-	// an error.
+	// When the writer it set, Run will also return a *ValidationError upon
+	// finding an error.
 	W io.Writer
 
-	// List of validation errors.
+	// List of validation errors. Each is a *NodeError naming the node
+	// which failed validation.
 	Errors []error
+
+	progress *uefi.Progress
+	// The root of the validated tree, to locate the nodes of the errors.
+	root uefi.Firmware
 }
 
 // Run wraps Visit and performs some setup and teardown tasks.
 func (v *Validate) Run(f uefi.Firmware) error {
-	if err := f.Apply(v); err != nil {
+	return v.RunContext(context.Background(), f)
+}
+
+// RunContext is Run, stopping with the error of ctx once ctx is done. The
+// context is checked before each file is validated.
+func (v *Validate) RunContext(ctx context.Context, f uefi.Firmware) (err error) {
+	ctx, endTrace := uefi.StartTrace(ctx, uefi.ProgressValidate)
+	defer func() { endTrace(err) }()
+	v.progress = newFileProgress(ctx, uefi.ProgressValidate, f)
+	v.root = f
+	defer func() { v.root = nil }()
+	if err := f.Apply(contextVisitor{ctx, v.visitContext}); err != nil {
 		return err
 	}
 
-	if v.W != nil && len(v.Errors) != 0 {
+	if v.W != nil && structuredOutput() {
+		errs := []ErrorReport{}
 		for _, e := range v.Errors {
-			fmt.Println(e)
+			errs = append(errs, NewErrorReport(e))
+		}
+		if err := writeStructured(v.W, struct{ Errors []ErrorReport }{errs}); err != nil {
+			return err
+		}
+	}
+	if v.W != nil && len(v.Errors) != 0 {
+		if !structuredOutput() {
+			for _, e := range v.Errors {
+				fmt.Fprintln(v.W, e)
+			}
 		}
-		os.Exit(1)
+		return &ValidationError{Errors: v.Errors}
 	}
 	return nil
 }
 
 // Visit applies the Validate visitor to any Firmware type.
 func (v *Validate) Visit(f uefi.Firmware) error {
+	return v.visitContext(context.Background(), f)
+}
+
+// visitContext is Visit, stopping with the error of ctx once it is done.
+func (v *Validate) visitContext(ctx context.Context, f uefi.Firmware) error {
+	if _, ok := f.(*uefi.File); ok {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	n := len(v.Errors)
+	err := v.visit(ctx, f)
+	// Children wrap their own errors first.
+	for i := n; i < len(v.Errors); i++ {
+		v.Errors[i] = newNodeError(v.root, f, v.Errors[i])
+	}
+	if _, ok := f.(*uefi.File); ok {
+		v.progress.Step()
+	}
+	return err
+}
+
+func (v *Validate) visit(ctx context.Context, f uefi.Firmware) error {
+	children := contextVisitor{ctx, v.visitContext}
 	// TODO: add more verification where needed
 	switch f := f.(type) {
 	case *uefi.FlashImage:
@@ -215,7 +267,7 @@ func (v *Validate) Visit(f uefi.Firmware) error {
 		}
 
 		for i, e := range f.Elements {
-			if err := e.Value.Apply(v); err != nil {
+			if err := e.Value.Apply(children); err != nil {
 				return err
 			}
 			f, ok := e.Value.(*uefi.FirmwareVolume)
@@ -249,11 +301,13 @@ func (v *Validate) Visit(f uefi.Firmware) error {
 			v.Errors = append(v.Errors, fmt.Errorf("region is not valid, region was %v", *f.FlashRegion()))
 		}
 	}
-	return f.ApplyChildren(v)
+	return f.ApplyChildren(children)
 }
 
 func init() {
 	RegisterCLI("validate", "perform extra validation checks", 0, func(args []string) (uefi.Visitor, error) {
-		return &Validate{}, nil
+		return &Validate{
+			W: os.Stdout,
+		}, nil
 	})
 }
@@ -5,6 +5,11 @@
 package visitors
 
 import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/linuxboot/fiano/pkg/uefi"
@@ -73,3 +78,98 @@ func TestValidateFile(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateErrorLocation(t *testing.T) {
+	fv, err := uefi.NewFirmwareVolume(sampleFV, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := fv.Files[len(fv.Files)-1]
+	_, offset, ok := uefi.Locate(fv, file)
+	if !ok || offset == nil {
+		t.Fatal("file not located")
+	}
+	// Break the header checksum of the file.
+	buf := append([]byte{}, sampleFV...)
+	buf[*offset+16]++
+	if fv, err = uefi.NewFirmwareVolume(buf, 0, false); err != nil {
+		t.Fatal(err)
+	}
+
+	v := &Validate{}
+	if err := v.Run(fv); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Errors) != 1 {
+		t.Fatalf("got errors %v, want one", v.Errors)
+	}
+	var ne *NodeError
+	if !errors.As(v.Errors[0], &ne) {
+		t.Fatalf("got %v, want a *NodeError", v.Errors[0])
+	}
+	path := "File " + file.Header.GUID.String()
+	if ne.Path != path || ne.Offset == nil || *ne.Offset != *offset {
+		t.Errorf("got path %q at %v, want %q at %#x", ne.Path, ne.Offset, path, *offset)
+	}
+	if msg := ne.Error(); !strings.HasPrefix(msg, path+" at ") {
+		t.Errorf("got message %q, want it prefixed with the path and offset", msg)
+	}
+	if r := NewErrorReport(ne); r.Path != path || strings.HasPrefix(r.Message, path) {
+		t.Errorf("got report %+v, want the path apart from the message", r)
+	}
+}
+
+func TestValidateContext(t *testing.T) {
+	fv, err := uefi.NewFirmwareVolume(sampleFV, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := (&Validate{}).RunContext(ctx, fv); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+	if err := ExecuteCLIContext(ctx, fv, []uefi.Visitor{&Count{}}); !errors.Is(err, context.Canceled) {
+		t.Errorf("ExecuteCLIContext: got %v, want %v", err, context.Canceled)
+	}
+	if err := ExecuteCLIContext(context.Background(), fv, []uefi.Visitor{&Validate{}}); err != nil {
+		t.Errorf("ExecuteCLIContext: %v", err)
+	}
+}
+
+func TestTraceValidateAssemble(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		phases []string
+		errs   []error
+	)
+	uefi.SetTrace(func(ctx context.Context, phase string) (context.Context, func(error)) {
+		return ctx, func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			phases = append(phases, phase)
+			errs = append(errs, err)
+		}
+	})
+	defer uefi.SetTrace(nil)
+
+	fv, err := uefi.NewFirmwareVolume(sampleFV, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := ExecuteCLI(fv, []uefi.Visitor{&Validate{}, &Assemble{}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Validate{}).RunContext(ctx, fv); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+	want := []string{uefi.ProgressValidate, uefi.ProgressAssemble, uefi.ProgressValidate}
+	if !reflect.DeepEqual(phases, want) {
+		t.Fatalf("got phases %q, want %q", phases, want)
+	}
+	if errs[0] != nil || errs[1] != nil || !errors.Is(errs[2], context.Canceled) {
+		t.Errorf("got errors %v", errs)
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	amd_manifest "github.com/linuxboot/fiano/pkg/amd/manifest"
+	"github.com/linuxboot/fiano/pkg/amd/psb"
+	"github.com/linuxboot/fiano/pkg/intel/metadata/fit"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// Verification statuses.
+const (
+	VerifyPass    = "pass"
+	VerifyFail    = "fail"
+	VerifySkipped = "skipped"
+)
+
+// Verified subsystems.
+const (
+	VerifyChecksums = "checksums"
+	VerifyFIT       = "fit"
+	VerifyBootGuard = "bootguard"
+	VerifyPSB       = "psb"
+)
+
+// VerifyResult is the outcome of the checks of one subsystem.
+type VerifyResult struct {
+	Subsystem string
+	Status    string
+	// Detail says what was checked, or why the checks were skipped.
+	Detail string   `json:",omitempty"`
+	Errors []string `json:",omitempty"`
+}
+
+// Verify runs all the integrity checks applicable to the image: the FV and
+// file checksums, the FIT consistency, the BootGuard or CBnT manifest chain,
+// the AMD PSB chain and, when VerifyCompressionRoundTrip is set, the
+// compression round trip. Subsystems which are not found in the image are
+// skipped.
+type Verify struct {
+	// The summary is written to this writer.
+	W io.Writer
+
+	// Output
+	Results []VerifyResult
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Verify) Run(f uefi.Firmware) error {
+	image := f.Buf()
+	v.Results = []VerifyResult{
+		verifyChecksums(f),
+		verifyFIT(image),
+		verifyBootGuard(f),
+		verifyPSB(image),
+	}
+	if VerifyCompressionRoundTrip {
+		v.Results = append(v.Results, verifyCompression(f))
+	}
+
+	var errs []error
+	for _, r := range v.Results {
+		for _, e := range r.Errors {
+			errs = append(errs, &NodeError{Node: r.Subsystem, Err: errors.New(e)})
+		}
+	}
+
+	if v.W != nil {
+		if structuredOutput() {
+			if err := writeStructured(v.W, v.Results); err != nil {
+				return err
+			}
+		} else {
+			for _, r := range v.Results {
+				fmt.Fprintf(v.W, "%-10s %-8s %s\n", r.Subsystem, r.Status, r.Detail)
+				for _, e := range r.Errors {
+					fmt.Fprintf(v.W, "\t%s\n", e)
+				}
+			}
+		}
+	}
+	if len(errs) != 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// Visit applies the Verify visitor to any Firmware type.
+func (v *Verify) Visit(f uefi.Firmware) error {
+	return nil
+}
+
+// newVerifyResult returns a passing result, or a failing one if there are
+// errors.
+func newVerifyResult(subsystem, detail string, errs []error) VerifyResult {
+	r := VerifyResult{Subsystem: subsystem, Status: VerifyPass, Detail: detail}
+	for _, err := range errs {
+		r.Status = VerifyFail
+		r.Errors = append(r.Errors, err.Error())
+	}
+	return r
+}
+
+func verifyChecksums(f uefi.Firmware) VerifyResult {
+	v := &Validate{}
+	if err := v.Run(f); err != nil {
+		v.Errors = append(v.Errors, err)
+	}
+	var errs []error
+	for _, err := range v.Errors {
+		// Name the node even when it is the root, whose errors have no
+		// path.
+		var nerr *NodeError
+		if errors.As(err, &nerr) && nerr.Path == "" {
+			err = fmt.Errorf("%s: %v", nerr.Node, nerr.Err)
+		}
+		errs = append(errs, err)
+	}
+	return newVerifyResult(VerifyChecksums, "firmware volume, file and section headers", errs)
+}
+
+func verifyFIT(image []byte) VerifyResult {
+	table, err := fit.GetTable(image)
+	if err != nil {
+		return VerifyResult{Subsystem: VerifyFIT, Status: VerifySkipped, Detail: "no FIT found"}
+	}
+
+	var errs []error
+	if len(table) == 0 || table[0].Type() != fit.EntryTypeFITHeaderEntry {
+		errs = append(errs, errors.New("the first entry is not the FIT header"))
+	}
+	for i := range table {
+		hdr := &table[i]
+		if hdr.IsChecksumValid() && hdr.Checksum != hdr.CalculateChecksum() {
+			errs = append(errs, fmt.Errorf("entry %d (%v): checksum is %#x, expected %#x", i, hdr.Type(), hdr.Checksum, hdr.CalculateChecksum()))
+		}
+	}
+	for i, entry := range table.GetEntries(image) {
+		for _, err := range entry.GetEntryBase().HeadersErrors {
+			errs = append(errs, fmt.Errorf("entry %d (%v): %v", i, table[i].Type(), err))
+		}
+	}
+	return newVerifyResult(VerifyFIT, fmt.Sprintf("%d entries", len(table)), errs)
+}
+
+func verifyBootGuard(f uefi.Firmware) VerifyResult {
+	image := f.Buf()
+	skipped := VerifyResult{Subsystem: VerifyBootGuard, Status: VerifySkipped, Detail: "no key and boot policy manifests in the FIT"}
+	table, err := fit.GetTable(image)
+	if err != nil {
+		return skipped
+	}
+	kmHdr := table.First(fit.EntryTypeKeyManifestRecord)
+	bpmHdr := table.First(fit.EntryTypeBootPolicyManifest)
+	if kmHdr == nil || bpmHdr == nil {
+		return skipped
+	}
+	kmEntry, ok := kmHdr.GetEntry(image).(*fit.EntryKeyManifestRecord)
+	if !ok {
+		return newVerifyResult(VerifyBootGuard, "", []error{errors.New("invalid key manifest entry")})
+	}
+	bpmEntry, ok := bpmHdr.GetEntry(image).(*fit.EntryBootPolicyManifestRecord)
+	if !ok {
+		return newVerifyResult(VerifyBootGuard, "", []error{errors.New("invalid boot policy manifest entry")})
+	}
+	km, bpm := kmEntry.DataSegmentBytes, bpmEntry.DataSegmentBytes
+
+	bgKM, cbntKM, err := kmEntry.ParseData()
+	if err != nil {
+		return newVerifyResult(VerifyBootGuard, "", []error{fmt.Errorf("key manifest: %v", err)})
+	}
+	bgBPM, cbntBPM, err := bpmEntry.ParseData()
+	if err != nil {
+		return newVerifyResult(VerifyBootGuard, "", []error{fmt.Errorf("boot policy manifest: %v", err)})
+	}
+
+	var errs []error
+	check := func(what string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", what, err))
+		}
+	}
+	// The manifests are signed up to their key and signature structure.
+	switch {
+	case bgKM != nil && bgBPM != nil:
+		check("key manifest signature", bgKM.KeyAndSignature.Verify(sliceUpTo(km, bgKM.KeyAndSignatureOffset())))
+		check("boot policy manifest signature", bgBPM.PMSE.Verify(sliceUpTo(bpm, bgBPM.PMSEOffset()+bgBPM.PMSE.KeySignatureOffset())))
+		check("boot policy manifest key", bgKM.ValidateBPMKey(bgBPM.PMSE.KeySignature))
+		check("IBB", bgBPM.ValidateIBB(f))
+		return newVerifyResult(VerifyBootGuard, "BootGuard 1.0 manifests", errs)
+	case cbntKM != nil && cbntBPM != nil:
+		check("key manifest signature", cbntKM.KeyAndSignature.Verify(sliceUpTo(km, cbntKM.KeyAndSignatureOffset())))
+		check("boot policy manifest signature", cbntBPM.PMSE.Verify(sliceUpTo(bpm, cbntBPM.PMSEOffset()+cbntBPM.PMSE.KeySignatureOffset())))
+		check("boot policy manifest key", cbntKM.ValidateBPMKey(cbntBPM.PMSE.KeySignature))
+		check("IBB", cbntBPM.ValidateIBB(f))
+		return newVerifyResult(VerifyBootGuard, "CBnT manifests", errs)
+	}
+	return newVerifyResult(VerifyBootGuard, "", []error{errors.New("key and boot policy manifests have different versions")})
+}
+
+// sliceUpTo returns the first n bytes of b, or all of b if it is shorter.
+func sliceUpTo(b []byte, n uint64) []byte {
+	if n > uint64(len(b)) {
+		return b
+	}
+	return b[:n]
+}
+
+func verifyPSB(image []byte) VerifyResult {
+	if _, _, err := amd_manifest.FindEmbeddedFirmwareStructure(amd_manifest.FirmwareImage(image)); err != nil {
+		return VerifyResult{Subsystem: VerifyPSB, Status: VerifySkipped, Detail: "no AMD embedded firmware structure"}
+	}
+	amdFw, err := psb.ParseAMDFirmware(image)
+	if err != nil {
+		return newVerifyResult(VerifyPSB, "", []error{err})
+	}
+	enabled, err := psb.IsPSBEnabled(amdFw)
+	if err != nil {
+		return newVerifyResult(VerifyPSB, "", []error{err})
+	}
+	if !enabled {
+		return VerifyResult{Subsystem: VerifyPSB, Status: VerifySkipped, Detail: "PSB is not enabled"}
+	}
+
+	level := uint(1)
+	if amdFw.PSPFirmware().BIOSDirectoryLevel2 != nil {
+		level = 2
+	}
+	result, err := psb.ValidateRTM(amdFw, level)
+	if err == nil {
+		err = result.Error()
+	}
+	var errs []error
+	if err != nil {
+		errs = append(errs, fmt.Errorf("RTM volume signature: %w", err))
+	}
+	return newVerifyResult(VerifyPSB, fmt.Sprintf("RTM volume signature of the level %d BIOS directory", level), errs)
+}
+
+func init() {
+	RegisterCLI("verify", "run all the integrity checks applicable to the image and summarize pass or fail per subsystem", 0, func(args []string) (uefi.Visitor, error) {
+		return &Verify{
+			W: os.Stdout,
+		}, nil
+	})
+}
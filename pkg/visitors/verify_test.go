@@ -0,0 +1,54 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestVerify(t *testing.T) {
+	f := parseImage(t)
+
+	var out bytes.Buffer
+	v := &Verify{W: &out}
+	if err := v.Run(f); err != nil {
+		t.Fatalf("%v\n%s", err, out.String())
+	}
+	want := map[string]string{
+		VerifyChecksums: VerifyPass,
+		VerifyFIT:       VerifySkipped,
+		VerifyBootGuard: VerifySkipped,
+		VerifyPSB:       VerifySkipped,
+	}
+	if len(v.Results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(v.Results), len(want))
+	}
+	for _, r := range v.Results {
+		if r.Status != want[r.Subsystem] {
+			t.Errorf("%s: got status %q, want %q", r.Subsystem, r.Status, want[r.Subsystem])
+		}
+	}
+}
+
+func TestVerifyBadChecksum(t *testing.T) {
+	f, err := uefi.NewFile(append([]byte{}, badFreeFormFile...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := &Verify{}
+	err = v.Run(f)
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("got error %v, want a *ValidationError", err)
+	}
+	if v.Results[0].Subsystem != VerifyChecksums || v.Results[0].Status != VerifyFail {
+		t.Errorf("got %+v, want failed checksums", v.Results[0])
+	}
+}
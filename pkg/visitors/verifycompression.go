@@ -0,0 +1,202 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/linuxboot/fiano/pkg/compression"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// VerifyCompressionRoundTrip adds the compression round trip of every
+// compressed section to the checks of Verify. It is off by default as it
+// recompresses the whole image. It is set by the -verify-compression flag of
+// utk.
+var VerifyCompressionRoundTrip = false
+
+// VerifyCompressionSubsystem is the subsystem of the Verify results of the
+// compression round trip.
+const VerifyCompressionSubsystem = "compression"
+
+// CompressionRoundTrip is the outcome of the round trip of a compressed
+// section.
+type CompressionRoundTrip struct {
+	// Path and Offset locate the section as in a NodeError.
+	Path   string
+	Offset *uint64 `json:",omitempty"`
+	// File is the name of the file holding the section.
+	File        string `json:",omitempty"`
+	Compression string
+	// StoredSize is the size of the compressed data of the section,
+	// RecompressedSize the size of the data compressed again.
+	StoredSize       int
+	RecompressedSize int `json:",omitempty"`
+	// Exact is true if compressing the decompressed data again yields the
+	// stored data.
+	Exact bool
+	Error string `json:",omitempty"`
+}
+
+// VerifyCompression decompresses every compressed section and compresses it
+// again with the compressor assembling the image would use, which honors
+// compression.Deterministic. The sections whose stored data is not
+// reproduced are reported: assembling the image after changing them, or the
+// sections they hold, does not yield their original bytes.
+type VerifyCompression struct {
+	// The sections are written to this writer.
+	W io.Writer
+
+	// Output
+	Sections []CompressionRoundTrip
+
+	// Private
+	root uefi.Firmware
+	file string
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *VerifyCompression) Run(f uefi.Firmware) error {
+	v.Sections = nil
+	v.root = f
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, s := range v.Sections {
+		if err := s.err(); err != nil {
+			errs = append(errs, &NodeError{Node: "compressed section", Path: s.Path, Offset: s.Offset, Err: err})
+		}
+	}
+
+	if v.W != nil {
+		if structuredOutput() {
+			if err := writeStructured(v.W, v.Sections); err != nil {
+				return err
+			}
+		} else {
+			for _, s := range v.Sections {
+				status := "exact"
+				if !s.Exact {
+					status = "differs"
+				}
+				fmt.Fprintf(v.W, "%-8s %-6s %#8x %#8x %s %s\n", status, s.Compression, s.StoredSize, s.RecompressedSize, s.Path, s.File)
+				if s.Error != "" {
+					fmt.Fprintf(v.W, "\t%s\n", s.Error)
+				}
+			}
+		}
+	}
+	if len(errs) != 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// Visit applies the VerifyCompression visitor to any Firmware type.
+func (v *VerifyCompression) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.File:
+		file := v.file
+		v.file = FileUIName(f)
+		if v.file == "" {
+			v.file = f.Header.GUID.String()
+		}
+		defer func() { v.file = file }()
+	case *uefi.Section:
+		if c, data := sectionCompressor(f); c != nil {
+			v.Sections = append(v.Sections, v.roundTrip(f, c, data))
+		}
+	}
+	return f.ApplyChildren(v)
+}
+
+// roundTrip decompresses and compresses again the data of s.
+func (v *VerifyCompression) roundTrip(s *uefi.Section, c compression.Compressor, data []byte) CompressionRoundTrip {
+	r := CompressionRoundTrip{File: v.file, Compression: c.Name(), StoredSize: len(data)}
+	r.Path, r.Offset, _ = uefi.Locate(v.root, s)
+	decoded, err := c.Decode(data)
+	if err != nil {
+		r.Error = fmt.Sprintf("cannot decompress: %v", err)
+		return r
+	}
+	encoded, err := c.Encode(decoded)
+	if err != nil {
+		r.Error = fmt.Sprintf("cannot compress again: %v", err)
+		return r
+	}
+	r.RecompressedSize = len(encoded)
+	r.Exact = bytes.Equal(encoded, data)
+	return r
+}
+
+// err returns why the round trip of the section is not exact.
+func (r CompressionRoundTrip) err() error {
+	switch {
+	case r.Error != "":
+		return errors.New(r.Error)
+	case !r.Exact:
+		return fmt.Errorf("%s data of %#x bytes is compressed again into %#x different bytes", r.Compression, r.StoredSize, r.RecompressedSize)
+	}
+	return nil
+}
+
+// sectionCompressor returns the compressor of a compressed section and its
+// compressed data, or nil if the section is not compressed or its
+// compression is unknown.
+func sectionCompressor(s *uefi.Section) (compression.Compressor, []byte) {
+	if s.TypeSpecific == nil {
+		return nil, nil
+	}
+	buf := s.Buf()
+	switch h := s.TypeSpecific.Header.(type) {
+	case *uefi.SectionCompression:
+		c := h.Compressor()
+		if c == nil || h.Compression == "UNKNOWN" {
+			return nil, nil
+		}
+		offset := sectionHeaderLen(s) + h.GetBinHeaderLen()
+		if offset > uint32(len(buf)) {
+			return nil, nil
+		}
+		return c, buf[offset:]
+	case *uefi.SectionGUIDDefined:
+		c := compression.CompressorFromGUID(&h.GUID)
+		if c == nil || h.Compression == "UNKNOWN" || int(h.DataOffset) > len(buf) {
+			return nil, nil
+		}
+		return c, buf[h.DataOffset:]
+	}
+	return nil, nil
+}
+
+// verifyCompression returns the result of the compression round trip, run
+// by Verify when VerifyCompressionRoundTrip is set.
+func verifyCompression(f uefi.Firmware) VerifyResult {
+	v := &VerifyCompression{}
+	var errs []error
+	if err := v.Run(f); err != nil {
+		var verr *ValidationError
+		if !errors.As(err, &verr) {
+			return newVerifyResult(VerifyCompressionSubsystem, "", []error{err})
+		}
+		errs = verr.Errors
+	}
+	if len(v.Sections) == 0 {
+		return VerifyResult{Subsystem: VerifyCompressionSubsystem, Status: VerifySkipped, Detail: "no compressed section found"}
+	}
+	return newVerifyResult(VerifyCompressionSubsystem, fmt.Sprintf("%d compressed sections", len(v.Sections)), errs)
+}
+
+func init() {
+	RegisterCLI("verify-compression", "decompress and compress again every compressed section and report those whose stored data is not reproduced", 0, func(args []string) (uefi.Visitor, error) {
+		return &VerifyCompression{W: os.Stdout}, nil
+	})
+}
@@ -0,0 +1,66 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestVerifyCompression(t *testing.T) {
+	raw, err := uefi.CreateSection(uefi.SectionTypeRaw, bytes.Repeat([]byte("FIANO ROCKS! "), 100), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := raw.GenSecHeader(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := uefi.CreateSection(uefi.SectionTypeCompression, nil, []uefi.Firmware{raw}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Assemble{}).Run(s); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	v := &VerifyCompression{W: &out}
+	if err := v.Run(s); err != nil {
+		t.Fatalf("%v\n%s", err, out.String())
+	}
+	if len(v.Sections) != 1 || !v.Sections[0].Exact || v.Sections[0].Compression != "EFI" {
+		t.Errorf("assembled section: got %+v", v.Sections)
+	}
+
+	// Data after the compressed stream is not reproduced.
+	buf := append(append([]byte{}, s.Buf()...), 0)
+	buf[0]++
+	s, err = uefi.NewSection(buf, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = v.Run(s)
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 {
+		t.Fatalf("got error %v, want a *ValidationError", err)
+	}
+	if len(v.Sections) != 1 || v.Sections[0].Exact || v.Sections[0].StoredSize != v.Sections[0].RecompressedSize+1 {
+		t.Errorf("padded section: got %+v", v.Sections)
+	}
+
+	defer func(old bool) { VerifyCompressionRoundTrip = old }(VerifyCompressionRoundTrip)
+	VerifyCompressionRoundTrip = true
+	verify := &Verify{}
+	if err := verify.Run(s); !errors.As(err, &verr) {
+		t.Fatalf("got error %v, want a *ValidationError", err)
+	}
+	r := verify.Results[len(verify.Results)-1]
+	if r.Subsystem != VerifyCompressionSubsystem || r.Status != VerifyFail {
+		t.Errorf("verify: got %+v", r)
+	}
+}
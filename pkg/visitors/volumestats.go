@@ -0,0 +1,108 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"io"
+	"os"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// VolumeStat holds the size accounting of a firmware volume.
+type VolumeStat struct {
+	FV     string
+	FVName string `json:",omitempty"`
+	// Offset of the volume from the start of the BIOS region, or from the
+	// start of the enclosing section for nested volumes.
+	Offset uint64
+	Size   uint64
+	Used   uint64
+	Free   uint64
+	// PadFiles and PadBytes account for the pad files in the volume.
+	PadFiles int
+	PadBytes uint64
+	// Compressed and Expanded are the total sizes of the compressed
+	// sections directly held by files of the volume, before and after
+	// decompression. Ratio is Compressed over Expanded.
+	Compressed uint64
+	Expanded   uint64
+	Ratio      float64 `json:",omitempty"`
+}
+
+// VolumeStats reports the size accounting of each firmware volume, so size
+// regressions between builds can be caught.
+type VolumeStats struct {
+	// JSON is written to this writer.
+	W io.Writer
+
+	// Output
+	Volumes []*VolumeStat
+
+	// Private
+	cur *VolumeStat
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *VolumeStats) Run(f uefi.Firmware) error {
+	v.Volumes = []*VolumeStat{}
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+
+	if v.W != nil {
+		return writeStructured(v.W, v.Volumes)
+	}
+	return nil
+}
+
+// Visit applies the VolumeStats visitor to any Firmware type.
+func (v *VolumeStats) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.FirmwareVolume:
+		stat := &VolumeStat{
+			FV:     f.FileSystemGUID.String(),
+			Offset: f.FVOffset,
+			Size:   f.Length,
+			Used:   f.Length - f.FreeSpace,
+			Free:   f.FreeSpace,
+		}
+		if f.ExtHeaderOffset != 0 {
+			stat.FVName = f.FVName.String()
+		}
+		v.Volumes = append(v.Volumes, stat)
+		prev := v.cur
+		v.cur = stat
+		defer func() { v.cur = prev }()
+
+	case *uefi.File:
+		if v.cur != nil {
+			if f.Header.Type == uefi.FVFileTypePad {
+				v.cur.PadFiles++
+				v.cur.PadBytes += uint64(len(f.Buf()))
+			}
+			walkSections(f.Sections, func(s *uefi.Section) {
+				if size, ok := compressedSize(s); ok {
+					v.cur.Compressed += size
+					for _, e := range s.Encapsulated {
+						v.cur.Expanded += uint64(len(e.Value.Buf()))
+					}
+				}
+			})
+			if v.cur.Expanded != 0 {
+				v.cur.Ratio = float64(v.cur.Compressed) / float64(v.cur.Expanded)
+			}
+		}
+	}
+	return f.ApplyChildren(v)
+}
+
+func init() {
+	RegisterCLI("volume_stats", "report the size, used and free space, pad overhead and compression ratio of each firmware volume", 0, func(args []string) (uefi.Visitor, error) {
+		return &VolumeStats{
+			W: os.Stdout,
+		}, nil
+	})
+}
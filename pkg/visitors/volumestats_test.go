@@ -0,0 +1,50 @@
+// Copyright 2023 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestVolumeStats(t *testing.T) {
+	f := parseImage(t)
+
+	var b bytes.Buffer
+	v := &VolumeStats{W: &b}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Volumes) == 0 {
+		t.Fatalf("no volumes reported")
+	}
+	var compressed bool
+	for _, s := range v.Volumes {
+		if s.Used+s.Free != s.Size {
+			t.Errorf("%s: used %#x + free %#x != size %#x", s.FV, s.Used, s.Free, s.Size)
+		}
+		if s.PadBytes > s.Used {
+			t.Errorf("%s: pad bytes %#x exceed used %#x", s.FV, s.PadBytes, s.Used)
+		}
+		if s.Compressed != 0 {
+			compressed = true
+			if s.Ratio <= 0 || s.Ratio >= 1 {
+				t.Errorf("%s: unexpected ratio %v", s.FV, s.Ratio)
+			}
+		}
+	}
+	if !compressed {
+		t.Errorf("expected a volume with compressed sections")
+	}
+
+	var got []VolumeStat
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(v.Volumes) {
+		t.Errorf("JSON has %d volumes, want %d", len(got), len(v.Volumes))
+	}
+}